@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"pgrollback/internal/config"
+	"pgrollback/internal/proxy/gui"
+)
+
+// adminTimeout bounds a "pgrollback list" / "pgrollback kill" / "pgrollback status" call to the running proxy.
+const adminTimeout = 15 * time.Second
+
+// adminUsage is printed when an admin subcommand is misused.
+const adminUsage = `usage:
+  pgrollback list [config.yaml]            list the sessions of the running proxy
+  pgrollback kill <testID> [config.yaml]   roll back a session and disconnect its clients
+  pgrollback status [config.yaml]          show version, uptime, backend and session counts`
+
+// isAdminCommand reports whether args (os.Args[1:]) start with a subcommand handled by runAdminCommand.
+func isAdminCommand(args []string) bool {
+	return len(args) > 0 && (args[0] == "list" || args[0] == "kill" || args[0] == "status")
+}
+
+// runAdminCommand runs "list", "kill" or "status" against the proxy described by the config (its listen address
+// and gui.admin_token) through the GUI session API, and returns the process exit code.
+func runAdminCommand(args []string, stdout, stderr io.Writer) int {
+	command, rest := args[0], args[1:]
+	testID := ""
+	if command == "kill" {
+		if len(rest) == 0 || rest[0] == "" {
+			fmt.Fprintln(stderr, adminUsage)
+			return 2
+		}
+		testID, rest = rest[0], rest[1:]
+	}
+	if len(rest) > 1 {
+		fmt.Fprintln(stderr, adminUsage)
+		return 2
+	}
+	configPath := ""
+	if len(rest) == 1 {
+		configPath = rest[0]
+	}
+
+	configResult, err := config.LoadConfigWithPath(configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	cfg := configResult.Config
+	client := gui.NewClient(cfg.Proxy.ListenHost, cfg.Proxy.ListenPort, cfg.GUI.AdminToken)
+	ctx, cancel := context.WithTimeout(context.Background(), adminTimeout)
+	defer cancel()
+
+	if command == "kill" {
+		if err := client.RollbackSession(ctx, testID); err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "session %s rolled back\n", testID)
+		return 0
+	}
+	if command == "status" {
+		status, err := client.ServerStatus(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return 1
+		}
+		printServerStatus(stdout, status)
+		return 0
+	}
+	sessions, err := client.Sessions(ctx)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	printSessions(stdout, sessions)
+	return 0
+}
+
+// maxListedQueryLength truncates the LAST QUERY column of "pgrollback list".
+const maxListedQueryLength = 60
+
+// printSessions writes sessions as an aligned table, one row per session.
+func printSessions(w io.Writer, sessions []gui.SessionInfo) {
+	if len(sessions) == 0 {
+		fmt.Fprintln(w, "no sessions")
+		return
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TEST ID\tLEVEL\tOPEN TX\tHOLDER\tLAST ACTIVITY\tLAST QUERY")
+	for _, s := range sessions {
+		holder := s.OpenTxHolder
+		if holder == "" {
+			holder = "-"
+		}
+		query := strings.Join(strings.Fields(s.LastQuery), " ")
+		if len(query) > maxListedQueryLength {
+			query = query[:maxListedQueryLength-3] + "..."
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%t\t%s\t%s\t%s\n", s.TestID, s.SavepointLevel, s.OpenUserTx, holder, s.LastActivity, query)
+	}
+	_ = tw.Flush()
+}
+
+// printServerStatus writes the proxy's status as an aligned block of "label: value" lines.
+func printServerStatus(w io.Writer, st gui.ServerStatus) {
+	tw := tabwriter.NewWriter(w, 0, 0, 1, ' ', 0)
+	fmt.Fprintf(tw, "Version:\t%s\n", st.Version)
+	fmt.Fprintf(tw, "Uptime:\t%s (since %s)\n", st.Uptime, st.StartedAt)
+	fmt.Fprintf(tw, "Listen address:\t%s\n", st.ListenAddress)
+	fmt.Fprintf(tw, "Backend:\t%s:%d/%s (PostgreSQL %s)\n", st.BackendHost, st.BackendPort, st.BackendDatabase, st.BackendVersion)
+	fmt.Fprintf(tw, "Sessions:\t%d active, %d peak\n", st.ActiveSessions, st.PeakSessions)
+	fmt.Fprintf(tw, "Connections:\t%d current, %d peak\n", st.CurrentConnections, st.PeakConnections)
+	fmt.Fprintf(tw, "Queries processed:\t%d\n", st.QueriesProcessed)
+	_ = tw.Flush()
+}
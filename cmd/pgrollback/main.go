@@ -1,17 +1,50 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"pgrollback/internal/config"
 	"pgrollback/internal/proxy"
+	"pgrollback/internal/tracing"
 	"pgrollback/internal/tray"
 	"pgrollback/pkg/logger"
 )
 
+// shutdownTimeout bounds how long Quit (or SIGINT/SIGTERM) waits for client connections to finish their
+// current statement.
+const shutdownTimeout = 5 * time.Second
+
+// quitOnSignal makes SIGINT and SIGTERM (Ctrl-C, kill, docker stop) quit like the tray's "Quit", so they
+// share its graceful shutdown. A second signal while it drains exits at once.
+func quitOnSignal() {
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		s := <-sig
+		log.Printf("Received %s, shutting down (send it again to exit at once)", s)
+		tray.Quit()
+		s = <-sig
+		log.Printf("Received %s again, exiting without waiting for clients", s)
+		os.Exit(1)
+	}()
+}
+
 func main() {
+	// "pgrollback list" / "pgrollback kill <testID>" talk to a running proxy instead of starting one.
+	if isAdminCommand(os.Args[1:]) {
+		os.Exit(runAdminCommand(os.Args[1:], os.Stdout, os.Stderr))
+	}
+	// "pgrollback replay <file>" feeds a captured client stream back at a running proxy.
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		os.Exit(runReplayCommand(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
 	// Aceita o caminho do arquivo de configuração como argumento
 	// Se não fornecido, usa string vazia (busca automática)
 	configPath := ""
@@ -19,7 +52,8 @@ func main() {
 		configPath = os.Args[1]
 	}
 
-	configResult, err := config.LoadConfigWithPath(configPath)
+	// Checa também o que só importa para um proxy rodando (porta igual à do PostgreSQL, timeouts): todos os problemas de uma vez.
+	configResult, err := config.LoadConfigForStartup(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
@@ -38,6 +72,21 @@ func main() {
 	}
 
 	cfg := config.GetCfg()
+	shutdownTracing := func(context.Context) error { return nil }
+	if cfg.Tracing.Enabled {
+		shutdownTracing, err = tracing.Start(context.Background(), cfg.Tracing.Endpoint)
+		if err != nil {
+			log.Fatalf("Failed to start tracing: %v", err)
+		}
+	}
+	tlsConfig, err := proxy.LoadTLSConfig(cfg.Proxy.TLSCert, cfg.Proxy.TLSKey)
+	if err != nil {
+		log.Fatalf("Failed to load TLS config: %v", err)
+	}
+	routes, err := proxy.ParseBackendRoutes(cfg.Postgres.Routes)
+	if err != nil {
+		log.Fatalf("Failed to load backend routes: %v", err)
+	}
 	server := proxy.NewServer(
 		cfg.Postgres.Host,
 		cfg.Postgres.Port,
@@ -50,21 +99,72 @@ func main() {
 		cfg.Proxy.ListenHost,
 		cfg.Proxy.ListenPort,
 		true, // GUI on same port at /gui
+		proxy.WithTLSConfig(tlsConfig),
+		proxy.WithMaxPreparedStatements(cfg.Proxy.MaxPreparedStatements),
+		proxy.WithBeginWaitTimeout(cfg.Proxy.BeginWaitTimeout.Duration),
+		proxy.WithAuthMethod(cfg.Proxy.AuthMethod),
+		proxy.WithSavepointPrefix(cfg.Proxy.SavepointPrefix),
+		proxy.WithReadConnection(cfg.Proxy.ReadConnection),
+		proxy.WithLockWaitTimeout(cfg.Proxy.LockWaitTimeout.Duration),
+		proxy.WithAdvisoryLockTimeout(cfg.Proxy.AdvisoryLockTimeout.Duration),
+		proxy.WithReadTimeout(cfg.Proxy.ReadTimeout.Duration),
+		proxy.WithIdleTimeout(cfg.Proxy.IdleTimeout.Duration),
+		proxy.WithMaxConnections(cfg.Proxy.MaxConnections, cfg.Proxy.ConnectionWaitTimeout.Duration),
+		proxy.WithListenSocket(cfg.Proxy.ListenSocket),
+		proxy.WithIsolateSchema(cfg.Proxy.IsolateSchema),
+		proxy.WithWarmPoolSize(cfg.Postgres.WarmPoolSize),
+		proxy.WithProtocolCapture(cfg.Proxy.CaptureDir, cfg.Proxy.CaptureTestID),
+		proxy.WithQueryHistorySize(cfg.Proxy.QueryHistorySize),
+		proxy.WithBackendRoutes(routes),
+		proxy.WithConnectRetries(cfg.Postgres.ConnectRetries, cfg.Postgres.ConnectRetryInterval.Duration),
+		proxy.WithConcurrentConnectionsNotice(cfg.Proxy.ConcurrentConnectionsNotice),
+		proxy.WithSessionSetupSQL(cfg.Postgres.SessionSetupSQL),
+		proxy.WithStatementPolicy(cfg.Proxy.AllowedStatements, cfg.Proxy.DeniedStatements),
+		proxy.WithQueryHistoryLabel(cfg.Proxy.QueryHistoryLabel),
+		proxy.WithMaxMessageSize(cfg.Proxy.MaxMessageSize),
+		proxy.WithDefaultTestID(cfg.Proxy.DefaultTestID),
 	)
 	if err := server.StartError(); err != nil {
 		log.Fatalf("Failed to start server: %v", err)
 	}
+	if cfg.Proxy.CheckBackendOnStart {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		err := server.Ready(ctx)
+		cancel()
+		if err != nil {
+			_ = server.Stop()
+			log.Fatalf("Backend PostgreSQL not ready: %v", err)
+		}
+	}
+
+	reloadOnSIGHUP(server)
+	quitOnSignal()
 
 	guiURL := fmt.Sprintf("http://%s:%d/", cfg.Proxy.ListenHost, cfg.Proxy.ListenPort)
 	log.Printf("PgRollback server started on port %d", cfg.Proxy.ListenPort)
 	log.Printf("GUI: %s", guiURL)
+	if path := server.SocketPath(); path != "" {
+		log.Printf("Unix socket: %s", path)
+	}
+	if dir := cfg.Proxy.CaptureDir; dir != "" {
+		log.Printf("Protocol capture: %s", dir)
+	}
+	for _, r := range routes {
+		log.Printf("Backend route: test IDs %q* -> %s", r.Prefix, r.Target)
+	}
 
-	// System tray icon blocks the main goroutine until the user clicks Quit.
+	// System tray icon blocks the main goroutine until the user clicks Quit or a signal arrives (quitOnSignal).
 	tray.Run(guiURL, config.PostgresConnStringMasked(&cfg.Postgres), func() {
 		log.Println("Shutting down server...")
-		if err := server.Stop(); err != nil {
+		// Let running statements finish; after shutdownTimeout the remaining clients are cut off.
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
 			log.Printf("Error stopping server: %v", err)
 		}
+		if err := shutdownTracing(ctx); err != nil {
+			log.Printf("Error flushing traces: %v", err)
+		}
 		log.Println("Server stopped")
 	})
 }
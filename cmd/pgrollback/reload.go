@@ -0,0 +1,71 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"pgrollback/internal/config"
+	"pgrollback/internal/proxy"
+	"pgrollback/pkg/logger"
+)
+
+// reloadOnSIGHUP re-reads the config file in use (the GUI may have saved it elsewhere) on every SIGHUP and
+// applies what can change while the proxy runs (see reloadConfig). Sessions and client connections are kept.
+func reloadOnSIGHUP(server *proxy.Server) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadConfig(server, config.GetConfigPath())
+		}
+	}()
+}
+
+// reloadConfig loads configPath again and applies logging.level, proxy.timeout and
+// proxy.keepalive_interval. Changes to the listen address or the backend connection are only logged:
+// they take effect on the next start. A config that fails to load leaves everything as it was.
+func reloadConfig(server *proxy.Server, configPath string) {
+	result, err := config.LoadConfigForStartup(configPath)
+	if err != nil {
+		log.Printf("SIGHUP: config not reloaded: %v", err)
+		return
+	}
+	next := result.Config
+	cfg := config.GetCfg()
+
+	if cfg.Proxy.ListenHost != next.Proxy.ListenHost || cfg.Proxy.ListenPort != next.Proxy.ListenPort {
+		log.Printf("SIGHUP: proxy.listen_host/listen_port changed to %s:%d, requires restart", next.Proxy.ListenHost, next.Proxy.ListenPort)
+	}
+	if config.PostgresConnStringMasked(&cfg.Postgres) != config.PostgresConnStringMasked(&next.Postgres) || cfg.Postgres.Password != next.Postgres.Password {
+		log.Printf("SIGHUP: postgres connection changed to %s, requires restart", config.PostgresConnStringMasked(&next.Postgres))
+	}
+
+	if next.Logging.Level != cfg.Logging.Level {
+		logger.SetDefaultLevelFromString(next.Logging.Level)
+		log.Printf("SIGHUP: logging.level %q -> %q", cfg.Logging.Level, next.Logging.Level)
+		cfg.Logging.Level = next.Logging.Level
+	}
+	if next.Proxy.Timeout != cfg.Proxy.Timeout {
+		server.PgRollback.SetTimeout(next.Proxy.Timeout)
+		log.Printf("SIGHUP: proxy.timeout %s -> %s", cfg.Proxy.Timeout, next.Proxy.Timeout)
+		cfg.Proxy.Timeout = next.Proxy.Timeout
+	}
+	if next.Proxy.KeepaliveInterval != cfg.Proxy.KeepaliveInterval {
+		server.PgRollback.SetKeepaliveInterval(next.Proxy.KeepaliveInterval.Duration)
+		log.Printf("SIGHUP: proxy.keepalive_interval %s -> %s", cfg.Proxy.KeepaliveInterval.Duration, next.Proxy.KeepaliveInterval.Duration)
+		cfg.Proxy.KeepaliveInterval = next.Proxy.KeepaliveInterval
+	}
+	// Only the applied fields change in the running config, so GET /api/config keeps showing what is in effect.
+	config.SetConfig(cfg)
+	log.Printf("SIGHUP: config reloaded from %s", reloadSource(result.ConfigPath))
+}
+
+// reloadSource names where a reload read its config from.
+func reloadSource(path string) string {
+	if path == "" {
+		return "defaults and environment"
+	}
+	return path
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"pgrollback/internal/config"
+	"pgrollback/internal/proxy"
+)
+
+// replayUsage is printed when "pgrollback replay" is misused.
+const replayUsage = `usage:
+  pgrollback replay <file.pgcapture> [config.yaml]   send a captured client stream to the running proxy`
+
+// runReplayCommand replays a proxy.capture_dir file against the proxy described by the config (its listen
+// address) and returns the process exit code: 1 when a response differs from the captured one.
+func runReplayCommand(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || len(args) > 2 || args[0] == "" {
+		fmt.Fprintln(stderr, replayUsage)
+		return 2
+	}
+	configPath := ""
+	if len(args) == 2 {
+		configPath = args[1]
+	}
+	configResult, err := config.LoadConfigWithPath(configPath)
+	if err != nil {
+		fmt.Fprintf(stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer f.Close()
+
+	cfg := configResult.Config
+	host := cfg.Proxy.ListenHost
+	switch host {
+	case "", "0.0.0.0", "::", "[::]":
+		host = "127.0.0.1"
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	differences, err := proxy.ReplayCapture(ctx, net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(cfg.Proxy.ListenPort)), f, stdout)
+	if err != nil {
+		fmt.Fprintf(stderr, "replay: %v\n", err)
+		return 1
+	}
+	if differences > 0 {
+		fmt.Fprintf(stdout, "%d response(s) differ from the capture\n", differences)
+		return 1
+	}
+	fmt.Fprintln(stdout, "all responses match the capture")
+	return 0
+}
@@ -3,9 +3,14 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/url"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"pgrollback/internal/testutil"
@@ -13,10 +18,17 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// Config é a configuração completa do proxy.
+//
+// Recarregáveis sem reiniciar (SIGHUP no processo pgrollback): logging.level, proxy.timeout e
+// proxy.keepalive_interval. Os demais campos só valem a partir do próximo start; mudanças em
+// proxy.listen_host/listen_port e na conexão postgres.* são registradas no log como "requires restart".
 type Config struct {
 	Postgres PostgresConfig `yaml:"postgres" json:"postgres"`
 	Proxy    ProxyConfig    `yaml:"proxy" json:"proxy"`
 	Logging  LoggingConfig  `yaml:"logging" json:"logging"`
+	GUI      GUIConfig      `yaml:"gui" json:"gui"`
+	Tracing  TracingConfig  `yaml:"tracing" json:"tracing"`
 	Test     TestConfig     `yaml:"test" json:"test"`
 }
 
@@ -27,18 +39,82 @@ type PostgresConfig struct {
 	User           string   `yaml:"user" json:"user"`
 	Password       string   `yaml:"password" json:"password"`
 	SessionTimeout Duration `yaml:"session_timeout" json:"session_timeout"` // Timeout de sessão PostgreSQL (idle_in_transaction_session_timeout)
+	WarmPoolSize   int      `yaml:"warm_pool_size" json:"warm_pool_size"`   // Conexões abertas de antemão para novas sessões (reabastecidas em background); 0 = nenhuma
+
+	// Routes manda as sessões cujo test ID começa com a chave para o banco do DSN (URL ou key=value);
+	// o prefixo mais longo vence e o resto usa o banco acima.
+	Routes map[string]string `yaml:"routes" json:"routes"`
+
+	// ConnectRetries tenta de novo a conexão+BEGIN de uma nova sessão (PostgreSQL ainda subindo);
+	// a espera começa em ConnectRetryInterval e dobra a cada tentativa, com jitter.
+	ConnectRetries       int      `yaml:"connect_retries" json:"connect_retries"`
+	ConnectRetryInterval Duration `yaml:"connect_retry_interval" json:"connect_retry_interval"`
+
+	// SessionSetupSQL roda logo após o BEGIN de toda transação base da sessão (SET search_path,
+	// CREATE SCHEMA IF NOT EXISTS ...) e é desfeito junto com o resto do teste.
+	SessionSetupSQL []string `yaml:"session_setup_sql" json:"session_setup_sql"`
 }
 
 type ProxyConfig struct {
-	ListenHost        string        `yaml:"listen_host" json:"listen_host"`
-	ListenPort        int           `yaml:"listen_port" json:"listen_port"`
-	Timeout           time.Duration `yaml:"timeout" json:"timeout"`
-	KeepaliveInterval Duration      `yaml:"keepalive_interval" json:"keepalive_interval"` // Intervalo de ping para manter conexão viva (ex.: em debugging)
+	ListenHost            string        `yaml:"listen_host" json:"listen_host"`
+	ListenPort            int           `yaml:"listen_port" json:"listen_port"`
+	Timeout               time.Duration `yaml:"timeout" json:"timeout"`                                 // Inatividade após a qual a sessão é destruída; recarregável via SIGHUP
+	KeepaliveInterval     Duration      `yaml:"keepalive_interval" json:"keepalive_interval"`           // Intervalo de ping para manter conexão viva (ex.: em debugging); recarregável via SIGHUP
+	TLSCert               string        `yaml:"tls_cert" json:"tls_cert"`                               // Certificado PEM; com tls_key habilita TLS no SSLRequest
+	TLSKey                string        `yaml:"tls_key" json:"tls_key"`                                 // Chave privada PEM do certificado
+	MaxPreparedStatements int           `yaml:"max_prepared_statements" json:"max_prepared_statements"` // Limite por conexão; acima disso o menos usado é desalocado (LRU)
+	CheckBackendOnStart   bool          `yaml:"check_backend_on_start" json:"check_backend_on_start"`   // Testa conexão com o PostgreSQL real na inicialização e aborta se falhar
+	BeginWaitTimeout      Duration      `yaml:"begin_wait_timeout" json:"begin_wait_timeout"`           // BEGIN de outra conexão espera a transação aberta terminar; 0 = erro 55006 imediato
+	AuthMethod            string        `yaml:"auth_method" json:"auth_method"`                         // Autenticação simulada pedida ao cliente: password (texto claro) ou md5
+	ReadConnection        bool          `yaml:"read_connection" json:"read_connection"`                 // Conexão extra somente leitura por sessão para SELECTs de clientes read-only (não vê escritas do teste)
+	LockWaitTimeout       Duration      `yaml:"lock_wait_timeout" json:"lock_wait_timeout"`             // Espera máxima por lock de outra sessão antes de cancelar com 40P01; 0 = desligado
+	AdvisoryLockTimeout   Duration      `yaml:"advisory_lock_timeout" json:"advisory_lock_timeout"`     // Espera máxima pelo advisory lock do test_id (ExecuteWithLock); 0 = padrão de 30s
+	ReadTimeout           Duration      `yaml:"read_timeout" json:"read_timeout"`                       // Limite de uma leitura do cliente no meio de uma mensagem; 0 = sem limite
+	IdleTimeout           Duration      `yaml:"idle_timeout" json:"idle_timeout"`                       // Fecha a conexão cliente sem nenhuma mensagem por esse tempo; 0 = sem limite
+	MaxConnections        int           `yaml:"max_connections" json:"max_connections"`                 // Máximo de conexões cliente simultâneas; acima disso 53300 too_many_connections; 0 = sem limite
+	ConnectionWaitTimeout Duration      `yaml:"connection_wait_timeout" json:"connection_wait_timeout"` // Espera por uma vaga quando max_connections foi atingido; 0 = recusa imediata
+	SavepointPrefix       string        `yaml:"savepoint_prefix" json:"savepoint_prefix"`               // Prefixo dos savepoints que substituem BEGIN (<prefixo>N); savepoints da aplicação não devem começar com ele
+	ListenSocket          string        `yaml:"listen_socket" json:"listen_socket"`                     // Diretório do socket Unix <dir>/.s.PGSQL.<listen_port>, além do TCP; vazio = só TCP
+	IsolateSchema         bool          `yaml:"isolate_schema" json:"isolate_schema"`                   // Schema próprio por sessão, primeiro no search_path (CREATE sem schema não colide entre test IDs)
+	CaptureDir            string        `yaml:"capture_dir" json:"capture_dir"`                         // Grava o protocolo cliente↔proxy de cada conexão em <dir>/*.pgcapture (pgrollback replay); vazio = desligado
+	CaptureTestID         string        `yaml:"capture_test_id" json:"capture_test_id"`                 // Com capture_dir, grava só as conexões deste test ID; vazio = todas
+	QueryHistorySize      int           `yaml:"query_history_size" json:"query_history_size"`           // Queries guardadas por sessão para a GUI e "pgrollback history"; 0 = sem histórico
+
+	// ConcurrentConnectionsNotice: acima desse número de conexões simultâneas de um test ID, a que passou
+	// do limite recebe um WARNING (uma vez por sessão) sugerindo pool de uma conexão; 0 = desligado.
+	ConcurrentConnectionsNotice int `yaml:"concurrent_connections_notice" json:"concurrent_connections_notice"`
+
+	// AllowedStatements / DeniedStatements: comandos ("DROP DATABASE", "ALTER SYSTEM", "SELECT", ou só a
+	// primeira palavra, "DROP") que o proxy deixa / não deixa chegar ao PostgreSQL; negados recebem 42501.
+	AllowedStatements []string `yaml:"allowed_statements" json:"allowed_statements"`
+	DeniedStatements  []string `yaml:"denied_statements" json:"denied_statements"`
+
+	// QueryHistoryLabel: template do rótulo "[rótulo] " das queries de cada conexão no histórico da sessão
+	// ({addr}, {conn}, {test_id}, {app}); vazio = sem rótulo.
+	QueryHistoryLabel string `yaml:"query_history_label" json:"query_history_label"`
+
+	// MaxMessageSize: maior mensagem (em bytes) aceita de um cliente; uma maior recebe FATAL 08P01 e a
+	// conexão é fechada antes de o proxy alocar o corpo. 0 = DefaultMaxMessageSize.
+	MaxMessageSize int `yaml:"max_message_size" json:"max_message_size"`
+
+	// DefaultTestID: test ID das conexões sem application_name ({database}, {user}, {host}), em vez da
+	// sessão "default"; vazio = "default".
+	DefaultTestID string `yaml:"default_test_id" json:"default_test_id"`
+}
+
+type GUIConfig struct {
+	AdminToken string `yaml:"admin_token" json:"admin_token"` // Se definido, exigido nas ações administrativas da API (Bearer)
+}
+
+type TracingConfig struct {
+	Enabled  bool   `yaml:"enabled" json:"enabled"`   // Exporta spans OpenTelemetry por mensagem de cliente, interceptação e execução
+	Endpoint string `yaml:"endpoint" json:"endpoint"` // Coletor OTLP/HTTP: URL http(s)://... ou host:porta (sem TLS); vazio = OTEL_EXPORTER_OTLP_* ou localhost:4318
 }
 
 type LoggingConfig struct {
-	Level string `yaml:"level" json:"level"`
-	File  string `yaml:"file" json:"file"`
+	Level  string `yaml:"level" json:"level"` // Recarregável via SIGHUP
+	File   string `yaml:"file" json:"file"`
+	Format string `yaml:"format" json:"format"` // text (padrão) ou json (uma linha JSON por entrada)
 }
 
 // Duration é um tipo customizado para fazer parsing de time.Duration do YAML
@@ -105,6 +181,17 @@ func LoadConfig(configPath string) (*Config, error) {
 
 // LoadConfigWithPath carrega a configuração e retorna também o caminho do arquivo usado
 func LoadConfigWithPath(configPath string) (*LoadConfigResult, error) {
+	return loadConfigWithPath(configPath, validateConfig)
+}
+
+// LoadConfigForStartup is LoadConfigWithPath for a proxy about to run with the config: it checks it with
+// Validate, so the field problems and the startup problems are reported together.
+func LoadConfigForStartup(configPath string) (*LoadConfigResult, error) {
+	return loadConfigWithPath(configPath, Validate)
+}
+
+// loadConfigWithPath loads the config and checks it with validate.
+func loadConfigWithPath(configPath string, validate func(*Config) error) (*LoadConfigResult, error) {
 	config := &Config{
 		Postgres: PostgresConfig{
 			Host:           "localhost",
@@ -113,12 +200,22 @@ func LoadConfigWithPath(configPath string) (*LoadConfigResult, error) {
 			User:           "postgres",
 			Password:       "",
 			SessionTimeout: Duration{Duration: 24 * time.Hour}, // Padrão: 24 horas
+
+			ConnectRetryInterval: Duration{Duration: DefaultConnectRetryInterval},
 		},
 		Proxy: ProxyConfig{
-			ListenHost:        "localhost",
-			ListenPort:        5432,
-			Timeout:           3600 * time.Second,
-			KeepaliveInterval: Duration{Duration: 60 * time.Second},
+			ListenHost:            "localhost",
+			ListenPort:            5432,
+			Timeout:               3600 * time.Second,
+			KeepaliveInterval:     Duration{Duration: 60 * time.Second},
+			MaxPreparedStatements: 512,
+			QueryHistorySize:      DefaultQueryHistorySize,
+			AuthMethod:            "password",
+			SavepointPrefix:       DefaultSavepointPrefix,
+
+			ConcurrentConnectionsNotice: DefaultConcurrentConnectionsNotice,
+			QueryHistoryLabel:           DefaultQueryHistoryLabel,
+			MaxMessageSize:              DefaultMaxMessageSize,
 		},
 		Logging: LoggingConfig{
 			Level: "info",
@@ -182,7 +279,7 @@ func LoadConfigWithPath(configPath string) (*LoadConfigResult, error) {
 			config.Proxy.ListenHost, config.Proxy.ListenPort)
 	}
 
-	if err := validateConfig(config); err != nil {
+	if err := validate(config); err != nil {
 		return nil, err
 	}
 
@@ -227,6 +324,23 @@ func loadFromEnv(config *Config) {
 				config.Postgres.SessionTimeout = Duration{Duration: d}
 			}
 		}, nil},
+		{"POSTGRES_WARM_POOL_SIZE", func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				config.Postgres.WarmPoolSize = n
+			}
+		}, nil},
+		{"POSTGRES_ROUTES", func(v string) { config.Postgres.Routes = parseRoutesEnv(v) }, nil},
+		{"POSTGRES_SESSION_SETUP_SQL", func(v string) { config.Postgres.SessionSetupSQL = []string{v} }, nil},
+		{"POSTGRES_CONNECT_RETRIES", func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				config.Postgres.ConnectRetries = n
+			}
+		}, nil},
+		{"POSTGRES_CONNECT_RETRY_INTERVAL", func(v string) {
+			if d, err := time.ParseDuration(v); err == nil {
+				config.Postgres.ConnectRetryInterval = Duration{Duration: d}
+			}
+		}, nil},
 		// Proxy
 		{"PGROLLBACK_LISTEN_HOST", func(v string) { config.Proxy.ListenHost = v }, nil},
 		{"PGROLLBACK_LISTEN_PORT", func(v string) {
@@ -244,9 +358,99 @@ func loadFromEnv(config *Config) {
 				config.Proxy.KeepaliveInterval = Duration{Duration: d}
 			}
 		}, nil},
+		{"PGROLLBACK_TLS_CERT", func(v string) { config.Proxy.TLSCert = v }, nil},
+		{"PGROLLBACK_TLS_KEY", func(v string) { config.Proxy.TLSKey = v }, nil},
+		{"PGROLLBACK_CHECK_BACKEND_ON_START", func(v string) {
+			if b, err := strconv.ParseBool(v); err == nil {
+				config.Proxy.CheckBackendOnStart = b
+			}
+		}, nil},
+		{"PGROLLBACK_AUTH_METHOD", func(v string) { config.Proxy.AuthMethod = v }, nil},
+		{"PGROLLBACK_SAVEPOINT_PREFIX", func(v string) { config.Proxy.SavepointPrefix = v }, nil},
+		{"PGROLLBACK_LISTEN_SOCKET", func(v string) { config.Proxy.ListenSocket = v }, nil},
+		{"PGROLLBACK_CAPTURE_DIR", func(v string) { config.Proxy.CaptureDir = v }, nil},
+		{"PGROLLBACK_CAPTURE_TEST_ID", func(v string) { config.Proxy.CaptureTestID = v }, nil},
+		{"PGROLLBACK_ISOLATE_SCHEMA", func(v string) {
+			if b, err := strconv.ParseBool(v); err == nil {
+				config.Proxy.IsolateSchema = b
+			}
+		}, nil},
+		{"PGROLLBACK_READ_CONNECTION", func(v string) {
+			if b, err := strconv.ParseBool(v); err == nil {
+				config.Proxy.ReadConnection = b
+			}
+		}, nil},
+		{"PGROLLBACK_BEGIN_WAIT_TIMEOUT", func(v string) {
+			if d, err := time.ParseDuration(v); err == nil {
+				config.Proxy.BeginWaitTimeout = Duration{Duration: d}
+			}
+		}, nil},
+		{"PGROLLBACK_LOCK_WAIT_TIMEOUT", func(v string) {
+			if d, err := time.ParseDuration(v); err == nil {
+				config.Proxy.LockWaitTimeout = Duration{Duration: d}
+			}
+		}, nil},
+		{"PGROLLBACK_ADVISORY_LOCK_TIMEOUT", func(v string) {
+			if d, err := time.ParseDuration(v); err == nil {
+				config.Proxy.AdvisoryLockTimeout = Duration{Duration: d}
+			}
+		}, nil},
+		{"PGROLLBACK_READ_TIMEOUT", func(v string) {
+			if d, err := time.ParseDuration(v); err == nil {
+				config.Proxy.ReadTimeout = Duration{Duration: d}
+			}
+		}, nil},
+		{"PGROLLBACK_IDLE_TIMEOUT", func(v string) {
+			if d, err := time.ParseDuration(v); err == nil {
+				config.Proxy.IdleTimeout = Duration{Duration: d}
+			}
+		}, nil},
+		{"PGROLLBACK_MAX_CONNECTIONS", func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				config.Proxy.MaxConnections = n
+			}
+		}, nil},
+		{"PGROLLBACK_CONNECTION_WAIT_TIMEOUT", func(v string) {
+			if d, err := time.ParseDuration(v); err == nil {
+				config.Proxy.ConnectionWaitTimeout = Duration{Duration: d}
+			}
+		}, nil},
+		{"PGROLLBACK_MAX_PREPARED_STATEMENTS", func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				config.Proxy.MaxPreparedStatements = n
+			}
+		}, nil},
+		{"PGROLLBACK_QUERY_HISTORY_SIZE", func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				config.Proxy.QueryHistorySize = n
+			}
+		}, nil},
+		{"PGROLLBACK_ALLOWED_STATEMENTS", func(v string) { config.Proxy.AllowedStatements = strings.Split(v, ",") }, nil},
+		{"PGROLLBACK_DENIED_STATEMENTS", func(v string) { config.Proxy.DeniedStatements = strings.Split(v, ",") }, nil},
+		{"PGROLLBACK_QUERY_HISTORY_LABEL", func(v string) { config.Proxy.QueryHistoryLabel = v }, nil},
+		{"PGROLLBACK_CONCURRENT_CONNECTIONS_NOTICE", func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				config.Proxy.ConcurrentConnectionsNotice = n
+			}
+		}, nil},
+		{"PGROLLBACK_MAX_MESSAGE_SIZE", func(v string) {
+			if n, err := strconv.Atoi(v); err == nil {
+				config.Proxy.MaxMessageSize = n
+			}
+		}, nil},
+		{"PGROLLBACK_DEFAULT_TEST_ID", func(v string) { config.Proxy.DefaultTestID = v }, nil},
+		// GUI
+		{"PGROLLBACK_GUI_ADMIN_TOKEN", func(v string) { config.GUI.AdminToken = v }, nil},
+		{"PGROLLBACK_TRACING_ENABLED", func(v string) {
+			if b, err := strconv.ParseBool(v); err == nil {
+				config.Tracing.Enabled = b
+			}
+		}, nil},
+		{"PGROLLBACK_TRACING_ENDPOINT", func(v string) { config.Tracing.Endpoint = v }, nil},
 		// Logging
 		{"PGROLLBACK_LOG_LEVEL", func(v string) { config.Logging.Level = v }, nil},
 		{"PGROLLBACK_LOG_FILE", func(v string) { config.Logging.File = v }, nil},
+		{"PGROLLBACK_LOG_FORMAT", func(v string) { config.Logging.Format = v }, nil},
 	}
 
 	for _, mapping := range envMappings {
@@ -256,22 +460,292 @@ func loadFromEnv(config *Config) {
 	}
 }
 
+// ValidationError lists every problem Validate found in a config, so they can all be fixed in one go.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	if len(e.Problems) == 1 {
+		return "invalid config: " + e.Problems[0]
+	}
+	return fmt.Sprintf("invalid config, %d problems:\n  - %s", len(e.Problems), strings.Join(e.Problems, "\n  - "))
+}
+
+// Validate checks a config before the proxy starts with it: each field (as LoadConfig does) and the
+// settings the proxy cannot run with, such as proxy.listen_port taking the port of the PostgreSQL it
+// forwards to on the same host, or a proxy.timeout that expires every session at once. It returns a
+// *ValidationError listing all problems, or nil.
+func Validate(config *Config) error {
+	if config == nil {
+		return &ValidationError{Problems: []string{"config is nil"}}
+	}
+	problems := append(configProblems(config), startupProblems(config)...)
+	if len(problems) == 0 {
+		return nil
+	}
+	return &ValidationError{Problems: problems}
+}
+
+// validateConfig checks each field on load. Settings that only matter to a running proxy are left to
+// Validate, so admin commands still load a config that could not start one.
 func validateConfig(config *Config) error {
-	if config.Postgres.Host == "" {
-		return fmt.Errorf("POSTGRES_HOST is required")
+	if problems := configProblems(config); len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// startupProblems returns the problems that keep the proxy from running with config.
+func startupProblems(config *Config) []string {
+	var problems []string
+	if p := config.Proxy.ListenPort; p < 1 || p > 65535 {
+		problems = append(problems, fmt.Sprintf("proxy.listen_port must be between 1 and 65535, got %d", p))
+	} else if p == config.Postgres.Port && sameHost(config.Proxy.ListenHost, config.Postgres.Host) {
+		problems = append(problems, fmt.Sprintf("proxy.listen_port %d is also postgres.port on %s: the proxy would forward to itself or fail to listen; move one of them (env PGROLLBACK_LISTEN_PORT / POSTGRES_PORT)", p, config.Postgres.Host))
+	}
+	if config.Proxy.Timeout <= 0 {
+		problems = append(problems, fmt.Sprintf("proxy.timeout must be positive, got %s: sessions idle longer than it are destroyed", config.Proxy.Timeout))
+	}
+	if config.Postgres.SessionTimeout.Duration < 0 {
+		problems = append(problems, "postgres.session_timeout must not be negative (0 = default)")
+	}
+	if config.Proxy.KeepaliveInterval.Duration < 0 {
+		problems = append(problems, "proxy.keepalive_interval must not be negative (0 = off)")
+	}
+	for _, t := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"test.context_timeout", config.Test.ContextTimeout.Duration},
+		{"test.query_timeout", config.Test.QueryTimeout.Duration},
+		{"test.ping_timeout", config.Test.PingTimeout.Duration},
+	} {
+		if t.value <= 0 {
+			problems = append(problems, fmt.Sprintf("%s must be positive, got %s", t.name, t.value))
+		}
+	}
+	return problems
+}
+
+// sameHost reports whether listenHost (where the proxy listens) and postgresHost name the same machine
+// as far as the config can tell: equal names, both loopback, or a wildcard listen address and a
+// loopback PostgreSQL.
+func sameHost(listenHost, postgresHost string) bool {
+	listenHost = strings.ToLower(strings.Trim(strings.TrimSpace(listenHost), "[]"))
+	postgresHost = strings.ToLower(strings.Trim(strings.TrimSpace(postgresHost), "[]"))
+	if listenHost == postgresHost {
+		return true
+	}
+	if !isLoopbackHost(postgresHost) {
+		return false
+	}
+	return isLoopbackHost(listenHost) || listenHost == "" || listenHost == "0.0.0.0" || listenHost == "::" || listenHost == "*"
+}
+
+// isLoopbackHost reports whether host is localhost or a loopback address.
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// configProblems returns the problems of each field of config.
+func configProblems(config *Config) []string {
+	var problems []string
+	if strings.TrimSpace(config.Postgres.Host) == "" {
+		problems = append(problems, "postgres.host is required (env POSTGRES_HOST)")
 	}
 	if config.Postgres.Port == 0 {
-		return fmt.Errorf("POSTGRES_PORT is required")
+		problems = append(problems, "postgres.port is required (env POSTGRES_PORT)")
+	} else if p := config.Postgres.Port; p < 1 || p > 65535 {
+		problems = append(problems, fmt.Sprintf("postgres.port must be between 1 and 65535, got %d", p))
 	}
-	if config.Postgres.Database == "" {
-		return fmt.Errorf("POSTGRES_DB is required")
+	if strings.TrimSpace(config.Postgres.Database) == "" {
+		problems = append(problems, "postgres.database is required (env POSTGRES_DB)")
 	}
-	if config.Postgres.User == "" {
-		return fmt.Errorf("POSTGRES_USER is required")
+	if strings.TrimSpace(config.Postgres.User) == "" {
+		problems = append(problems, "postgres.user is required (env POSTGRES_USER)")
 	}
-	return nil
+	if n := config.Postgres.WarmPoolSize; n < 0 || n > maxWarmPoolSize {
+		problems = append(problems, fmt.Sprintf("postgres.warm_pool_size must be between 0 and %d, got %d", maxWarmPoolSize, n))
+	}
+	for prefix, dsn := range config.Postgres.Routes {
+		if prefix == "" || strings.TrimSpace(dsn) == "" {
+			problems = append(problems, fmt.Sprintf("postgres.routes needs a non-empty test ID prefix and DSN, got %q: %q", prefix, dsn))
+		}
+	}
+	if n := config.Postgres.ConnectRetries; n < 0 || n > maxConnectRetries {
+		problems = append(problems, fmt.Sprintf("postgres.connect_retries must be between 0 and %d, got %d", maxConnectRetries, n))
+	}
+	if config.Postgres.ConnectRetryInterval.Duration < 0 {
+		problems = append(problems, "postgres.connect_retry_interval must not be negative")
+	}
+	for i, stmt := range config.Postgres.SessionSetupSQL {
+		if strings.TrimSpace(stmt) == "" {
+			problems = append(problems, fmt.Sprintf("postgres.session_setup_sql[%d] is empty", i))
+		}
+	}
+	if (config.Proxy.TLSCert == "") != (config.Proxy.TLSKey == "") {
+		problems = append(problems, "proxy.tls_cert and proxy.tls_key must be set together")
+	}
+	if f := strings.ToLower(strings.TrimSpace(config.Logging.Format)); f != "" && f != "text" && f != "json" {
+		problems = append(problems, fmt.Sprintf("logging.format must be text or json, got %q", config.Logging.Format))
+	}
+	if config.Proxy.MaxPreparedStatements < 0 {
+		problems = append(problems, "proxy.max_prepared_statements must not be negative")
+	}
+	if config.Proxy.ConcurrentConnectionsNotice < 0 {
+		problems = append(problems, "proxy.concurrent_connections_notice must not be negative")
+	}
+	if n := config.Proxy.MaxMessageSize; n != 0 && (n < minMaxMessageSize || n > maxMaxMessageSize) {
+		problems = append(problems, fmt.Sprintf("proxy.max_message_size must be 0 (default) or between %d and %d bytes, got %d", minMaxMessageSize, maxMaxMessageSize, n))
+	}
+	for i, entry := range config.Proxy.AllowedStatements {
+		if strings.TrimSpace(entry) == "" {
+			problems = append(problems, fmt.Sprintf("proxy.allowed_statements[%d] is empty", i))
+		}
+	}
+	for i, entry := range config.Proxy.DeniedStatements {
+		if strings.TrimSpace(entry) == "" {
+			problems = append(problems, fmt.Sprintf("proxy.denied_statements[%d] is empty", i))
+		}
+	}
+	if n := config.Proxy.QueryHistorySize; n < 0 || n > maxQueryHistorySize {
+		problems = append(problems, fmt.Sprintf("proxy.query_history_size must be between 0 and %d, got %d", maxQueryHistorySize, n))
+	}
+	if e := config.Tracing.Endpoint; strings.Contains(e, "://") {
+		if u, err := url.Parse(e); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			problems = append(problems, fmt.Sprintf("tracing.endpoint must be an http:// or https:// URL or host:port, got %q", e))
+		}
+	}
+	for _, field := range historyLabelFieldPattern.FindAllString(config.Proxy.QueryHistoryLabel, -1) {
+		if !slices.Contains(queryHistoryLabelFields, field) {
+			problems = append(problems, fmt.Sprintf("proxy.query_history_label: unknown field %s (use %s)", field, strings.Join(queryHistoryLabelFields, ", ")))
+		}
+	}
+	for _, field := range historyLabelFieldPattern.FindAllString(config.Proxy.DefaultTestID, -1) {
+		if !slices.Contains(defaultTestIDFields, field) {
+			problems = append(problems, fmt.Sprintf("proxy.default_test_id: unknown field %s (use %s)", field, strings.Join(defaultTestIDFields, ", ")))
+		}
+	}
+	if m := config.Proxy.AuthMethod; m != "" && m != "password" && m != "md5" {
+		problems = append(problems, fmt.Sprintf("proxy.auth_method must be password or md5, got %q", m))
+	}
+	if config.Proxy.BeginWaitTimeout.Duration < 0 {
+		problems = append(problems, "proxy.begin_wait_timeout must not be negative")
+	}
+	if config.Proxy.LockWaitTimeout.Duration < 0 {
+		problems = append(problems, "proxy.lock_wait_timeout must not be negative")
+	}
+	if config.Proxy.AdvisoryLockTimeout.Duration < 0 {
+		problems = append(problems, "proxy.advisory_lock_timeout must not be negative")
+	}
+	if config.Proxy.ReadTimeout.Duration < 0 {
+		problems = append(problems, "proxy.read_timeout must not be negative")
+	}
+	if config.Proxy.IdleTimeout.Duration < 0 {
+		problems = append(problems, "proxy.idle_timeout must not be negative")
+	}
+	if config.Proxy.MaxConnections < 0 {
+		problems = append(problems, "proxy.max_connections must not be negative")
+	}
+	if config.Proxy.ConnectionWaitTimeout.Duration < 0 {
+		problems = append(problems, "proxy.connection_wait_timeout must not be negative")
+	}
+	if p := config.Proxy.SavepointPrefix; p != "" {
+		if !savepointPrefixPattern.MatchString(p) {
+			problems = append(problems, fmt.Sprintf("proxy.savepoint_prefix must be a lowercase identifier of at most %d characters (letters, digits, _), got %q", maxSavepointPrefixLen, p))
+		}
+		if strings.HasPrefix(p, namedSavepointPrefix) || strings.HasPrefix(namedSavepointPrefix, p) {
+			problems = append(problems, fmt.Sprintf("proxy.savepoint_prefix %q collides with the %q savepoints of \"pgrollback savepoint\"", p, namedSavepointPrefix))
+		}
+	}
+	if dir := config.Proxy.ListenSocket; dir != "" {
+		if path := filepath.Join(dir, ".s.PGSQL.65535"); len(path) > maxUnixSocketPathLen {
+			problems = append(problems, fmt.Sprintf("proxy.listen_socket %q is too long: the socket path %s must fit in %d bytes", dir, path, maxUnixSocketPathLen))
+		}
+	}
+	if config.Proxy.CaptureTestID != "" && config.Proxy.CaptureDir == "" {
+		problems = append(problems, "proxy.capture_test_id requires proxy.capture_dir")
+	}
+	return problems
 }
 
+// parseRoutesEnv reads POSTGRES_ROUTES: "prefix=dsn" entries separated by ";" (the prefix ends at the
+// first "=", so key=value DSNs work), e.g. "app1:=postgres://u:p@db/app1;app2:=host=db dbname=app2".
+func parseRoutesEnv(v string) map[string]string {
+	routes := make(map[string]string)
+	for _, entry := range strings.Split(v, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		prefix, dsn, _ := strings.Cut(entry, "=")
+		routes[strings.TrimSpace(prefix)] = strings.TrimSpace(dsn)
+	}
+	return routes
+}
+
+// maxUnixSocketPathLen is the longest Unix socket path accepted everywhere (sun_path is 104 bytes on
+// macOS and the BSDs, 108 on Linux, including the terminating NUL).
+const maxUnixSocketPathLen = 103
+
+// DefaultQueryHistorySize is the default proxy.query_history_size (same as the proxy's maxQueryHistory).
+const DefaultQueryHistorySize = 100
+
+// maxQueryHistorySize bounds proxy.query_history_size: every session holds that many query texts.
+const maxQueryHistorySize = 100000
+
+// DefaultQueryHistoryLabel is the default proxy.query_history_label (same as proxy.DefaultQueryHistoryLabel).
+const DefaultQueryHistoryLabel = "{addr}"
+
+// queryHistoryLabelFields are the fields of proxy.query_history_label (same as proxy.QueryHistoryLabelFields).
+var queryHistoryLabelFields = []string{"{addr}", "{conn}", "{test_id}", "{app}"}
+
+var historyLabelFieldPattern = regexp.MustCompile(`\{[^{}]*\}`)
+
+// defaultTestIDFields are the fields of proxy.default_test_id (same as proxy.DefaultTestIDFields).
+var defaultTestIDFields = []string{"{database}", "{user}", "{host}"}
+
+// DefaultMaxMessageSize is the default proxy.max_message_size (same as proxy.DefaultMaxMessageSize).
+const DefaultMaxMessageSize = 64 << 20
+
+// minMaxMessageSize and maxMaxMessageSize bound proxy.max_message_size: below the first, ordinary startup
+// packets and queries are refused; above the second, PostgreSQL itself would refuse the message.
+const (
+	minMaxMessageSize = 16 << 10
+	maxMaxMessageSize = 1<<30 - 1
+)
+
+// maxWarmPoolSize bounds postgres.warm_pool_size: every warm connection holds a backend slot while idle.
+const maxWarmPoolSize = 100
+
+// DefaultConcurrentConnectionsNotice is the default proxy.concurrent_connections_notice: the pool size of
+// pkg/client, so its pools never trigger the notice.
+const DefaultConcurrentConnectionsNotice = 4
+
+// DefaultConnectRetryInterval is the default postgres.connect_retry_interval (same as proxy.DefaultConnectRetryInterval).
+const DefaultConnectRetryInterval = 500 * time.Millisecond
+
+// maxConnectRetries bounds postgres.connect_retries: a new session waits through every retry.
+const maxConnectRetries = 100
+
+// DefaultSavepointPrefix is the default proxy.savepoint_prefix (same as proxy.DefaultSavepointPrefix).
+const DefaultSavepointPrefix = "pgrollback_v_"
+
+// namedSavepointPrefix is the prefix of the backend savepoints of "pgrollback savepoint <name>"; the
+// savepoint_prefix must not overlap it or the proxy would mistake one kind for the other.
+const namedSavepointPrefix = "pgrollback_user_"
+
+// maxSavepointPrefixLen leaves room for the level digits within PostgreSQL's 63-byte identifier limit.
+const maxSavepointPrefixLen = 50
+
+// savepointPrefixPattern accepts prefixes usable unquoted in SAVEPOINT: PostgreSQL folds unquoted names to
+// lower case, so an upper-case prefix would never match the name it echoes back.
+var savepointPrefixPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,49}$`)
+
 // PasswordMask is the value returned for password in config API responses and in masked connection strings.
 const PasswordMask = "******"
 
@@ -285,7 +759,7 @@ func PostgresConnStringMasked(p *PostgresConfig) string {
 		p.Host, p.Port, p.Database, p.User, PasswordMask)
 }
 
-// ConfigForAPI returns a copy of the config with password masked as PasswordMask for API/UI display.
+// ConfigForAPI returns a copy of the config with password and GUI admin token masked as PasswordMask for API/UI display.
 func ConfigForAPI(c *Config) *Config {
 	if c == nil {
 		return nil
@@ -295,10 +769,14 @@ func ConfigForAPI(c *Config) *Config {
 	if c.Postgres.Password != "" {
 		out.Postgres.Password = PasswordMask
 	}
+	out.GUI.AdminToken = ""
+	if c.GUI.AdminToken != "" {
+		out.GUI.AdminToken = PasswordMask
+	}
 	return &out
 }
 
-// UpdateAndSave merges updated into the current config (keeping existing password if updated sends "" or PasswordMask),
+// UpdateAndSave merges updated into the current config (keeping existing password and admin token if updated sends "" or PasswordMask),
 // validates, writes to the config file, and updates in-memory config. Returns error if path is empty or write fails.
 func UpdateAndSave(updated *Config) error {
 	if updated == nil {
@@ -316,7 +794,10 @@ func UpdateAndSave(updated *Config) error {
 	if updated.Postgres.Password == "" || updated.Postgres.Password == PasswordMask || updated.Postgres.Password == "****" {
 		merged.Postgres.Password = current.Postgres.Password
 	}
-	if err := validateConfig(&merged); err != nil {
+	if updated.GUI.AdminToken == "" || updated.GUI.AdminToken == PasswordMask {
+		merged.GUI.AdminToken = current.GUI.AdminToken
+	}
+	if err := Validate(&merged); err != nil {
 		return err
 	}
 	data, err := yaml.Marshal(&merged)
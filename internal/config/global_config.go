@@ -36,6 +36,9 @@ func SetOnce(config *Config, cfgPath string) {
 
 // GetCfgIfSet returns the current config and true if config was initialized, or (nil, false).
 func GetCfgIfSet() (*Config, bool) {
+	if global == nil {
+		return nil, false // Init never called (e.g. proxy embedded in tests)
+	}
 	global.mu.RLock()
 	defer global.mu.RUnlock()
 	if global.instance == nil {
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// validTestConfig returns a config Validate accepts: the proxy on 6432 in front of PostgreSQL on 5432.
+func validTestConfig() *Config {
+	return &Config{
+		Postgres: PostgresConfig{Host: "localhost", Port: 5432, Database: "postgres", User: "postgres"},
+		Proxy: ProxyConfig{
+			ListenHost:      "localhost",
+			ListenPort:      6432,
+			Timeout:         time.Hour,
+			SavepointPrefix: DefaultSavepointPrefix,
+		},
+		Test: TestConfig{
+			ContextTimeout: Duration{Duration: 10 * time.Second},
+			QueryTimeout:   Duration{Duration: 5 * time.Second},
+			PingTimeout:    Duration{Duration: 3 * time.Second},
+		},
+	}
+}
+
+func TestValidate_Valid(t *testing.T) {
+	if err := Validate(validTestConfig()); err != nil {
+		t.Fatalf("Validate() = %v, want nil", err)
+	}
+}
+
+func TestValidate_ListsEveryProblem(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Postgres.Host = " "
+	cfg.Postgres.User = ""
+	cfg.Proxy.Timeout = 0
+	cfg.Proxy.SavepointPrefix = "Pgr-"
+	cfg.Test.QueryTimeout = Duration{}
+
+	err := Validate(cfg)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("Validate() = %v, want *ValidationError", err)
+	}
+	for _, want := range []string{"postgres.host", "postgres.user", "proxy.timeout", "proxy.savepoint_prefix", "test.query_timeout"} {
+		if !slices.ContainsFunc(invalid.Problems, func(p string) bool { return strings.HasPrefix(p, want) }) {
+			t.Errorf("no problem reported for %s in %q", want, invalid.Problems)
+		}
+	}
+	if len(invalid.Problems) != 5 {
+		t.Errorf("got %d problems, want 5: %q", len(invalid.Problems), invalid.Problems)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "invalid config, 5 problems:\n  - ") {
+		t.Errorf("Error() = %q, want one problem per line", msg)
+	}
+}
+
+func TestValidate_PortCollision(t *testing.T) {
+	tests := []struct {
+		listenHost, postgresHost string
+		collides                 bool
+	}{
+		{"localhost", "localhost", true},
+		{"127.0.0.1", "localhost", true},
+		{"0.0.0.0", "::1", true},
+		{"", "127.0.0.1", true},
+		{"db.internal", "DB.internal", true},
+		{"0.0.0.0", "db.internal", false},
+		{"localhost", "db.internal", false},
+		{"localhost", "/var/run/postgresql", false},
+	}
+	for _, tt := range tests {
+		cfg := validTestConfig()
+		cfg.Proxy.ListenHost, cfg.Postgres.Host = tt.listenHost, tt.postgresHost
+		cfg.Proxy.ListenPort = cfg.Postgres.Port
+		err := Validate(cfg)
+		if collides := err != nil && strings.Contains(err.Error(), "proxy.listen_port 5432 is also postgres.port"); collides != tt.collides {
+			t.Errorf("listen %q, postgres %q: Validate() = %v, want collision %v", tt.listenHost, tt.postgresHost, err, tt.collides)
+		}
+	}
+}
+
+func TestValidateConfig_LeavesStartupChecksToValidate(t *testing.T) {
+	cfg := validTestConfig()
+	cfg.Proxy.ListenPort = cfg.Postgres.Port
+	if err := validateConfig(cfg); err != nil {
+		t.Errorf("validateConfig() = %v, want nil: the port collision only matters to a running proxy", err)
+	}
+	if err := Validate(cfg); err == nil {
+		t.Error("Validate() accepted the proxy listening on the PostgreSQL port")
+	}
+}
+
+func TestLoadConfigForStartup_ReportsFieldAndStartupProblemsTogether(t *testing.T) {
+	for _, env := range []string{"POSTGRES_HOST", "POSTGRES_PORT", "PGROLLBACK_LISTEN_HOST", "PGROLLBACK_LISTEN_PORT", "PGROLLBACK_SAVEPOINT_PREFIX"} {
+		t.Setenv(env, "")
+	}
+	path := filepath.Join(t.TempDir(), "pgrollback.yaml")
+	yaml := "postgres:\n  host: localhost\n  port: 5432\nproxy:\n  listen_host: localhost\n  listen_port: 5432\n  savepoint_prefix: Pgr-\n"
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := LoadConfigForStartup(path)
+	var invalid *ValidationError
+	if !errors.As(err, &invalid) || len(invalid.Problems) != 2 {
+		t.Fatalf("LoadConfigForStartup() = %v, want the savepoint prefix and the port collision", err)
+	}
+	if !strings.HasPrefix(invalid.Problems[0], "proxy.savepoint_prefix") || !strings.HasPrefix(invalid.Problems[1], "proxy.listen_port") {
+		t.Errorf("problems = %q", invalid.Problems)
+	}
+	// Admin commands still load it with only the field problem.
+	if _, err := LoadConfigWithPath(path); err == nil || strings.Contains(err.Error(), "listen_port") {
+		t.Errorf("LoadConfigWithPath() = %v, want only the savepoint prefix problem", err)
+	}
+}
+
+func TestParseRoutesEnv_KeyValueDSN(t *testing.T) {
+	routes := parseRoutesEnv("app1:=postgres://u:p@db/app1; app2:=host=db dbname=app2 ;;")
+	want := map[string]string{"app1:": "postgres://u:p@db/app1", "app2:": "host=db dbname=app2"}
+	if len(routes) != len(want) || routes["app1:"] != want["app1:"] || routes["app2:"] != want["app2:"] {
+		t.Fatalf("parseRoutesEnv = %v, want %v", routes, want)
+	}
+
+	cfg := validTestConfig()
+	cfg.Postgres.Routes = parseRoutesEnv("app3:")
+	if err := Validate(cfg); err == nil || !strings.Contains(err.Error(), "postgres.routes") {
+		t.Errorf("an entry without \"=dsn\" must be reported, got %v", err)
+	}
+}
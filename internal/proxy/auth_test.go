@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// md5PasswordResponse builds the client reply for AuthenticationMD5Password: "md5" + md5(md5(password+user)+salt).
+func md5PasswordResponse(user, password string, salt [4]byte) string {
+	inner := md5.Sum([]byte(password + user))
+	innerHex := hex.EncodeToString(inner[:])
+	outer := md5.Sum(append([]byte(innerHex), salt[:]...))
+	return "md5" + hex.EncodeToString(outer[:])
+}
+
+func TestStartup_MD5AuthMethodHandshake(t *testing.T) {
+	// Unreachable backend: after the password the proxy reports the session error instead of AuthenticationOk.
+	pgr := NewPgRollback("127.0.0.1", 1, "postgres", "postgres", "", time.Second, time.Minute, 0)
+	s := &Server{activeConns: make(map[net.Conn]struct{}), PgRollback: pgr, authMethod: AuthMethodMD5}
+	conn := startPipeConnection(t, s)
+
+	frontend := pgproto3.NewFrontend(conn, conn)
+	frontend.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "postgres", "application_name": "md5_test"},
+	})
+	if err := frontend.Flush(); err != nil {
+		t.Fatalf("send startup: %v", err)
+	}
+
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive auth request: %v", err)
+	}
+	md5Req, ok := msg.(*pgproto3.AuthenticationMD5Password)
+	if !ok {
+		t.Fatalf("got %T, want *pgproto3.AuthenticationMD5Password", msg)
+	}
+
+	frontend.Send(&pgproto3.PasswordMessage{Password: md5PasswordResponse("postgres", "secret", md5Req.Salt)})
+	if err := frontend.Flush(); err != nil {
+		t.Fatalf("send password: %v", err)
+	}
+	msg, err = frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive after password: %v", err)
+	}
+	if _, ok := msg.(*pgproto3.ErrorResponse); !ok {
+		t.Fatalf("got %T after md5 password, want *pgproto3.ErrorResponse from the unreachable backend", msg)
+	}
+}
+
+func TestStartup_DefaultAuthMethodIsCleartext(t *testing.T) {
+	s := &Server{activeConns: make(map[net.Conn]struct{})}
+	conn := startPipeConnection(t, s)
+
+	frontend := pgproto3.NewFrontend(conn, conn)
+	frontend.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "postgres", "application_name": "cleartext_test"},
+	})
+	if err := frontend.Flush(); err != nil {
+		t.Fatalf("send startup: %v", err)
+	}
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive auth request: %v", err)
+	}
+	if _, ok := msg.(*pgproto3.AuthenticationCleartextPassword); !ok {
+		t.Fatalf("got %T, want *pgproto3.AuthenticationCleartextPassword", msg)
+	}
+}
@@ -0,0 +1,235 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Reconnection after the backend connection dies.
+//
+// When PostgreSQL restarts or drops the session's backend connection, the base transaction and every
+// savepoint in it are gone; without this the session would fail every query until destroyed. The next
+// client message on the session (or the statement that hit the fatal error) dials a fresh backend
+// connection, retrying with exponential backoff, and begins a new base transaction. The dial runs without
+// the session lock, so the session's other clients and the GUI do not hang behind the backoff; the read
+// connection (proxy.read_connection) is reopened with it. The transaction
+// state is reset to match the new backend: SavepointLevel 0, no open user transaction, no named
+// savepoints, no prepared statements. The next client to run a command gets a WARNING saying so, and each
+// client connection drops its own BEGIN count and statements before its next message (see
+// syncBackendGeneration).
+
+const (
+	reconnectMaxAttempts    = 6
+	reconnectInitialBackoff = 100 * time.Millisecond
+	reconnectMaxBackoff     = 3 * time.Second
+)
+
+// reconnectBackoff returns the pause before reconnection attempt n (1-based; none before the first).
+func reconnectBackoff(n int) time.Duration {
+	if n <= 1 {
+		return 0
+	}
+	d := reconnectInitialBackoff << (n - 2)
+	if d <= 0 || d > reconnectMaxBackoff {
+		return reconnectMaxBackoff
+	}
+	return d
+}
+
+// backendDeadLocked reports whether the backend connection is gone. Caller must hold d.mu.
+func (d *realSessionDB) backendDeadLocked() bool {
+	return d.conn == nil || d.conn.IsClosed()
+}
+
+// BackendGeneration counts the backend connections the session has had (0 for the first); it changes
+// each time the session reconnects.
+func (d *realSessionDB) BackendGeneration() uint64 {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.generation
+}
+
+// recoverBackend reconnects when the backend connection is dead: always when it is already closed,
+// and after a failed ping when cause looks fatal (isConnClosedOrFatal). It returns whether the session
+// now runs on a new backend connection. Sessions without a dial function (tests) never reconnect.
+func (d *realSessionDB) recoverBackend(cause error) (bool, error) {
+	if d == nil {
+		return false, nil
+	}
+	d.mu.RLock()
+	dead, canDial := d.conn != nil && d.backendDeadLocked(), d.dial != nil
+	d.mu.RUnlock()
+	if !canDial || (!dead && !isConnClosedOrFatal(cause)) {
+		return false, nil
+	}
+
+	d.mu.Lock()
+	if d.conn == nil {
+		d.mu.Unlock()
+		return false, nil // closed by DestroySession
+	}
+	if d.reconnectDone == nil && !d.backendDeadLocked() {
+		pingCtx, cancel := context.WithTimeout(d.contextOrBackground(), 5*time.Second)
+		err := d.conn.Ping(pingCtx)
+		cancel()
+		if err == nil {
+			d.mu.Unlock()
+			return false, nil
+		}
+	}
+	d.mu.Unlock()
+	return d.reconnect(d.contextOrBackground())
+}
+
+// reconnect replaces the dead backend connection and base transaction, retrying the dial with
+// exponential backoff, then resets the transaction state. The dial and the waits run without d.mu, so
+// the session's other clients (and the GUI) are not stuck behind them; meanwhile reconnectDone marks the
+// session as reconnecting, and a second caller waits for the first instead of dialing again. It returns
+// whether the session now runs on a new backend connection.
+func (d *realSessionDB) reconnect(ctx context.Context) (bool, error) {
+	d.mu.Lock()
+	if done := d.reconnectDone; done != nil {
+		generation := d.generation
+		d.mu.Unlock()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return false, ctx.Err()
+		}
+		d.mu.RLock()
+		defer d.mu.RUnlock()
+		if d.generation == generation {
+			return false, fmt.Errorf("backend connection lost; reconnect failed")
+		}
+		return true, nil
+	}
+	if d.conn == nil {
+		d.mu.Unlock()
+		return false, nil // closed by DestroySession
+	}
+	done := make(chan struct{})
+	d.reconnectDone = done
+	old := d.conn
+	d.mu.Unlock()
+
+	closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+	_ = old.Close(closeCtx)
+	cancel()
+	conn, tx, err := d.dialBackend(ctx)
+	if err == nil {
+		if rerr := d.readConn.reopen(ctx); rerr != nil {
+			log.Printf("[PROXY] read connection not reopened after the backend reconnect, reads use the write connection: %v", rerr)
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.reconnectDone = nil
+	close(done)
+	if err != nil {
+		return false, err
+	}
+	if d.conn == nil {
+		// DestroySession closed the session while we were dialing.
+		_ = conn.Close(context.Background())
+		return false, nil
+	}
+	d.replaceBackendLocked(conn, tx)
+	if err := d.runSessionSetupLocked(ctx); err != nil {
+		return true, fmt.Errorf("backend reconnected, but the session setup failed: %w", err)
+	}
+	return true, nil
+}
+
+// dialBackend opens a new backend connection and begins its base transaction, retrying with exponential
+// backoff. It does not touch the session's state and runs without d.mu.
+func (d *realSessionDB) dialBackend(ctx context.Context) (*pgx.Conn, pgx.Tx, error) {
+	var lastErr error
+	for attempt := 1; attempt <= reconnectMaxAttempts; attempt++ {
+		if wait := reconnectBackoff(attempt); wait > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, nil, ctx.Err()
+			case <-time.After(wait):
+			}
+		}
+		conn, err := d.dial(ctx)
+		if err != nil {
+			lastErr = err
+			log.Printf("[PROXY] backend reconnect attempt %d/%d failed: %v", attempt, reconnectMaxAttempts, err)
+			continue
+		}
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			lastErr = err
+			_ = conn.Close(ctx)
+			log.Printf("[PROXY] backend reconnect attempt %d/%d could not begin: %v", attempt, reconnectMaxAttempts, err)
+			continue
+		}
+		return conn, tx, nil
+	}
+	return nil, nil, fmt.Errorf("backend connection lost; reconnect failed after %d attempts: %w", reconnectMaxAttempts, lastErr)
+}
+
+// replaceBackendLocked installs a new backend connection and base transaction and drops the state that
+// lived in the old one. Caller must hold d.mu.
+func (d *realSessionDB) replaceBackendLocked(conn *pgx.Conn, tx pgx.Tx) {
+	level := d.GetSavepointLevel()
+	d.conn = conn
+	d.tx = tx
+	d.cancelConn.Store(conn.PgConn())
+	d.generation++
+	d.setSavepointLevelLocked(0)
+	d.namedSavepoints = nil
+	d.clearSchemaChanges()
+	d.releaseOpenTransactionLocked(d.connectionWithOpenTx)
+	d.invalidateClientGUCsLocked()
+
+	d.backendStatements.mu.Lock()
+	d.backendStatements.byConn = nil
+	d.backendStatements.mu.Unlock()
+	d.prepared.mu.Lock()
+	d.prepared.byConn = nil
+	d.prepared.mu.Unlock()
+
+	log.Printf("[PROXY] backend connection re-established (generation %d); %d open transaction level(s) lost", d.generation, level)
+	if d.notices != nil {
+		d.notices.queue(&pgconn.Notice{
+			Severity: "WARNING",
+			Code:     "01000",
+			Message:  "backend connection lost; transaction state reset",
+			Detail:   fmt.Sprintf("pgrollback reconnected to PostgreSQL and began a new base transaction. Everything done in the old one is gone, including %d open transaction level(s) and all prepared statements.", level),
+		})
+	}
+}
+
+// syncBackendGeneration brings this connection up to date with the session's backend: it reconnects a
+// dead backend and, when the session is on a new backend connection since this connection last looked,
+// forgets the connection's open BEGINs, SET LOCAL values and prepared statements, which died with the
+// old one. Called before each client message.
+func (p *proxyConnection) syncBackendGeneration(session *TestSession) {
+	if session == nil || session.DB == nil {
+		return
+	}
+	if _, err := session.DB.recoverBackend(nil); err != nil {
+		log.Printf("[PROXY] %v", err)
+	}
+	generation := session.DB.BackendGeneration()
+	p.mu.Lock()
+	stale := p.backendGeneration != generation
+	if stale {
+		p.backendGeneration = generation
+		p.userOpenTransactionCount = 0
+		p.userTxFailed = false
+		p.clearLocalGUCsLocked()
+	}
+	p.mu.Unlock()
+	if stale {
+		p.clearStatementPortalState()
+	}
+}
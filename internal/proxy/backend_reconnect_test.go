@@ -0,0 +1,138 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestReconnectBackoff_DoublesUpToMax(t *testing.T) {
+	want := []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond, 800 * time.Millisecond, 1600 * time.Millisecond, reconnectMaxBackoff, reconnectMaxBackoff}
+	for i, w := range want {
+		if got := reconnectBackoff(i + 1); got != w {
+			t.Errorf("reconnectBackoff(%d) = %s, want %s", i+1, got, w)
+		}
+	}
+	if got := reconnectBackoff(80); got != reconnectMaxBackoff {
+		t.Errorf("reconnectBackoff(80) = %s, want the cap (no overflow)", got)
+	}
+}
+
+func TestRecoverBackend_NeedsDialAndConnection(t *testing.T) {
+	db := newTestSessionDB()
+	dialed := false
+	db.dial = func(ctx context.Context) (*pgx.Conn, error) {
+		dialed = true
+		return nil, errors.New("unreachable")
+	}
+	// No connection: the session was closed by DestroySession, nothing to recover.
+	if ok, err := db.recoverBackend(errors.New("conn closed")); ok || err != nil || dialed {
+		t.Errorf("recoverBackend on a closed session = %v, %v (dialed=%v); want no reconnect", ok, err, dialed)
+	}
+	var nilDB *realSessionDB
+	if ok, err := nilDB.recoverBackend(errors.New("conn closed")); ok || err != nil {
+		t.Errorf("recoverBackend on nil = %v, %v", ok, err)
+	}
+}
+
+func TestSyncBackendGeneration_ForgetsStateOfOldBackend(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	session := &TestSession{DB: newTestSessionDB(), TestID: "reconnect"}
+
+	p.IncrementUserOpenTransactionCount()
+	p.markUserTransactionFailed()
+	p.preparedStatements["s1"] = "SELECT 1"
+	p.syncBackendGeneration(session)
+	if p.GetUserOpenTransactionCount() != 1 || len(p.preparedStatements) != 1 {
+		t.Fatal("same generation: connection state must be kept")
+	}
+
+	session.DB.mu.Lock()
+	session.DB.generation++
+	session.DB.mu.Unlock()
+	p.syncBackendGeneration(session)
+	if got := p.GetUserOpenTransactionCount(); got != 0 {
+		t.Errorf("open BEGIN count after reconnect = %d, want 0", got)
+	}
+	if got := p.ReadyForQueryTxStatus(); got != 'I' {
+		t.Errorf("ReadyForQuery status after reconnect = %c, want I", got)
+	}
+	if len(p.preparedStatements) != 0 {
+		t.Errorf("prepared statements after reconnect = %v, want none", p.preparedStatements)
+	}
+}
+
+func TestRecoverBackend_DroppedConnectionResetsState(t *testing.T) {
+	d, b := newWireSessionDB(t, 0)
+	ctx := context.Background()
+	dials, lockFreeWhileDialing := 0, true
+	d.dial = func(ctx context.Context) (*pgx.Conn, error) {
+		dials++
+		if d.mu.TryLock() {
+			d.mu.Unlock()
+		} else {
+			lockFreeWhileDialing = false
+		}
+		if dials == 1 {
+			return nil, errors.New("connection refused")
+		}
+		return b.connect(ctx, d.notices)
+	}
+	oldRead, err := b.connect(ctx, &backendNotices{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.readConn = &readConnection{conn: oldRead, dial: func(ctx context.Context) (*pgx.Conn, error) {
+		return b.connect(ctx, &backendNotices{})
+	}}
+	t.Cleanup(func() { d.readConn.close(ctx) })
+
+	if err := d.ClaimOpenTransaction(7, "client"); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	d.setSavepointLevelLocked(2)
+	d.namedSavepoints = []namedSavepoint{{name: "before", level: 1}}
+	d.mu.Unlock()
+	d.SetPreparedStatement(7, "s1", "SELECT 1")
+	d.prepared.byConn = map[ConnectionID]int{7: 1}
+
+	// The backend drops the connection: the next statement fails with an EOF and the ping fails too.
+	oldConn := d.conn
+	oldConn.PgConn().Conn().Close()
+	reconnected, err := d.recoverBackend(errors.New("unexpected EOF"))
+	if err != nil || !reconnected {
+		t.Fatalf("recoverBackend = %v, %v; want a reconnect", reconnected, err)
+	}
+	if dials != 2 || !lockFreeWhileDialing {
+		t.Errorf("dials = %d, lock free while dialing = %v; want a retry without holding d.mu", dials, lockFreeWhileDialing)
+	}
+
+	if d.conn == oldConn || d.BackendGeneration() != 1 {
+		t.Errorf("backend connection not replaced (generation %d)", d.BackendGeneration())
+	}
+	if !d.HasActiveTransaction() {
+		t.Error("no base transaction on the new backend connection")
+	}
+	if got := d.GetSavepointLevel(); got != 0 {
+		t.Errorf("SavepointLevel after reconnect = %d, want 0", got)
+	}
+	if d.HasOpenUserTransaction() || len(d.namedSavepoints) != 0 {
+		t.Error("open transaction claim or named savepoints survived the reconnect")
+	}
+	if _, ok := d.ResolveBackendStatement(7, "s1"); ok || d.PreparedStatementCount() != 0 {
+		t.Error("prepared statements survived the reconnect")
+	}
+	if !d.readConn.available() || d.readConn.conn == oldRead || !oldRead.IsClosed() {
+		t.Error("read connection not reopened after the reconnect")
+	}
+	notices := d.notices.drain()
+	if len(notices) != 1 || notices[0].Severity != "WARNING" {
+		t.Errorf("notices after reconnect = %v, want one WARNING", notices)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Backend routes (postgres.routes).
+//
+// By default every session connects to the configured postgres.* database. A route sends the sessions
+// of test IDs that start with its prefix to another database, or another server, given by a DSN: with
+// "app1:" → postgres://u:p@db/app1, test ID "app1:checkout" runs against app1. The longest matching
+// prefix wins; a test ID no route matches uses the default database. Sessions stay keyed by the whole
+// test ID, prefix included, so the same name under two prefixes gets two sessions on their two backends.
+// The warm pool, the startup check and ServerStatus only serve the default database; the lock watchdog
+// polls every backend that has sessions.
+
+// BackendTarget is a real PostgreSQL database sessions connect to.
+type BackendTarget struct {
+	Host     string
+	Port     int
+	Database string
+	User     string
+	Password string
+}
+
+func (t BackendTarget) String() string {
+	return fmt.Sprintf("%s:%d/%s", t.Host, t.Port, t.Database)
+}
+
+// BackendRoute sends the sessions of test IDs starting with Prefix to Target.
+type BackendRoute struct {
+	Prefix string
+	Target BackendTarget
+}
+
+// ParseBackendRoutes turns postgres.routes (test ID prefix → DSN, URL or key=value form) into routes,
+// longest prefix first. Only the DSN's host, port, database, user and password are used: the session
+// connection options (sslmode, application_name, timeouts) are the proxy's own, as for the default backend.
+func ParseBackendRoutes(routes map[string]string) ([]BackendRoute, error) {
+	list := make([]BackendRoute, 0, len(routes))
+	for prefix, dsn := range routes {
+		if prefix == "" {
+			return nil, fmt.Errorf("postgres.routes: empty test ID prefix")
+		}
+		target, err := parseBackendDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("postgres.routes[%q]: %w", prefix, err)
+		}
+		list = append(list, BackendRoute{Prefix: prefix, Target: target})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if len(list[i].Prefix) != len(list[j].Prefix) {
+			return len(list[i].Prefix) > len(list[j].Prefix)
+		}
+		return list[i].Prefix < list[j].Prefix
+	})
+	return list, nil
+}
+
+// parseBackendDSN extracts the target of a DSN; parts the DSN leaves out get libpq's defaults (PG* variables,
+// port 5432, the OS user, a database named after the user).
+func parseBackendDSN(dsn string) (BackendTarget, error) {
+	if strings.TrimSpace(dsn) == "" {
+		return BackendTarget{}, fmt.Errorf("empty DSN")
+	}
+	cfg, err := pgconn.ParseConfig(dsn)
+	if err != nil {
+		return BackendTarget{}, err
+	}
+	if strings.HasPrefix(cfg.Host, "/") {
+		return BackendTarget{}, fmt.Errorf("unix socket host %s is not supported", cfg.Host)
+	}
+	return BackendTarget{Host: cfg.Host, Port: int(cfg.Port), Database: cfg.Database, User: cfg.User, Password: cfg.Password}, nil
+}
+
+// defaultBackend is the postgres.* database.
+func (p *PgRollback) defaultBackend() BackendTarget {
+	return BackendTarget{Host: p.PostgresHost, Port: p.PostgresPort, Database: p.PostgresDB, User: p.PostgresUser, Password: p.PostgresPass}
+}
+
+// backendFor returns the database the session of testID connects to; routed is false for the default one.
+func (p *PgRollback) backendFor(testID string) (target BackendTarget, routed bool) {
+	for _, r := range p.Routes {
+		if strings.HasPrefix(testID, r.Prefix) {
+			return r.Target, true
+		}
+	}
+	return p.defaultBackend(), false
+}
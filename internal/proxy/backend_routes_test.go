@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBackendRoutes_LongestPrefixFirst(t *testing.T) {
+	routes, err := ParseBackendRoutes(map[string]string{
+		"app1:":     "postgres://u1:p1@db1:5433/app1",
+		"app1:slow": "host=db2 port=5434 dbname=slow user=u2 password=p2",
+	})
+	if err != nil {
+		t.Fatalf("ParseBackendRoutes: %v", err)
+	}
+	if len(routes) != 2 || routes[0].Prefix != "app1:slow" || routes[1].Prefix != "app1:" {
+		t.Fatalf("routes = %+v, want app1:slow before app1:", routes)
+	}
+	want := BackendTarget{Host: "db2", Port: 5434, Database: "slow", User: "u2", Password: "p2"}
+	if routes[0].Target != want {
+		t.Errorf("key=value target = %+v, want %+v", routes[0].Target, want)
+	}
+	want = BackendTarget{Host: "db1", Port: 5433, Database: "app1", User: "u1", Password: "p1"}
+	if routes[1].Target != want {
+		t.Errorf("URL target = %+v, want %+v", routes[1].Target, want)
+	}
+}
+
+func TestParseBackendRoutes_Invalid(t *testing.T) {
+	for name, routes := range map[string]map[string]string{
+		"empty prefix": {"": "postgres://u@db/app"},
+		"empty dsn":    {"app:": " "},
+		"bad dsn":      {"app:": "postgres://u@db:notaport/app"},
+		"unix socket":  {"app:": "host=/var/run/postgresql dbname=app"},
+	} {
+		if _, err := ParseBackendRoutes(routes); err == nil {
+			t.Errorf("%s: ParseBackendRoutes succeeded", name)
+		}
+	}
+}
+
+func TestBackendFor_FallsBackToDefault(t *testing.T) {
+	pgr := NewPgRollback("default-db", 5432, "main", "u", "p", time.Minute, time.Hour, 0)
+	routes, err := ParseBackendRoutes(map[string]string{"app1:": "postgres://u1:p1@db1/app1", "app1:slow": "postgres://u2:p2@db2/slow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgr.Routes = routes
+	tests := []struct {
+		testID string
+		db     string
+		routed bool
+	}{
+		{"app1:checkout", "app1", true},
+		{"app1:slow_report", "slow", true},
+		{"app2:checkout", "main", false},
+		{"checkout", "main", false},
+	}
+	for _, tt := range tests {
+		target, routed := pgr.backendFor(tt.testID)
+		if target.Database != tt.db || routed != tt.routed {
+			t.Errorf("backendFor(%q) = %s, %v; want database %s, %v", tt.testID, target, routed, tt.db, tt.routed)
+		}
+	}
+	if target, _ := pgr.backendFor("other"); target != pgr.defaultBackend() || target.Host != "default-db" {
+		t.Errorf("default target = %+v", target)
+	}
+}
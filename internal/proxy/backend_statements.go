@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Every client connection of a test ID shares one backend connection, so two clients preparing the same
+// statement name (PDO's pdo_stmt_00000001, pgx's stmtcache_*) would collide on the backend. The session
+// therefore gives each named statement of each connection its own backend name, pgrb_<connID>_<seq>.
+// The sequence is per session, so a name is never reused even when a connection ID is (IDs are pointer
+// values), and the length stays well under NAMEDATALEN whatever the client name is.
+//
+// The unnamed statement gets a backend name too: PostgreSQL drops its unnamed statement on every simple
+// Query (the proxy's own SAVEPOINT guards run as such) and on the next unnamed Parse of any connection
+// of the session. Its backend name is deallocated when the next unnamed Parse of the connection replaces it.
+// Only statements actually prepared on the backend are registered: multi-statement Parses (run as a
+// batch on Execute) never reach it.
+
+// backendStatement is one client statement as prepared on the backend.
+type backendStatement struct {
+	name string // backend statement name
+	sql  string // query as prepared (after interception)
+}
+
+// backendStatementNames maps (connection, client name) to backend statements. Own mutex so lookups
+// do not need d.mu; callers that prepare or deallocate on the backend hold LockRun around the I/O.
+type backendStatementNames struct {
+	mu     sync.Mutex
+	seq    uint64
+	byConn map[ConnectionID]map[string]backendStatement
+}
+
+// SetPreparedStatement allocates a new backend name for clientName on connection connID and records
+// sql under it, replacing any earlier statement of that name (the caller deallocates the old backend
+// name first; see ResolveBackendStatement).
+func (d *realSessionDB) SetPreparedStatement(connID ConnectionID, clientName, sql string) string {
+	n := &d.backendStatements
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.seq++
+	stmt := backendStatement{name: fmt.Sprintf("pgrb_%d_%d", connID, n.seq), sql: sql}
+	if n.byConn == nil {
+		n.byConn = make(map[ConnectionID]map[string]backendStatement)
+	}
+	if n.byConn[connID] == nil {
+		n.byConn[connID] = make(map[string]backendStatement)
+	}
+	n.byConn[connID][clientName] = stmt
+	return stmt.name
+}
+
+// ResolveBackendStatement returns the backend statement registered for clientName on connection connID.
+// ok is false when the connection has not prepared that name on the backend (or d is nil).
+func (d *realSessionDB) ResolveBackendStatement(connID ConnectionID, clientName string) (stmt backendStatement, ok bool) {
+	if d == nil {
+		return backendStatement{}, false
+	}
+	n := &d.backendStatements
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	stmt, ok = n.byConn[connID][clientName]
+	return stmt, ok
+}
+
+// forgetPreparedStatement drops clientName of connection connID and returns its backend name, so the
+// caller can DEALLOCATE it; ok is false when it was not prepared on the backend.
+func (d *realSessionDB) forgetPreparedStatement(connID ConnectionID, clientName string) (backendName string, ok bool) {
+	if d == nil {
+		return "", false
+	}
+	n := &d.backendStatements
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	stmt, ok := n.byConn[connID][clientName]
+	if !ok {
+		return "", false
+	}
+	delete(n.byConn[connID], clientName)
+	if len(n.byConn[connID]) == 0 {
+		delete(n.byConn, connID)
+	}
+	return stmt.name, true
+}
+
+// deallocatePreparedStatementLocked forgets clientName of connection connID and deallocates it on the
+// backend, if it was prepared there. Caller must hold d.mu (LockRun).
+func (d *realSessionDB) deallocatePreparedStatementLocked(ctx context.Context, connID ConnectionID, clientName string) error {
+	backendName, ok := d.forgetPreparedStatement(connID, clientName)
+	if !ok {
+		return nil
+	}
+	pgConn := d.PgConnLocked()
+	if pgConn == nil {
+		return nil
+	}
+	return pgConn.Deallocate(ctx, backendName)
+}
+
+// DeallocateConnStatements forgets every statement connection connID prepared and deallocates them on
+// the backend, leaving other connections' statements alone. Called when the connection goes away.
+func (d *realSessionDB) DeallocateConnStatements(ctx context.Context, connID ConnectionID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deallocateConnStatementsLocked(ctx, connID)
+}
+
+// deallocateConnStatementsLocked is DeallocateConnStatements for a caller holding d.mu (LockRun).
+func (d *realSessionDB) deallocateConnStatementsLocked(ctx context.Context, connID ConnectionID) {
+	n := &d.backendStatements
+	n.mu.Lock()
+	stmts := n.byConn[connID]
+	delete(n.byConn, connID)
+	n.mu.Unlock()
+
+	pgConn := d.PgConnLocked()
+	if pgConn == nil {
+		return
+	}
+	for _, stmt := range stmts {
+		if err := pgConn.Deallocate(ctx, stmt.name); err != nil {
+			log.Printf("[PROXY] Deallocate of %s on disconnect failed: %v", stmt.name, err)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBackendStatements_AllocateAndResolve(t *testing.T) {
+	d := &realSessionDB{}
+	name := d.SetPreparedStatement(7, "pdo_stmt_00000001", "SELECT 1")
+	if !strings.HasPrefix(name, "pgrb_7_") {
+		t.Fatalf("backend name = %q, want pgrb_7_<seq>", name)
+	}
+	stmt, ok := d.ResolveBackendStatement(7, "pdo_stmt_00000001")
+	if !ok || stmt.name != name || stmt.sql != "SELECT 1" {
+		t.Fatalf("resolve = %+v, %v; want %q for SELECT 1", stmt, ok, name)
+	}
+	if _, ok := d.ResolveBackendStatement(7, "other"); ok {
+		t.Error("unknown client name resolved")
+	}
+	if got := d.SetPreparedStatement(7, "", "SELECT 0"); !strings.HasPrefix(got, "pgrb_7_") {
+		t.Errorf("unnamed statement got backend name %q, want pgrb_7_<seq>", got)
+	}
+
+	// Re-Parse of the same name gets a fresh backend name.
+	again := d.SetPreparedStatement(7, "pdo_stmt_00000001", "SELECT 2")
+	if again == name {
+		t.Errorf("re-prepared statement reused backend name %q", name)
+	}
+	if stmt, _ := d.ResolveBackendStatement(7, "pdo_stmt_00000001"); stmt.name != again || stmt.sql != "SELECT 2" {
+		t.Errorf("resolve after re-prepare = %+v, want %q", stmt, again)
+	}
+}
+
+func TestBackendStatements_SameNameOnTwoConnections(t *testing.T) {
+	d := &realSessionDB{}
+	a := d.SetPreparedStatement(1, "s", "SELECT 1")
+	b := d.SetPreparedStatement(2, "s", "SELECT 2")
+	if a == b {
+		t.Fatalf("connections 1 and 2 share backend name %q", a)
+	}
+	if name, ok := d.forgetPreparedStatement(1, "s"); !ok || name != a {
+		t.Fatalf("forget on connection 1 = %q, %v; want %q", name, ok, a)
+	}
+	if stmt, ok := d.ResolveBackendStatement(2, "s"); !ok || stmt.name != b {
+		t.Errorf("connection 2's statement affected by connection 1: %+v, %v", stmt, ok)
+	}
+}
+
+func TestBackendStatements_DeallocateConnStatements(t *testing.T) {
+	d := &realSessionDB{}
+	d.SetPreparedStatement(1, "a", "SELECT 1")
+	d.SetPreparedStatement(1, "b", "SELECT 2")
+	kept := d.SetPreparedStatement(2, "a", "SELECT 3")
+
+	d.DeallocateConnStatements(context.Background(), 1)
+	for _, name := range []string{"a", "b"} {
+		if _, ok := d.ResolveBackendStatement(1, name); ok {
+			t.Errorf("connection 1 statement %q still registered", name)
+		}
+	}
+	if stmt, ok := d.ResolveBackendStatement(2, "a"); !ok || stmt.name != kept {
+		t.Errorf("connection 2 statement lost: %+v, %v", stmt, ok)
+	}
+
+	// A connection ID reused after the first connection went away never gets an old backend name.
+	if again := d.SetPreparedStatement(1, "a", "SELECT 1"); again == kept || strings.HasSuffix(again, "_1") {
+		t.Errorf("reused connection ID got backend name %q already handed out", again)
+	}
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// newBufferedProxyConnection returns a proxyConnection whose backend writes into out, so tests can
+// decode what would be sent to the client with a pgproto3.Frontend.
+func newBufferedProxyConnection(out *bytes.Buffer) *proxyConnection {
+	return &proxyConnection{
+		backend:                  pgproto3.NewBackend(bytes.NewReader(nil), out),
+		preparedStatements:       make(map[string]string),
+		statementDescs:           make(map[string]*pgconn.StatementDescription),
+		portalToStatement:        make(map[string]string),
+		portalParams:             make(map[string][][]byte),
+		portalFormatCodes:        make(map[string][]int16),
+		portalResultFormats:      make(map[string][]int16),
+		multiStatementStatements: make(map[string]struct{}),
+	}
+}
+
+func receiveOne(t *testing.T, out *bytes.Buffer) pgproto3.BackendMessage {
+	t.Helper()
+	frontend := pgproto3.NewFrontend(out, nil)
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	return msg
+}
+
+func TestHandleMessageBind_StoresResultFormats(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.SetPreparedStatement("s1", "SELECT $1::int")
+	p.handleMessageBind(&pgproto3.Bind{
+		DestinationPortal:    "p1",
+		PreparedStatement:    "s1",
+		ParameterFormatCodes: []int16{1},
+		Parameters:           [][]byte{{0, 0, 0, 7}},
+		ResultFormatCodes:    []int16{1},
+	})
+	if _, ok := receiveOne(t, &out).(*pgproto3.BindComplete); !ok {
+		t.Fatal("expected BindComplete")
+	}
+	if got := p.PortalResultFormats("p1"); len(got) != 1 || got[0] != 1 {
+		t.Errorf("PortalResultFormats = %v, want [1]", got)
+	}
+}
+
+func TestHandleMessageBind_RejectsUnknownFormatCode(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.SetPreparedStatement("s1", "SELECT 1")
+	p.handleMessageBind(&pgproto3.Bind{PreparedStatement: "s1", ResultFormatCodes: []int16{2}})
+	if _, ok := receiveOne(t, &out).(*pgproto3.ErrorResponse); !ok {
+		t.Fatal("expected ErrorResponse for format code 2")
+	}
+	if p.extendedQueryPendingError == nil {
+		t.Error("error must be pending until Sync")
+	}
+}
+
+func TestHandleMessageBind_MultiStatementRejectsBinaryAndMixedResults(t *testing.T) {
+	for _, formats := range [][]int16{{1}, {0, 1}} {
+		var out bytes.Buffer
+		p := newBufferedProxyConnection(&out)
+		p.SetPreparedStatement("m1", "SELECT 1; SELECT 2")
+		p.SetMultiStatement("m1")
+		p.handleMessageBind(&pgproto3.Bind{PreparedStatement: "m1", ResultFormatCodes: formats})
+		if _, ok := receiveOne(t, &out).(*pgproto3.ErrorResponse); !ok {
+			t.Errorf("formats %v: expected ErrorResponse instead of silently sending text", formats)
+		}
+	}
+}
+
+func TestHandleMessageBind_MultiStatementRejectsParameters(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.SetPreparedStatement("m1", "UPDATE t SET a = $1; SELECT 1")
+	p.SetMultiStatement("m1")
+	p.handleMessageBind(&pgproto3.Bind{PreparedStatement: "m1", Parameters: [][]byte{[]byte("x")}})
+	if _, ok := receiveOne(t, &out).(*pgproto3.ErrorResponse); !ok {
+		t.Fatal("expected ErrorResponse instead of dropping bound parameters")
+	}
+	if _, ok := p.portalToStatement[""]; ok {
+		t.Error("rejected Bind must not create a portal")
+	}
+}
@@ -0,0 +1,197 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// cancelRequestTimeout bounds the CancelRequest the proxy sends to the real PostgreSQL.
+const cancelRequestTimeout = 5 * time.Second
+
+// cancelKeyRegistry maps the BackendKeyData handed to each client connection back to that connection.
+// A CancelRequest arrives on a new TCP connection carrying only (process ID, secret key), so this is
+// how it finds the statement to cancel. The zero value is ready to use.
+type cancelKeyRegistry struct {
+	mu    sync.Mutex
+	byPID map[uint32]cancelKeyEntry
+}
+
+type cancelKeyEntry struct {
+	secretKey uint32
+	conn      *proxyConnection
+	testID    string
+}
+
+// register generates a (process ID, secret key) pair not used by any other open connection and binds it to p.
+func (r *cancelKeyRegistry) register(p *proxyConnection, testID string) pgproto3.BackendKeyData {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.byPID == nil {
+		r.byPID = make(map[uint32]cancelKeyEntry)
+	}
+	for {
+		// Positive int32 like a real backend PID; some clients store it signed.
+		pid := randomUint32() & 0x7fffffff
+		if pid == 0 {
+			continue
+		}
+		if _, taken := r.byPID[pid]; taken {
+			continue
+		}
+		key := pgproto3.BackendKeyData{ProcessID: pid, SecretKey: randomUint32()}
+		r.byPID[pid] = cancelKeyEntry{secretKey: key.SecretKey, conn: p, testID: testID}
+		return key
+	}
+}
+
+// unregister frees the key when its connection ends.
+func (r *cancelKeyRegistry) unregister(key pgproto3.BackendKeyData) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if e, ok := r.byPID[key.ProcessID]; ok && e.secretKey == key.SecretKey {
+		delete(r.byPID, key.ProcessID)
+	}
+}
+
+// lookup returns the connection owning (processID, secretKey); ok is false for unknown or wrong keys.
+func (r *cancelKeyRegistry) lookup(processID, secretKey uint32) (conn *proxyConnection, testID string, ok bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e, found := r.byPID[processID]
+	if !found || e.secretKey != secretKey {
+		return nil, "", false
+	}
+	return e.conn, e.testID, true
+}
+
+func randomUint32() uint32 {
+	var b [4]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// crypto/rand does not fail on supported platforms; a time-based value still keeps keys distinct.
+		return uint32(time.Now().UnixNano())
+	}
+	return binary.BigEndian.Uint32(b[:])
+}
+
+// handleCancelRequestFrame runs after we read length==16: it is a CancelRequest when the following code
+// says so, otherwise a (very short) StartupMessage whose first 8 bytes are replayed.
+func (s *Server) handleCancelRequestFrame(clientConn net.Conn, length int32) {
+	code, err := ReadSpecialRequestCode(clientConn)
+	if err != nil {
+		log.Printf("Error reading special request code: %v", err)
+		return
+	}
+	if !IsPostgresCancelRequestCode(code) {
+		s.processStartupWithReplayedSpecialFrame(clientConn, length, code)
+		return
+	}
+	s.handleCancelRequest(clientConn)
+}
+
+// handleCancelRequest reads the process ID and secret key of a CancelRequest and cancels the statement the
+// matching client connection is running. As in PostgreSQL nothing is answered: the caller closes the connection.
+func (s *Server) handleCancelRequest(clientConn net.Conn) {
+	var body [8]byte
+	if _, err := io.ReadFull(clientConn, body[:]); err != nil {
+		log.Printf("Error reading cancel request: %v", err)
+		return
+	}
+	processID := binary.BigEndian.Uint32(body[0:4])
+	secretKey := binary.BigEndian.Uint32(body[4:8])
+	p, testID, ok := s.cancelKeys.lookup(processID, secretKey)
+	if !ok {
+		logIfVerbose("[SERVER] CancelRequest with unknown key (pid=%d) ignored", processID)
+		return
+	}
+	p.cancelRunningStatement(s.PgRollback.GetSession(testID))
+}
+
+// cancelRunningStatement asks the real PostgreSQL to cancel the statement this connection is running.
+// The backend connection is shared by every client of the test ID, so the cancel is only sent while
+// this connection is the only one with a statement in progress; otherwise it could hit another
+// client's query. Like PostgreSQL, a cancel for an idle connection does nothing.
+func (p *proxyConnection) cancelRunningStatement(session *TestSession) {
+	if session == nil || session.DB == nil {
+		return
+	}
+	db := session.DB
+	if !db.running.onlyRunning(p.connectionID()) {
+		logIfVerbose("[PROXY] CancelRequest ignored: connection has no statement running alone on the backend")
+		return
+	}
+	cancelConn := db.cancelConn.Load()
+	if cancelConn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cancelRequestTimeout)
+	defer cancel()
+	if err := cancelConn.CancelRequest(ctx); err != nil {
+		log.Printf("[PROXY] CancelRequest to backend failed: %v", err)
+	}
+}
+
+// beginStatement marks a statement of this connection as running on the session's backend (see
+// runningStatements) and, until the returned end is called, cancels it with CancelRequest if the client
+// goes away: a failed write, the connection closed by the proxy, or EOF from the client noticed by the
+// background read. Without this a long query keeps running to completion for nobody.
+func (p *proxyConnection) beginStatement(session *TestSession) (end func()) {
+	endRunning := session.DB.running.begin(p.connectionID())
+	conn := watchedClientConnOf(p.clientConn)
+	if conn == nil {
+		return endRunning
+	}
+	stopRead := conn.watchWhileBusy()
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-conn.gone():
+			logIfVerbose("[PROXY] client connection lost during a statement; cancelling it on the backend")
+			p.cancelRunningStatement(session)
+		case <-stop:
+		}
+	}()
+	return func() {
+		close(stop)
+		stopRead()
+		endRunning()
+	}
+}
+
+// runningStatements tracks which client connections of a session are in the middle of a statement.
+type runningStatements struct {
+	mu    sync.Mutex
+	conns map[ConnectionID]int
+}
+
+// begin marks a statement of connection id as in progress; call the returned func when it is done.
+func (r *runningStatements) begin(id ConnectionID) (end func()) {
+	r.mu.Lock()
+	if r.conns == nil {
+		r.conns = make(map[ConnectionID]int)
+	}
+	r.conns[id]++
+	r.mu.Unlock()
+	return func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.conns[id]--; r.conns[id] <= 0 {
+			delete(r.conns, id)
+		}
+	}
+}
+
+// onlyRunning reports whether id has a statement in progress and no other connection does.
+func (r *runningStatements) onlyRunning(id ConnectionID) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, running := r.conns[id]
+	return running && len(r.conns) == 1
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestCancelKeyRegistry_UniqueKeysAndCleanup(t *testing.T) {
+	var r cancelKeyRegistry
+	a, b := &proxyConnection{}, &proxyConnection{}
+	keyA := r.register(a, "t1")
+	keyB := r.register(b, "t1")
+	if keyA.ProcessID == 0 || keyA.ProcessID == keyB.ProcessID {
+		t.Fatalf("keys must have distinct non-zero process IDs: %+v %+v", keyA, keyB)
+	}
+	if keyA.ProcessID > 0x7fffffff {
+		t.Errorf("process ID %d does not fit a signed int32", keyA.ProcessID)
+	}
+	if conn, testID, ok := r.lookup(keyA.ProcessID, keyA.SecretKey); !ok || conn != a || testID != "t1" {
+		t.Errorf("lookup(keyA) = %p, %q, %v; want connection a", conn, testID, ok)
+	}
+	if _, _, ok := r.lookup(keyA.ProcessID, keyA.SecretKey+1); ok {
+		t.Error("lookup with a wrong secret key must fail")
+	}
+	r.unregister(keyA)
+	if _, _, ok := r.lookup(keyA.ProcessID, keyA.SecretKey); ok {
+		t.Error("key still registered after unregister")
+	}
+	if _, _, ok := r.lookup(keyB.ProcessID, keyB.SecretKey); !ok {
+		t.Error("unregistering keyA removed keyB")
+	}
+}
+
+func TestRunningStatements_OnlyRunning(t *testing.T) {
+	var r runningStatements
+	if r.onlyRunning(1) {
+		t.Fatal("idle connection reported as running")
+	}
+	end1 := r.begin(1)
+	if !r.onlyRunning(1) {
+		t.Fatal("connection 1 should be the only one running")
+	}
+	end2 := r.begin(2)
+	if r.onlyRunning(1) || r.onlyRunning(2) {
+		t.Error("with two connections running, neither may be cancelled on the shared backend")
+	}
+	end2()
+	if !r.onlyRunning(1) {
+		t.Error("connection 1 should be alone again")
+	}
+	end1()
+	if r.onlyRunning(1) {
+		t.Error("connection 1 still running after end")
+	}
+}
+
+func TestSendInitialProtocolMessages_ConnectionBackendKeyData(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)}
+	p.cancelKey = p.server.cancelKeys.register(p, "t1")
+	if err := p.sendInitialProtocolMessages(""); err != nil {
+		t.Fatal(err)
+	}
+	frontend := pgproto3.NewFrontend(&out, nil)
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		if key, ok := msg.(*pgproto3.BackendKeyData); ok {
+			if *key != p.cancelKey {
+				t.Errorf("BackendKeyData = %+v, want the connection's key %+v", *key, p.cancelKey)
+			}
+			return
+		}
+		if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+			t.Fatal("no BackendKeyData before ReadyForQuery")
+		}
+	}
+}
+
+func writeCancelRequest(t *testing.T, conn net.Conn, key pgproto3.BackendKeyData) {
+	t.Helper()
+	req := make([]byte, 16)
+	binary.BigEndian.PutUint32(req[0:4], 16)
+	binary.BigEndian.PutUint32(req[4:8], PostgresCancelRequestCode)
+	binary.BigEndian.PutUint32(req[8:12], key.ProcessID)
+	binary.BigEndian.PutUint32(req[12:16], key.SecretKey)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatalf("write CancelRequest: %v", err)
+	}
+}
+
+func TestServer_CancelRequestClosesWithoutReply(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	s := &Server{activeConns: make(map[net.Conn]struct{}), PgRollback: pgr}
+	p := &proxyConnection{server: s}
+	key := s.cancelKeys.register(p, "t1")
+
+	for _, k := range []pgproto3.BackendKeyData{key, {ProcessID: key.ProcessID, SecretKey: key.SecretKey + 1}} {
+		conn := startPipeConnection(t, s)
+		writeCancelRequest(t, conn, k)
+		if n, err := conn.Read(make([]byte, 1)); n != 0 || !errors.Is(err, io.EOF) {
+			t.Errorf("after CancelRequest read = %d, %v; want the proxy to close without a reply", n, err)
+		}
+	}
+}
+
+func TestCancelRunningStatement_InterruptsBackend(t *testing.T) {
+	session := newGuardTestSession(t, "cancel_running")
+	p := &proxyConnection{}
+
+	end := session.DB.running.begin(p.connectionID())
+	done := make(chan error, 1)
+	go func() {
+		defer end()
+		_, err := session.DB.SafeExec(context.Background(), "SELECT pg_sleep(30)")
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	p.cancelRunningStatement(session)
+
+	select {
+	case err := <-done:
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "57014" {
+			t.Fatalf("err = %v, want query_canceled (57014)", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("statement was not cancelled")
+	}
+	if _, err := session.DB.SafeExec(context.Background(), "SELECT 1"); err != nil {
+		t.Fatalf("session unusable after cancel: %v", err)
+	}
+}
+
+func TestBeginStatement_CancelsWhenClientHangsUp(t *testing.T) {
+	session := newGuardTestSession(t, "cancel_hangup")
+	server, client := net.Pipe()
+	defer server.Close()
+	p := &proxyConnection{clientConn: newWatchedClientConn(server, 0, 0)}
+
+	done := make(chan error, 1)
+	go func() {
+		defer p.beginStatement(session)()
+		_, err := session.DB.SafeExec(context.Background(), "SELECT pg_sleep(30)")
+		done <- err
+	}()
+	time.Sleep(200 * time.Millisecond)
+	client.Close()
+
+	select {
+	case err := <-done:
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "57014" {
+			t.Fatalf("err = %v, want query_canceled (57014)", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("statement kept running after the client hung up")
+	}
+}
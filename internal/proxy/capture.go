@@ -0,0 +1,247 @@
+package proxy
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Protocol capture (proxy.capture_dir).
+//
+// To reproduce a client-specific bug from real traffic, the proxy can write everything a client
+// connection exchanges after startup to a file: each message the client sends ('F') and each message
+// the proxy answers ('B'), as the wire frame with the time it was seen. Client messages are recorded in
+// RunMessageLoop as they are received; the proxy's output is recorded by captureConn, under the
+// pgproto3.Backend, so every write reaches the file whatever sent it. "pgrollback replay <file>" feeds
+// the client frames back at a proxy (ReplayCapture).
+//
+// File layout: the line captureMagic, one JSON line (captureHeader), then records of
+// direction (1 byte), Unix time in nanoseconds (int64, big endian) and the frame (type byte, int32
+// length, body).
+
+const captureMagic = "PGROLLBACK-CAPTURE 1"
+
+// Directions of a capture record.
+const (
+	captureFromClient byte = 'F'
+	captureFromProxy  byte = 'B'
+)
+
+// captureHeader describes the connection a capture file belongs to.
+type captureHeader struct {
+	TestID  string            `json:"test_id"`
+	Started time.Time         `json:"started"`
+	Startup map[string]string `json:"startup"` // StartupMessage parameters of the client
+}
+
+// captureRecord is one message of a capture file.
+type captureRecord struct {
+	Direction byte
+	Time      time.Time
+	Frame     []byte
+}
+
+// captureConn is the client connection seen by pgproto3.Backend when proxy.capture_dir is set. It
+// passes everything through; once RunMessageLoop starts a capture, what the proxy writes is recorded too.
+type captureConn struct {
+	net.Conn
+	startup map[string]string // StartupMessage parameters, set by processConnectionStartupMessage
+	capture atomic.Pointer[protocolCapture]
+}
+
+func (c *captureConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if pc := c.capture.Load(); pc != nil && n > 0 {
+		pc.recordProxyOutput(b[:n])
+	}
+	return n, err
+}
+
+// captureConnOf returns the captureConn of conn, or nil when capture is off.
+func captureConnOf(conn net.Conn) *captureConn {
+	c, _ := conn.(*captureConn)
+	return c
+}
+
+// wrapForCapture returns conn wrapped in a captureConn when proxy.capture_dir is set, conn otherwise.
+func (s *Server) wrapForCapture(conn net.Conn) net.Conn {
+	if s.captureDir == "" {
+		return conn
+	}
+	return &captureConn{Conn: conn}
+}
+
+// startCapture opens the capture file of a client connection of testID and starts recording its
+// output, when proxy.capture_dir is set and proxy.capture_test_id is empty or testID. Returns nil when
+// nothing is captured; a file that cannot be created is logged and the connection runs uncaptured.
+func (p *proxyConnection) startCapture(testID string) *protocolCapture {
+	cc := captureConnOf(p.clientConn)
+	if cc == nil || (p.server.captureTestID != "" && p.server.captureTestID != testID) {
+		return nil
+	}
+	now := time.Now()
+	name := fmt.Sprintf("%s-%s-%d.pgcapture", captureFileID(testID), now.Format("20060102-150405.000000"), p.cancelKey.ProcessID)
+	pc, err := createProtocolCapture(filepath.Join(p.server.captureDir, name), captureHeader{TestID: testID, Started: now, Startup: cc.startup})
+	if err != nil {
+		log.Printf("[PROXY] protocol capture disabled for testID %s: %v", testID, err)
+		return nil
+	}
+	cc.capture.Store(pc)
+	return pc
+}
+
+// stopCapture stops recording the connection's output and closes the file.
+func (p *proxyConnection) stopCapture(pc *protocolCapture) {
+	if cc := captureConnOf(p.clientConn); cc != nil {
+		cc.capture.Store(nil)
+	}
+	pc.close()
+}
+
+// captureFileID reduces testID to characters safe in a file name.
+func captureFileID(testID string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+			return r
+		}
+		return '_'
+	}, testID)
+}
+
+// protocolCapture writes one connection's capture file. Safe for concurrent use: the proxy's output may
+// be written from another goroutine (keepalive, notifications) while a client message is recorded.
+type protocolCapture struct {
+	mu      sync.Mutex
+	f       *os.File
+	pending []byte // proxy output that does not form a whole frame yet
+	failed  bool   // a write failed: stop writing, the file is incomplete
+}
+
+func createProtocolCapture(path string, header captureHeader) (*protocolCapture, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	hdr, err := json.Marshal(header)
+	if err == nil {
+		_, err = f.Write([]byte(captureMagic + "\n" + string(hdr) + "\n"))
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &protocolCapture{f: f}, nil
+}
+
+// recordClientMessage records a message received from the client.
+func (c *protocolCapture) recordClientMessage(msg pgproto3.FrontendMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeRecordLocked(captureFromClient, time.Now(), msg.Encode(nil))
+}
+
+// recordProxyOutput records bytes written to the client, one record per complete frame.
+func (c *protocolCapture) recordProxyOutput(b []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	c.pending = append(c.pending, b...)
+	for len(c.pending) >= 5 {
+		size := 1 + int(binary.BigEndian.Uint32(c.pending[1:5]))
+		if len(c.pending) < size {
+			break
+		}
+		c.writeRecordLocked(captureFromProxy, now, c.pending[:size])
+		c.pending = c.pending[size:]
+	}
+	if len(c.pending) == 0 {
+		c.pending = nil
+	}
+}
+
+func (c *protocolCapture) writeRecordLocked(direction byte, t time.Time, frame []byte) {
+	if c.failed || c.f == nil {
+		return
+	}
+	rec := make([]byte, 9, 9+len(frame))
+	rec[0] = direction
+	binary.BigEndian.PutUint64(rec[1:9], uint64(t.UnixNano()))
+	rec = append(rec, frame...)
+	if _, err := c.f.Write(rec); err != nil {
+		log.Printf("[PROXY] protocol capture %s stopped: %v", c.f.Name(), err)
+		c.failed = true
+	}
+}
+
+// close closes the file. Safe to call on nil.
+func (c *protocolCapture) close() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.f != nil {
+		c.f.Close()
+		c.f = nil
+	}
+}
+
+// readCapture parses a capture file.
+func readCapture(r io.Reader) (captureHeader, []captureRecord, error) {
+	var header captureHeader
+	br := bufio.NewReader(r)
+	magic, err := br.ReadString('\n')
+	if err != nil || strings.TrimSuffix(magic, "\n") != captureMagic {
+		return header, nil, fmt.Errorf("not a pgrollback capture file")
+	}
+	line, err := br.ReadBytes('\n')
+	if err != nil {
+		return header, nil, fmt.Errorf("capture header: %w", err)
+	}
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, nil, fmt.Errorf("capture header: %w", err)
+	}
+	var records []captureRecord
+	for {
+		var head [14]byte // direction, time, frame type and length
+		if _, err := io.ReadFull(br, head[:]); err != nil {
+			if err == io.EOF {
+				return header, records, nil
+			}
+			return header, records, fmt.Errorf("capture record %d: %w", len(records)+1, err)
+		}
+		if head[0] != captureFromClient && head[0] != captureFromProxy {
+			return header, records, fmt.Errorf("capture record %d: unknown direction %q", len(records)+1, head[0])
+		}
+		size := int(binary.BigEndian.Uint32(head[10:14]))
+		if size < 4 {
+			return header, records, fmt.Errorf("capture record %d: invalid frame length %d", len(records)+1, size)
+		}
+		frame := make([]byte, 1+size)
+		copy(frame, head[9:])
+		if _, err := io.ReadFull(br, frame[5:]); err != nil {
+			return header, records, fmt.Errorf("capture record %d: %w", len(records)+1, err)
+		}
+		records = append(records, captureRecord{
+			Direction: head[0],
+			Time:      time.Unix(0, int64(binary.BigEndian.Uint64(head[1:9]))),
+			Frame:     frame,
+		})
+	}
+}
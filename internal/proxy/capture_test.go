@@ -0,0 +1,159 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestProtocolCapture_RoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "x.pgcapture")
+	pc, err := createProtocolCapture(path, captureHeader{TestID: "t1", Started: time.Now(), Startup: map[string]string{"application_name": "pgrollback-t1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.recordClientMessage(&pgproto3.Query{String: "SELECT 1"})
+	// Proxy output split mid-frame is recorded once the frame is complete, one record per frame.
+	out := (&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(nil)
+	out = append(out, (&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(nil)...)
+	pc.recordProxyOutput(out[:3])
+	pc.recordProxyOutput(out[3:])
+	pc.close()
+	pc.close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header, records, err := readCapture(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.TestID != "t1" || header.Startup["application_name"] != "pgrollback-t1" {
+		t.Fatalf("header = %+v", header)
+	}
+	if len(records) != 3 {
+		t.Fatalf("got %d records, want 3", len(records))
+	}
+	wantDirs := []byte{captureFromClient, captureFromProxy, captureFromProxy}
+	wantTypes := []byte{'Q', 'C', 'Z'}
+	for i, rec := range records {
+		if rec.Direction != wantDirs[i] || rec.Frame[0] != wantTypes[i] {
+			t.Errorf("record %d = %c %c, want %c %c", i, rec.Direction, rec.Frame[0], wantDirs[i], wantTypes[i])
+		}
+	}
+	if got := describeFrame(records[0]); !strings.Contains(got, "SELECT 1") {
+		t.Errorf("describeFrame = %s", got)
+	}
+
+	if _, _, err := readCapture(strings.NewReader("not a capture\n")); err == nil {
+		t.Error("readCapture accepted a file without the magic line")
+	}
+}
+
+func TestCaptureFileID(t *testing.T) {
+	if got := captureFileID("Suite/test 1:a"); got != "Suite_test_1_a" {
+		t.Errorf("captureFileID = %q", got)
+	}
+}
+
+// fakeReplayProxy accepts one connection, authenticates it and answers every Query with tag; it returns
+// the queries it received once the client terminates.
+func fakeReplayProxy(t *testing.T, ln net.Listener, tag string) <-chan []string {
+	t.Helper()
+	done := make(chan []string, 1)
+	go func() {
+		var queries []string
+		defer func() { done <- queries }()
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		backend := pgproto3.NewBackend(conn, conn)
+		if _, err := backend.ReceiveStartupMessage(); err != nil {
+			return
+		}
+		backend.Send(&pgproto3.AuthenticationCleartextPassword{})
+		backend.Flush()
+		if _, err := backend.Receive(); err != nil {
+			return
+		}
+		backend.Send(&pgproto3.AuthenticationOk{})
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+		backend.Flush()
+		for {
+			msg, err := backend.Receive()
+			if err != nil {
+				return
+			}
+			switch msg := msg.(type) {
+			case *pgproto3.Query:
+				queries = append(queries, msg.String)
+				backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+				backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+				backend.Flush()
+			case *pgproto3.Terminate:
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func testCaptureFile(t *testing.T) []byte {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "c.pgcapture")
+	pc, err := createProtocolCapture(path, captureHeader{TestID: "t1", Startup: map[string]string{"user": "u", "application_name": "pgrollback-t1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc.recordClientMessage(&pgproto3.Query{String: "SELECT 1"})
+	pc.recordProxyOutput((&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")}).Encode(nil))
+	pc.recordProxyOutput((&pgproto3.ReadyForQuery{TxStatus: 'I'}).Encode(nil))
+	pc.recordClientMessage(&pgproto3.Terminate{})
+	pc.close()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestReplayCapture(t *testing.T) {
+	data := testCaptureFile(t)
+	for _, tt := range []struct {
+		tag  string
+		want int
+	}{
+		{"SELECT 1", 0},
+		{"SELECT 2", 1},
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := fakeReplayProxy(t, ln, tt.tag)
+		var out bytes.Buffer
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		differences, err := ReplayCapture(ctx, ln.Addr().String(), bytes.NewReader(data), &out)
+		cancel()
+		ln.Close()
+		if err != nil {
+			t.Fatalf("ReplayCapture: %v\n%s", err, out.String())
+		}
+		if differences != tt.want {
+			t.Errorf("tag %q: differences = %d, want %d\n%s", tt.tag, differences, tt.want, out.String())
+		}
+		if queries := <-done; len(queries) != 1 || queries[0] != "SELECT 1" {
+			t.Errorf("proxy received %q", queries)
+		}
+	}
+}
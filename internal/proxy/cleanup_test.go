@@ -0,0 +1,48 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// TestCleanupExpiredSessions_SlowCloseDoesNotBlockOtherTestIDs holds the backend lock of an expired session,
+// so its close hangs, and checks that GetOrCreateSession for another test ID still returns (here with a
+// connection error, as the backend address is unreachable) while the cleanup waits.
+func TestCleanupExpiredSessions_SlowCloseDoesNotBlockOtherTestIDs(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Second, time.Hour, 0)
+	session, _ := pgr.newFakeTestSession("slow_close")
+	session.mu.Lock()
+	session.LastActivity = time.Now().Add(-time.Minute)
+	session.mu.Unlock()
+
+	session.DB.mu.Lock()
+	cleaned := make(chan int, 1)
+	go func() {
+		n, _ := pgr.CleanupExpiredSessions()
+		cleaned <- n
+	}()
+	time.Sleep(50 * time.Millisecond) // let the teardown reach the close, which waits for DB.mu
+
+	created := make(chan error, 1)
+	go func() {
+		_, err := pgr.GetOrCreateSession("other_test")
+		created <- err
+	}()
+	select {
+	case <-created:
+	case <-time.After(5 * time.Second):
+		session.DB.mu.Unlock()
+		t.Fatal("GetOrCreateSession for another test ID blocked behind the slow close")
+	}
+	if pgr.GetSession("slow_close") != session {
+		t.Error("session left the map before its close finished")
+	}
+
+	session.DB.mu.Unlock()
+	if n := <-cleaned; n != 1 {
+		t.Errorf("CleanupExpiredSessions cleaned %d sessions, want 1", n)
+	}
+	if pgr.GetSession("slow_close") != nil {
+		t.Error("expired session still in the map after cleanup")
+	}
+}
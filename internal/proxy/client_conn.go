@@ -0,0 +1,215 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// watchedClientConn envolve cada conexão cliente (handleConnection) para duas coisas:
+//
+// Deadlines de leitura (proxy.read_timeout e proxy.idle_timeout). Durante o handshake vale o prazo
+// absoluto ConnectionTimeout. Quando o message loop começa ele chama arm, que troca esse prazo por um
+// por leitura: enquanto o proxy espera a próxima mensagem vale idleTimeout (reiniciado a cada
+// mensagem); depois que ela começa a chegar, cada Read bloqueante é limitado por readTimeout. Zero
+// desliga o respectivo limite.
+//
+// Cliente morto. Enquanto um statement roda no backend o message loop não lê o cliente, então
+// watchWhileBusy deixa uma leitura de 1 byte em segundo plano (como o net/http faz): EOF ou erro
+// significa que o cliente caiu. Esse erro, um erro de escrita ou o Close da conexão (Server.Stop,
+// teardown da sessão) fecham o canal dead; beginStatement usa isso para cancelar no backend o
+// statement em curso, em vez de deixá-lo rodar até o fim para um cliente que não vai ler o resultado.
+
+// Drenagem (Server.Shutdown). O message loop marca a conexão ociosa (enterIdle) só quando o cliente
+// recebeu ReadyForQuery e nenhuma mensagem do protocolo estendido está pendente de Sync; drain fecha
+// na hora uma conexão ociosa e faz as outras saírem do loop na próxima vez que ficarem ociosas.
+
+// Só o goroutine do message loop chama Read, arm, awaitMessage e watchWhileBusy, então armed, waiting
+// e bgDone não precisam de lock; pending e pendingErr só são escritos pela leitura em segundo plano,
+// e lidos depois de <-bgDone. idle, draining e drained são protegidos por drainMu.
+type watchedClientConn struct {
+	net.Conn
+	readTimeout time.Duration
+	idleTimeout time.Duration
+	armed       bool // false até o fim do handshake: Read não mexe no deadline
+	waiting     bool // esperando o primeiro byte da próxima mensagem
+	dead        chan struct{}
+	deadOnce    sync.Once
+	bgDone      chan struct{} // não nil enquanto há uma leitura em segundo plano
+	pending     []byte        // byte lido em segundo plano, entregue ao próximo Read
+	pendingErr  error         // erro da leitura em segundo plano, entregue ao próximo Read
+	drainMu     sync.Mutex
+	idle        bool // em ReadyForQuery esperando a próxima mensagem (enterIdle)
+	draining    bool // Server.Shutdown pediu para encerrar na próxima vez que ficar ociosa
+	drained     bool // fechada por drain enquanto ociosa
+}
+
+func newWatchedClientConn(conn net.Conn, readTimeout, idleTimeout time.Duration) *watchedClientConn {
+	return &watchedClientConn{Conn: conn, readTimeout: readTimeout, idleTimeout: idleTimeout, dead: make(chan struct{})}
+}
+
+// watchedClientConnOf returns the watchedClientConn under conn (also under a tls.Server conn or a
+// captureConn), or nil.
+func watchedClientConnOf(conn net.Conn) *watchedClientConn {
+	if cc, ok := conn.(*captureConn); ok {
+		conn = cc.Conn
+	}
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		conn = tlsConn.NetConn()
+	}
+	c, _ := conn.(*watchedClientConn)
+	return c
+}
+
+// arm drops the handshake's absolute deadline and switches to per-read deadlines.
+func (c *watchedClientConn) arm() {
+	if c == nil {
+		return
+	}
+	c.armed = true
+	_ = c.Conn.SetDeadline(time.Time{})
+}
+
+// awaitMessage is called before each backend.Receive: the next read waits for a new message.
+func (c *watchedClientConn) awaitMessage() {
+	if c != nil {
+		c.waiting = true
+	}
+}
+
+// enterIdle marks the connection idle before the message loop waits for a new message after ReadyForQuery.
+// It returns false when Server.Shutdown is draining: the loop then ends the connection instead of waiting.
+func (c *watchedClientConn) enterIdle() bool {
+	if c == nil {
+		return true
+	}
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	if c.draining {
+		return false
+	}
+	c.idle = true
+	return true
+}
+
+// leaveIdle is called once a message has been received. It returns false when drain closed the
+// connection while it was idle, so the message that raced the close is not executed.
+func (c *watchedClientConn) leaveIdle() bool {
+	if c == nil {
+		return true
+	}
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	c.idle = false
+	return !c.drained
+}
+
+// drain closes the connection at once when it is idle; otherwise the message loop finishes the current
+// statement or pipeline and ends at its next enterIdle.
+func (c *watchedClientConn) drain() {
+	c.drainMu.Lock()
+	defer c.drainMu.Unlock()
+	c.draining = true
+	if c.idle && !c.drained {
+		c.drained = true
+		_ = c.Close()
+	}
+}
+
+func (c *watchedClientConn) Read(b []byte) (int, error) {
+	c.stopBackgroundRead()
+	if len(c.pending) > 0 {
+		n := copy(b, c.pending)
+		c.pending = c.pending[n:]
+		c.waiting = false
+		return n, nil
+	}
+	if c.pendingErr != nil {
+		return 0, c.pendingErr
+	}
+	if c.armed {
+		timeout := c.readTimeout
+		if c.waiting {
+			timeout = c.idleTimeout
+		}
+		deadline := time.Time{}
+		if timeout > 0 {
+			deadline = time.Now().Add(timeout)
+		}
+		_ = c.Conn.SetReadDeadline(deadline)
+	}
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.waiting = false
+	}
+	return n, err
+}
+
+// Write marks the client dead when the write fails: the peer reset or closed the connection.
+func (c *watchedClientConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	if err != nil {
+		c.markDead()
+	}
+	return n, err
+}
+
+func (c *watchedClientConn) Close() error {
+	c.markDead()
+	return c.Conn.Close()
+}
+
+func (c *watchedClientConn) markDead() {
+	c.deadOnce.Do(func() { close(c.dead) })
+}
+
+// gone is closed once the client is known to be unreachable (write error or Close).
+func (c *watchedClientConn) gone() <-chan struct{} {
+	return c.dead
+}
+
+// watchWhileBusy starts the background read that notices the client hanging up while the message loop
+// is busy with a statement. It ends at the next Read (e.g. COPY FROM STDIN reading CopyData) or when
+// the returned stop is called; a byte that arrives meanwhile is kept for that Read.
+func (c *watchedClientConn) watchWhileBusy() (stop func()) {
+	if c.bgDone != nil || len(c.pending) > 0 || c.pendingErr != nil {
+		return func() {}
+	}
+	_ = c.Conn.SetReadDeadline(time.Time{})
+	done := make(chan struct{})
+	c.bgDone = done
+	go func() {
+		defer close(done)
+		var b [1]byte
+		n, err := c.Conn.Read(b[:])
+		if n > 0 {
+			c.pending = append(c.pending, b[0])
+			return
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return // interrompida por stopBackgroundRead
+		}
+		if err != nil {
+			c.pendingErr = err
+			c.markDead()
+		}
+	}()
+	return c.stopBackgroundRead
+}
+
+// stopBackgroundRead interrupts the background read, if any, and waits for it to finish.
+func (c *watchedClientConn) stopBackgroundRead() {
+	if c.bgDone == nil {
+		return
+	}
+	_ = c.Conn.SetReadDeadline(aLongTimeAgo)
+	<-c.bgDone
+	c.bgDone = nil
+	_ = c.Conn.SetReadDeadline(time.Time{})
+}
+
+// aLongTimeAgo is a read deadline in the past, which makes a blocked Read return at once.
+var aLongTimeAgo = time.Unix(1, 0)
@@ -0,0 +1,226 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestWatchedClientConn_IdleThenReadTimeout(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newWatchedClientConn(server, 30*time.Millisecond, time.Hour)
+	defer c.Close()
+	c.arm()
+	buf := make([]byte, 1)
+
+	// Esperando a próxima mensagem vale o idle timeout (1h): o byte chega depois do read timeout.
+	c.awaitMessage()
+	go func() {
+		time.Sleep(80 * time.Millisecond)
+		client.Write([]byte{'Q', 0})
+	}()
+	if _, err := c.Read(buf); err != nil {
+		t.Fatalf("read while idle: %v (read_timeout must not apply between messages)", err)
+	}
+	if _, err := c.Read(buf); err != nil {
+		t.Fatalf("read: %v", err)
+	}
+
+	// No meio da mensagem cada Read é limitado pelo read timeout.
+	start := time.Now()
+	_, err := c.Read(buf)
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("mid-message read err = %v, want deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("mid-message read took %v, want about 30ms", elapsed)
+	}
+}
+
+func TestWatchedClientConn_IdleTimeoutAndDisarmed(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newWatchedClientConn(server, 0, 30*time.Millisecond)
+	defer c.Close()
+
+	// Antes de arm (handshake) Read não define deadline: o byte atrasado é lido.
+	go func() {
+		time.Sleep(80 * time.Millisecond)
+		client.Write([]byte{'X'})
+	}()
+	buf := make([]byte, 1)
+	if _, err := c.Read(buf); err != nil {
+		t.Fatalf("read before arm: %v", err)
+	}
+
+	c.arm()
+	c.awaitMessage()
+	if _, err := c.Read(buf); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("idle read err = %v, want deadline exceeded", err)
+	}
+}
+
+func TestWatchedClientConnOf(t *testing.T) {
+	server, client := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	c := newWatchedClientConn(server, 0, 0)
+	if watchedClientConnOf(c) != c {
+		t.Error("plain conn: wrapper not found")
+	}
+	if watchedClientConnOf(tls.Server(c, &tls.Config{})) != c {
+		t.Error("tls conn: wrapper not found under tls.Server")
+	}
+	if watchedClientConnOf(server) != nil {
+		t.Error("unwrapped conn must return nil")
+	}
+	var none *watchedClientConn
+	none.arm()
+	none.awaitMessage()
+}
+
+func TestWatchedClientConn_NoticesHangUpWhileBusy(t *testing.T) {
+	server, client := net.Pipe()
+	c := newWatchedClientConn(server, 0, 0)
+	defer c.Close()
+	c.arm()
+
+	stop := c.watchWhileBusy()
+	client.Close()
+	select {
+	case <-c.gone():
+	case <-time.After(2 * time.Second):
+		t.Fatal("client hang-up not noticed by the background read")
+	}
+	stop()
+	if _, err := c.Read(make([]byte, 1)); !errors.Is(err, io.EOF) {
+		t.Errorf("Read after hang-up err = %v, want io.EOF", err)
+	}
+}
+
+func TestWatchedClientConn_KeepsBytesReadWhileBusy(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newWatchedClientConn(server, 0, 0)
+	defer c.Close()
+	c.arm()
+
+	// Pipelined message arriving while the statement runs: the background read keeps its first byte.
+	stop := c.watchWhileBusy()
+	go client.Write([]byte("SQ"))
+	time.Sleep(50 * time.Millisecond)
+	stop()
+	buf := make([]byte, 2)
+	if n, err := io.ReadFull(c, buf); err != nil || string(buf[:n]) != "SQ" {
+		t.Fatalf("read %q, %v; want \"SQ\"", buf[:n], err)
+	}
+
+	// A foreground Read (COPY FROM STDIN) ends the background read instead of racing it.
+	c.watchWhileBusy()
+	go client.Write([]byte("d"))
+	if n, err := c.Read(buf[:1]); err != nil || n != 1 || buf[0] != 'd' {
+		t.Fatalf("foreground read = %q, %v", buf[:n], err)
+	}
+	select {
+	case <-c.gone():
+		t.Fatal("interrupting the background read must not mark the client dead")
+	default:
+	}
+}
+
+func TestWatchedClientConn_WriteErrorAndCloseMarkDead(t *testing.T) {
+	server, client := net.Pipe()
+	c := newWatchedClientConn(server, 0, 0)
+	client.Close()
+	if _, err := c.Write([]byte("x")); err == nil {
+		t.Fatal("write to a closed peer succeeded")
+	}
+	select {
+	case <-c.gone():
+	default:
+		t.Error("write error did not mark the client dead")
+	}
+
+	server2, client2 := net.Pipe()
+	defer client2.Close()
+	c2 := newWatchedClientConn(server2, 0, 0)
+	c2.Close()
+	select {
+	case <-c2.gone():
+	default:
+		t.Error("Close did not mark the client dead")
+	}
+}
+
+func TestWatchedClientConn_DrainClosesIdleConnection(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newWatchedClientConn(server, 0, 0)
+	if !c.enterIdle() {
+		t.Fatal("enterIdle before drain = false")
+	}
+	c.drain()
+	select {
+	case <-c.gone():
+	default:
+		t.Fatal("drain did not close the idle connection")
+	}
+	if c.leaveIdle() {
+		t.Error("leaveIdle after drain closed the connection = true, want false")
+	}
+}
+
+func TestWatchedClientConn_DrainWaitsForBusyConnection(t *testing.T) {
+	server, client := net.Pipe()
+	defer client.Close()
+	c := newWatchedClientConn(server, 0, 0)
+	defer c.Close()
+	c.enterIdle()
+	if !c.leaveIdle() {
+		t.Fatal("leaveIdle without drain = false")
+	}
+	c.drain()
+	select {
+	case <-c.gone():
+		t.Fatal("drain closed a connection in the middle of a statement")
+	default:
+	}
+	if c.enterIdle() {
+		t.Error("enterIdle after drain = true, want false so the message loop ends")
+	}
+}
+
+func TestServerShutdown_ClosesConnectionsWhenContextEnds(t *testing.T) {
+	s := &Server{activeConns: make(map[net.Conn]struct{})}
+	startPipeConnection(t, s) // stays in the handshake: never idle
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.RLock()
+		n := len(s.activeConns)
+		s.mu.RUnlock()
+		if n == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("connection never became active")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Shutdown = %v, want context.DeadlineExceeded", err)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if len(s.activeConns) != 0 {
+		t.Errorf("%d connections still active after Shutdown returned", len(s.activeConns))
+	}
+}
@@ -0,0 +1,361 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pgrollback/pkg/protocol"
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// Per-connection run-time parameters.
+//
+// Every client connection of a test ID shares one backend connection, so a plain SET from one
+// client would leak into all the others. For the parameters in trackedClientGUCs the proxy keeps
+// each connection's own value and, in the same locked section as each statement of that connection,
+// re-applies its values on the backend when they differ from what was applied last. SHOW (or current_setting)
+// therefore reports the calling connection's view. SET LOCAL is kept until the connection's
+// emulated transaction (BEGIN … COMMIT/ROLLBACK) ends.
+//
+// SET ROLE and SET SESSION AUTHORIZATION are tracked the same way, so a connection that switched role
+// runs its statements as that role and the others keep the session user. The proxy's own statements
+// (savepoints, history, advisory locks) run as whichever role was applied last.
+
+// trackedClientGUCs lists the parameters tracked per client connection. session_authorization comes
+// before role: setting it resets the role, which is then applied again.
+var trackedClientGUCs = []string{"search_path", "statement_timeout", "timezone", "session_authorization", "role"}
+
+// clientGUCDefaults holds the statement that restores a parameter whose backend default differs
+// from the server default: session connections are created with statement_timeout = 0.
+var clientGUCDefaults = map[string]string{
+	"statement_timeout": "SET statement_timeout = '0'",
+}
+
+func isTrackedClientGUC(name string) bool {
+	for _, n := range trackedClientGUCs {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultGUCSQL returns the statement that puts name back to the value a fresh session connection has.
+func defaultGUCSQL(name string) string {
+	if s, ok := clientGUCDefaults[name]; ok {
+		return s
+	}
+	return "RESET " + name
+}
+
+// desiredGUCsLocked returns, for every tracked parameter, the statement that gives the backend
+// this connection's view (SET LOCAL over SET over the default). Caller must hold p.mu.
+func (p *proxyConnection) desiredGUCsLocked() map[string]string {
+	want := make(map[string]string, len(trackedClientGUCs))
+	for _, name := range trackedClientGUCs {
+		switch {
+		case p.localGUCs[name] != "":
+			want[name] = p.localGUCs[name]
+		case p.gucs[name] != "":
+			want[name] = p.gucs[name]
+		default:
+			want[name] = defaultGUCSQL(name)
+		}
+	}
+	return want
+}
+
+// recordVariableSetLocked stores the effect of a tracked SET/RESET on this connection's values.
+// It returns a function that undoes the change. Caller must hold p.mu.
+func (p *proxyConnection) recordVariableSetLocked(vs sql.VariableSet) (undo func()) {
+	prevSession, prevLocal := p.gucs[vs.Name], p.localGUCs[vs.Name]
+	value := vs.SetSQL
+	if vs.IsReset {
+		value = defaultGUCSQL(vs.Name)
+	}
+	if vs.IsLocal {
+		p.localGUCs = setOrDeleteGUC(p.localGUCs, vs.Name, value)
+	} else {
+		if vs.IsReset {
+			value = ""
+		}
+		p.gucs = setOrDeleteGUC(p.gucs, vs.Name, value)
+		// Like PostgreSQL, a session-level SET also replaces an earlier SET LOCAL in the same transaction.
+		p.localGUCs = setOrDeleteGUC(p.localGUCs, vs.Name, "")
+	}
+	return func() {
+		p.gucs = setOrDeleteGUC(p.gucs, vs.Name, prevSession)
+		p.localGUCs = setOrDeleteGUC(p.localGUCs, vs.Name, prevLocal)
+	}
+}
+
+// resetAllGUCsLocked applies RESET ALL to this connection's values. Like PostgreSQL, it leaves the role
+// and session authorization alone. Caller must hold p.mu.
+func (p *proxyConnection) resetAllGUCsLocked() {
+	p.gucs = keepRoleGUCs(p.gucs)
+	p.localGUCs = keepRoleGUCs(p.localGUCs)
+}
+
+// keepRoleGUCs returns the entries of m for the role parameters, nil when there are none.
+func keepRoleGUCs(m map[string]string) map[string]string {
+	var kept map[string]string
+	for name, stmt := range m {
+		if (sql.VariableSet{Name: name}).ChangesRole() {
+			kept = setOrDeleteGUC(kept, name, stmt)
+		}
+	}
+	return kept
+}
+
+// hasRoleSet reports whether this connection switched role or session authorization.
+func (p *proxyConnection) hasRoleSet() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(keepRoleGUCs(p.gucs)) > 0 || len(keepRoleGUCs(p.localGUCs)) > 0
+}
+
+// setOrDeleteGUC sets m[name] = value, or deletes the key when value is empty.
+func setOrDeleteGUC(m map[string]string, name, value string) map[string]string {
+	if value == "" {
+		delete(m, name)
+		return m
+	}
+	if m == nil {
+		m = make(map[string]string)
+	}
+	m[name] = value
+	return m
+}
+
+// clearLocalGUCsLocked drops this connection's SET LOCAL values; called when its emulated transaction ends. Caller must hold p.mu.
+func (p *proxyConnection) clearLocalGUCsLocked() {
+	p.localGUCs = nil
+}
+
+// handleClientVariableSet answers a single-statement SET/RESET of a tracked parameter: it records
+// the value for this connection and applies it on the backend right away, so an invalid value
+// fails now, as on PostgreSQL. handled is false for anything else. RESET ALL is not handled (it
+// goes to the backend) but drops this connection's tracked values first.
+func (p *proxyConnection) handleClientVariableSet(session *TestSession, query string, sendReadyForQuery bool) (handled bool, err error) {
+	if session == nil || session.DB == nil {
+		return false, nil
+	}
+	stmts, parseErr := sql.ParseStatements(query)
+	if parseErr != nil || len(stmts) != 1 || stmts[0].Stmt == nil {
+		return false, nil
+	}
+	vs, ok := sql.ParseVariableSet(stmts[0].Stmt)
+	if !ok {
+		return false, nil
+	}
+	p.noticeApplicationNameChange(session, stmts[0].Stmt)
+	if vs.ResetAll {
+		p.mu.Lock()
+		p.resetAllGUCsLocked()
+		p.mu.Unlock()
+		session.DB.invalidateClientGUCs()
+		return false, nil
+	}
+	if !isTrackedClientGUC(vs.Name) {
+		return false, nil
+	}
+	p.recordQuery(session.DB, query)
+	tag := vs.Tag
+
+	p.mu.Lock()
+	if vs.IsLocal && p.userOpenTransactionCount == 0 {
+		p.mu.Unlock()
+		p.backend.Send(&pgproto3.NoticeResponse{Severity: "WARNING", Code: "25P01", Message: "SET LOCAL can only be used in transaction blocks"})
+		p.completeClientVariableSet(tag, sendReadyForQuery)
+		return true, nil
+	}
+	undo := p.recordVariableSetLocked(vs)
+	want := p.desiredGUCsLocked()
+	p.mu.Unlock()
+
+	if err := session.DB.applyClientGUCs(session.Context(), want); err != nil {
+		p.mu.Lock()
+		undo()
+		p.mu.Unlock()
+		return true, err
+	}
+	p.completeClientVariableSet(tag, sendReadyForQuery)
+	return true, nil
+}
+
+func (p *proxyConnection) completeClientVariableSet(tag string, sendReadyForQuery bool) {
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+	if sendReadyForQuery {
+		p.SendReadyForQuery()
+		return
+	}
+	p.backend.Flush()
+}
+
+// recordClientVariableSets records the tracked SET/RESET statements of a multi-statement query that
+// already ran on the backend, so the next syncClientGUCsLocked keeps them for this connection and
+// restores the defaults for the others.
+func (p *proxyConnection) recordClientVariableSets(session *TestSession, query string) {
+	if session == nil || session.DB == nil {
+		return
+	}
+	stmts, err := sql.ParseStatements(query)
+	if err != nil {
+		return
+	}
+	recorded := false
+	p.mu.Lock()
+	for _, st := range stmts {
+		vs, ok := sql.ParseVariableSet(st.Stmt)
+		if !ok {
+			continue
+		}
+		p.noticeApplicationNameChange(session, st.Stmt)
+		switch {
+		case vs.ResetAll:
+			p.resetAllGUCsLocked()
+		case !isTrackedClientGUC(vs.Name):
+			continue
+		case vs.IsLocal && p.userOpenTransactionCount == 0:
+			continue
+		default:
+			p.recordVariableSetLocked(vs)
+		}
+		recorded = true
+	}
+	p.mu.Unlock()
+	if recorded {
+		session.DB.invalidateClientGUCs()
+	}
+}
+
+// clientStatement is what a client statement carries in its context (see statementContext): the
+// connection's view of the tracked parameters and, once it ran, the backend notices it raised.
+type clientStatement struct {
+	gucs    map[string]string
+	notices []*pgconn.Notice
+}
+
+// clientStatementKey is the context key of the clientStatement a statement runs with.
+type clientStatementKey struct{}
+
+// statementContext returns the session's context carrying this connection's view of the tracked
+// parameters. The session DB applies them with syncClientGUCsLocked in the same locked section as the
+// statement, so another connection of the test ID cannot run in between with this connection's values.
+func (p *proxyConnection) statementContext(session *TestSession) context.Context {
+	p.mu.Lock()
+	want := p.desiredGUCsLocked()
+	p.mu.Unlock()
+	return context.WithValue(session.Context(), clientStatementKey{}, &clientStatement{gucs: want})
+}
+
+// statementOf returns the clientStatement of ctx, nil for the proxy's own commands.
+func statementOf(ctx context.Context) *clientStatement {
+	st, _ := ctx.Value(clientStatementKey{}).(*clientStatement)
+	return st
+}
+
+// syncClientGUCsLocked re-applies the tracked parameters carried by ctx (see statementContext) before a
+// statement runs; a context without them (the proxy's own statements) changes nothing. Caller must hold d.mu.
+func (d *realSessionDB) syncClientGUCsLocked(ctx context.Context) error {
+	st := statementOf(ctx)
+	if st == nil {
+		return nil
+	}
+	return d.applyClientGUCsLocked(ctx, st.gucs)
+}
+
+// applyClientGUCs makes the backend match want (see desiredGUCsLocked), running only the statements
+// whose value differs from the last applied one. The statements run inside a savepoint guard so an
+// invalid value does not abort the base transaction.
+func (d *realSessionDB) applyClientGUCs(ctx context.Context, want map[string]string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.applyClientGUCsLocked(ctx, want)
+}
+
+// applyClientGUCsLocked is the body of applyClientGUCs. Caller must hold d.mu.
+func (d *realSessionDB) applyClientGUCsLocked(ctx context.Context, want map[string]string) error {
+	if !d.hasActiveTransactionLocked() {
+		return nil
+	}
+	want = d.withIsolationSearchPathLocked(d.withSetupGUCsLocked(want))
+	// Nothing is known about the backend: the session's schema may have been rolled back too.
+	ensureSchema := d.gucApplied == nil && d.isolationSchema != ""
+	var stmts []string
+	authorizationSet := false
+	for _, name := range trackedClientGUCs {
+		if d.gucApplied != nil && !(name == "role" && authorizationSet) {
+			applied, ok := d.gucApplied[name]
+			if !ok {
+				applied = defaultGUCSQL(name)
+			}
+			if applied == want[name] {
+				continue
+			}
+		}
+		stmts = append(stmts, want[name])
+		authorizationSet = authorizationSet || name == "session_authorization"
+	}
+	if len(stmts) == 0 {
+		return nil
+	}
+	err := d.runWithSavepointGuardLocked(ctx, "pgrollback_guc_guard", func() error {
+		if ensureSchema {
+			if err := d.ensureIsolationSchemaLocked(ctx); err != nil {
+				return err
+			}
+		}
+		_, err := d.tx.Exec(ctx, strings.Join(stmts, "; "))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	applied := make(map[string]string, len(trackedClientGUCs))
+	for _, name := range trackedClientGUCs {
+		applied[name] = want[name]
+	}
+	d.gucApplied = applied
+	return nil
+}
+
+// invalidateClientGUCs forgets what was applied last, so the next applyClientGUCs sets every tracked parameter.
+func (d *realSessionDB) invalidateClientGUCs() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.invalidateClientGUCsLocked()
+}
+
+// invalidateClientGUCsLocked is called after anything that may have rolled back a SET on the backend
+// (ROLLBACK TO SAVEPOINT, RELEASE of an outer savepoint, a new base transaction). Caller must hold d.mu.
+func (d *realSessionDB) invalidateClientGUCsLocked() {
+	d.gucApplied = nil
+}
+
+// noticeApplicationNameChange warns the client when stmt sets application_name to a value that names
+// another test ID. The test ID is chosen once, from the startup application_name, and the connection
+// stays on that session: switching would move it to another backend transaction in the middle of
+// whatever it has open. The SET itself still runs; only the routing ignores it.
+func (p *proxyConnection) noticeApplicationNameChange(session *TestSession, stmt *pg_query.Node) {
+	name, ok := sql.ApplicationNameSet(stmt)
+	if !ok {
+		return
+	}
+	testID, _ := protocol.ExtractTestID(map[string]string{"application_name": name})
+	if testID == session.TestID {
+		return
+	}
+	p.backend.Send(&pgproto3.NoticeResponse{
+		Severity: "WARNING",
+		Code:     "01000",
+		Message:  fmt.Sprintf("application_name %q would select test ID %q, but this connection stays on test ID %q", name, testID, session.TestID),
+		Detail:   "The test ID is taken from application_name when the connection starts and cannot change afterwards.",
+		Hint:     "Open a new connection with the new application_name to use another test session.",
+	})
+}
@@ -0,0 +1,368 @@
+package proxy
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestClientVariableSet_PerConnectionView(t *testing.T) {
+	session := &TestSession{DB: newTestSessionDB()}
+	var outA, outB bytes.Buffer
+	a, b := newBufferedProxyConnection(&outA), newBufferedProxyConnection(&outB)
+
+	if handled, err := a.handleClientVariableSet(session, "SET search_path TO app, public", false); !handled || err != nil {
+		t.Fatalf("handleClientVariableSet = %v, %v; want handled", handled, err)
+	}
+	if cc, ok := receiveOne(t, &outA).(*pgproto3.CommandComplete); !ok || string(cc.CommandTag) != "SET" {
+		t.Fatalf("expected CommandComplete SET, got %#v", cc)
+	}
+
+	a.mu.Lock()
+	wantA := a.desiredGUCsLocked()
+	a.mu.Unlock()
+	b.mu.Lock()
+	wantB := b.desiredGUCsLocked()
+	b.mu.Unlock()
+	if wantA["search_path"] != "SET search_path TO app, public" {
+		t.Errorf("connection A search_path = %q", wantA["search_path"])
+	}
+	if wantB["search_path"] != "RESET search_path" {
+		t.Errorf("connection B search_path = %q, want the default", wantB["search_path"])
+	}
+	if wantB["statement_timeout"] != "SET statement_timeout = '0'" {
+		t.Errorf("default statement_timeout = %q", wantB["statement_timeout"])
+	}
+
+	if handled, _ := a.handleClientVariableSet(session, "RESET search_path", false); !handled {
+		t.Fatal("RESET search_path not handled")
+	}
+	if cc, ok := receiveOne(t, &outA).(*pgproto3.CommandComplete); !ok || string(cc.CommandTag) != "RESET" {
+		t.Fatalf("expected CommandComplete RESET, got %#v", cc)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if got := a.desiredGUCsLocked()["search_path"]; got != "RESET search_path" {
+		t.Errorf("after RESET search_path = %q", got)
+	}
+}
+
+func TestClientVariableSet_LocalEndsWithTransaction(t *testing.T) {
+	session := &TestSession{DB: newTestSessionDB()}
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.IncrementUserOpenTransactionCount()
+
+	if handled, err := p.handleClientVariableSet(session, "SET statement_timeout = '5s'", false); !handled || err != nil {
+		t.Fatalf("SET: %v, %v", handled, err)
+	}
+	if handled, err := p.handleClientVariableSet(session, "SET LOCAL statement_timeout = '1s'", false); !handled || err != nil {
+		t.Fatalf("SET LOCAL: %v, %v", handled, err)
+	}
+	p.mu.Lock()
+	got := p.desiredGUCsLocked()["statement_timeout"]
+	p.mu.Unlock()
+	if got != `SET statement_timeout TO "1s"` {
+		t.Errorf("inside transaction statement_timeout = %q, want the SET LOCAL value", got)
+	}
+
+	if err := p.DecrementUserOpenTransactionCount(); err != nil {
+		t.Fatal(err)
+	}
+	p.mu.Lock()
+	got = p.desiredGUCsLocked()["statement_timeout"]
+	p.mu.Unlock()
+	if got != `SET statement_timeout TO "5s"` {
+		t.Errorf("after COMMIT statement_timeout = %q, want the session value", got)
+	}
+}
+
+func TestClientVariableSet_LocalOutsideTransactionWarns(t *testing.T) {
+	session := &TestSession{DB: newTestSessionDB()}
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+
+	if handled, err := p.handleClientVariableSet(session, "SET LOCAL timezone = 'UTC'", false); !handled || err != nil {
+		t.Fatalf("handleClientVariableSet = %v, %v; want handled", handled, err)
+	}
+	frontend := pgproto3.NewFrontend(&out, nil)
+	msg, err := frontend.Receive()
+	if notice, ok := msg.(*pgproto3.NoticeResponse); err != nil || !ok || notice.Code != "25P01" || notice.Severity != "WARNING" {
+		t.Fatalf("expected WARNING 25P01, got %#v (%v)", msg, err)
+	}
+	if msg, err := frontend.Receive(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := msg.(*pgproto3.CommandComplete); !ok {
+		t.Fatalf("expected CommandComplete, got %#v", msg)
+	}
+	if len(p.localGUCs) != 0 {
+		t.Errorf("SET LOCAL outside a transaction must have no effect, got %v", p.localGUCs)
+	}
+}
+
+func TestClientVariableSet_NotHandled(t *testing.T) {
+	session := &TestSession{DB: newTestSessionDB()}
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.gucs = map[string]string{"search_path": "SET search_path TO app"}
+
+	for _, q := range []string{"SET client_encoding = 'UTF8'", "SELECT 1", "SET search_path TO a; SELECT 1", "RESET ALL"} {
+		if handled, _ := p.handleClientVariableSet(session, q, false); handled {
+			t.Errorf("%q should go to the backend", q)
+		}
+	}
+	if len(p.gucs) != 0 {
+		t.Errorf("RESET ALL should drop the connection's values, got %v", p.gucs)
+	}
+}
+
+func TestClientVariableSet_ApplicationNameKeepsTestID(t *testing.T) {
+	session := &TestSession{DB: newTestSessionDB(), TestID: "t1"}
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+
+	if handled, _ := p.handleClientVariableSet(session, "SET application_name = 'pgrollback_t1'", false); handled {
+		t.Error("SET application_name should go to the backend")
+	}
+	p.backend.Flush()
+	if out.Len() != 0 {
+		t.Fatal("setting the connection's own application_name must not send a notice")
+	}
+
+	if handled, _ := p.handleClientVariableSet(session, "SET application_name TO 'pgrollback-other'", false); handled {
+		t.Error("SET application_name should go to the backend")
+	}
+	p.backend.Flush()
+	n, ok := receiveOne(t, &out).(*pgproto3.NoticeResponse)
+	if !ok || n.Severity != "WARNING" || !strings.Contains(n.Message, `"other"`) || !strings.Contains(n.Message, `"t1"`) {
+		t.Fatalf("expected a WARNING naming both test IDs, got %#v", n)
+	}
+}
+
+func TestApplyClientGUCs_SwitchesBetweenConnections(t *testing.T) {
+	session := newGuardTestSession(t, "client_gucs")
+	var outA, outB bytes.Buffer
+	a, b := newBufferedProxyConnection(&outA), newBufferedProxyConnection(&outB)
+
+	if _, err := a.handleClientVariableSet(session, "SET TIME ZONE 'America/Sao_Paulo'", false); err != nil {
+		t.Fatalf("SET TIME ZONE: %v", err)
+	}
+	show := func(p *proxyConnection) string {
+		t.Helper()
+		rows, err := session.DB.SafeQuery(p.statementContext(session), "SHOW timezone")
+		if err != nil {
+			t.Fatalf("SHOW timezone: %v", err)
+		}
+		defer rows.Close()
+		var tz string
+		for rows.Next() {
+			if err := rows.Scan(&tz); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return tz
+	}
+	if got := show(a); got != "America/Sao_Paulo" {
+		t.Errorf("connection A timezone = %q", got)
+	}
+	if got := show(b); got == "America/Sao_Paulo" {
+		t.Error("connection B sees connection A's timezone")
+	}
+	if got := show(a); got != "America/Sao_Paulo" {
+		t.Errorf("connection A timezone after B = %q", got)
+	}
+
+	if _, err := a.handleClientVariableSet(session, "SET statement_timeout = 'nonsense'", false); err == nil {
+		t.Fatal("invalid statement_timeout should fail")
+	}
+	if got := show(a); got != "America/Sao_Paulo" {
+		t.Errorf("transaction unusable after failed SET: timezone = %q", got)
+	}
+}
+
+func TestClientVariableSet_RoleIsPerConnection(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", 0, 0, 0)
+	session, backend := pgr.newFakeTestSession("roles")
+	var outA, outB bytes.Buffer
+	a, b := newBufferedProxyConnection(&outA), newBufferedProxyConnection(&outB)
+
+	for _, q := range []string{"SET SESSION AUTHORIZATION bob", "SET ROLE readonly"} {
+		if handled, err := a.handleClientVariableSet(session, q, false); !handled || err != nil {
+			t.Fatalf("%s: %v, %v", q, handled, err)
+		}
+	}
+	if !a.hasRoleSet() || b.hasRoleSet() {
+		t.Fatalf("hasRoleSet: A %v, B %v; want only A", a.hasRoleSet(), b.hasRoleSet())
+	}
+
+	lastBatch := func() string {
+		executed := backend.Executed()
+		for i := len(executed) - 1; i >= 0; i-- {
+			if strings.HasPrefix(executed[i], "SET ") || strings.HasPrefix(executed[i], "RESET ") {
+				return executed[i]
+			}
+		}
+		return ""
+	}
+	if _, err := session.DB.SafeExec(b.statementContext(session), "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastBatch(); got != "RESET session_authorization; RESET role" {
+		t.Errorf("connection B applied %q, want the session user back", got)
+	}
+	if _, err := session.DB.SafeExec(a.statementContext(session), "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := lastBatch(); got != "SET session_authorization TO bob; SET role TO readonly" {
+		t.Errorf("connection A applied %q", got)
+	}
+
+	// RESET ALL leaves the role alone, as on PostgreSQL.
+	if handled, _ := a.handleClientVariableSet(session, "RESET ALL", false); handled {
+		t.Fatal("RESET ALL should go to the backend")
+	}
+	a.mu.Lock()
+	want := a.desiredGUCsLocked()
+	a.mu.Unlock()
+	if want["role"] != "SET role TO readonly" || want["session_authorization"] != "SET session_authorization TO bob" {
+		t.Errorf("RESET ALL dropped the role: %v", want)
+	}
+}
+
+func TestApplyClientGUCs_RoleSwitchesBetweenConnections(t *testing.T) {
+	session := newGuardTestSession(t, "client_roles")
+	var outA, outB bytes.Buffer
+	a, b := newBufferedProxyConnection(&outA), newBufferedProxyConnection(&outB)
+
+	if _, err := a.handleClientVariableSet(session, "SET ROLE pg_monitor", false); err != nil {
+		t.Skipf("SET ROLE pg_monitor not allowed for the test user: %v", err)
+	}
+	currentUser := func(p *proxyConnection) string {
+		t.Helper()
+		var user string
+		rows, err := session.DB.SafeQuery(p.statementContext(session), "SELECT current_user::text")
+		if err != nil {
+			t.Fatalf("SELECT current_user: %v", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			if err := rows.Scan(&user); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return user
+	}
+	if got := currentUser(a); got != "pg_monitor" {
+		t.Errorf("connection A current_user = %q", got)
+	}
+	if got := currentUser(b); got == "pg_monitor" {
+		t.Error("connection B runs as connection A's role")
+	}
+	if got := currentUser(a); got != "pg_monitor" {
+		t.Errorf("connection A current_user after B = %q", got)
+	}
+	if _, err := a.handleClientVariableSet(session, "SET ROLE no_such_role_pgrollback", false); err == nil {
+		t.Error("SET ROLE to a missing role should fail")
+	}
+}
+
+// interleaveStatements runs rounds statements of every connection in conns at the same time, each a
+// "SELECT '<label>'" through SafeExec with the connection's statementContext, then replays the fake
+// backend's log and calls check with the tracked parameters in effect when each statement ran.
+func interleaveStatements(t *testing.T, session *TestSession, backend *fakeBackend, conns map[string]*proxyConnection, rounds int, check func(label string, applied map[string]string)) {
+	t.Helper()
+	var wg sync.WaitGroup
+	for label, p := range conns {
+		wg.Add(1)
+		go func(label string, p *proxyConnection) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				if _, err := session.DB.SafeExec(p.statementContext(session), "SELECT '"+label+"'"); err != nil {
+					t.Errorf("%s: %v", label, err)
+					return
+				}
+			}
+		}(label, p)
+	}
+	wg.Wait()
+
+	applied := make(map[string]string)
+	ran := 0
+	for _, q := range backend.Executed() {
+		if label, ok := strings.CutPrefix(q, "SELECT '"); ok {
+			check(strings.TrimSuffix(label, "'"), applied)
+			ran++
+			continue
+		}
+		for _, stmt := range strings.Split(q, "; ") {
+			for _, name := range trackedClientGUCs {
+				if strings.HasPrefix(stmt, "SET "+name+" ") || stmt == defaultGUCSQL(name) {
+					applied[name] = stmt
+				}
+			}
+		}
+	}
+	if want := rounds * len(conns); ran != want {
+		t.Errorf("%d statements reached the backend, want %d", ran, want)
+	}
+}
+
+func TestClientGUCs_InterleavedStatementsKeepTheirSearchPath(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", 0, 0, 0)
+	session, backend := pgr.newFakeTestSession("interleaved_gucs")
+	conns := make(map[string]*proxyConnection)
+	for _, label := range []string{"a", "b", "c", "d"} {
+		p := newBufferedProxyConnection(new(bytes.Buffer))
+		conns[label] = p
+		q := "SET search_path TO schema_" + label
+		if handled, err := p.handleClientVariableSet(session, q, false); !handled || err != nil {
+			t.Fatalf("%s: %v, %v", q, handled, err)
+		}
+	}
+
+	backend.latency = 100 * time.Microsecond
+	interleaveStatements(t, session, backend, conns, 25, func(label string, applied map[string]string) {
+		if want := "SET search_path TO schema_" + label; applied["search_path"] != want {
+			t.Fatalf("statement of connection %s ran with %q, want %q", label, applied["search_path"], want)
+		}
+	})
+}
+
+// TestClientGUCs_InterleavedStatementsKeepTheirRole interleaves two connections the way that used to leak
+// a role: connection A picks up its values for a statement, connection B runs one, then A's statement
+// reaches the backend. A's role must be applied with its statement, not before B's.
+func TestClientGUCs_InterleavedStatementsKeepTheirRole(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", 0, 0, 0)
+	session, backend := pgr.newFakeTestSession("interleaved_roles")
+	var outA, outB bytes.Buffer
+	a, b := newBufferedProxyConnection(&outA), newBufferedProxyConnection(&outB)
+	if handled, err := a.handleClientVariableSet(session, "SET ROLE readonly", false); !handled || err != nil {
+		t.Fatalf("SET ROLE: %v, %v", handled, err)
+	}
+
+	ctxA := a.statementContext(session)
+	if _, err := session.DB.SafeExec(b.statementContext(session), "SELECT 'b'"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := session.DB.SafeExec(ctxA, "SELECT 'a'"); err != nil {
+		t.Fatal(err)
+	}
+
+	role := ""
+	for _, q := range backend.Executed() {
+		switch {
+		case strings.Contains(q, "SET role TO readonly"):
+			role = "readonly"
+		case strings.Contains(q, "RESET role"):
+			role = ""
+		case q == "SELECT 'a'" && role != "readonly":
+			t.Errorf("connection A's statement ran as the session user: %q", backend.Executed())
+		case q == "SELECT 'b'" && role != "":
+			t.Errorf("connection B's statement ran as role %s: %q", role, backend.Executed())
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// newCloseTestConnection returns a connection of session testID with statements "s1" (bound to portals
+// "p1" and the unnamed portal), "s2" (bound to "p2") and the unnamed statement (bound to "p3"); s1 and s2
+// are registered as prepared on the backend.
+func newCloseTestConnection(t *testing.T, testID string) (*proxyConnection, *realSessionDB) {
+	t.Helper()
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	p.server = &Server{PgRollback: pgr}
+	session, _ := pgr.newFakeTestSession(testID)
+	db := session.DB
+
+	for name, query := range map[string]string{"s1": "SELECT 1", "s2": "SELECT 2", "": "SELECT 3"} {
+		p.SetPreparedStatement(name, query)
+		db.SetPreparedStatement(p.connectionID(), name, query)
+	}
+	p.BindPortal("p1", "s1", nil, nil)
+	p.BindPortal("", "s1", nil, nil)
+	p.BindPortal("p2", "s2", nil, nil)
+	p.BindPortal("p3", "", nil, nil)
+	return p, db
+}
+
+func assertPortals(t *testing.T, p *proxyConnection, want ...string) {
+	t.Helper()
+	for _, name := range []string{"p1", "", "p2", "p3"} {
+		_, open := p.portalStatement(name)
+		if open != slices.Contains(want, name) {
+			t.Errorf("portal %q open = %v, want %v", name, open, !open)
+		}
+	}
+}
+
+func assertStatements(t *testing.T, p *proxyConnection, want ...string) {
+	t.Helper()
+	for _, name := range []string{"s1", "s2", ""} {
+		_, open := p.GetPreparedStatement(name)
+		if open != slices.Contains(want, name) {
+			t.Errorf("statement %q open = %v, want %v", name, open, !open)
+		}
+	}
+}
+
+func TestHandleMessageClose_StatementClosesItsPortals(t *testing.T) {
+	p, db := newCloseTestConnection(t, "close_s")
+
+	p.handleMessageClose("close_s", &pgproto3.Close{ObjectType: 'S', Name: "s1"})
+
+	assertStatements(t, p, "s2", "")
+	assertPortals(t, p, "p2", "p3")
+	if _, ok := db.ResolveBackendStatement(p.connectionID(), "s1"); ok {
+		t.Error("backend statement of s1 still registered after Close 'S'")
+	}
+	if _, ok := db.ResolveBackendStatement(p.connectionID(), "s2"); !ok {
+		t.Error("backend statement of s2 dropped by closing s1")
+	}
+}
+
+func TestHandleMessageClose_PortalKeepsStatement(t *testing.T) {
+	p, db := newCloseTestConnection(t, "close_p")
+
+	p.handleMessageClose("close_p", &pgproto3.Close{ObjectType: 'P', Name: "p1"})
+
+	assertStatements(t, p, "s1", "s2", "")
+	assertPortals(t, p, "", "p2", "p3")
+	if _, ok := db.ResolveBackendStatement(p.connectionID(), "s1"); !ok {
+		t.Error("closing a portal deallocated its statement")
+	}
+}
+
+func TestHandleMessageClose_Unnamed(t *testing.T) {
+	p, _ := newCloseTestConnection(t, "close_unnamed")
+
+	p.handleMessageClose("close_unnamed", &pgproto3.Close{ObjectType: 'P', Name: ""})
+	assertStatements(t, p, "s1", "s2", "")
+	assertPortals(t, p, "p1", "p2", "p3")
+
+	p.handleMessageClose("close_unnamed", &pgproto3.Close{ObjectType: 'S', Name: ""})
+	assertStatements(t, p, "s1", "s2")
+	assertPortals(t, p, "p1", "p2")
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Concurrent connections of one test ID (proxy.concurrent_connections_notice).
+//
+// Every connection of a test ID runs on the session's single backend transaction, so a client pool with
+// several open connections does not run their statements in parallel: they queue on the session and run
+// one at a time in arrival order, and a BEGIN from one waits for (or fails against) the transaction
+// another has open. Tests written for a real pool can be surprised by that. When a session gets more
+// connected clients than the threshold, the connection that crossed it gets a WARNING NoticeResponse,
+// once per session, recommending a pool of one connection.
+
+// noteConcurrentClient reports how many clients the session has after one registered, and whether that is
+// the first time the count went over threshold (threshold <= 0 never notifies).
+func (s *TestSession) noteConcurrentClient(threshold int) (clients int, notify bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients = len(s.teardown.clientConns)
+	if threshold <= 0 || clients <= threshold || s.concurrentNoticeSent {
+		return clients, false
+	}
+	s.concurrentNoticeSent = true
+	return clients, true
+}
+
+// noticeConcurrentClients sends the notice of noteConcurrentClient to this connection, which has just
+// registered with session. It runs before the message loop starts, so nothing else writes to the client.
+func (p *proxyConnection) noticeConcurrentClients(session *TestSession) {
+	clients, notify := session.noteConcurrentClient(p.server.concurrentConnectionsNotice)
+	if !notify {
+		return
+	}
+	log.Printf("[PROXY] testID %s has %d concurrent client connections sharing one transaction", session.TestID, clients)
+	p.backend.Send(&pgproto3.NoticeResponse{
+		Severity: "WARNING",
+		Code:     "01000",
+		Message:  fmt.Sprintf("%d connections are open for test ID %q and they all share one transaction", clients, session.TestID),
+		Detail:   "Statements from these connections run one at a time in arrival order, and a BEGIN on one waits for the transaction another has open.",
+		Hint:     "Use a pool of one connection for the test (e.g. SetMaxOpenConns(1)), or do not rely on concurrent connections running in parallel.",
+	})
+	if err := p.backend.Flush(); err != nil {
+		log.Printf("[PROXY] failed to send concurrent connections notice: %v", err)
+	}
+}
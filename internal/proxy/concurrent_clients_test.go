@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"bytes"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestNoticeConcurrentClients_OncePerSessionAboveThreshold(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	session, _ := pgr.newFakeTestSession("pool_test")
+	server := &Server{PgRollback: pgr, concurrentConnectionsNotice: 2}
+
+	var notices []string
+	for i := 0; i < 4; i++ {
+		client, peer := net.Pipe()
+		defer client.Close()
+		defer peer.Close()
+		if !session.registerProxyClient(client) {
+			t.Fatal("registerProxyClient refused the client")
+		}
+		var out bytes.Buffer
+		p := newBufferedProxyConnection(&out)
+		p.server = server
+		p.noticeConcurrentClients(session)
+		if out.Len() == 0 {
+			notices = append(notices, "")
+			continue
+		}
+		notice, ok := receiveOne(t, &out).(*pgproto3.NoticeResponse)
+		if !ok {
+			t.Fatalf("connection %d: expected a NoticeResponse", i+1)
+		}
+		notices = append(notices, notice.Message)
+	}
+
+	if notices[0] != "" || notices[1] != "" || notices[3] != "" {
+		t.Errorf("only the third connection should be warned, got %q", notices)
+	}
+	if !strings.Contains(notices[2], `3 connections are open for test ID "pool_test"`) {
+		t.Errorf("unexpected notice %q", notices[2])
+	}
+}
+
+func TestNoteConcurrentClient_DisabledWithZeroThreshold(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	session, _ := pgr.newFakeTestSession("pool_test")
+	for i := 0; i < 3; i++ {
+		client, peer := net.Pipe()
+		defer client.Close()
+		defer peer.Close()
+		session.registerProxyClient(client)
+		if clients, notify := session.noteConcurrentClient(0); notify || clients != i+1 {
+			t.Errorf("client %d: got (%d, %v), want (%d, false)", i+1, clients, notify, i+1)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package proxy
+
+import (
+	"testing"
+
+	"pgrollback/internal/config"
+)
+
+// config cannot import proxy, so it repeats these defaults; keep both copies equal.
+func TestConfigDefaultsMatchProxy(t *testing.T) {
+	if config.DefaultMaxMessageSize != DefaultMaxMessageSize {
+		t.Errorf("config.DefaultMaxMessageSize = %d, proxy.DefaultMaxMessageSize = %d", config.DefaultMaxMessageSize, DefaultMaxMessageSize)
+	}
+	if config.DefaultSavepointPrefix != DefaultSavepointPrefix {
+		t.Errorf("config.DefaultSavepointPrefix = %q, proxy.DefaultSavepointPrefix = %q", config.DefaultSavepointPrefix, DefaultSavepointPrefix)
+	}
+	if config.DefaultQueryHistorySize != maxQueryHistory {
+		t.Errorf("config.DefaultQueryHistorySize = %d, proxy maxQueryHistory = %d", config.DefaultQueryHistorySize, maxQueryHistory)
+	}
+}
@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// ConnectionStats are the client connection counters of a Server (see Server.ConnectionStats).
+type ConnectionStats struct {
+	Current  int    // client connections past the startup message and not yet closed
+	Peak     int    // highest Current since the server started
+	Max      int    // proxy.max_connections; 0 = unlimited
+	Rejected uint64 // connections refused with 53300 because no slot freed up in time
+}
+
+// connLimiter caps concurrent client connections (proxy.max_connections). A connection over the cap
+// waits up to waitTimeout for a slot and is then refused; with waitTimeout 0 it is refused at once.
+// The zero value is ready to use and unlimited, but still counts connections for ConnectionStats.
+type connLimiter struct {
+	mu          sync.Mutex
+	max         int
+	waitTimeout time.Duration
+	current     int
+	peak        int
+	rejected    uint64
+	stopped     bool
+	// released is closed (and cleared) whenever a slot frees up or the server stops, waking queued connections.
+	released chan struct{}
+}
+
+// acquire takes a slot for a new client connection. It returns false when the cap is reached and no slot
+// freed up within waitTimeout, or when the server is stopping; release must only follow a true result.
+func (l *connLimiter) acquire() bool {
+	var timeout <-chan time.Time
+	for {
+		l.mu.Lock()
+		if l.stopped {
+			l.mu.Unlock()
+			return false
+		}
+		if l.max <= 0 || l.current < l.max {
+			l.current++
+			if l.current > l.peak {
+				l.peak = l.current
+			}
+			l.mu.Unlock()
+			return true
+		}
+		if l.waitTimeout <= 0 {
+			l.rejected++
+			l.mu.Unlock()
+			return false
+		}
+		if l.released == nil {
+			l.released = make(chan struct{})
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		if timeout == nil {
+			timer := time.NewTimer(l.waitTimeout)
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case <-released:
+		case <-timeout:
+			l.mu.Lock()
+			l.rejected++
+			l.mu.Unlock()
+			return false
+		}
+	}
+}
+
+// release frees the slot of a closed connection and wakes the connections queued for one.
+func (l *connLimiter) release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.current > 0 {
+		l.current--
+	}
+	l.wakeLocked()
+}
+
+// stop refuses further connections and wakes the queued ones so Server.Stop does not wait for their timeout.
+func (l *connLimiter) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.stopped = true
+	l.wakeLocked()
+}
+
+func (l *connLimiter) wakeLocked() {
+	if l.released != nil {
+		close(l.released)
+		l.released = nil
+	}
+}
+
+func (l *connLimiter) stats() ConnectionStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return ConnectionStats{Current: l.current, Peak: l.peak, Max: l.max, Rejected: l.rejected}
+}
+
+// ConnectionStats returns the current and peak number of client connections, the configured cap and how
+// many connections were refused for exceeding it. HTTP requests to the GUI are not counted.
+func (s *Server) ConnectionStats() ConnectionStats {
+	return s.connLimit.stats()
+}
+
+// sendTooManyConnections refuses a connection over proxy.max_connections during startup, with the same
+// FATAL 53300 (too_many_connections) a real PostgreSQL sends when max_connections is exhausted.
+func sendTooManyConnections(backend *pgproto3.Backend) {
+	backend.Send(&pgproto3.ErrorResponse{
+		Severity: "FATAL",
+		Code:     "53300",
+		Message:  "sorry, too many clients already",
+	})
+	backend.Flush()
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestConnLimiter_RejectsOverCapWithoutWait(t *testing.T) {
+	l := &connLimiter{max: 2}
+	if !l.acquire() || !l.acquire() {
+		t.Fatal("acquire under the cap failed")
+	}
+	if l.acquire() {
+		t.Fatal("third acquire succeeded with max 2")
+	}
+	l.release()
+	if !l.acquire() {
+		t.Fatal("acquire after release failed")
+	}
+	got := l.stats()
+	if got.Current != 2 || got.Peak != 2 || got.Max != 2 || got.Rejected != 1 {
+		t.Errorf("stats = %+v, want current 2, peak 2, max 2, rejected 1", got)
+	}
+}
+
+func TestConnLimiter_WaitsForReleasedSlot(t *testing.T) {
+	l := &connLimiter{max: 1, waitTimeout: 5 * time.Second}
+	if !l.acquire() {
+		t.Fatal("first acquire failed")
+	}
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		l.release()
+	}()
+	if !l.acquire() {
+		t.Fatal("queued acquire did not get the released slot")
+	}
+	if got := l.stats(); got.Current != 1 || got.Rejected != 0 {
+		t.Errorf("stats = %+v, want current 1, rejected 0", got)
+	}
+}
+
+func TestConnLimiter_WaitTimeoutAndStop(t *testing.T) {
+	l := &connLimiter{max: 1, waitTimeout: 30 * time.Millisecond}
+	l.acquire()
+	if l.acquire() {
+		t.Fatal("acquire succeeded although no slot was released")
+	}
+
+	l.waitTimeout = time.Hour
+	done := make(chan bool)
+	go func() { done <- l.acquire() }()
+	time.Sleep(30 * time.Millisecond)
+	l.stop()
+	select {
+	case ok := <-done:
+		if ok {
+			t.Fatal("acquire succeeded after stop")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("stop did not wake the queued acquire")
+	}
+}
+
+func TestStartup_MaxConnectionsRefusesWith53300(t *testing.T) {
+	s := &Server{activeConns: make(map[net.Conn]struct{})}
+	WithMaxConnections(1, 0)(s)
+	s.connLimit.acquire() // another client holds the only slot
+	conn := startPipeConnection(t, s)
+
+	frontend := pgproto3.NewFrontend(conn, conn)
+	frontend.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "postgres", "application_name": "max_conn_test"},
+	})
+	if err := frontend.Flush(); err != nil {
+		t.Fatalf("send startup: %v", err)
+	}
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	errResp, ok := msg.(*pgproto3.ErrorResponse)
+	if !ok {
+		t.Fatalf("got %T, want *pgproto3.ErrorResponse", msg)
+	}
+	if errResp.Code != "53300" || errResp.Severity != "FATAL" {
+		t.Errorf("error = %s %s, want FATAL 53300", errResp.Severity, errResp.Code)
+	}
+	if got := s.ConnectionStats(); got.Rejected != 1 || got.Current != 1 {
+		t.Errorf("stats = %+v, want rejected 1, current 1", got)
+	}
+}
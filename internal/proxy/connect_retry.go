@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Retries of a session's first backend connection (postgres.connect_retries).
+//
+// With docker-compose and similar setups the proxy often accepts clients before PostgreSQL accepts
+// connections, and the first query of a test would fail. createNewSession retries connect+BEGIN up
+// to ConnectRetries more times, waiting ConnectRetryInterval before the first retry and doubling the wait
+// after each one (capped at connectRetryMaxBackoff), with jitter so that many tests starting together do
+// not retry in lockstep. The last error is returned once the retries run out or the client's context ends.
+// The session map is not locked meanwhile: other test IDs connect in parallel, and other clients of the
+// same test ID wait for this connection (see createAndStoreSessionLocked).
+
+// DefaultConnectRetryInterval is the wait before the first retry when ConnectRetryInterval is 0.
+const DefaultConnectRetryInterval = 500 * time.Millisecond
+
+const connectRetryMaxBackoff = 10 * time.Second
+
+// connectRetryBackoff returns the pause before retry n (1-based) with base interval: interval·2^(n-1),
+// at most connectRetryMaxBackoff, scaled to between half and all of it by jitter (in [0, 1)).
+func connectRetryBackoff(interval time.Duration, n int, jitter float64) time.Duration {
+	if interval <= 0 {
+		interval = DefaultConnectRetryInterval
+	}
+	d := connectRetryMaxBackoff
+	if n < 1 {
+		n = 1
+	}
+	if n <= 30 {
+		if shifted := interval << (n - 1); shifted > 0 && shifted < connectRetryMaxBackoff {
+			d = shifted
+		}
+	}
+	return d/2 + time.Duration(jitter*float64(d/2))
+}
+
+// connectSession opens the backend connection of testID and begins its base transaction, retrying both
+// as configured by ConnectRetries and ConnectRetryInterval.
+func (p *PgRollback) connectSession(ctx context.Context, testID string, notices *backendNotices) (*pgx.Conn, pgx.Tx, error) {
+	attempts := 1 + max(p.ConnectRetries, 0)
+	var lastErr error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			wait := connectRetryBackoff(p.ConnectRetryInterval, attempt-1, rand.Float64())
+			log.Printf("[PROXY] testID %s: backend connection attempt %d/%d failed, retrying in %s: %v", testID, attempt-1, attempts, wait.Round(time.Millisecond), lastErr)
+			select {
+			case <-ctx.Done():
+				return nil, nil, fmt.Errorf("%w (gave up waiting to retry: %v)", lastErr, ctx.Err())
+			case <-time.After(wait):
+			}
+		}
+		conn, err := p.sessionConnection(ctx, testID, notices)
+		if err != nil {
+			lastErr = fmt.Errorf("failed to create connection for testID %s: %w", testID, err)
+			continue
+		}
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			_ = conn.Close(context.Background())
+			lastErr = fmt.Errorf("failed to begin transaction: %w", err)
+			continue
+		}
+		return conn, tx, nil
+	}
+	if attempts > 1 {
+		return nil, nil, fmt.Errorf("%w (after %d attempts)", lastErr, attempts)
+	}
+	return nil, nil, lastErr
+}
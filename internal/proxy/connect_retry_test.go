@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConnectRetryBackoff(t *testing.T) {
+	interval := 100 * time.Millisecond
+	for n, want := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond, 4: 800 * time.Millisecond, 10: connectRetryMaxBackoff, 100: connectRetryMaxBackoff} {
+		if got := connectRetryBackoff(interval, n, 0.9999999); got < want-time.Millisecond || got > want {
+			t.Errorf("retry %d, max jitter: got %s, want about %s", n, got, want)
+		}
+		if got := connectRetryBackoff(interval, n, 0); got != want/2 {
+			t.Errorf("retry %d, no jitter: got %s, want %s", n, got, want/2)
+		}
+	}
+	if got := connectRetryBackoff(0, 1, 0); got != DefaultConnectRetryInterval/2 {
+		t.Errorf("zero interval: got %s, want %s", got, DefaultConnectRetryInterval/2)
+	}
+}
+
+func TestConnectSession_ReturnsLastErrorAfterRetries(t *testing.T) {
+	// Nothing listens on port 1: every attempt is refused right away.
+	pgr := NewPgRollback("127.0.0.1", 1, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	pgr.ConnectRetries = 2
+	pgr.ConnectRetryInterval = time.Millisecond
+
+	_, _, err := pgr.connectSession(context.Background(), "retry_test", &backendNotices{})
+	if err == nil {
+		t.Fatal("expected an error from an unreachable backend")
+	}
+	if msg := err.Error(); !strings.Contains(msg, "failed to create connection for testID retry_test") || !strings.Contains(msg, "after 3 attempts") {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestConnectSession_StopsRetryingWhenContextEnds(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	pgr.ConnectRetries = 5
+	pgr.ConnectRetryInterval = time.Minute
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, _, err := pgr.connectSession(ctx, "retry_test", &backendNotices{})
+	if err == nil || !strings.Contains(err.Error(), "gave up waiting to retry") {
+		t.Fatalf("expected the retry wait to end with the context, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("connectSession waited %s after the context ended", elapsed)
+	}
+}
+
+func TestGetOrCreateSession_SlowConnectDoesNotBlockOtherTestIDs(t *testing.T) {
+	// The slow_ test IDs go to a server that accepts and never answers; the others are refused at once.
+	hang, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hang.Close()
+	go func() {
+		for {
+			conn, err := hang.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+	pgr := NewPgRollback("127.0.0.1", 1, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	pgr.Routes = []BackendRoute{{Prefix: "slow_", Target: BackendTarget{Host: "127.0.0.1", Port: hang.Addr().(*net.TCPAddr).Port, Database: "postgres", User: "postgres"}}}
+
+	slowCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	slowDone := make(chan error, 2)
+	go func() {
+		_, err := pgr.GetOrCreateSessionContext(slowCtx, "slow_a")
+		slowDone <- err
+	}()
+	// Wait until the first client is connecting, then a second one for the same test ID joins it.
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		pgr.mu.RLock()
+		connecting := pgr.connecting["slow_a"] != nil
+		pgr.mu.RUnlock()
+		if connecting {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("slow_a never started connecting")
+		}
+	}
+	go func() {
+		_, err := pgr.GetOrCreateSessionContext(slowCtx, "slow_a")
+		slowDone <- err
+	}()
+
+	start := time.Now()
+	if _, err := pgr.GetOrCreateSessionContext(context.Background(), "fast_b"); err == nil {
+		t.Fatal("expected fast_b's refused connection to fail")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("fast_b waited %s for slow_a's connection", elapsed)
+	}
+	select {
+	case err := <-slowDone:
+		t.Fatalf("slow_a returned before its context ended: %v", err)
+	default:
+	}
+
+	cancel()
+	for i := 0; i < 2; i++ {
+		if err := <-slowDone; err == nil {
+			t.Error("slow_a: expected an error once its context ended")
+		}
+	}
+	pgr.mu.RLock()
+	defer pgr.mu.RUnlock()
+	if len(pgr.connecting) != 0 || len(pgr.SessionsByTestID) != 0 {
+		t.Errorf("left behind: connecting %v, sessions %v", pgr.connecting, pgr.SessionsByTestID)
+	}
+}
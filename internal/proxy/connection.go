@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 	"sync"
 	"unsafe"
@@ -14,13 +15,16 @@ import (
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
 
+	"pgrollback/pkg/logger"
 	"pgrollback/pkg/sql"
 )
 
 // ErrNoOpenUserTransaction is returned when COMMIT or ROLLBACK is executed but there is no open user transaction on this connection.
 var ErrNoOpenUserTransaction = errors.New("there is no open transaction on this connection")
 
-const pgrollbackSavepointPrefix = "pgrollback_v_"
+// DefaultSavepointPrefix is the default proxy.savepoint_prefix: user BEGIN N levels deep becomes
+// SAVEPOINT <prefix>N on the session transaction.
+const DefaultSavepointPrefix = "pgrollback_v_"
 
 // PrintR é uma função utilitária similar ao print_r do PHP
 // Imprime estruturas de dados de forma legível para debugging
@@ -53,8 +57,11 @@ type proxyConnection struct {
 	server                   *Server
 	mu                       sync.Mutex
 	userOpenTransactionCount int
+	// userTxFailed is set when a statement fails inside the user's transaction and cleared when that
+	// transaction level ends (COMMIT/ROLLBACK); it turns ReadyForQuery's status into 'E' (mu).
+	userTxFailed bool
 
-	// Per-connection Extended Query state (statement/portal names are client names; backend uses prefixed names).
+	// Per-connection Extended Query state (statement/portal names are client names; backend names come from realSessionDB.SetPreparedStatement).
 	preparedStatements       map[string]string
 	statementDescs           map[string]*pgconn.StatementDescription
 	portalToStatement        map[string]string
@@ -62,18 +69,60 @@ type proxyConnection struct {
 	portalFormatCodes        map[string][]int16
 	portalResultFormats      map[string][]int16
 	multiStatementStatements map[string]struct{} // statement names that are multi-statement (not prepared on backend)
+	explainCommandStatements map[string]struct{} // statement names parsed from "pgrollback explain …", which Execute runs even in explain mode
+	preparedStatementUse     map[string]uint64   // last-use tick per named statement, for LRU eviction (mu)
+	preparedStatementUseTick uint64
 
 	// extendedQueryPendingError holds an error from a failed extended-query message (Parse, Describe,
 	// Bind, Execute). Per the PostgreSQL wire protocol, ReadyForQuery is only sent in response to
 	// Sync, NOT after each individual extended-query error. While this is non-nil, subsequent
-	// messages in the same pipeline cycle echo the cached error (no RFQ) until Sync clears it.
+	// messages in the same pipeline cycle are discarded without a response until Sync clears it
+	// (skipUntilSync).
 	extendedQueryPendingError error
+
+	// connLog is the per-connection logger (test_id and conn fields), created once in RunMessageLoop;
+	// see connLogger.
+	connLog *logger.Logger
+
+	// readOnly is set when the client started with default_transaction_read_only=on; its plain
+	// SELECTs then use the session's read connection when proxy.read_connection is enabled.
+	readOnly bool
+
+	// gucs and localGUCs hold this connection's SET and SET LOCAL statements for the parameters
+	// tracked per connection (see client_gucs.go), keyed by parameter name (mu).
+	gucs      map[string]string
+	localGUCs map[string]string
+
+	// cancelKey is the BackendKeyData sent to this client; a CancelRequest carrying it cancels our running statement.
+	cancelKey pgproto3.BackendKeyData
+
+	// backendGeneration is the session's BackendGeneration this connection's state belongs to (mu), see backend_reconnect.go.
+	backendGeneration uint64
+
+	// Reported parameters (mu), see parameter_status.go: the values this client was told with
+	// ParameterStatus, and the parameters a statement may have changed since the last ReadyForQuery.
+	reportedParameters      map[string]string
+	pendingParameterReports []string
+	parameterReportDB       *realSessionDB
+
+	// capture records this connection's messages when proxy.capture_dir selects its test ID (see capture.go).
+	capture *protocolCapture
+
+	// applicationName is the application_name of the StartupMessage, which chose the test ID ("pgrollback whoami").
+	applicationName string
+
+	// historyLabel prefixes this connection's queries in the session's query history (see history_label.go).
+	historyLabel string
+
+	// traceCtx holds the span of the client message being handled; nil between messages (see tracing.go).
+	traceCtx context.Context
 }
 
 // startProxy inicia o proxy usando a sessão existente
 // A sessão já tem conexão PostgreSQL autenticada e transação ativa
-func (server *Server) startProxy(testID string, clientConn net.Conn, backend *pgproto3.Backend) {
+func (server *Server) startProxy(testID string, clientConn net.Conn, backend *pgproto3.Backend, params map[string]string) {
 	proxy := &proxyConnection{
+		readOnly:                 clientRequestsReadOnly(params),
 		clientConn:               clientConn,
 		backend:                  backend,
 		server:                   server,
@@ -84,9 +133,13 @@ func (server *Server) startProxy(testID string, clientConn net.Conn, backend *pg
 		portalFormatCodes:        make(map[string][]int16),
 		portalResultFormats:      make(map[string][]int16),
 		multiStatementStatements: make(map[string]struct{}),
+		applicationName:          params["application_name"],
 	}
+	proxy.historyLabel = expandHistoryLabel(server.historyLabel, clientConn.RemoteAddr().String(), server.connSeq.Add(1), testID, proxy.applicationName)
+	proxy.cancelKey = server.cancelKeys.register(proxy, testID)
+	defer server.cancelKeys.unregister(proxy.cancelKey)
 
-	if err := proxy.sendInitialProtocolMessages(); err != nil {
+	if err := proxy.sendInitialProtocolMessages(server.PgRollback.takeExpiredSessionNotice(testID)); err != nil {
 		log.Printf("[PROXY] Failed to send initial protocol messages: %v", err)
 		return
 	}
@@ -100,6 +153,7 @@ func (server *Server) startProxy(testID string, clientConn net.Conn, backend *pg
 		log.Printf("[PROXY] session %s is tearing down; rejecting proxy client", testID)
 		return
 	}
+	proxy.noticeConcurrentClients(session)
 
 	// Inicia o loop de mensagens refatorado em message_loop.go
 	// unregisterProxyClient is deferred inside RunMessageLoop so it runs before disconnect cleanup;
@@ -107,31 +161,40 @@ func (server *Server) startProxy(testID string, clientConn net.Conn, backend *pg
 	proxy.RunMessageLoop(session)
 }
 
+// connLogger returns connLog, or the default logger when the message loop has not set it (a connection
+// rejected before its loop, or handlers driven directly by tests).
+func (p *proxyConnection) connLogger() *logger.Logger {
+	if p.connLog == nil {
+		return logger.GetDefaultLogger()
+	}
+	return p.connLog
+}
+
 // connectionID returns an opaque id for this connection so session_db can allow nested BEGIN
 // on the same connection while rejecting BEGIN from a different connection.
 func (p *proxyConnection) connectionID() ConnectionID {
 	return ConnectionID(uintptr(unsafe.Pointer(p)))
 }
 
-// backendStmtName returns the name to use on the backend for a client statement name.
-// Empty name stays empty (unnamed statement per protocol). Named statements are prefixed so
-// multiple connections sharing the same backend do not collide.
-func (p *proxyConnection) backendStmtName(clientName string) string {
-	if clientName == "" {
+// clientAddr returns the client's remote address, or "" when there is no network connection (tests).
+func (p *proxyConnection) clientAddr() string {
+	if p.clientConn == nil {
 		return ""
 	}
-	return fmt.Sprintf("c%d_%s", p.connectionID(), clientName)
+	return p.clientConn.RemoteAddr().String()
 }
 
-// rewriteDEALLOCATEForBackend rewrites a DEALLOCATE simple-query so the backend sees
-// this connection's prefixed statement names. Multiple client connections (same testID)
-// share one backend but each has its own prepared statements; we track them per connection
-// and "DEALLOCATE ALL" is faked to only deallocate statements prepared on this connection.
+// rewriteDEALLOCATEForBackend rewrites a DEALLOCATE simple-query so the backend sees the backend
+// names db gave this connection's statements (see backend_statements.go). Multiple client connections
+// (same testID) share one backend but each has its own prepared statements; we track them per
+// connection and "DEALLOCATE ALL" is faked to only deallocate statements prepared on this connection.
 // Returns (rewritten commands, true, names deallocated) if query was DEALLOCATE; otherwise (nil, false, nil).
-// For "DEALLOCATE name" returns one command and [name]. For "DEALLOCATE ALL" returns
-// one DEALLOCATE per prepared statement owned by this connection (or ["SELECT 1"] and nil if none).
+// For "DEALLOCATE name" returns one command and [name] when this connection prepared name on the
+// backend; when it did not (unknown name, or a multi-statement kept only in the session map) no command
+// is returned and the caller only clears local state. For "DEALLOCATE ALL" returns one DEALLOCATE per
+// statement this connection prepared on the backend (possibly none) and every name it tracks.
 // Uses AST (sql.ParseDeallocate) so comments are handled by the PG parser.
-func (p *proxyConnection) rewriteDEALLOCATEForBackend(query string) (rewritten []string, isDEALLOCATE bool, deallocatedNames []string) {
+func (p *proxyConnection) rewriteDEALLOCATEForBackend(db *realSessionDB, query string) (rewritten []string, isDEALLOCATE bool, deallocatedNames []string) {
 	stmts, err := sql.ParseStatements(query)
 	if err != nil || len(stmts) == 0 || stmts[0].Stmt == nil {
 		return nil, false, nil
@@ -143,25 +206,46 @@ func (p *proxyConnection) rewriteDEALLOCATEForBackend(query string) (rewritten [
 	if isAll {
 		names := p.copyPreparedStatementNames()
 		for _, n := range names {
-			if p.IsMultiStatement(n) {
-				continue
-			}
-			rewritten = append(rewritten, "DEALLOCATE "+p.backendStmtName(n))
 			deallocatedNames = append(deallocatedNames, n)
-		}
-		if len(rewritten) == 0 {
-			rewritten = []string{"SELECT 1"}
+			if stmt, ok := db.ResolveBackendStatement(p.connectionID(), n); ok {
+				rewritten = append(rewritten, "DEALLOCATE "+stmt.name)
+			}
 		}
 		return rewritten, true, deallocatedNames
 	}
-	return []string{"DEALLOCATE " + p.backendStmtName(name)}, true, []string{name}
+	stmt, ok := db.ResolveBackendStatement(p.connectionID(), name)
+	if !ok {
+		return nil, true, []string{name}
+	}
+	return []string{"DEALLOCATE " + stmt.name}, true, []string{name}
+}
+
+// forgetDeallocatedStatements drops names from this connection's maps and from the session's backend
+// names, after the rewritten DEALLOCATE ran (or nothing had to run on the backend).
+func (p *proxyConnection) forgetDeallocatedStatements(db *realSessionDB, names []string) {
+	p.RemovePreparedStatements(names)
+	for _, n := range names {
+		db.forgetPreparedStatement(p.connectionID(), n)
+	}
 }
 
 // SetPreparedStatement stores the intercepted query for the given statement name (Extended Query).
+// A statement already stored under that name is closed first, with the portals bound to it: the next
+// unnamed Parse replaces the unnamed statement, and a portal must not run the new query.
 func (p *proxyConnection) SetPreparedStatement(statementName, query string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	p.removePreparedStatementLocked(statementName)
 	p.preparedStatements[statementName] = query
+	p.touchPreparedStatementLocked(statementName)
+}
+
+// GetPreparedStatement returns the stored (intercepted) query for the given statement name.
+func (p *proxyConnection) GetPreparedStatement(statementName string) (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	query, ok := p.preparedStatements[statementName]
+	return query, ok
 }
 
 // GetStatementDescription returns the cached StatementDescription for the given statement name, or nil.
@@ -191,6 +275,9 @@ func (p *proxyConnection) BindPortal(portalName, statementName string, parameter
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	p.portalToStatement[portalName] = statementName
+	if _, ok := p.preparedStatements[statementName]; ok {
+		p.touchPreparedStatementLocked(statementName)
+	}
 	if parameters != nil {
 		dup := make([][]byte, len(parameters))
 		for i, param := range parameters {
@@ -224,10 +311,10 @@ func (p *proxyConnection) QueryForPortal(portalName string) (query string, param
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	statementName := p.portalToStatement[portalName]
-	query = p.preparedStatements[statementName]
+	query, ok = p.preparedStatements[statementName]
 	params = p.portalParams[portalName]
 	formatCodes = p.portalFormatCodes[portalName]
-	return query, params, formatCodes, query != ""
+	return query, params, formatCodes, ok
 }
 
 // PortalStatementName returns the statement name bound to the given portal.
@@ -237,6 +324,15 @@ func (p *proxyConnection) PortalStatementName(portalName string) string {
 	return p.portalToStatement[portalName]
 }
 
+// portalStatement returns the statement name bound to the given portal; ok is false when no Bind created
+// the portal (or it was closed).
+func (p *proxyConnection) portalStatement(portalName string) (statementName string, ok bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	statementName, ok = p.portalToStatement[portalName]
+	return statementName, ok
+}
+
 // PortalResultFormats returns the ResultFormatCodes for the given portal, or nil.
 func (p *proxyConnection) PortalResultFormats(portalName string) []int16 {
 	p.mu.Lock()
@@ -252,20 +348,17 @@ func (p *proxyConnection) GetStatementDescriptionForPortal(portalName string) *p
 	return p.statementDescs[stmtName]
 }
 
-// CloseStatementOrPortal removes the statement or portal from the per-connection maps (objectType 'S' or 'P').
+// CloseStatementOrPortal handles a Close message on the per-connection maps. Closing a statement ('S')
+// also closes the portals bound from it, as in PostgreSQL; closing a portal ('P') leaves its statement.
+// The backend statement is deallocated by the caller (handleMessageClose).
 func (p *proxyConnection) CloseStatementOrPortal(objectType byte, name string) {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	switch objectType {
 	case 'S':
-		delete(p.preparedStatements, name)
-		delete(p.statementDescs, name)
-		delete(p.multiStatementStatements, name)
+		p.removePreparedStatementLocked(name)
 	case 'P':
-		delete(p.portalToStatement, name)
-		delete(p.portalParams, name)
-		delete(p.portalFormatCodes, name)
-		delete(p.portalResultFormats, name)
+		p.removePortalLocked(name)
 	}
 }
 
@@ -289,26 +382,33 @@ func (p *proxyConnection) RemovePreparedStatements(names []string) {
 	}
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	nameSet := make(map[string]struct{}, len(names))
 	for _, n := range names {
-		nameSet[n] = struct{}{}
-	}
-	for n := range nameSet {
-		delete(p.preparedStatements, n)
-		delete(p.statementDescs, n)
-		delete(p.multiStatementStatements, n)
-		// Remove portals that were bound to this statement
-		for portal, stmt := range p.portalToStatement {
-			if stmt == n {
-				delete(p.portalToStatement, portal)
-				delete(p.portalParams, portal)
-				delete(p.portalFormatCodes, portal)
-				delete(p.portalResultFormats, portal)
-			}
+		p.removePreparedStatementLocked(n)
+	}
+}
+
+// removePreparedStatementLocked drops statement name and the portals bound to it. Caller must hold p.mu.
+func (p *proxyConnection) removePreparedStatementLocked(name string) {
+	delete(p.preparedStatements, name)
+	delete(p.statementDescs, name)
+	delete(p.multiStatementStatements, name)
+	delete(p.explainCommandStatements, name)
+	delete(p.preparedStatementUse, name)
+	for portal, stmt := range p.portalToStatement {
+		if stmt == name {
+			p.removePortalLocked(portal)
 		}
 	}
 }
 
+// removePortalLocked drops portal name. Caller must hold p.mu.
+func (p *proxyConnection) removePortalLocked(name string) {
+	delete(p.portalToStatement, name)
+	delete(p.portalParams, name)
+	delete(p.portalFormatCodes, name)
+	delete(p.portalResultFormats, name)
+}
+
 // clearStatementPortalState clears all per-connection statement/portal maps (call after deallocate on disconnect).
 func (p *proxyConnection) clearStatementPortalState() {
 	p.mu.Lock()
@@ -320,67 +420,31 @@ func (p *proxyConnection) clearStatementPortalState() {
 	p.portalFormatCodes = make(map[string][]int16)
 	p.portalResultFormats = make(map[string][]int16)
 	p.multiStatementStatements = make(map[string]struct{})
+	p.explainCommandStatements = nil
+	p.preparedStatementUse = nil
 }
 
-// deallocateBackendStatementsOnDisconnect deallocates all backend prepared statements that were
-// created by this proxy connection (skips multi-statement; those were never prepared on backend)
-// and clears this connection's local statement/portal tracking.
-//
-// Call on disconnect so the shared backend session stays clean.
+// deallocateBackendStatementsLocked deallocates all backend prepared statements that were
+// created by this proxy connection and clears this connection's local statement/portal tracking.
 //
-// Concurrency: multiple proxy connections can share the same backend DB (same testID/session).
-// We take the session DB run-lock (realSessionDB.LockRun) around each DEALLOCATE so:
-// - we don't interleave with other operations that also require the run-lock, and
-// - other connections that inspect backend prepared statements observe a consistent state.
-func (p *proxyConnection) deallocateBackendStatementsOnDisconnect(testID string) {
-	session := p.server.PgRollback.GetSession(testID)
-	if session == nil || session.DB == nil {
-		return
-	}
-	keptValidSessionDB := session.DB
-	keptValidBackendPgConn := keptValidSessionDB.PgConn()
-	if keptValidBackendPgConn == nil {
-		p.clearStatementPortalState()
-		return
-	}
-	for _, name := range p.copyPreparedStatementNames() {
-		if p.IsMultiStatement(name) {
-			continue
-		}
-		backendName := p.backendStmtName(name)
-		keptValidSessionDB.LockRun()
-		_ = keptValidBackendPgConn.Deallocate(context.Background(), backendName)
-		keptValidSessionDB.UnlockRun()
-	}
+// Call on disconnect so the shared backend session stays clean. Caller must hold db.LockRun, so
+// other connections sharing the backend (same testID) never observe a partly deallocated set.
+func (p *proxyConnection) deallocateBackendStatementsLocked(db *realSessionDB) {
+	db.deallocateConnStatementsLocked(context.Background(), p.connectionID())
 	p.clearStatementPortalState()
 }
 
-// rollbackUserSavepointsOnDisconnect rolls back any open user savepoints (from BEGIN not yet
+// rollbackUserSavepointsLocked rolls back any open user savepoints (from BEGIN not yet
 // COMMIT/ROLLBACK) when this connection disconnects, so the session state matches real PostgreSQL
-// behavior (implicit rollback on disconnect). Uses session from p.server.PgRollback.GetSession(testID).
-func (p *proxyConnection) rollbackUserSavepointsOnDisconnect(testID string) {
-	session := p.server.PgRollback.GetSession(testID)
-	if session == nil || session.DB == nil {
-		return
-	}
+// behavior (implicit rollback on disconnect). Caller must hold db.LockRun.
+func (p *proxyConnection) rollbackUserSavepointsLocked(db *realSessionDB) {
 	if count := p.GetUserOpenTransactionCount(); count > 0 {
-		err := session.DB.RollbackUserSavepointsOnDisconnect(context.Background(), count)
-		if err != nil {
+		if err := db.rollbackUserSavepointsOnDisconnectLocked(context.Background(), count); err != nil {
 			log.Printf("[PROXY] Error rolling back user savepoints on disconnect: %v", err)
 		}
 	}
 }
 
-// releaseOpenTransactionOnDisconnect releases this connection's claim on the session's open
-// transaction when the connection disconnects. Uses session from p.server.PgRollback.GetSession(testID).
-func (p *proxyConnection) releaseOpenTransactionOnDisconnect(testID string) {
-	session := p.server.PgRollback.GetSession(testID)
-	if session == nil || session.DB == nil {
-		return
-	}
-	session.DB.ReleaseOpenTransaction(p.connectionID())
-}
-
 // SetMultiStatement marks the given statement name as multi-statement (not prepared on backend).
 func (p *proxyConnection) SetMultiStatement(statementName string) {
 	p.mu.Lock()
@@ -396,6 +460,24 @@ func (p *proxyConnection) IsMultiStatement(statementName string) bool {
 	return ok
 }
 
+// setExplainCommandStatement marks the given statement name as parsed from "pgrollback explain …".
+func (p *proxyConnection) setExplainCommandStatement(statementName string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.explainCommandStatements == nil {
+		p.explainCommandStatements = make(map[string]struct{})
+	}
+	p.explainCommandStatements[statementName] = struct{}{}
+}
+
+// isExplainCommandStatement reports whether the statement was parsed from "pgrollback explain …".
+func (p *proxyConnection) isExplainCommandStatement(statementName string) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	_, ok := p.explainCommandStatements[statementName]
+	return ok
+}
+
 // IncrementUserOpenTransactionCount is called when a user BEGIN (converted to SAVEPOINT) is executed on this connection.
 func (p *proxyConnection) IncrementUserOpenTransactionCount() {
 	p.mu.Lock()
@@ -412,9 +494,23 @@ func (p *proxyConnection) DecrementUserOpenTransactionCount() error {
 		return ErrNoOpenUserTransaction
 	}
 	p.userOpenTransactionCount--
+	p.userTxFailed = false
+	if p.userOpenTransactionCount == 0 {
+		p.clearLocalGUCsLocked()
+	}
 	return nil
 }
 
+// markUserTransactionFailed records that a statement failed inside this connection's open transaction,
+// as PostgreSQL would then report the transaction block as failed. No-op outside a transaction.
+func (p *proxyConnection) markUserTransactionFailed() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.userOpenTransactionCount > 0 {
+		p.userTxFailed = true
+	}
+}
+
 // GetUserOpenTransactionCount returns how many user transactions are still open on this connection (for rollback on disconnect).
 func (p *proxyConnection) GetUserOpenTransactionCount() int {
 	p.mu.Lock()
@@ -443,7 +539,7 @@ func (p *proxyConnection) ApplyTCLSuccessTracking(query string, session *TestSes
 			continue
 		}
 		savepointName := sql.GetSavepointName(stmt)
-		if savepointName == "" || !strings.HasPrefix(savepointName, pgrollbackSavepointPrefix) {
+		if savepointName == "" || !session.DB.isUserSavepointName(savepointName) {
 			continue
 		}
 		if sql.IsSavepoint(stmt) {
@@ -458,16 +554,20 @@ func (p *proxyConnection) ApplyTCLSuccessTracking(query string, session *TestSes
 			if savepointName != session.DB.GetSavepointName() {
 				continue
 			}
+			session.DB.discardSchemaChangesFrom(session.DB.GetSavepointLevel())
 			continue
 		}
 		if sql.IsReleaseSavepoint(stmt) {
-			if savepointName != session.DB.GetSavepointName() {
+			released := releasedUserLevels(savepointName, session.DB.savepointPrefixOrDefault(), session.DB.GetSavepointLevel())
+			if released == 0 {
 				continue
 			}
-			if err := p.DecrementUserOpenTransactionCount(); err != nil {
-				return err
+			for i := 0; i < released; i++ {
+				if err := p.DecrementUserOpenTransactionCount(); err != nil {
+					return err
+				}
+				session.DB.DecrementSavepointLevel()
 			}
-			session.DB.DecrementSavepointLevel()
 			if p.GetUserOpenTransactionCount() == 0 {
 				session.DB.ReleaseOpenTransaction(p.connectionID())
 			}
@@ -477,6 +577,18 @@ func (p *proxyConnection) ApplyTCLSuccessTracking(query string, session *TestSes
 	return nil
 }
 
+// releasedUserLevels returns how many user transaction levels a successful RELEASE SAVEPOINT name
+// closed at savepoint level level: 1 for the innermost <prefix><level>, more when an outer
+// <prefix>K is released (PostgreSQL releases the savepoints above it too; "pgrollback reset"
+// releases <prefix>1), and 0 for any other savepoint.
+func releasedUserLevels(name, prefix string, level int) int {
+	k, err := strconv.Atoi(strings.TrimPrefix(name, prefix))
+	if err != nil || !strings.HasPrefix(name, prefix) || k < 1 || k > level {
+		return 0
+	}
+	return level - k + 1
+}
+
 // ApplyTCLSuccessTrackingLocked is like ApplyTCLSuccessTracking but uses only session.DB methods that do not acquire d.mu.
 // Applies to every statement in the query. Call only while the caller holds session.DB's lock (e.g. LockRun).
 func (p *proxyConnection) ApplyTCLSuccessTrackingLocked(query string, session *TestSession) error {
@@ -493,7 +605,7 @@ func (p *proxyConnection) ApplyTCLSuccessTrackingLocked(query string, session *T
 			continue
 		}
 		savepointName := sql.GetSavepointName(stmt)
-		if savepointName == "" || !strings.HasPrefix(savepointName, pgrollbackSavepointPrefix) {
+		if savepointName == "" || !session.DB.isUserSavepointName(savepointName) {
 			continue
 		}
 		if sql.IsSavepoint(stmt) {
@@ -508,16 +620,20 @@ func (p *proxyConnection) ApplyTCLSuccessTrackingLocked(query string, session *T
 			if savepointName != session.DB.getSavepointNameLocked() {
 				continue
 			}
+			session.DB.discardSchemaChangesFrom(session.DB.GetSavepointLevel())
 			continue
 		}
 		if sql.IsReleaseSavepoint(stmt) {
-			if savepointName != session.DB.getSavepointNameLocked() {
+			released := releasedUserLevels(savepointName, session.DB.savepointPrefixOrDefault(), session.DB.GetSavepointLevel())
+			if released == 0 {
 				continue
 			}
-			if err := p.DecrementUserOpenTransactionCount(); err != nil {
-				return err
+			for i := 0; i < released; i++ {
+				if err := p.DecrementUserOpenTransactionCount(); err != nil {
+					return err
+				}
+				session.DB.decrementSavepointLevelLocked()
 			}
-			session.DB.decrementSavepointLevelLocked()
 			if p.getUserOpenTransactionCountLocked() == 0 {
 				session.DB.releaseOpenTransactionLocked(p.connectionID())
 			}
@@ -527,24 +643,44 @@ func (p *proxyConnection) ApplyTCLSuccessTrackingLocked(query string, session *T
 	return nil
 }
 
+// sendParameterStatus sends a ParameterStatus and remembers the value the client was told.
+func (p *proxyConnection) sendParameterStatus(name, value string) {
+	p.backend.Send(&pgproto3.ParameterStatus{Name: name, Value: value})
+	p.recordReportedParameter(name, value)
+}
+
 // sendInitialProtocolMessages sends the initial PostgreSQL protocol messages to the client.
 // When we have a cache from the real PostgreSQL (first connection), we replay those;
-// otherwise we fall back to hardcoded defaults.
-func (p *proxyConnection) sendInitialProtocolMessages() error {
+// otherwise we fall back to hardcoded defaults. server_version is always the real backend's when
+// any connection to it has reported one (see PgRollback.ServerVersion). A non-empty expiredNotice
+// is sent as a NoticeResponse right before ReadyForQuery (testID was reaped for inactivity; see expiredSessionSet).
+func (p *proxyConnection) sendInitialProtocolMessages(expiredNotice string) error {
+	serverVersion := p.server.PgRollback.ServerVersion()
 	cache := p.server.PgRollback.GetBackendStartupCache()
 	if cache != nil && len(cache.ParameterStatuses) > 0 {
+		sentVersion := false
 		for i := range cache.ParameterStatuses {
 			ps := &cache.ParameterStatuses[i]
-			p.backend.Send(&pgproto3.ParameterStatus{Name: ps.Name, Value: ps.Value})
+			value := ps.Value
+			if ps.Name == "server_version" {
+				value = serverVersion
+				sentVersion = true
+			}
+			p.sendParameterStatus(ps.Name, value)
+		}
+		if !sentVersion {
+			p.sendParameterStatus("server_version", serverVersion)
 		}
-		p.backend.Send(&pgproto3.BackendKeyData{ProcessID: cache.BackendKeyData.ProcessID, SecretKey: cache.BackendKeyData.SecretKey})
 	} else {
-		p.backend.Send(&pgproto3.ParameterStatus{Name: "server_version", Value: "14.0"})
-		p.backend.Send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
-		p.backend.Send(&pgproto3.ParameterStatus{Name: "DateStyle", Value: "ISO"})
-		p.backend.Send(&pgproto3.BackendKeyData{ProcessID: 12345, SecretKey: 67890})
+		p.sendParameterStatus("server_version", serverVersion)
+		p.sendParameterStatus("client_encoding", "UTF8")
+		p.sendParameterStatus("DateStyle", "ISO")
+	}
+	p.backend.Send(&pgproto3.BackendKeyData{ProcessID: p.cancelKey.ProcessID, SecretKey: p.cancelKey.SecretKey})
+	if expiredNotice != "" {
+		p.backend.Send(&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "01000", Message: expiredNotice})
 	}
-	p.backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	p.backend.Send(&pgproto3.ReadyForQuery{TxStatus: p.ReadyForQueryTxStatus()})
 
 	if err := p.backend.Flush(); err != nil {
 		return fmt.Errorf("failed to flush initial protocol messages: %w", err)
@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 // newConnectionForTestID cria uma nova conexão PostgreSQL para o testID.
@@ -18,8 +19,40 @@ import (
 // Connection parameters are set via pgx config (not DSN string concatenation) so that
 // host, user, password, database, and application_name can safely contain spaces and
 // special characters without manual escaping.
-func newConnectionForTestID(host string, port int, database string, user string, password string, sessionTimeout time.Duration, testID string) (*pgx.Conn, error) {
-	appName := getAppNameForTestID(testID)
+//
+// onNotice receives the backend's NoticeResponse messages (see backendNotices); nil discards them.
+func newConnectionForTestID(ctx context.Context, host string, port int, database string, user string, password string, sessionTimeout time.Duration, testID string, onNotice pgconn.NoticeHandler) (*pgx.Conn, error) {
+	if sessionTimeout <= 0 {
+		sessionTimeout = 300 * time.Second
+	}
+	config, err := backendConnConfig(host, port, database, user, password, sessionTimeout, getAppNameForTestID(testID))
+	if err != nil {
+		return nil, err
+	}
+	config.OnNotice = onNotice
+	return connectBackend(ctx, config, sessionTimeout)
+}
+
+// connectBackend opens a session connection from config and sets its timeouts: no statement or idle
+// session limit, and sessionTimeout as idle_in_transaction_session_timeout.
+func connectBackend(ctx context.Context, config *pgx.ConnConfig, sessionTimeout time.Duration) (*pgx.Conn, error) {
+	conn, err := pgx.ConnectConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	timeoutMs := int64(sessionTimeout / time.Millisecond)
+	_, err = conn.Exec(ctx, fmt.Sprintf("SET statement_timeout = '0'; SET idle_session_timeout = '0'; SET idle_in_transaction_session_timeout = %d", timeoutMs))
+	if err != nil {
+		conn.Close(context.Background())
+		return nil, fmt.Errorf("failed to set session timeout: %w", err)
+	}
+
+	return conn, nil
+}
+
+// backendConnConfig builds the pgx config for a connection to the real PostgreSQL.
+func backendConnConfig(host string, port int, database string, user string, password string, sessionTimeout time.Duration, appName string) (*pgx.ConnConfig, error) {
 	u := &url.URL{
 		Scheme: "postgres",
 		User:   url.UserPassword(user, password),
@@ -48,20 +81,27 @@ func newConnectionForTestID(host string, port int, database string, user string,
 	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) {
 		return dialer.DialContext(ctx, network, addr)
 	}
+	return config, nil
+}
 
-	conn, err := pgx.ConnectConfig(context.Background(), config)
+// PingBackend opens a throwaway connection to the real PostgreSQL, pings it and closes it.
+// The error says which backend was unreachable (wrong host/port, server down, bad credentials).
+func (p *PgRollback) PingBackend(ctx context.Context) error {
+	config, err := backendConnConfig(p.PostgresHost, p.PostgresPort, p.PostgresDB, p.PostgresUser, p.PostgresPass, p.SessionTimeout, "pgrollback_healthcheck")
 	if err != nil {
-		return nil, err
+		return err
 	}
-
-	timeoutMs := int64(sessionTimeout / time.Millisecond)
-	_, err = conn.Exec(context.Background(), fmt.Sprintf("SET statement_timeout = '0'; SET idle_session_timeout = '0'; SET idle_in_transaction_session_timeout = %d", timeoutMs))
+	conn, err := pgx.ConnectConfig(ctx, config)
 	if err != nil {
-		conn.Close(context.Background())
-		return nil, fmt.Errorf("failed to set session timeout: %w", err)
+		return fmt.Errorf("backend PostgreSQL %s:%d/%s unreachable: %w", p.PostgresHost, p.PostgresPort, p.PostgresDB, err)
 	}
-
-	return conn, nil
+	defer conn.Close(context.Background())
+	if err := conn.Ping(ctx); err != nil {
+		return fmt.Errorf("backend PostgreSQL %s:%d/%s ping failed: %w", p.PostgresHost, p.PostgresPort, p.PostgresDB, err)
+	}
+	// A startup check runs before any client connects, so the first client already sees the real version.
+	p.rememberServerVersion(conn.PgConn())
+	return nil
 }
 
 func getAppNameForTestID(testID string) string {
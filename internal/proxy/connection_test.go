@@ -1,114 +1,112 @@
 package proxy
 
 import (
-	"strings"
+	"bytes"
 	"testing"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
 )
 
-func TestRewriteDEALLOCATEForBackend(t *testing.T) {
-	p := &proxyConnection{
+// prepareOnBackend records name as prepared by p, locally and in db's backend names, as a Parse does.
+func prepareOnBackend(p *proxyConnection, db *realSessionDB, name, query string) string {
+	p.SetPreparedStatement(name, query)
+	return db.SetPreparedStatement(p.connectionID(), name, query)
+}
+
+func newDEALLOCATETestConn() *proxyConnection {
+	return &proxyConnection{
 		preparedStatements:       make(map[string]string),
 		multiStatementStatements: make(map[string]struct{}),
+		statementDescs:           make(map[string]*pgconn.StatementDescription),
+		portalToStatement:        make(map[string]string),
+	}
+}
+
+func TestRewriteDEALLOCATEForBackend(t *testing.T) {
+	db := &realSessionDB{}
+	p := newDEALLOCATETestConn()
+
+	// DEALLOCATE <name> never prepared on this connection: no backend command, name still reported
+	rewritten, isDEALLOCATE, names := p.rewriteDEALLOCATEForBackend(db, "DEALLOCATE pdo_stmt_00000003")
+	if !isDEALLOCATE || len(rewritten) != 0 || len(names) != 1 || names[0] != "pdo_stmt_00000003" {
+		t.Fatalf("DEALLOCATE unknown name: got (%v, %v, %v), want ([], true, [pdo_stmt_00000003])", rewritten, isDEALLOCATE, names)
 	}
-	// Ensure other maps exist so copyPreparedStatementNames doesn't panic
-	p.statementDescs = make(map[string]*pgconn.StatementDescription)
-	p.portalToStatement = make(map[string]string)
 
-	// DEALLOCATE <name>: should return one command with backend-prefixed name
-	rewritten, isDEALLOCATE, _ := p.rewriteDEALLOCATEForBackend("DEALLOCATE pdo_stmt_00000003")
-	if !isDEALLOCATE || len(rewritten) != 1 {
-		t.Fatalf("DEALLOCATE name: isDEALLOCATE=%v len=%d, want true, 1", isDEALLOCATE, len(rewritten))
+	// DEALLOCATE <name> prepared on this connection: one command with the backend name
+	backendName := prepareOnBackend(p, db, "pdo_stmt_00000003", "SELECT 3")
+	rewritten, isDEALLOCATE, names = p.rewriteDEALLOCATEForBackend(db, "DEALLOCATE pdo_stmt_00000003")
+	if !isDEALLOCATE || len(rewritten) != 1 || rewritten[0] != "DEALLOCATE "+backendName {
+		t.Fatalf("DEALLOCATE name: got (%v, %v), want ([DEALLOCATE %s], true)", rewritten, isDEALLOCATE, backendName)
 	}
-	if !strings.HasPrefix(rewritten[0], "DEALLOCATE c") || !strings.HasSuffix(rewritten[0], "pdo_stmt_00000003") {
-		t.Errorf("DEALLOCATE name: got %q, want DEALLOCATE c<id>_pdo_stmt_00000003", rewritten[0])
+	p.forgetDeallocatedStatements(db, names)
+	if _, ok := db.ResolveBackendStatement(p.connectionID(), "pdo_stmt_00000003"); ok {
+		t.Error("deallocated statement still resolves to a backend name")
 	}
 
 	// Not DEALLOCATE: (nil, false, nil)
-	rewritten, isDEALLOCATE, _ = p.rewriteDEALLOCATEForBackend("SELECT 1")
+	rewritten, isDEALLOCATE, _ = p.rewriteDEALLOCATEForBackend(db, "SELECT 1")
 	if isDEALLOCATE || rewritten != nil {
 		t.Errorf("SELECT 1: got (%v, %v), want (nil, false)", rewritten, isDEALLOCATE)
 	}
 
-	// DEALLOCATE ALL with no statements: ["SELECT 1"]
-	rewritten, isDEALLOCATE, _ = p.rewriteDEALLOCATEForBackend("DEALLOCATE ALL")
-	if !isDEALLOCATE || len(rewritten) != 1 || rewritten[0] != "SELECT 1" {
-		t.Errorf("DEALLOCATE ALL (no stmts): got %v, %v, want [SELECT 1], true", rewritten, isDEALLOCATE)
+	// DEALLOCATE ALL with no statements: nothing to run on the backend
+	rewritten, isDEALLOCATE, _ = p.rewriteDEALLOCATEForBackend(db, "DEALLOCATE ALL")
+	if !isDEALLOCATE || len(rewritten) != 0 {
+		t.Errorf("DEALLOCATE ALL (no stmts): got %v, %v, want [], true", rewritten, isDEALLOCATE)
 	}
 
-	// DEALLOCATE ALL with one prepared statement: one DEALLOCATE with backend name
-	p.SetPreparedStatement("s1", "SELECT 1")
-	rewritten, isDEALLOCATE, _ = p.rewriteDEALLOCATEForBackend("DEALLOCATE ALL")
-	if !isDEALLOCATE || len(rewritten) != 1 {
-		t.Fatalf("DEALLOCATE ALL (one stmt): isDEALLOCATE=%v len=%d", isDEALLOCATE, len(rewritten))
+	// DEALLOCATE ALL with one prepared statement and one multi-statement kept only locally
+	s1 := prepareOnBackend(p, db, "s1", "SELECT 1")
+	p.SetPreparedStatement("batch", "SELECT 1; SELECT 2")
+	p.SetMultiStatement("batch")
+	rewritten, isDEALLOCATE, names = p.rewriteDEALLOCATEForBackend(db, "DEALLOCATE ALL")
+	if !isDEALLOCATE || len(rewritten) != 1 || rewritten[0] != "DEALLOCATE "+s1 {
+		t.Fatalf("DEALLOCATE ALL (one stmt): got (%v, %v), want ([DEALLOCATE %s], true)", rewritten, isDEALLOCATE, s1)
 	}
-	if !strings.HasPrefix(rewritten[0], "DEALLOCATE c") || !strings.Contains(rewritten[0], "s1") {
-		t.Errorf("DEALLOCATE ALL (one stmt): got %q", rewritten[0])
+	if len(names) != 2 {
+		t.Errorf("DEALLOCATE ALL names = %v, want s1 and batch", names)
 	}
 
-	// DEALLOCATE followed by multiple spaces and ALL: rewrite works (rest is trimmed to "ALL")
-	p2 := &proxyConnection{
-		preparedStatements:       make(map[string]string),
-		multiStatementStatements: make(map[string]struct{}),
-		statementDescs:           make(map[string]*pgconn.StatementDescription),
-		portalToStatement:        make(map[string]string),
-	}
-	p2.SetPreparedStatement("stmt_x", "SELECT 2")
-	rewritten, isDEALLOCATE, _ = p2.rewriteDEALLOCATEForBackend("DEALLOCATE    \r\n/**/ ALL")
-	if !isDEALLOCATE {
-		t.Fatalf("DEALLOCATE     ALL: isDEALLOCATE=%v, want true", isDEALLOCATE)
-	}
-	if len(rewritten) != 1 {
-		t.Fatalf("DEALLOCATE     ALL: len(rewritten)=%d, want 1", len(rewritten))
-	}
-	if !strings.HasPrefix(rewritten[0], "DEALLOCATE c") || !strings.Contains(rewritten[0], "stmt_x") {
-		t.Errorf("DEALLOCATE     ALL: got %q, want DEALLOCATE c<id>_stmt_x", rewritten[0])
+	// DEALLOCATE followed by multiple spaces, a comment and ALL: still recognized
+	p2 := newDEALLOCATETestConn()
+	x := prepareOnBackend(p2, db, "stmt_x", "SELECT 2")
+	rewritten, isDEALLOCATE, _ = p2.rewriteDEALLOCATEForBackend(db, "DEALLOCATE    \r\n/**/ ALL")
+	if !isDEALLOCATE || len(rewritten) != 1 || rewritten[0] != "DEALLOCATE "+x {
+		t.Fatalf("DEALLOCATE     ALL: got (%v, %v), want ([DEALLOCATE %s], true)", rewritten, isDEALLOCATE, x)
 	}
 }
 
 // TestDEALLOCATEOnlyAffectsOwnConnection verifies that one connection cannot deallocate
 // a statement prepared on another connection. Each connection's DEALLOCATE is rewritten
-// to its own backend prefix, so the second connection's DEALLOCATE targets a different
+// to its own backend name, so the second connection's DEALLOCATE targets a different
 // name than the first's prepared statement and therefore does not affect it.
 func TestDEALLOCATEOnlyAffectsOwnConnection(t *testing.T) {
-	makeConn := func() *proxyConnection {
-		return &proxyConnection{
-			preparedStatements:       make(map[string]string),
-			multiStatementStatements: make(map[string]struct{}),
-			statementDescs:           make(map[string]*pgconn.StatementDescription),
-			portalToStatement:        make(map[string]string),
-		}
-	}
-
-	connA := makeConn()
-	connB := makeConn()
+	db := &realSessionDB{}
+	connA := newDEALLOCATETestConn()
+	connB := newDEALLOCATETestConn()
 
 	// Connection A prepares a statement (e.g. via extended protocol Parse).
-	connA.SetPreparedStatement("pdo_stmt_00000001", "SELECT 1")
+	backendNameA := prepareOnBackend(connA, db, "pdo_stmt_00000001", "SELECT 1")
 
-	// Connection B tries to DEALLOCATE the same client-side name.
-	rewritten, isDEALLOCATE, _ := connB.rewriteDEALLOCATEForBackend("DEALLOCATE pdo_stmt_00000001")
-	if !isDEALLOCATE || len(rewritten) != 1 {
-		t.Fatalf("connB DEALLOCATE: isDEALLOCATE=%v len=%d, want true, 1", isDEALLOCATE, len(rewritten))
+	// Connection B tries to DEALLOCATE the same client-side name. B never prepared it, so nothing
+	// is sent to the backend (the proxy answers DEALLOCATE locally) and A's statement stays allocated.
+	rewritten, isDEALLOCATE, _ := connB.rewriteDEALLOCATEForBackend(db, "DEALLOCATE pdo_stmt_00000001")
+	if !isDEALLOCATE || len(rewritten) != 0 {
+		t.Fatalf("connB DEALLOCATE: isDEALLOCATE=%v rewritten=%v, want true, []", isDEALLOCATE, rewritten)
 	}
 
-	// B's rewrite must use B's backend name, not A's.
-	backendNameA := connA.backendStmtName("pdo_stmt_00000001")
-	backendNameB := connB.backendStmtName("pdo_stmt_00000001")
-
+	// Once B prepares the same client name, its DEALLOCATE targets B's backend name, never A's.
+	backendNameB := prepareOnBackend(connB, db, "pdo_stmt_00000001", "SELECT 1")
 	if backendNameA == backendNameB {
 		t.Fatalf("different connections must have different backend names: both %q", backendNameA)
 	}
-
+	rewritten, _, _ = connB.rewriteDEALLOCATEForBackend(db, "DEALLOCATE pdo_stmt_00000001")
 	expectedB := "DEALLOCATE " + backendNameB
-	if rewritten[0] != expectedB {
-		t.Errorf("connB DEALLOCATE rewritten to %q, want %q", rewritten[0], expectedB)
+	if len(rewritten) != 1 || rewritten[0] != expectedB {
+		t.Errorf("connB DEALLOCATE rewritten to %v, want [%q]", rewritten, expectedB)
 	}
-
-	// So on the backend: A's statement is backendNameA, B's DEALLOCATE targets backendNameB.
-	// backendNameB does not exist (B never prepared it), so the backend would return
-	// "prepared statement does not exist". A's statement remains allocated.
 }
 
 // TestDEALLOCATEALLWithSameNameOnTwoConnections: two connections prepare the same
@@ -116,31 +114,20 @@ func TestDEALLOCATEOnlyAffectsOwnConnection(t *testing.T) {
 // it "fails" to deallocate the other's prepared statement). The other runs
 // DEALLOCATE <name> and succeeds because the rewrite uses its own backend name.
 func TestDEALLOCATEALLWithSameNameOnTwoConnections(t *testing.T) {
-	makeConn := func() *proxyConnection {
-		return &proxyConnection{
-			preparedStatements:       make(map[string]string),
-			multiStatementStatements: make(map[string]struct{}),
-			statementDescs:           make(map[string]*pgconn.StatementDescription),
-			portalToStatement:        make(map[string]string),
-		}
-	}
-
-	connA := makeConn()
-	connB := makeConn()
+	db := &realSessionDB{}
+	connA := newDEALLOCATETestConn()
+	connB := newDEALLOCATETestConn()
 
 	const sameName = "pdo_stmt_00000001"
-	connA.SetPreparedStatement(sameName, "SELECT 1")
-	connB.SetPreparedStatement(sameName, "SELECT 1")
-
-	backendNameA := connA.backendStmtName(sameName)
-	backendNameB := connB.backendStmtName(sameName)
+	backendNameA := prepareOnBackend(connA, db, sameName, "SELECT 1")
+	backendNameB := prepareOnBackend(connB, db, sameName, "SELECT 1")
 	if backendNameA == backendNameB {
 		t.Fatalf("same client name must get different backend names: both %q", backendNameA)
 	}
 
 	// Connection A runs DEALLOCATE ALL. It must only deallocate A's statement, not B's.
 	// So the rewrite must contain only A's backend name; B's statement remains on the backend.
-	rewrittenA, isDEALLOCATE, _ := connA.rewriteDEALLOCATEForBackend("DEALLOCATE ALL")
+	rewrittenA, isDEALLOCATE, namesA := connA.rewriteDEALLOCATEForBackend(db, "DEALLOCATE ALL")
 	if !isDEALLOCATE || len(rewrittenA) != 1 {
 		t.Fatalf("connA DEALLOCATE ALL: isDEALLOCATE=%v len=%d, want true, 1", isDEALLOCATE, len(rewrittenA))
 	}
@@ -148,13 +135,10 @@ func TestDEALLOCATEALLWithSameNameOnTwoConnections(t *testing.T) {
 	if rewrittenA[0] != expectedA {
 		t.Errorf("connA DEALLOCATE ALL: got %q, want %q", rewrittenA[0], expectedA)
 	}
-	// Must not contain B's backend name (so A "fails" to deallocate B's statement).
-	if strings.Contains(rewrittenA[0], backendNameB) {
-		t.Errorf("connA DEALLOCATE ALL must not contain connB backend name %q", backendNameB)
-	}
+	connA.forgetDeallocatedStatements(db, namesA)
 
 	// Connection B runs DEALLOCATE <same name>. It must succeed by deallocating only B's.
-	rewrittenB, isDEALLOCATE, _ := connB.rewriteDEALLOCATEForBackend("DEALLOCATE " + sameName)
+	rewrittenB, isDEALLOCATE, _ := connB.rewriteDEALLOCATEForBackend(db, "DEALLOCATE "+sameName)
 	if !isDEALLOCATE || len(rewrittenB) != 1 {
 		t.Fatalf("connB DEALLOCATE %q: isDEALLOCATE=%v len=%d, want true, 1", sameName, isDEALLOCATE, len(rewrittenB))
 	}
@@ -163,3 +147,130 @@ func TestDEALLOCATEALLWithSameNameOnTwoConnections(t *testing.T) {
 		t.Errorf("connB DEALLOCATE %q: got %q, want %q", sameName, rewrittenB[0], expectedB)
 	}
 }
+
+// initialServerVersion runs sendInitialProtocolMessages and returns the server_version it reported.
+func initialServerVersion(t *testing.T, pgr *PgRollback) string {
+	t.Helper()
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: pgr}
+	if err := p.sendInitialProtocolMessages(""); err != nil {
+		t.Fatalf("sendInitialProtocolMessages: %v", err)
+	}
+	frontend := pgproto3.NewFrontend(&out, nil)
+	version := ""
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		switch m := msg.(type) {
+		case *pgproto3.ParameterStatus:
+			if m.Name == "server_version" {
+				version = m.Value
+			}
+		case *pgproto3.ReadyForQuery:
+			return version
+		}
+	}
+}
+
+func TestSendInitialProtocolMessages_ServerVersion(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	if got := initialServerVersion(t, pgr); got != fallbackServerVersion {
+		t.Errorf("without any backend connection server_version = %q, want the fallback %q", got, fallbackServerVersion)
+	}
+
+	pgr.serverVersion.Store("16.4 (Debian 16.4-1.pgdg120+1)")
+	if got := initialServerVersion(t, pgr); got != "16.4 (Debian 16.4-1.pgdg120+1)" {
+		t.Errorf("server_version = %q, want the backend's", got)
+	}
+
+	// A startup cache without server_version still reports the real one.
+	pgr.backendStartupCache = &BackendStartupCache{ParameterStatuses: []pgproto3.ParameterStatus{{Name: "client_encoding", Value: "UTF8"}}}
+	if got := initialServerVersion(t, pgr); got != "16.4 (Debian 16.4-1.pgdg120+1)" {
+		t.Errorf("server_version with cache = %q, want the backend's", got)
+	}
+}
+
+// TestApplyTCLSuccessTracking_ReleasingOuterSavepointClosesAllLevels covers "pgrollback reset": releasing
+// pgrollback_v_1 three BEGINs deep closes all three on the session and on the connection, and frees the claim.
+func TestApplyTCLSuccessTracking_ReleasingOuterSavepointClosesAllLevels(t *testing.T) {
+	db := newTestSessionDB()
+	session := &TestSession{DB: db}
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	if err := db.ClaimOpenTransaction(p.connectionID(), ""); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		db.IncrementSavepointLevel()
+		p.IncrementUserOpenTransactionCount()
+	}
+
+	if err := p.ApplyTCLSuccessTracking("ROLLBACK TO SAVEPOINT pgrollback_v_1; RELEASE SAVEPOINT pgrollback_v_1", session); err != nil {
+		t.Fatal(err)
+	}
+	if db.GetSavepointLevel() != 0 || p.GetUserOpenTransactionCount() != 0 {
+		t.Errorf("level = %d, connection count = %d; want 0 and 0", db.GetSavepointLevel(), p.GetUserOpenTransactionCount())
+	}
+	if p.ReadyForQueryTxStatus() != 'I' || db.connectionWithOpenTx != 0 {
+		t.Error("after the reset the connection must be idle and the claim released")
+	}
+}
+
+func TestReleasedUserLevels(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		level int
+		want  int
+	}{
+		{"pgrollback_v_3", 3, 1},
+		{"pgrollback_v_1", 3, 3},
+		{"pgrollback_v_4", 3, 0},
+		{"pgrollback_v_0", 3, 0},
+		{"pgrollback_user_x", 3, 0},
+		{"sp1", 3, 0},
+	} {
+		if got := releasedUserLevels(tt.name, DefaultSavepointPrefix, tt.level); got != tt.want {
+			t.Errorf("releasedUserLevels(%q, %d) = %d, want %d", tt.name, tt.level, got, tt.want)
+		}
+	}
+}
+
+// TestApplyTCLSuccessTracking_AppSavepointsAreUntracked: savepoints the application names itself do not
+// match proxy.savepoint_prefix, so they pass through without touching the levels; with a custom prefix
+// the old pgrollback_v_ names become ordinary application savepoints too.
+func TestApplyTCLSuccessTracking_AppSavepointsAreUntracked(t *testing.T) {
+	for _, prefix := range []string{"", "app_tx_"} {
+		db := newTestSessionDB()
+		db.savepointPrefix = prefix
+		session := &TestSession{DB: db}
+		var out bytes.Buffer
+		p := newBufferedProxyConnection(&out)
+
+		begin := db.GetNextSavepointName()
+		if err := p.ApplyTCLSuccessTracking("SAVEPOINT "+begin, session); err != nil {
+			t.Fatal(err)
+		}
+		untracked := []string{"SAVEPOINT sp1", "RELEASE SAVEPOINT sp1", "SAVEPOINT my_pgrollback_v_2", "RELEASE SAVEPOINT pgrollback_user_x"}
+		if prefix != "" {
+			untracked = append(untracked, "SAVEPOINT pgrollback_v_2", "RELEASE SAVEPOINT pgrollback_v_1")
+		}
+		for _, q := range untracked {
+			if err := p.ApplyTCLSuccessTracking(q, session); err != nil {
+				t.Fatalf("prefix %q, %s: %v", prefix, q, err)
+			}
+			if db.GetSavepointLevel() != 1 || p.GetUserOpenTransactionCount() != 1 {
+				t.Errorf("prefix %q, after %s: level = %d, connection count = %d; want 1 and 1",
+					prefix, q, db.GetSavepointLevel(), p.GetUserOpenTransactionCount())
+			}
+		}
+		if db.IsUserBeginQuery("SAVEPOINT sp1") {
+			t.Errorf("prefix %q: SAVEPOINT sp1 must not count as a user BEGIN", prefix)
+		}
+		if want := db.savepointPrefixOrDefault() + "1"; begin != want {
+			t.Errorf("prefix %q: first BEGIN savepoint = %q, want %q", prefix, begin, want)
+		}
+	}
+}
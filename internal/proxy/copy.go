@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// copyGuardSavepoint wraps a COPY so a failed load is undone without aborting the session transaction.
+const copyGuardSavepoint = "pgrollback_copy_guard"
+
+// errClientCopyFail is returned by clientCopyReader when the client aborts the copy with CopyFail.
+var errClientCopyFail = errors.New("COPY from stdin failed")
+
+// clientCopyReader exposes the client's CopyData stream as an io.Reader for pgconn.CopyFrom.
+// CopyDone ends the stream (io.EOF); CopyFail turns into an error so pgconn sends CopyFail to the backend.
+// Flush and Sync are ignored during copy-in, as PostgreSQL does.
+type clientCopyReader struct {
+	backend *pgproto3.Backend
+	pending []byte
+	done    bool
+}
+
+func (r *clientCopyReader) Read(buf []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		msg, err := r.backend.Receive()
+		if err != nil {
+			r.done = true
+			return 0, err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			r.pending = msg.Data
+		case *pgproto3.CopyDone:
+			r.done = true
+			return 0, io.EOF
+		case *pgproto3.CopyFail:
+			r.done = true
+			return 0, fmt.Errorf("%w: %s", errClientCopyFail, msg.Message)
+		case *pgproto3.Flush, *pgproto3.Sync:
+		default:
+			r.done = true
+			return 0, fmt.Errorf("unexpected message %T during COPY from stdin", msg)
+		}
+	}
+	n := copy(buf, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// copyDescribeStatement is the backend statement briefly prepared to learn the columns of COPY (query) TO.
+const copyDescribeStatement = "pgrollback_copy_describe"
+
+// copyColumnCount returns how many columns a COPY of info transfers: the explicit column list, the
+// columns of the inner query, or every live column of the table. Caller must hold the session DB run-lock.
+func copyColumnCount(ctx context.Context, pgConn *pgconn.PgConn, info sql.CopyStmt) (int, error) {
+	if len(info.Columns) > 0 {
+		return len(info.Columns), nil
+	}
+	if info.Relation == "" {
+		sd, err := pgConn.Prepare(ctx, copyDescribeStatement, info.Query, nil)
+		if err != nil {
+			return 0, err
+		}
+		if err := pgConn.Deallocate(ctx, copyDescribeStatement); err != nil {
+			return 0, err
+		}
+		return len(sd.Fields), nil
+	}
+	// regclass parses its input as SQL, so quote the names: a mixed-case or dotted name must not be
+	// folded or split.
+	result := pgConn.ExecParams(ctx,
+		"SELECT count(*) FROM pg_attribute WHERE attrelid = $1::regclass AND attnum > 0 AND NOT attisdropped",
+		[][]byte{[]byte(pgx.Identifier(info.RelationParts).Sanitize())}, nil, nil, nil).Read()
+	if result.Err != nil {
+		return 0, result.Err
+	}
+	if len(result.Rows) != 1 || len(result.Rows[0]) != 1 {
+		return 0, fmt.Errorf("column count for %s: unexpected result", info.Relation)
+	}
+	return strconv.Atoi(string(result.Rows[0][0]))
+}
+
+// copyColumnFormats returns n format codes matching the COPY format (all text or all binary).
+func copyColumnFormats(n int, binary bool) []uint16 {
+	formats := make([]uint16, n)
+	if binary {
+		for i := range formats {
+			formats[i] = 1
+		}
+	}
+	return formats
+}
+
+// executeCopyFromStdin runs COPY ... FROM STDIN for a Simple Query: it answers CopyInResponse, streams the
+// client's CopyData to the backend and finishes with CommandComplete ("COPY n"). The load runs inside a
+// guard savepoint of the session transaction, so it is rolled back with the test like any other write.
+func (p *proxyConnection) executeCopyFromStdin(testID string, query string, info sql.CopyStmt, sendReadyForQuery bool) error {
+	session := p.server.PgRollback.GetSession(testID)
+	if session == nil || session.DB == nil {
+		return fmt.Errorf("sessão não encontrada para testID: %s", testID)
+	}
+	db := session.DB
+	ctx := p.statementContext(session)
+	p.recordQuery(db, query)
+
+	db.LockRun()
+	pgConn := db.PgConnLocked()
+	if pgConn == nil {
+		db.UnlockRun()
+		return fmt.Errorf("sessão sem conexão para testID: %s", testID)
+	}
+	if err := db.beginClientStatementLocked(ctx); err != nil {
+		db.UnlockRun()
+		return err
+	}
+	var tag pgconn.CommandTag
+	err := db.runWithSavepointGuardLocked(ctx, copyGuardSavepoint, func() error {
+		columns, err := copyColumnCount(ctx, pgConn, info)
+		if err != nil {
+			return err
+		}
+		overall := byte(0)
+		if info.Binary {
+			overall = 1
+		}
+		p.backend.Send(&pgproto3.CopyInResponse{OverallFormat: overall, ColumnFormatCodes: copyColumnFormats(columns, info.Binary)})
+		if err := p.backend.Flush(); err != nil {
+			return err
+		}
+		tag, err = pgConn.CopyFrom(ctx, &clientCopyReader{backend: p.backend}, query)
+		return err
+	})
+	db.endClientStatementLocked(ctx)
+	db.UnlockRun()
+	p.relayStatementNotices(ctx)
+	if err != nil {
+		log.Printf("[PROXY] COPY FROM STDIN failed (testID=%s): %v", testID, err)
+		return err
+	}
+
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag.String())})
+	if sendReadyForQuery {
+		p.SendReadyForQuery()
+	} else if err := p.backend.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// clientCopyWriter forwards the backend's COPY TO STDOUT data to the client as CopyData, flushing every
+// streamFlushEveryRows messages (one message per row in text/CSV) so large exports are not buffered.
+type clientCopyWriter struct {
+	backend *pgproto3.Backend
+	sent    int
+}
+
+func (w *clientCopyWriter) Write(data []byte) (int, error) {
+	w.backend.Send(&pgproto3.CopyData{Data: data})
+	w.sent++
+	if w.sent%streamFlushEveryRows == 0 {
+		if err := w.backend.Flush(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// executeCopyToStdout runs COPY ... TO STDOUT for a Simple Query: it answers CopyOutResponse, relays the
+// backend's rows as CopyData, then CopyDone and CommandComplete ("COPY n"). It reads inside the session
+// transaction (under a guard savepoint), so it sees the test's uncommitted data.
+func (p *proxyConnection) executeCopyToStdout(testID string, query string, info sql.CopyStmt, sendReadyForQuery bool) error {
+	session := p.server.PgRollback.GetSession(testID)
+	if session == nil || session.DB == nil {
+		return fmt.Errorf("sessão não encontrada para testID: %s", testID)
+	}
+	db := session.DB
+	ctx := p.statementContext(session)
+	p.recordQuery(db, query)
+
+	db.LockRun()
+	pgConn := db.PgConnLocked()
+	if pgConn == nil {
+		db.UnlockRun()
+		return fmt.Errorf("sessão sem conexão para testID: %s", testID)
+	}
+	if err := db.beginClientStatementLocked(ctx); err != nil {
+		db.UnlockRun()
+		return err
+	}
+	var tag pgconn.CommandTag
+	err := db.runWithSavepointGuardLocked(ctx, copyGuardSavepoint, func() error {
+		columns, err := copyColumnCount(ctx, pgConn, info)
+		if err != nil {
+			return err
+		}
+		overall := byte(0)
+		if info.Binary {
+			overall = 1
+		}
+		p.backend.Send(&pgproto3.CopyOutResponse{OverallFormat: overall, ColumnFormatCodes: copyColumnFormats(columns, info.Binary)})
+		tag, err = pgConn.CopyTo(ctx, &clientCopyWriter{backend: p.backend}, query)
+		return err
+	})
+	db.endClientStatementLocked(ctx)
+	db.UnlockRun()
+	p.relayStatementNotices(ctx)
+	if err != nil {
+		log.Printf("[PROXY] COPY TO STDOUT failed (testID=%s): %v", testID, err)
+		return err
+	}
+
+	p.backend.Send(&pgproto3.CopyDone{})
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag.String())})
+	if sendReadyForQuery {
+		p.SendReadyForQuery()
+	} else if err := p.backend.Flush(); err != nil {
+		return err
+	}
+	return nil
+}
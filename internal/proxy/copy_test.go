@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// clientCopyStream encodes frontend messages as a client would send them during COPY FROM STDIN.
+func clientCopyStream(t *testing.T, msgs ...pgproto3.FrontendMessage) *pgproto3.Backend {
+	t.Helper()
+	var buf bytes.Buffer
+	frontend := pgproto3.NewFrontend(nil, &buf)
+	for _, m := range msgs {
+		frontend.Send(m)
+	}
+	if err := frontend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	return pgproto3.NewBackend(&buf, io.Discard)
+}
+
+func TestClientCopyReader_ConcatenatesDataUntilCopyDone(t *testing.T) {
+	backend := clientCopyStream(t,
+		&pgproto3.CopyData{Data: []byte("1\tone\n")},
+		&pgproto3.Flush{},
+		&pgproto3.CopyData{Data: []byte("2\ttwo\n")},
+		&pgproto3.CopyDone{},
+	)
+	got, err := io.ReadAll(&clientCopyReader{backend: backend})
+	if err != nil {
+		t.Fatalf("ReadAll: %v", err)
+	}
+	if string(got) != "1\tone\n2\ttwo\n" {
+		t.Fatalf("data = %q", got)
+	}
+}
+
+func TestClientCopyReader_CopyFailIsError(t *testing.T) {
+	backend := clientCopyStream(t,
+		&pgproto3.CopyData{Data: []byte("1\tone\n")},
+		&pgproto3.CopyFail{Message: "aborted by user"},
+	)
+	_, err := io.ReadAll(&clientCopyReader{backend: backend})
+	if !errors.Is(err, errClientCopyFail) {
+		t.Fatalf("err = %v, want errClientCopyFail", err)
+	}
+}
+
+func TestCopyColumnFormats(t *testing.T) {
+	if got := copyColumnFormats(2, false); len(got) != 2 || got[0] != 0 || got[1] != 0 {
+		t.Fatalf("text formats = %v", got)
+	}
+	if got := copyColumnFormats(2, true); got[0] != 1 || got[1] != 1 {
+		t.Fatalf("binary formats = %v", got)
+	}
+}
+
+func TestClientCopyWriter_SendsCopyData(t *testing.T) {
+	var out bytes.Buffer
+	w := &clientCopyWriter{backend: pgproto3.NewBackend(bytes.NewReader(nil), &out)}
+	if _, err := w.Write([]byte("1\tone\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.backend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	cd, ok := receiveOne(t, &out).(*pgproto3.CopyData)
+	if !ok || string(cd.Data) != "1\tone\n" {
+		t.Fatalf("got %#v", cd)
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"net"
+	"strings"
+)
+
+// Test ID of connections without application_name (proxy.default_test_id).
+//
+// protocol.ParseApplicationIdentity puts a connection that sends no application_name (a plain psql, a GUI
+// client) in the "default" session. proxy.default_test_id replaces that test ID with a template filled
+// from the connection: {database} and {user} are its startup parameters and {host} the client address
+// without the port. "sandbox" sends all such connections to one fixed session, "{database}" gives each
+// database its own. Connections that name a test ID in application_name are not affected.
+
+// DefaultTestIDFields are the fields a proxy.default_test_id template may use.
+var DefaultTestIDFields = []string{"{database}", "{user}", "{host}"}
+
+// expandDefaultTestID returns the test ID of a connection without application_name under template, or ""
+// when template is empty, the connection sent an application_name or the fields expanded to nothing.
+func expandDefaultTestID(template string, params map[string]string, remoteAddr net.Addr) string {
+	if template == "" || params["application_name"] != "" {
+		return ""
+	}
+	host := ""
+	if remoteAddr != nil {
+		host = remoteAddr.String()
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	return strings.TrimSpace(strings.NewReplacer(
+		"{database}", params["database"],
+		"{user}", params["user"],
+		"{host}", host,
+	).Replace(template))
+}
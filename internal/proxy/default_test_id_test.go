@@ -0,0 +1,28 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+)
+
+func TestExpandDefaultTestID(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(10, 0, 0, 5), Port: 50432}
+	params := map[string]string{"user": "alice", "database": "shop"}
+	tests := []struct {
+		template string
+		params   map[string]string
+		want     string
+	}{
+		{"", params, ""},
+		{"sandbox", params, "sandbox"},
+		{"{database}", params, "shop"},
+		{"{user}@{host}", params, "alice@10.0.0.5"},
+		{"{database}", map[string]string{"user": "alice", "database": "shop", "application_name": "pgrollback_x"}, ""},
+		{"{database}", map[string]string{"user": "alice"}, ""},
+	}
+	for _, tt := range tests {
+		if got := expandDefaultTestID(tt.template, tt.params, addr); got != tt.want {
+			t.Errorf("expandDefaultTestID(%q, %v) = %q, want %q", tt.template, tt.params, got, tt.want)
+		}
+	}
+}
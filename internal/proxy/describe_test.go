@@ -1,7 +1,13 @@
 package proxy
 
 import (
+	"bytes"
+	"errors"
+	"io"
 	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
 )
 
 // Tests in this file assert DescribeRowFieldsForQuery returns non-empty fields for
@@ -48,3 +54,177 @@ func TestDescribeRowFieldsForQuery_ReturningMultipleColumns(t *testing.T) {
 		t.Errorf("field names = %q, %q; want \"id\", \"name\"", fields[0].Name, fields[1].Name)
 	}
 }
+
+// TestStatementDescriptionFromQuery_MultiStatement asserts that a multi-statement "prepared" query
+// (no backend description) is described with the parameter count across all statements and the
+// row shape of the last statement.
+func TestStatementDescriptionFromQuery_MultiStatement(t *testing.T) {
+	query := `UPDATE t SET a = $1 WHERE id = $3; INSERT INTO t (a, b) VALUES ($2, 'x') RETURNING "id"`
+	sd := statementDescriptionFromQuery(query)
+	if len(sd.ParamOIDs) != 3 {
+		t.Errorf("ParamOIDs len = %d, want 3", len(sd.ParamOIDs))
+	}
+	if len(sd.Fields) != 1 || sd.Fields[0].Name != "id" {
+		t.Fatalf("Fields = %+v, want single \"id\" column", sd.Fields)
+	}
+}
+
+// TestStatementDescriptionFromQuery_NoResultSet asserts NoData (no fields) for statements without rows.
+func TestStatementDescriptionFromQuery_NoResultSet(t *testing.T) {
+	sd := statementDescriptionFromQuery(`DELETE FROM t WHERE id = $1; DELETE FROM u`)
+	if len(sd.ParamOIDs) != 1 {
+		t.Errorf("ParamOIDs len = %d, want 1", len(sd.ParamOIDs))
+	}
+	if len(sd.Fields) != 0 {
+		t.Errorf("Fields = %+v, want none", sd.Fields)
+	}
+}
+
+// describeReplies runs Describe(objectType, name) on p and decodes everything it sent to the client.
+func describeReplies(t *testing.T, p *proxyConnection, out *bytes.Buffer, objectType byte, name string) []pgproto3.BackendMessage {
+	t.Helper()
+	p.handleMessageDescribe(&pgproto3.Describe{ObjectType: objectType, Name: name})
+	frontend := pgproto3.NewFrontend(out, nil)
+	var msgs []pgproto3.BackendMessage
+	for {
+		msg, err := frontend.Receive()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return msgs
+		}
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		msgs = append(msgs, msg)
+	}
+}
+
+// TestHandleMessageDescribe_StatementSendsParameterDescription asserts Describe('S') answers with
+// ParameterDescription followed by RowDescription for a statement with a result set.
+func TestHandleMessageDescribe_StatementSendsParameterDescription(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.SetPreparedStatement("s1", `INSERT INTO t (a) VALUES ($1) RETURNING "id"`)
+	p.SetStatementDescription("s1", &pgconn.StatementDescription{
+		ParamOIDs: []uint32{23},
+		Fields:    []pgconn.FieldDescription{{Name: "id", DataTypeOID: 20}},
+	})
+
+	msgs := describeReplies(t, p, &out, 'S', "s1")
+	if len(msgs) != 2 {
+		t.Fatalf("got %d messages (%#v), want ParameterDescription + RowDescription", len(msgs), msgs)
+	}
+	if pd, ok := msgs[0].(*pgproto3.ParameterDescription); !ok || len(pd.ParameterOIDs) != 1 || pd.ParameterOIDs[0] != 23 {
+		t.Errorf("first message = %#v, want ParameterDescription [23]", msgs[0])
+	}
+	if rd, ok := msgs[1].(*pgproto3.RowDescription); !ok || len(rd.Fields) != 1 {
+		t.Errorf("second message = %#v, want RowDescription with one field", msgs[1])
+	}
+}
+
+// TestHandleMessageDescribe_PortalSendsOnlyRowShape asserts Describe('P') never sends ParameterDescription:
+// RowDescription (with the Bind result formats) when the portal returns rows, NoData otherwise.
+func TestHandleMessageDescribe_PortalSendsOnlyRowShape(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.SetPreparedStatement("ins", `INSERT INTO t (a) VALUES ($1) RETURNING "id"`)
+	p.SetPreparedStatement("del", `DELETE FROM t WHERE id = $1`)
+	p.BindPortal("p_ins", "ins", [][]byte{[]byte("1")}, nil, []int16{1})
+	p.BindPortal("p_del", "del", [][]byte{[]byte("1")}, nil)
+
+	msgs := describeReplies(t, p, &out, 'P', "p_ins")
+	if len(msgs) != 1 {
+		t.Fatalf("portal with RETURNING: got %d messages (%#v), want only RowDescription", len(msgs), msgs)
+	}
+	rd, ok := msgs[0].(*pgproto3.RowDescription)
+	if !ok || len(rd.Fields) != 1 || string(rd.Fields[0].Name) != "id" || rd.Fields[0].Format != 1 {
+		t.Errorf("portal with RETURNING = %#v, want RowDescription of binary \"id\"", msgs[0])
+	}
+
+	msgs = describeReplies(t, p, &out, 'P', "p_del")
+	if len(msgs) != 1 {
+		t.Fatalf("portal without rows: got %d messages (%#v), want only NoData", len(msgs), msgs)
+	}
+	if _, ok := msgs[0].(*pgproto3.NoData); !ok {
+		t.Errorf("portal without rows = %#v, want NoData", msgs[0])
+	}
+}
+
+// TestHandleMessageDescribe_UnknownObjects asserts the SQLSTATEs PostgreSQL uses for a Describe of a
+// statement (26000) or portal (34000) that does not exist.
+func TestHandleMessageDescribe_UnknownObjects(t *testing.T) {
+	tests := []struct {
+		objectType byte
+		wantCode   string
+	}{
+		{'S', "26000"},
+		{'P', "34000"},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		p := newBufferedProxyConnection(&out)
+		msgs := describeReplies(t, p, &out, tt.objectType, "missing")
+		if len(msgs) != 1 {
+			t.Fatalf("Describe(%c): got %d messages, want one ErrorResponse", tt.objectType, len(msgs))
+		}
+		if errResp, ok := msgs[0].(*pgproto3.ErrorResponse); !ok || errResp.Code != tt.wantCode {
+			t.Errorf("Describe(%c) of unknown name = %#v, want ErrorResponse %s", tt.objectType, msgs[0], tt.wantCode)
+		}
+	}
+}
+
+// TestHandleMessageDescribe_MultiStatementUsesCachedBackendTypes asserts Describe of a multi-statement
+// query reports the types cached at Parse (described on the backend), and ignores a description left by
+// an earlier single statement of the same name.
+func TestHandleMessageDescribe_MultiStatementUsesCachedBackendTypes(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	query := `DELETE FROM t; INSERT INTO t (a) VALUES ('x') RETURNING "uid"`
+	p.SetPreparedStatement("m1", query)
+	p.SetMultiStatement("m1")
+	p.SetStatementDescription("m1", &pgconn.StatementDescription{
+		SQL:    query,
+		Fields: []pgconn.FieldDescription{{Name: "uid", DataTypeOID: 2950, DataTypeSize: 16}},
+	})
+	msgs := describeReplies(t, p, &out, 'S', "m1")
+	if rd, ok := msgs[len(msgs)-1].(*pgproto3.RowDescription); !ok || len(rd.Fields) != 1 || rd.Fields[0].DataTypeOID != 2950 {
+		t.Errorf("Describe = %#v, want RowDescription with the cached uuid column", msgs)
+	}
+
+	p.SetStatementDescription("m1", &pgconn.StatementDescription{
+		SQL:    "SELECT now() AS uid",
+		Fields: []pgconn.FieldDescription{{Name: "uid", DataTypeOID: 1184}},
+	})
+	msgs = describeReplies(t, p, &out, 'S', "m1")
+	if rd, ok := msgs[len(msgs)-1].(*pgproto3.RowDescription); !ok || len(rd.Fields) != 1 || rd.Fields[0].DataTypeOID != 25 {
+		t.Errorf("stale description: Describe = %#v, want the text column derived from the SQL", msgs)
+	}
+}
+
+// TestDescribeRowFieldsForQuery_ReturningExpression asserts unaliased and aliased expressions are
+// described with PostgreSQL's column names instead of giving up on the whole RowDescription.
+func TestDescribeRowFieldsForQuery_ReturningExpression(t *testing.T) {
+	fields := DescribeRowFieldsForQuery(`UPDATE t SET a = a + 1 RETURNING id, a + b AS total, a * 2`)
+	if len(fields) != 3 {
+		t.Fatalf("expected 3 fields, got %d", len(fields))
+	}
+	for i, want := range []string{"id", "total", "?column?"} {
+		if got := string(fields[i].Name); got != want {
+			t.Errorf("field %d name = %q, want %q", i, got, want)
+		}
+	}
+}
+
+// TestLastStatementReturnsRows asserts a batch ending in RETURNING * is described on the backend at
+// Parse: its columns cannot be derived from the SQL, yet it returns rows.
+func TestLastStatementReturnsRows(t *testing.T) {
+	query := `DELETE FROM u; INSERT INTO t (a) VALUES ($1) RETURNING *`
+	if !lastStatementReturnsRows(query) {
+		t.Error("lastStatementReturnsRows = false for a batch ending in RETURNING *")
+	}
+	if sd := statementDescriptionFromQuery(query); len(sd.Fields) != 0 {
+		t.Errorf("Fields = %+v, want none (resolved by the backend)", sd.Fields)
+	}
+	if lastStatementReturnsRows(`INSERT INTO t (a) VALUES ($1) RETURNING *; DELETE FROM u`) {
+		t.Error("lastStatementReturnsRows = true for a batch ending in DELETE")
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"log"
+
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// DISCARD from connection poolers.
+//
+// Poolers send DISCARD ALL to clean a connection between checkouts. On the backend it would fail
+// (DISCARD ALL cannot run inside a transaction block, and the session always has its base transaction
+// open) or, for DISCARD TEMP, drop temporary tables every connection of the test ID sees. The proxy
+// answers DISCARD ALL, DISCARD PLANS and DISCARD TEMP itself and only cleans this connection's view:
+//
+//   - ALL: deallocates this connection's prepared statements (on the backend too), forgets its portals
+//     and drops its tracked SET/SET LOCAL values, so the next statement sees the defaults again.
+//   - PLANS: nothing to do; statements keep their backend names and plans are the backend's business.
+//   - TEMP: nothing is dropped; temporary tables belong to the shared backend session (a WARNING says so).
+//
+// DISCARD SEQUENCES is harmless inside a transaction and goes to the backend unchanged.
+
+// handleDiscard answers a single-statement DISCARD ALL / PLANS / TEMP without touching the shared
+// transaction. handled is false for anything else.
+func (p *proxyConnection) handleDiscard(session *TestSession, query string, sendReadyForQuery bool) (handled bool, err error) {
+	if session == nil || session.DB == nil {
+		return false, nil
+	}
+	stmts, parseErr := sql.ParseStatements(query)
+	if parseErr != nil || len(stmts) != 1 {
+		return false, nil
+	}
+	target := sql.DiscardTarget(stmts[0].Stmt)
+	switch target {
+	case "ALL":
+		if p.GetUserOpenTransactionCount() > 0 {
+			// Like PostgreSQL: the client's own BEGIN is still open.
+			return true, &pgconn.PgError{
+				Severity: "ERROR",
+				Code:     "25001",
+				Message:  "DISCARD ALL cannot run inside a transaction block",
+			}
+		}
+		p.recordQuery(session.DB, query)
+		p.discardConnectionState(session)
+	case "PLANS":
+		p.recordQuery(session.DB, query)
+	case "TEMP":
+		p.recordQuery(session.DB, query)
+		p.backend.Send(&pgproto3.NoticeResponse{
+			Severity: "WARNING",
+			Code:     "01000",
+			Message:  "DISCARD TEMP ignored by pgrollback: temporary tables are kept",
+			Detail:   "Every connection of the test ID shares one backend session, so its temporary tables belong to all of them.",
+			Hint:     "Drop the tables by name, or use \"pgrollback rollback\" to discard the whole base transaction.",
+		})
+	default:
+		return false, nil
+	}
+	p.completeClientVariableSet("DISCARD "+target, sendReadyForQuery)
+	return true, nil
+}
+
+// discardConnectionState is the proxy-local part of DISCARD ALL: this connection's prepared statements
+// (deallocated on the backend under LockRun), portals and tracked parameters.
+func (p *proxyConnection) discardConnectionState(session *TestSession) {
+	session.DB.LockRun()
+	p.deallocateBackendStatementsLocked(session.DB)
+	session.DB.UnlockRun()
+
+	p.mu.Lock()
+	p.gucs = nil
+	p.localGUCs = nil
+	p.mu.Unlock()
+
+	p.reportPreparedStatementCount(session.TestID)
+	log.Printf("[PROXY] DISCARD ALL: cleared the prepared statements, portals and parameters of conn %d (testID=%s)", p.connectionID(), session.TestID)
+}
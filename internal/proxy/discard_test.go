@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestHandleDiscardAll_ClearsOnlyThisConnection(t *testing.T) {
+	db := newTestSessionDB()
+	session := &TestSession{DB: db, TestID: "t1"}
+	var out, otherOut bytes.Buffer
+	p, other := newBufferedProxyConnection(&out), newBufferedProxyConnection(&otherOut)
+
+	prepareOnBackend(p, db, "s1", "SELECT 1")
+	p.BindPortal("portal", "s1", nil, nil)
+	otherName := prepareOnBackend(other, db, "s1", "SELECT 1")
+	if handled, err := p.handleClientVariableSet(session, "SET search_path TO app", false); !handled || err != nil {
+		t.Fatalf("SET: %v, %v", handled, err)
+	}
+	out.Reset()
+
+	if handled, err := p.handleDiscard(session, "DISCARD ALL", false); !handled || err != nil {
+		t.Fatalf("handleDiscard = %v, %v; want handled", handled, err)
+	}
+	if cc, ok := receiveOne(t, &out).(*pgproto3.CommandComplete); !ok || string(cc.CommandTag) != "DISCARD ALL" {
+		t.Fatalf("expected CommandComplete DISCARD ALL, got %#v", cc)
+	}
+	if _, ok := p.GetPreparedStatement("s1"); ok {
+		t.Error("prepared statement survived DISCARD ALL")
+	}
+	if _, bound := p.portalStatement("portal"); bound {
+		t.Error("portal survived DISCARD ALL")
+	}
+	if _, ok := db.ResolveBackendStatement(p.connectionID(), "s1"); ok {
+		t.Error("backend statement of this connection still tracked")
+	}
+	if stmt, ok := db.ResolveBackendStatement(other.connectionID(), "s1"); !ok || stmt.name != otherName {
+		t.Error("DISCARD ALL must leave the other connection's statement alone")
+	}
+	p.mu.Lock()
+	searchPath := p.desiredGUCsLocked()["search_path"]
+	p.mu.Unlock()
+	if searchPath != "RESET search_path" {
+		t.Errorf("search_path after DISCARD ALL = %q, want the default", searchPath)
+	}
+}
+
+func TestHandleDiscard_Targets(t *testing.T) {
+	session := &TestSession{DB: newTestSessionDB(), TestID: "t1"}
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+
+	if handled, err := p.handleDiscard(session, "DISCARD PLANS", false); !handled || err != nil {
+		t.Fatalf("DISCARD PLANS = %v, %v", handled, err)
+	}
+	if cc, ok := receiveOne(t, &out).(*pgproto3.CommandComplete); !ok || string(cc.CommandTag) != "DISCARD PLANS" {
+		t.Fatalf("expected CommandComplete DISCARD PLANS, got %#v", cc)
+	}
+
+	out.Reset()
+	if handled, err := p.handleDiscard(session, "DISCARD TEMP", false); !handled || err != nil {
+		t.Fatalf("DISCARD TEMP = %v, %v", handled, err)
+	}
+	frontend := pgproto3.NewFrontend(&out, nil)
+	if msg, err := frontend.Receive(); err != nil {
+		t.Fatal(err)
+	} else if n, ok := msg.(*pgproto3.NoticeResponse); !ok || n.Severity != "WARNING" {
+		t.Fatalf("DISCARD TEMP: expected a WARNING first, got %#v", msg)
+	}
+	if msg, err := frontend.Receive(); err != nil {
+		t.Fatal(err)
+	} else if cc, ok := msg.(*pgproto3.CommandComplete); !ok || string(cc.CommandTag) != "DISCARD TEMP" {
+		t.Fatalf("expected CommandComplete DISCARD TEMP, got %#v", msg)
+	}
+
+	for _, q := range []string{"DISCARD SEQUENCES", "SELECT 1", "DISCARD ALL; SELECT 1"} {
+		if handled, _ := p.handleDiscard(session, q, false); handled {
+			t.Errorf("%q must go to the backend", q)
+		}
+	}
+
+	p.IncrementUserOpenTransactionCount()
+	_, err := p.handleDiscard(session, "DISCARD ALL", false)
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "25001" {
+		t.Errorf("DISCARD ALL inside BEGIN = %v; want SQLSTATE 25001", err)
+	}
+}
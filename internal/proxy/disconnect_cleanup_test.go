@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestWaitForDisconnectCleanup_WaitsForCleanupToFinish(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	s := &Server{PgRollback: pgr}
+	session := &TestSession{TestID: "t1"}
+	pgr.SessionsByTestID["t1"] = session
+	c1, peer1 := net.Pipe()
+	c2, peer2 := net.Pipe()
+	defer peer1.Close()
+	defer peer2.Close()
+	session.registerProxyClient(c1)
+	session.registerProxyClient(c2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := s.WaitForDisconnectCleanup(ctx, "t1", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("with two clients connected err = %v, want deadline exceeded", err)
+	}
+
+	// c1 disconnects: unregistered, but still counted while its cleanup runs.
+	session.beginDisconnectCleanup(c1)
+	done := make(chan error, 1)
+	go func() { done <- s.WaitForDisconnectCleanup(context.Background(), "t1", 1) }()
+	select {
+	case err := <-done:
+		t.Fatalf("returned (%v) before the disconnect cleanup finished", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	session.endDisconnectCleanup()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("not woken when the disconnect cleanup finished")
+	}
+
+	if err := s.WaitForDisconnectCleanup(context.Background(), "missing", 0); err != nil {
+		t.Errorf("unknown test ID: %v, want nil", err)
+	}
+}
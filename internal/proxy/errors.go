@@ -1,6 +1,9 @@
 package proxy
 
 import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
 )
 
@@ -14,3 +17,56 @@ func sendErrorToClient(backend *pgproto3.Backend, message string) {
 	// Flush é necessário para garantir que a mensagem de erro seja enviada imediatamente
 	backend.Flush()
 }
+
+// errorResponseFor monta o ErrorResponse de err: XX000 por padrão, o SQLSTATE/hint específico
+// para erros conhecidos do proxy (ex.: TransactionInUseError → 55006 object_in_use), ou todos os
+// campos do erro original quando ele veio do PostgreSQL (*pgconn.PgError).
+func errorResponseFor(err error) *pgproto3.ErrorResponse {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return errorResponseFromPgError(pgErr)
+	}
+	resp := &pgproto3.ErrorResponse{
+		Severity: "ERROR",
+		Message:  err.Error(),
+		Code:     "XX000",
+	}
+	var inUse *TransactionInUseError
+	if errors.As(err, &inUse) {
+		resp.Code = "55006"
+		resp.Hint = inUse.Hint()
+	}
+	return resp
+}
+
+// errorResponseFromPgError copia um erro do backend para o cliente sem perder Detail, Hint,
+// Position, constraint etc. (psql e ORMs exibem esses campos).
+func errorResponseFromPgError(pgErr *pgconn.PgError) *pgproto3.ErrorResponse {
+	severity := pgErr.Severity
+	if severity == "" {
+		severity = "ERROR"
+	}
+	code := pgErr.Code
+	if code == "" {
+		code = "XX000"
+	}
+	return &pgproto3.ErrorResponse{
+		Severity:         severity,
+		Code:             code,
+		Message:          pgErr.Message,
+		Detail:           pgErr.Detail,
+		Hint:             pgErr.Hint,
+		Position:         pgErr.Position,
+		InternalPosition: pgErr.InternalPosition,
+		InternalQuery:    pgErr.InternalQuery,
+		Where:            pgErr.Where,
+		SchemaName:       pgErr.SchemaName,
+		TableName:        pgErr.TableName,
+		ColumnName:       pgErr.ColumnName,
+		DataTypeName:     pgErr.DataTypeName,
+		ConstraintName:   pgErr.ConstraintName,
+		File:             pgErr.File,
+		Line:             pgErr.Line,
+		Routine:          pgErr.Routine,
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestErrorResponseFor_CopiesPgErrorFields(t *testing.T) {
+	pgErr := &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           "23505",
+		Message:        `duplicate key value violates unique constraint "users_email_key"`,
+		Detail:         "Key (email)=(a@b.c) already exists.",
+		Hint:           "use another email",
+		Position:       12,
+		SchemaName:     "public",
+		TableName:      "users",
+		ColumnName:     "email",
+		ConstraintName: "users_email_key",
+	}
+	resp := errorResponseFor(fmt.Errorf("Safe exec failed: %w, sql: '''INSERT ...'''", pgErr))
+	if resp.Code != "23505" || resp.Message != pgErr.Message || resp.Severity != "ERROR" {
+		t.Errorf("resp = %+v, want the backend's code and message", resp)
+	}
+	if resp.Detail != pgErr.Detail || resp.Hint != pgErr.Hint || resp.Position != 12 {
+		t.Errorf("detail/hint/position not copied: %+v", resp)
+	}
+	if resp.ConstraintName != "users_email_key" || resp.SchemaName != "public" || resp.TableName != "users" || resp.ColumnName != "email" {
+		t.Errorf("schema/table/column/constraint not copied: %+v", resp)
+	}
+}
+
+func TestErrorResponseFor_UniqueViolationKeepsConstraint(t *testing.T) {
+	session := newGuardTestSession(t, "errors_unique_violation")
+	ctx := context.Background()
+	if _, err := session.DB.SafeExec(ctx, "CREATE TEMP TABLE pgrollback_err_unique (id int CONSTRAINT pgrollback_err_unique_pk PRIMARY KEY)"); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	if _, err := session.DB.SafeExec(ctx, "INSERT INTO pgrollback_err_unique VALUES (1)"); err != nil {
+		t.Fatalf("first insert: %v", err)
+	}
+	_, err := session.DB.SafeExec(ctx, "INSERT INTO pgrollback_err_unique VALUES (1)")
+	if err == nil {
+		t.Fatal("duplicate insert should fail")
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		t.Fatalf("err = %v, want a wrapped *pgconn.PgError", err)
+	}
+	resp := errorResponseFor(err)
+	if resp.Code != "23505" || resp.ConstraintName != "pgrollback_err_unique_pk" || resp.Detail == "" {
+		t.Errorf("resp = %+v, want 23505 with constraint pgrollback_err_unique_pk and a detail", resp)
+	}
+}
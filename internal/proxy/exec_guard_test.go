@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// wireBackend stands in for PostgreSQL at the protocol level, behind a real pgx connection over net.Pipe,
+// so the round trips of SafeExec can be counted. It answers simple Query messages only: one
+// CommandComplete per ";"-separated statement, an ErrorResponse (23505) for a statement containing
+// "fail", and 25P02 for anything but ROLLBACK once the transaction is aborted, skipping the rest of the
+// query as PostgreSQL does. A SELECT returns one row (the statement) first, so it fails after its
+// RowDescription. A statement containing "notice" raises a NOTICE whose message is the statement.
+// Every Query waits latency before the reply, like a network hop.
+type wireBackend struct {
+	latency time.Duration
+
+	mu      sync.Mutex
+	queries []string
+}
+
+// newWireSessionDB returns a realSessionDB with an open base transaction on a wireBackend; the BEGIN is
+// not counted among the backend's queries.
+func newWireSessionDB(tb testing.TB, latency time.Duration) (*realSessionDB, *wireBackend) {
+	tb.Helper()
+	b := &wireBackend{latency: latency}
+	notices := &backendNotices{}
+	ctx := context.Background()
+	conn, err := b.connect(ctx, notices)
+	if err != nil {
+		tb.Fatalf("connect to wire backend: %v", err)
+	}
+	tb.Cleanup(func() { conn.Close(ctx) })
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		tb.Fatalf("begin: %v", err)
+	}
+	b.reset()
+	d := newSessionDB(conn, tx, ctx)
+	d.notices = notices
+	return d, b
+}
+
+// connect opens a new pgx connection to the fake backend, over its own pipe.
+func (b *wireBackend) connect(ctx context.Context, notices *backendNotices) (*pgx.Conn, error) {
+	clientEnd, serverEnd := net.Pipe()
+	go b.serve(serverEnd)
+	config, err := pgx.ParseConfig("host=127.0.0.1 port=5432 user=u dbname=d sslmode=disable")
+	if err != nil {
+		return nil, err
+	}
+	config.DialFunc = func(ctx context.Context, network, addr string) (net.Conn, error) { return clientEnd, nil }
+	config.OnNotice = notices.onNotice
+	return pgx.ConnectConfig(ctx, config)
+}
+
+func (b *wireBackend) serve(conn net.Conn) {
+	defer conn.Close()
+	backend := pgproto3.NewBackend(conn, conn)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		return
+	}
+	backend.Send(&pgproto3.AuthenticationOk{})
+	backend.Send(&pgproto3.BackendKeyData{ProcessID: 1, SecretKey: 1})
+	backend.Send(&pgproto3.ReadyForQuery{TxStatus: 'I'})
+	if backend.Flush() != nil {
+		return
+	}
+	txStatus := byte('I')
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		query, ok := msg.(*pgproto3.Query)
+		if !ok {
+			return // Terminate
+		}
+		b.mu.Lock()
+		b.queries = append(b.queries, query.String)
+		b.mu.Unlock()
+		time.Sleep(b.latency)
+		for _, stmt := range strings.Split(query.String, ";") {
+			stmt = strings.ToLower(strings.TrimSpace(stmt))
+			if stmt == "" {
+				continue
+			}
+			if txStatus == 'E' && !strings.HasPrefix(stmt, "rollback") {
+				backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "25P02", Message: "current transaction is aborted"})
+				break
+			}
+			if strings.HasPrefix(stmt, "select") {
+				backend.Send(&pgproto3.RowDescription{Fields: []pgproto3.FieldDescription{{Name: []byte("v"), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1}}})
+				backend.Send(&pgproto3.DataRow{Values: [][]byte{[]byte(stmt)}})
+			}
+			if strings.Contains(stmt, "fail") {
+				backend.Send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "23505", Message: "duplicate key value"})
+				txStatus = 'E'
+				break
+			}
+			if strings.Contains(stmt, "notice") {
+				backend.Send(&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: stmt})
+			}
+			if stmt == "begin" || strings.HasPrefix(stmt, "rollback to") {
+				txStatus = 'T'
+			}
+			tag := strings.ToUpper(strings.Fields(stmt)[0])
+			if tag == "INSERT" {
+				tag = "INSERT 0 1"
+			}
+			backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+		}
+		backend.Send(&pgproto3.ReadyForQuery{TxStatus: txStatus})
+		if backend.Flush() != nil {
+			return
+		}
+	}
+}
+
+// Queries returns the Query messages received since the last reset, one per round trip.
+func (b *wireBackend) Queries() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.queries...)
+}
+
+func (b *wireBackend) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.queries = nil
+}
+
+func TestSafeExec_GuardInOneRoundTrip(t *testing.T) {
+	d, backend := newWireSessionDB(t, 0)
+
+	tag, err := d.SafeExec(context.Background(), "INSERT INTO t VALUES (1)")
+	if err != nil {
+		t.Fatalf("SafeExec: %v", err)
+	}
+	if tag.String() != "INSERT 0 1" {
+		t.Errorf("tag = %q, want the statement's INSERT 0 1", tag.String())
+	}
+	queries := backend.Queries()
+	if len(queries) != 1 {
+		t.Fatalf("round trips = %d %q, want 1", len(queries), queries)
+	}
+	if !strings.HasPrefix(queries[0], "SAVEPOINT "+execGuardSavepoint) || !strings.HasSuffix(queries[0], "RELEASE SAVEPOINT "+execGuardSavepoint) {
+		t.Errorf("query = %q, want the statement between its guard's SAVEPOINT and RELEASE", queries[0])
+	}
+}
+
+func TestSafeExec_FailedStatementRollsBackGuard(t *testing.T) {
+	d, backend := newWireSessionDB(t, 0)
+	ctx := context.Background()
+
+	_, err := d.SafeExec(ctx, "INSERT INTO t VALUES ('fail')")
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" {
+		t.Fatalf("SafeExec error = %v, want the statement's 23505", err)
+	}
+	queries := backend.Queries()
+	if want := "ROLLBACK TO SAVEPOINT " + execGuardSavepoint + "; RELEASE SAVEPOINT " + execGuardSavepoint; len(queries) != 2 || queries[1] != want {
+		t.Fatalf("round trips = %q, want the batch and then %q", queries, want)
+	}
+
+	if _, err := d.SafeExec(ctx, "INSERT INTO t VALUES (2)"); err != nil {
+		t.Errorf("statement after the failed one: %v (base transaction left aborted)", err)
+	}
+}
+
+func TestSafeExec_SelectFailingAfterRowsIsTheStatementsError(t *testing.T) {
+	d, backend := newWireSessionDB(t, 0)
+	ctx := context.Background()
+
+	tag, err := d.SafeExec(ctx, "SELECT 1")
+	if err != nil || tag.String() != "SELECT" {
+		t.Fatalf("SafeExec(SELECT 1) = %q, %v", tag, err)
+	}
+	_, err = d.SafeExec(ctx, "SELECT fail")
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "23505" || !strings.HasPrefix(err.Error(), "Safe exec failed") {
+		t.Fatalf("SafeExec error = %v, want the statement's own 23505 (not the guard's RELEASE)", err)
+	}
+	if queries := backend.Queries(); len(queries) != 3 || !strings.HasPrefix(queries[2], "ROLLBACK TO SAVEPOINT "+execGuardSavepoint) {
+		t.Errorf("round trips = %q, want the guard rolled back after the failed SELECT", queries)
+	}
+}
+
+// BenchmarkSafeExecRoundTrips runs one guarded INSERT per op against a backend 100µs away, with the guard
+// in separate round trips (SAVEPOINT, statement, RELEASE) and batched with the statement.
+func BenchmarkSafeExecRoundTrips(b *testing.B) {
+	const latency = 100 * time.Microsecond
+	run := func(b *testing.B, exec func(d *realSessionDB) error) {
+		d, backend := newWireSessionDB(b, latency)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := exec(d); err != nil {
+				b.Fatal(err)
+			}
+		}
+		b.StopTimer()
+		b.ReportMetric(float64(len(backend.Queries()))/float64(b.N), "roundtrips/op")
+	}
+	ctx := context.Background()
+	b.Run("separate", func(b *testing.B) {
+		run(b, func(d *realSessionDB) error {
+			d.mu.Lock()
+			defer d.mu.Unlock()
+			_, err := d.safeExecSeparateLocked(ctx, "INSERT INTO t VALUES (1)")
+			return err
+		})
+	})
+	b.Run("batched", func(b *testing.B) {
+		run(b, func(d *realSessionDB) error {
+			_, err := d.SafeExec(ctx, "INSERT INTO t VALUES (1)")
+			return err
+		})
+	})
+}
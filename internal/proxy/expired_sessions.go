@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	// expiredSessionGrace é por quanto tempo um testID reaped continua marcado como "expirado".
+	expiredSessionGrace = 10 * time.Minute
+	// maxExpiredSessions limita o conjunto; ao estourar, a entrada mais antiga é descartada.
+	maxExpiredSessions = 1024
+)
+
+// expiredSessionEntry records why and when a testID was reaped by CleanupExpiredSessions.
+type expiredSessionEntry struct {
+	expiredAt time.Time
+	idle      time.Duration
+}
+
+// expiredSessionSet is a bounded, short-lived set of testIDs reaped for inactivity. The next client that
+// connects with one of these testIDs gets a NoticeResponse explaining that its previous work was rolled back.
+type expiredSessionSet struct {
+	mu      sync.Mutex
+	entries map[string]expiredSessionEntry
+	grace   time.Duration
+	max     int
+}
+
+func newExpiredSessionSet() *expiredSessionSet {
+	return &expiredSessionSet{
+		entries: make(map[string]expiredSessionEntry),
+		grace:   expiredSessionGrace,
+		max:     maxExpiredSessions,
+	}
+}
+
+// add marks testID as expired after idle inactivity. Evicts stale entries and, if still full, the oldest one.
+func (s *expiredSessionSet) add(testID string, idle time.Duration, now time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictLocked(now)
+	if _, exists := s.entries[testID]; !exists && len(s.entries) >= s.max {
+		oldestID := ""
+		var oldest time.Time
+		for id, e := range s.entries {
+			if oldestID == "" || e.expiredAt.Before(oldest) {
+				oldestID, oldest = id, e.expiredAt
+			}
+		}
+		delete(s.entries, oldestID)
+	}
+	s.entries[testID] = expiredSessionEntry{expiredAt: now, idle: idle}
+}
+
+// take removes testID from the set and returns its entry; ok is false if absent or past the grace period.
+func (s *expiredSessionSet) take(testID string, now time.Time) (entry expiredSessionEntry, ok bool) {
+	if s == nil {
+		return expiredSessionEntry{}, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.evictLocked(now)
+	entry, ok = s.entries[testID]
+	delete(s.entries, testID)
+	return entry, ok
+}
+
+// evictLocked drops entries older than the grace period. Caller must hold s.mu.
+func (s *expiredSessionSet) evictLocked(now time.Time) {
+	for id, e := range s.entries {
+		if now.Sub(e.expiredAt) > s.grace {
+			delete(s.entries, id)
+		}
+	}
+}
+
+// takeExpiredSessionNotice returns the notice text for a client connecting to a testID that was recently
+// reaped for inactivity (consumed once), or "" if the testID was not reaped.
+func (p *PgRollback) takeExpiredSessionNotice(testID string) string {
+	entry, ok := p.expiredSessions.take(testID, time.Now())
+	if !ok {
+		return ""
+	}
+	return fmt.Sprintf("pgrollback: session %q expired after %s of inactivity, starting fresh (previous changes were rolled back)",
+		testID, entry.idle.Round(time.Second))
+}
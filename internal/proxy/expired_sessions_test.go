@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpiredSessionSet_TakeConsumesEntry(t *testing.T) {
+	s := newExpiredSessionSet()
+	now := time.Now()
+	s.add("t1", 90*time.Second, now)
+
+	entry, ok := s.take("t1", now)
+	if !ok || entry.idle != 90*time.Second {
+		t.Fatalf("take(t1) = %+v, %v; want idle=90s, true", entry, ok)
+	}
+	if _, ok := s.take("t1", now); ok {
+		t.Fatal("second take(t1) should report not found")
+	}
+}
+
+func TestExpiredSessionSet_EvictsAfterGrace(t *testing.T) {
+	s := newExpiredSessionSet()
+	now := time.Now()
+	s.add("t1", time.Minute, now)
+	if _, ok := s.take("t1", now.Add(s.grace+time.Second)); ok {
+		t.Fatal("entry older than grace period should be evicted")
+	}
+}
+
+func TestExpiredSessionSet_Bounded(t *testing.T) {
+	s := newExpiredSessionSet()
+	s.max = 3
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		s.add(fmt.Sprintf("t%d", i), time.Minute, now.Add(time.Duration(i)*time.Millisecond))
+	}
+	if len(s.entries) != 3 {
+		t.Fatalf("len(entries) = %d, want 3", len(s.entries))
+	}
+	if _, ok := s.take("t0", now); ok {
+		t.Error("oldest entry t0 should have been evicted")
+	}
+	if _, ok := s.take("t4", now); !ok {
+		t.Error("newest entry t4 should be present")
+	}
+}
+
+func TestTakeExpiredSessionNotice(t *testing.T) {
+	p := NewPgRollback("localhost", 5432, "postgres", "postgres", "", time.Minute, time.Hour, 0)
+	if msg := p.takeExpiredSessionNotice("t1"); msg != "" {
+		t.Fatalf("unexpected notice for never-expired testID: %q", msg)
+	}
+	p.expiredSessions.add("t1", 2*time.Minute, time.Now())
+	msg := p.takeExpiredSessionNotice("t1")
+	if !strings.Contains(msg, "expired after 2m0s of inactivity") {
+		t.Fatalf("notice = %q, want mention of idle duration", msg)
+	}
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Explain mode ("pgrollback explain on|off") shows how the proxy rewrites queries without running them.
+//
+// While it is on, each Simple Query is answered with one NOTICE per statement giving the SQL the proxy
+// would send to PostgreSQL in its place (BEGIN → SAVEPOINT, ROLLBACK → ROLLBACK TO SAVEPOINT …; RELEASE
+// SAVEPOINT …), or the no-op answered by the proxy itself, and then completes without touching the
+// backend or the session's levels. Statements of one query are explained in order, so "BEGIN; …;
+// ROLLBACK" shows the savepoint the ROLLBACK would go back to. pgrollback commands are explained too,
+// except "pgrollback explain", which always runs so the mode can be turned off.
+//
+// Explain mode is per test ID and off by default. The extended protocol (Parse, and Execute of a statement
+// prepared earlier) is refused while it is on, except for "pgrollback explain" itself: preparing or
+// executing a statement would run the rewrite for real.
+
+// errExplainExtendedQuery is sent for Parse and Execute while explain mode is on.
+var errExplainExtendedQuery = fmt.Errorf("pgrollback explain is on: only Simple Query is explained; run \"pgrollback explain off\" to prepare and execute statements")
+
+// handleExplainCommand runs "pgrollback explain on|off".
+func (p *PgRollback) handleExplainCommand(testID string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("uso: pgrollback explain on|off")
+	}
+	mode := strings.ToLower(args[0])
+	if mode != "on" && mode != "off" {
+		return "", fmt.Errorf("uso: pgrollback explain on|off")
+	}
+	session := p.GetSession(testID)
+	if session == nil {
+		return "", fmt.Errorf("sessão não encontrada para testID: %s", testID)
+	}
+	session.SetExplain(mode == "on")
+	return "SELECT 1", nil
+}
+
+// SetExplain turns explain mode on or off for the session.
+func (s *TestSession) SetExplain(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.explain != on {
+		log.Printf("[PGROLLBACK] explain mode %v for testID=%s", on, s.TestID)
+	}
+	s.explain = on
+}
+
+// IsExplain reports whether explain mode is on.
+func (s *TestSession) IsExplain() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.explain
+}
+
+// isExplainCommand reports whether query is "pgrollback explain …", which runs even in explain mode.
+func isExplainCommand(query string) bool {
+	fields := strings.Fields(query)
+	return len(fields) >= 2 && strings.EqualFold(fields[0], "pgrollback") && strings.EqualFold(fields[1], "explain")
+}
+
+// queryExplanation is what the proxy would do with one statement of a client query.
+type queryExplanation struct {
+	statement string // the client's statement
+	rewritten string // SQL sent to PostgreSQL instead; "" when the proxy answers on its own
+	note      string // what the proxy does itself, when rewritten is not the whole story
+}
+
+// explainQuery returns, statement by statement, what InterceptQuery would turn query into, without any of
+// its side effects: no claim, no base transaction restart, no session created or changed.
+func (s *TestSession) explainQuery(query string) []queryExplanation {
+	s.mu.RLock()
+	db, persist := s.DB, s.persist
+	s.mu.RUnlock()
+	level := 0
+	if db != nil {
+		level = db.GetSavepointLevel()
+	}
+
+	var out []queryExplanation
+	for _, stmt := range explainStatements(query) {
+		e := queryExplanation{statement: stmt}
+		if fields := strings.Fields(stmt); len(fields) > 0 && strings.EqualFold(fields[0], "pgrollback") {
+			explainPgRollbackCommand(&e, db, level, fields)
+			out = append(out, e)
+			continue
+		}
+		kind, _, _ := classifyClientTCL(stmt)
+		switch {
+		case kind == clientOther:
+			e.rewritten = stmt
+		case persist:
+			e.rewritten, e.note = explainPersistTCL(kind)
+		case db == nil:
+			e.note = "fails: the session has no backend connection"
+		case kind == clientBegin:
+			e.rewritten = db.beginRewrite(level)
+			if level == 0 {
+				level++
+			}
+		case kind == clientCommit:
+			e.rewritten = db.commitRewrite(level)
+			level = max(level-1, 0)
+		case kind == clientRollback:
+			e.rewritten = db.rollbackRewrite(level)
+			level = max(level-1, 0)
+		}
+		out = append(out, e)
+	}
+	return out
+}
+
+// explainStatements splits a client query into the statements the proxy would handle one by one.
+func explainStatements(query string) []string {
+	if strings.HasPrefix(strings.ToUpper(strings.TrimSpace(query)), "PGROLLBACK") {
+		return []string{strings.TrimSpace(query)}
+	}
+	var parts []string
+	if stmts, err := sql.ParseStatements(query); err == nil {
+		for _, raw := range stmts {
+			if c := sql.CommandStringFromRaw(query, raw); c != "" {
+				parts = append(parts, c)
+			}
+		}
+		return parts
+	}
+	for _, part := range sql.SplitCommandsFallback(query) {
+		if t := strings.TrimSpace(part); t != "" {
+			parts = append(parts, t)
+		}
+	}
+	return parts
+}
+
+// explainPgRollbackCommand fills e for a pgrollback command (fields of the statement).
+func explainPgRollbackCommand(e *queryExplanation, db *realSessionDB, level int, fields []string) {
+	action := ""
+	if len(fields) > 1 {
+		action = strings.ToLower(fields[1])
+	}
+	switch action {
+	case "reset":
+		if db != nil {
+			e.rewritten = db.resetRewrite(level)
+		}
+		e.note = "discards every open BEGIN; the base transaction is kept"
+	case "rollback":
+		e.note = "rolls back the base transaction and begins a new one"
+	case "status", "list", "history", "whoami", "cleanup":
+		e.note = "result set built by the proxy"
+	case "persist", "savepoint", "release":
+		e.rewritten = "SELECT 1"
+		e.note = "handled by the proxy (pgrollback " + action + ")"
+	default:
+		e.rewritten = DEFAULT_SELECT_ONE
+		e.note = "handled by the proxy (pgrollback " + action + ")"
+	}
+}
+
+// explainPersistTCL describes BEGIN/COMMIT/ROLLBACK in persist mode, where they act on the base transaction.
+func explainPersistTCL(kind clientTCL) (string, string) {
+	switch kind {
+	case clientCommit:
+		return "", "persist mode: COMMIT of the base transaction, then a new one begins"
+	case clientRollback:
+		return "", "persist mode: ROLLBACK of the base transaction, then a new one begins"
+	default:
+		return DEFAULT_SELECT_ONE, "persist mode: the base transaction is already open"
+	}
+}
+
+// message renders e for the NOTICE sent to the client.
+func (e queryExplanation) message() string {
+	target := e.rewritten
+	switch target {
+	case "":
+		target = "nothing sent to PostgreSQL"
+	case DEFAULT_SELECT_ONE:
+		target = "SELECT 1 (answered without running anything)"
+	}
+	if e.note != "" {
+		target += "; " + e.note
+	}
+	return fmt.Sprintf("pgrollback explain: %s → %s", e.statement, target)
+}
+
+// sendExplain answers a Simple Query in explain mode: one NOTICE per statement, then an empty result.
+func (p *proxyConnection) sendExplain(session *TestSession, query string) {
+	for _, e := range session.explainQuery(query) {
+		p.backend.Send(&pgproto3.NoticeResponse{Severity: "NOTICE", Code: "00000", Message: e.message()})
+	}
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 0")})
+	p.SendReadyForQuery()
+}
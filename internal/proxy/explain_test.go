@@ -0,0 +1,155 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// explainNotices runs query as a Simple Query and returns the NOTICE messages sent before ReadyForQuery.
+func explainNotices(t *testing.T, p *proxyConnection, out *bytes.Buffer, query string) []string {
+	t.Helper()
+	out.Reset()
+	if err := p.ProcessSimpleQuery("t1", query); err != nil {
+		t.Fatalf("ProcessSimpleQuery(%q) = %v", query, err)
+	}
+	frontend := pgproto3.NewFrontend(out, nil)
+	var notices []string
+	for {
+		msg, err := frontend.Receive()
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("%q: no ReadyForQuery", query)
+		}
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		switch m := msg.(type) {
+		case *pgproto3.NoticeResponse:
+			notices = append(notices, m.Message)
+		case *pgproto3.ReadyForQuery:
+			return notices
+		}
+	}
+}
+
+func TestExplainMode_ShowsRewriteWithoutRunning(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	db := newTestSessionDB()
+	session := &TestSession{DB: db, TestID: "t1"}
+	pgr.SessionsByTestID["t1"] = session
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: pgr}
+
+	for _, q := range []string{"pgrollback explain", "pgrollback explain maybe"} {
+		if _, err := pgr.InterceptQuery("t1", q, 0); err == nil || !strings.Contains(err.Error(), "uso:") {
+			t.Errorf("InterceptQuery(%q) err = %v; want a usage error", q, err)
+		}
+	}
+	if session.IsExplain() {
+		t.Fatal("explain mode must be off by default")
+	}
+	if got, err := pgr.InterceptQuery("t1", "pgrollback explain on", 0); err != nil || got != "SELECT 1" || !session.IsExplain() {
+		t.Fatalf("explain on = %q, %v (on=%v)", got, err, session.IsExplain())
+	}
+
+	notices := explainNotices(t, p, &out, "BEGIN; INSERT INTO t VALUES (1); ROLLBACK")
+	want := []string{
+		"BEGIN → SAVEPOINT pgrollback_v_1",
+		"INSERT INTO t VALUES (1) → INSERT INTO t VALUES (1)",
+		"ROLLBACK → ROLLBACK TO SAVEPOINT pgrollback_v_1; RELEASE SAVEPOINT pgrollback_v_1",
+	}
+	if len(notices) != len(want) {
+		t.Fatalf("notices = %q, want %d", notices, len(want))
+	}
+	for i, w := range want {
+		if notices[i] != "pgrollback explain: "+w {
+			t.Errorf("notice %d = %q, want %q", i, notices[i], "pgrollback explain: "+w)
+		}
+	}
+	if db.GetSavepointLevel() != 0 || db.connectionWithOpenTx != 0 {
+		t.Error("explain mode must not change the session")
+	}
+
+	notices = explainNotices(t, p, &out, "pgrollback persist on")
+	if len(notices) != 1 || !strings.Contains(notices[0], "→ SELECT 1; handled by the proxy") || session.IsPersist() {
+		t.Errorf("pgrollback persist on explained as %q (persist=%v)", notices, session.IsPersist())
+	}
+	notices = explainNotices(t, p, &out, "COMMIT")
+	if len(notices) != 1 || !strings.Contains(notices[0], "SELECT 1 (answered without running anything)") {
+		t.Errorf("COMMIT with no BEGIN explained as %q", notices)
+	}
+
+	// "pgrollback explain off" runs for real.
+	if got, err := pgr.InterceptQuery("t1", "pgrollback explain off", 0); err != nil || got != "SELECT 1" || session.IsExplain() {
+		t.Fatalf("explain off = %q, %v (on=%v)", got, err, session.IsExplain())
+	}
+}
+
+func TestExplainQuery_FollowsOpenLevels(t *testing.T) {
+	db := newTestSessionDB()
+	db.SavepointLevel.Store(1)
+	db.savepointPrefix = "app_tx_"
+	session := &TestSession{DB: db, TestID: "t1"}
+
+	if got := session.explainQuery("pgrollback reset"); len(got) != 1 || got[0].rewritten != "ROLLBACK TO SAVEPOINT app_tx_1; RELEASE SAVEPOINT app_tx_1" {
+		t.Errorf("pgrollback reset = %+v, want the rollback to app_tx_1", got)
+	}
+	got := session.explainQuery("COMMIT; COMMIT")
+	if len(got) != 2 || got[0].rewritten != "RELEASE SAVEPOINT app_tx_1" || got[1].rewritten != DEFAULT_SELECT_ONE {
+		t.Errorf("explainQuery = %+v; want the first COMMIT released and the second a no-op", got)
+	}
+	if db.GetSavepointLevel() != 1 {
+		t.Errorf("SavepointLevel = %d, want 1", db.GetSavepointLevel())
+	}
+}
+
+func TestExplainMode_RefusesExtendedQueryButExplainCommand(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	db, backend := newWireSessionDB(t, 0)
+	session := &TestSession{DB: db, TestID: "t1"}
+	pgr.SessionsByTestID["t1"] = session
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: pgr}
+	session.SetExplain(true)
+
+	// Prepared before explain mode was turned on: Execute must not run it.
+	p.SetPreparedStatement("s1", "INSERT INTO t VALUES (1)")
+	p.handleMessageBind(&pgproto3.Bind{DestinationPortal: "p1", PreparedStatement: "s1"})
+	p.handleMessageExecute("t1", &pgproto3.Execute{Portal: "p1"})
+	p.handleMessageSync()
+	p.handleMessageParse("t1", &pgproto3.Parse{Name: "s2", Query: "INSERT INTO t VALUES (2)"})
+	p.handleMessageSync()
+	if q := backend.Queries(); len(q) != 0 {
+		t.Errorf("backend got %q in explain mode, want nothing", q)
+	}
+	frontend := pgproto3.NewFrontend(&out, nil)
+	var errs []string
+	for ready := 0; ready < 2; {
+		msg, err := frontend.Receive()
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		switch m := msg.(type) {
+		case *pgproto3.ErrorResponse:
+			errs = append(errs, m.Message)
+		case *pgproto3.ReadyForQuery:
+			ready++
+		}
+	}
+	if len(errs) != 2 || !strings.Contains(errs[0], "pgrollback explain is on") || errs[0] != errs[1] {
+		t.Errorf("errors = %q, want the explain mode error for Execute and for Parse", errs)
+	}
+
+	// "pgrollback explain off" is accepted, so the mode can be turned off over the extended protocol.
+	p.handleMessageParse("t1", &pgproto3.Parse{Name: "e", Query: "pgrollback explain off"})
+	if session.IsExplain() || !p.isExplainCommandStatement("e") {
+		t.Errorf("Parse of pgrollback explain off: explain=%v, marked=%v; want off and marked", session.IsExplain(), p.isExplainCommandStatement("e"))
+	}
+}
@@ -0,0 +1,227 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	sqlpkg "pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// fakeBackend is an in-memory stand-in for the session's PostgreSQL transaction, so savepoint tracking
+// can be tested without a database. It records every SQL text it is given and keeps the stack of open
+// savepoints the way PostgreSQL would: SAVEPOINT pushes, RELEASE pops the named savepoint and everything
+// above it, ROLLBACK TO pops only what is above it, and an unknown name fails with 3B001. Other
+// statements are only recorded; queries return no rows.
+type fakeBackend struct {
+	mu         sync.Mutex
+	executed   []string
+	savepoints []string
+	nested     int // pseudo nested transactions started by Begin, for their sp_N names (as pgx names them)
+
+	// latency is waited before every statement, like a network hop, so concurrent callers get the chance
+	// to interleave. Set it before the backend is shared.
+	latency time.Duration
+}
+
+// newFakeSessionDB returns a realSessionDB whose transaction is a fakeBackend, plus the backend so tests
+// can look at what ran. It has no *pgx.Conn: nothing that goes through the PgConn (multi-statement
+// batches, prepared statements, COPY, keepalive) works on it.
+func newFakeSessionDB() (*realSessionDB, *fakeBackend) {
+	b := &fakeBackend{}
+	d := newSessionDB(nil, &fakeTx{backend: b}, context.Background())
+	return d, b
+}
+
+// Executed returns the SQL texts run so far, oldest first.
+func (b *fakeBackend) Executed() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.executed...)
+}
+
+// Savepoints returns the open savepoints, outermost first.
+func (b *fakeBackend) Savepoints() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]string(nil), b.savepoints...)
+}
+
+// exec records query and applies its transaction control statements to the savepoint stack.
+func (b *fakeBackend) exec(query string) (pgconn.CommandTag, error) {
+	time.Sleep(b.latency)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.executed = append(b.executed, query)
+	stmts, err := sqlpkg.ParseStatements(query)
+	if err != nil {
+		return pgconn.CommandTag{}, &pgconn.PgError{Severity: "ERROR", Code: "42601", Message: err.Error()}
+	}
+	tag := ""
+	for _, raw := range stmts {
+		stmt := raw.Stmt
+		name := sqlpkg.GetSavepointName(stmt)
+		switch {
+		case sqlpkg.IsSavepoint(stmt):
+			b.savepoints = append(b.savepoints, name)
+		case sqlpkg.IsReleaseSavepoint(stmt), sqlpkg.IsRollbackToSavepoint(stmt):
+			i := b.findSavepointLocked(name)
+			if i < 0 {
+				return pgconn.CommandTag{}, &pgconn.PgError{Severity: "ERROR", Code: "3B001", Message: fmt.Sprintf("savepoint \"%s\" does not exist", name)}
+			}
+			if sqlpkg.IsRollbackToSavepoint(stmt) {
+				i++
+			}
+			b.savepoints = b.savepoints[:i]
+		}
+		tag = sqlpkg.ClassifyStatement(stmt)
+	}
+	return pgconn.NewCommandTag(tag), nil
+}
+
+func (b *fakeBackend) findSavepointLocked(name string) int {
+	for i := len(b.savepoints) - 1; i >= 0; i-- {
+		if b.savepoints[i] == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// fakeTx is the pgx.Tx of a fakeBackend: the base transaction when savepoint is "", otherwise a pseudo
+// nested transaction started by Begin (the guards of SafeExec and SafeExecTCL).
+type fakeTx struct {
+	backend   *fakeBackend
+	savepoint string
+	closed    bool
+}
+
+var _ pgx.Tx = (*fakeTx)(nil)
+
+func (t *fakeTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	if t.closed {
+		return nil, pgx.ErrTxClosed
+	}
+	t.backend.mu.Lock()
+	t.backend.nested++
+	name := fmt.Sprintf("sp_%d", t.backend.nested)
+	t.backend.mu.Unlock()
+	if _, err := t.backend.exec("savepoint " + name); err != nil {
+		return nil, err
+	}
+	return &fakeTx{backend: t.backend, savepoint: name}, nil
+}
+
+func (t *fakeTx) Commit(ctx context.Context) error {
+	if t.closed {
+		return pgx.ErrTxClosed
+	}
+	t.closed = true
+	if t.savepoint != "" {
+		_, err := t.backend.exec("release savepoint " + t.savepoint)
+		return err
+	}
+	_, err := t.backend.exec("COMMIT")
+	t.backend.mu.Lock()
+	t.backend.savepoints = nil
+	t.backend.mu.Unlock()
+	return err
+}
+
+func (t *fakeTx) Rollback(ctx context.Context) error {
+	if t.closed {
+		return pgx.ErrTxClosed
+	}
+	t.closed = true
+	if t.savepoint != "" {
+		_, err := t.backend.exec("rollback to savepoint " + t.savepoint)
+		return err
+	}
+	_, err := t.backend.exec("ROLLBACK")
+	t.backend.mu.Lock()
+	t.backend.savepoints = nil
+	t.backend.mu.Unlock()
+	return err
+}
+
+func (t *fakeTx) Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error) {
+	if t.closed {
+		return pgconn.CommandTag{}, pgx.ErrTxClosed
+	}
+	return t.backend.exec(sql)
+}
+
+func (t *fakeTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	tag, err := t.Exec(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{tag: tag}, nil
+}
+
+func (t *fakeTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	_, err := t.Exec(ctx, sql, args...)
+	return fakeRow{err: err}
+}
+
+var errFakeBackendUnsupported = errors.New("not supported by the in-memory test backend")
+
+func (t *fakeTx) CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error) {
+	return 0, errFakeBackendUnsupported
+}
+
+func (t *fakeTx) SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults { return nil }
+
+func (t *fakeTx) LargeObjects() pgx.LargeObjects { return pgx.LargeObjects{} }
+
+func (t *fakeTx) Prepare(ctx context.Context, name, sql string) (*pgconn.StatementDescription, error) {
+	return nil, errFakeBackendUnsupported
+}
+
+func (t *fakeTx) Conn() *pgx.Conn { return nil }
+
+// fakeRows is an empty result set.
+type fakeRows struct {
+	tag    pgconn.CommandTag
+	closed bool
+}
+
+func (r *fakeRows) Close()                                       { r.closed = true }
+func (r *fakeRows) Err() error                                   { return nil }
+func (r *fakeRows) CommandTag() pgconn.CommandTag                { return r.tag }
+func (r *fakeRows) FieldDescriptions() []pgconn.FieldDescription { return nil }
+func (r *fakeRows) Next() bool                                   { return false }
+func (r *fakeRows) Scan(dest ...any) error                       { return pgx.ErrNoRows }
+func (r *fakeRows) Values() ([]any, error)                       { return nil, pgx.ErrNoRows }
+func (r *fakeRows) RawValues() [][]byte                          { return nil }
+func (r *fakeRows) Conn() *pgx.Conn                              { return nil }
+
+// fakeRow is the result of QueryRow: never a row.
+type fakeRow struct{ err error }
+
+func (r fakeRow) Scan(dest ...any) error {
+	if r.err != nil {
+		return r.err
+	}
+	return pgx.ErrNoRows
+}
+
+// newFakeTestSession registers a session for testID backed by newFakeSessionDB, replacing any session
+// already registered under that ID.
+func (p *PgRollback) newFakeTestSession(testID string) (*TestSession, *fakeBackend) {
+	db, backend := newFakeSessionDB()
+	db.beginWaitTimeout = p.BeginWaitTimeout
+	db.savepointPrefix = p.SavepointPrefix
+	now := time.Now()
+	session := &TestSession{DB: db, TestID: testID, CreatedAt: now, LastActivity: now}
+	p.mu.Lock()
+	p.SessionsByTestID[testID] = session
+	p.peakSessions = max(p.peakSessions, len(p.SessionsByTestID))
+	p.mu.Unlock()
+	return session, backend
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFakeBackend_SavepointStack(t *testing.T) {
+	_, b := newFakeSessionDB()
+	for _, q := range []string{"SAVEPOINT a", "SAVEPOINT b", "SAVEPOINT c", "ROLLBACK TO SAVEPOINT b", "INSERT INTO t VALUES (1)"} {
+		if _, err := b.exec(q); err != nil {
+			t.Fatalf("%s: %v", q, err)
+		}
+	}
+	if got := b.Savepoints(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("after ROLLBACK TO b: savepoints = %v, want [a b]", got)
+	}
+	if _, err := b.exec("RELEASE SAVEPOINT a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Savepoints(); len(got) != 0 {
+		t.Errorf("RELEASE a must release b too; savepoints = %v", got)
+	}
+	if _, err := b.exec("RELEASE SAVEPOINT a"); err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("RELEASE of a released savepoint = %v; want 3B001", err)
+	}
+	if n := len(b.Executed()); n != 7 {
+		t.Errorf("recorded %d statements, want 7", n)
+	}
+}
+
+// TestTransactionFlow_OnFakeBackend runs BEGIN / INSERT / ROLLBACK / BEGIN / COMMIT the way ForwardCommandToDB
+// does (InterceptQuery, SafeExecTCL or SafeExec, ApplyTCLSuccessTracking) and checks that the proxy's levels
+// follow the savepoints really open on the backend.
+func TestTransactionFlow_OnFakeBackend(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	session, backend := pgr.newFakeTestSession("t1")
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: pgr}
+	run := func(query string) error {
+		intercepted, err := pgr.InterceptQuery("t1", query, p.connectionID())
+		if err != nil {
+			return err
+		}
+		if session.DB.IsUserBeginQuery(intercepted) {
+			if err := session.DB.ClaimOpenTransaction(p.connectionID(), ""); err != nil {
+				return err
+			}
+		}
+		switch {
+		case intercepted == DEFAULT_SELECT_ONE:
+			return nil
+		case strings.Contains(strings.ToUpper(intercepted), "SAVEPOINT"):
+			_, err = session.DB.SafeExecTCL(session.Context(), intercepted)
+		default:
+			_, err = session.DB.SafeExec(session.Context(), intercepted)
+		}
+		if err != nil {
+			return err
+		}
+		return p.ApplyTCLSuccessTracking(intercepted, session)
+	}
+
+	steps := []struct {
+		query      string
+		level      int
+		savepoints []string
+	}{
+		{"BEGIN", 1, []string{"pgrollback_v_1"}},
+		{"INSERT INTO t VALUES (1)", 1, []string{"pgrollback_v_1"}},
+		{"ROLLBACK", 0, nil},
+		{"BEGIN", 1, []string{"pgrollback_v_1"}},
+		{"SAVEPOINT app_sp", 1, []string{"pgrollback_v_1", "app_sp"}},
+		{"COMMIT", 0, nil},
+	}
+	for _, st := range steps {
+		if err := run(st.query); err != nil {
+			t.Fatalf("%s: %v", st.query, err)
+		}
+		if got := session.DB.GetSavepointLevel(); got != st.level {
+			t.Errorf("after %s: level = %d, want %d", st.query, got, st.level)
+		}
+		if got := backend.Savepoints(); len(got) != len(st.savepoints) || (len(got) > 0 && !reflect.DeepEqual(got, st.savepoints)) {
+			t.Errorf("after %s: backend savepoints = %v, want %v", st.query, got, st.savepoints)
+		}
+	}
+	if p.GetUserOpenTransactionCount() != 0 || session.DB.connectionWithOpenTx != 0 {
+		t.Error("the transaction claim must be released after COMMIT")
+	}
+	executed := strings.Join(backend.Executed(), "\n")
+	for _, want := range []string{"SAVEPOINT pgrollback_v_1", "INSERT INTO t VALUES (1)", "ROLLBACK TO SAVEPOINT pgrollback_v_1; RELEASE SAVEPOINT pgrollback_v_1", "RELEASE SAVEPOINT pgrollback_v_1"} {
+		if !strings.Contains(executed, want) {
+			t.Errorf("backend never ran %q; ran:\n%s", want, executed)
+		}
+	}
+}
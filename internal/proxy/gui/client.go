@@ -0,0 +1,123 @@
+package gui
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clientTimeout bounds each request of a Client using the default HTTP client.
+const clientTimeout = 10 * time.Second
+
+// Client calls the session API of a running proxy (GET /api/sessions, POST /api/sessions/{testID}/rollback,
+// GET /api/server), for command-line tools and CI scripts that clean up sessions without a SQL client.
+type Client struct {
+	BaseURL    string       // e.g. http://127.0.0.1:5432
+	AdminToken string       // gui.admin_token, sent as a Bearer token on administrative calls; "" = none
+	HTTPClient *http.Client // nil = a client with clientTimeout
+}
+
+// NewClient returns a Client for the proxy listening on host:port (proxy.listen_host / listen_port).
+// A wildcard or empty host is reached through the loopback address.
+func NewClient(host string, port int, adminToken string) *Client {
+	switch host {
+	case "", "0.0.0.0", "::", "[::]":
+		host = "127.0.0.1"
+	}
+	return &Client{
+		BaseURL:    "http://" + net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port)),
+		AdminToken: adminToken,
+	}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return &http.Client{Timeout: clientTimeout}
+}
+
+// Sessions returns every live session, as GET /api/sessions does.
+func (c *Client) Sessions(ctx context.Context) ([]SessionInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/sessions", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("list sessions: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("list sessions: %s", responseError(resp))
+	}
+	var list []SessionInfo
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("list sessions: invalid response: %w", err)
+	}
+	return list, nil
+}
+
+// ServerStatus returns the proxy's operational summary, as GET /api/server does.
+func (c *Client) ServerStatus(ctx context.Context) (ServerStatus, error) {
+	var status ServerStatus
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+"/api/server", nil)
+	if err != nil {
+		return status, err
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return status, fmt.Errorf("server status: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, fmt.Errorf("server status: %s", responseError(resp))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("server status: invalid response: %w", err)
+	}
+	return status, nil
+}
+
+// RollbackSession force-rolls back the session of testID (its clients are disconnected), as
+// POST /api/sessions/{testID}/rollback does.
+func (c *Client) RollbackSession(ctx context.Context, testID string) error {
+	endpoint := c.BaseURL + "/api/sessions/" + url.PathEscape(testID) + "/rollback"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	if c.AdminToken != "" {
+		req.Header.Set("Authorization", "Bearer "+c.AdminToken)
+	}
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return fmt.Errorf("rollback session %s: %w", testID, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNotFound {
+		var body SessionRollbackResponse
+		if err := json.NewDecoder(resp.Body).Decode(&body); err == nil && body.RolledBack {
+			return nil
+		} else if err == nil && body.Error != "" {
+			return fmt.Errorf("rollback session %s: %s", testID, body.Error)
+		}
+	}
+	return fmt.Errorf("rollback session %s: %s", testID, responseError(resp))
+}
+
+// responseError describes a failed API response by its status and (short) body.
+func responseError(resp *http.Response) string {
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if msg := strings.TrimSpace(string(body)); msg != "" {
+		return resp.Status + ": " + msg
+	}
+	return resp.Status
+}
@@ -0,0 +1,86 @@
+package gui
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pgrollback/internal/config"
+)
+
+func TestNewClient_WildcardHostUsesLoopback(t *testing.T) {
+	for host, want := range map[string]string{
+		"":          "http://127.0.0.1:6432",
+		"0.0.0.0":   "http://127.0.0.1:6432",
+		"::":        "http://127.0.0.1:6432",
+		"localhost": "http://localhost:6432",
+		"::1":       "http://[::1]:6432",
+	} {
+		if got := NewClient(host, 6432, "").BaseURL; got != want {
+			t.Errorf("NewClient(%q).BaseURL = %s, want %s", host, got, want)
+		}
+	}
+}
+
+func TestClient_SessionsAndRollback(t *testing.T) {
+	provider := &mockProvider{sessions: []SessionInfo{{TestID: "suite a", SavepointLevel: 1, OpenUserTx: true}}}
+	srv := httptest.NewServer(NewMux(provider))
+	defer srv.Close()
+	c := &Client{BaseURL: srv.URL}
+	ctx := context.Background()
+
+	list, err := c.Sessions(ctx)
+	if err != nil || len(list) != 1 || list[0].TestID != "suite a" || !list[0].OpenUserTx {
+		t.Fatalf("Sessions = %+v, %v", list, err)
+	}
+	if err := c.RollbackSession(ctx, "suite a"); err != nil {
+		t.Fatalf("RollbackSession: %v", err)
+	}
+	if len(provider.destroyed) != 1 || provider.destroyed[0] != "suite a" {
+		t.Errorf("destroyed = %v, want [suite a]", provider.destroyed)
+	}
+
+	provider.destroyErr = errors.New("session not found for test_id: gone")
+	if err := c.RollbackSession(ctx, "gone"); err == nil || !strings.Contains(err.Error(), "session not found") {
+		t.Errorf("RollbackSession(unknown) = %v, want the server's error", err)
+	}
+}
+
+func TestClient_RollbackSendsAdminToken(t *testing.T) {
+	config.Init()
+	prev, hadPrev := config.GetCfgIfSet()
+	config.SetConfig(&config.Config{GUI: config.GUIConfig{AdminToken: "s3cret"}})
+	t.Cleanup(func() {
+		if hadPrev {
+			config.SetConfig(prev)
+		} else {
+			config.SetConfig(nil)
+		}
+	})
+
+	srv := httptest.NewServer(NewMux(&mockProvider{}))
+	defer srv.Close()
+	if err := (&Client{BaseURL: srv.URL}).RollbackSession(context.Background(), "t1"); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("without token: err = %v, want 401", err)
+	}
+	if err := (&Client{BaseURL: srv.URL, AdminToken: "s3cret"}).RollbackSession(context.Background(), "t1"); err != nil {
+		t.Errorf("with token: %v", err)
+	}
+}
+
+func TestClient_ServerStatus(t *testing.T) {
+	srv := httptest.NewServer(NewMux(&serverStatusProvider{status: ServerStatus{Version: "v1", Uptime: "1m0s", ActiveSessions: 1}}))
+	defer srv.Close()
+	st, err := (&Client{BaseURL: srv.URL}).ServerStatus(context.Background())
+	if err != nil || st.Version != "v1" || st.Uptime != "1m0s" || st.ActiveSessions != 1 {
+		t.Fatalf("ServerStatus = %+v, %v", st, err)
+	}
+
+	plain := httptest.NewServer(NewMux(&mockProvider{}))
+	defer plain.Close()
+	if _, err := (&Client{BaseURL: plain.URL}).ServerStatus(context.Background()); err == nil {
+		t.Error("ServerStatus against a proxy without /api/server succeeded")
+	}
+}
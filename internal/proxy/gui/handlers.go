@@ -1,9 +1,13 @@
 package gui
 
 import (
+	"context"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	"pgrollback/internal/config"
 )
@@ -23,6 +27,20 @@ func handleAPISessions(provider SessionProvider) http.HandlerFunc {
 			return
 		}
 		list := provider.GetSessions()
+		// ?testID= narrows the list to one session (still an array); unknown IDs are 404.
+		if testID := r.URL.Query().Get("testID"); testID != "" {
+			var filtered []SessionInfo
+			for _, s := range list {
+				if s.TestID == testID {
+					filtered = append(filtered, s)
+				}
+			}
+			if len(filtered) == 0 {
+				http.Error(w, "session not found", http.StatusNotFound)
+				return
+			}
+			list = filtered
+		}
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(list)
 	}
@@ -55,7 +73,7 @@ func handleAPISessionsClose(provider SessionProvider) http.HandlerFunc {
 			return
 		}
 		if err := provider.DestroySession(testID); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			http.Error(w, err.Error(), sessionErrorStatus(err))
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -90,7 +108,7 @@ func handleAPISessionsClearHistory(provider SessionProvider) http.HandlerFunc {
 			return
 		}
 		if err := provider.ClearHistory(testID); err != nil {
-			http.Error(w, err.Error(), http.StatusNotFound)
+			http.Error(w, err.Error(), sessionErrorStatus(err))
 			return
 		}
 		w.WriteHeader(http.StatusOK)
@@ -98,6 +116,105 @@ func handleAPISessionsClearHistory(provider SessionProvider) http.HandlerFunc {
 	}
 }
 
+// healthzTimeout bounds the backend check behind GET /healthz.
+const healthzTimeout = 5 * time.Second
+
+// handleHealthz answers 200 {"status":"ok"} when the proxy can reach PostgreSQL, otherwise
+// 503 {"status":"unavailable","error":...}.
+func handleHealthz(checker ReadinessChecker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), healthzTimeout)
+		defer cancel()
+		body := map[string]string{"status": "ok"}
+		status := http.StatusOK
+		if err := checker.Ready(ctx); err != nil {
+			body = map[string]string{"status": "unavailable", "error": err.Error()}
+			status = http.StatusServiceUnavailable
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(body)
+	}
+}
+
+// handleAPIStats returns the proxy's connection counters (current, peak, cap, rejected).
+func handleAPIStats(stats StatsProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(stats.ConnectionStats())
+	}
+}
+
+// handleAPIServer returns the proxy's version, uptime, backend and session/query counters.
+func handleAPIServer(status ServerStatusProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(status.ServerStatus())
+	}
+}
+
+// SessionRollbackResponse is the JSON returned by POST /api/sessions/{testID}/rollback.
+type SessionRollbackResponse struct {
+	TestID     string `json:"test_id"`
+	RolledBack bool   `json:"rolled_back"`
+	Error      string `json:"error,omitempty"`
+}
+
+// requireAdminToken protects an administrative endpoint with gui.admin_token when it is configured:
+// the request must send "Authorization: Bearer <token>". Without a token the endpoint is open, like
+// the rest of the GUI, which is only reachable on the proxy's listen host.
+func requireAdminToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token := ""
+		if cfg, ok := config.GetCfgIfSet(); ok {
+			token = cfg.GUI.AdminToken
+		}
+		if token != "" {
+			got, _ := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+				http.Error(w, "invalid or missing admin token", http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+// sessionErrorStatus is the HTTP status of a SessionProvider error: 404 when the session does not exist,
+// 500 when tearing it down failed.
+func sessionErrorStatus(err error) int {
+	if errors.Is(err, ErrSessionNotFound) {
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+// handleAPISessionRollback force-rolls back one session (e.g. left open by a crashed test and holding
+// locks): its clients are disconnected and its base transaction is rolled back.
+func handleAPISessionRollback(provider SessionProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		testID := r.PathValue("testID")
+		resp := SessionRollbackResponse{TestID: testID, RolledBack: true}
+		status := http.StatusOK
+		if err := provider.DestroySession(testID); err != nil {
+			resp.RolledBack = false
+			resp.Error = err.Error()
+			status = sessionErrorStatus(err)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_ = json.NewEncoder(w).Encode(resp)
+	}
+}
+
 func handleAPISessionsRollbackAll(provider SessionProvider) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if r.Method != http.MethodPost {
@@ -168,6 +285,17 @@ func handleAPIConfigSave(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "config required", http.StatusBadRequest)
 		return
 	}
+	// The token guards this endpoint, so it cannot be changed through it: only the config file sets it.
+	if t := payload.Config.GUI.AdminToken; t != "" && t != config.PasswordMask {
+		current := ""
+		if cfg, ok := config.GetCfgIfSet(); ok {
+			current = cfg.GUI.AdminToken
+		}
+		if t != current {
+			http.Error(w, "gui.admin_token cannot be changed through the API; edit the config file", http.StatusForbidden)
+			return
+		}
+	}
 	// Determine which path to save to: user-provided or default.
 	path := strings.TrimSpace(payload.ConfigPath)
 	if path == "" {
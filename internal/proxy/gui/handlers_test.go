@@ -1,12 +1,16 @@
 package gui
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"pgrollback/internal/config"
 )
 
 // mockProvider implements SessionProvider for testing.
@@ -70,6 +74,32 @@ func TestHandleAPISessions_ReturnsJSON(t *testing.T) {
 	}
 }
 
+func TestHandleAPISessions_FilterByTestID(t *testing.T) {
+	provider := &mockProvider{
+		sessions: []SessionInfo{{TestID: "test-1", SavepointLevel: 2}, {TestID: "test-2"}},
+	}
+	mux := NewMux(provider)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/sessions?testID=test-1", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got []SessionInfo
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if len(got) != 1 || got[0].TestID != "test-1" || got[0].SavepointLevel != 2 {
+		t.Fatalf("got %+v, want only test-1", got)
+	}
+
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/sessions?testID=missing", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("unknown testID status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
 func TestHandleAPISessions_EmptyList(t *testing.T) {
 	provider := &mockProvider{sessions: []SessionInfo{}}
 	mux := NewMux(provider)
@@ -135,7 +165,7 @@ func TestHandleAPISessionsClose_MissingTestID(t *testing.T) {
 }
 
 func TestHandleAPISessionsClose_NotFound(t *testing.T) {
-	provider := &mockProvider{destroyErr: fmt.Errorf("not found")}
+	provider := &mockProvider{destroyErr: fmt.Errorf("%w for test_id: no-such", ErrSessionNotFound)}
 	mux := NewMux(provider)
 	body := `{"test_id":"no-such"}`
 	req := httptest.NewRequest(http.MethodPost, "/api/sessions/close", strings.NewReader(body))
@@ -194,7 +224,7 @@ func TestHandleAPIClearHistory_MissingTestID(t *testing.T) {
 }
 
 func TestHandleAPIClearHistory_NotFound(t *testing.T) {
-	provider := &mockProvider{clearErr: fmt.Errorf("session not found")}
+	provider := &mockProvider{clearErr: fmt.Errorf("%w for test_id: no-such", ErrSessionNotFound)}
 	mux := NewMux(provider)
 	body := `{"test_id":"no-such"}`
 	req := httptest.NewRequest(http.MethodPost, "/api/sessions/clear-history", strings.NewReader(body))
@@ -271,6 +301,144 @@ func TestHandleAPISessionsRollbackAll_EmptySessions(t *testing.T) {
 	}
 }
 
+// --- POST /api/sessions/{testID}/rollback ---
+
+func TestHandleAPISessionRollback_DestroysSession(t *testing.T) {
+	provider := &mockProvider{}
+	mux := NewMux(provider)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/sessions/test-1/rollback", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var got SessionRollbackResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if got.TestID != "test-1" || !got.RolledBack {
+		t.Errorf("response = %+v", got)
+	}
+	if len(provider.destroyed) != 1 || provider.destroyed[0] != "test-1" {
+		t.Errorf("destroyed = %v, want [test-1]", provider.destroyed)
+	}
+}
+
+func TestHandleAPISessionRollback_NotFound(t *testing.T) {
+	provider := &mockProvider{destroyErr: fmt.Errorf("%w for test_id: nope", ErrSessionNotFound)}
+	mux := NewMux(provider)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/sessions/nope/rollback", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+	var got SessionRollbackResponse
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("decode error: %v", err)
+	}
+	if got.RolledBack || got.Error == "" {
+		t.Errorf("response = %+v, want rolled_back=false with error", got)
+	}
+}
+
+func TestHandleAPISessionRollback_RequiresAdminToken(t *testing.T) {
+	setAdminToken(t, "s3cret")
+
+	provider := &mockProvider{}
+	mux := NewMux(provider)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/sessions/test-1/rollback", nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("without token: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+	if len(provider.destroyed) != 0 {
+		t.Fatalf("session destroyed without token: %v", provider.destroyed)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/sessions/test-1/rollback", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec = httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("with token: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestHandleAPISessionRollback_BackendFailureIs500(t *testing.T) {
+	provider := &mockProvider{destroyErr: errors.New("rollback failed: connection reset")}
+	mux := NewMux(provider)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/sessions/test-1/rollback", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
+
+// setAdminToken configures gui.admin_token for one test.
+func setAdminToken(t *testing.T, token string) {
+	t.Helper()
+	config.Init()
+	prev, hadPrev := config.GetCfgIfSet()
+	config.SetConfig(&config.Config{GUI: config.GUIConfig{AdminToken: token}})
+	t.Cleanup(func() {
+		if hadPrev {
+			config.SetConfig(prev)
+		} else {
+			config.SetConfig(nil)
+		}
+	})
+}
+
+func TestAdminToken_GuardsStateChangingRoutes(t *testing.T) {
+	setAdminToken(t, "s3cret")
+	provider := &mockProvider{sessions: []SessionInfo{{TestID: "test-1"}}}
+	mux := NewMux(provider)
+
+	for _, path := range []string{
+		"/api/sessions/close?test_id=test-1",
+		"/api/sessions/clear-history?test_id=test-1",
+		"/api/sessions/rollback-all",
+		"/api/sessions/disconnect-all",
+		"/api/config/save",
+	} {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(`{}`)))
+		if rec.Code != http.StatusUnauthorized {
+			t.Errorf("POST %s without token: status = %d, want %d", path, rec.Code, http.StatusUnauthorized)
+		}
+	}
+	if len(provider.destroyed) != 0 || len(provider.clearedHistory) != 0 {
+		t.Fatalf("state changed without token: destroyed %v, cleared %v", provider.destroyed, provider.clearedHistory)
+	}
+
+	req := httptest.NewRequest(http.MethodPost, "/api/sessions/close?test_id=test-1", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("close with token: status = %d, want %d", rec.Code, http.StatusOK)
+	}
+}
+
+func TestHandleAPIConfigSave_RefusesAdminTokenChange(t *testing.T) {
+	setAdminToken(t, "s3cret")
+	mux := NewMux(&mockProvider{})
+
+	body := `{"config":{"gui":{"admin_token":"mine-now"}}}`
+	req := httptest.NewRequest(http.MethodPost, "/api/config/save", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusForbidden)
+	}
+	if cfg, _ := config.GetCfgIfSet(); cfg.GUI.AdminToken != "s3cret" {
+		t.Errorf("admin token = %q after the refused save, want it unchanged", cfg.GUI.AdminToken)
+	}
+}
+
 // --- Sessions JSON shape ---
 
 func TestSessionInfo_JSONShape(t *testing.T) {
@@ -288,7 +456,8 @@ func TestSessionInfo_JSONShape(t *testing.T) {
 	}
 	s := string(data)
 	// Check that JSON keys match the expected shape
-	for _, key := range []string{`"test_id"`, `"in_transaction"`, `"last_query"`, `"last_query_duration"`, `"query_history"`, `"query"`, `"at"`, `"duration"`} {
+	for _, key := range []string{`"test_id"`, `"in_transaction"`, `"last_query"`, `"last_query_duration"`, `"query_history"`, `"query"`, `"at"`, `"duration"`,
+		`"active"`, `"savepoint_level"`, `"created_at"`, `"last_activity"`, `"open_user_tx"`} {
 		if !strings.Contains(s, key) {
 			t.Errorf("JSON missing key %s: %s", key, s)
 		}
@@ -356,6 +525,94 @@ func TestHTML_ContainsAPIBase(t *testing.T) {
 	}
 }
 
+// readyProvider is a mockProvider that also implements ReadinessChecker.
+type readyProvider struct {
+	mockProvider
+	readyErr error
+}
+
+func (r *readyProvider) Ready(ctx context.Context) error {
+	return r.readyErr
+}
+
+func TestHealthz_Ready(t *testing.T) {
+	mux := NewMux(&readyProvider{})
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var out map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out["status"] != "ok" {
+		t.Errorf("status field = %q, want ok", out["status"])
+	}
+}
+
+func TestHealthz_BackendUnavailable(t *testing.T) {
+	mux := NewMux(&readyProvider{readyErr: errors.New("connection refused")})
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusServiceUnavailable)
+	}
+	var out map[string]string
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out["status"] != "unavailable" || out["error"] != "connection refused" {
+		t.Errorf("body = %v", out)
+	}
+}
+
+func TestHealthz_NotRegisteredWithoutChecker(t *testing.T) {
+	mux := NewMux(&mockProvider{})
+	req := httptest.NewRequest(http.MethodGet, "/healthz", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code == http.StatusOK && strings.Contains(rec.Body.String(), `"status"`) {
+		t.Errorf("/healthz should not be served when the provider has no Ready method")
+	}
+}
+
+// statsProvider is a mockProvider that also implements StatsProvider.
+type statsProvider struct {
+	mockProvider
+	stats ConnectionStats
+}
+
+func (s *statsProvider) ConnectionStats() ConnectionStats {
+	return s.stats
+}
+
+func TestAPIStats_ReturnsConnectionCounters(t *testing.T) {
+	mux := NewMux(&statsProvider{stats: ConnectionStats{CurrentConnections: 3, PeakConnections: 7, MaxConnections: 10, RejectedConnections: 2}})
+	req := httptest.NewRequest(http.MethodGet, "/api/stats", nil)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var out map[string]int
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := map[string]int{"current_connections": 3, "peak_connections": 7, "max_connections": 10, "rejected_connections": 2}
+	for k, v := range want {
+		if out[k] != v {
+			t.Errorf("%s = %d, want %d", k, out[k], v)
+		}
+	}
+}
+
 func TestHTMLWithBase_CustomBase(t *testing.T) {
 	html := HTMLWithBase("/myprefix")
 	if strings.Contains(html, "__API_BASE__") {
@@ -379,3 +636,35 @@ func TestHTMLWithBase_SlashBase(t *testing.T) {
 		t.Error("HTMLWithBase('/') should contain /api/sessions")
 	}
 }
+
+// serverStatusProvider is a mockProvider that also implements ServerStatusProvider.
+type serverStatusProvider struct {
+	mockProvider
+	status ServerStatus
+}
+
+func (s *serverStatusProvider) ServerStatus() ServerStatus {
+	return s.status
+}
+
+func TestAPIServer_ReturnsStatus(t *testing.T) {
+	mux := NewMux(&serverStatusProvider{status: ServerStatus{Version: "v1.2.3", ActiveSessions: 2, PeakSessions: 5, QueriesProcessed: 42}})
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/server", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var out map[string]any
+	if err := json.NewDecoder(rec.Body).Decode(&out); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if out["version"] != "v1.2.3" || out["active_sessions"] != float64(2) || out["peak_sessions"] != float64(5) || out["queries_processed"] != float64(42) {
+		t.Errorf("body = %v", out)
+	}
+
+	rec = httptest.NewRecorder()
+	NewMux(&mockProvider{}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/server", nil))
+	if rec.Code == http.StatusOK && strings.Contains(rec.Body.String(), `"version"`) {
+		t.Error("/api/server should not be served when the provider has no ServerStatus method")
+	}
+}
@@ -8,8 +8,9 @@ import (
 
 const apiBasePlaceholder = "__API_BASE__"
 const faviconPlaceholder = "__FAVICON_DATA_URI__"
+const homePlaceholder = "__HOME__" // GUI home page path; the live session pages are under __HOME__gui/session/
 
-// htmlTemplate is the full GUI page; __API_BASE__ and __FAVICON_DATA_URI__ are replaced at runtime.
+// htmlTemplate is the full GUI page; __API_BASE__, __HOME__ and __FAVICON_DATA_URI__ are replaced at runtime.
 const htmlTemplate = `<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -162,7 +163,7 @@ const htmlTemplate = `<!DOCTYPE html>
     }
     .query-duration { color: #64748b; font-weight: 500; margin-left: 0.25rem; }
     .actions { white-space: nowrap; }
-    .history-btn, .close-btn, .clear-log-btn {
+    .history-btn, .close-btn, .clear-log-btn, .live-btn {
       padding: 0.35rem 0.75rem;
       border: 0;
       border-radius: 6px;
@@ -174,6 +175,8 @@ const htmlTemplate = `<!DOCTYPE html>
     .history-btn { margin-right: 0.35rem; background: #475569; color: #e2e8f0; }
     .history-btn:hover { background: #64748b; }
     .clear-log-btn { margin-right: 0.35rem; background: #475569; color: #e2e8f0; }
+    .live-btn { display: inline-block; margin-right: 0.35rem; background: #0369a1; color: #e0f2fe; text-decoration: none; }
+    .live-btn:hover { background: #0284c7; }
     .clear-log-btn:hover { background: #64748b; }
     .close-btn { background: #dc2626; color: #fff; }
     .close-btn:hover { background: #ef4444; }
@@ -435,6 +438,25 @@ const htmlTemplate = `<!DOCTYPE html>
       div.textContent = s;
       return div.innerHTML;
     }
+    // adminFetch sends a state-changing request with gui.admin_token as a Bearer token. The token is asked
+    // for on the first 401 and kept for the tab's lifetime; a wrong token is forgotten so it is asked again.
+    function adminFetch(url, opts) {
+      opts = opts || {};
+      function send() {
+        var headers = Object.assign({}, opts.headers || {});
+        var token = sessionStorage.getItem('pgrollbackAdminToken');
+        if (token) headers['Authorization'] = 'Bearer ' + token;
+        return fetch(url, Object.assign({}, opts, { headers: headers }));
+      }
+      return send().then(function(r) {
+        if (r.status !== 401) return r;
+        sessionStorage.removeItem('pgrollbackAdminToken');
+        var token = prompt('Admin token (gui.admin_token):');
+        if (!token) return r;
+        sessionStorage.setItem('pgrollbackAdminToken', token);
+        return send();
+      });
+    }
     function formatHistoryAt(at) {
       if (!at) return '';
       try {
@@ -506,7 +528,8 @@ const htmlTemplate = `<!DOCTYPE html>
         var n = hist.length;
         var txLabel = (s.in_transaction === true) ? 'Yes' : 'No';
         var txClass = (s.in_transaction === true) ? 'tx-status yes' : 'tx-status no';
-        html += '<tr class="session-row" data-id="' + escapeHtml(s.test_id) + '"><td>' + escapeHtml(s.test_id) + '</td><td class="' + txClass + '">' + txLabel + '</td><td class="query" title="' + escapeHtml(qTitle) + '">' + q + dur + '</td><td><button type="button" class="history-btn" data-id="' + escapeHtml(s.test_id) + '">History (' + n + ')</button><button type="button" class="clear-log-btn" data-id="' + escapeHtml(s.test_id) + '">Clear log</button><button type="button" class="close-btn" data-id="' + escapeHtml(s.test_id) + '">Disconnect</button></td></tr>';
+        var txTitle = s.open_tx_holder ? ('held by ' + s.open_tx_holder) : '';
+        html += '<tr class="session-row" data-id="' + escapeHtml(s.test_id) + '"><td>' + escapeHtml(s.test_id) + '</td><td class="' + txClass + '" title="' + escapeHtml(txTitle) + '">' + txLabel + '</td><td class="query" title="' + escapeHtml(qTitle) + '">' + q + dur + '</td><td><button type="button" class="history-btn" data-id="' + escapeHtml(s.test_id) + '">History (' + n + ')</button><a class="live-btn" target="_blank" href="__HOME__gui/session/' + encodeURIComponent(s.test_id) + '" title="Live query stream of this session">Live</a><button type="button" class="clear-log-btn" data-id="' + escapeHtml(s.test_id) + '">Clear log</button><button type="button" class="close-btn" data-id="' + escapeHtml(s.test_id) + '">Disconnect</button></td></tr>';
         html += '<tr class="history-row" data-id="' + escapeHtml(s.test_id) + '" style="display:none"><td colspan="4"><div class="history-list-wrap"><div class="history-list-toolbar"><button type="button" class="history-height-btn">Full height</button></div><div class="history-list"><ul>';
         for (var j = 0; j < hist.length; j++) {
           html += '<li>' + historyItemHtml(hist[j]) + '</li>';
@@ -541,7 +564,7 @@ const htmlTemplate = `<!DOCTYPE html>
       tbody.querySelectorAll('.clear-log-btn').forEach(function(btn) {
         btn.addEventListener('click', function() {
           var id = this.getAttribute('data-id');
-          fetch('__API_BASE__/sessions/clear-history', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ test_id: id }) })
+          adminFetch('__API_BASE__/sessions/clear-history', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ test_id: id }) })
             .then(function(r) {
               if (!r.ok) { r.text().then(function(t) { alert(t); }); return; }
               // Force fullReplace on the next render so history <ul> and counts match the server (incremental updateRow can miss some shrink cases).
@@ -554,7 +577,7 @@ const htmlTemplate = `<!DOCTYPE html>
       tbody.querySelectorAll('.close-btn').forEach(function(btn) {
         btn.addEventListener('click', function() {
           var id = this.getAttribute('data-id');
-          fetch('__API_BASE__/sessions/close', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ test_id: id }) })
+          adminFetch('__API_BASE__/sessions/close', { method: 'POST', headers: { 'Content-Type': 'application/json' }, body: JSON.stringify({ test_id: id }) })
             .then(function(r) { if (r.ok) load(); else r.text().then(function(t) { alert(t); }); });
         });
       });
@@ -574,6 +597,7 @@ const htmlTemplate = `<!DOCTYPE html>
       var txLabel = (s.in_transaction === true) ? 'Yes' : 'No';
       mainRow.cells[1].textContent = txLabel;
       mainRow.cells[1].className = (s.in_transaction === true) ? 'tx-status yes' : 'tx-status no';
+      mainRow.cells[1].title = s.open_tx_holder ? ('held by ' + s.open_tx_holder) : '';
       var queryCell = mainRow.cells[2];
       queryCell.innerHTML = escapeHtml(q) + dur;
       queryCell.title = q;
@@ -649,7 +673,7 @@ const htmlTemplate = `<!DOCTYPE html>
     var rollbackAllBtn = document.getElementById('rollbackAllBtn');
     if (rollbackAllBtn) {
       rollbackAllBtn.addEventListener('click', function() {
-        fetch('__API_BASE__/sessions/rollback-all', { method: 'POST' })
+        adminFetch('__API_BASE__/sessions/rollback-all', { method: 'POST' })
           .then(function(r) { if (!r.ok) throw new Error(r.statusText); return r.json(); })
           .then(function() { load(); })
           .catch(function(e) { alert('Rollback All failed: ' + (e && e.message ? e.message : e)); });
@@ -659,7 +683,7 @@ const htmlTemplate = `<!DOCTYPE html>
     if (disconnectAllBtn) {
       disconnectAllBtn.addEventListener('click', function() {
         if (!confirm('Disconnect ALL sessions? This will close all connections and rollback their transactions.')) return;
-        fetch('__API_BASE__/sessions/disconnect-all', { method: 'POST' })
+        adminFetch('__API_BASE__/sessions/disconnect-all', { method: 'POST' })
           .then(function(r) { if (!r.ok) throw new Error(r.statusText); return r.json(); })
           .then(function() { load(); })
           .catch(function(e) { alert('Disconnect All failed: ' + (e && e.message ? e.message : e)); });
@@ -745,7 +769,7 @@ const htmlTemplate = `<!DOCTYPE html>
           ping_timeout: document.getElementById('cfg_test_ping_timeout').value
         };
         var payload = { config: { postgres: p, proxy: px, logging: l, test: t }, config_path: cfgPathVal };
-        fetch('__API_BASE__/config/save', {
+        adminFetch('__API_BASE__/config/save', {
           method: 'POST',
           headers: { 'Content-Type': 'application/json' },
           body: JSON.stringify(payload)
@@ -760,6 +784,13 @@ const htmlTemplate = `<!DOCTYPE html>
 </html>
 `
 
+func homeFrom(base string) string {
+	if base != "" && base != "/" {
+		return base + "/"
+	}
+	return "/"
+}
+
 func apiBaseFrom(base string) string {
 	if base != "" && base != "/" {
 		return base + "/api"
@@ -770,6 +801,7 @@ func apiBaseFrom(base string) string {
 // HTMLWithBase returns the GUI page HTML with API path prefix and favicon set.
 func HTMLWithBase(base string) string {
 	s := strings.ReplaceAll(htmlTemplate, apiBasePlaceholder, apiBaseFrom(base))
+	s = strings.ReplaceAll(s, homePlaceholder, homeFrom(base))
 	s = strings.ReplaceAll(s, faviconPlaceholder, tray.FaviconDataURI())
 	return s
 }
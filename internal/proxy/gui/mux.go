@@ -11,13 +11,25 @@ func NewMux(provider SessionProvider) http.Handler {
 	mux.HandleFunc("/", serveHome)
 	mux.HandleFunc("/gui", serveHome)
 	mux.HandleFunc("/gui/", serveHome)
+	mux.HandleFunc("GET /gui/session/{testID}", serveSessionPage)
 	mux.HandleFunc("/api/sessions", handleAPISessions(provider))
-	mux.HandleFunc("/api/sessions/close", handleAPISessionsClose(provider))
-	mux.HandleFunc("/api/sessions/clear-history", handleAPISessionsClearHistory(provider))
-	mux.HandleFunc("/api/sessions/rollback-all", handleAPISessionsRollbackAll(provider))
-	mux.HandleFunc("/api/sessions/disconnect-all", handleAPISessionsDisconnectAll(provider))
+	mux.HandleFunc("/api/sessions/close", requireAdminToken(handleAPISessionsClose(provider)))
+	mux.HandleFunc("/api/sessions/clear-history", requireAdminToken(handleAPISessionsClearHistory(provider)))
+	mux.HandleFunc("/api/sessions/rollback-all", requireAdminToken(handleAPISessionsRollbackAll(provider)))
+	mux.HandleFunc("/api/sessions/disconnect-all", requireAdminToken(handleAPISessionsDisconnectAll(provider)))
+	mux.HandleFunc("POST /api/sessions/{testID}/rollback", requireAdminToken(handleAPISessionRollback(provider)))
+	mux.HandleFunc("GET /api/sessions/{testID}/history", handleAPISessionHistory(provider))
+	if checker, ok := provider.(ReadinessChecker); ok {
+		mux.HandleFunc("/healthz", handleHealthz(checker))
+	}
+	if stats, ok := provider.(StatsProvider); ok {
+		mux.HandleFunc("/api/stats", handleAPIStats(stats))
+	}
+	if status, ok := provider.(ServerStatusProvider); ok {
+		mux.HandleFunc("GET /api/server", handleAPIServer(status))
+	}
 	mux.HandleFunc("/api/config", handleAPIConfigGet)
-	mux.HandleFunc("/api/config/save", handleAPIConfigSave)
+	mux.HandleFunc("/api/config/save", requireAdminToken(handleAPIConfigSave))
 	return mux
 }
 
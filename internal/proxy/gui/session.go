@@ -1,5 +1,10 @@
 package gui
 
+import (
+	"context"
+	"errors"
+)
+
 // QueryHistoryItem is one entry in the session's query history (with timestamp and duration for display).
 type QueryHistoryItem struct {
 	Query    string `json:"query"`
@@ -10,12 +15,24 @@ type QueryHistoryItem struct {
 // SessionInfo is the JSON shape for one session in the GUI API.
 type SessionInfo struct {
 	TestID            string             `json:"test_id"`
-	InTransaction     bool               `json:"in_transaction"`     // true if session has an active (open) transaction
+	InTransaction     bool               `json:"in_transaction"` // true if session has an active (open) transaction
 	LastQuery         string             `json:"last_query"`
 	LastQueryDuration string             `json:"last_query_duration"` // e.g. "12.345ms" for GUI display
 	QueryHistory      []QueryHistoryItem `json:"query_history"`       // last executed queries (oldest first), max 100
+	Active            bool               `json:"active"`              // session still holds its base transaction
+	SavepointLevel    int                `json:"savepoint_level"`     // nesting of client BEGINs (savepoints)
+	CreatedAt         string             `json:"created_at"`          // RFC3339
+	LastActivity      string             `json:"last_activity"`       // RFC3339
+	OpenUserTx        bool               `json:"open_user_tx"`        // a client connection holds an open BEGIN
+	OpenTxHolder      string             `json:"open_tx_holder"`      // client address holding it; "" when none
+	CreatedObjects    []string           `json:"created_objects"`     // schema objects a full rollback will discard, e.g. "table public.t"
+	DroppedObjects    []string           `json:"dropped_objects"`     // schema objects dropped by the session that a rollback brings back
 }
 
+// ErrSessionNotFound is returned (wrapped) by a SessionProvider for a test ID without a session; the API
+// answers it with 404 and any other error with 500.
+var ErrSessionNotFound = errors.New("session not found")
+
 // SessionProvider supplies session data and close for the GUI. Implemented by the proxy.
 type SessionProvider interface {
 	GetSessions() []SessionInfo
@@ -24,3 +41,44 @@ type SessionProvider interface {
 	// DestroyAllSessions disconnects all clients (rollback all sessions). Returns count destroyed.
 	DestroyAllSessions() (int, error)
 }
+
+// ReadinessChecker is optionally implemented by a SessionProvider to back GET /healthz.
+type ReadinessChecker interface {
+	Ready(ctx context.Context) error
+}
+
+// ConnectionStats is the JSON returned by GET /api/stats: client connection counters of the proxy.
+type ConnectionStats struct {
+	CurrentConnections  int    `json:"current_connections"`
+	PeakConnections     int    `json:"peak_connections"`
+	MaxConnections      int    `json:"max_connections"`      // proxy.max_connections; 0 = unlimited
+	RejectedConnections uint64 `json:"rejected_connections"` // refused with 53300 too_many_connections
+}
+
+// StatsProvider is optionally implemented by a SessionProvider to back GET /api/stats.
+type StatsProvider interface {
+	ConnectionStats() ConnectionStats
+}
+
+// ServerStatus is the JSON returned by GET /api/server: an operational summary of the proxy.
+type ServerStatus struct {
+	Version            string `json:"version"`
+	StartedAt          string `json:"started_at"` // RFC3339
+	Uptime             string `json:"uptime"`     // e.g. "3h12m5s"
+	UptimeSeconds      int64  `json:"uptime_seconds"`
+	ListenAddress      string `json:"listen_address"`
+	BackendHost        string `json:"backend_host"`
+	BackendPort        int    `json:"backend_port"`
+	BackendDatabase    string `json:"backend_database"`
+	BackendVersion     string `json:"backend_version"`
+	ActiveSessions     int    `json:"active_sessions"`
+	PeakSessions       int    `json:"peak_sessions"`
+	CurrentConnections int    `json:"current_connections"`
+	PeakConnections    int    `json:"peak_connections"`
+	QueriesProcessed   uint64 `json:"queries_processed"` // Simple Query and Execute messages received
+}
+
+// ServerStatusProvider is optionally implemented by a SessionProvider to back GET /api/server.
+type ServerStatusProvider interface {
+	ServerStatus() ServerStatus
+}
@@ -0,0 +1,180 @@
+package gui
+
+// sessionPageTemplate is the live query page of one session (/gui/session/{testID}); __API_BASE__,
+// __HOME__ and __FAVICON_DATA_URI__ are replaced at runtime. It polls the session's history every
+// second and appends new queries, highlighting transaction control (BEGIN, COMMIT, ROLLBACK, SAVEPOINT).
+const sessionPageTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <title>PgRollback Session</title>
+  <link rel="icon" type="image/x-icon" href="__FAVICON_DATA_URI__">
+  <style>
+    *, *::before, *::after { box-sizing: border-box; }
+    body {
+      font-family: 'Segoe UI', system-ui, -apple-system, sans-serif;
+      margin: 0;
+      min-height: 100vh;
+      background: linear-gradient(160deg, #0f172a 0%, #1e293b 50%, #0f172a 100%);
+      color: #e2e8f0;
+      line-height: 1.5;
+    }
+    .page { width: 100%; margin: 0; padding: 1.25rem 1.5rem; }
+    .header {
+      display: flex;
+      align-items: center;
+      justify-content: space-between;
+      flex-wrap: wrap;
+      gap: 1rem;
+      margin-bottom: 1rem;
+      padding-bottom: 1rem;
+      border-bottom: 1px solid rgba(51, 65, 85, 0.6);
+    }
+    .header h1 { margin: 0; font-size: 1.5rem; font-weight: 600; color: #f1f5f9; }
+    .header h1 span { color: #38bdf8; font-weight: 700; word-break: break-all; }
+    .header a { color: #94a3b8; font-size: 0.875rem; text-decoration: none; }
+    .header a:hover { color: #e2e8f0; }
+    .toolbar { display: flex; gap: 0.75rem; align-items: center; font-size: 0.875rem; color: #cbd5e1; }
+    .toolbar button {
+      padding: 0.4rem 0.9rem;
+      border: 0;
+      border-radius: 8px;
+      font-size: 0.875rem;
+      font-weight: 500;
+      cursor: pointer;
+      background: #0ea5e9;
+      color: #fff;
+    }
+    .toolbar button.paused { background: #475569; }
+    .status { font-size: 0.8125rem; color: #94a3b8; margin-bottom: 0.75rem; }
+    .status b { color: #e2e8f0; font-weight: 600; }
+    .status .error { color: #fca5a5; }
+    .stream {
+      background: rgba(15, 23, 42, 0.75);
+      border: 1px solid rgba(51, 65, 85, 0.7);
+      border-radius: 8px;
+      max-height: calc(100vh - 10rem);
+      overflow-y: auto;
+    }
+    .stream table { width: 100%; border-collapse: collapse; }
+    .stream td {
+      padding: 0.35rem 0.75rem;
+      border-bottom: 1px solid rgba(51, 65, 85, 0.4);
+      vertical-align: top;
+      font-size: 0.8125rem;
+    }
+    .stream td.at, .stream td.dur { white-space: nowrap; color: #64748b; }
+    .stream td.query {
+      font-family: 'Consolas', 'Monaco', ui-monospace, monospace;
+      white-space: pre-wrap;
+      word-break: break-word;
+      width: 100%;
+    }
+    .stream tr.kind-begin td.query, .stream tr.kind-commit td.query { color: #86efac; }
+    .stream tr.kind-savepoint td.query { color: #7dd3fc; }
+    .stream tr.kind-rollback td.query { color: #fca5a5; font-weight: 600; }
+    .stream tr.kind-rollback td { background: rgba(127, 29, 29, 0.25); }
+    .stream .empty { color: #64748b; text-align: center; padding: 1.5rem; }
+  </style>
+</head>
+<body>
+  <div class="page">
+    <div class="header">
+      <h1>Session <span id="testID"></span></h1>
+      <div class="toolbar">
+        <label><input type="checkbox" id="follow" checked> Follow</label>
+        <button type="button" id="pause">Pause</button>
+        <a href="__HOME__">&larr; All sessions</a>
+      </div>
+    </div>
+    <div class="status" id="status">Loading…</div>
+    <div class="stream" id="stream"><table><tbody id="tbody"></tbody></table></div>
+  </div>
+  <script>
+    var parts = location.pathname.split('/');
+    var testID = decodeURIComponent(parts[parts.length - 1] || '');
+    document.getElementById('testID').textContent = testID;
+    document.title = 'PgRollback · ' + testID;
+    var tbody = document.getElementById('tbody');
+    var stream = document.getElementById('stream');
+    var statusEl = document.getElementById('status');
+    var followBox = document.getElementById('follow');
+    var pauseBtn = document.getElementById('pause');
+    var paused = false;
+    var shown = [];
+    function escapeHtml(s) {
+      var div = document.createElement('div');
+      div.textContent = s == null ? '' : String(s);
+      return div.innerHTML;
+    }
+    function formatAt(at) {
+      if (!at) return '';
+      var d = new Date(at);
+      return isNaN(d.getTime()) ? at : d.toLocaleTimeString(undefined, { hour12: false });
+    }
+    function rowHtml(item) {
+      var cls = item.kind ? ' class="kind-' + escapeHtml(item.kind) + '"' : '';
+      return '<tr' + cls + '><td class="at">' + escapeHtml(formatAt(item.at)) + '</td><td class="dur">' + escapeHtml(item.duration || '') + '</td><td class="query">' + escapeHtml(item.query) + '</td></tr>';
+    }
+    function sameItem(a, b) { return a && b && a.at === b.at && a.query === b.query; }
+    function renderStatus(data) {
+      var tx = data.in_transaction ? 'yes' : 'no';
+      var holder = data.open_tx_holder ? ' (held by ' + escapeHtml(data.open_tx_holder) + ')' : '';
+      statusEl.innerHTML = 'Active: <b>' + (data.active ? 'yes' : 'no') + '</b> · Savepoint level: <b>' + data.savepoint_level + '</b> · Open transaction: <b>' + tx + '</b>' + holder + ' · Queries: <b>' + data.history.length + '</b>' +
+        objectsHtml('Created', data.created_objects) + objectsHtml('Dropped', data.dropped_objects);
+    }
+    function objectsHtml(label, objects) {
+      if (!objects || objects.length === 0) return '';
+      return '<br>' + label + ': ' + escapeHtml(objects.join(', '));
+    }
+    function render(data) {
+      var hist = data.history || [];
+      renderStatus(data);
+      // Append what follows the last row shown; when it is gone (history cleared) redraw everything.
+      var appendFrom = 0;
+      if (shown.length > 0) {
+        appendFrom = -1;
+        for (var i = hist.length - 1; i >= 0; i--) {
+          if (sameItem(hist[i], shown[shown.length - 1])) { appendFrom = i + 1; break; }
+        }
+      }
+      if (appendFrom < 0 || shown.length === 0) {
+        tbody.innerHTML = '';
+        appendFrom = 0;
+      }
+      var html = '';
+      for (var j = appendFrom; j < hist.length; j++) html += rowHtml(hist[j]);
+      if (html) tbody.insertAdjacentHTML('beforeend', html);
+      // Drop rows the server no longer keeps (history is capped by proxy.query_history_size).
+      while (tbody.rows.length > hist.length) tbody.deleteRow(0);
+      if (hist.length === 0) tbody.innerHTML = '<tr><td class="empty">No queries yet</td></tr>';
+      // Durations are filled in after a query completes: refresh the last row.
+      if (hist.length > 0 && tbody.rows.length === hist.length) {
+        tbody.rows[hist.length - 1].outerHTML = rowHtml(hist[hist.length - 1]);
+      }
+      shown = hist;
+      if (html && followBox.checked) stream.scrollTop = stream.scrollHeight;
+    }
+    function poll() {
+      if (paused) { setTimeout(poll, 1000); return; }
+      fetch('__API_BASE__/sessions/' + encodeURIComponent(testID) + '/history')
+        .then(function(r) {
+          if (r.status === 404) throw new Error('session not found (ended or not started yet)');
+          if (!r.ok) throw new Error(r.statusText);
+          return r.json();
+        })
+        .then(render)
+        .catch(function(e) { statusEl.innerHTML = '<span class="error">' + escapeHtml(e.message) + '</span>'; })
+        .then(function() { setTimeout(poll, 1000); });
+    }
+    pauseBtn.addEventListener('click', function() {
+      paused = !paused;
+      pauseBtn.textContent = paused ? 'Resume' : 'Pause';
+      pauseBtn.classList.toggle('paused', paused);
+    });
+    poll();
+  </script>
+</body>
+</html>
+`
@@ -0,0 +1,122 @@
+package gui
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"pgrollback/internal/tray"
+)
+
+// SessionHistoryItem is one query of GET /api/sessions/{testID}/history: the history entry plus its
+// kind, so the live page can highlight transaction control.
+type SessionHistoryItem struct {
+	QueryHistoryItem
+	Kind string `json:"kind,omitempty"` // begin, commit, rollback, savepoint; "" for other statements
+}
+
+// SessionHistory is the JSON returned by GET /api/sessions/{testID}/history.
+type SessionHistory struct {
+	TestID         string               `json:"test_id"`
+	Active         bool                 `json:"active"`
+	InTransaction  bool                 `json:"in_transaction"`
+	SavepointLevel int                  `json:"savepoint_level"`
+	OpenTxHolder   string               `json:"open_tx_holder"`
+	CreatedObjects []string             `json:"created_objects"`
+	DroppedObjects []string             `json:"dropped_objects"`
+	History        []SessionHistoryItem `json:"history"` // oldest first
+}
+
+// queryKind classifies a history entry for highlighting. Entries may start with the "[client address] "
+// label of extended-protocol queries and with comments.
+func queryKind(query string) string {
+	q := strings.TrimSpace(query)
+	if strings.HasPrefix(q, "[") {
+		if i := strings.Index(q, "] "); i >= 0 {
+			q = strings.TrimSpace(q[i+2:])
+		}
+	}
+	for {
+		switch {
+		case strings.HasPrefix(q, "--"):
+			i := strings.IndexByte(q, '\n')
+			if i < 0 {
+				return ""
+			}
+			q = strings.TrimSpace(q[i+1:])
+			continue
+		case strings.HasPrefix(q, "/*"):
+			i := strings.Index(q, "*/")
+			if i < 0 {
+				return ""
+			}
+			q = strings.TrimSpace(q[i+2:])
+			continue
+		}
+		break
+	}
+	end := strings.IndexFunc(q, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z')
+	})
+	if end < 0 {
+		end = len(q)
+	}
+	switch strings.ToUpper(q[:end]) {
+	case "BEGIN", "START":
+		return "begin"
+	case "COMMIT", "END":
+		return "commit"
+	case "ROLLBACK", "ABORT":
+		return "rollback"
+	case "SAVEPOINT", "RELEASE":
+		return "savepoint"
+	}
+	return ""
+}
+
+func handleAPISessionHistory(provider SessionProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		testID := r.PathValue("testID")
+		for _, s := range provider.GetSessions() {
+			if s.TestID != testID {
+				continue
+			}
+			resp := SessionHistory{
+				TestID:         s.TestID,
+				Active:         s.Active,
+				InTransaction:  s.InTransaction,
+				SavepointLevel: s.SavepointLevel,
+				OpenTxHolder:   s.OpenTxHolder,
+				CreatedObjects: s.CreatedObjects,
+				DroppedObjects: s.DroppedObjects,
+				History:        make([]SessionHistoryItem, len(s.QueryHistory)),
+			}
+			for i, item := range s.QueryHistory {
+				resp.History[i] = SessionHistoryItem{QueryHistoryItem: item, Kind: queryKind(item.Query)}
+			}
+			w.Header().Set("Content-Type", "application/json")
+			_ = json.NewEncoder(w).Encode(resp)
+			return
+		}
+		http.Error(w, "session not found", http.StatusNotFound)
+	}
+}
+
+// serveSessionPage serves the live query page of one session; the page reads the test ID from its URL.
+func serveSessionPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(SessionPageHTML()))
+}
+
+// SessionPageHTMLWithBase returns the live session page with API path prefix and favicon set.
+func SessionPageHTMLWithBase(base string) string {
+	s := strings.ReplaceAll(sessionPageTemplate, apiBasePlaceholder, apiBaseFrom(base))
+	s = strings.ReplaceAll(s, homePlaceholder, homeFrom(base))
+	s = strings.ReplaceAll(s, faviconPlaceholder, tray.FaviconDataURI())
+	return s
+}
+
+// SessionPageHTML returns the live session page for the default routes (API at /api).
+func SessionPageHTML() string {
+	return SessionPageHTMLWithBase("")
+}
@@ -0,0 +1,106 @@
+package gui
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"pgrollback/internal/tray"
+)
+
+func TestHandleAPISessionHistory_ReturnsHistoryWithKinds(t *testing.T) {
+	provider := &mockProvider{
+		sessions: []SessionInfo{
+			{TestID: "other"},
+			{TestID: "test/1", Active: true, SavepointLevel: 1, QueryHistory: []QueryHistoryItem{
+				{Query: "BEGIN", At: "2026-01-01T00:00:00Z"},
+				{Query: "[127.0.0.1:5000] INSERT INTO t VALUES (1)", At: "2026-01-01T00:00:01Z", Duration: "1ms"},
+				{Query: "ROLLBACK", At: "2026-01-01T00:00:02Z"},
+			}},
+		},
+	}
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions/test%2F1/history", nil)
+	rec := httptest.NewRecorder()
+	NewMux(provider).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200 (body %q)", rec.Code, rec.Body.String())
+	}
+	var got SessionHistory
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.TestID != "test/1" || !got.Active || got.SavepointLevel != 1 || len(got.History) != 3 {
+		t.Fatalf("response = %+v", got)
+	}
+	for i, want := range []string{"begin", "", "rollback"} {
+		if got.History[i].Kind != want {
+			t.Errorf("history[%d].kind = %q, want %q", i, got.History[i].Kind, want)
+		}
+	}
+	if got.History[1].Duration != "1ms" || !strings.Contains(rec.Body.String(), `"query":"[127.0.0.1:5000] INSERT`) {
+		t.Errorf("history items should keep query, at and duration: %s", rec.Body.String())
+	}
+}
+
+func TestHandleAPISessionHistory_NotFound(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api/sessions/missing/history", nil)
+	rec := httptest.NewRecorder()
+	NewMux(&mockProvider{}).ServeHTTP(rec, req)
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
+
+func TestQueryKind(t *testing.T) {
+	tests := map[string]string{
+		"BEGIN":                             "begin",
+		"start transaction read only":       "begin",
+		"COMMIT;":                           "commit",
+		"rollback to savepoint a":           "rollback",
+		"[10.0.0.1:4242] ROLLBACK":          "rollback",
+		"SAVEPOINT a":                       "savepoint",
+		"/* app */ RELEASE SAVEPOINT a":     "savepoint",
+		"-- note\nbegin":                    "begin",
+		"SELECT 'ROLLBACK'":                 "",
+		"BEGINNING":                         "",
+		"":                                  "",
+		"/* unterminated comment ROLLBACK ": "",
+	}
+	for query, want := range tests {
+		if got := queryKind(query); got != want {
+			t.Errorf("queryKind(%q) = %q, want %q", query, got, want)
+		}
+	}
+}
+
+func TestServeSessionPage(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/gui/session/test-1", nil)
+	rec := httptest.NewRecorder()
+	NewMux(&mockProvider{}).ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, placeholder := range []string{apiBasePlaceholder, homePlaceholder, faviconPlaceholder} {
+		if strings.Contains(body, placeholder) {
+			t.Errorf("page still contains %s", placeholder)
+		}
+	}
+	if !strings.Contains(body, "'/api/sessions/' + encodeURIComponent(testID) + '/history'") {
+		t.Error("page should poll /api/sessions/{testID}/history")
+	}
+	if !strings.Contains(body, tray.FaviconDataURI()) {
+		t.Error("page should use the tray favicon")
+	}
+}
+
+func TestHTML_LinksToSessionPage(t *testing.T) {
+	if !strings.Contains(HTML(), `href="/gui/session/' + encodeURIComponent(s.test_id)`) {
+		t.Error("sessions table should link to /gui/session/{testID}")
+	}
+	if !strings.Contains(HTMLWithBase("/pgr"), `href="/pgr/gui/session/`) {
+		t.Error("session link should follow the base path")
+	}
+}
@@ -1,6 +1,8 @@
 package proxy
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
@@ -17,13 +19,9 @@ func (a *sessionProviderAdapter) GetSessions() []gui.SessionInfo {
 	sessions := a.s.PgRollback.GetAllSessions()
 	list := make([]gui.SessionInfo, 0, len(sessions))
 	for testID, session := range sessions {
-		inTransaction := false
-		lastQuery := ""
+		snap := session.Snapshot()
 		var queryHistory []gui.QueryHistoryItem
-		lastQueryDuration := session.GetLastQueryDuration()
 		if session.DB != nil {
-			inTransaction = session.DB.HasOpenUserTransaction()
-			lastQuery = session.DB.Gui.GetLastQuery()
 			entries := session.DB.Gui.GetQueryHistory()
 			queryHistory = make([]gui.QueryHistoryItem, len(entries))
 			for i, e := range entries {
@@ -32,23 +30,72 @@ func (a *sessionProviderAdapter) GetSessions() []gui.SessionInfo {
 		}
 		list = append(list, gui.SessionInfo{
 			TestID:            testID,
-			InTransaction:     inTransaction,
-			LastQuery:         lastQuery,
-			LastQueryDuration: lastQueryDuration,
+			InTransaction:     snap.OpenUserTx,
+			LastQuery:         snap.LastQuery,
+			LastQueryDuration: session.GetLastQueryDuration(),
 			QueryHistory:      queryHistory,
+			Active:            snap.Active,
+			SavepointLevel:    snap.SavepointLevel,
+			CreatedAt:         snap.CreatedAt.Format(time.RFC3339),
+			LastActivity:      snap.LastActivity.Format(time.RFC3339),
+			OpenUserTx:        snap.OpenUserTx,
+			OpenTxHolder:      snap.OpenTxHolder,
+			CreatedObjects:    snap.CreatedObjects,
+			DroppedObjects:    snap.DroppedObjects,
 		})
 	}
 	return list
 }
 
+// Ready implements gui.ReadinessChecker (GET /healthz).
+func (a *sessionProviderAdapter) Ready(ctx context.Context) error {
+	return a.s.Ready(ctx)
+}
+
+// ConnectionStats implements gui.StatsProvider (GET /api/stats).
+func (a *sessionProviderAdapter) ConnectionStats() gui.ConnectionStats {
+	st := a.s.ConnectionStats()
+	return gui.ConnectionStats{
+		CurrentConnections:  st.Current,
+		PeakConnections:     st.Peak,
+		MaxConnections:      st.Max,
+		RejectedConnections: st.Rejected,
+	}
+}
+
+// ServerStatus implements gui.ServerStatusProvider (GET /api/server).
+func (a *sessionProviderAdapter) ServerStatus() gui.ServerStatus {
+	st := a.s.Status()
+	return gui.ServerStatus{
+		Version:            st.Version,
+		StartedAt:          st.StartedAt.Format(time.RFC3339),
+		Uptime:             st.Uptime.Truncate(time.Second).String(),
+		UptimeSeconds:      int64(st.Uptime / time.Second),
+		ListenAddress:      st.ListenAddress,
+		BackendHost:        st.BackendHost,
+		BackendPort:        st.BackendPort,
+		BackendDatabase:    st.BackendDatabase,
+		BackendVersion:     st.BackendVersion,
+		ActiveSessions:     st.ActiveSessions,
+		PeakSessions:       st.PeakSessions,
+		CurrentConnections: st.Connections.Current,
+		PeakConnections:    st.Connections.Peak,
+		QueriesProcessed:   st.QueriesProcessed,
+	}
+}
+
 func (a *sessionProviderAdapter) DestroySession(testID string) error {
-	return a.s.PgRollback.DestroySession(testID)
+	err := a.s.PgRollback.DestroySession(testID)
+	if errors.Is(err, ErrSessionNotFound) {
+		return fmt.Errorf("%w for test_id: %s", gui.ErrSessionNotFound, testID)
+	}
+	return err
 }
 
 func (a *sessionProviderAdapter) ClearHistory(testID string) error {
 	session := a.s.PgRollback.GetSession(testID)
 	if session == nil {
-		return fmt.Errorf("session not found")
+		return fmt.Errorf("%w for test_id: %s", gui.ErrSessionNotFound, testID)
 	}
 	if session.DB != nil {
 		session.DB.Gui.ClearQueryHistory()
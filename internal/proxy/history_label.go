@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Connection label of query history entries (proxy.query_history_label).
+//
+// The queries a client connection runs are stored in its session's history (GUI, "pgrollback history")
+// as "[label] query", so that the queries of several connections sharing a test ID can be told apart.
+// The label is built once per connection from a template with these fields: {addr} is the client
+// address, {conn} the connection's number since the proxy started (1, 2, …), {test_id} the session's
+// test ID and {app} the application_name of the connection. An empty template stores queries unlabeled.
+
+// DefaultQueryHistoryLabel is the default proxy.query_history_label: the client address.
+const DefaultQueryHistoryLabel = "{addr}"
+
+// QueryHistoryLabelFields are the fields a proxy.query_history_label template may use.
+var QueryHistoryLabelFields = []string{"{addr}", "{conn}", "{test_id}", "{app}"}
+
+// expandHistoryLabel fills the fields of template for one client connection.
+func expandHistoryLabel(template, addr string, seq uint64, testID, app string) string {
+	if template == "" {
+		return ""
+	}
+	return strings.NewReplacer(
+		"{addr}", addr,
+		"{conn}", strconv.FormatUint(seq, 10),
+		"{test_id}", testID,
+		"{app}", app,
+	).Replace(template)
+}
+
+// labelQuery returns query as stored in the history for a connection labeled connLabel.
+func labelQuery(query, connLabel string) string {
+	if connLabel = strings.TrimSpace(connLabel); connLabel == "" {
+		return query
+	}
+	return "[" + connLabel + "] " + query
+}
+
+// recordQuery appends query to the history of db under this connection's label.
+func (p *proxyConnection) recordQuery(db *realSessionDB, query string) {
+	db.Gui.SetLastQueryFrom(query, p.historyLabel)
+}
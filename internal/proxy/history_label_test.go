@@ -0,0 +1,43 @@
+package proxy
+
+import "testing"
+
+func TestExpandHistoryLabel(t *testing.T) {
+	tests := []struct {
+		template string
+		want     string
+	}{
+		{DefaultQueryHistoryLabel, "10.0.0.5:50432"},
+		{"#{conn} {test_id}", "#7 checkout"},
+		{"{app}@{addr}", "pgrollback_checkout@10.0.0.5:50432"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := expandHistoryLabel(tt.template, "10.0.0.5:50432", 7, "checkout", "pgrollback_checkout"); got != tt.want {
+			t.Errorf("expandHistoryLabel(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestSetLastQueryWithParams_LabelsQueriesWithAndWithoutArgs(t *testing.T) {
+	db := newTestSessionDB()
+	db.SetLastQueryWithParams("SELECT $1", []any{int32(1)}, "#2")
+	db.SetLastQueryWithParams("SELECT 2", nil, "#2")
+	db.SetLastQueryWithParams("DEALLOCATE pdo_stmt_00000001", nil, "#2")
+	history := db.Gui.GetQueryHistory()
+	if len(history) != 2 || history[0].Query != "[#2] SELECT 1" || history[1].Query != "[#2] SELECT 2" {
+		t.Errorf("history = %+v, want [#2] SELECT 1 and [#2] SELECT 2", history)
+	}
+}
+
+func TestRecordQuery_UsesConnectionLabel(t *testing.T) {
+	db := newTestSessionDB()
+	labeled := &proxyConnection{historyLabel: "#1 a"}
+	unlabeled := &proxyConnection{}
+	labeled.recordQuery(db, "INSERT INTO t VALUES (1)")
+	unlabeled.recordQuery(db, "SELECT * FROM t")
+	history := db.Gui.GetQueryHistory()
+	if len(history) != 2 || history[0].Query != "[#1 a] INSERT INTO t VALUES (1)" || history[1].Query != "SELECT * FROM t" {
+		t.Errorf("history = %+v", history)
+	}
+}
@@ -3,10 +3,13 @@ package proxy
 import (
 	"fmt"
 	"log"
+	"strconv"
 	"strings"
 	"time"
 
 	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
 )
 
 const (
@@ -18,85 +21,126 @@ const (
 
 // InterceptQuery intercepta e modifica queries específicas antes da execução.
 // connID is the connection making the request; pass 0 when there is no connection (e.g. tests). When connID != 0, BEGIN fails if another connection already has an open transaction.
-// PGROLLBACK commands are checked first (not valid SQL). TCL (BEGIN/COMMIT/ROLLBACK) is detected via AST when parse succeeds.
+// PGROLLBACK commands are checked first (not valid SQL). TCL (BEGIN/COMMIT/ROLLBACK) is classified on the
+// AST of the first statement, so comments, odd casing and identifiers such as "beginner" cannot misfire;
+// the string prefixes are only a fallback when the query does not parse. Statements rejected by the
+// allow/deny lists fail with 42501 before anything runs (see statement_policy.go), and commands that
+// cannot run inside a transaction block fail with 25001 (see non_transactional.go).
 func (p *PgRollback) InterceptQuery(testID string, query string, connID ConnectionID) (string, error) {
 	queryTrimmed := strings.TrimSpace(query)
 	queryUpper := strings.ToUpper(queryTrimmed)
 
 	if strings.HasPrefix(queryUpper, "PGROLLBACK") {
-		return p.interceptPgRollbackCommand(testID, queryTrimmed)
+		return p.interceptPgRollbackCommand(testID, queryTrimmed, connID)
 	}
-
-	// Multi-statement simple query starting with BEGIN: intercept only the first statement.
-	// Otherwise ParseStatements sees BEGIN first and InterceptQuery would replace the entire
-	// string with SAVEPOINT, dropping CREATE TABLE / INSERT / etc.
-	parts := sql.SplitCommandsFallback(queryTrimmed)
-	var nonEmpty []string
-	for _, part := range parts {
-		if t := strings.TrimSpace(part); t != "" {
-			nonEmpty = append(nonEmpty, t)
-		}
+	if err := p.checkStatementPolicy(query); err != nil {
+		return "", err
 	}
-	if len(nonEmpty) >= 2 {
-		first := nonEmpty[0]
-		firstUpper := strings.ToUpper(first)
-		if strings.HasPrefix(firstUpper, "BEGIN") && !strings.Contains(firstUpper, "SAVEPOINT") {
-			isBegin := false
-			if stmts1, err1 := sql.ParseStatements(first); err1 == nil && len(stmts1) > 0 && stmts1[0].Stmt != nil {
-				if sql.IsTransactionBegin(stmts1[0].Stmt) {
-					isBegin = true
-				}
-			}
-			if !isBegin && strings.HasPrefix(strings.TrimSpace(firstUpper), "BEGIN") {
-				isBegin = true
-			}
-			if isBegin {
-				rewrittenFirst, err := p.interceptBegin(testID, connID)
-				if err != nil {
-					return "", err
-				}
-				rest := strings.Join(nonEmpty[1:], "; ")
-				if rest == "" {
-					return rewrittenFirst, nil
-				}
-				return rewrittenFirst + "; " + rest, nil
-			}
-		}
+	if err := checkNonTransactional(query, queryUpper); err != nil {
+		return "", err
+	}
+
+	switch kind, opts, rest := classifyClientTCL(query); kind {
+	case clientBegin:
+		return p.interceptBeginWithRest(testID, connID, opts, rest)
+	case clientCommit:
+		return p.interceptCommit(testID)
+	case clientRollback:
+		return p.interceptRollback(testID)
 	}
+	return query, nil
+}
+
+// clientTCL is the transaction command a client query starts with, as far as InterceptQuery is concerned.
+type clientTCL int
+
+const (
+	clientOther clientTCL = iota
+	clientBegin
+	clientCommit
+	clientRollback
+)
 
+// classifyClientTCL tells whether query is a BEGIN, COMMIT or ROLLBACK to be converted; for BEGIN it also
+// returns the transaction characteristics and the statements that follow it in the same query.
+func classifyClientTCL(query string) (clientTCL, sql.TransactionOptions, []string) {
 	stmts, err := sql.ParseStatements(query)
 	if err == nil && len(stmts) > 0 && stmts[0].Stmt != nil {
 		stmt := stmts[0].Stmt
-		if sql.IsTransactionBegin(stmt) {
-			return p.interceptBegin(testID, connID)
+		if opts, ok := sql.ParseTransactionOptions(stmt); ok {
+			// Multi-statement simple query starting with BEGIN: rewrite only the first statement and
+			// keep the rest (CREATE TABLE / INSERT / ...) after the savepoint.
+			var rest []string
+			for _, raw := range stmts[1:] {
+				if c := sql.CommandStringFromRaw(query, raw); c != "" {
+					rest = append(rest, c)
+				}
+			}
+			return clientBegin, opts, rest
 		}
 		if sql.IsTransactionCommit(stmt) {
-			return p.interceptCommit(testID)
+			return clientCommit, sql.TransactionOptions{}, nil
 		}
 		if sql.IsTransactionRollback(stmt) {
-			return p.interceptRollback(testID)
+			return clientRollback, sql.TransactionOptions{}, nil
 		}
-		return query, nil
+		return clientOther, sql.TransactionOptions{}, nil
 	}
 
-	// Fallback when parse fails (e.g. malformed SQL)
-	if strings.HasPrefix(queryUpper, "BEGIN") {
-		return p.interceptBegin(testID, connID)
+	// Fallback when parse fails (e.g. malformed SQL after a valid BEGIN).
+	queryUpper := strings.ToUpper(strings.TrimSpace(query))
+	var nonEmpty []string
+	for _, part := range sql.SplitCommandsFallback(strings.TrimSpace(query)) {
+		if t := strings.TrimSpace(part); t != "" {
+			nonEmpty = append(nonEmpty, t)
+		}
 	}
-	if strings.HasPrefix(queryUpper, "COMMIT") {
-		return p.interceptCommit(testID)
+	if len(nonEmpty) >= 2 && hasKeywordPrefix(strings.ToUpper(nonEmpty[0]), "BEGIN") {
+		return clientBegin, sql.TransactionOptions{}, nonEmpty[1:]
 	}
-	if strings.HasPrefix(queryUpper, "ROLLBACK") && !strings.Contains(queryUpper, "SAVEPOINT") {
-		return p.interceptRollback(testID)
+	if hasKeywordPrefix(queryUpper, "BEGIN") {
+		return clientBegin, sql.TransactionOptions{}, nil
+	}
+	if hasKeywordPrefix(queryUpper, "COMMIT") {
+		return clientCommit, sql.TransactionOptions{}, nil
 	}
+	if hasKeywordPrefix(queryUpper, "ROLLBACK") && !strings.Contains(queryUpper, "SAVEPOINT") {
+		return clientRollback, sql.TransactionOptions{}, nil
+	}
+	return clientOther, sql.TransactionOptions{}, nil
+}
 
-	return query, nil
+// interceptBeginWithRest rewrites a BEGIN into its savepoint and appends the statements that followed
+// it in the same query.
+func (p *PgRollback) interceptBeginWithRest(testID string, connID ConnectionID, opts sql.TransactionOptions, rest []string) (string, error) {
+	rewritten, err := p.interceptBegin(testID, connID)
+	if err != nil {
+		return "", err
+	}
+	p.warnIgnoredTransactionOptions(testID, opts)
+	if len(rest) == 0 {
+		return rewritten, nil
+	}
+	return rewritten + "; " + strings.Join(rest, "; "), nil
+}
+
+// hasKeywordPrefix reports whether upper (an upper-cased query) starts with the keyword kw as a whole
+// word, so "BEGINNER" or "COMMITTED_AT" do not count as BEGIN or COMMIT.
+func hasKeywordPrefix(upper, kw string) bool {
+	if !strings.HasPrefix(upper, kw) {
+		return false
+	}
+	if len(upper) == len(kw) {
+		return true
+	}
+	c := upper[len(kw)]
+	return !(c == '_' || c == '$' || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || c >= 0x80)
 }
 
 // interceptPgRollbackCommand processa comandos PgRollback especiais
 // Usa o testID da sessão quando disponível, evitando a necessidade de passá-lo como parâmetro
-func (p *PgRollback) interceptPgRollbackCommand(testID string, query string) (string, error) {
-	parts := strings.Fields(query)
+func (p *PgRollback) interceptPgRollbackCommand(testID string, query string, connID ConnectionID) (string, error) {
+	parts := strings.Fields(trimCommandTerminator(query))
 	if len(parts) < 2 {
 		return "", fmt.Errorf("comando pgrollback inválido: %s", query)
 	}
@@ -115,6 +159,10 @@ func (p *PgRollback) interceptPgRollbackCommand(testID string, query string) (st
 		log.Printf("[PGROLLBACK] rollback requested for testID=%s", testID)
 		return p.RollbackBaseTransaction(testID)
 
+	case "reset":
+		log.Printf("[PGROLLBACK] reset requested for testID=%s", testID)
+		return p.interceptReset(testID, connID)
+
 	case "disconnect":
 		log.Printf("[PGROLLBACK] disconnect requested for testID=%s", testID)
 		if session := p.GetSession(testID); session != nil {
@@ -122,12 +170,27 @@ func (p *PgRollback) interceptPgRollbackCommand(testID string, query string) (st
 		}
 		return DISCONNECT_SENTINEL, nil
 
+	case "savepoint", "release":
+		return p.handleNamedSavepointCommand(testID, action, parts[2:])
+
+	case "persist":
+		return p.handlePersistCommand(testID, parts[2:])
+
+	case "explain":
+		return p.handleExplainCommand(testID, parts[2:])
+
 	case "status":
 		return p.buildStatusResultSet(testID)
 
 	case "list":
 		return p.buildListResultSet()
 
+	case "history":
+		return p.buildHistoryResultSet(testID, parts[2:])
+
+	case "whoami":
+		return p.buildWhoamiResultSet(testID, "")
+
 	case "cleanup":
 		cleaned, err := p.CleanupExpiredSessions()
 		if err != nil {
@@ -147,7 +210,7 @@ func (p *PgRollback) interceptPgRollbackCommand(testID string, query string) (st
 // - Cada BEGIN cria um novo savepoint, permitindo rollback aninhado
 // - O primeiro BEGIN (SavepointLevel = 0) marca o "ponto de início" desta conexão/cliente
 // - Savepoints subsequentes permitem rollback parcial dentro da mesma conexão
-// - When connID != 0, BEGIN fails with ErrOnlyOneTransactionAtATime if connectionWithOpenTx is another connection.
+// - When connID != 0, BEGIN fails with TransactionInUseError (SQLSTATE 55006) if connectionWithOpenTx is another connection (after waiting up to BeginWaitTimeout).
 //
 // Caso de uso PHP:
 // - PHP conecta → executa BEGIN → cria savepoint pgrollback_v_1 (ponto de início)
@@ -162,6 +225,62 @@ func (p *PgRollback) interceptBegin(testID string, connID ConnectionID) (string,
 	return session.handleBegin(testID, connID)
 }
 
+// warnIgnoredTransactionOptions queues a WARNING for the characteristics of a BEGIN (isolation level,
+// READ ONLY, DEFERRABLE), which pgrollback cannot honour: the client's BEGIN becomes a savepoint in the
+// base transaction, whose characteristics are already fixed. PostgreSQL refuses SET TRANSACTION
+// ISOLATION LEVEL in a subtransaction, and READ ONLY set there would outlive the savepoint after COMMIT
+// (RELEASE) with no way back to read-write. The warning reaches the client with the BEGIN's
+// CommandComplete, like a backend notice.
+func (p *PgRollback) warnIgnoredTransactionOptions(testID string, opts sql.TransactionOptions) {
+	if opts.IsZero() {
+		return
+	}
+	session := p.GetSession(testID)
+	if session == nil || session.DB == nil || session.DB.notices == nil {
+		return
+	}
+	session.DB.notices.queue(&pgconn.Notice{
+		Severity: "WARNING",
+		Code:     "01000",
+		Message:  fmt.Sprintf("transaction characteristics ignored by pgrollback: %s", opts),
+		Detail:   "BEGIN runs as a savepoint inside the test's base transaction, which keeps its own isolation level and access mode.",
+		Hint:     "Tests that depend on these characteristics behave differently than against PostgreSQL directly.",
+	})
+}
+
+// warnNotificationsNeverDelivered queues a WARNING when query has a LISTEN, UNLISTEN or NOTIFY. They
+// still run on the backend, but PostgreSQL only delivers a NOTIFY when its transaction commits, and the
+// test's base transaction never does: a NOTIFY sent through the proxy reaches no one, and a LISTENer on
+// the proxy is never told about anything (the shared backend connection does the listening, not the
+// client). Called once per client query (Simple Query or Parse), not from InterceptQuery, which runs
+// again on the already intercepted text.
+func (p *PgRollback) warnNotificationsNeverDelivered(testID string, query string) {
+	stmts, err := sql.ParseStatements(query)
+	if err != nil {
+		return
+	}
+	command := ""
+	for _, raw := range stmts {
+		if command = sql.NotificationCommand(raw.Stmt); command != "" {
+			break
+		}
+	}
+	if command == "" {
+		return
+	}
+	session := p.GetSession(testID)
+	if session == nil || session.DB == nil || session.DB.notices == nil {
+		return
+	}
+	session.DB.notices.queue(&pgconn.Notice{
+		Severity: "WARNING",
+		Code:     "01000",
+		Message:  fmt.Sprintf("%s has no effect under pgrollback: notifications are never delivered", command),
+		Detail:   "PostgreSQL delivers a NOTIFY only when its transaction commits, and the test's base transaction is always rolled back.",
+		Hint:     "Test LISTEN/NOTIFY against PostgreSQL directly, without the proxy.",
+	})
+}
+
 // interceptCommit converte COMMIT em RELEASE SAVEPOINT
 func (p *PgRollback) interceptCommit(testID string) (string, error) {
 	session := p.GetSession(testID)
@@ -189,6 +308,15 @@ func (p *PgRollback) interceptRollback(testID string) (string, error) {
 	return session.handleRollback(testID)
 }
 
+// interceptReset converte "pgrollback reset" em ROLLBACK TO SAVEPOINT pgrollback_v_1 (ver TestSession.handleReset).
+func (p *PgRollback) interceptReset(testID string, connID ConnectionID) (string, error) {
+	session := p.GetSession(testID)
+	if session == nil {
+		return "", fmt.Errorf("sessão não encontrada para testID: %s", testID)
+	}
+	return session.handleReset(testID, connID)
+}
+
 // buildStatusResultSet constrói uma query SELECT para status de uma sessão
 func (p *PgRollback) buildStatusResultSet(testID string) (string, error) {
 	session := p.GetSession(testID)
@@ -198,6 +326,59 @@ func (p *PgRollback) buildStatusResultSet(testID string) (string, error) {
 	return session.buildStatusResultSet(testID)
 }
 
+// buildHistoryResultSet constrói uma query SELECT com as últimas N queries da sessão ("pgrollback history [N]").
+// Sem N, devolve o histórico inteiro (no máximo proxy.query_history_size; vazio quando desligado).
+func (p *PgRollback) buildHistoryResultSet(testID string, args []string) (string, error) {
+	n := -1
+	if len(args) > 0 {
+		parsed, err := strconv.Atoi(args[0])
+		if err != nil || parsed <= 0 {
+			return "", fmt.Errorf("pgrollback history: N deve ser um inteiro positivo, recebido %q", args[0])
+		}
+		n = parsed
+	}
+	session := p.GetSession(testID)
+	if session == nil || session.DB == nil {
+		return "", fmt.Errorf("Session with testID '%s', was not found", testID)
+	}
+	limit := session.DB.Gui.historyLimit()
+	if n < 0 || n > limit {
+		n = limit
+	}
+	return historyResultSetQuery(session.DB.Gui.GetQueryHistory(), n), nil
+}
+
+// buildWhoamiResultSet constrói uma linha com o test ID, o application_name que o escolheu, o banco
+// da sessão (postgres.routes ou o padrão) e o nível de savepoint ("pgrollback whoami").
+func (p *PgRollback) buildWhoamiResultSet(testID, applicationName string) (string, error) {
+	session := p.GetSession(testID)
+	if session == nil || session.DB == nil {
+		return "", fmt.Errorf("Session with testID '%s', was not found", testID)
+	}
+	target, _ := p.backendFor(testID)
+	return fmt.Sprintf("SELECT %s AS test_id, %s AS application_name, %s AS backend_host, %s AS backend_db, %d AS savepoint_level",
+		textLiteralOrNull(testID), textLiteralOrNull(applicationName), textLiteralOrNull(target.Host),
+		textLiteralOrNull(target.Database), session.DB.GetSavepointLevel()), nil
+}
+
+// trimCommandTerminator drops the trailing ";" (and whitespace) a client may end a pgrollback command
+// with, as psql users do.
+func trimCommandTerminator(query string) string {
+	return strings.TrimRight(query, "; \t\r\n")
+}
+
+// interceptQuery is PgRollback.InterceptQuery for this connection. "pgrollback whoami" is answered here,
+// with the application_name this connection started with; everything else goes to InterceptQuery.
+func (p *proxyConnection) interceptQuery(testID, query string) (string, error) {
+	if fields := strings.Fields(trimCommandTerminator(query)); len(fields) == 2 && strings.EqualFold(fields[0], "pgrollback") && strings.EqualFold(fields[1], "whoami") {
+		return p.server.PgRollback.buildWhoamiResultSet(testID, p.applicationName)
+	}
+	span := p.startSpan("pgrollback.intercept", testID, query)
+	intercepted, err := p.server.PgRollback.InterceptQuery(testID, query, p.connectionID())
+	endSpan(span, err)
+	return intercepted, err
+}
+
 // buildListResultSet constrói uma query SELECT para listar todas as sessões
 func (p *PgRollback) buildListResultSet() (string, error) {
 	sessions := p.GetAllSessions()
@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestInterceptQuery_ClassifiesTCLOnAST checks the dispatch of InterceptQuery without a backend: with
+// no session, BEGIN/COMMIT/ROLLBACK fail with their own "session not found" errors, and anything else
+// comes back unchanged.
+func TestInterceptQuery_ClassifiesTCLOnAST(t *testing.T) {
+	p := NewPgRollback("localhost", 5432, "postgres", "postgres", "", 0, 0, 0)
+	tests := []struct {
+		query string
+		want  string // substring of the error; "" = returned unchanged
+	}{
+		{"/* leading */ COMMIT", "Commit"},
+		{"-- comment\nbegin", "Session not found"},
+		{"BeGiN WoRk", "Session not found"},
+		{"START TRANSACTION READ ONLY", "Session not found"},
+		{"  rollback;", "no session"},
+		{"BEGIN; SELEC 1", "Session not found"}, // parse fails: string fallback, still BEGIN
+		{"SELECT beginner, committed FROM t", ""},
+		{"BEGINNER", ""},
+		{"COMMITTED_AT = 1", ""},
+		{"ROLLBACK TO SAVEPOINT sp1", ""},
+		{"/* BEGIN */ SELECT 1", ""},
+	}
+	for _, tt := range tests {
+		got, err := p.InterceptQuery("t1", tt.query, 0)
+		if tt.want == "" {
+			if err != nil || got != tt.query {
+				t.Errorf("InterceptQuery(%q) = %q, %v; want it unchanged", tt.query, got, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("InterceptQuery(%q) err = %v; want one containing %q", tt.query, err, tt.want)
+		}
+	}
+}
+
+func TestHasKeywordPrefix(t *testing.T) {
+	for _, tt := range []struct {
+		s    string
+		want bool
+	}{
+		{"BEGIN", true},
+		{"BEGIN;", true},
+		{"BEGIN ISOLATION LEVEL SERIALIZABLE", true},
+		{"BEGINNER", false},
+		{"BEGIN_AT", false},
+		{"BEGIN1", false},
+		{"BEGI", false},
+	} {
+		if got := hasKeywordPrefix(tt.s, "BEGIN"); got != tt.want {
+			t.Errorf("hasKeywordPrefix(%q, BEGIN) = %v, want %v", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestWarnNotificationsNeverDelivered(t *testing.T) {
+	p := NewPgRollback("localhost", 5432, "postgres", "postgres", "", 0, 0, 0)
+	db := newTestSessionDB()
+	db.notices = &backendNotices{}
+	p.SessionsByTestID["t1"] = &TestSession{DB: db, TestID: "t1"}
+
+	p.warnNotificationsNeverDelivered("t1", "SELECT 1; NOTIFY jobs, 'x'")
+	p.warnNotificationsNeverDelivered("t1", "SELECT pg_notify('jobs', 'x')")
+	notices := db.notices.drain()
+	if len(notices) != 1 || notices[0].Severity != "WARNING" || !strings.HasPrefix(notices[0].Message, "NOTIFY ") {
+		t.Fatalf("notices = %+v, want one WARNING about NOTIFY", notices)
+	}
+}
+
+// TestWhoami_ReportsConnectionAndBackend checks "pgrollback whoami": the connection's application_name
+// and the routed backend of its test ID, as a single SELECT.
+func TestWhoami_ReportsConnectionAndBackend(t *testing.T) {
+	pgr := NewPgRollback("default-db", 5432, "main", "postgres", "", 0, 0, 0)
+	routes, err := ParseBackendRoutes(map[string]string{"app1:": "postgres://u:p@db1:5432/app1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgr.Routes = routes
+	pgr.newFakeTestSession("app1:checkout")
+	pgr.newFakeTestSession("o'brien")
+	p := &proxyConnection{server: &Server{PgRollback: pgr}, applicationName: "app1:checkout"}
+
+	got, err := p.interceptQuery("app1:checkout", "PGROLLBACK  whoami")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "SELECT 'app1:checkout' AS test_id, 'app1:checkout' AS application_name, 'db1' AS backend_host, 'app1' AS backend_db, 0 AS savepoint_level"
+	if got != want {
+		t.Errorf("whoami = %s\nwant     %s", got, want)
+	}
+
+	// Without a connection (InterceptQuery) the application_name is unknown; literals are escaped.
+	got, err = pgr.InterceptQuery("o'brien", "pgrollback whoami", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "SELECT 'o''brien' AS test_id, NULL::text AS application_name, 'default-db' AS backend_host, 'main' AS backend_db, 0 AS savepoint_level"
+	if got != want {
+		t.Errorf("whoami = %s\nwant     %s", got, want)
+	}
+	if _, err := pgr.InterceptQuery("missing", "pgrollback whoami", 0); err == nil {
+		t.Error("whoami without a session succeeded")
+	}
+
+	// psql sends the terminating ";" along.
+	for _, q := range []string{"pgrollback whoami;", "pgrollback whoami ; \n"} {
+		if got, err := p.interceptQuery("app1:checkout", q); err != nil || !strings.Contains(got, "'app1:checkout' AS application_name") {
+			t.Errorf("interceptQuery(%q) = %q, %v; want the connection's whoami", q, got, err)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"log"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Per-test schema (proxy.isolate_schema).
+//
+// Sessions of different test IDs run in separate backend transactions, so they never see each other's
+// rows, but unqualified names still resolve in the shared schemas: two tests that CREATE TABLE foo at
+// the same time wait on each other (the catalog's unique index) and then one fails. With the option on,
+// each session gets its own schema, created in its base transaction, and a search_path that puts it
+// first: "SET search_path = <schema>, "$user", public" becomes the session's default, so unqualified
+// CREATEs land there while existing tables in public are still found. A client's own SET search_path
+// still wins for that connection. Temporary tables need no help: they live in the pg_temp schema of
+// each session's own backend connection.
+
+// isolationSchemaMaxIDLen caps the part of the schema name taken from the test ID.
+const isolationSchemaMaxIDLen = 40
+
+// isolationSchemaName returns the schema of testID: "pgrollback_", the test ID reduced to lowercase
+// letters, digits and underscores, and 8 hex digits of its SHA-1, so test IDs that reduce to the same
+// text still get different schemas and the name stays under PostgreSQL's 63-byte identifier limit.
+func isolationSchemaName(testID string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(testID) {
+		if b.Len() >= isolationSchemaMaxIDLen {
+			break
+		}
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	sum := sha1.Sum([]byte(testID))
+	return "pgrollback_" + b.String() + "_" + hex.EncodeToString(sum[:4])
+}
+
+// isolationSearchPathSQL is the statement that replaces "RESET search_path" for a session with schema.
+func isolationSearchPathSQL(schema string) string {
+	return `SET search_path = ` + pgx.Identifier{schema}.Sanitize() + `, "$user", public`
+}
+
+// defaultClientGUCs returns the statements that give the backend a fresh connection's tracked parameters.
+func defaultClientGUCs() map[string]string {
+	want := make(map[string]string, len(trackedClientGUCs))
+	for _, name := range trackedClientGUCs {
+		want[name] = defaultGUCSQL(name)
+	}
+	return want
+}
+
+// withIsolationSearchPathLocked returns want with the default search_path replaced by the session's
+// schema, when it has one. Caller must hold d.mu.
+func (d *realSessionDB) withIsolationSearchPathLocked(want map[string]string) map[string]string {
+	if d.isolationSchema == "" || want["search_path"] != defaultGUCSQL("search_path") {
+		return want
+	}
+	out := make(map[string]string, len(want))
+	for name, stmt := range want {
+		out[name] = stmt
+	}
+	out["search_path"] = isolationSearchPathSQL(d.isolationSchema)
+	return out
+}
+
+// ensureIsolationSchemaLocked creates the session's schema in the base transaction when it is missing:
+// at session start, and again after a rollback (ROLLBACK TO SAVEPOINT, a new base transaction, a
+// reconnect) undid its creation. The existence check keeps CREATE SCHEMA IF NOT EXISTS's notice away
+// from clients. Caller must hold d.mu and run it inside a savepoint guard.
+func (d *realSessionDB) ensureIsolationSchemaLocked(ctx context.Context) error {
+	if d.isolationSchema == "" || !d.hasActiveTransactionLocked() {
+		return nil
+	}
+	var exists bool
+	if err := d.tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM pg_catalog.pg_namespace WHERE nspname = $1)", d.isolationSchema).Scan(&exists); err != nil {
+		return err
+	}
+	if exists {
+		return nil
+	}
+	_, err := d.tx.Exec(ctx, "CREATE SCHEMA "+pgx.Identifier{d.isolationSchema}.Sanitize())
+	return err
+}
+
+// dropIsolationSchemaLocked rolls back the base transaction, which undoes an uncommitted schema, and
+// drops the schema outside it, for the one a persist-mode COMMIT left behind. Called when the session
+// is destroyed; failures are only logged. Caller must hold d.mu.
+func (d *realSessionDB) dropIsolationSchemaLocked(ctx context.Context) {
+	if d.isolationSchema == "" || d.conn == nil {
+		return
+	}
+	if d.hasActiveTransactionLocked() {
+		_ = d.tx.Rollback(ctx)
+		d.tx = nil
+	}
+	if _, err := d.conn.Exec(ctx, "DROP SCHEMA IF EXISTS "+pgx.Identifier{d.isolationSchema}.Sanitize()+" CASCADE"); err != nil {
+		log.Printf("[PROXY] failed to drop isolation schema %s: %v", d.isolationSchema, err)
+	}
+}
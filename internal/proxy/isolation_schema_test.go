@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIsolationSchemaName(t *testing.T) {
+	a := isolationSchemaName("Suite A/test-1")
+	if !strings.HasPrefix(a, "pgrollback_suite_a_test_1_") || len(a) != len("pgrollback_suite_a_test_1_")+8 {
+		t.Errorf("isolationSchemaName = %q, want pgrollback_suite_a_test_1_<8 hex>", a)
+	}
+	// Test IDs that reduce to the same text still get different schemas.
+	if b := isolationSchemaName("suite a test 1"); b == a {
+		t.Errorf("%q and %q share schema %s", "Suite A/test-1", "suite a test 1", a)
+	}
+	if long := isolationSchemaName(strings.Repeat("x", 200)); len(long) > 63 {
+		t.Errorf("schema of a long test ID = %q (%d bytes), want at most 63", long, len(long))
+	}
+}
+
+func TestWithIsolationSearchPath_ReplacesOnlyTheDefault(t *testing.T) {
+	db := newTestSessionDB()
+	want := defaultClientGUCs()
+	if got := db.withIsolationSearchPathLocked(want); got["search_path"] != "RESET search_path" {
+		t.Errorf("without a schema search_path = %q, want RESET", got["search_path"])
+	}
+
+	db.isolationSchema = "pgrollback_t_0000abcd"
+	got := db.withIsolationSearchPathLocked(want)
+	if got["search_path"] != `SET search_path = "pgrollback_t_0000abcd", "$user", public` {
+		t.Errorf("default search_path = %q, want the session schema first", got["search_path"])
+	}
+	if want["search_path"] != "RESET search_path" {
+		t.Error("the caller's map must not be modified")
+	}
+
+	// A client's own search_path is kept.
+	want["search_path"] = "SET search_path = app"
+	if got := db.withIsolationSearchPathLocked(want); got["search_path"] != "SET search_path = app" {
+		t.Errorf("client search_path = %q, want it unchanged", got["search_path"])
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRunKeepalive_PingsAtInterval(t *testing.T) {
+	var pings atomic.Int32
+	fired := make(chan struct{}, 1)
+	stop := runKeepalive(5*time.Millisecond, func() {
+		pings.Add(1)
+		select {
+		case fired <- struct{}{}:
+		default:
+		}
+	})
+	defer stop()
+
+	select {
+	case <-fired:
+	case <-time.After(2 * time.Second):
+		t.Fatal("keepalive ping did not fire within 2s for a 5ms interval")
+	}
+}
+
+func TestRunKeepalive_StopEndsGoroutine(t *testing.T) {
+	var pings atomic.Int32
+	stop := runKeepalive(time.Millisecond, func() { pings.Add(1) })
+	time.Sleep(20 * time.Millisecond)
+
+	stopped := make(chan struct{})
+	go func() {
+		stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("stop did not return")
+	}
+
+	after := pings.Load()
+	time.Sleep(20 * time.Millisecond)
+	if got := pings.Load(); got != after {
+		t.Errorf("pings after stop = %d, want %d (goroutine still running)", got, after)
+	}
+}
+
+func TestStopKeepaliveUnlocked_NoKeepaliveIsNoop(t *testing.T) {
+	db := newTestSessionDB()
+	db.startKeepalive(time.Millisecond) // nil conn: nothing started
+	if db.stopKeepalive != nil {
+		t.Fatal("startKeepalive without a connection should not start a goroutine")
+	}
+	db.stopKeepaliveUnlocked()
+}
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Lock wait watchdog (proxy.lock_wait_timeout).
+//
+// Each test ID holds one long-lived backend transaction, so a row or advisory lock taken by one test is
+// kept until that test's session ends. When another test needs it, its statement waits with no end in
+// sight: PostgreSQL only detects cycles it can see, not a test whose client waits on the other test
+// outside the database. The watchdog polls pg_stat_activity for backends of proxy sessions waiting on a
+// lock held by another proxy session and, once the wait exceeds the grace period, cancels one side and
+// reports 40P01 (deadlock_detected) to its client instead of 57014. Sessions routed to other databases
+// (postgres.routes) are polled on their own backend: a lock wait never crosses servers, and backend PIDs
+// are only unique within one.
+
+// lockWatchdogQuery lists, for the given backend PIDs waiting on a lock, each backend blocking them.
+const lockWatchdogQuery = `SELECT w.pid, b.pid,
+       EXTRACT(EPOCH FROM now() - w.query_start)::float8,
+       COALESCE(b.wait_event_type = 'Lock', false),
+       w.xact_start, b.xact_start
+  FROM pg_stat_activity w
+ CROSS JOIN LATERAL unnest(pg_blocking_pids(w.pid)) AS bp(pid)
+  JOIN pg_stat_activity b ON b.pid = bp.pid
+ WHERE w.wait_event_type = 'Lock' AND w.pid = ANY($1)`
+
+// Limites do intervalo de verificação (metade do lock_wait_timeout).
+const (
+	lockWatchdogMinInterval = 100 * time.Millisecond
+	lockWatchdogMaxInterval = 5 * time.Second
+)
+
+// lockWait is one (waiter, blocker) pair between two proxy session backends.
+type lockWait struct {
+	waiter, blocker                   uint32
+	waited                            time.Duration // how long the waiter's statement has been running
+	blockerWaiting                    bool          // the blocker is itself waiting on a lock (has a statement to cancel)
+	waiterXactStart, blockerXactStart time.Time
+}
+
+// chooseLockWaitVictims returns the backends to cancel for the waits older than grace, each at most once.
+// The younger transaction of a pair is cancelled, as it has the least work to lose; a younger blocker
+// that is idle has no statement to cancel, so the waiter is cancelled instead and the wait still ends.
+func chooseLockWaitVictims(waits []lockWait, grace time.Duration) []uint32 {
+	var victims []uint32
+	seen := make(map[uint32]bool)
+	for _, w := range waits {
+		if w.waited < grace || w.waiter == w.blocker {
+			continue
+		}
+		victim := w.waiter
+		if w.blockerWaiting && w.blockerXactStart.After(w.waiterXactStart) {
+			victim = w.blocker
+		}
+		if !seen[victim] {
+			seen[victim] = true
+			victims = append(victims, victim)
+		}
+	}
+	return victims
+}
+
+// lockWatchdog polls each backend on its own connection; it only runs in the goroutine started by start.
+type lockWatchdog struct {
+	pgr   *PgRollback
+	grace time.Duration
+	conns map[BackendTarget]*pgx.Conn
+}
+
+func newLockWatchdog(pgr *PgRollback, grace time.Duration) *lockWatchdog {
+	return &lockWatchdog{pgr: pgr, grace: grace, conns: make(map[BackendTarget]*pgx.Conn)}
+}
+
+// start runs the watchdog in a single goroutine; the returned func stops it and closes its connection.
+func (w *lockWatchdog) start() (stop func()) {
+	interval := w.grace / 2
+	if interval < lockWatchdogMinInterval {
+		interval = lockWatchdogMinInterval
+	}
+	if interval > lockWatchdogMaxInterval {
+		interval = lockWatchdogMaxInterval
+	}
+	stopTicker := runKeepalive(interval, w.tick)
+	return func() {
+		stopTicker()
+		w.closeConns()
+	}
+}
+
+// tick runs one check per backend: finds lock waits between proxy sessions and cancels the chosen victims.
+func (w *lockWatchdog) tick() {
+	for target, sessions := range w.sessionsByBackend() {
+		if len(sessions) < 2 {
+			continue
+		}
+		w.checkBackend(target, sessions)
+	}
+}
+
+func (w *lockWatchdog) checkBackend(target BackendTarget, sessions map[uint32]watchedSession) {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelRequestTimeout)
+	defer cancel()
+	waits, err := w.queryLockWaits(ctx, target, sessions)
+	if err != nil {
+		log.Printf("[PROXY] Lock watchdog query on %s failed: %v", target, err)
+		w.closeConn(target)
+		return
+	}
+	for _, pid := range chooseLockWaitVictims(waits, w.grace) {
+		w.cancelVictim(ctx, pid, waits, sessions)
+	}
+}
+
+// watchedSession is a proxy session backend seen by the watchdog.
+type watchedSession struct {
+	testID string
+	db     *realSessionDB
+}
+
+// sessionsByBackend snapshots the backend PID of every session, grouped by the database it runs on.
+func (w *lockWatchdog) sessionsByBackend() map[BackendTarget]map[uint32]watchedSession {
+	w.pgr.mu.RLock()
+	defer w.pgr.mu.RUnlock()
+	backends := make(map[BackendTarget]map[uint32]watchedSession)
+	for testID, s := range w.pgr.SessionsByTestID {
+		if s == nil || s.DB == nil || s.DB.cancelConn.Load() == nil {
+			continue
+		}
+		target, _ := w.pgr.backendFor(testID)
+		if backends[target] == nil {
+			backends[target] = make(map[uint32]watchedSession)
+		}
+		backends[target][s.DB.cancelConn.Load().PID()] = watchedSession{testID: testID, db: s.DB}
+	}
+	return backends
+}
+
+func (w *lockWatchdog) queryLockWaits(ctx context.Context, target BackendTarget, sessions map[uint32]watchedSession) ([]lockWait, error) {
+	conn := w.conns[target]
+	if conn == nil {
+		config, err := backendConnConfig(target.Host, target.Port, target.Database, target.User, target.Password, w.pgr.SessionTimeout, "pgrollback_watchdog")
+		if err != nil {
+			return nil, err
+		}
+		if conn, err = pgx.ConnectConfig(ctx, config); err != nil {
+			return nil, err
+		}
+		w.conns[target] = conn
+	}
+	pids := make([]int32, 0, len(sessions))
+	for pid := range sessions {
+		pids = append(pids, int32(pid))
+	}
+	rows, err := conn.Query(ctx, lockWatchdogQuery, pids)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var waits []lockWait
+	for rows.Next() {
+		var waiter, blocker int32
+		var seconds float64
+		var blockerWaiting bool
+		var waiterXactStart, blockerXactStart *time.Time
+		if err := rows.Scan(&waiter, &blocker, &seconds, &blockerWaiting, &waiterXactStart, &blockerXactStart); err != nil {
+			return nil, err
+		}
+		// Only waits between two proxy sessions: anything else is not ours to break.
+		if _, ok := sessions[uint32(blocker)]; !ok {
+			continue
+		}
+		lw := lockWait{
+			waiter:         uint32(waiter),
+			blocker:        uint32(blocker),
+			waited:         time.Duration(seconds * float64(time.Second)),
+			blockerWaiting: blockerWaiting,
+		}
+		if waiterXactStart != nil {
+			lw.waiterXactStart = *waiterXactStart
+		}
+		if blockerXactStart != nil {
+			lw.blockerXactStart = *blockerXactStart
+		}
+		waits = append(waits, lw)
+	}
+	return waits, rows.Err()
+}
+
+// cancelVictim marks the victim's session so its client gets 40P01 and cancels the backend statement.
+func (w *lockWatchdog) cancelVictim(ctx context.Context, pid uint32, waits []lockWait, sessions map[uint32]watchedSession) {
+	victim := sessions[pid]
+	var reason string
+	for _, lw := range waits {
+		if pid == lw.waiter || pid == lw.blocker {
+			reason = fmt.Sprintf("Test session %q waited more than %s for a lock held by test session %q.",
+				sessions[lw.waiter].testID, w.grace, sessions[lw.blocker].testID)
+			break
+		}
+	}
+	log.Printf("[PROXY] Lock watchdog: cancelling statement of test session %q (backend pid %d): %s", victim.testID, pid, reason)
+	victim.db.deadlockVictim.Store(&reason)
+	if err := victim.db.cancelConn.Load().CancelRequest(ctx); err != nil {
+		victim.db.deadlockVictim.Store(nil)
+		log.Printf("[PROXY] Lock watchdog: CancelRequest to backend failed: %v", err)
+	}
+}
+
+func (w *lockWatchdog) closeConn(target BackendTarget) {
+	conn := w.conns[target]
+	if conn == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cancelRequestTimeout)
+	defer cancel()
+	_ = conn.Close(ctx)
+	delete(w.conns, target)
+}
+
+func (w *lockWatchdog) closeConns() {
+	for target := range w.conns {
+		w.closeConn(target)
+	}
+}
+
+// translateDeadlockCancel turns the query_canceled error of a statement cancelled by the lock watchdog
+// into 40P01 (deadlock_detected), so drivers and tests see why it failed and may retry. It also clears
+// the mark, so call it after every statement, err nil or not.
+func (d *realSessionDB) translateDeadlockCancel(err error) error {
+	if d == nil {
+		return err
+	}
+	reason := d.deadlockVictim.Swap(nil)
+	if reason == nil || err == nil {
+		return err
+	}
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != "57014" {
+		return err
+	}
+	return &pgconn.PgError{
+		Severity: "ERROR",
+		Code:     "40P01",
+		Message:  "deadlock detected",
+		Detail:   *reason,
+		Hint:     "The statement was cancelled by the pgrollback lock watchdog (proxy.lock_wait_timeout); retry it once the other test session releases the lock.",
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestChooseLockWaitVictims(t *testing.T) {
+	older := time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)
+	younger := older.Add(time.Minute)
+	grace := 5 * time.Second
+
+	tests := []struct {
+		name  string
+		waits []lockWait
+		want  []uint32
+	}{
+		{"within grace", []lockWait{{waiter: 1, blocker: 2, waited: time.Second, waiterXactStart: older, blockerXactStart: younger}}, nil},
+		{"younger blocker waiting", []lockWait{{waiter: 1, blocker: 2, waited: 10 * time.Second, blockerWaiting: true, waiterXactStart: older, blockerXactStart: younger}}, []uint32{2}},
+		{"younger blocker idle", []lockWait{{waiter: 1, blocker: 2, waited: 10 * time.Second, waiterXactStart: older, blockerXactStart: younger}}, []uint32{1}},
+		{"older blocker", []lockWait{{waiter: 1, blocker: 2, waited: 10 * time.Second, blockerWaiting: true, waiterXactStart: younger, blockerXactStart: older}}, []uint32{1}},
+		{"cycle cancels one side once", []lockWait{
+			{waiter: 1, blocker: 2, waited: 10 * time.Second, blockerWaiting: true, waiterXactStart: older, blockerXactStart: younger},
+			{waiter: 2, blocker: 1, waited: 8 * time.Second, blockerWaiting: true, waiterXactStart: younger, blockerXactStart: older},
+		}, []uint32{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := chooseLockWaitVictims(tt.waits, grace)
+			if len(got) != len(tt.want) {
+				t.Fatalf("victims = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("victims = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateDeadlockCancel(t *testing.T) {
+	d := newTestSessionDB()
+	canceled := &pgconn.PgError{Severity: "ERROR", Code: "57014", Message: "canceling statement due to user request"}
+
+	if err := d.translateDeadlockCancel(canceled); err != canceled {
+		t.Errorf("unmarked cancel = %v, want it unchanged", err)
+	}
+	reason := "waited too long"
+	d.deadlockVictim.Store(&reason)
+	var pgErr *pgconn.PgError
+	if err := d.translateDeadlockCancel(canceled); !errors.As(err, &pgErr) || pgErr.Code != "40P01" || pgErr.Detail != reason {
+		t.Fatalf("marked cancel = %v, want 40P01 with the watchdog's reason", err)
+	}
+	if err := d.translateDeadlockCancel(canceled); err != canceled {
+		t.Error("the mark must be cleared after one statement")
+	}
+
+	d.deadlockVictim.Store(&reason)
+	if err := d.translateDeadlockCancel(nil); err != nil {
+		t.Fatalf("translateDeadlockCancel(nil) = %v", err)
+	}
+	if d.deadlockVictim.Load() != nil {
+		t.Error("a statement that finished before the cancel must clear the mark")
+	}
+}
+
+func TestLockWatchdog_CancelsWaitBetweenSessions(t *testing.T) {
+	pgr := newPgRollbackFromConfig()
+	if pgr == nil {
+		t.Skip("no config for PostgreSQL")
+	}
+	holder, err := pgr.GetOrCreateSession("lock_watchdog_holder")
+	if err != nil {
+		t.Skipf("PostgreSQL not available: %v", err)
+	}
+	t.Cleanup(func() { _ = pgr.DestroySession("lock_watchdog_holder") })
+	waiter, err := pgr.GetOrCreateSession("lock_watchdog_waiter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = pgr.DestroySession("lock_watchdog_waiter") })
+	ctx := context.Background()
+
+	const lockSQL = "SELECT pg_advisory_xact_lock(285285)"
+	if _, err := holder.DB.SafeExec(ctx, lockSQL); err != nil {
+		t.Fatalf("holder lock: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := waiter.DB.SafeExec(ctx, lockSQL)
+		done <- waiter.DB.translateDeadlockCancel(err)
+	}()
+
+	w := newLockWatchdog(pgr, 200*time.Millisecond)
+	defer w.closeConns()
+	deadline := time.After(10 * time.Second)
+	for {
+		select {
+		case err := <-done:
+			var pgErr *pgconn.PgError
+			if !errors.As(err, &pgErr) || pgErr.Code != "40P01" {
+				t.Fatalf("waiter err = %v, want deadlock_detected (40P01)", err)
+			}
+			if _, err := waiter.DB.SafeExec(ctx, "SELECT 1"); err != nil {
+				t.Fatalf("waiter session unusable after cancel: %v", err)
+			}
+			return
+		case <-deadline:
+			t.Fatal("lock wait was not cancelled by the watchdog")
+		case <-time.After(100 * time.Millisecond):
+			w.tick()
+		}
+	}
+}
+
+func TestLockWatchdog_GroupsSessionsByBackend(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	routes, err := ParseBackendRoutes(map[string]string{"app1:": "host=db1 port=5433 dbname=app1 user=u"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pgr.Routes = routes
+	// The fake backend gives every connection pid 1, as two real servers may.
+	for _, testID := range []string{"app1:checkout", "default_test"} {
+		db, _ := newWireSessionDB(t, 0)
+		pgr.SessionsByTestID[testID] = &TestSession{DB: db, TestID: testID}
+	}
+
+	backends := newLockWatchdog(pgr, time.Second).sessionsByBackend()
+	if len(backends) != 2 {
+		t.Fatalf("sessions grouped on %d backends, want 2: %v", len(backends), backends)
+	}
+	if got := backends[routes[0].Target][1].testID; got != "app1:checkout" {
+		t.Errorf("routed backend pid 1 = %q, want app1:checkout", got)
+	}
+	if got := backends[pgr.defaultBackend()][1].testID; got != "default_test" {
+		t.Errorf("default backend pid 1 = %q, want default_test", got)
+	}
+}
@@ -3,8 +3,9 @@ package proxy
 import (
 	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
+	"net"
 	"os"
 	"strings"
 	"time"
@@ -17,12 +18,14 @@ import (
 
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 // DescribeRowFieldsForQuery returns the RowDescription fields to send for Describe (Portal/Statement)
 // when the query returns a result set (e.g. INSERT/UPDATE/DELETE ... RETURNING). Clients that rely
 // on Describe (e.g. PHP PDO / Laravel Eloquent) need this so they get the correct result shape and
-// do not receive an empty result set. Returns nil if the query does not return rows or we cannot parse RETURNING.
+// do not receive an empty result set. Returns nil if the query does not return rows or its columns depend on
+// the table (RETURNING *), which only the backend can describe.
 func DescribeRowFieldsForQuery(query string) []pgproto3.FieldDescription {
 	if query == "" {
 		return nil
@@ -73,6 +76,86 @@ func maxParameterIndex(query string) int {
 	return sql.MaxParamIndex(stmts[0].Stmt)
 }
 
+// lastStatementReturnsRows is true when the last statement of query returns a result set, even one whose
+// shape cannot be derived from the SQL (RETURNING *): the backend is asked for it (describeBatchResultLocked).
+func lastStatementReturnsRows(query string) bool {
+	stmts, err := sql.ParseStatements(query)
+	return err == nil && len(stmts) > 0 && sql.StmtReturnsResultSet(stmts[len(stmts)-1].Stmt)
+}
+
+// statementDescriptionFromQuery builds a StatementDescription from the SQL text alone, for statements that
+// have no backend description (multi-statement "prepared" queries run as a batch on Execute, or a portal
+// whose statement description was lost). Parameters are counted across all statements with OID 0
+// (unspecified, the client sends text); the row shape is that of the last statement, which is the result
+// the batch returns, or NoData when it cannot be derived (see DescribeRowFieldsForQuery).
+func statementDescriptionFromQuery(query string) *pgconn.StatementDescription {
+	sd := &pgconn.StatementDescription{SQL: query}
+	stmts, err := sql.ParseStatements(query)
+	if err != nil || len(stmts) == 0 {
+		return sd
+	}
+	maxIdx := 0
+	for _, raw := range stmts {
+		if raw.Stmt != nil {
+			maxIdx = max(maxIdx, sql.MaxParamIndex(raw.Stmt))
+		}
+	}
+	sd.ParamOIDs = make([]uint32, maxIdx)
+	last := sql.CommandStringFromRaw(query, stmts[len(stmts)-1])
+	if last == "" {
+		last = query
+	}
+	for _, f := range DescribeRowFieldsForQuery(last) {
+		sd.Fields = append(sd.Fields, pgconn.FieldDescription{
+			Name:         string(f.Name),
+			DataTypeOID:  f.DataTypeOID,
+			DataTypeSize: f.DataTypeSize,
+			TypeModifier: f.TypeModifier,
+			Format:       f.Format,
+		})
+	}
+	return sd
+}
+
+// batchDescribeStatementName is the backend statement describeBatchResultLocked prepares and deallocates
+// at once; LockRun keeps it from being seen by any other statement.
+const batchDescribeStatementName = "pgrollback_describe_batch"
+
+// describeBatchResultLocked asks the backend for the row shape of the last statement of a multi-statement
+// query (the result the batch returns), so Describe announces the real column names and types instead of
+// the ones guessed from the SQL. The statement is prepared under a savepoint guard and deallocated at
+// once. Returns nil when the backend cannot describe it on its own, e.g. it refers to a table created
+// earlier in the same batch. The batch runs as a Simple Query, so every field is in text format.
+// Caller holds LockRun.
+func describeBatchResultLocked(ctx context.Context, db *realSessionDB, query string) []pgconn.FieldDescription {
+	stmts, err := sql.ParseStatements(query)
+	if err != nil || len(stmts) == 0 {
+		return nil
+	}
+	last := sql.CommandStringFromRaw(query, stmts[len(stmts)-1])
+	pgConn := db.PgConnLocked()
+	if last == "" || pgConn == nil {
+		return nil
+	}
+	var sd *pgconn.StatementDescription
+	err = db.runWithSavepointGuardLocked(ctx, "pgrollback_describe_guard", func() error {
+		var err error
+		if sd, err = pgConn.Prepare(ctx, batchDescribeStatementName, last, nil); err != nil {
+			return err
+		}
+		return pgConn.Deallocate(ctx, batchDescribeStatementName)
+	})
+	if err != nil || len(sd.Fields) == 0 {
+		return nil
+	}
+	fields := make([]pgconn.FieldDescription, len(sd.Fields))
+	for i, f := range sd.Fields {
+		f.Format = pgtype.TextFormatCode
+		fields[i] = f
+	}
+	return fields
+}
+
 // pgconnFieldToProto converts a pgconn.FieldDescription to a pgproto3.FieldDescription.
 func pgconnFieldToProto(f pgconn.FieldDescription) pgproto3.FieldDescription {
 	return pgproto3.FieldDescription{
@@ -117,30 +200,36 @@ func (p *proxyConnection) sendDescribeFromSD(sd *pgconn.StatementDescription, ob
 
 // executeViaExecPrepared calls PgConn.ExecPrepared for the given portal, reads all results,
 // and sends DataRow + CommandComplete to the client. Returns an error if the execution fails.
-func (p *proxyConnection) executeViaExecPrepared(ctx context.Context, pgConn *pgconn.PgConn, stmtName string, params [][]byte, paramFormats []int16, resultFormats []int16) error {
+// Backend notices raised during execution are relayed before CommandComplete (or the error). Caller holds
+// LockRun and has started the statement (beginClientStatementLocked), so the notices are its own.
+func (p *proxyConnection) executeViaExecPrepared(ctx context.Context, pgConn *pgconn.PgConn, notices *backendNotices, stmtName string, params [][]byte, paramFormats []int16, resultFormats []int16) (pgconn.CommandTag, error) {
 	rr := pgConn.ExecPrepared(ctx, stmtName, params, paramFormats, resultFormats)
-	// Read all rows and forward as DataRow messages.
+	// Forward rows as they arrive, flushing periodically so large results are not held in memory.
+	rowCount := 0
 	for rr.NextRow() {
-		values := rr.Values()
-		p.backend.Send(&pgproto3.DataRow{Values: values})
+		p.backend.Send(&pgproto3.DataRow{Values: rr.Values()})
+		rowCount++
+		if rowCount%streamFlushEveryRows == 0 {
+			if err := p.backend.Flush(); err != nil {
+				_, _ = rr.Close()
+				return pgconn.CommandTag{}, err
+			}
+		}
 	}
 	// Close finishes reading (CommandComplete + ReadyForQuery internally).
 	tag, err := rr.Close()
+	p.relayBackendNotices(notices)
 	if err != nil {
-		return err
+		return tag, err
 	}
-	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag.String())})
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(protocol.NormalizeCommandTag(tag.String()))})
 	p.backend.Flush()
-	return nil
+	return tag, nil
 }
 
-// runDisconnectCleanup runs rollback, release, and deallocate cleanup for this connection.
-// Call on disconnect so the shared session/backend state is correct. Run inline (before return).
-// We run deallocate first (it holds the session lock for the whole DEALLOCATE batch) so that
-// any other connection (e.g. conn2 polling prepared-statement count) is blocked until
-// statements are deallocated; then we run rollback/release (which take the lock briefly).
+// destroySessionIfRequested destroys the session when MarkDisconnectRequested was called for it;
+// returns true when it did.
 func (p *proxyConnection) destroySessionIfRequested(testID string) bool {
-	remoteAddr := p.clientConn.RemoteAddr().String()
 	p.server.PgRollback.mu.Lock()
 	defer p.server.PgRollback.mu.Unlock()
 	session := p.server.PgRollback.GetSessionLocked(testID)
@@ -154,21 +243,33 @@ func (p *proxyConnection) destroySessionIfRequested(testID string) bool {
 		return false
 	}
 
-	log.Printf("[PROXY] destroy session on disconnect (testID=%s, conn=%s)", testID, remoteAddr)
+	p.connLogger().Info("[PROXY] destroy session on disconnect")
 	if err := p.server.PgRollback.destroySessionCoreWithPLock(session, testID); err != nil {
-		log.Printf("[PROXY] error destroying session on disconnect (testID=%s, conn=%s): %v", testID, remoteAddr, err)
+		p.connLogger().Error("[PROXY] error destroying session on disconnect: %v", err)
 	}
 	return true
 }
 
+// runDisconnectCleanup deallocates this connection's prepared statements, rolls back its open user
+// savepoints and releases its open-transaction claim. Call on disconnect so the shared session/backend
+// state is correct. It runs inline, before the handler returns, and the backend part runs under one
+// LockRun: another connection of the session never sees the statements or savepoints half cleaned up.
+// Server.WaitForDisconnectCleanup waits for it.
 func (p *proxyConnection) runDisconnectCleanup(testID string) {
-	remoteAddr := p.clientConn.RemoteAddr().String()
-	log.Printf("[PROXY] disconnect cleanup starting (testID=%s, conn=%s)", testID, remoteAddr)
-	p.deallocateBackendStatementsOnDisconnect(testID)
-	p.rollbackUserSavepointsOnDisconnect(testID)
-	p.releaseOpenTransactionOnDisconnect(testID)
+	p.connLogger().Info("[PROXY] disconnect cleanup starting")
+	if session := p.server.PgRollback.GetSession(testID); session != nil && session.DB != nil {
+		db := session.DB
+		db.LockRun()
+		p.deallocateBackendStatementsLocked(db)
+		db.setPreparedStatementCount(p.connectionID(), 0)
+		p.rollbackUserSavepointsLocked(db)
+		db.releaseOpenTransactionLocked(p.connectionID())
+		db.UnlockRun()
+	} else {
+		p.clearStatementPortalState()
+	}
 	if !p.destroySessionIfRequested(testID) {
-		log.Printf("[PROXY] disconnect cleanup done (testID=%s, conn=%s)", testID, remoteAddr)
+		p.connLogger().Info("[PROXY] disconnect cleanup done")
 	}
 }
 
@@ -178,74 +279,127 @@ func (p *proxyConnection) runDisconnectCleanup(testID string) {
 func (p *proxyConnection) RunMessageLoop(session *TestSession) {
 	defer p.clientConn.Close()
 	if session == nil {
-		log.Printf("[PROXY] RunMessageLoop: nil session")
+		p.connLogger().Error("[PROXY] RunMessageLoop: nil session")
 		return
 	}
 	testID := p.server.PgRollback.GetTestID(session)
 	if testID == "" {
-		log.Printf("[PROXY] RunMessageLoop: session not found in SessionsByTestID map")
+		p.connLogger().Error("[PROXY] RunMessageLoop: session not found in SessionsByTestID map")
 		session.unregisterProxyClient(p.clientConn)
 		return
 	}
-	defer p.runDisconnectCleanup(testID)
-	defer session.unregisterProxyClient(p.clientConn)
+	p.connLog = logger.With("test_id", testID).With("conn", p.clientConn.RemoteAddr().String())
+	if capture := p.startCapture(testID); capture != nil {
+		defer p.stopCapture(capture)
+		p.capture = capture
+	}
+	defer func() {
+		session.beginDisconnectCleanup(p.clientConn)
+		defer session.endDisconnectCleanup()
+		p.runDisconnectCleanup(testID)
+	}()
+	deadlines := watchedClientConnOf(p.clientConn)
+	deadlines.arm()
 	// Extended Query protocol (e.g. pgx for QueryContext("SELECT 1")) typically sends:
 	//   Parse → Describe(S) → Sync → Bind → Describe(P) → Execute → Sync
 	// We forward each message to the real PostgreSQL (via the session's PgConn) and relay
 	// the backend's response to the client. Query interception (BEGIN→SAVEPOINT etc.) is applied
 	// at Parse time: we forward the modified Parse, so the backend never sees the raw client query.
 	// Simple Query (pgproto3.Query) continues to use the pgx Tx API via ProcessSimpleQuery.
+	// awaitingSync is true between an extended-query message and its Sync: the client has not had
+	// ReadyForQuery yet, so Server.Shutdown must not end the connection there.
+	awaitingSync := false
 	for {
+		if !awaitingSync && !deadlines.enterIdle() {
+			p.connLog.Info("[PROXY-ML] Conexão cliente encerrada pelo desligamento do servidor")
+			return
+		}
+		deadlines.awaitMessage()
 		msg, err := p.backend.Receive()
 		if err != nil {
+			if rejectOversizedMessage(p.backend, err) {
+				p.connLog.Warn("[PROXY-ML] Conexão cliente encerrada: %v", err)
+				return
+			}
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				p.connLog.Info("[PROXY-ML] Conexão cliente encerrada por timeout de leitura: %v", err)
+			}
 			return
 		}
+		if !deadlines.leaveIdle() {
+			return
+		}
+		if p.capture != nil {
+			p.capture.recordClientMessage(msg)
+		}
+		switch msg.(type) {
+		case *pgproto3.Parse, *pgproto3.Bind, *pgproto3.Describe, *pgproto3.Execute, *pgproto3.Close:
+			awaitingSync = true
+		case *pgproto3.Sync, *pgproto3.Query:
+			awaitingSync = false
+		}
 
+		switch msg.(type) {
+		case *pgproto3.Query, *pgproto3.Parse, *pgproto3.Bind:
+			// A dead backend is replaced here, before the message needs it (see backend_reconnect.go).
+			p.syncBackendGeneration(session)
+		}
+
+		endMessageSpan := p.startMessageSpan(testID, msg)
 		switch msg := msg.(type) {
 		case *pgproto3.Query:
-			logger.Debug("[PROXY-ML] Query recebido: %s", msg.String)
+			p.connLog.Debug("[PROXY-ML] Query recebido: %s", msg.String)
+			p.server.queriesProcessed.Add(1)
 			p.handleMessageQuery(testID, msg)
 
 		case *pgproto3.Parse:
-			logger.Debug("[PROXY-ML] Parse recebido: %s", msg.Query)
+			p.connLog.Debug("[PROXY-ML] Parse recebido: %s", msg.Query)
 			p.handleMessageParse(testID, msg)
 
 		case *pgproto3.Bind:
-			logger.Debug("[PROXY-ML] Bind recebido: %s", msg.PreparedStatement)
+			p.connLog.Debug("[PROXY-ML] Bind recebido: %s", msg.PreparedStatement)
 			p.handleMessageBind(msg)
 
 		case *pgproto3.Execute:
-			logger.Debug("[PROXY-ML] Execute recebido: %s", msg.Portal)
+			p.connLog.Debug("[PROXY-ML] Execute recebido: %s", msg.Portal)
+			p.server.queriesProcessed.Add(1)
 			p.handleMessageExecute(testID, msg)
 
 		case *pgproto3.Describe:
-			logger.Debug("[PROXY-ML] Describe recebido: %s", msg.Name)
+			p.connLog.Debug("[PROXY-ML] Describe recebido: %s", msg.Name)
 			p.handleMessageDescribe(msg)
 
 		case *pgproto3.Sync:
-			logger.Debug("[PROXY-ML] Sync recebido")
+			p.connLog.Debug("[PROXY-ML] Sync recebido")
 			p.handleMessageSync()
 
 		case *pgproto3.Terminate:
-			logger.Debug("[PROXY-ML] Terminate recebido")
+			p.connLog.Debug("[PROXY-ML] Terminate recebido")
+			endMessageSpan()
 			return
 
 		case *pgproto3.Flush:
-			logger.Debug("[PROXY-ML] Flush recebido")
+			p.connLog.Debug("[PROXY-ML] Flush recebido")
 			p.handleMessageFlush(testID)
 
 		case *pgproto3.Close:
-			logger.Debug("[PROXY-ML] Close recebido: %s", msg.Name)
+			p.connLog.Debug("[PROXY-ML] Close recebido: %s", msg.Name)
 			p.handleMessageClose(testID, msg)
 
-		case *pgproto3.CopyData:
-			logger.Debug("[PROXY-ML] CopyData recebido")
+		case *pgproto3.CopyData, *pgproto3.CopyDone, *pgproto3.CopyFail:
+			p.connLog.Debug("[PROXY-ML] %T recebido fora de COPY", msg)
 			p.handleMessageCopyData(testID)
 
+		case *pgproto3.FunctionCall:
+			p.connLog.Debug("[PROXY-ML] FunctionCall recebido: oid=%d", msg.Function)
+			p.handleMessageFunctionCall(testID)
+
 		default:
-			logger.Warn("[PROXY-ML] Mensagem desconhecida recebida: %T", msg)
+			p.connLog.Warn("[PROXY-ML] Mensagem desconhecida recebida: %T", msg)
 			p.handleMessageDefault(testID, msg)
 		}
+		endMessageSpan()
 	}
 }
 
@@ -255,38 +409,49 @@ func (p *proxyConnection) handleMessageDefault(testID string, msg pgproto3.Front
 	p.backend.Flush()
 }
 
-func (p *proxyConnection) handleMessageCopyData(testID string) {
-	remoteAddr := p.clientConn.RemoteAddr().String()
-	// Mensagens de tráfego de dados (COPY). Ignoramos no log para evitar spam,
-	// mas mantemos o fallback seguro de enviar ReadyForQuery para não travar.
-	log.Printf("[PROXY] CopyData ignorado (testID=%s, conn=%s)", testID, remoteAddr)
-	p.SendReadyForQuery()
+// handleMessageFunctionCall rejeita o protocolo legado de fastpath (mensagem 'F'). O cliente espera
+// FunctionCallResponse ou ErrorResponse seguido de ReadyForQuery; responder só com ReadyForQuery o
+// deixaria esperando o resultado para sempre.
+func (p *proxyConnection) handleMessageFunctionCall(testID string) {
+	p.SendErrorResponse(&pgconn.PgError{
+		Severity: "ERROR",
+		Code:     "0A000",
+		Message:  "fastpath function calls not supported",
+	})
 	p.backend.Flush()
 }
 
+func (p *proxyConnection) handleMessageCopyData(testID string) {
+	// COPY FROM STDIN consumes CopyData/CopyDone/CopyFail itself (executeCopyFromStdin). Any that reach
+	// the loop are leftovers of a copy the backend already failed; PostgreSQL ignores them without a
+	// reply, and so do we (a ReadyForQuery here would desynchronize the client).
+	logIfVerbose("[PROXY] mensagem de COPY ignorada fora de COPY (testID=%s)", testID)
+}
+
 func (p *proxyConnection) handleMessageClose(testID string, msg *pgproto3.Close) {
-	// Deallocate on backend using connection-prefixed name (only if we prepared it); clean up per-connection maps.
+	if p.skipUntilSync("Close") {
+		return
+	}
+	// Deallocate this connection's backend statement (only if we prepared it); clean up per-connection maps.
 	session := p.server.PgRollback.GetSession(testID)
 	if session != nil && session.DB != nil {
 		db := session.DB
-		pgConn := db.PgConn()
-		if msg.ObjectType == 'S' && pgConn != nil && !p.IsMultiStatement(msg.Name) {
-			backendName := p.backendStmtName(msg.Name)
+		if msg.ObjectType == 'S' {
 			db.LockRun()
-			if err := pgConn.Deallocate(session.Context(), backendName); err != nil {
-				log.Printf("[PROXY] Deallocate failed: %v", err)
+			if err := db.deallocatePreparedStatementLocked(session.Context(), p.connectionID(), msg.Name); err != nil {
+				p.connLogger().Error("[PROXY] Deallocate failed: %v", err)
 			}
 			db.UnlockRun()
 		}
 		p.CloseStatementOrPortal(msg.ObjectType, msg.Name)
+		p.reportPreparedStatementCount(testID)
 	}
 	p.backend.Send(&pgproto3.CloseComplete{})
 	p.backend.Flush()
 }
 
 func (p *proxyConnection) handleMessageFlush(testID string) {
-	remoteAddr := p.clientConn.RemoteAddr().String()
-	log.Printf("[PROXY] Flush recebido (testID=%s, conn=%s)", testID, remoteAddr)
+	p.connLogger().Info("[PROXY] Flush recebido")
 	p.backend.Flush()
 }
 
@@ -298,69 +463,115 @@ func (p *proxyConnection) handleMessageSync() {
 	p.SendReadyForQuery()
 }
 
+// handleMessageDescribe answers Describe per object type: a statement ('S') gets ParameterDescription
+// followed by RowDescription or NoData; a portal ('P') gets only RowDescription or NoData, with the
+// result formats chosen in its Bind, since its parameters are already bound.
 func (p *proxyConnection) handleMessageDescribe(msg *pgproto3.Describe) {
-	// If a previous message in this extended-query cycle failed, propagate that same error.
-	// Do NOT send ReadyForQuery — only Sync does that.
-	if p.extendedQueryPendingError != nil {
-		p.sendExtendedQueryErr(p.extendedQueryPendingError)
+	if p.skipUntilSync("Describe") {
 		return
 	}
-
-	// Use per-connection cached StatementDescription to respond with ParameterDescription + RowDescription/NoData.
-	// Multi-statement "prepared" queries have no backend SD; send empty params + NoData.
-	var stmtName string
-	if msg.ObjectType == 'S' {
-		stmtName = msg.Name
-	} else {
-		stmtName = p.PortalStatementName(msg.Name)
+	switch msg.ObjectType {
+	case 'S':
+		p.describeStatement(msg.Name)
+	case 'P':
+		p.describePortal(msg.Name)
+	default:
+		p.sendExtendedQueryErr(&pgconn.PgError{
+			Severity: "ERROR",
+			Code:     "08P01",
+			Message:  fmt.Sprintf("invalid DESCRIBE message subtype %d", msg.ObjectType),
+		})
 	}
-	if p.IsMultiStatement(stmtName) {
-		p.backend.Send(&pgproto3.ParameterDescription{ParameterOIDs: nil})
-		p.backend.Send(&pgproto3.NoData{})
-		p.backend.Flush()
+}
+
+// describeStatement sends ParameterDescription + RowDescription/NoData for a prepared statement, from the
+// backend's description cached at Parse. Multi-statement "prepared" queries have no backend statement;
+// theirs is cached at Parse too (the last statement described on the backend, see
+// describeBatchResultLocked) or, failing that, derived from the stored SQL (statementDescriptionFromQuery).
+func (p *proxyConnection) describeStatement(name string) {
+	query, ok := p.GetPreparedStatement(name)
+	if !ok {
+		p.sendExtendedQueryErr(&pgconn.PgError{
+			Severity: "ERROR",
+			Code:     "26000",
+			Message:  fmt.Sprintf("prepared statement \"%s\" does not exist", name),
+		})
 		return
 	}
-	var sd *pgconn.StatementDescription
-	var resultFormats []int16
-	if msg.ObjectType == 'S' {
-		sd = p.GetStatementDescription(msg.Name)
-	} else {
-		sd = p.GetStatementDescriptionForPortal(msg.Name)
-		resultFormats = p.PortalResultFormats(msg.Name)
+	sd := p.GetStatementDescription(name)
+	if sd == nil || (p.IsMultiStatement(name) && sd.SQL != query) {
+		sd = statementDescriptionFromQuery(query)
 	}
-	if sd == nil {
-		p.sendExtendedQueryErr(fmt.Errorf("statement description not found for Describe (objectType=%c, name=%q)", msg.ObjectType, msg.Name))
+	p.sendDescribeFromSD(sd, 'S', nil)
+}
+
+// describePortal sends RowDescription or NoData for a bound portal; a portal never gets a
+// ParameterDescription. The shape comes from the statement the portal was bound to, derived from its
+// SQL when there is no backend description. An empty statement has no result, hence NoData.
+func (p *proxyConnection) describePortal(name string) {
+	stmtName, bound := p.portalStatement(name)
+	query, ok := p.GetPreparedStatement(stmtName)
+	if !bound || !ok {
+		p.sendExtendedQueryErr(&pgconn.PgError{
+			Severity: "ERROR",
+			Code:     "34000",
+			Message:  fmt.Sprintf("portal \"%s\" does not exist", name),
+		})
 		return
 	}
-	p.sendDescribeFromSD(sd, msg.ObjectType, resultFormats)
+	sd := p.GetStatementDescriptionForPortal(name)
+	if sd == nil || (p.IsMultiStatement(stmtName) && sd.SQL != query) {
+		sd = statementDescriptionFromQuery(query)
+	}
+	p.sendDescribeFromSD(sd, 'P', p.PortalResultFormats(name))
 }
 
 func (p *proxyConnection) handleMessageExecute(testID string, msg *pgproto3.Execute) {
-	// If a previous message in this extended-query cycle failed, propagate the error (no RFQ).
-	if p.extendedQueryPendingError != nil {
-		p.sendExtendedQueryErr(p.extendedQueryPendingError)
+	if p.skipUntilSync("Execute") {
 		return
 	}
 	// Execute the prepared statement via PgConn.ExecPrepared() using per-connection
-	// portal/statement state and backend-prefixed statement name. LockRun serializes backend use.
+	// portal/statement state and the backend statement name (ResolveBackendStatement). LockRun serializes backend use.
+	// Bound parameters go to the backend as the original bytes + format codes from Bind; they are
+	// only substituted into the SQL text for the GUI history entry (SetLastQueryWithParams).
+	if query, _, _, ok := p.QueryForPortal(msg.Portal); ok && query == "" {
+		// Empty statement (Parse of "", whitespace or only comments): like PostgreSQL, nothing runs.
+		p.backend.Send(&pgproto3.EmptyQueryResponse{})
+		return
+	}
 	session := p.server.PgRollback.GetSession(testID)
 	if session == nil || session.DB == nil || session.DB.PgConn() == nil {
 		p.sendExtendedQueryErr(fmt.Errorf("sessão não encontrada para testID: %s", testID))
 		return
 	}
+	defer p.beginStatement(session)()
 	stmtName := p.PortalStatementName(msg.Portal)
 	query, params, formatCodes, ok := p.QueryForPortal(msg.Portal)
 	if !ok {
 		p.sendExtendedQueryErr(fmt.Errorf("portal ou statement não encontrado para execução (portal=%q)", msg.Portal))
 		return
 	}
+	// A statement prepared before explain mode was turned on would otherwise run for real.
+	if session.IsExplain() && !p.isExplainCommandStatement(stmtName) {
+		p.sendExtendedQueryErr(errExplainExtendedQuery)
+		return
+	}
 	if query != "" && session.DB != nil {
 		args := bindParamsToArgs(params, formatCodes)
-		connLabel := ""
-		if p.clientConn != nil {
-			connLabel = p.clientConn.RemoteAddr().String()
+		session.DB.SetLastQueryWithParams(query, args, p.historyLabel)
+	}
+	p.noteParameterSets(session.DB, query)
+	if handled, err := p.handleClientVariableSet(session, query, false); handled {
+		if err != nil {
+			p.sendExtendedQueryErr(err)
 		}
-		session.DB.SetLastQueryWithParams(query, args, connLabel)
+		return
+	}
+	if handled, err := p.handleDiscard(session, query, false); handled {
+		if err != nil {
+			p.sendExtendedQueryErr(err)
+		}
+		return
 	}
 	if p.IsMultiStatement(stmtName) {
 		// Run as batch and send only the last result (same behavior as Simple Query multi-statement).
@@ -378,51 +589,90 @@ func (p *proxyConnection) handleMessageExecute(testID string, msg *pgproto3.Exec
 		if len(commands) == 0 {
 			commands = sql.SplitCommandsFallback(query)
 		}
-		if err := p.SafeForwardMultipleCommandsToDB(testID, commands, false); err != nil {
-			log.Printf("[PROXY] multi-statement Execute failed: %v", err)
+		if err := session.DB.translateDeadlockCancel(p.SafeForwardMultipleCommandsToDB(testID, commands, false)); err != nil {
+			p.connLogger().Error("[PROXY] multi-statement Execute failed: %v", err)
 			p.sendExtendedQueryErr(err)
 			recoverSessionTxAfterDirectExec(session)
+			return
 		}
+		p.recordClientVariableSets(session, query)
 		return
 	}
 	resultFormats := p.PortalResultFormats(msg.Portal)
 	pgConn := session.DB.PgConn()
-	backendStmtName := p.backendStmtName(stmtName)
+	stmt, ok := session.DB.ResolveBackendStatement(p.connectionID(), stmtName)
+	if !ok {
+		message := fmt.Sprintf("prepared statement \"%s\" does not exist", stmtName)
+		if stmtName == "" {
+			message = "unnamed prepared statement does not exist"
+		}
+		p.sendExtendedQueryErr(&pgconn.PgError{Severity: "ERROR", Code: "26000", Message: message})
+		return
+	}
+	ctx := p.statementContext(session)
+	span := p.startSpan("pgrollback.execute", testID, query)
 	session.DB.LockRun()
+	if err := session.DB.beginClientStatementLocked(ctx); err != nil {
+		session.DB.UnlockRun()
+		endSpan(span, err)
+		p.sendExtendedQueryErr(err)
+		return
+	}
 	start := time.Now()
-	err := p.executeViaExecPrepared(session.Context(), pgConn, backendStmtName, params, formatCodes, resultFormats)
+	tag, err := p.executeViaExecPrepared(ctx, pgConn, session.DB.notices, stmt.name, params, formatCodes, resultFormats)
 	elapsed := time.Since(start)
+	session.DB.endClientStatementLocked(ctx)
 	session.DB.UnlockRun()
+	endExecuteSpan(span, tag, err)
 	session.DB.Gui.UpdateLastQueryHistoryDuration(elapsed)
-	if err != nil {
-		log.Printf("[PROXY] ExecPrepared failed: %v", err)
+	if err := session.DB.translateDeadlockCancel(err); err != nil {
+		p.connLogger().Error("[PROXY] ExecPrepared failed: %v", err)
 		p.sendExtendedQueryErr(err)
 		recoverSessionTxAfterDirectExec(session)
+		return
 	}
+	session.DB.recordSchemaChanges(query)
 }
 
 func (p *proxyConnection) handleMessageBind(msg *pgproto3.Bind) {
-	// If a previous message in this extended-query cycle failed, propagate the error (no RFQ).
-	if p.extendedQueryPendingError != nil {
-		p.sendExtendedQueryErr(p.extendedQueryPendingError)
+	if p.skipUntilSync("Bind") {
+		return
+	}
+	if err := validateFormatCodes("parameter", msg.ParameterFormatCodes); err != nil {
+		p.sendExtendedQueryErr(err)
 		return
 	}
+	if err := validateFormatCodes("result", msg.ResultFormatCodes); err != nil {
+		p.sendExtendedQueryErr(err)
+		return
+	}
+	// Multi-statement statements run as a text batch on Execute, so only text results can be honored,
+	// and bound parameters cannot be sent as real bind values (PostgreSQL itself rejects a multi-command
+	// prepared statement); fail instead of dropping the values or splicing them into the SQL text.
+	if p.IsMultiStatement(msg.PreparedStatement) {
+		if !allTextFormats(msg.ResultFormatCodes) {
+			p.sendExtendedQueryErr(fmt.Errorf("binary or mixed result formats are not supported for multi-statement prepared queries (statement=%q)", msg.PreparedStatement))
+			return
+		}
+		if len(msg.Parameters) > 0 {
+			p.sendExtendedQueryErr(fmt.Errorf("cannot bind %d parameter(s) to multi-statement prepared query %q: use one statement per Parse", len(msg.Parameters), msg.PreparedStatement))
+			return
+		}
+	}
 	// Store portal mapping per-connection. The actual Bind to PostgreSQL happens when
-	// Execute arrives (via ExecPrepared which uses backend-prefixed statement name).
+	// Execute arrives (via ExecPrepared which uses the backend statement name,
+	// the stored parameter format codes and the stored result format codes).
 	p.BindPortal(msg.DestinationPortal, msg.PreparedStatement, msg.Parameters, msg.ParameterFormatCodes, msg.ResultFormatCodes)
 	p.backend.Send(&pgproto3.BindComplete{})
 	p.backend.Flush()
 }
 
 func (p *proxyConnection) handleMessageParse(testID string, msg *pgproto3.Parse) {
-	// Extended Query: intercept query, store per-connection, call PgConn.Prepare() with
-	// connection-prefixed name so concurrent connections don't collide. LockRun serializes
-	// use of the shared backend. Do NOT call any session.DB method that takes d.mu while holding LockRun.
-	//
-	// If a previous message in this extended-query cycle already failed, short-circuit with
-	// the same error (no RFQ — only Sync sends ReadyForQuery).
-	if p.extendedQueryPendingError != nil {
-		p.sendExtendedQueryErr(p.extendedQueryPendingError)
+	// Extended Query: intercept query, store per-connection, call PgConn.Prepare() with a
+	// per-connection backend name (realSessionDB.SetPreparedStatement) so concurrent connections
+	// don't collide. LockRun serializes use of the shared backend. Do NOT call any session.DB
+	// method that takes d.mu while holding LockRun.
+	if p.skipUntilSync("Parse") {
 		return
 	}
 	session := p.server.PgRollback.GetSession(testID)
@@ -430,15 +680,31 @@ func (p *proxyConnection) handleMessageParse(testID string, msg *pgproto3.Parse)
 		p.sendExtendedQueryErr(fmt.Errorf("sessão não encontrada para testID: %s", testID))
 		return
 	}
+	explainCommand := isExplainCommand(msg.Query)
+	if session.IsExplain() && !explainCommand {
+		p.sendExtendedQueryErr(errExplainExtendedQuery)
+		return
+	}
+	// Registered before the LockRun defer below, so it runs after UnlockRun (it takes PgRollback.mu).
+	defer p.reportPreparedStatementCount(testID)
 	// Capture DB pointer for LockRun/defer: if another goroutine runs disconnect-all, session.DB
 	// becomes nil before defer runs; defer session.DB.UnlockRun() would then call UnlockRun on nil.
 	db := session.DB
-	interceptedQuery, err := p.server.PgRollback.InterceptQuery(testID, msg.Query, p.connectionID())
+	p.server.PgRollback.warnNotificationsNeverDelivered(testID, msg.Query)
+	interceptedQuery, err := p.interceptQuery(testID, msg.Query)
 	if err != nil {
 		p.sendExtendedQueryErr(err)
 		return
 	}
+	if sql.IsEmptyQuery(msg.Query) {
+		// Stored as "" so Execute answers EmptyQueryResponse; an intercepted no-op ("-- ping" for a
+		// nested BEGIN) is kept as is and still completes with its command tag.
+		interceptedQuery = ""
+	}
 	p.SetPreparedStatement(msg.Name, interceptedQuery)
+	if explainCommand {
+		p.setExplainCommandStatement(msg.Name)
+	}
 	var numStmts int
 	if stmts, err := sql.ParseStatements(interceptedQuery); err == nil {
 		numStmts = len(stmts)
@@ -448,26 +714,39 @@ func (p *proxyConnection) handleMessageParse(testID string, msg *pgproto3.Parse)
 	if numStmts > 1 {
 		// PostgreSQL does not allow multiple commands in a prepared statement. Run as batch on Execute.
 		p.SetMultiStatement(msg.Name)
+		sd := statementDescriptionFromQuery(interceptedQuery)
+		ctx := p.statementContext(session)
+		db.LockRun()
+		// A statement of the same name prepared earlier on the backend is replaced by the batch.
+		_ = db.deallocatePreparedStatementLocked(ctx, p.connectionID(), msg.Name)
+		p.evictLRUPreparedStatementsLocked(ctx, db, msg.Name)
+		if lastStatementReturnsRows(interceptedQuery) && db.syncClientGUCsLocked(ctx) == nil {
+			if fields := describeBatchResultLocked(ctx, db, interceptedQuery); fields != nil {
+				sd.Fields = fields
+			}
+		}
+		db.UnlockRun()
+		p.SetStatementDescription(msg.Name, sd)
 		p.backend.Send(&pgproto3.ParseComplete{})
 		p.backend.Flush()
 		return
 	}
-	backendName := p.backendStmtName(msg.Name)
+	ctx := p.statementContext(session)
 	session.DB.LockRun()
 	defer session.DB.UnlockRun()
-	var existingSD *pgconn.StatementDescription
-	if msg.Name != "" {
-		existingSD = p.GetStatementDescriptionLocked(msg.Name)
-	}
 	pgConn := session.DB.PgConnLocked()
-	ctx := session.Context()
-	if msg.Name != "" && existingSD != nil && pgConn != nil {
-		_ = pgConn.Deallocate(ctx, backendName)
-	}
 	if pgConn == nil {
 		p.sendExtendedQueryErr(fmt.Errorf("conexão backend indisponível"))
 		return
 	}
+	// search_path decides which objects the statement refers to, so apply this connection's view first.
+	if err := db.syncClientGUCsLocked(ctx); err != nil {
+		p.sendExtendedQueryErr(err)
+		return
+	}
+	// Re-Parse of a name: drop the old backend statement, then prepare under a fresh backend name.
+	_ = db.deallocatePreparedStatementLocked(ctx, p.connectionID(), msg.Name)
+	backendName := db.SetPreparedStatement(p.connectionID(), msg.Name, interceptedQuery)
 
 	// Wrap pgConn.Prepare in a savepoint guard: a failed parse (e.g. table/column does not exist)
 	// would otherwise leave the base transaction in aborted state (SQLSTATE 25P02).
@@ -479,11 +758,13 @@ func (p *proxyConnection) handleMessageParse(testID string, msg *pgproto3.Parse)
 		return err
 	})
 	if prepErr != nil {
-		log.Printf("[PROXY] Prepare failed: %v", prepErr)
+		p.connLogger().Error("[PROXY] Prepare failed: %v", prepErr)
+		db.forgetPreparedStatement(p.connectionID(), msg.Name)
 		p.sendExtendedQueryErr(prepErr)
 		return
 	}
 	p.SetStatementDescriptionLocked(msg.Name, sd)
+	p.evictLRUPreparedStatementsLocked(ctx, db, msg.Name)
 	p.backend.Send(&pgproto3.ParseComplete{})
 	p.backend.Flush()
 }
@@ -492,14 +773,13 @@ func (p *proxyConnection) handleMessageQuery(testID string, msg *pgproto3.Query)
 	// Flow "Simple Query": O cliente envia uma string SQL direta.
 	// Espera-se que retornemos RowDescription, DataRow(s), CommandComplete e ReadyForQuery.
 	queryStr := msg.String
-	remoteAddr := p.clientConn.RemoteAddr().String()
-	log.Printf("[PROXY] Query Simples Recebida (testID=%s, conn=%s): %s", testID, remoteAddr, queryStr)
+	p.connLogger().Info("[PROXY] Query Simples Recebida: %s", queryStr)
 	if os.Getenv("PGROLLBACK_LOG_MESSAGE_ORDER") == "1" {
 		preview := queryStr
 		if len(preview) > 60 {
 			preview = strings.TrimSpace(preview[:60]) + "..."
 		}
-		log.Printf("[MSG_ORDER] RECV SimpleQuery: %s", preview)
+		p.connLogger().Info("[MSG_ORDER] RECV SimpleQuery: %s", preview)
 	}
 	//p.mu.Lock()
 	//p.lastQuery = "" // Limpa a query armazenada para evitar execução duplicada
@@ -507,26 +787,48 @@ func (p *proxyConnection) handleMessageQuery(testID string, msg *pgproto3.Query)
 	//p.mu.Unlock()
 	start := time.Now()
 	if err := p.ProcessSimpleQuery(testID, queryStr); err != nil {
-		log.Printf("[PROXY] Erro ao processar Query Simples: %v", err)
+		p.connLogger().Error("[PROXY] Erro ao processar Query Simples: %v", err)
 		p.SendErrorResponse(err)
+		if session := p.server.PgRollback.GetSession(testID); session != nil {
+			if _, rerr := session.DB.recoverBackend(err); rerr != nil {
+				p.connLogger().Error("[PROXY] %v", rerr)
+			}
+		}
 	} else {
 		elapsed := time.Since(start)
 		if session := p.server.PgRollback.GetSession(testID); session != nil && session.DB != nil {
 			session.DB.Gui.UpdateLastQueryHistoryDuration(elapsed)
 		}
-		log.Printf("[PROXY] Query Simples processada com sucesso: %s", queryStr)
+		p.connLogger().Info("[PROXY] Query Simples processada com sucesso: %s", queryStr)
 	}
 	p.backend.Flush()
 }
 
 // ProcessSimpleQuery lida com o fluxo de "Simple Query" (pgproto3.Query).
 // Intercepta o SQL, executa e garante o envio de ReadyForQuery ao final via executeQuery(..., true).
-func (p *proxyConnection) ProcessSimpleQuery(testID string, query string) error {
+func (p *proxyConnection) ProcessSimpleQuery(testID string, query string) (err error) {
+	if sql.IsEmptyQuery(query) {
+		// Comment-only pings (pgx ResetSession) are answered here, as PostgreSQL would, without a
+		// backend round trip whose response could be attributed to the next query.
+		p.backend.Send(&pgproto3.EmptyQueryResponse{})
+		p.SendReadyForQuery()
+		return nil
+	}
 	session := p.server.PgRollback.GetSession(testID)
 	if session == nil {
 		return fmt.Errorf("sessão não encontrada para testID: %s", testID)
 	}
-	interceptedQuery, err := p.server.PgRollback.InterceptQuery(testID, query, p.connectionID())
+	if session.DB != nil {
+		// Lets a CancelRequest with this connection's key, or the client hanging up, cancel it on the backend (see cancel.go).
+		defer p.beginStatement(session)()
+		defer func() { err = session.DB.translateDeadlockCancel(err) }()
+	}
+	if session.IsExplain() && !isExplainCommand(query) {
+		p.sendExplain(session, query)
+		return nil
+	}
+	p.server.PgRollback.warnNotificationsNeverDelivered(testID, query)
+	interceptedQuery, err := p.interceptQuery(testID, query)
 	if err != nil {
 		return err
 	}
@@ -538,18 +840,50 @@ func (p *proxyConnection) ProcessSimpleQuery(testID string, query string) error
 	// Isso acontece com comandos pgrollback internos ou quando queremos silenciar uma query.
 	if interceptedQuery == "" || interceptedQuery == FULLROLLBACK_SENTINEL || interceptedQuery == DISCONNECT_SENTINEL {
 		if os.Getenv("PGROLLBACK_LOG_MESSAGE_ORDER") == "1" {
-			log.Printf("[MSG_ORDER] SEND CommandComplete: SELECT (intercepted)")
-			log.Printf("[MSG_ORDER] SEND ReadyForQuery")
+			p.connLogger().Info("[MSG_ORDER] SEND CommandComplete: SELECT (intercepted)")
+			p.connLogger().Info("[MSG_ORDER] SEND ReadyForQuery")
 		}
 		p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT")})
 		p.SendReadyForQuery()
 		return nil
 	}
 
+	p.noteParameterSets(session.DB, interceptedQuery)
+	if handled, err := p.handleClientVariableSet(session, interceptedQuery, true); handled {
+		return err
+	}
+	if handled, err := p.handleDiscard(session, interceptedQuery, true); handled {
+		return err
+	}
+
 	// Run via session (Exec/ForwardMultipleCommandsToDB) so we use the same connection/transaction
 	// as the rest of the session. Forwarding raw Simple Query on PgConn can conflict with the
 	// connection state and cause long delays.
-	return p.ExecuteInterpretedQuery(testID, interceptedQuery, true)
+	if err := p.ExecuteInterpretedQuery(testID, interceptedQuery, true); err != nil {
+		return err
+	}
+	p.recordClientVariableSets(session, interceptedQuery)
+	return nil
+}
+
+// validateFormatCodes checks Bind format codes: each must be 0 (text) or 1 (binary), as in PostgreSQL.
+func validateFormatCodes(kind string, codes []int16) error {
+	for i, c := range codes {
+		if c != 0 && c != 1 {
+			return fmt.Errorf("unsupported %s format code %d at position %d", kind, c, i)
+		}
+	}
+	return nil
+}
+
+// allTextFormats reports whether codes request text for every column (empty = all text).
+func allTextFormats(codes []int16) bool {
+	for _, c := range codes {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // bindParamsToArgs converts wire-format Bind parameters (text or binary) to []any for pgx.
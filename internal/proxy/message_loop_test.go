@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+	"time"
+
+	"pgrollback/pkg/logger"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// TestHandleMessageFunctionCall_RejectsFastpath feeds a raw 'F' frame (lo_open(0, 0) by OID) through
+// the backend reader and checks the client gets ErrorResponse 0A000 and ReadyForQuery, not a hang.
+func TestHandleMessageFunctionCall_RejectsFastpath(t *testing.T) {
+	body := binary.BigEndian.AppendUint32(nil, 952) // function OID
+	body = binary.BigEndian.AppendUint16(body, 0)   // argument format codes
+	body = binary.BigEndian.AppendUint16(body, 2)   // arguments
+	for i := 0; i < 2; i++ {
+		body = binary.BigEndian.AppendUint32(body, 4)
+		body = binary.BigEndian.AppendUint32(body, 0)
+	}
+	body = binary.BigEndian.AppendUint16(body, 0) // result format
+	frame := append([]byte{'F'}, binary.BigEndian.AppendUint32(nil, uint32(4+len(body)))...)
+	frame = append(frame, body...)
+
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.backend = pgproto3.NewBackend(bytes.NewReader(frame), &out)
+	msg, err := p.backend.Receive()
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if fc, ok := msg.(*pgproto3.FunctionCall); !ok || fc.Function != 952 {
+		t.Fatalf("received %#v, want FunctionCall for OID 952", msg)
+	}
+	p.handleMessageFunctionCall("t1")
+
+	frontend := pgproto3.NewFrontend(&out, nil)
+	first, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	errResp, ok := first.(*pgproto3.ErrorResponse)
+	if !ok || errResp.Code != "0A000" || errResp.Message != "fastpath function calls not supported" {
+		t.Fatalf("first message = %#v, want ErrorResponse 0A000", first)
+	}
+	second, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if rfq, ok := second.(*pgproto3.ReadyForQuery); !ok || rfq.TxStatus != 'I' {
+		t.Errorf("second message = %#v, want ReadyForQuery idle", second)
+	}
+}
+
+// TestExtendedQueryError_SkipsUntilSync pipelines a failing Describe, then Bind/Describe/Execute/Close of
+// a valid statement and one Sync: like PostgreSQL, the client gets one ErrorResponse and one
+// ReadyForQuery, and the next cycle is processed again.
+func TestExtendedQueryError_SkipsUntilSync(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.SetPreparedStatement("s1", "")
+
+	p.handleMessageDescribe(&pgproto3.Describe{ObjectType: 'S', Name: "missing"})
+	p.handleMessageBind(&pgproto3.Bind{DestinationPortal: "p1", PreparedStatement: "s1"})
+	p.handleMessageDescribe(&pgproto3.Describe{ObjectType: 'P', Name: "p1"})
+	p.handleMessageExecute("t1", &pgproto3.Execute{Portal: "p1"})
+	p.handleMessageClose("t1", &pgproto3.Close{ObjectType: 'S', Name: "s1"})
+	p.handleMessageSync()
+
+	frontend := pgproto3.NewFrontend(&out, nil)
+	var got []pgproto3.BackendMessage
+	for len(got) < 10 {
+		msg, err := frontend.Receive()
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		got = append(got, msg)
+		if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+			break
+		}
+	}
+	if len(got) != 2 {
+		t.Fatalf("pipeline replies = %d messages %#v, want ErrorResponse + ReadyForQuery", len(got), got)
+	}
+	if errResp, ok := got[0].(*pgproto3.ErrorResponse); !ok || errResp.Code != "26000" {
+		t.Errorf("first reply = %#v, want ErrorResponse 26000", got[0])
+	}
+	if _, ok := got[1].(*pgproto3.ReadyForQuery); !ok {
+		t.Errorf("second reply = %#v, want ReadyForQuery", got[1])
+	}
+	if _, ok := p.portalStatement("p1"); ok {
+		t.Error("Bind after the failed Describe was processed")
+	}
+	if _, ok := p.GetPreparedStatement("s1"); !ok {
+		t.Error("Close after the failed Describe was processed")
+	}
+
+	p.handleMessageBind(&pgproto3.Bind{DestinationPortal: "p1", PreparedStatement: "s1"})
+	if _, ok := receiveOne(t, &out).(*pgproto3.BindComplete); !ok {
+		t.Error("Bind after Sync: want BindComplete")
+	}
+}
+
+func TestSimpleQueryError_LoggedWithTestID(t *testing.T) {
+	var logs bytes.Buffer
+	base := logger.NewLogger(logger.INFO, "", 0)
+	base.SetOutput(&logs)
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)}
+	p.connLog = base.With("test_id", "missing")
+
+	p.handleMessageQuery("missing", &pgproto3.Query{String: "SELECT 1"})
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		if !strings.Contains(line, "test_id=missing") {
+			t.Errorf("log line without test_id: %q", line)
+		}
+	}
+	if !strings.Contains(logs.String(), "Erro ao processar Query Simples") {
+		t.Errorf("logs = %q, want the failed query logged", logs.String())
+	}
+}
@@ -0,0 +1,141 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// Maximum size of a client message (proxy.max_message_size).
+//
+// pgproto3 reads a frame's length and allocates a buffer of that size before reading the body, so one
+// frame announcing a gigabyte makes the proxy allocate a gigabyte. The client stream is therefore read
+// through messageSizeLimiter, which follows the frames (the untyped startup packet, then type byte +
+// length) and fails the read at the header of a frame longer than the limit, before pgproto3 sees it.
+// The client then gets a FATAL ErrorResponse (08P01, as PostgreSQL answers an invalid message length)
+// and the connection is closed: the rest of the stream cannot be trusted.
+
+// DefaultMaxMessageSize is the client message limit when none is configured. PostgreSQL itself accepts up
+// to 1 GB; 64 MB leaves room for large bytea parameters and query texts.
+const DefaultMaxMessageSize = 64 << 20
+
+// messageTooLargeError is returned by messageSizeLimiter for a frame over the limit (or shorter than its
+// own length field).
+type messageTooLargeError struct {
+	msgType byte // 0 for the startup packet
+	length  uint32
+	max     int
+}
+
+func (e *messageTooLargeError) Error() string {
+	if e.length < 4 {
+		return fmt.Sprintf("invalid message length %d", e.length)
+	}
+	if e.msgType == 0 {
+		return fmt.Sprintf("startup packet of %d bytes exceeds proxy.max_message_size (%d)", e.length, e.max)
+	}
+	return fmt.Sprintf("message of type %q with %d bytes exceeds proxy.max_message_size (%d)", e.msgType, e.length, e.max)
+}
+
+// messageSizeLimiter passes a client stream through until a frame header announces more than max bytes.
+// The bytes before that header are still returned; the next Read fails with *messageTooLargeError.
+type messageSizeLimiter struct {
+	r         io.Reader
+	max       int
+	typed     bool    // the startup packet was passed: frames now start with a type byte
+	header    [5]byte // header of the current frame, as far as it was read
+	headerN   int
+	remaining uint64 // body bytes of the current frame still to pass
+	err       error
+}
+
+func newMessageSizeLimiter(r io.Reader, max int) *messageSizeLimiter {
+	if max <= 0 {
+		max = DefaultMaxMessageSize
+	}
+	return &messageSizeLimiter{r: r, max: max}
+}
+
+func (l *messageSizeLimiter) Read(p []byte) (int, error) {
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.r.Read(p)
+	if ok, tooLarge := l.scan(p[:n]); tooLarge != nil {
+		l.err = tooLarge
+		if ok == 0 {
+			return 0, tooLarge
+		}
+		return ok, nil
+	}
+	return n, err
+}
+
+// scan follows the frames in buf. On a frame over the limit it returns the number of bytes before that
+// frame's header (0 when the header began in an earlier read) and the error.
+func (l *messageSizeLimiter) scan(buf []byte) (int, *messageTooLargeError) {
+	headerStart := 0 // stays 0 when the header began in an earlier read, whose bytes were already returned
+	for i := 0; i < len(buf); {
+		if l.remaining > 0 {
+			skip := min(l.remaining, uint64(len(buf)-i))
+			l.remaining -= skip
+			i += int(skip)
+			continue
+		}
+		headerLen := 4
+		if l.typed {
+			headerLen = 5
+		}
+		if l.headerN == 0 {
+			headerStart = i
+		}
+		l.header[l.headerN] = buf[i]
+		l.headerN++
+		i++
+		if l.headerN < headerLen {
+			continue
+		}
+		length := binary.BigEndian.Uint32(l.header[headerLen-4 : headerLen])
+		if length < 4 || uint64(length) > uint64(l.max) {
+			err := &messageTooLargeError{length: length, max: l.max}
+			if l.typed {
+				err.msgType = l.header[0]
+			}
+			return headerStart, err
+		}
+		l.remaining = uint64(length) - 4
+		l.headerN = 0
+		l.typed = true
+	}
+	return 0, nil
+}
+
+// newClientBackend returns the pgproto3 backend that reads client messages from r (the connection, or the
+// bytes already read followed by it) under s's message size limit and writes to clientConn, and the reader
+// that knows the protocol version the startup packet asked for (see protocol_negotiation.go).
+func (s *Server) newClientBackend(r io.Reader, clientConn net.Conn) (*pgproto3.Backend, *startupVersionReader) {
+	version := &startupVersionReader{r: r}
+	return pgproto3.NewBackend(newMessageSizeLimiter(version, s.maxMessageSize), clientConn), version
+}
+
+// rejectOversizedMessage sends the FATAL ErrorResponse for a read that failed on a message over the
+// limit and reports whether err was one; the caller then closes the connection.
+func rejectOversizedMessage(backend *pgproto3.Backend, err error) bool {
+	var tooLarge *messageTooLargeError
+	if !errors.As(err, &tooLarge) {
+		return false
+	}
+	backend.Send(errorResponseFor(&pgconn.PgError{
+		Severity: "FATAL",
+		Code:     "08P01",
+		Message:  "invalid message length",
+		Detail:   tooLarge.Error() + ".",
+	}))
+	_ = backend.Flush()
+	return true
+}
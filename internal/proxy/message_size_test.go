@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// hugeFrame returns the header of a msgType message announcing a body of almost 2 GB, without the body.
+func hugeFrame(msgType byte) []byte {
+	return binary.BigEndian.AppendUint32([]byte{msgType}, 0x7ffffff0)
+}
+
+func TestMessageSizeLimiter_StopsAtOversizedFrame(t *testing.T) {
+	var stream []byte
+	stream = (&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "u"},
+	}).Encode(stream)
+	stream = (&pgproto3.Query{String: "SELECT 1"}).Encode(stream)
+	stream = append(stream, hugeFrame('Q')...)
+
+	backend := pgproto3.NewBackend(newMessageSizeLimiter(bytes.NewReader(stream), 1024), io.Discard)
+	if _, err := backend.ReceiveStartupMessage(); err != nil {
+		t.Fatalf("startup: %v", err)
+	}
+	if msg, err := backend.Receive(); err != nil {
+		t.Fatalf("query under the limit: %v", err)
+	} else if q, ok := msg.(*pgproto3.Query); !ok || q.String != "SELECT 1" {
+		t.Fatalf("got %#v, want Query SELECT 1", msg)
+	}
+	_, err := backend.Receive()
+	var tooLarge *messageTooLargeError
+	if !errors.As(err, &tooLarge) || tooLarge.msgType != 'Q' {
+		t.Fatalf("oversized frame: err = %v, want *messageTooLargeError for 'Q'", err)
+	}
+}
+
+func TestMessageSizeLimiter_HeaderSplitAcrossReads(t *testing.T) {
+	var stream []byte
+	stream = (&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber}).Encode(stream)
+	stream = append(stream, hugeFrame('P')...)
+
+	l := newMessageSizeLimiter(iotest.OneByteReader(bytes.NewReader(stream)), 1024)
+	got, err := io.ReadAll(l)
+	var tooLarge *messageTooLargeError
+	if !errors.As(err, &tooLarge) {
+		t.Fatalf("err = %v, want *messageTooLargeError", err)
+	}
+	if want := len(stream) - 1; len(got) != want {
+		t.Errorf("passed %d bytes, want %d (all but the last byte of the oversized header)", len(got), want)
+	}
+}
+
+// TestStartup_OversizedPasswordMessageIsRejected sends a password message announcing almost 2 GB: the
+// client gets FATAL 08P01 and the connection is closed, without the proxy reading the body.
+func TestStartup_OversizedPasswordMessageIsRejected(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "postgres", "postgres", "", time.Second, time.Minute, 0)
+	s := &Server{activeConns: make(map[net.Conn]struct{}), PgRollback: pgr}
+	conn := startPipeConnection(t, s)
+
+	frontend := pgproto3.NewFrontend(conn, conn)
+	frontend.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "postgres", "application_name": "max_message_size"},
+	})
+	if err := frontend.Flush(); err != nil {
+		t.Fatalf("send startup: %v", err)
+	}
+	if _, err := frontend.Receive(); err != nil {
+		t.Fatalf("receive auth request: %v", err)
+	}
+	if _, err := conn.Write(hugeFrame('p')); err != nil {
+		t.Fatalf("send oversized header: %v", err)
+	}
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive rejection: %v", err)
+	}
+	errResp, ok := msg.(*pgproto3.ErrorResponse)
+	if !ok || errResp.Severity != "FATAL" || errResp.Code != "08P01" {
+		t.Fatalf("got %#v, want FATAL ErrorResponse 08P01", msg)
+	}
+	if _, err := frontend.Receive(); err == nil {
+		t.Error("connection still open after the rejection")
+	}
+}
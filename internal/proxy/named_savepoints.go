@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// namedSavepointPrefix distinguishes savepoints created by "pgrollback savepoint <name>" from the
+// <savepoint_prefix>N savepoints used for BEGIN/COMMIT/ROLLBACK conversion (config validation keeps
+// proxy.savepoint_prefix from overlapping it).
+const namedSavepointPrefix = "pgrollback_user_"
+
+var namedSavepointNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// namedSavepoint is a checkpoint created via "pgrollback savepoint <name>".
+// level is SavepointLevel at creation time: releasing it while user BEGINs opened afterwards are still
+// open would silently destroy their pgrollback_v_N savepoints, so that case is rejected.
+type namedSavepoint struct {
+	name  string
+	level int
+}
+
+// backendNamedSavepoint returns the real savepoint name used on the backend for a user checkpoint name.
+func backendNamedSavepoint(name string) string {
+	return namedSavepointPrefix + strings.ToLower(name)
+}
+
+func validateNamedSavepoint(name string) error {
+	if !namedSavepointNameRe.MatchString(name) {
+		return fmt.Errorf("nome de savepoint inválido: %q (use letras, dígitos e _)", name)
+	}
+	return nil
+}
+
+// findNamedSavepointLocked returns the index of the most recent checkpoint with name, or -1. Caller must hold d.mu.
+func (d *realSessionDB) findNamedSavepointLocked(name string) int {
+	for i := len(d.namedSavepoints) - 1; i >= 0; i-- {
+		if strings.EqualFold(d.namedSavepoints[i].name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// CreateNamedSavepoint runs SAVEPOINT pgrollback_user_<name> on the session transaction and tracks it.
+func (d *realSessionDB) CreateNamedSavepoint(ctx context.Context, name string) error {
+	if err := validateNamedSavepoint(name); err != nil {
+		return err
+	}
+	d.Gui.incRunningQueryCount()
+	defer d.Gui.decRunningQueryCount()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, err := d.execTxLocked(ctx, "SAVEPOINT "+backendNamedSavepoint(name)); err != nil {
+		return fmt.Errorf("falha ao criar savepoint %q: %w", name, err)
+	}
+	d.namedSavepoints = append(d.namedSavepoints, namedSavepoint{name: name, level: d.GetSavepointLevel()})
+	return nil
+}
+
+// ReleaseNamedSavepoint runs RELEASE SAVEPOINT for a checkpoint created by CreateNamedSavepoint.
+// Like PostgreSQL, releasing a checkpoint also discards checkpoints created after it.
+func (d *realSessionDB) ReleaseNamedSavepoint(ctx context.Context, name string) error {
+	if err := validateNamedSavepoint(name); err != nil {
+		return err
+	}
+	d.Gui.incRunningQueryCount()
+	defer d.Gui.decRunningQueryCount()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	idx := d.findNamedSavepointLocked(name)
+	if idx < 0 {
+		return fmt.Errorf("savepoint %q não existe", name)
+	}
+	if d.GetSavepointLevel() > d.namedSavepoints[idx].level {
+		return fmt.Errorf("savepoint %q não pode ser liberado: há transações (BEGIN) abertas depois dele", name)
+	}
+	_, err := d.safeExecTCLLocked(ctx, "RELEASE SAVEPOINT "+backendNamedSavepoint(name))
+	// On success the backend dropped it and every later savepoint; on failure it is gone anyway
+	// (e.g. discarded by a ROLLBACK TO an older savepoint), so stop tracking both ways.
+	d.namedSavepoints = d.namedSavepoints[:idx]
+	if err != nil {
+		return fmt.Errorf("savepoint %q não existe: %w", name, err)
+	}
+	return nil
+}
+
+// NamedSavepoints returns the names of the tracked checkpoints, oldest first.
+func (d *realSessionDB) NamedSavepoints() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	names := make([]string, len(d.namedSavepoints))
+	for i, sp := range d.namedSavepoints {
+		names[i] = sp.name
+	}
+	return names
+}
+
+// handleNamedSavepointCommand implements "pgrollback savepoint <name>" / "pgrollback release <name>".
+func (p *PgRollback) handleNamedSavepointCommand(testID, action string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("uso: pgrollback %s <nome>", action)
+	}
+	session := p.GetSession(testID)
+	if session == nil || session.DB == nil {
+		return "", fmt.Errorf("sessão não encontrada para testID: %s", testID)
+	}
+	var err error
+	if action == "savepoint" {
+		err = session.DB.CreateNamedSavepoint(session.Context(), args[0])
+	} else {
+		err = session.DB.ReleaseNamedSavepoint(session.Context(), args[0])
+	}
+	if err != nil {
+		return "", err
+	}
+	return "SELECT 1", nil
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestBackendNamedSavepoint_UsesDistinctPrefix(t *testing.T) {
+	got := backendNamedSavepoint("Before_Seed")
+	if got != "pgrollback_user_before_seed" {
+		t.Fatalf("backendNamedSavepoint = %q, want pgrollback_user_before_seed", got)
+	}
+	if strings.HasPrefix(got, DefaultSavepointPrefix) {
+		t.Fatalf("named savepoint %q must not collide with %s*", got, DefaultSavepointPrefix)
+	}
+}
+
+func TestCreateNamedSavepoint_RejectsInvalidName(t *testing.T) {
+	d := newTestSessionDB()
+	for _, name := range []string{"", "1abc", "a;DROP TABLE x", "a b"} {
+		if err := d.CreateNamedSavepoint(context.Background(), name); err == nil {
+			t.Errorf("CreateNamedSavepoint(%q) should fail", name)
+		}
+	}
+}
+
+func TestCreateNamedSavepoint_NoActiveTransaction(t *testing.T) {
+	d := newTestSessionDB()
+	if err := d.CreateNamedSavepoint(context.Background(), "cp1"); err == nil {
+		t.Fatal("CreateNamedSavepoint without transaction should fail")
+	}
+	if len(d.NamedSavepoints()) != 0 {
+		t.Fatalf("failed savepoint must not be tracked, got %v", d.NamedSavepoints())
+	}
+}
+
+func TestReleaseNamedSavepoint_UnknownName(t *testing.T) {
+	d := newTestSessionDB()
+	err := d.ReleaseNamedSavepoint(context.Background(), "missing")
+	if err == nil || !strings.Contains(err.Error(), "não existe") {
+		t.Fatalf("ReleaseNamedSavepoint(missing) error = %v, want 'não existe'", err)
+	}
+}
+
+func TestReleaseNamedSavepoint_RejectsWhenUserBeginOpenedAfter(t *testing.T) {
+	d := newTestSessionDB()
+	d.namedSavepoints = []namedSavepoint{{name: "cp1", level: 0}}
+	d.SavepointLevel.Store(1)
+	if err := d.ReleaseNamedSavepoint(context.Background(), "cp1"); err == nil {
+		t.Fatal("release should fail while a BEGIN opened after the checkpoint is still open")
+	}
+	if got := d.NamedSavepoints(); len(got) != 1 {
+		t.Fatalf("rejected release must keep tracking, got %v", got)
+	}
+}
+
+func TestInterceptPgRollbackCommand_SavepointRequiresName(t *testing.T) {
+	p := NewPgRollback("localhost", 5432, "postgres", "postgres", "", 0, 0, 0)
+	if _, err := p.InterceptQuery("t1", "pgrollback savepoint", 0); err == nil {
+		t.Fatal("pgrollback savepoint without name should fail")
+	}
+	if _, err := p.InterceptQuery("t1", "pgrollback release cp1", 0); err == nil {
+		t.Fatal("pgrollback release without session should fail")
+	}
+}
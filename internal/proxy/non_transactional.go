@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Commands that cannot run inside a transaction block (VACUUM, CREATE INDEX CONCURRENTLY, CREATE DATABASE, ...).
+//
+// Every statement of a test ID runs in the session's base transaction, so PostgreSQL would reject these
+// with "cannot run inside a transaction block", which reads like a bug in the test to someone who does
+// not know the proxy wraps everything. InterceptQuery answers them itself, before anything runs, with
+// the same SQLSTATE (25001 active_sql_transaction) and a message that says why and what to do instead.
+
+// nonTransactionalKeywords are words one of the commands sql.NonTransactionalCommand detects must
+// contain; queries without any of them are not parsed.
+var nonTransactionalKeywords = []string{"VACUUM", "CONCURRENTLY", "CLUSTER", "DATABASE", "TABLESPACE", "SYSTEM"}
+
+// checkNonTransactional returns the 25001 error for the first statement of query that cannot run inside
+// a transaction block. queryUpper is query in upper case.
+func checkNonTransactional(query, queryUpper string) error {
+	if !containsAny(queryUpper, nonTransactionalKeywords) {
+		return nil
+	}
+	stmts, err := sql.ParseStatements(query)
+	if err != nil {
+		return nil
+	}
+	for _, st := range stmts {
+		if cmd := sql.NonTransactionalCommand(st.Stmt); cmd != "" {
+			return &pgconn.PgError{
+				Severity: "ERROR",
+				Code:     "25001",
+				Message:  fmt.Sprintf("%s cannot run through pgrollback", cmd),
+				Detail:   fmt.Sprintf("pgrollback runs every statement of a test inside one transaction so that it can roll them back, and PostgreSQL does not allow %s inside a transaction block.", cmd),
+				Hint:     "Run it directly against PostgreSQL, outside the proxy (e.g. when preparing the schema before the tests).",
+			}
+		}
+	}
+	return nil
+}
+
+func containsAny(s string, words []string) bool {
+	for _, w := range words {
+		if strings.Contains(s, w) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,34 @@
+package proxy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestInterceptQuery_RejectsNonTransactionalCommands(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	pgr.newFakeTestSession("vacuum")
+
+	for q, cmd := range map[string]string{
+		"VACUUM t":            "VACUUM",
+		"vacuum full analyze": "VACUUM",
+		"SELECT 1; CREATE INDEX CONCURRENTLY i ON t (c)": "CREATE INDEX CONCURRENTLY",
+		"REINDEX TABLE CONCURRENTLY t":                   "REINDEX CONCURRENTLY",
+		"CREATE DATABASE scratch":                        "CREATE DATABASE",
+	} {
+		_, err := pgr.InterceptQuery("vacuum", q, 0)
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "25001" || !strings.HasPrefix(pgErr.Message, cmd+" ") || pgErr.Hint == "" {
+			t.Errorf("InterceptQuery(%q) = %v, want 25001 naming %s", q, err, cmd)
+		}
+	}
+	for _, q := range []string{"ANALYZE t", "CREATE INDEX i ON t (c)", "SELECT 'vacuum concurrently'", "SELECT * FROM database_tablespace_system"} {
+		if _, err := pgr.InterceptQuery("vacuum", q, 0); err != nil {
+			t.Errorf("InterceptQuery(%q) = %v, want it to pass", q, err)
+		}
+	}
+}
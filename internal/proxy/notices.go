@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// maxPendingNotices bounds the notices buffered between relays, so a chatty backend cannot grow the
+// buffer without limit. Oldest are dropped first.
+const maxPendingNotices = 256
+
+// backendNotices buffers NoticeResponse messages the real PostgreSQL sends on the session connection
+// (RAISE NOTICE, "already exists, skipping", ...). pgconn delivers them via Config.OnNotice while a
+// result is being read; the proxy relays them to the client before the matching CommandComplete.
+//
+// Only a client statement's notices are kept: the session DB captures them from beginClientStatementLocked
+// to endClientStatementLocked, under its run-lock, so the notices of the proxy's own commands (keepalive,
+// guard savepoints, parameter and setup SQL) and of another connection's statement never reach a client.
+// Own mutex: the handler runs inside pgconn reads, i.e. while the session DB run-lock is held.
+type backendNotices struct {
+	mu        sync.Mutex
+	pending   []*pgconn.Notice
+	capturing bool
+}
+
+// onNotice is the pgconn.NoticeHandler installed on the session connection. Notices outside a client
+// statement are dropped.
+func (b *backendNotices) onNotice(_ *pgconn.PgConn, n *pgconn.Notice) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.capturing {
+		b.appendLocked(n)
+	}
+}
+
+// queue adds a notice of the proxy itself (a reconnect, a warning of an interceptor), relayed with the
+// next client statement's notices.
+func (b *backendNotices) queue(n *pgconn.Notice) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.appendLocked(n)
+}
+
+func (b *backendNotices) appendLocked(n *pgconn.Notice) {
+	if len(b.pending) >= maxPendingNotices {
+		b.pending = b.pending[1:]
+	}
+	b.pending = append(b.pending, n)
+}
+
+// setCapturing turns keeping the backend's notices on or off. Safe on a nil receiver.
+func (b *backendNotices) setCapturing(on bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.capturing = on
+}
+
+// drain returns and clears the buffered notices. Safe on a nil receiver.
+func (b *backendNotices) drain() []*pgconn.Notice {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := b.pending
+	b.pending = nil
+	return out
+}
+
+// beginClientStatementLocked readies the backend for the client statement of ctx (see statementContext):
+// it applies the connection's tracked parameters, then captures the backend's notices. A context without
+// a statement (the proxy's own commands) changes nothing. Caller must hold d.mu.
+func (d *realSessionDB) beginClientStatementLocked(ctx context.Context) error {
+	if statementOf(ctx) == nil {
+		return nil
+	}
+	if err := d.syncClientGUCsLocked(ctx); err != nil {
+		return err
+	}
+	d.notices.setCapturing(true)
+	return nil
+}
+
+// endClientStatementLocked stops capturing and hands the notices buffered so far to the statement of ctx,
+// for relayStatementNotices once the lock is released. Caller must hold d.mu.
+func (d *realSessionDB) endClientStatementLocked(ctx context.Context) {
+	st := statementOf(ctx)
+	if st == nil {
+		return
+	}
+	st.notices = append(st.notices, d.notices.drain()...)
+	d.notices.setCapturing(false)
+}
+
+// noticeResponseFromNotice converts a backend notice into the wire message sent to the client.
+func noticeResponseFromNotice(n *pgconn.Notice) *pgproto3.NoticeResponse {
+	return &pgproto3.NoticeResponse{
+		Severity:         n.Severity,
+		Code:             n.Code,
+		Message:          n.Message,
+		Detail:           n.Detail,
+		Hint:             n.Hint,
+		Position:         n.Position,
+		InternalPosition: n.InternalPosition,
+		InternalQuery:    n.InternalQuery,
+		Where:            n.Where,
+		SchemaName:       n.SchemaName,
+		TableName:        n.TableName,
+		ColumnName:       n.ColumnName,
+		DataTypeName:     n.DataTypeName,
+		ConstraintName:   n.ConstraintName,
+		File:             n.File,
+		Line:             n.Line,
+		Routine:          n.Routine,
+	}
+}
+
+// relayBackendNotices sends the notices buffered so far to the client. Call it with the session DB
+// run-lock held, while the statement's notices are captured, right before the CommandComplete (or error)
+// of the command that produced them; it does not flush.
+func (p *proxyConnection) relayBackendNotices(notices *backendNotices) {
+	for _, n := range notices.drain() {
+		p.backend.Send(noticeResponseFromNotice(n))
+	}
+}
+
+// relayStatementNotices sends the notices handed to the statement of ctx by endClientStatementLocked,
+// for the statements that run in a locked section of the session DB (SafeExec, SafeExecTCL, COPY).
+func (p *proxyConnection) relayStatementNotices(ctx context.Context) {
+	st := statementOf(ctx)
+	if st == nil {
+		return
+	}
+	for _, n := range st.notices {
+		p.backend.Send(noticeResponseFromNotice(n))
+	}
+	st.notices = nil
+}
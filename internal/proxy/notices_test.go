@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestRelayBackendNotices_SendsBufferedNoticesOnce(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	notices := &backendNotices{capturing: true}
+	notices.onNotice(nil, &pgconn.Notice{Severity: "NOTICE", Code: "42P07", Message: `relation "t" already exists, skipping`})
+
+	p.relayBackendNotices(notices)
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("CREATE TABLE")})
+	if err := p.backend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	frontend := pgproto3.NewFrontend(&out, nil)
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nr, ok := msg.(*pgproto3.NoticeResponse)
+	if !ok {
+		t.Fatal("expected NoticeResponse before CommandComplete")
+	}
+	if nr.Code != "42P07" || nr.Severity != "NOTICE" || nr.Message != `relation "t" already exists, skipping` {
+		t.Fatalf("unexpected notice: %+v", nr)
+	}
+	msg, err = frontend.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := msg.(*pgproto3.CommandComplete); !ok {
+		t.Fatal("expected CommandComplete after the notice")
+	}
+	if got := notices.drain(); len(got) != 0 {
+		t.Fatalf("notices should be drained after relay, got %d", len(got))
+	}
+}
+
+func TestBackendNotices_BoundedAndNilSafe(t *testing.T) {
+	var nilNotices *backendNotices
+	if got := nilNotices.drain(); got != nil {
+		t.Fatalf("nil drain = %v", got)
+	}
+	nilNotices.setCapturing(true)
+	notices := &backendNotices{capturing: true}
+	for i := 0; i < maxPendingNotices+5; i++ {
+		notices.onNotice(nil, &pgconn.Notice{Message: "n"})
+	}
+	if got := len(notices.drain()); got != maxPendingNotices {
+		t.Fatalf("pending = %d, want %d", got, maxPendingNotices)
+	}
+}
+
+func TestWarnIgnoredTransactionOptions_QueuesWarning(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	db := newTestSessionDB()
+	db.notices = &backendNotices{}
+	pgr.SessionsByTestID["t1"] = &TestSession{DB: db}
+
+	pgr.warnIgnoredTransactionOptions("t1", sql.TransactionOptions{})
+	if got := db.notices.drain(); len(got) != 0 {
+		t.Fatalf("plain BEGIN must not warn, got %+v", got)
+	}
+	pgr.warnIgnoredTransactionOptions("t1", sql.TransactionOptions{IsolationLevel: "SERIALIZABLE", AccessMode: "READ ONLY"})
+	got := db.notices.drain()
+	if len(got) != 1 || got[0].Severity != "WARNING" || !strings.Contains(got[0].Message, "ISOLATION LEVEL SERIALIZABLE, READ ONLY") {
+		t.Fatalf("notices = %+v, want one WARNING naming the ignored characteristics", got)
+	}
+}
+
+func TestBackendNotices_KeepsOnlyClientStatementNotices(t *testing.T) {
+	d, _ := newWireSessionDB(t, 0)
+	session := &TestSession{DB: d, TestID: "notices"}
+	var outA, outB bytes.Buffer
+	a, b := newBufferedProxyConnection(&outA), newBufferedProxyConnection(&outB)
+	ctx := context.Background()
+
+	d.notices.queue(&pgconn.Notice{Severity: "WARNING", Message: "from the proxy"})
+	ctxA := a.statementContext(session)
+	if _, err := d.SafeExec(ctxA, "SELECT 'notice a'"); err != nil {
+		t.Fatal(err)
+	}
+	// The proxy's own commands (keepalive, guards, setup SQL) run without a client statement.
+	if _, err := d.SafeExec(ctx, "SELECT 'notice internal'"); err != nil {
+		t.Fatal(err)
+	}
+	ctxB := b.statementContext(session)
+	if _, err := d.SafeExec(ctxB, "SELECT 1"); err != nil {
+		t.Fatal(err)
+	}
+
+	messages := func(ctx context.Context) []string {
+		var got []string
+		for _, n := range statementOf(ctx).notices {
+			got = append(got, n.Message)
+		}
+		return got
+	}
+	if got := messages(ctxA); len(got) != 2 || got[0] != "from the proxy" || got[1] != "select 'notice a'" {
+		t.Errorf("connection A's notices = %q, want the queued warning and its own NOTICE", got)
+	}
+	if got := messages(ctxB); len(got) != 0 {
+		t.Errorf("connection B's notices = %q, want none", got)
+	}
+	if got := d.notices.drain(); len(got) != 0 {
+		t.Errorf("left in the buffer: %+v", got)
+	}
+
+	a.relayStatementNotices(ctxA)
+	a.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("SELECT 1")})
+	a.backend.Flush()
+	if n, ok := receiveOne(t, &outA).(*pgproto3.NoticeResponse); !ok || n.Message != "from the proxy" {
+		t.Fatalf("first message to A = %#v, want the queued warning", n)
+	}
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"pgrollback/pkg/sql"
+)
+
+// ParameterStatus after SET.
+//
+// PostgreSQL tells the client, with an asynchronous ParameterStatus, when a reported parameter
+// (client_encoding, DateStyle, TimeZone, application_name, ...) changes; drivers such as libpq decode
+// results with the client_encoding they were last told. The backend sends those messages to the
+// session connection, where pgconn keeps the latest values. For a client statement that SETs or RESETs a
+// reported parameter, the proxy compares that value, once the statement has run, with the one this
+// client was last told and sends a ParameterStatus before ReadyForQuery when it differs. A failed SET
+// leaves the value unchanged, so nothing is sent.
+
+// noteParameterSets remembers the reported parameters query may change, to be checked before the next
+// ReadyForQuery (sendParameterStatusChanges).
+func (p *proxyConnection) noteParameterSets(db *realSessionDB, query string) {
+	if db == nil {
+		return
+	}
+	names := sql.ReportedParameterSets(query)
+	if len(names) == 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.parameterReportDB = db
+	p.pendingParameterReports = append(p.pendingParameterReports, names...)
+}
+
+// recordReportedParameter stores the value this client was told for a parameter (at startup or later).
+func (p *proxyConnection) recordReportedParameter(name, value string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.reportedParameters == nil {
+		p.reportedParameters = make(map[string]string)
+	}
+	p.reportedParameters[name] = value
+}
+
+// sendParameterStatusChanges sends a ParameterStatus for every noted parameter whose backend value is
+// not the one this client was last told. Called right before ReadyForQuery; the caller flushes.
+func (p *proxyConnection) sendParameterStatusChanges() {
+	p.mu.Lock()
+	names, db := p.pendingParameterReports, p.parameterReportDB
+	p.pendingParameterReports, p.parameterReportDB = nil, nil
+	p.mu.Unlock()
+	if len(names) == 0 || db == nil {
+		return
+	}
+	p.reportParameterValues(names, db.parameterStatus)
+}
+
+// reportParameterValues sends a ParameterStatus for each of names whose current value (as returned by
+// current; "" when unknown) differs from the one this client was last told.
+func (p *proxyConnection) reportParameterValues(names []string, current func(name string) string) {
+	for _, name := range names {
+		value := current(name)
+		p.mu.Lock()
+		told, ok := p.reportedParameters[name]
+		p.mu.Unlock()
+		if value == "" || (ok && told == value) {
+			continue
+		}
+		p.sendParameterStatus(name, value)
+	}
+}
+
+// parameterStatus returns the backend's current value of a reported parameter, as last sent to the
+// session connection in a ParameterStatus; "" when unknown or without a connection.
+func (d *realSessionDB) parameterStatus(name string) string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.conn == nil {
+		return ""
+	}
+	return d.conn.PgConn().ParameterStatus(name)
+}
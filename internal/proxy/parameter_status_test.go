@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestReportParameterValues_SendsOnlyChanges(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.recordReportedParameter("client_encoding", "UTF8")
+	p.recordReportedParameter("DateStyle", "ISO, MDY")
+
+	current := map[string]string{"client_encoding": "LATIN1", "DateStyle": "ISO, MDY", "TimeZone": "UTC"}
+	p.reportParameterValues([]string{"client_encoding", "DateStyle", "TimeZone", "IntervalStyle"}, func(name string) string {
+		return current[name]
+	})
+	if err := p.backend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	frontend := pgproto3.NewFrontend(&out, nil)
+	for _, want := range []pgproto3.ParameterStatus{{Name: "client_encoding", Value: "LATIN1"}, {Name: "TimeZone", Value: "UTC"}} {
+		msg, err := frontend.Receive()
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		if ps, ok := msg.(*pgproto3.ParameterStatus); !ok || *ps != want {
+			t.Fatalf("got %#v, want %#v", msg, want)
+		}
+	}
+	if msg, err := frontend.Receive(); err == nil {
+		t.Fatalf("unexpected extra message %#v", msg)
+	}
+
+	// Once told, the same value is not reported again.
+	p.reportParameterValues([]string{"client_encoding"}, func(name string) string { return current[name] })
+	if err := p.backend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Fatalf("client_encoding reported twice (%d bytes)", out.Len())
+	}
+}
+
+func TestSendParameterStatusChanges_ClearsPending(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	db := newTestSessionDB()
+
+	p.noteParameterSets(db, "SELECT 1")
+	if len(p.pendingParameterReports) != 0 {
+		t.Fatalf("pending = %q after a query without SET", p.pendingParameterReports)
+	}
+	p.noteParameterSets(db, "SET client_encoding = 'LATIN1'; SET TIME ZONE 'UTC'")
+	if got := p.pendingParameterReports; len(got) != 2 || got[0] != "client_encoding" || got[1] != "TimeZone" {
+		t.Fatalf("pending = %q", got)
+	}
+	// Without a backend connection the values are unknown: nothing is sent, and the list is consumed.
+	p.sendParameterStatusChanges()
+	if err := p.backend.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 || p.pendingParameterReports != nil || p.parameterReportDB != nil {
+		t.Fatalf("out=%d bytes pending=%q", out.Len(), p.pendingParameterReports)
+	}
+}
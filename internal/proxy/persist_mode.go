@@ -0,0 +1,123 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Persist mode ("pgrollback persist on|off") lets a test seed data that outlives the sandbox.
+//
+// While it is on, the client's BEGIN/COMMIT/ROLLBACK reach the base transaction itself instead of
+// being converted to savepoints: BEGIN is a no-op (the base transaction is already open), COMMIT
+// really commits it and ROLLBACK really rolls it back, each starting a new base transaction right
+// away. Statements outside BEGIN … COMMIT are committed when persist mode is turned off. From then
+// on BEGIN/COMMIT/ROLLBACK are converted to savepoints again, and "pgrollback rollback" only undoes
+// work done after the seed.
+//
+// Work the base transaction already holds when persist mode is turned on is committed with the seed,
+// so turn it on first thing in the test (or after "pgrollback rollback").
+//
+// Persist mode is per test ID, so it applies to every connection of the session. It can only be
+// switched while no BEGIN or "pgrollback savepoint" is open: committing the base transaction would
+// release those savepoints under the clients that opened them.
+
+// handlePersistCommand runs "pgrollback persist on|off".
+func (p *PgRollback) handlePersistCommand(testID string, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("uso: pgrollback persist on|off")
+	}
+	mode := strings.ToLower(args[0])
+	if mode != "on" && mode != "off" {
+		return "", fmt.Errorf("uso: pgrollback persist on|off")
+	}
+	session := p.GetSession(testID)
+	if session == nil || session.DB == nil {
+		return "", fmt.Errorf("sessão não encontrada para testID: %s", testID)
+	}
+	if err := session.SetPersist(mode == "on"); err != nil {
+		return "", err
+	}
+	return "SELECT 1", nil
+}
+
+// SetPersist turns persist mode on or off for the session. Turning it off commits whatever the base
+// transaction holds (the seed) and starts a new one.
+func (s *TestSession) SetPersist(on bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.DB == nil {
+		return fmt.Errorf("sessão %s não tem conexão com o banco", s.TestID)
+	}
+	if s.persist == on {
+		return nil
+	}
+	if err := s.DB.checkPersistSwitchAllowed(); err != nil {
+		return err
+	}
+	if !on {
+		if err := s.DB.restartBaseTx(s.contextLocked(), true); err != nil {
+			return err
+		}
+	}
+	s.persist = on
+	log.Printf("[PGROLLBACK] persist mode %v for testID=%s", on, s.TestID)
+	return nil
+}
+
+// IsPersist reports whether persist mode is on.
+func (s *TestSession) IsPersist() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.persist
+}
+
+// checkPersistSwitchAllowed fails while a user BEGIN or a named checkpoint is open on the session.
+func (d *realSessionDB) checkPersistSwitchAllowed() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.GetSavepointLevel() > 0 || d.connectionWithOpenTx != 0 {
+		return fmt.Errorf("pgrollback persist: há uma transação (BEGIN) aberta; faça COMMIT ou ROLLBACK antes")
+	}
+	if len(d.namedSavepoints) > 0 {
+		return fmt.Errorf("pgrollback persist: há savepoints criados com \"pgrollback savepoint\"; libere-os antes")
+	}
+	return nil
+}
+
+// restartBaseTx ends the base transaction, committing it when commit is true and rolling it back
+// otherwise, and begins a new one. The new transaction is started even when ending the old one failed
+// (a COMMIT of an aborted transaction rolls it back), so the session stays usable; the error is returned.
+func (d *realSessionDB) restartBaseTx(ctx context.Context, commit bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return fmt.Errorf("sessão sem conexão com o banco")
+	}
+	var endErr error
+	if d.hasActiveTransactionLocked() {
+		if commit {
+			endErr = d.tx.Commit(ctx)
+		} else {
+			endErr = d.tx.Rollback(ctx)
+		}
+		d.tx = nil
+	}
+	d.namedSavepoints = nil
+	d.clearSchemaChanges()
+	d.invalidateClientGUCsLocked()
+	newTx, err := d.conn.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin new transaction: %w", err)
+	}
+	d.tx = newTx
+	setupErr := d.runSessionSetupLocked(ctx)
+	if endErr != nil {
+		if commit {
+			return fmt.Errorf("falha ao fazer COMMIT da transação base: %w", endErr)
+		}
+		return fmt.Errorf("falha ao fazer ROLLBACK da transação base: %w", endErr)
+	}
+	return setupErr
+}
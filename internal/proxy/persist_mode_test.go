@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPersistCommand_BeginIsNoOpWhileOn(t *testing.T) {
+	p := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	session := &TestSession{DB: newTestSessionDB(), TestID: "t1"}
+	p.SessionsByTestID["t1"] = session
+
+	for _, q := range []string{"pgrollback persist", "pgrollback persist maybe", "pgrollback persist on off"} {
+		if _, err := p.InterceptQuery("t1", q, 0); err == nil || !strings.Contains(err.Error(), "uso:") {
+			t.Errorf("InterceptQuery(%q) err = %v; want a usage error", q, err)
+		}
+	}
+	if got, err := p.InterceptQuery("t1", "pgrollback persist ON", 0); err != nil || got != "SELECT 1" {
+		t.Fatalf("persist on = %q, %v", got, err)
+	}
+	if !session.IsPersist() {
+		t.Fatal("session should be in persist mode")
+	}
+	// No savepoint (and no backend round trip): the base transaction is the client's transaction.
+	if got, err := p.InterceptQuery("t1", "BEGIN", 7); err != nil || got != DEFAULT_SELECT_ONE {
+		t.Errorf("BEGIN in persist mode = %q, %v; want %q", got, err, DEFAULT_SELECT_ONE)
+	}
+	if session.DB.GetSavepointLevel() != 0 {
+		t.Errorf("SavepointLevel = %d, want 0", session.DB.GetSavepointLevel())
+	}
+}
+
+func TestSetPersist_RefusesWithOpenTransaction(t *testing.T) {
+	db := newTestSessionDB()
+	session := &TestSession{DB: db, TestID: "t1"}
+	db.SavepointLevel.Store(1)
+	if err := session.SetPersist(true); err == nil || session.IsPersist() {
+		t.Fatalf("SetPersist with an open BEGIN = %v; want an error and persist off", err)
+	}
+	db.SavepointLevel.Store(0)
+	db.namedSavepoints = []namedSavepoint{{name: "cp", level: 0}}
+	if err := session.SetPersist(true); err == nil {
+		t.Fatal("SetPersist with a named savepoint open should fail")
+	}
+}
+
+func TestPersistMode_CommitOutlivesSession(t *testing.T) {
+	seed := newGuardTestSession(t, "persist_seed")
+	other := newGuardTestSession(t, "persist_other")
+	ctx := context.Background()
+	tableExists := func() bool {
+		t.Helper()
+		rows, err := other.DB.SafeQuery(ctx, "SELECT to_regclass('public.pgrollback_persist_seed') IS NOT NULL")
+		if err != nil {
+			t.Fatalf("checking the seed table: %v", err)
+		}
+		defer rows.Close()
+		var exists bool
+		if !rows.Next() || rows.Scan(&exists) != nil {
+			t.Fatalf("checking the seed table: %v", rows.Err())
+		}
+		return exists
+	}
+
+	if err := seed.SetPersist(true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.handleBegin("persist_seed", 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.DB.SafeExec(ctx, "CREATE TABLE pgrollback_persist_seed (id int)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.handleCommit("persist_seed"); err != nil {
+		t.Fatalf("COMMIT in persist mode: %v", err)
+	}
+	t.Cleanup(func() {
+		_ = seed.SetPersist(true)
+		_, _ = seed.DB.SafeExec(ctx, "DROP TABLE IF EXISTS pgrollback_persist_seed")
+		_ = seed.SetPersist(false)
+	})
+	if !tableExists() {
+		t.Fatal("a COMMIT in persist mode must be visible to other sessions")
+	}
+
+	if err := seed.SetPersist(false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.DB.SafeExec(ctx, "DROP TABLE pgrollback_persist_seed"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := seed.RollbackBaseTransaction("persist_seed"); err != nil {
+		t.Fatal(err)
+	}
+	if !tableExists() {
+		t.Fatal("after persist off, work must be rolled back again")
+	}
+}
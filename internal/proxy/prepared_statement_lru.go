@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+)
+
+// DefaultMaxPreparedStatements is the per-connection cap on named prepared statements
+// (proxy.max_prepared_statements) when none is configured.
+const DefaultMaxPreparedStatements = 512
+
+// preparedStatementCounts tracks how many prepared statements each proxy connection holds on a
+// session, for "pgrollback status". Own mutex so it can be updated without d.mu (see realSessionDB).
+type preparedStatementCounts struct {
+	mu     sync.Mutex
+	byConn map[ConnectionID]int
+}
+
+// setPreparedStatementCount records n statements for connID; n <= 0 forgets the connection.
+func (d *realSessionDB) setPreparedStatementCount(connID ConnectionID, n int) {
+	c := &d.prepared
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n <= 0 {
+		delete(c.byConn, connID)
+		return
+	}
+	if c.byConn == nil {
+		c.byConn = make(map[ConnectionID]int)
+	}
+	c.byConn[connID] = n
+}
+
+// PreparedStatementCount returns the number of prepared statements held by all connections of the session.
+func (d *realSessionDB) PreparedStatementCount() int {
+	c := &d.prepared
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := 0
+	for _, n := range c.byConn {
+		total += n
+	}
+	return total
+}
+
+// maxPreparedStatements returns the configured per-connection cap (DefaultMaxPreparedStatements when unset).
+func (p *proxyConnection) maxPreparedStatements() int {
+	if p.server != nil && p.server.maxPreparedStatements > 0 {
+		return p.server.maxPreparedStatements
+	}
+	return DefaultMaxPreparedStatements
+}
+
+// touchPreparedStatementLocked marks statementName as most recently used. Caller must hold p.mu.
+func (p *proxyConnection) touchPreparedStatementLocked(statementName string) {
+	if statementName == "" {
+		return // the unnamed statement is replaced by the next unnamed Parse, never evicted
+	}
+	if p.preparedStatementUse == nil {
+		p.preparedStatementUse = make(map[string]uint64)
+	}
+	p.preparedStatementUseTick++
+	p.preparedStatementUse[statementName] = p.preparedStatementUseTick
+}
+
+// lruEvictionCandidates returns the least-recently-used named statements that must go so this
+// connection holds at most max named statements. keep (the statement just parsed) is never chosen.
+func (p *proxyConnection) lruEvictionCandidates(max int, keep string) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var named []string
+	for name := range p.preparedStatements {
+		if name != "" && name != keep {
+			named = append(named, name)
+		}
+	}
+	excess := len(named) - max
+	if keep != "" {
+		excess++
+	}
+	if excess <= 0 {
+		return nil
+	}
+	sort.Slice(named, func(i, j int) bool {
+		return p.preparedStatementUse[named[i]] < p.preparedStatementUse[named[j]]
+	})
+	return named[:excess]
+}
+
+// evictLRUPreparedStatementsLocked deallocates this connection's least-recently-used statements on the
+// backend once the cap is exceeded. Only this connection's backend names are touched, so a statement
+// with the same client name on another connection is never affected.
+// Caller must hold the session DB run-lock (LockRun); db may be nil when nothing is on the backend.
+func (p *proxyConnection) evictLRUPreparedStatementsLocked(ctx context.Context, db *realSessionDB, keep string) {
+	victims := p.lruEvictionCandidates(p.maxPreparedStatements(), keep)
+	if len(victims) == 0 {
+		return
+	}
+	for _, name := range victims {
+		if db == nil {
+			continue
+		}
+		if err := db.deallocatePreparedStatementLocked(ctx, p.connectionID(), name); err != nil {
+			log.Printf("[PROXY] LRU deallocate of %q failed: %v", name, err)
+		}
+	}
+	logIfVerbose("[PROXY] evicted %d least-recently-used prepared statement(s): %v", len(victims), victims)
+	p.RemovePreparedStatements(victims)
+}
+
+// preparedStatementCount returns how many statements this connection currently tracks.
+func (p *proxyConnection) preparedStatementCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.preparedStatements)
+}
+
+// reportPreparedStatementCount publishes this connection's statement count to the session for status.
+// Must not be called while holding the session DB run-lock (it looks the session up via PgRollback.mu).
+func (p *proxyConnection) reportPreparedStatementCount(testID string) {
+	if p.server == nil || p.server.PgRollback == nil {
+		return
+	}
+	session := p.server.PgRollback.GetSession(testID)
+	if session == nil || session.DB == nil {
+		return
+	}
+	session.DB.setPreparedStatementCount(p.connectionID(), p.preparedStatementCount())
+}
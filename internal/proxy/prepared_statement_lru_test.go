@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestLRUEviction_DropsLeastRecentlyUsed(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{maxPreparedStatements: 2}
+
+	p.SetPreparedStatement("s1", "SELECT 1")
+	p.SetPreparedStatement("s2", "SELECT 2")
+	// Binding s1 makes s2 the least recently used.
+	p.BindPortal("", "s1", nil, nil)
+	p.SetPreparedStatement("s3", "SELECT 3")
+
+	p.evictLRUPreparedStatementsLocked(context.Background(), nil, "s3")
+
+	if _, ok := p.GetPreparedStatement("s2"); ok {
+		t.Fatal("s2 should have been evicted")
+	}
+	for _, name := range []string{"s1", "s3"} {
+		if _, ok := p.GetPreparedStatement(name); !ok {
+			t.Fatalf("%s should still be prepared", name)
+		}
+	}
+	if _, ok := p.preparedStatementUse["s2"]; ok {
+		t.Fatal("usage entry for s2 should be removed")
+	}
+}
+
+func TestLRUEvictionCandidates_IgnoresUnnamedAndKeep(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.SetPreparedStatement("", "SELECT 0")
+	p.SetPreparedStatement("a", "SELECT 1")
+	p.SetPreparedStatement("b", "SELECT 2")
+
+	if got := p.lruEvictionCandidates(2, "b"); got != nil {
+		t.Fatalf("under the cap, got %v", got)
+	}
+	if got := p.lruEvictionCandidates(1, "b"); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Fatalf("candidates = %v, want [a]", got)
+	}
+}
+
+func TestMaxPreparedStatements_DefaultWhenUnset(t *testing.T) {
+	p := &proxyConnection{server: &Server{}}
+	if got := p.maxPreparedStatements(); got != DefaultMaxPreparedStatements {
+		t.Fatalf("maxPreparedStatements() = %d, want %d", got, DefaultMaxPreparedStatements)
+	}
+}
+
+func TestPreparedStatementCount_SumsConnections(t *testing.T) {
+	d := &realSessionDB{}
+	d.setPreparedStatementCount(1, 3)
+	d.setPreparedStatementCount(2, 4)
+	if got := d.PreparedStatementCount(); got != 7 {
+		t.Fatalf("PreparedStatementCount() = %d, want 7", got)
+	}
+	d.setPreparedStatementCount(1, 0)
+	if got := d.PreparedStatementCount(); got != 4 {
+		t.Fatalf("after forgetting conn 1, PreparedStatementCount() = %d, want 4", got)
+	}
+}
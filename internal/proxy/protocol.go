@@ -9,12 +9,18 @@ import (
 const (
 	ProtocolVersion        = 196608
 	PostgresSSLRequestCode = 80877103 // Código da mensagem SSLRequest do PostgreSQL
-	// specialRequestTotalBytes is the on-wire size of SSLRequest / CancelRequest (4-byte length + 4-byte code).
+	// PostgresCancelRequestCode is the request code of a CancelRequest (followed by process ID and secret key).
+	PostgresCancelRequestCode = 80877102
+	// PostgresGSSENCRequestCode is the request code of a GSSENCRequest, sent by libpq (gssencmode=prefer) before SSLRequest.
+	PostgresGSSENCRequestCode = 80877104
+	// specialRequestTotalBytes is the on-wire size of SSLRequest and GSSENCRequest (4-byte length + 4-byte code).
 	specialRequestTotalBytes = 8
+	// cancelRequestTotalBytes is the on-wire size of CancelRequest (length + code + process ID + secret key).
+	cancelRequestTotalBytes = 16
 )
 
 // IsSSLRequestLength reports whether the first int32 on the wire is PostgreSQL's special-request
-// frame size (8 bytes total), i.e. SSLRequest or CancelRequest, as opposed to a StartupMessage length.
+// frame size (8 bytes total), i.e. SSLRequest or GSSENCRequest, as opposed to a StartupMessage length.
 func IsSSLRequestLength(length int32) bool {
 	return length == specialRequestTotalBytes
 }
@@ -38,11 +44,27 @@ func ReadSpecialRequestCode(r io.Reader) (code int32, err error) {
 	return code, nil
 }
 
+// IsCancelRequestLength reports whether the first int32 on the wire may be a CancelRequest (16 bytes total).
+// A StartupMessage can have the same length, so the request code must still be checked.
+func IsCancelRequestLength(length int32) bool {
+	return length == cancelRequestTotalBytes
+}
+
+// IsPostgresCancelRequestCode reports whether code is the PostgreSQL CancelRequest payload (after the 4-byte length).
+func IsPostgresCancelRequestCode(code int32) bool {
+	return code == PostgresCancelRequestCode
+}
+
 // IsPostgresSSLRequestCode reports whether code is the PostgreSQL SSLRequest payload (after the 4-byte length).
 func IsPostgresSSLRequestCode(code int32) bool {
 	return code == PostgresSSLRequestCode
 }
 
+// IsPostgresGSSENCRequestCode reports whether code is the PostgreSQL GSSENCRequest payload (after the 4-byte length).
+func IsPostgresGSSENCRequestCode(code int32) bool {
+	return code == PostgresGSSENCRequestCode
+}
+
 type StartupMessage struct {
 	ProtocolVersion int32
 	Parameters      map[string]string
@@ -132,6 +154,32 @@ func WriteAuthenticationCleartextPassword(writer io.Writer) error {
 	return err
 }
 
+// WriteAuthenticationMD5Password solicita a senha em MD5 (md5(md5(senha+usuário)+salt)) com o salt dado
+func WriteAuthenticationMD5Password(writer io.Writer, salt [4]byte) error {
+	message := []byte{
+		'R',
+		0, 0, 0, 12,
+		0, 0, 0, 5, // AuthenticationMD5Password
+		salt[0], salt[1], salt[2], salt[3],
+	}
+	_, err := writer.Write(message)
+	return err
+}
+
+// WriteNegotiateProtocolVersion tells the client the newest minor version of protocol 3 the server
+// supports and the protocol options ("_pq_.*") of its StartupMessage the server did not recognize.
+func WriteNegotiateProtocolVersion(writer io.Writer, minor int32, unrecognized []string) error {
+	body := binary.BigEndian.AppendUint32(nil, uint32(minor))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(unrecognized)))
+	for _, option := range unrecognized {
+		body = append(body, option...)
+		body = append(body, 0)
+	}
+	message := append([]byte{'v'}, binary.BigEndian.AppendUint32(nil, uint32(4+len(body)))...)
+	_, err := writer.Write(append(message, body...))
+	return err
+}
+
 func WriteReadyForQuery(writer io.Writer) error {
 	message := []byte{
 		'Z',
@@ -180,3 +228,10 @@ func WriteSSLResponse(writer io.Writer, allowSSL bool) error {
 	_, err := writer.Write([]byte{'N'})
 	return err
 }
+
+// WriteGSSENCDeclined responde 'N' à GSSENCRequest: sem criptografia GSSAPI, o cliente segue com
+// SSLRequest ou StartupMessage na mesma conexão.
+func WriteGSSENCDeclined(writer io.Writer) error {
+	_, err := writer.Write([]byte{'N'})
+	return err
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Protocol version negotiation (NegotiateProtocolVersion).
+//
+// Newer libpq may ask for protocol 3.2 and send "_pq_.<name>" protocol options in the StartupMessage.
+// The proxy speaks 3.0 and knows no protocol options, so, like a PostgreSQL server older than the client,
+// it answers the StartupMessage with NegotiateProtocolVersion listing the newest minor version it
+// supports and the options it did not recognize; the client then carries on with 3.0 instead of failing.
+// pgproto3 only decodes StartupMessages for 3.0, so startupVersionReader rewrites a 3.x version to 3.0 on
+// the way in and remembers the one requested.
+
+// supportedProtocolMinor is the newest minor version of protocol 3 the proxy speaks.
+const supportedProtocolMinor = 0
+
+// protocolOptionPrefix starts the StartupMessage parameters that are protocol options, not settings.
+const protocolOptionPrefix = "_pq_."
+
+// startupVersionReader passes a client stream through, replacing a protocol version 3.x with x above
+// supportedProtocolMinor in the first packet (length, version) by 3.0.
+type startupVersionReader struct {
+	r              io.Reader
+	pending        []byte // rewritten first packet header not yet returned
+	started        bool
+	requestedMinor uint16 // minor version the client asked for, when it was rewritten
+}
+
+func (v *startupVersionReader) Read(p []byte) (int, error) {
+	if !v.started {
+		v.started = true
+		head := make([]byte, 8)
+		n, err := io.ReadFull(v.r, head)
+		head = head[:n]
+		if n == 8 {
+			version := binary.BigEndian.Uint32(head[4:8])
+			if major, minor := version>>16, uint16(version); major == 3 && minor > supportedProtocolMinor {
+				v.requestedMinor = minor
+				binary.BigEndian.PutUint32(head[4:8], 3<<16|supportedProtocolMinor)
+			}
+		}
+		v.pending = head
+		if n == 0 {
+			return 0, err
+		}
+	}
+	if len(v.pending) > 0 {
+		n := copy(p, v.pending)
+		v.pending = v.pending[n:]
+		return n, nil
+	}
+	return v.r.Read(p)
+}
+
+// negotiateProtocolVersion removes the protocol options from params and, when the client asked for a newer
+// minor version than the proxy speaks or sent any protocol option, writes NegotiateProtocolVersion.
+func negotiateProtocolVersion(w io.Writer, requestedMinor uint16, params map[string]string) error {
+	var options []string
+	for name := range params {
+		if strings.HasPrefix(name, protocolOptionPrefix) {
+			options = append(options, name)
+			delete(params, name)
+		}
+	}
+	if requestedMinor <= supportedProtocolMinor && len(options) == 0 {
+		return nil
+	}
+	sort.Strings(options)
+	return WriteNegotiateProtocolVersion(w, supportedProtocolMinor, options)
+}
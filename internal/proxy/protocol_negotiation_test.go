@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// TestStartup_NegotiatesProtocolVersion sends a protocol 3.2 StartupMessage with two _pq_ options: the
+// proxy answers NegotiateProtocolVersion (3.0, both options unrecognized) and goes on with authentication.
+func TestStartup_NegotiatesProtocolVersion(t *testing.T) {
+	s := &Server{activeConns: make(map[net.Conn]struct{})}
+	conn := startPipeConnection(t, s)
+
+	startup := (&pgproto3.StartupMessage{
+		ProtocolVersion: 3<<16 | 2,
+		Parameters: map[string]string{
+			"user":                   "postgres",
+			"application_name":       "negotiate_test",
+			"_pq_.protocol_managed":  "1",
+			"_pq_.another_extension": "on",
+		},
+	}).Encode(nil)
+	if _, err := conn.Write(startup); err != nil {
+		t.Fatalf("send startup: %v", err)
+	}
+
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if header[0] != 'v' {
+		t.Fatalf("first reply type %q, want 'v' (NegotiateProtocolVersion)", header[0])
+	}
+	body := make([]byte, binary.BigEndian.Uint32(header[1:])-4)
+	if _, err := io.ReadFull(conn, body); err != nil {
+		t.Fatalf("receive body: %v", err)
+	}
+	if minor := binary.BigEndian.Uint32(body[0:4]); minor != 0 {
+		t.Errorf("newest minor version = %d, want 0", minor)
+	}
+	count := binary.BigEndian.Uint32(body[4:8])
+	options := bytes.Split(bytes.TrimSuffix(body[8:], []byte{0}), []byte{0})
+	var names []string
+	for _, o := range options {
+		names = append(names, string(o))
+	}
+	if want := []string{"_pq_.another_extension", "_pq_.protocol_managed"}; count != 2 || !slices.Equal(names, want) {
+		t.Errorf("unrecognized options = %d %v, want %v", count, names, want)
+	}
+
+	msg, err := pgproto3.NewFrontend(conn, conn).Receive()
+	if err != nil {
+		t.Fatalf("receive auth request: %v", err)
+	}
+	if _, ok := msg.(*pgproto3.AuthenticationCleartextPassword); !ok {
+		t.Fatalf("got %T after NegotiateProtocolVersion, want *pgproto3.AuthenticationCleartextPassword", msg)
+	}
+}
+
+func TestNegotiateProtocolVersion_NothingToNegotiate(t *testing.T) {
+	var out bytes.Buffer
+	params := map[string]string{"user": "u"}
+	if err := negotiateProtocolVersion(&out, 0, params); err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("wrote %d bytes for a 3.0 startup without protocol options, want none", out.Len())
+	}
+
+	params["_pq_.x"] = "1"
+	if err := negotiateProtocolVersion(&out, 0, params); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := params["_pq_.x"]; ok {
+		t.Error("protocol option left among the startup parameters")
+	}
+	if out.Len() == 0 {
+		t.Error("no NegotiateProtocolVersion for an unrecognized protocol option")
+	}
+}
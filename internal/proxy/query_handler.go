@@ -64,14 +64,24 @@ func multiCommandBatchNeedsSequentialExec(commands []string) bool {
 // Ela decide se é comando único ou múltiplos comandos e encaminha para o banco.
 // args são os parâmetros bound (Extended Query); para Simple Query não passar args.
 //
-// DEALLOCATE is rewritten so the backend sees this connection's prefixed statement names
-// (multiple client connections with the same testID share one backend but use per-connection prefixes).
+// DEALLOCATE is rewritten so the backend sees this connection's backend statement names
+// (multiple client connections with the same testID share one backend; see backend_statements.go).
 //
 // sendReadyForQuery:
 //   - true para fluxo "Simple Query" (envia ReadyForQuery ao final).
 //   - false para fluxo "Extended Query" (não envia, espera-se recebimento de Sync depois).
 func (p *proxyConnection) ExecuteInterpretedQuery(testID string, query string, sendReadyForQuery bool, args ...any) error {
+	var db *realSessionDB // backend statement names for the DEALLOCATE rewrite; nil without a session
+	if session := p.server.PgRollback.GetSession(testID); session != nil {
+		db = session.DB
+	}
 	var deallocatedInQuery []string
+	sawDEALLOCATE := false
+	defer func() {
+		if sawDEALLOCATE {
+			p.reportPreparedStatementCount(testID)
+		}
+	}()
 	stmts, err := sql.ParseStatements(query)
 	if err != nil || len(stmts) == 0 {
 		// Fallback to string split when parse fails or empty (respects quotes, e.g. SET client_encoding='utf-8')
@@ -82,8 +92,9 @@ func (p *proxyConnection) ExecuteInterpretedQuery(testID string, query string, s
 			if c == "" {
 				continue
 			}
-			rewritten, isDEALLOCATE, names := p.rewriteDEALLOCATEForBackend(c)
+			rewritten, isDEALLOCATE, names := p.rewriteDEALLOCATEForBackend(db, c)
 			if isDEALLOCATE {
+				sawDEALLOCATE = true
 				expanded = append(expanded, rewritten...)
 				deallocatedInQuery = append(deallocatedInQuery, names...)
 			} else {
@@ -91,13 +102,16 @@ func (p *proxyConnection) ExecuteInterpretedQuery(testID string, query string, s
 			}
 		}
 		if len(expanded) == 0 {
+			if sawDEALLOCATE {
+				return p.completeLocalDEALLOCATE(query, deallocatedInQuery, sendReadyForQuery)
+			}
 			return p.ForwardCommandToDB(testID, query, sendReadyForQuery, args...)
 		}
 		err := p.executeExpandedCommands(testID, expanded, sendReadyForQuery)
 		if err != nil {
 			return err
 		}
-		p.RemovePreparedStatements(deallocatedInQuery)
+		p.forgetDeallocatedStatements(db, deallocatedInQuery)
 		return nil
 	}
 	var expanded []string
@@ -113,7 +127,8 @@ func (p *proxyConnection) ExecuteInterpretedQuery(testID string, query string, s
 			if len(singleStmts) > 0 && singleStmts[0].Stmt != nil {
 				_, _, isDEALLOCATE := sql.ParseDeallocate(singleStmts[0].Stmt)
 				if isDEALLOCATE {
-					rewritten, _, names := p.rewriteDEALLOCATEForBackend(c)
+					sawDEALLOCATE = true
+					rewritten, _, names := p.rewriteDEALLOCATEForBackend(db, c)
 					expanded = append(expanded, rewritten...)
 					deallocatedInQuery = append(deallocatedInQuery, names...)
 				} else {
@@ -135,7 +150,8 @@ func (p *proxyConnection) ExecuteInterpretedQuery(testID string, query string, s
 			if stmt != nil {
 				_, _, isDEALLOCATE := sql.ParseDeallocate(stmt)
 				if isDEALLOCATE {
-					rewritten, _, names := p.rewriteDEALLOCATEForBackend(c)
+					sawDEALLOCATE = true
+					rewritten, _, names := p.rewriteDEALLOCATEForBackend(db, c)
 					expanded = append(expanded, rewritten...)
 					deallocatedInQuery = append(deallocatedInQuery, names...)
 				} else {
@@ -147,13 +163,34 @@ func (p *proxyConnection) ExecuteInterpretedQuery(testID string, query string, s
 		}
 	}
 	if len(expanded) == 0 {
+		if sawDEALLOCATE {
+			return p.completeLocalDEALLOCATE(query, deallocatedInQuery, sendReadyForQuery)
+		}
 		return p.ForwardCommandToDB(testID, query, sendReadyForQuery, args...)
 	}
 	err = p.executeExpandedCommands(testID, expanded, sendReadyForQuery)
 	if err != nil {
 		return err
 	}
-	p.RemovePreparedStatements(deallocatedInQuery)
+	p.forgetDeallocatedStatements(db, deallocatedInQuery)
+	return nil
+}
+
+// completeLocalDEALLOCATE answers a DEALLOCATE that needs nothing on the backend (the statement was never
+// prepared there by this connection): it only clears the per-connection maps and replies with a synthetic
+// CommandComplete, like PostgreSQL would after a successful DEALLOCATE.
+func (p *proxyConnection) completeLocalDEALLOCATE(query string, names []string, sendReadyForQuery bool) error {
+	p.RemovePreparedStatements(names)
+	tag := "DEALLOCATE"
+	if stmts, err := sql.ParseStatements(query); err == nil && len(stmts) == 1 && stmts[0].Stmt != nil {
+		if _, isAll, ok := sql.ParseDeallocate(stmts[0].Stmt); ok && isAll {
+			tag = "DEALLOCATE ALL"
+		}
+	}
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
+	if sendReadyForQuery {
+		p.SendReadyForQuery()
+	}
 	return nil
 }
 
@@ -173,7 +210,7 @@ func (p *proxyConnection) ForwardCommandToDB(testID string, query string, sendRe
 		return fmt.Errorf("sessão não encontrada para testID: %s", testID)
 	}
 
-	intercepted, err := p.server.PgRollback.InterceptQuery(testID, query, p.connectionID())
+	intercepted, err := p.interceptQuery(testID, query)
 	if err != nil {
 		return err
 	}
@@ -189,6 +226,12 @@ func (p *proxyConnection) ForwardCommandToDB(testID string, query string, sendRe
 	if stmts, parseErr := sql.ParseStatements(query); parseErr == nil && len(stmts) > 0 && stmts[0].Stmt != nil {
 		stmt = stmts[0].Stmt
 	}
+	if copyInfo, ok := sql.ParseCopy(stmt); ok && copyInfo.IsStdio {
+		if copyInfo.IsFrom {
+			return p.executeCopyFromStdin(testID, query, copyInfo, sendReadyForQuery)
+		}
+		return p.executeCopyToStdout(testID, query, copyInfo, sendReadyForQuery)
+	}
 	if stmt != nil && sql.StmtReturnsResultSet(stmt) {
 		return p.ExecuteSelectQuery(testID, query, sendReadyForQuery, args...)
 	}
@@ -199,7 +242,7 @@ func (p *proxyConnection) ForwardCommandToDB(testID string, query string, sendRe
 	var tag pgconn.CommandTag
 
 	log.Printf("[PROXY] ForwardCommandToDB: Executando via transação: %s", query)
-	session.DB.Gui.SetLastQuery(query)
+	p.recordQuery(session.DB, query)
 	start := time.Now()
 
 	// All TCL (SAVEPOINT, RELEASE, ROLLBACK) goes to SafeExecTCL, which runs inside a guard
@@ -215,24 +258,28 @@ func (p *proxyConnection) ForwardCommandToDB(testID string, query string, sendRe
 
 	isUserBegin := false
 	if stmt != nil {
-		isUserBegin = sql.IsSavepoint(stmt) && strings.HasPrefix(sql.GetSavepointName(stmt), "pgrollback_v_")
+		isUserBegin = sql.IsSavepoint(stmt) && session.DB.isUserSavepointName(sql.GetSavepointName(stmt))
 	} else {
-		isUserBegin = IsUserBeginQuery(query)
+		isUserBegin = session.DB.IsUserBeginQuery(query)
 	}
 
 	if isTransactionControl {
 		if isUserBegin {
-			if err := session.DB.ClaimOpenTransaction(p.connectionID()); err != nil {
+			if err := session.DB.ClaimOpenTransaction(p.connectionID(), p.clientAddr()); err != nil {
 				return err
 			}
 		}
-		tag, err = session.DB.SafeExecTCL(session.Context(), query, args...)
+		ctx := p.statementContext(session)
+		span := p.startSpan("pgrollback.execute", testID, query)
+		tag, err = session.DB.SafeExecTCL(ctx, query, args...)
+		endExecuteSpan(span, tag, err)
+		p.relayStatementNotices(ctx)
 		if err != nil {
 			affectsClaim := isUserBegin
 			if stmt != nil {
-				affectsClaim = affectsClaim || (sql.IsReleaseSavepoint(stmt) && strings.HasPrefix(sql.GetSavepointName(stmt), "pgrollback_v_"))
+				affectsClaim = affectsClaim || (sql.IsReleaseSavepoint(stmt) && session.DB.isUserSavepointName(sql.GetSavepointName(stmt)))
 			} else {
-				affectsClaim = IsQueryThatAffectsClaim(query)
+				affectsClaim = session.DB.IsQueryThatAffectsClaim(query)
 			}
 			if affectsClaim {
 				session.DB.ReleaseOpenTransaction(p.connectionID())
@@ -246,22 +293,19 @@ func (p *proxyConnection) ForwardCommandToDB(testID string, query string, sendRe
 			return err
 		}
 	} else {
-		tag, err = session.DB.SafeExec(session.Context(), query, args...)
+		ctx := p.statementContext(session)
+		span := p.startSpan("pgrollback.execute", testID, query)
+		tag, err = session.DB.SafeExec(ctx, query, args...)
+		endExecuteSpan(span, tag, err)
+		p.relayStatementNotices(ctx)
 		if err != nil {
 			return err
 		}
+		session.DB.recordSchemaChanges(query)
 	}
 
 	// Envia o CommandTag real ANTES do ReadyForQuery.
-	// Aplica workaround para INSERT com oid=0 para compatibilidade com drivers.
-	tagStr := tag.String()
-	rowsAffected := tag.RowsAffected()
-	originalTagStr := tagStr
-
-	if strings.HasPrefix(tagStr, "INSERT 0 ") && rowsAffected >= 0 {
-		tagStr = fmt.Sprintf("INSERT %d", rowsAffected)
-		log.Printf("[PROXY] Workaround INSERT aplicado: '%s' -> '%s' (linhas=%d)", originalTagStr, tagStr, rowsAffected)
-	}
+	tagStr := protocol.NormalizeCommandTag(tag.String())
 
 	if tagStr != "" {
 		//log.Printf("[PROXY] Enviando CommandComplete: '%s'", tagStr)
@@ -356,10 +400,29 @@ func (p *proxyConnection) safeForwardMultipleCommandsSequential(
 	return nil
 }
 
+// streamFlushEveryRows is how many DataRows are queued before flushing to the client while streaming
+// a result set, so large results never sit whole in the backend write buffer.
+const streamFlushEveryRows = 1000
+
+// expectedResultCount returns how many results the backend will produce for fullQuery: one per parsed
+// statement, or one per non-empty command when the parser cannot handle the text.
+func expectedResultCount(fullQuery string, commands []string) int {
+	if stmts, err := sql.ParseStatements(fullQuery); err == nil && len(stmts) > 0 {
+		return len(stmts)
+	}
+	n := 0
+	for _, cmd := range commands {
+		if strings.TrimSpace(cmd) != "" {
+			n++
+		}
+	}
+	return n
+}
+
 // SafeForwardMultipleCommandsToDB lida com strings contendo múltiplos comandos separados por ponto e vírgula.
 // Runs the whole batch inside a savepoint: either all commands succeed (RELEASE SAVEPOINT) or none apply (ROLLBACK TO SAVEPOINT).
 // The real transaction is never aborted; only the savepoint is rolled back on failure.
-func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, commands []string, sendReadyForQuery bool) error {
+func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, commands []string, sendReadyForQuery bool) (err error) {
 	const multiCommandSavepointName = "pgrollback_multi_guard"
 	session := p.server.PgRollback.GetSession(testID)
 	if session == nil {
@@ -368,7 +431,7 @@ func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, command
 	if session.DB == nil {
 		return fmt.Errorf("sessão sem DB para testID: '%s'", testID)
 	}
-	ctx := session.Context()
+	ctx := p.statementContext(session)
 	pgConn := session.DB.PgConn()
 	if pgConn == nil {
 		return fmt.Errorf("sessão sem conexão para testID: '%s'", testID)
@@ -379,7 +442,7 @@ func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, command
 
 	// Gui methods are self-contained, safe to call before LockRun.
 	fullQuery := strings.Join(commands, "; ")
-	session.DB.Gui.SetLastQuery(fullQuery)
+	p.recordQuery(session.DB, fullQuery)
 	if !strings.HasSuffix(fullQuery, ";") {
 		fullQuery += ";"
 	}
@@ -391,8 +454,8 @@ func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, command
 		if c == "" {
 			continue
 		}
-		if IsUserBeginQuery(c) {
-			if err := session.DB.ClaimOpenTransaction(p.connectionID()); err != nil {
+		if session.DB.IsUserBeginQuery(c) {
+			if err := session.DB.ClaimOpenTransaction(p.connectionID(), p.clientAddr()); err != nil {
 				return err
 			}
 		}
@@ -410,6 +473,10 @@ func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, command
 
 	session.DB.LockRun()
 	defer session.DB.UnlockRun()
+	if err := session.DB.beginClientStatementLocked(ctx); err != nil {
+		return err
+	}
+	defer session.DB.endClientStatementLocked(ctx)
 
 	// Guard the whole batch with a savepoint: all run or none.
 	if _, err := session.DB.execTxLocked(ctx, "SAVEPOINT "+multiCommandSavepointName); err != nil {
@@ -420,66 +487,74 @@ func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, command
 		_, _ = session.DB.execTxLocked(ctx, "ROLLBACK TO SAVEPOINT "+multiCommandSavepointName+"; RELEASE SAVEPOINT "+multiCommandSavepointName)
 	}
 
+	span := p.startSpan("pgrollback.execute", testID, fullQuery)
+	defer func() { endSpan(span, err) }()
 	mrr := pgConn.Exec(ctx, fullQuery)
 	defer mrr.Close()
 
-	// Track only the last command's result (may be a SELECT with rows, SELECT with no rows, or a non-SELECT).
+	// Only the last command's result reaches the client. Its rows are streamed as they arrive (flushing
+	// every streamFlushEveryRows) instead of being buffered; earlier results are drained and discarded.
+	lastIndex := expectedResultCount(fullQuery, commands) - 1
 	var lastResultRowDesc *pgproto3.RowDescription
-	var lastResultRows []*pgproto3.DataRow
 	var lastResultTag []byte
+	streamed := false
 
-	for mrr.NextResult() {
+	for i := 0; mrr.NextResult(); i++ {
 		rr := mrr.ResultReader()
 		if rr == nil {
 			continue
 		}
 
-		fieldDescs := rr.FieldDescriptions()
-		if len(fieldDescs) > 0 {
-			// SELECT: keep this as the last result (rows may be empty).
-			fields := protocol.ConvertFieldDescriptions(fieldDescs)
-			lastResultRowDesc = &pgproto3.RowDescription{Fields: fields}
-			lastResultRows = nil
+		lastResultRowDesc = nil
+		if fieldDescs := rr.FieldDescriptions(); len(fieldDescs) > 0 {
+			lastResultRowDesc = &pgproto3.RowDescription{Fields: protocol.ConvertFieldDescriptions(fieldDescs)}
+		}
+		stream := lastResultRowDesc != nil && !isRollbackPair && i >= lastIndex
+		if stream {
+			// Notices from the earlier commands precede the streamed result.
+			p.relayBackendNotices(session.DB.notices)
+			p.backend.Send(lastResultRowDesc)
+			rowCount := 0
 			for rr.NextRow() {
-				values := rr.Values()
-				valuesCopy := make([][]byte, len(values))
-				for i, v := range values {
-					if v != nil {
-						valuesCopy[i] = make([]byte, len(v))
-						copy(valuesCopy[i], v)
+				p.backend.Send(&pgproto3.DataRow{Values: rr.Values()})
+				rowCount++
+				if rowCount%streamFlushEveryRows == 0 {
+					if err := p.backend.Flush(); err != nil {
+						_ = mrr.Close()
+						rollbackSavepoint()
+						return fmt.Errorf("falha no flush de linhas: %w", err)
 					}
 				}
-				lastResultRows = append(lastResultRows, &pgproto3.DataRow{Values: valuesCopy})
-			}
-			tag, err := rr.Close()
-			if err != nil {
-				rollbackSavepoint()
-				return fmt.Errorf("erro ao fechar result reader: %w", err)
 			}
-			lastResultTag = []byte(tag.String())
-		} else {
-			// Non-SELECT (UPDATE, INSERT, SET, etc): last result is just the tag.
-			lastResultRowDesc = nil
-			lastResultRows = nil
-			tag, err := rr.Close()
-			if err != nil {
-				rollbackSavepoint()
-				return fmt.Errorf("erro ao fechar result reader: %w", err)
-			}
-			lastResultTag = []byte(tag.String())
+		}
+		streamed = stream
+		// Rows not streamed are skipped by Close.
+		tag, err := rr.Close()
+		if err != nil {
+			p.relayBackendNotices(session.DB.notices)
+			rollbackSavepoint()
+			return fmt.Errorf("erro ao fechar result reader: %w", err)
+		}
+		lastResultTag = []byte(protocol.NormalizeCommandTag(tag.String()))
+		if stream {
+			p.relayBackendNotices(session.DB.notices)
+			p.backend.Send(&pgproto3.CommandComplete{CommandTag: lastResultTag})
 		}
 	}
 
-	// Send only the last command's result.
-	if isRollbackPair {
+	// Send the last command's result when it was not streamed; notices from every command precede it.
+	p.relayBackendNotices(session.DB.notices)
+	switch {
+	case isRollbackPair:
 		p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte("ROLLBACK")})
-	} else if lastResultRowDesc != nil {
+	case streamed:
+		// Already sent.
+	case lastResultRowDesc != nil:
+		// Fewer results than expected: the rows of the final SELECT were already discarded.
+		log.Printf("[PROXY] multi-command batch returned fewer results than statements; sending last result without rows")
 		p.backend.Send(lastResultRowDesc)
-		for _, row := range lastResultRows {
-			p.backend.Send(row)
-		}
 		p.backend.Send(&pgproto3.CommandComplete{CommandTag: lastResultTag})
-	} else if len(lastResultTag) > 0 {
+	case len(lastResultTag) > 0:
 		p.backend.Send(&pgproto3.CommandComplete{CommandTag: lastResultTag})
 	}
 
@@ -506,6 +581,7 @@ func (p *proxyConnection) SafeForwardMultipleCommandsToDB(testID string, command
 	// Use Locked variant: caller holds LockRun (d.mu), so we must not call session.DB methods that take d.mu again.
 	for _, cmd := range commands {
 		if trimmed := strings.TrimSpace(cmd); trimmed != "" {
+			session.DB.recordSchemaChanges(trimmed)
 			_ = p.ApplyTCLSuccessTrackingLocked(trimmed, session)
 		}
 	}
@@ -530,17 +606,23 @@ func (p *proxyConnection) ExecuteSelectQuery(testID string, query string, sendRe
 		return fmt.Errorf("sessão não encontrada para testID: %s", testID)
 	}
 	if session.DB != nil && query != "" {
-		session.DB.Gui.SetLastQuery(query)
+		p.recordQuery(session.DB, query)
 	}
 
 	start := time.Now()
-	rows, err := session.DB.SafeQuery(session.Context(), query, args...)
+	ctx := p.statementContext(session)
+	span := p.startSpan("pgrollback.execute", testID, query)
+	rows, err := p.querySelect(ctx, session, query, args...)
 	if err != nil {
+		endSpan(span, err)
+		p.relayStatementNotices(ctx)
 		return err
 	}
 	defer rows.Close()
 
-	if err := p.SendSelectResultsWithQuery(rows, query); err != nil {
+	err = p.sendSelectResults(rows, query, session.DB.notices)
+	endExecuteSpan(span, rows.CommandTag(), err)
+	if err != nil {
 		return err
 	}
 
@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+func TestExpectedResultCount(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    string
+		commands []string
+		want     int
+	}{
+		{"parsed statements", "SELECT 1; SELECT 2;", []string{"SELECT 1", "SELECT 2"}, 2},
+		{"comment-only command is not a result", "SELECT 1; -- trailing;", []string{"SELECT 1", "-- trailing"}, 1},
+		{"unparseable falls back to commands", "SELEC 1; SELEC 2;", []string{"SELEC 1", " ", "SELEC 2"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := expectedResultCount(tt.query, tt.commands); got != tt.want {
+				t.Fatalf("expectedResultCount(%q) = %d, want %d", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProcessSimpleQuery_EmptyQueryAnsweredLocally(t *testing.T) {
+	for _, q := range []string{"-- ping", "", "  \n", "/* nothing */ ;"} {
+		var out bytes.Buffer
+		p := newBufferedProxyConnection(&out)
+		// No server or session: any attempt to reach the backend would fail.
+		if err := p.ProcessSimpleQuery("t1", q); err != nil {
+			t.Fatalf("ProcessSimpleQuery(%q) = %v", q, err)
+		}
+		frontend := pgproto3.NewFrontend(&out, nil)
+		if msg, err := frontend.Receive(); err != nil {
+			t.Fatal(err)
+		} else if _, ok := msg.(*pgproto3.EmptyQueryResponse); !ok {
+			t.Fatalf("%q: got %T, want EmptyQueryResponse", q, msg)
+		}
+		if msg, err := frontend.Receive(); err != nil {
+			t.Fatal(err)
+		} else if _, ok := msg.(*pgproto3.ReadyForQuery); !ok {
+			t.Fatalf("%q: got %T, want ReadyForQuery", q, msg)
+		}
+	}
+}
+
+func TestHandleMessageExecute_EmptyStatement(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	// What handleMessageParse stores for Parse("") / Parse("-- comment"), then Bind to the unnamed portal.
+	p.SetPreparedStatement("", "")
+	p.portalToStatement[""] = ""
+
+	// No server or session: the empty statement must not reach the backend.
+	p.handleMessageExecute("t1", &pgproto3.Execute{})
+	p.backend.Flush()
+	frontend := pgproto3.NewFrontend(&out, nil)
+	if msg, err := frontend.Receive(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := msg.(*pgproto3.EmptyQueryResponse); !ok {
+		t.Fatalf("got %T, want EmptyQueryResponse", msg)
+	}
+}
@@ -1,12 +1,14 @@
 package proxy
 
 import (
+	"fmt"
 	"strings"
 	"time"
 
 	sqlpkg "pgrollback/pkg/sql"
 )
 
+// maxQueryHistory is the default number of queries a session keeps (proxy.query_history_size).
 const maxQueryHistory = 100
 
 // QueryHistoryEntry is one item in the session's query history (for GUI and internal storage).
@@ -16,11 +18,30 @@ type QueryHistoryEntry struct {
 	Duration string // execution time e.g. "12.345ms"; set when query completes
 }
 
+// historyQueryMarker prefixes the SELECT generated for "pgrollback history" so it is not itself recorded.
+const historyQueryMarker = "/* pgrollback history */"
+
+// historyResultSetQuery returns a SELECT with columns (at timestamptz, query text) for the last n entries, oldest first.
+func historyResultSetQuery(entries []QueryHistoryEntry, n int) string {
+	if len(entries) > n {
+		entries = entries[len(entries)-n:]
+	}
+	if len(entries) == 0 {
+		return historyQueryMarker + " SELECT NULL::timestamptz AS at, NULL::text AS query WHERE false"
+	}
+	rows := make([]string, len(entries))
+	for i, e := range entries {
+		rows[i] = fmt.Sprintf("(%d, '%s'::timestamptz, '%s'::text)", i, e.At.Format(time.RFC3339Nano), strings.ReplaceAll(e.Query, "'", "''"))
+	}
+	return historyQueryMarker + " SELECT at, query FROM (VALUES " + strings.Join(rows, ", ") + ") AS h(n, at, query) ORDER BY n"
+}
+
 // isInternalNoiseQuery returns true for standard driver/internal queries we don't want in the GUI history.
 // - DEALLOCATE [name]: sent by many drivers after each prepared statement use (expected protocol cleanup).
+// - The SELECT generated for "pgrollback history".
 func isInternalNoiseQuery(query string) bool {
 	q := strings.TrimSpace(query)
-	if q == "" {
+	if q == "" || strings.HasPrefix(q, historyQueryMarker) {
 		return true
 	}
 	stmts, err := sqlpkg.ParseStatements(q)
@@ -31,32 +52,75 @@ func isInternalNoiseQuery(query string) bool {
 	return sqlpkg.IsDeallocateNoise(stmts[0].Stmt)
 }
 
-// SetLastQuery appends the query to the session's query history (max maxQueryHistory).
-// Internal noise queries (e.g. DEALLOCATE from the driver) are not recorded.
+// setHistorySize sets how many queries the history keeps: size > 0 keeps that many, 0 or less disables
+// the history (nothing is recorded, not even the last query). Older entries beyond size are dropped.
+func (g *guiState) setHistorySize(size int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if size <= 0 {
+		g.historySize = -1
+		g.queryHistory = nil
+		return
+	}
+	g.historySize = size
+	if over := len(g.queryHistory) - size; over > 0 {
+		g.queryHistory = append([]QueryHistoryEntry(nil), g.queryHistory[over:]...)
+	}
+}
+
+// historyLimit returns how many queries the history keeps (0 when disabled).
+func (g *guiState) historyLimit() int {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.historyLimitLocked()
+}
+
+func (g *guiState) historyLimitLocked() int {
+	switch {
+	case g.historySize < 0:
+		return 0
+	case g.historySize == 0:
+		return maxQueryHistory
+	}
+	return g.historySize
+}
+
+// SetLastQuery appends the query to the session's query history (at most historyLimit entries; nothing
+// when the history is disabled). Internal noise queries (e.g. DEALLOCATE from the driver) are not recorded.
 func (g *guiState) SetLastQuery(query string) {
+	g.SetLastQueryFrom(query, "")
+}
+
+// SetLastQueryFrom is SetLastQuery for a query of the client connection labeled connLabel (see
+// history_label.go); the query is stored as "[connLabel] query", or as is when connLabel is empty.
+func (g *guiState) SetLastQueryFrom(query, connLabel string) {
 	g.mu.Lock()
 	defer g.mu.Unlock()
-	if isInternalNoiseQuery(query) {
+	limit := g.historyLimitLocked()
+	if limit == 0 || isInternalNoiseQuery(query) {
 		return
 	}
-	g.queryHistory = append(g.queryHistory, QueryHistoryEntry{Query: query, At: time.Now(), Duration: ""})
-	if len(g.queryHistory) > maxQueryHistory {
+	g.queryHistory = append(g.queryHistory, QueryHistoryEntry{Query: labelQuery(query, connLabel), At: time.Now(), Duration: ""})
+	if len(g.queryHistory) > limit {
 		g.queryHistory = g.queryHistory[1:]
 	}
 }
 
 // SetLastQueryWithParams stores the query with $1, $2, ... substituted by the given args (for extended protocol).
-// connLabel is optional (e.g. connection remote address) and is prepended in the stored query for GUI.
+// connLabel is optional (the connection's history label, see history_label.go) and is prepended in the stored query for GUI.
 func (d *realSessionDB) SetLastQueryWithParams(query string, args []any, connLabel string) {
+	if d.Gui.historyLimit() == 0 {
+		return // history disabled: skip substituting the parameters
+	}
 	if len(args) == 0 {
-		d.Gui.SetLastQuery(query)
+		d.Gui.SetLastQueryFrom(query, connLabel)
 		return
 	}
-	resolved := sqlpkg.SubstituteParams(query, args, connLabel)
-	d.Gui.SetLastQuery(resolved)
+	resolved := sqlpkg.SubstituteParams(query, args)
+	d.Gui.SetLastQueryFrom(resolved, connLabel)
 }
 
-// GetQueryHistory returns a copy of the last executed queries with timestamps (oldest first), at most maxQueryHistory.
+// GetQueryHistory returns a copy of the last executed queries with timestamps (oldest first), at most historyLimit.
 func (g *guiState) GetQueryHistory() []QueryHistoryEntry {
 	g.mu.RLock()
 	defer g.mu.RUnlock()
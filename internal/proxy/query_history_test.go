@@ -2,6 +2,8 @@ package proxy
 
 import (
 	"context"
+	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -179,8 +181,85 @@ func TestQueryHistory_MaxPreservesNewest(t *testing.T) {
 	}
 }
 
+func TestQueryHistory_ConfiguredSize(t *testing.T) {
+	db := newTestSessionDB()
+	db.Gui.setHistorySize(3)
+	for i := 0; i < 5; i++ {
+		db.Gui.SetLastQuery(fmt.Sprintf("SELECT %d", i))
+	}
+	hist := db.Gui.GetQueryHistory()
+	if len(hist) != 3 || hist[0].Query != "SELECT 2" || hist[2].Query != "SELECT 4" {
+		t.Fatalf("history = %+v, want SELECT 2..4", hist)
+	}
+	db.Gui.setHistorySize(2)
+	if hist := db.Gui.GetQueryHistory(); len(hist) != 2 || hist[0].Query != "SELECT 3" {
+		t.Errorf("after shrinking, history = %+v, want SELECT 3..4", hist)
+	}
+}
+
+func TestQueryHistory_ZeroDisables(t *testing.T) {
+	db := newTestSessionDB()
+	db.Gui.SetLastQuery("SELECT 1")
+	db.Gui.setHistorySize(0)
+	db.Gui.SetLastQuery("SELECT 2")
+	db.SetLastQueryWithParams("SELECT $1", []any{1}, "")
+	if hist := db.Gui.GetQueryHistory(); hist != nil {
+		t.Errorf("history = %+v, want none", hist)
+	}
+	if got := db.Gui.GetLastQuery(); got != "" {
+		t.Errorf("GetLastQuery = %q, want empty", got)
+	}
+	if got := db.Gui.historyLimit(); got != 0 {
+		t.Errorf("historyLimit = %d, want 0", got)
+	}
+}
+
 // --- ClearQueryHistory ---
 
+// --- pgrollback history ---
+
+func TestHistoryResultSetQuery_LastNOldestFirst(t *testing.T) {
+	at := time.Date(2024, 5, 6, 7, 8, 9, 0, time.UTC)
+	entries := []QueryHistoryEntry{
+		{Query: "SELECT 1", At: at},
+		{Query: "INSERT INTO t VALUES ('x')", At: at.Add(time.Second)},
+		{Query: "SELECT 3", At: at.Add(2 * time.Second)},
+	}
+	q := historyResultSetQuery(entries, 2)
+	if strings.Contains(q, "SELECT 1'") {
+		t.Errorf("query should only contain the last 2 entries: %s", q)
+	}
+	if !strings.Contains(q, "(0, '2024-05-06T07:08:10Z'::timestamptz, 'INSERT INTO t VALUES (''x'')'::text)") {
+		t.Errorf("query missing escaped first row: %s", q)
+	}
+	if !strings.Contains(q, "(1, '2024-05-06T07:08:11Z'::timestamptz, 'SELECT 3'::text)") {
+		t.Errorf("query missing last row: %s", q)
+	}
+	stmts, err := sqlpkg.ParseStatements(q)
+	if err != nil || len(stmts) != 1 || !sqlpkg.StmtReturnsResultSet(stmts[0].Stmt) {
+		t.Fatalf("history query must be a single SELECT: %v", err)
+	}
+	if c := sqlpkg.CommandStringFromRaw(q, stmts[0]); !isInternalNoiseQuery(c) {
+		t.Errorf("history query %q must not be recorded in the history", c)
+	}
+}
+
+func TestHistoryResultSetQuery_Empty(t *testing.T) {
+	q := historyResultSetQuery(nil, maxQueryHistory)
+	if !strings.Contains(q, "WHERE false") {
+		t.Errorf("empty history query = %s", q)
+	}
+}
+
+func TestInterceptPgRollbackCommand_HistoryRejectsBadN(t *testing.T) {
+	p := NewPgRollback("localhost", 5432, "postgres", "postgres", "", 0, 0, 0)
+	for _, q := range []string{"pgrollback history 0", "pgrollback history abc"} {
+		if _, err := p.InterceptQuery("t1", q, 0); err == nil {
+			t.Errorf("%s should fail", q)
+		}
+	}
+}
+
 func TestClearQueryHistory(t *testing.T) {
 	db := newTestSessionDB()
 	db.Gui.SetLastQuery("SELECT 1")
@@ -224,7 +303,7 @@ func TestQueryHistory_ReturnsCopy(t *testing.T) {
 // --- SubstituteParams (via sql package) ---
 
 func TestSubstituteParams_Basic(t *testing.T) {
-	got := sqlpkg.SubstituteParams("SELECT $1, $2", []any{"hello", int32(42)}, "")
+	got := sqlpkg.SubstituteParams("SELECT $1, $2", []any{"hello", int32(42)})
 	want := "SELECT 'hello', 42"
 	if got != want {
 		t.Errorf("got %q, want %q", got, want)
@@ -237,7 +316,7 @@ func TestSubstituteParams_HighIndexFirst(t *testing.T) {
 	for i := range args {
 		args[i] = i + 1
 	}
-	got := sqlpkg.SubstituteParams("$1 $10", args, "")
+	got := sqlpkg.SubstituteParams("$1 $10", args)
 	want := "1 10"
 	if got != want {
 		t.Errorf("got %q, want %q", got, want)
@@ -245,7 +324,7 @@ func TestSubstituteParams_HighIndexFirst(t *testing.T) {
 }
 
 func TestSubstituteParams_Nil(t *testing.T) {
-	got := sqlpkg.SubstituteParams("SELECT $1", []any{nil}, "")
+	got := sqlpkg.SubstituteParams("SELECT $1", []any{nil})
 	want := "SELECT NULL"
 	if got != want {
 		t.Errorf("got %q, want %q", got, want)
@@ -253,7 +332,7 @@ func TestSubstituteParams_Nil(t *testing.T) {
 }
 
 func TestSubstituteParams_NoArgs(t *testing.T) {
-	got := sqlpkg.SubstituteParams("SELECT 1", nil, "")
+	got := sqlpkg.SubstituteParams("SELECT 1", nil)
 	if got != "SELECT 1" {
 		t.Errorf("got %q, want %q", got, "SELECT 1")
 	}
@@ -304,7 +383,7 @@ func TestHasOpenUserTransaction(t *testing.T) {
 		t.Error("new session should not have open user transaction")
 	}
 	// Simulate a user BEGIN
-	if err := db.ClaimOpenTransaction(ConnectionID(1)); err != nil {
+	if err := db.ClaimOpenTransaction(ConnectionID(1), ""); err != nil {
 		t.Fatal(err)
 	}
 	if !db.HasOpenUserTransaction() {
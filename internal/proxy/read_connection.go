@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	sqlpkg "pgrollback/pkg/sql"
+)
+
+// Read connection (proxy.read_connection): an optional second backend connection per session, in
+// autocommit READ ONLY mode, that serves plain SELECTs from client connections that started with
+// default_transaction_read_only=on. Those SELECTs no longer wait for the session's write
+// connection, but they only see committed data: nothing the test wrote in its (never committed)
+// base transaction is visible to them. Writes, TCL and extended-protocol statements always stay on
+// the single write connection.
+
+// readConnection serializes use of the read-only backend connection; it is independent of
+// realSessionDB.mu so reads do not queue behind the write connection. conn is nil after a reopen
+// failed: the write connection then serves the reads, as when the read connection could not be opened.
+type readConnection struct {
+	mu   sync.Mutex
+	conn *pgx.Conn
+	dial func(ctx context.Context) (*pgx.Conn, error) // opens a new read-only connection, for reopen
+}
+
+// readConnRows unlocks the read connection when the rows are closed.
+type readConnRows struct {
+	pgx.Rows
+	release func()
+	closed  bool
+}
+
+func (r *readConnRows) Close() {
+	if r.closed {
+		return
+	}
+	r.closed = true
+	r.Rows.Close()
+	r.release()
+}
+
+// available reports whether reads can go to the read connection. Safe on a nil receiver.
+func (r *readConnection) available() bool {
+	if r == nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.conn != nil && !r.conn.IsClosed()
+}
+
+// query runs sql on the read connection; the connection stays locked until the rows are closed.
+func (r *readConnection) query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	r.mu.Lock()
+	if r.conn == nil {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("read connection: unavailable")
+	}
+	rows, err := r.conn.Query(ctx, sql, args...)
+	if err != nil {
+		r.mu.Unlock()
+		return nil, fmt.Errorf("read connection: %w", err)
+	}
+	return &readConnRows{Rows: rows, release: r.mu.Unlock}, nil
+}
+
+// reopen replaces the read connection with a new one, after the session's backend was lost (see
+// backend_reconnect.go): the old one most likely died with it. On failure the read connection is left
+// unavailable. Safe on a nil receiver.
+func (r *readConnection) reopen(ctx context.Context) error {
+	if r == nil || r.dial == nil {
+		return nil
+	}
+	conn, err := r.dial(ctx)
+	r.mu.Lock()
+	old := r.conn
+	r.conn = conn
+	r.mu.Unlock()
+	if old != nil {
+		closeCtx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_ = old.Close(closeCtx)
+		cancel()
+	}
+	return err
+}
+
+func (r *readConnection) close(ctx context.Context) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	return r.conn.Close(ctx)
+}
+
+// newReadConnectionForTestID opens the read-only backend connection for a session
+// (application_name pgrollback-<testID>_read).
+func newReadConnectionForTestID(host string, port int, database string, user string, password string, sessionTimeout time.Duration, testID string) (*readConnection, error) {
+	config, err := backendConnConfig(host, port, database, user, password, sessionTimeout, getAppNameForTestID(testID)+"_read")
+	if err != nil {
+		return nil, err
+	}
+	r := &readConnection{dial: func(ctx context.Context) (*pgx.Conn, error) {
+		conn, err := pgx.ConnectConfig(ctx, config.Copy())
+		if err != nil {
+			return nil, err
+		}
+		if _, err := conn.Exec(ctx, "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY; SET statement_timeout = '0'"); err != nil {
+			conn.Close(context.Background())
+			return nil, fmt.Errorf("failed to make read connection read-only: %w", err)
+		}
+		return conn, nil
+	}}
+	if r.conn, err = r.dial(context.Background()); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// clientRequestsReadOnly reports whether the client's startup parameters ask for a read-only session:
+// default_transaction_read_only=on directly or as options=-c default_transaction_read_only=on.
+func clientRequestsReadOnly(params map[string]string) bool {
+	if isOnSetting(params["default_transaction_read_only"]) {
+		return true
+	}
+	fields := strings.Fields(params["options"])
+	for i, f := range fields {
+		setting := strings.TrimPrefix(f, "--")
+		if f == "-c" && i+1 < len(fields) {
+			setting = fields[i+1]
+		} else if strings.HasPrefix(f, "-c") {
+			setting = strings.TrimPrefix(f, "-c")
+		}
+		name, value, ok := strings.Cut(setting, "=")
+		if ok && strings.EqualFold(strings.ReplaceAll(name, "-", "_"), "default_transaction_read_only") && isOnSetting(value) {
+			return true
+		}
+	}
+	return false
+}
+
+// isOnSetting accepts the boolean spellings PostgreSQL accepts for "true".
+func isOnSetting(v string) bool {
+	switch strings.ToLower(strings.TrimSpace(v)) {
+	case "on", "true", "yes", "1", "t", "y":
+		return true
+	}
+	return false
+}
+
+// querySelect runs a result-set query for this client: on the session's read connection when the
+// client is read-only and the query is a plain SELECT, otherwise on the write connection (SafeQuery).
+// ctx is the statement's (statementContext).
+// Results are requested in text format: pgx would otherwise ask for binary columns, and the raw
+// values and field descriptions are relayed as-is to a client that expects PostgreSQL's text output.
+func (p *proxyConnection) querySelect(ctx context.Context, session *TestSession, query string, args ...any) (pgx.Rows, error) {
+	args = append([]any{pgx.QueryResultFormats{pgx.TextFormatCode}}, args...)
+	// The read connection runs as the session user, so a connection that switched role stays on the write one.
+	if p.readOnly && session.DB.readConn.available() && !p.hasRoleSet() {
+		if stmts, err := sqlpkg.ParseStatements(query); err == nil && len(stmts) == 1 && sqlpkg.IsPlainSelect(stmts[0].Stmt) {
+			return session.DB.readConn.query(ctx, query, args...)
+		}
+	}
+	return session.DB.SafeQuery(ctx, query, args...)
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import "testing"
+
+func TestClientRequestsReadOnly(t *testing.T) {
+	tests := []struct {
+		name   string
+		params map[string]string
+		want   bool
+	}{
+		{"none", map[string]string{"user": "postgres"}, false},
+		{"parameter on", map[string]string{"default_transaction_read_only": "on"}, true},
+		{"parameter off", map[string]string{"default_transaction_read_only": "off"}, false},
+		{"options -c separate", map[string]string{"options": "-c default_transaction_read_only=on"}, true},
+		{"options -c joined", map[string]string{"options": "-cdefault_transaction_read_only=true"}, true},
+		{"options long form", map[string]string{"options": "--default-transaction-read-only=on"}, true},
+		{"options other setting", map[string]string{"options": "-c search_path=app"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := clientRequestsReadOnly(tt.params); got != tt.want {
+				t.Errorf("clientRequestsReadOnly(%v) = %v, want %v", tt.params, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestQuerySelect_WithoutReadConnectionUsesWriteConnection(t *testing.T) {
+	// No read connection on the session: a read-only client still goes through SafeQuery,
+	// which fails here because the test session has no backend transaction.
+	db := newTestSessionDB()
+	session := &TestSession{DB: db}
+	p := &proxyConnection{readOnly: true}
+	if _, err := p.querySelect(p.statementContext(session), session, "SELECT 1"); err == nil {
+		t.Fatal("expected SafeQuery error without a backend transaction")
+	}
+}
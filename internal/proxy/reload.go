@@ -0,0 +1,36 @@
+package proxy
+
+import "time"
+
+// Settings changed while the proxy runs (SIGHUP in cmd/pgrollback).
+//
+// Only values the proxy reads again on every use can change without a restart: the session inactivity
+// timeout (proxy.timeout) is checked by each cleanup pass, and the keepalive interval
+// (proxy.keepalive_interval) is read when a session is created. Live sessions and client connections are
+// kept; their keepalive goroutine is restarted with the new interval.
+
+// SetTimeout changes the inactivity timeout after which CleanupExpiredSessions destroys a session.
+func (p *PgRollback) SetTimeout(timeout time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Timeout = timeout
+}
+
+// SetKeepaliveInterval changes the backend ping interval of new sessions and restarts the keepalive of
+// the live ones with it; 0 turns keepalive off.
+func (p *PgRollback) SetKeepaliveInterval(interval time.Duration) {
+	p.mu.Lock()
+	p.KeepaliveInterval = interval
+	p.mu.Unlock()
+	for _, session := range p.GetAllSessions() {
+		if session.DB != nil {
+			session.DB.restartKeepalive(interval)
+		}
+	}
+}
+
+// restartKeepalive stops the keepalive goroutine, if any, and starts one with interval (none when 0).
+func (d *realSessionDB) restartKeepalive(interval time.Duration) {
+	d.stopKeepaliveUnlocked()
+	d.startKeepalive(interval)
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSetTimeout_AppliesToLiveSessions(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Hour, time.Hour, 0)
+	session, _ := pgr.newFakeTestSession("reload")
+	session.mu.Lock()
+	session.LastActivity = time.Now().Add(-time.Minute)
+	session.mu.Unlock()
+
+	if n, err := pgr.CleanupExpiredSessions(); err != nil || n != 0 {
+		t.Fatalf("CleanupExpiredSessions with 1h timeout = %d, %v; want 0, nil", n, err)
+	}
+	pgr.SetTimeout(time.Second)
+	if n, err := pgr.CleanupExpiredSessions(); err != nil || n != 1 {
+		t.Fatalf("CleanupExpiredSessions after SetTimeout(1s) = %d, %v; want 1, nil", n, err)
+	}
+}
+
+func TestSetKeepaliveInterval_RestartsLiveKeepalive(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Hour, time.Hour, time.Minute)
+	session, _ := pgr.newFakeTestSession("reload")
+	stopped := false
+	session.DB.stopKeepalive = func() { stopped = true }
+
+	pgr.SetKeepaliveInterval(0)
+	if pgr.KeepaliveInterval != 0 {
+		t.Errorf("KeepaliveInterval = %s, want 0", pgr.KeepaliveInterval)
+	}
+	if !stopped {
+		t.Error("the live session's keepalive was not stopped")
+	}
+	if session.DB.stopKeepalive != nil {
+		t.Error("keepalive restarted with interval 0")
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// ReplayReadTimeout bounds how long ReplayCapture waits for each response the capture says comes next.
+const ReplayReadTimeout = 30 * time.Second
+
+// replayPassword answers the proxy's password request; it is not verified.
+const replayPassword = "pgrollback-replay"
+
+// ReplayCapture connects to the proxy at addr (host:port), starts up with the captured StartupMessage
+// parameters (so the same test ID is used) and sends the captured client messages in their order. Before
+// each client message it reads as many responses as the capture shows the proxy sent before it, and
+// prints both to out: "> " for what was sent, "< " for what was received, followed by "! captured: "
+// when a response differs from the captured one (another message type, or another CommandComplete,
+// ErrorResponse or ReadyForQuery). It returns the number of differing responses.
+func ReplayCapture(ctx context.Context, addr string, capture io.Reader, out io.Writer) (differences int, err error) {
+	header, records, err := readCapture(capture)
+	if err != nil {
+		return 0, err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.SetDeadline(time.Now()) })
+	defer stop()
+
+	frontend := pgproto3.NewFrontend(conn, conn)
+	if err := replayStartup(conn, frontend, header.Startup); err != nil {
+		return 0, fmt.Errorf("startup: %w", err)
+	}
+	fmt.Fprintf(out, "replaying %d messages of test ID %q captured %s\n", len(records), header.TestID, header.Started.Format(time.RFC3339))
+
+	terminated := false
+	for _, rec := range records {
+		if rec.Direction == captureFromClient {
+			if _, err := conn.Write(rec.Frame); err != nil {
+				return differences, err
+			}
+			fmt.Fprintf(out, "> %s\n", describeFrame(rec))
+			if rec.Frame[0] == 'X' {
+				terminated = true
+				break
+			}
+			continue
+		}
+		conn.SetReadDeadline(time.Now().Add(ReplayReadTimeout))
+		msg, err := frontend.Receive()
+		if err != nil {
+			return differences, fmt.Errorf("waiting for %s: %w", describeFrame(rec), err)
+		}
+		got := msg.Encode(nil)
+		fmt.Fprintf(out, "< %s\n", messageJSON(msg))
+		if responseDiffers(rec.Frame, got) {
+			differences++
+			fmt.Fprintf(out, "! captured: %s\n", describeFrame(rec))
+		}
+	}
+	if !terminated {
+		_, _ = conn.Write((&pgproto3.Terminate{}).Encode(nil))
+	}
+	if ctx.Err() != nil {
+		return differences, ctx.Err()
+	}
+	return differences, nil
+}
+
+// replayStartup sends the StartupMessage, answers the password request and waits for ReadyForQuery.
+func replayStartup(conn net.Conn, frontend *pgproto3.Frontend, params map[string]string) error {
+	conn.SetDeadline(time.Now().Add(ReplayReadTimeout))
+	defer conn.SetDeadline(time.Time{})
+	frontend.Send(&pgproto3.StartupMessage{ProtocolVersion: pgproto3.ProtocolVersionNumber, Parameters: params})
+	if err := frontend.Flush(); err != nil {
+		return err
+	}
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			return err
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.AuthenticationCleartextPassword, *pgproto3.AuthenticationMD5Password:
+			frontend.Send(&pgproto3.PasswordMessage{Password: replayPassword})
+			if err := frontend.Flush(); err != nil {
+				return err
+			}
+		case *pgproto3.ErrorResponse:
+			return fmt.Errorf("%s: %s (SQLSTATE %s)", msg.Severity, msg.Message, msg.Code)
+		case *pgproto3.ReadyForQuery:
+			return nil
+		}
+	}
+}
+
+// responseDiffers reports whether a received frame differs from the captured one in a way that matters
+// for reproducing a bug: the message type, or the content of messages that carry the outcome.
+func responseDiffers(captured, got []byte) bool {
+	if len(captured) == 0 || len(got) == 0 || captured[0] != got[0] {
+		return true
+	}
+	switch captured[0] {
+	case 'C', 'E', 'Z': // CommandComplete, ErrorResponse, ReadyForQuery
+		return !bytes.Equal(captured, got)
+	}
+	return false
+}
+
+// describeFrame decodes a captured frame for printing; undecodable frames are shown by type and size.
+func describeFrame(rec captureRecord) string {
+	var (
+		msg any
+		err error
+	)
+	if rec.Direction == captureFromClient {
+		msg, err = pgproto3.NewBackend(bytes.NewReader(rec.Frame), nil).Receive()
+	} else {
+		msg, err = pgproto3.NewFrontend(bytes.NewReader(rec.Frame), nil).Receive()
+	}
+	if err != nil {
+		return fmt.Sprintf("%q message of %d bytes", rec.Frame[0], len(rec.Frame))
+	}
+	return messageJSON(msg)
+}
+
+func messageJSON(msg any) string {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Sprintf("%T", msg)
+	}
+	return string(b)
+}
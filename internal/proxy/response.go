@@ -10,6 +10,7 @@ import (
 	"pgrollback/pkg/sql"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
 )
 
@@ -43,6 +44,7 @@ func resolveFieldDescriptions(query string, rows pgx.Rows) (fields []pgproto3.Fi
 			oids[i] = c.OID
 		}
 		fields = protocol.FieldDescriptionsFromNamesAndOIDs(names, oids)
+		useBackendTypes(fields, oids, rows.FieldDescriptions())
 		returnOIDs = oids
 	}
 	if fields == nil {
@@ -67,9 +69,34 @@ func resolveFieldDescriptions(query string, rows pgx.Rows) (fields []pgproto3.Fi
 	return fields, returnOIDs, returnsSet
 }
 
+// useBackendTypes replaces the guessed types of a synthetic RETURNING description (text, or int8 for "id")
+// with those of the backend's RowDescription, so uuid, timestamptz, numeric... columns are announced
+// with their real OID. oids is updated alongside fields. Columns the backend did not type (OID 0), or a
+// backend description of another width (pgx before the first row), keep the guess.
+func useBackendTypes(fields []pgproto3.FieldDescription, oids []uint32, backend []pgconn.FieldDescription) {
+	if len(backend) != len(fields) {
+		return
+	}
+	for i, f := range backend {
+		if f.DataTypeOID == 0 {
+			continue
+		}
+		fields[i].DataTypeOID = f.DataTypeOID
+		fields[i].DataTypeSize = f.DataTypeSize
+		fields[i].TypeModifier = f.TypeModifier
+		oids[i] = f.DataTypeOID
+	}
+}
+
 // SendSelectResultsWithQuery envia resultados; se query tiver RETURNING, usa o mesmo RowDescription
 // sintético do Describe para que clientes (ex.: PHP PDO) que dependem da consistência recebam a linha.
 func (p *proxyConnection) SendSelectResultsWithQuery(rows pgx.Rows, query string) error {
+	return p.sendSelectResults(rows, query, nil)
+}
+
+// sendSelectResults is SendSelectResultsWithQuery that also relays backend notices raised while the
+// rows were read, before CommandComplete (as PostgreSQL itself orders them).
+func (p *proxyConnection) sendSelectResults(rows pgx.Rows, query string, notices *backendNotices) error {
 	fields, returnOIDs, returnsSet := resolveFieldDescriptions(query, rows)
 	if os.Getenv("PGROLLBACK_LOG_MESSAGE_ORDER") == "1" {
 		log.Printf("[MSG_ORDER] SEND RowDescription: %d cols", len(fields))
@@ -81,18 +108,15 @@ func (p *proxyConnection) SendSelectResultsWithQuery(rows pgx.Rows, query string
 		rowCount++
 		rawValues := rows.RawValues()
 		if len(returnOIDs) > 0 && len(rawValues) == len(returnOIDs) {
-			// Synthetic RowDescription uses Format 0 (text); convert binary backend values to text
-			textValues := make([][]byte, len(rawValues))
-			for i, raw := range rawValues {
-				oid := uint32(25)
-				if i < len(returnOIDs) {
-					oid = returnOIDs[i]
-				}
-				textValues[i] = protocol.RawValueToText(oid, raw)
-			}
-			rawValues = textValues
+			// Synthetic RowDescription uses Format 0 (text); convert columns the backend sent as binary
+			rawValues = textRawValues(rows.FieldDescriptions(), returnOIDs, rawValues)
 		}
 		p.backend.Send(&pgproto3.DataRow{Values: rawValues})
+		if rowCount%streamFlushEveryRows == 0 {
+			if err := p.backend.Flush(); err != nil {
+				return fmt.Errorf("falha no flush dos resultados do select: %w", err)
+			}
+		}
 	}
 	if query != "" && returnsSet && rowCount == 0 {
 		preview := strings.TrimSpace(query)
@@ -101,17 +125,28 @@ func (p *proxyConnection) SendSelectResultsWithQuery(rows pgx.Rows, query string
 		}
 		log.Printf("[PROXY] INSERT/UPDATE/DELETE RETURNING returned 0 rows (cols=%d); client may get empty result; query: %s", len(fields), preview)
 	}
+	tag := resultCommandTag(rows, rowCount)
 	if os.Getenv("PGROLLBACK_LOG_MESSAGE_ORDER") == "1" {
 		log.Printf("[MSG_ORDER] SEND DataRows: %d", rowCount)
-		log.Printf("[MSG_ORDER] SEND CommandComplete: SELECT %d", rowCount)
+		log.Printf("[MSG_ORDER] SEND CommandComplete: %s", tag)
 	}
-	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(fmt.Sprintf("SELECT %d", rowCount))})
+	p.relayBackendNotices(notices)
+	p.backend.Send(&pgproto3.CommandComplete{CommandTag: []byte(tag)})
 	if err := p.backend.Flush(); err != nil {
 		return fmt.Errorf("falha no flush dos resultados do select: %w", err)
 	}
 	return nil
 }
 
+// resultCommandTag returns the CommandComplete tag for a finished result: INSERT/UPDATE/DELETE ... RETURNING
+// keep the backend's own tag (rows affected), anything else is reported as "SELECT <rows>".
+func resultCommandTag(rows pgx.Rows, rowCount int) string {
+	if tag := rows.CommandTag(); tag.Insert() || tag.Update() || tag.Delete() {
+		return protocol.NormalizeCommandTag(tag.String())
+	}
+	return fmt.Sprintf("SELECT %d", rowCount)
+}
+
 // SendCommandComplete envia a mensagem de completamento de comando.
 func (p *proxyConnection) SendCommandComplete(cmd string) {
 	tag := sql.GetCommandTagFallback(cmd)
@@ -119,10 +154,18 @@ func (p *proxyConnection) SendCommandComplete(cmd string) {
 }
 
 // ReadyForQueryTxStatus returns the transaction status byte for ReadyForQuery.
-// 'I' = idle, 'T' = in transaction. Used so libpq's PQtransactionStatus() (and thus PDO's
-// pdo_is_in_transaction()) matches the connection's user-transaction count. Exported for tests.
+// 'I' = idle, 'T' = in transaction, 'E' = in a failed transaction. Used so libpq's PQtransactionStatus()
+// (and thus PDO's pdo_is_in_transaction(), psql's prompt) matches the connection's user transaction.
+// Only this connection's BEGINs count: the session's SavepointLevel also includes savepoints opened by
+// other connections of the same testID, which are not in a transaction from this client's view.
+// Exported for tests.
 func (p *proxyConnection) ReadyForQueryTxStatus() byte {
-	if p.GetUserOpenTransactionCount() > 0 {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	switch {
+	case p.userOpenTransactionCount > 0 && p.userTxFailed:
+		return 'E'
+	case p.userOpenTransactionCount > 0:
 		return 'T'
 	}
 	return 'I'
@@ -132,10 +175,14 @@ func (p *proxyConnection) ReadyForQueryTxStatus() byte {
 // The TxStatus byte drives libpq's PQtransactionStatus() and therefore PDO's
 // pdo_is_in_transaction() check. We send:
 //   - 'T' (in transaction) when the connection has open user transactions (userOpenTransactionCount > 0)
+//   - 'E' (failed)         when, in addition, a statement failed since the current level's BEGIN
 //   - 'I' (idle)           when no user transaction is active
 //
-// This ensures PDO/libpq see the correct transaction state after BEGIN and COMMIT/ROLLBACK.
+// This ensures PDO/libpq see the correct transaction state after BEGIN and COMMIT/ROLLBACK. After 'E'
+// the proxy still runs further statements (the failed one was undone by its guard savepoint), unlike
+// PostgreSQL, which answers 25P02 until ROLLBACK.
 func (p *proxyConnection) SendReadyForQuery() {
+	p.sendParameterStatusChanges()
 	status := p.ReadyForQueryTxStatus()
 	p.backend.Send(&pgproto3.ReadyForQuery{TxStatus: status})
 	if err := p.backend.Flush(); err != nil {
@@ -146,24 +193,44 @@ func (p *proxyConnection) SendReadyForQuery() {
 // SendErrorResponse constrói e envia uma mensagem de erro PostgreSQL padrão.
 // Seguido por ReadyForQuery para garantir que o cliente possa continuar.
 func (p *proxyConnection) SendErrorResponse(err error) {
-	p.backend.Send(&pgproto3.ErrorResponse{
-		Severity: "ERROR",
-		Message:  err.Error(),
-		Code:     "XX000", // Internal Error como default
-	})
+	p.markUserTransactionFailed()
+	p.backend.Send(errorResponseFor(err))
 	p.SendReadyForQuery()
 }
 
 // sendExtendedQueryErr sends an ErrorResponse for an extended-query pipeline message (Parse,
 // Describe, Bind, Execute) WITHOUT ReadyForQuery. ReadyForQuery is sent only on Sync.
-// It also caches the error so subsequent messages in the same pipeline cycle (before Sync)
-// are short-circuited with the original error, preventing confusing secondary errors.
+// It also records the error, so the rest of the cycle is skipped until Sync (see skipUntilSync).
 func (p *proxyConnection) sendExtendedQueryErr(err error) {
+	p.markUserTransactionFailed()
 	p.extendedQueryPendingError = err
-	p.backend.Send(&pgproto3.ErrorResponse{
-		Severity: "ERROR",
-		Message:  err.Error(),
-		Code:     "XX000",
-	})
+	p.backend.Send(errorResponseFor(err))
 	p.backend.Flush()
 }
+
+// skipUntilSync reports whether an earlier message of this extended-query cycle failed. Like PostgreSQL,
+// the connection then discards Parse, Bind, Describe, Execute and Close without any response until Sync,
+// which answers with the cycle's single ReadyForQuery: a pipelining client (a pgx batch) gets exactly one
+// ErrorResponse for the cycle, not one per message it queued behind the failed one.
+func (p *proxyConnection) skipUntilSync(message string) bool {
+	if p.extendedQueryPendingError == nil {
+		return false
+	}
+	logIfVerbose("[PROXY] %s ignorado até o Sync após erro: %v", message, p.extendedQueryPendingError)
+	return true
+}
+
+// textRawValues returns values with every column the backend sent in binary format converted to text
+// for its OID. Text columns are already what PostgreSQL would send and are passed through untouched
+// (an 8-byte text value such as "12345678" must not be read as a binary int8).
+func textRawValues(backendFields []pgconn.FieldDescription, oids []uint32, values [][]byte) [][]byte {
+	textValues := make([][]byte, len(values))
+	for i, raw := range values {
+		if i < len(backendFields) && backendFields[i].Format == pgx.BinaryFormatCode && i < len(oids) {
+			textValues[i] = protocol.RawValueToText(oids[i], raw)
+		} else {
+			textValues[i] = raw
+		}
+	}
+	return textValues
+}
@@ -1,7 +1,17 @@
 package proxy
 
 import (
+	"bytes"
+	"errors"
+	"slices"
 	"testing"
+	"time"
+
+	"pgrollback/pkg/protocol"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 // TestReadyForQueryTxStatus verifies that ReadyForQueryTxStatus returns the correct byte
@@ -37,3 +47,127 @@ func TestReadyForQueryTxStatus(t *testing.T) {
 		t.Errorf("ReadyForQueryTxStatus() with 0 open transactions = %q, want 'I' (idle)", got)
 	}
 }
+
+// TestReadyForQueryTxStatus_FailedTransaction follows TestTransactionHandling_ErrorHandlingAbortedTransaction
+// (integration): BEGIN, a duplicate-key error, ROLLBACK. The ReadyForQuery after the error must be 'E'
+// (psql shows "!"), and ROLLBACK must bring the connection back to idle.
+func TestReadyForQueryTxStatus_FailedTransaction(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+
+	// An error outside a transaction block leaves the connection idle.
+	p.SendErrorResponse(errors.New("relation does not exist"))
+	if got := p.ReadyForQueryTxStatus(); got != 'I' {
+		t.Errorf("after error outside a transaction = %q, want 'I'", got)
+	}
+
+	out.Reset()
+	p.IncrementUserOpenTransactionCount() // BEGIN
+	p.SendErrorResponse(&pgconn.PgError{Severity: "ERROR", Code: "23505", Message: "duplicate key value violates unique constraint"})
+	frontend := pgproto3.NewFrontend(&out, nil)
+	if msg, err := frontend.Receive(); err != nil {
+		t.Fatalf("receive: %v", err)
+	} else if _, ok := msg.(*pgproto3.ErrorResponse); !ok {
+		t.Fatalf("first message = %#v, want ErrorResponse", msg)
+	}
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive: %v", err)
+	}
+	if rfq, ok := msg.(*pgproto3.ReadyForQuery); !ok || rfq.TxStatus != 'E' {
+		t.Errorf("ReadyForQuery after error in transaction = %#v, want TxStatus 'E'", msg)
+	}
+
+	_ = p.DecrementUserOpenTransactionCount() // ROLLBACK
+	if got := p.ReadyForQueryTxStatus(); got != 'I' {
+		t.Errorf("after ROLLBACK = %q, want 'I'", got)
+	}
+}
+
+// TestReadyForQueryTxStatus_FailedNestedLevel asserts a failure in a nested BEGIN only fails that level:
+// rolling it back returns to the still-healthy outer transaction ('T').
+func TestReadyForQueryTxStatus_FailedNestedLevel(t *testing.T) {
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.IncrementUserOpenTransactionCount()
+	p.IncrementUserOpenTransactionCount()
+	p.sendExtendedQueryErr(errors.New("division by zero"))
+	if got := p.ReadyForQueryTxStatus(); got != 'E' {
+		t.Errorf("after extended-query error in nested transaction = %q, want 'E'", got)
+	}
+	_ = p.DecrementUserOpenTransactionCount()
+	if got := p.ReadyForQueryTxStatus(); got != 'T' {
+		t.Errorf("after rolling back the failed level = %q, want 'T'", got)
+	}
+}
+
+// TestTextRawValues_ConvertsOnlyBinaryColumns checks the RETURNING path: a binary int8 becomes its
+// decimal text, while text values (even 8 bytes long) and NULLs pass through unchanged.
+func TestTextRawValues_ConvertsOnlyBinaryColumns(t *testing.T) {
+	oids := []uint32{20, 20, 20}
+	values := [][]byte{{0, 0, 0, 0, 0, 0, 0x30, 0x39}, []byte("12345678"), nil}
+
+	got := textRawValues([]pgconn.FieldDescription{{Format: 1}, {Format: 0}, {Format: 1}}, oids, values)
+	want := [][]byte{[]byte("12345"), []byte("12345678"), nil}
+	for i := range want {
+		if !bytes.Equal(got[i], want[i]) || (got[i] == nil) != (want[i] == nil) {
+			t.Errorf("column %d = %q, want %q", i, got[i], want[i])
+		}
+	}
+
+	// No backend descriptions (pgx before Next): nothing is known to be binary.
+	if got := textRawValues(nil, oids, values); !bytes.Equal(got[1], []byte("12345678")) || !bytes.Equal(got[0], values[0]) {
+		t.Errorf("without field descriptions values must pass through, got %q", got)
+	}
+}
+
+// TestUseBackendTypes_ReplacesGuessedReturningTypes asserts a RETURNING description announces the
+// backend's types (uuid, timestamptz) instead of text, keeping the guess for untyped columns.
+func TestUseBackendTypes_ReplacesGuessedReturningTypes(t *testing.T) {
+	oids := []uint32{20, 25, 25}
+	fields := protocol.FieldDescriptionsFromNamesAndOIDs([]string{"id", "uid", "created_at"}, oids)
+	useBackendTypes(fields, oids, []pgconn.FieldDescription{
+		{DataTypeOID: 23, DataTypeSize: 4},
+		{DataTypeOID: 2950, DataTypeSize: 16},
+		{DataTypeOID: 0},
+	})
+	if want := []uint32{23, 2950, 25}; !slices.Equal(oids, want) {
+		t.Errorf("oids = %v, want %v", oids, want)
+	}
+	if fields[1].DataTypeOID != 2950 || fields[1].DataTypeSize != 16 || fields[1].Format != 0 || string(fields[1].Name) != "uid" {
+		t.Errorf("uid field = %+v, want text-format uuid named uid", fields[1])
+	}
+
+	// pgx before the first row may describe no columns: the guesses stay.
+	oids = []uint32{20}
+	fields = protocol.FieldDescriptionsFromNamesAndOIDs([]string{"id"}, oids)
+	useBackendTypes(fields, oids, nil)
+	if oids[0] != 20 || fields[0].DataTypeOID != 20 {
+		t.Errorf("without backend description oid = %d, want 20", oids[0])
+	}
+}
+
+// TestTextRawValues_TypedReturningColumns asserts binary uuid and timestamptz values are sent as the
+// text PostgreSQL would have produced, now that RETURNING columns carry their real OIDs.
+func TestTextRawValues_TypedReturningColumns(t *testing.T) {
+	m := pgtype.NewMap()
+	var u pgtype.UUID
+	if err := u.Scan("6ba7b810-9dad-11d1-80b4-00c04fd430c8"); err != nil {
+		t.Fatal(err)
+	}
+	uid, err := m.Encode(pgtype.UUIDOID, pgtype.BinaryFormatCode, u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, err := m.Encode(pgtype.TimestamptzOID, pgtype.BinaryFormatCode, time.Date(2024, 5, 6, 7, 8, 9, 120000000, time.UTC), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := textRawValues([]pgconn.FieldDescription{{Format: 1}, {Format: 1}}, []uint32{pgtype.UUIDOID, pgtype.TimestamptzOID}, [][]byte{uid, ts})
+	if string(got[0]) != "6ba7b810-9dad-11d1-80b4-00c04fd430c8" {
+		t.Errorf("uuid = %q", got[0])
+	}
+	if string(got[1]) != "2024-05-06 07:08:09.12+00" {
+		t.Errorf("timestamptz = %q, want 2024-05-06 07:08:09.12+00", got[1])
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"sync"
+
+	sqlpkg "pgrollback/pkg/sql"
+)
+
+// Schema objects created and dropped by a session ("pgrollback status", GUI).
+//
+// Every CREATE or DROP of a table, sequence, view, materialized view, index or schema that succeeds on a
+// session is journaled with the savepoint level it ran at, so the list follows the user's transactions: a
+// ROLLBACK of a user BEGIN discards what ran inside it, a COMMIT hands it to the enclosing level, and a new
+// base transaction ("pgrollback rollback", persist, reconnect) or the session's destroy clears it. What is
+// left is what a full rollback of the session will discard (created) or bring back (dropped). The journal
+// is in memory only and follows the statements as written; IF NOT EXISTS, OR REPLACE and IF EXISTS forms are
+// left out, since they may not have changed anything (see sqlpkg.SchemaObjectChanges).
+
+// schemaChange is one journaled CREATE or DROP.
+type schemaChange struct {
+	object  sqlpkg.SchemaObject
+	dropped bool
+	level   int // savepoint level the statement ran at
+}
+
+// schemaChangeJournal is the session's list of schema changes, oldest first. Own mutex so it can be
+// updated with or without d.mu (see realSessionDB).
+type schemaChangeJournal struct {
+	mu      sync.Mutex
+	changes []schemaChange
+}
+
+// recordSchemaChanges journals the CREATEs and DROPs of query, which ran successfully at the current
+// savepoint level.
+func (d *realSessionDB) recordSchemaChanges(query string) {
+	stmts, err := sqlpkg.ParseStatements(query)
+	if err != nil {
+		return
+	}
+	level := d.GetSavepointLevel()
+	j := &d.schemaChanges
+	for _, raw := range stmts {
+		created, dropped := sqlpkg.SchemaObjectChanges(raw.Stmt)
+		if len(created) == 0 && len(dropped) == 0 {
+			continue
+		}
+		j.mu.Lock()
+		for _, o := range created {
+			j.changes = append(j.changes, schemaChange{object: o, level: level})
+		}
+		for _, o := range dropped {
+			j.changes = append(j.changes, schemaChange{object: o, dropped: true, level: level})
+		}
+		j.mu.Unlock()
+	}
+}
+
+// discardSchemaChangesFrom forgets the changes made at savepoint level or above, after a ROLLBACK TO the
+// savepoint of that level.
+func (d *realSessionDB) discardSchemaChangesFrom(level int) {
+	j := &d.schemaChanges
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	kept := j.changes[:0]
+	for _, c := range j.changes {
+		if c.level < level {
+			kept = append(kept, c)
+		}
+	}
+	j.changes = kept
+}
+
+// mergeSchemaChangesInto moves the changes made above level to level, after the savepoints above it
+// were released (COMMIT of a user BEGIN).
+func (d *realSessionDB) mergeSchemaChangesInto(level int) {
+	j := &d.schemaChanges
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for i := range j.changes {
+		j.changes[i].level = min(j.changes[i].level, level)
+	}
+}
+
+// clearSchemaChanges empties the journal when the base transaction ends.
+func (d *realSessionDB) clearSchemaChanges() {
+	j := &d.schemaChanges
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.changes = nil
+}
+
+// schemaObjectNames renders objects as "table public.t", "index t_idx", ...
+func schemaObjectNames(objects []sqlpkg.SchemaObject) []string {
+	names := make([]string, len(objects))
+	for i, o := range objects {
+		names[i] = o.String()
+	}
+	return names
+}
+
+// SchemaObjects returns the objects the session created and those it dropped that existed before it,
+// in the order of their first change. An object created and then dropped appears in neither list.
+func (d *realSessionDB) SchemaObjects() (created, dropped []sqlpkg.SchemaObject) {
+	j := &d.schemaChanges
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	type key struct{ kind, name string }
+	keyOf := func(o sqlpkg.SchemaObject) key { return key{o.Kind, o.Name} }
+	createdAt := make(map[key]int)
+	droppedAt := make(map[key]int)
+	for _, c := range j.changes {
+		k := keyOf(c.object)
+		if !c.dropped {
+			if _, ok := createdAt[k]; !ok {
+				createdAt[k] = len(created)
+				created = append(created, c.object)
+			}
+			continue
+		}
+		if i, ok := createdAt[k]; ok {
+			// Dropping an object the session created: it was never there before the session.
+			created = append(created[:i], created[i+1:]...)
+			delete(createdAt, k)
+			for other, at := range createdAt {
+				if at > i {
+					createdAt[other] = at - 1
+				}
+			}
+			continue
+		}
+		if _, ok := droppedAt[k]; !ok {
+			droppedAt[k] = len(dropped)
+			dropped = append(dropped, c.object)
+		}
+	}
+	return created, dropped
+}
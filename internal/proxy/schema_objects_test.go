@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSchemaObjects_FollowUserTransactions runs DDL inside and outside user transactions the way
+// ForwardCommandToDB does and checks the created/dropped lists after each step.
+func TestSchemaObjects_FollowUserTransactions(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	session, _ := pgr.newFakeTestSession("ddl")
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: pgr}
+	run := func(query string) {
+		t.Helper()
+		intercepted, err := pgr.InterceptQuery("ddl", query, p.connectionID())
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if strings.Contains(strings.ToUpper(intercepted), "SAVEPOINT") {
+			if session.DB.IsUserBeginQuery(intercepted) {
+				if err := session.DB.ClaimOpenTransaction(p.connectionID(), ""); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := session.DB.SafeExecTCL(session.Context(), intercepted); err != nil {
+				t.Fatalf("%s: %v", query, err)
+			}
+			if err := p.ApplyTCLSuccessTracking(intercepted, session); err != nil {
+				t.Fatal(err)
+			}
+			return
+		}
+		if _, err := session.DB.SafeExec(session.Context(), intercepted); err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		session.DB.recordSchemaChanges(intercepted)
+	}
+	check := func(step string, wantCreated, wantDropped []string) {
+		t.Helper()
+		created, dropped := session.DB.SchemaObjects()
+		if got := schemaObjectNames(created); !reflect.DeepEqual(got, wantCreated) {
+			t.Errorf("after %s: created = %v, want %v", step, got, wantCreated)
+		}
+		if got := schemaObjectNames(dropped); !reflect.DeepEqual(got, wantDropped) {
+			t.Errorf("after %s: dropped = %v, want %v", step, got, wantDropped)
+		}
+	}
+
+	run("CREATE TABLE keep (id int)")
+	check("CREATE TABLE", []string{"table keep"}, []string{})
+
+	run("BEGIN")
+	run("CREATE TEMP TABLE scratch (id int); DROP VIEW legacy")
+	check("DDL inside BEGIN", []string{"table keep", "temporary table scratch"}, []string{"view legacy"})
+	run("ROLLBACK")
+	check("ROLLBACK", []string{"table keep"}, []string{})
+
+	run("BEGIN")
+	run("CREATE SEQUENCE public.ids")
+	run("COMMIT")
+	check("COMMIT", []string{"table keep", "sequence public.ids"}, []string{})
+
+	run("DROP SEQUENCE public.ids")
+	run("DROP MATERIALIZED VIEW report")
+	check("DROP", []string{"table keep"}, []string{"materialized view report"})
+
+	query, err := session.DB.buildStatusResultSet(time.Now(), "ddl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(query, "ARRAY['table keep']::text[] AS created_objects, ARRAY['materialized view report']::text[] AS dropped_objects") {
+		t.Errorf("status query = %s, want the created and dropped objects", query)
+	}
+
+	if err := session.DB.close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	check("close", []string{}, []string{})
+}
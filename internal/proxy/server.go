@@ -2,6 +2,9 @@ package proxy
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
 	"encoding/binary"
 	"errors"
 	"fmt"
@@ -11,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"pgrollback/pkg/protocol"
@@ -19,10 +23,13 @@ import (
 )
 
 const (
-	// ConnectionTimeout é o timeout para operações de leitura/escrita nas conexões
+	// ConnectionTimeout limita o handshake (SSLRequest, StartupMessage, senha) de uma conexão cliente;
+	// depois dele valem proxy.read_timeout e proxy.idle_timeout (ver client_conn.go).
 	ConnectionTimeout = 3600 * time.Second
 	// DefaultSessionTimeout é o timeout padrão para sessões se não especificado
 	DefaultSessionTimeout = 24 * time.Hour
+	// DefaultAdvisoryLockTimeout é a espera máxima pelo advisory lock do test_id se não especificada
+	DefaultAdvisoryLockTimeout = 30 * time.Second
 	// DefaultListenPort é a porta padrão de escuta se não especificada
 	DefaultListenPort = 5433
 	// portCheckTimeout é o timeout para verificar se uma porta está em uso
@@ -45,6 +52,54 @@ type Server struct {
 	activeConns map[net.Conn]struct{}
 	// GUI on same port; non-nil when NewServer(..., withGUI=true). Owns inject listener + HTTP server.
 	gui *samePortGUIServer
+	// tlsConfig habilita TLS no SSLRequest; nil = responde 'N' (ver WithTLSConfig).
+	tlsConfig *tls.Config
+	// maxPreparedStatements limita statements nomeados por conexão (LRU); 0 = DefaultMaxPreparedStatements.
+	maxPreparedStatements int
+	// maxMessageSize limita o tamanho de cada mensagem do cliente (ver message_size.go); 0 = DefaultMaxMessageSize.
+	maxMessageSize int
+	// authMethod é a autenticação simulada pedida ao cliente (AuthMethodPassword ou AuthMethodMD5; "" = password).
+	authMethod string
+	// cancelKeys liga o BackendKeyData de cada conexão cliente à conexão, para CancelRequest (ver cancel.go).
+	cancelKeys cancelKeyRegistry
+	// lockWaitTimeout liga o watchdog de espera por lock entre sessões; 0 = desligado (ver WithLockWaitTimeout).
+	lockWaitTimeout time.Duration
+	// readTimeout e idleTimeout são os limites de leitura do cliente no message loop; 0 = sem limite.
+	readTimeout time.Duration
+	idleTimeout time.Duration
+	// stopLockWatchdog para o watchdog iniciado por NewServer; nil quando não está rodando (mu).
+	stopLockWatchdog func()
+	// draining é ligado por Shutdown: conexões novas no handshake também são drenadas (mu).
+	draining bool
+	// connLimit conta as conexões cliente e aplica proxy.max_connections (ver conn_limit.go).
+	connLimit connLimiter
+	// listenSocketDir habilita o socket Unix <dir>/.s.PGSQL.<porta> além do TCP; "" = só TCP (ver unix_socket.go).
+	listenSocketDir string
+	socketListener  net.Listener // nil sem listenSocketDir ou depois de Stop (mu)
+	socketPath      string       // caminho do socket criado (mu)
+
+	// captureDir grava o protocolo das conexões cliente em arquivos; "" = desligado. captureTestID
+	// restringe a um test ID; "" = todos (ver capture.go).
+	captureDir    string
+	captureTestID string
+
+	// startedAt é quando NewServer criou o servidor (uptime de GET /api/server); queriesProcessed conta
+	// as mensagens Query e Execute recebidas de clientes (ver server_status.go).
+	startedAt        time.Time
+	queriesProcessed atomic.Uint64
+
+	// concurrentConnectionsNotice: acima desse número de clientes conectados numa sessão, o que passou
+	// do limite recebe um WARNING (uma vez por sessão); 0 = desligado (ver concurrent_clients.go).
+	concurrentConnectionsNotice int
+
+	// historyLabel é o template do rótulo das queries de cada conexão no histórico; "" = sem rótulo.
+	// connSeq numera as conexões cliente para o campo {conn} (ver history_label.go).
+	historyLabel string
+	connSeq      atomic.Uint64
+
+	// defaultTestID é o template do test ID das conexões sem application_name; "" = sessão "default"
+	// (ver default_test_id.go).
+	defaultTestID string
 }
 
 // ListenHost returns the host the server is bound to (e.g. "127.0.0.1").
@@ -109,17 +164,23 @@ func isPortInUse(host string, port int) bool {
 //
 // Se proxyListenHost estiver vazio, usa "localhost". Se sessionTimeout for 0, usa DefaultSessionTimeout.
 // Se houver erro ao iniciar, o erro é armazenado no Server e pode ser verificado com StartError()
-func NewServer(postgresHost string, postgresPort int, postgresDB, postgresUser, postgresPass string, timeout time.Duration, sessionTimeout time.Duration, keepaliveInterval time.Duration, proxyListenHost string, proxyListenPort int, withGUI bool) *Server {
+// Opções adicionais (TLS etc.) são passadas via opts e aplicadas antes do bind.
+func NewServer(postgresHost string, postgresPort int, postgresDB, postgresUser, postgresPass string, timeout time.Duration, sessionTimeout time.Duration, keepaliveInterval time.Duration, proxyListenHost string, proxyListenPort int, withGUI bool, opts ...ServerOption) *Server {
 	if sessionTimeout <= 0 {
 		sessionTimeout = DefaultSessionTimeout
 	}
 
 	pgrollback := NewPgRollback(postgresHost, postgresPort, postgresDB, postgresUser, postgresPass, timeout, sessionTimeout, keepaliveInterval)
 	server := &Server{
-		PgRollback:  pgrollback,
-		listenHost:  proxyListenHost,
-		listenPort:  proxyListenPort,
-		activeConns: make(map[net.Conn]struct{}),
+		PgRollback:   pgrollback,
+		listenHost:   proxyListenHost,
+		listenPort:   proxyListenPort,
+		activeConns:  make(map[net.Conn]struct{}),
+		startedAt:    time.Now(),
+		historyLabel: DefaultQueryHistoryLabel,
+	}
+	for _, opt := range opts {
+		opt(server)
 	}
 
 	if err := server.bindAndListenTCP(proxyListenPort); err != nil {
@@ -128,12 +189,30 @@ func NewServer(postgresHost string, postgresPort int, postgresDB, postgresUser,
 		server.mu.Unlock()
 		return server
 	}
+	if server.listenSocketDir != "" {
+		if err := server.bindUnixSocket(); err != nil {
+			_ = server.listener.Close()
+			server.mu.Lock()
+			server.listener = nil
+			server.startErr = err
+			server.mu.Unlock()
+			return server
+		}
+	}
 
 	if withGUI {
 		server.gui = newSamePortGUIServer(server)
 	}
+	if server.lockWaitTimeout > 0 {
+		server.stopLockWatchdog = newLockWatchdog(pgrollback, server.lockWaitTimeout).start()
+	}
+	pgrollback.startWarmPool()
 
-	go server.acceptConnections()
+	go server.acceptConnections(server.listener)
+	if server.socketListener != nil {
+		go server.acceptConnections(server.socketListener)
+		logIfVerbose("PgRollback server listening on unix socket %s", server.SocketPath())
+	}
 
 	logIfVerbose("PgRollback server listening on %s:%d", server.ListenHost(), server.ListenPort())
 	return server
@@ -175,9 +254,11 @@ func (s *Server) waitUntilListening(host string, port int) bool {
 // - Alterações persistem entre reconexões do mesmo application_name
 // - Controle total sobre quando fazer rollback (via comandos pgrollback especiais)
 // - Isolamento entre diferentes testIDs (cada um tem sua própria transação)
-func (s *Server) acceptConnections() {
+//
+// Roda uma vez por listener (TCP e, com proxy.listen_socket, o socket Unix); os dois são fechados juntos.
+func (s *Server) acceptConnections(listener net.Listener) {
 	for {
-		conn, err := s.listener.Accept()
+		conn, err := listener.Accept()
 		if err != nil {
 			s.mu.Lock()
 			if s.listener == nil {
@@ -228,40 +309,127 @@ func (s *Server) acceptConnections() {
 	}
 }
 
+// Stop closes the listener and every client connection at once, aborting statements in flight, and
+// waits for the connection handlers to return. See Shutdown for a graceful variant.
 func (s *Server) Stop() error {
+	conns, stopWatchdog, err := s.stopAccepting()
+	if stopWatchdog != nil {
+		defer stopWatchdog()
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range conns {
+		_ = c.Close()
+	}
+	s.wg.Wait()
+	return nil
+}
+
+// Shutdown stops accepting connections and drains the open ones: an idle client (after ReadyForQuery)
+// is disconnected at once, a busy one when its current statement, or extended-query pipeline up to
+// Sync, has finished. When ctx ends first the remaining connections are closed as Stop does and
+// ctx.Err() is returned, after their handlers have returned.
+func (s *Server) Shutdown(ctx context.Context) error {
 	s.mu.Lock()
-	if s.listener != nil {
-		listener := s.listener
-		s.listener = nil
-		// Copy active connections so we can close them without holding mu (closing unblocks handlers)
-		conns := make([]net.Conn, 0, len(s.activeConns))
-		for c := range s.activeConns {
-			conns = append(conns, c)
-		}
+	s.draining = true
+	s.mu.Unlock()
+	conns, stopWatchdog, err := s.stopAccepting()
+	if stopWatchdog != nil {
+		defer stopWatchdog()
+	}
+	if err != nil {
+		return err
+	}
+	for _, c := range conns {
+		drainConn(c)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+	s.mu.Lock()
+	remaining := make([]net.Conn, 0, len(s.activeConns))
+	for c := range s.activeConns {
+		remaining = append(remaining, c)
+	}
+	s.mu.Unlock()
+	for _, c := range remaining {
+		_ = c.Close()
+	}
+	<-done
+	return ctx.Err()
+}
+
+// stopAccepting closes the listener, the GUI, the connection limiter queue and the warm pool and returns the client
+// connections open at that moment, plus the lock watchdog's stop function for the caller to run once
+// the connections are gone. Calling it again returns no connections.
+func (s *Server) stopAccepting() (conns []net.Conn, stopWatchdog func(), err error) {
+	s.connLimit.stop()
+	if s.PgRollback != nil {
+		s.PgRollback.warmPool.close()
+	}
+	s.mu.Lock()
+	stopWatchdog = s.stopLockWatchdog
+	s.stopLockWatchdog = nil
+	if s.listener == nil {
 		s.mu.Unlock()
-		if err := listener.Close(); err != nil {
-			return err
-		}
-		if s.gui != nil {
-			s.gui.shutdown()
-		}
-		for _, c := range conns {
-			_ = c.Close()
+		return nil, stopWatchdog, nil
+	}
+	listener := s.listener
+	s.listener = nil
+	socketListener := s.socketListener
+	s.socketListener = nil
+	s.socketPath = ""
+	// Copy active connections so we can close them without holding mu (closing unblocks handlers)
+	conns = make([]net.Conn, 0, len(s.activeConns))
+	for c := range s.activeConns {
+		conns = append(conns, c)
+	}
+	s.mu.Unlock()
+	if socketListener != nil {
+		// Closing a listener created by net.Listen("unix", …) also removes the socket file.
+		if err := socketListener.Close(); err != nil {
+			log.Printf("[PROXY] Failed to close unix socket listener: %v", err)
 		}
-	} else {
-		s.mu.Unlock()
 	}
-	s.wg.Wait()
-	return nil
+	if err := listener.Close(); err != nil {
+		return nil, stopWatchdog, err
+	}
+	if s.gui != nil {
+		s.gui.shutdown()
+	}
+	return conns, stopWatchdog, nil
+}
+
+// drainConn asks a client connection to end at its next idle point (see watchedClientConn.drain).
+// Connections without the wrapper have no idle tracking and are closed.
+func drainConn(c net.Conn) {
+	if w := watchedClientConnOf(c); w != nil {
+		w.drain()
+		return
+	}
+	_ = c.Close()
 }
 
 // addActiveConn records a client connection so Stop() can close it to unblock handlers.
+// While Shutdown is draining, a connection still finishing its handshake is told to end once idle.
 func (s *Server) addActiveConn(c net.Conn) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 	if s.activeConns != nil {
 		s.activeConns[c] = struct{}{}
 	}
+	if s.draining {
+		drainConn(c)
+	}
 }
 
 // removeActiveConn removes a client connection from the active set (e.g. when handler returns).
@@ -271,6 +439,40 @@ func (s *Server) removeActiveConn(c net.Conn) {
 	delete(s.activeConns, c)
 }
 
+// Ready reports whether the proxy can serve clients: it is listening (StartError is nil) and the real
+// PostgreSQL accepts a connection with the configured credentials (see PgRollback.PingBackend).
+func (s *Server) Ready(ctx context.Context) error {
+	if err := s.StartError(); err != nil {
+		return fmt.Errorf("proxy not listening: %w", err)
+	}
+	return s.PgRollback.PingBackend(ctx)
+}
+
+// WaitForDisconnectCleanup blocks until the session of testID has at most remaining client connections,
+// counting a closed connection until its disconnect cleanup (statement deallocation, savepoint rollback)
+// has finished. A client's Close returns before the proxy even reads the EOF, so tests that check the
+// backend after closing one connection call this first. Returns nil at once when there is no session.
+func (s *Server) WaitForDisconnectCleanup(ctx context.Context, testID string, remaining int) error {
+	for {
+		session := s.PgRollback.GetSession(testID)
+		if session == nil {
+			return nil
+		}
+		session.mu.Lock()
+		if session.teardown.pendingClientsLocked() <= remaining {
+			session.mu.Unlock()
+			return nil
+		}
+		changed := session.teardown.clientsChangedChanLocked()
+		session.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
 // StartError retorna o erro de inicialização, se houver
 func (s *Server) StartError() error {
 	s.mu.RLock()
@@ -280,6 +482,7 @@ func (s *Server) StartError() error {
 
 func (s *Server) handleConnection(clientConn net.Conn) {
 	defer s.wg.Done()
+	clientConn = newWatchedClientConn(clientConn, s.readTimeout, s.idleTimeout)
 	defer clientConn.Close()
 	s.addActiveConn(clientConn)
 	defer s.removeActiveConn(clientConn)
@@ -292,6 +495,12 @@ func (s *Server) handleConnection(clientConn net.Conn) {
 
 	clientConn.SetDeadline(time.Now().Add(ConnectionTimeout))
 
+	s.handleInitialFrame(clientConn, false)
+}
+
+// handleInitialFrame reads the first frame of a connection (or the one after a declined GSSENCRequest)
+// and routes it: SSLRequest/GSSENCRequest, CancelRequest or StartupMessage.
+func (s *Server) handleInitialFrame(clientConn net.Conn, gssDeclined bool) {
 	length, err := ReadFrontendMessageLength(clientConn)
 	if err != nil {
 		if !errors.Is(err, io.EOF) {
@@ -300,15 +509,19 @@ func (s *Server) handleConnection(clientConn net.Conn) {
 		return
 	}
 
-	if !IsSSLRequestLength(length) {
+	switch {
+	case IsSSLRequestLength(length):
+		s.handleEightByteSpecialFrame(clientConn, length, gssDeclined)
+	case IsCancelRequestLength(length):
+		s.handleCancelRequestFrame(clientConn, length)
+	default:
 		s.resumeStartupMessageAfterLengthPrefix(clientConn, length)
-		return
 	}
-	s.handleEightByteSpecialFrame(clientConn, length)
 }
 
 // handleEightByteSpecialFrame runs after we read length==8 and must read the following request code.
-func (s *Server) handleEightByteSpecialFrame(clientConn net.Conn, length int32) {
+// A GSSENCRequest is declined once, as PostgreSQL without GSSAPI does; a repeated one is not special.
+func (s *Server) handleEightByteSpecialFrame(clientConn net.Conn, length int32, gssDeclined bool) {
 	code, err := ReadSpecialRequestCode(clientConn)
 	if err != nil {
 		log.Printf("Error reading special request code: %v", err)
@@ -316,6 +529,14 @@ func (s *Server) handleEightByteSpecialFrame(clientConn net.Conn, length int32)
 	}
 
 	switch {
+	case IsPostgresGSSENCRequestCode(code) && !gssDeclined:
+		if err := WriteGSSENCDeclined(clientConn); err != nil {
+			log.Printf("Error writing GSSENC response: %v", err)
+			return
+		}
+		s.handleInitialFrame(clientConn, true)
+	case IsPostgresSSLRequestCode(code) && s.tlsConfig != nil:
+		s.replySSLAcceptedThenStartup(clientConn)
 	case IsPostgresSSLRequestCode(code):
 		s.replySSLNotSupportedThenStartup(clientConn)
 	default:
@@ -325,8 +546,9 @@ func (s *Server) handleEightByteSpecialFrame(clientConn net.Conn, length int32)
 
 // resumeStartupMessageAfterLengthPrefix re-injects the 4-byte StartupMessage length we already read.
 func (s *Server) resumeStartupMessageAfterLengthPrefix(clientConn net.Conn, length int32) {
-	backend := s.createBackendWithPreRead(clientConn, 4, length, 0)
-	s.processConnectionStartupMessage(backend, clientConn)
+	clientConn = s.wrapForCapture(clientConn)
+	backend, startup := s.createBackendWithPreRead(clientConn, 4, length, 0)
+	s.processConnectionStartupMessage(backend, startup, clientConn)
 }
 
 // replySSLNotSupportedThenStartup implements the PostgreSQL SSL negotiation: respond with 'N' (SSL not available),
@@ -336,25 +558,27 @@ func (s *Server) replySSLNotSupportedThenStartup(clientConn net.Conn) {
 		log.Printf("Error writing SSL response: %v", err)
 		return
 	}
-	backend := pgproto3.NewBackend(clientConn, clientConn)
-	s.processConnectionStartupMessage(backend, clientConn)
+	clientConn = s.wrapForCapture(clientConn)
+	backend, startup := s.newClientBackend(clientConn, clientConn)
+	s.processConnectionStartupMessage(backend, startup, clientConn)
 }
 
 // processStartupWithReplayedSpecialFrame replays an 8-byte special request (length+code) then runs startup.
 func (s *Server) processStartupWithReplayedSpecialFrame(clientConn net.Conn, length int32, code int32) {
-	backend := s.createBackendWithPreRead(clientConn, 8, length, code)
-	s.processConnectionStartupMessage(backend, clientConn)
+	clientConn = s.wrapForCapture(clientConn)
+	backend, startup := s.createBackendWithPreRead(clientConn, 8, length, code)
+	s.processConnectionStartupMessage(backend, startup, clientConn)
 }
 
 // createBackendWithPreRead cria um backend reconstruindo bytes já lidos
-func (s *Server) createBackendWithPreRead(clientConn net.Conn, dataSize int, length int32, code int32) *pgproto3.Backend {
+func (s *Server) createBackendWithPreRead(clientConn net.Conn, dataSize int, length int32, code int32) (*pgproto3.Backend, *startupVersionReader) {
 	preReadData := make([]byte, dataSize)
 	binary.BigEndian.PutUint32(preReadData[0:4], uint32(length))
 	if dataSize == 8 {
 		binary.BigEndian.PutUint32(preReadData[4:8], uint32(code))
 	}
 	multiReader := io.MultiReader(bytes.NewReader(preReadData), clientConn)
-	return pgproto3.NewBackend(multiReader, clientConn)
+	return s.newClientBackend(multiReader, clientConn)
 }
 
 // isConnClosedBeforePasswordErr reports common client-side teardown while waiting for PasswordMessage.
@@ -378,10 +602,14 @@ func isConnClosedBeforePasswordErr(err error) bool {
 //
 // Fluxo de Autenticação:
 // 1. Recebe StartupMessage do cliente (contém application_name e outros parâmetros)
+//   - Versão 3.x (x > 0) ou opções _pq_.*: responde NegotiateProtocolVersion e segue em 3.0
+//
 // 2. Extrai o testID do application_name (via protocol.ParseApplicationIdentity)
+//   - Sem application_name: sessão "default", ou o template de proxy.default_test_id
+//
 // 3. Simula autenticação PostgreSQL para o cliente:
-//   - Solicita senha (AuthenticationCleartextPassword)
-//   - Recebe senha do cliente
+//   - Solicita senha (AuthenticationCleartextPassword, ou AuthenticationMD5Password com proxy.auth_method: md5)
+//   - Recebe senha do cliente (não é verificada)
 //   - Responde AuthenticationOK
 //
 // 4. Obtém ou cria sessão para o testID:
@@ -393,30 +621,54 @@ func isConnClosedBeforePasswordErr(err error) bool {
 // IMPORTANTE: O cliente sempre passa por autenticação completa, mesmo quando
 // reutilizamos uma conexão PostgreSQL existente. Isso garante que o cliente
 // não percebe diferença entre uma conexão nova e uma reutilizada.
-func (s *Server) processConnectionStartupMessage(backend *pgproto3.Backend, clientConn net.Conn) {
+func (s *Server) processConnectionStartupMessage(backend *pgproto3.Backend, startup *startupVersionReader, clientConn net.Conn) {
 	clientConn.SetDeadline(time.Now().Add(ConnectionTimeout))
 
 	params, err := getConnectionStartupParameters(backend)
 	if err != nil {
+		rejectOversizedMessage(backend, err)
+		return
+	}
+	// Protocol 3.2 / _pq_.* options: answer with what the proxy speaks (see protocol_negotiation.go).
+	if err := negotiateProtocolVersion(clientConn, startup.requestedMinor, params); err != nil {
+		log.Printf("Error writing NegotiateProtocolVersion: %v", err)
 		return
 	}
 	// testID + nome para log a partir de application_name (ver protocol.ParseApplicationIdentity)
 	testID, appName := protocol.ParseApplicationIdentity(params)
+	if id := expandDefaultTestID(s.defaultTestID, params, clientConn.RemoteAddr()); id != "" {
+		testID = id
+	}
+	if cc := captureConnOf(clientConn); cc != nil {
+		cc.startup = params
+	}
 
 	// Log para identificar qual teste/código está fazendo a conexão
 	remoteAddr := clientConn.RemoteAddr().String()
 	logIfVerbose("[SERVER] Conexão estabelecida - testID=%s, application_name=%s, origem=%s", testID, appName, remoteAddr)
 
+	// proxy.max_connections: espera por uma vaga (connection_wait_timeout) ou recusa com 53300
+	if !s.connLimit.acquire() {
+		log.Printf("[SERVER] Conexão recusada (proxy.max_connections=%d) - testID=%s, origem=%s", s.connLimit.max, testID, remoteAddr)
+		sendTooManyConnections(backend)
+		return
+	}
+	defer s.connLimit.release()
+
 	// Simula autenticação PostgreSQL: sempre solicita senha do cliente
 	// Isso garante que o cliente sempre passa pelo mesmo fluxo, independente
 	// de estarmos reutilizando uma conexão PostgreSQL ou criando nova
-	if err := WriteAuthenticationCleartextPassword(clientConn); err != nil {
+	if err := s.requestClientPassword(backend, clientConn); err != nil {
 		log.Printf("Error writing authentication request: %v", err)
 		return
 	}
 
 	passwordMsg, err := backend.Receive()
 	if err != nil {
+		if rejectOversizedMessage(backend, err) {
+			log.Printf("Rejected password message from %s: %v", remoteAddr, err)
+			return
+		}
 		// Client often closes abandoned or raced TCP connects (e.g. database/sql pool churn); not worth ERROR spam.
 		if isConnClosedBeforePasswordErr(err) {
 			logIfVerbose("client closed connection before password message: %v", err)
@@ -451,13 +703,29 @@ func (s *Server) processConnectionStartupMessage(backend *pgproto3.Backend, clie
 	}
 
 	// Inicia proxy para encaminhar comandos entre cliente e PostgreSQL
-	s.startProxy(testID, clientConn, backend)
+	s.startProxy(testID, clientConn, backend, params)
+}
+
+// requestClientPassword envia o pedido de senha conforme s.authMethod. Com md5 o salt é aleatório;
+// o hash devolvido pelo cliente não é verificado, como a senha em texto claro.
+func (s *Server) requestClientPassword(backend *pgproto3.Backend, clientConn net.Conn) error {
+	if s.authMethod != AuthMethodMD5 {
+		return WriteAuthenticationCleartextPassword(clientConn)
+	}
+	var salt [4]byte
+	if _, err := rand.Read(salt[:]); err != nil {
+		return fmt.Errorf("generate md5 salt: %w", err)
+	}
+	if err := backend.SetAuthType(pgproto3.AuthTypeMD5Password); err != nil {
+		return err
+	}
+	return WriteAuthenticationMD5Password(clientConn, salt)
 }
 
 func getConnectionStartupParameters(backend *pgproto3.Backend) (map[string]string, error) {
 	startupMsg, err := backend.ReceiveStartupMessage()
 	if err != nil && err != io.EOF {
-		return nil, fmt.Errorf("Error receiving startup message from client: %v", err)
+		return nil, fmt.Errorf("Error receiving startup message from client: %w", err)
 	}
 
 	params := make(map[string]string)
@@ -0,0 +1,181 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"time"
+)
+
+// Métodos de autenticação simulada aceitos por WithAuthMethod (proxy.auth_method).
+const (
+	AuthMethodPassword = "password" // AuthenticationCleartextPassword (padrão)
+	AuthMethodMD5      = "md5"      // AuthenticationMD5Password com salt aleatório
+)
+
+// ServerOption configures optional Server behaviour. Options are applied by NewServer before the
+// listener starts accepting connections, so they never race with connection handlers.
+type ServerOption func(*Server)
+
+// WithTLSConfig enables TLS termination: SSLRequest is answered with 'S' and the startup flow continues
+// over a tls.Server connection. A nil config keeps the default behaviour (answer 'N', plaintext only).
+func WithTLSConfig(cfg *tls.Config) ServerOption {
+	return func(s *Server) { s.tlsConfig = cfg }
+}
+
+// WithMaxPreparedStatements caps the named prepared statements kept per client connection; beyond it the
+// least-recently-used statement is deallocated on the backend. n <= 0 keeps DefaultMaxPreparedStatements.
+func WithMaxPreparedStatements(n int) ServerOption {
+	return func(s *Server) { s.maxPreparedStatements = n }
+}
+
+// WithMaxMessageSize caps the size of each message a client sends; a larger one is rejected with a FATAL
+// ErrorResponse and the connection is closed, before its body is buffered. n <= 0 keeps DefaultMaxMessageSize.
+func WithMaxMessageSize(n int) ServerOption {
+	return func(s *Server) { s.maxMessageSize = n }
+}
+
+// WithBeginWaitTimeout makes a BEGIN from a second connection wait up to d for the connection holding the
+// session's open transaction to COMMIT/ROLLBACK, instead of failing at once with SQLSTATE 55006.
+func WithBeginWaitTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.PgRollback.BeginWaitTimeout = d }
+}
+
+// WithSavepointPrefix sets the prefix of the savepoints that stand for user transactions (BEGIN becomes
+// SAVEPOINT <prefix>N). Savepoints the application names itself must not start with it. "" keeps DefaultSavepointPrefix.
+func WithSavepointPrefix(prefix string) ServerOption {
+	return func(s *Server) { s.PgRollback.SavepointPrefix = prefix }
+}
+
+// WithAuthMethod selects the password request sent to clients during startup: AuthMethodPassword (default,
+// cleartext) or AuthMethodMD5 for drivers that only negotiate md5. The password is never verified.
+func WithAuthMethod(method string) ServerOption {
+	return func(s *Server) { s.authMethod = method }
+}
+
+// WithReadConnection gives each session a second, read-only backend connection that serves plain SELECTs
+// from clients started with default_transaction_read_only=on, concurrently with the write connection.
+// Those reads only see committed data (see read_connection.go).
+func WithReadConnection(enabled bool) ServerOption {
+	return func(s *Server) { s.PgRollback.ReadConnection = enabled }
+}
+
+// WithLockWaitTimeout starts a watchdog that cancels a statement of one test session waiting longer than d
+// for a lock held by another test session, reporting 40P01 (deadlock_detected) to that client. The younger
+// transaction of the pair is cancelled when it has a statement running. d <= 0 disables it (default).
+func WithLockWaitTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.lockWaitTimeout = d }
+}
+
+// WithAdvisoryLockTimeout bounds how long ExecuteWithLock waits for the test_id's advisory lock held by
+// another backend before failing with ErrAdvisoryLockTimeout. d <= 0 keeps DefaultAdvisoryLockTimeout.
+func WithAdvisoryLockTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.PgRollback.AdvisoryLockTimeout = d }
+}
+
+// WithReadTimeout bounds each blocking read of a client connection once a message has started to arrive,
+// so a stalled network does not hold the connection forever. d <= 0 disables it (default).
+func WithReadTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.readTimeout = d }
+}
+
+// WithIdleTimeout closes a client connection that sends no message for d; the clock restarts on every
+// message received. d <= 0 disables it (default), so idle psql sessions stay open indefinitely.
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(s *Server) { s.idleTimeout = d }
+}
+
+// WithMaxConnections caps concurrent client connections. A connection over the cap waits up to waitTimeout
+// for another to close, then gets FATAL 53300 (too_many_connections) during startup; waitTimeout <= 0
+// refuses it at once. max <= 0 means unlimited (default).
+func WithMaxConnections(max int, waitTimeout time.Duration) ServerOption {
+	return func(s *Server) {
+		s.connLimit.max = max
+		s.connLimit.waitTimeout = waitTimeout
+	}
+}
+
+// WithListenSocket also listens on the Unix socket <dir>/.s.PGSQL.<listen port>, the path libpq and
+// pdo_pgsql open for host=<dir>. TCP keeps listening. "" disables it (the default).
+func WithListenSocket(dir string) ServerOption {
+	return func(s *Server) { s.listenSocketDir = dir }
+}
+
+// WithIsolateSchema gives each session its own schema, first in its default search_path, so unqualified
+// CREATEs of different test IDs do not collide (see isolation_schema.go). It changes name resolution.
+func WithIsolateSchema(enabled bool) ServerOption {
+	return func(s *Server) { s.PgRollback.IsolateSchema = enabled }
+}
+
+// WithWarmPoolSize keeps size backend connections open ahead of time, so a new session only has to
+// begin its transaction (see warm_pool.go). 0 disables the pool.
+func WithWarmPoolSize(size int) ServerOption {
+	return func(s *Server) { s.PgRollback.WarmPoolSize = size }
+}
+
+// WithProtocolCapture writes the protocol of client connections to files in dir, for "pgrollback replay"
+// (see capture.go). testID limits it to connections of that test ID; "" captures all. dir "" disables it.
+func WithProtocolCapture(dir, testID string) ServerOption {
+	return func(s *Server) {
+		s.captureDir = dir
+		s.captureTestID = testID
+	}
+}
+
+// WithQueryHistorySize sets how many queries each session keeps for the GUI and "pgrollback history".
+// size 0 disables the history, so nothing is recorded; without the option maxQueryHistory is kept.
+func WithQueryHistorySize(size int) ServerOption {
+	return func(s *Server) {
+		if size <= 0 {
+			size = -1
+		}
+		s.PgRollback.QueryHistorySize = size
+	}
+}
+
+// WithBackendRoutes sends the sessions of test IDs matching a route's prefix to its database instead of
+// the default one (see backend_routes.go and ParseBackendRoutes).
+func WithBackendRoutes(routes []BackendRoute) ServerOption {
+	return func(s *Server) { s.PgRollback.Routes = routes }
+}
+
+// WithConnectRetries retries a new session's backend connection and BEGIN up to retries more times,
+// waiting interval (0 = DefaultConnectRetryInterval) before the first retry with jittered, doubling backoff.
+func WithConnectRetries(retries int, interval time.Duration) ServerOption {
+	return func(s *Server) {
+		s.PgRollback.ConnectRetries = retries
+		s.PgRollback.ConnectRetryInterval = interval
+	}
+}
+
+// WithConcurrentConnectionsNotice warns, once per session, the client connection that makes a test ID's
+// connected clients exceed threshold: they share one transaction and do not run in parallel (see
+// concurrent_clients.go). threshold <= 0 disables it (default).
+func WithConcurrentConnectionsNotice(threshold int) ServerOption {
+	return func(s *Server) { s.concurrentConnectionsNotice = threshold }
+}
+
+// WithSessionSetupSQL runs statements at the start of every base transaction of a session, so they are
+// rolled back with the test's work and run again after "pgrollback rollback" (see session_setup.go).
+func WithSessionSetupSQL(statements []string) ServerOption {
+	return func(s *Server) { s.PgRollback.SessionSetupSQL = statements }
+}
+
+// WithStatementPolicy rejects client statements with 42501 before they reach the backend: those denied
+// matches and, when allowed is not empty, those it does not match (see statement_policy.go).
+func WithStatementPolicy(allowed, denied []string) ServerOption {
+	return func(s *Server) {
+		s.PgRollback.AllowedStatements = NormalizeStatementList(allowed)
+		s.PgRollback.DeniedStatements = NormalizeStatementList(denied)
+	}
+}
+
+// WithQueryHistoryLabel sets the template of the label that prefixes each client connection's queries in
+// the query history (see history_label.go); "" stores them unlabeled. Default DefaultQueryHistoryLabel.
+func WithQueryHistoryLabel(template string) ServerOption {
+	return func(s *Server) { s.historyLabel = template }
+}
+
+// WithDefaultTestID sets the test ID template of connections that send no application_name (see
+// default_test_id.go), e.g. "sandbox" or "{database}". "" keeps them in the "default" session.
+func WithDefaultTestID(template string) ServerOption {
+	return func(s *Server) { s.defaultTestID = template }
+}
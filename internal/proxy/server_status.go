@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"net"
+	"runtime/debug"
+	"strconv"
+	"time"
+)
+
+// Version is the build version reported by GET /api/server and "pgrollback status". Set it at build time
+// with -ldflags "-X pgrollback/internal/proxy.Version=v1.2.3"; when empty, the module version or VCS
+// revision recorded by the Go toolchain is used.
+var Version = ""
+
+// ServerStatus is an operational summary of a Server (see Server.Status).
+type ServerStatus struct {
+	Version          string
+	StartedAt        time.Time
+	Uptime           time.Duration
+	ListenAddress    string // proxy.listen_host:listen_port
+	BackendHost      string
+	BackendPort      int
+	BackendDatabase  string
+	BackendVersion   string // server_version of the real PostgreSQL (fallback until a connection reported it)
+	ActiveSessions   int
+	PeakSessions     int // highest ActiveSessions since the server started
+	Connections      ConnectionStats
+	QueriesProcessed uint64 // Simple Query and Execute messages received from clients
+}
+
+// Status returns the server's version, uptime, backend, session and query counters.
+func (s *Server) Status() ServerStatus {
+	active, peak := s.PgRollback.sessionCounts()
+	return ServerStatus{
+		Version:          buildVersion(),
+		StartedAt:        s.startedAt,
+		Uptime:           time.Since(s.startedAt),
+		ListenAddress:    net.JoinHostPort(s.ListenHost(), strconv.Itoa(s.ListenPort())),
+		BackendHost:      s.PgRollback.PostgresHost,
+		BackendPort:      s.PgRollback.PostgresPort,
+		BackendDatabase:  s.PgRollback.PostgresDB,
+		BackendVersion:   s.PgRollback.ServerVersion(),
+		ActiveSessions:   active,
+		PeakSessions:     peak,
+		Connections:      s.ConnectionStats(),
+		QueriesProcessed: s.queriesProcessed.Load(),
+	}
+}
+
+// buildVersion returns Version, else the main module version or VCS revision of the binary, else "dev".
+func buildVersion() string {
+	if Version != "" {
+		return Version
+	}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "dev"
+	}
+	if v := info.Main.Version; v != "" && v != "(devel)" {
+		return v
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if revision == "" {
+		return "dev"
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if modified {
+		revision += "-dirty"
+	}
+	return "dev-" + revision
+}
+
+// sessionCounts returns the number of live sessions and the highest it has been.
+func (p *PgRollback) sessionCounts() (active, peak int) {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return len(p.SessionsByTestID), max(p.peakSessions, len(p.SessionsByTestID))
+}
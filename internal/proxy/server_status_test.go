@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestServerStatus_CountsSessionsAndQueries(t *testing.T) {
+	pgr := NewPgRollback("db.internal", 5433, "app", "u", "p", time.Minute, time.Hour, 0)
+	s := &Server{PgRollback: pgr, listenHost: "127.0.0.1", listenPort: 6432, startedAt: time.Now().Add(-time.Minute)}
+	pgr.newFakeTestSession("a")
+	pgr.newFakeTestSession("b")
+	pgr.mu.Lock()
+	delete(pgr.SessionsByTestID, "b")
+	pgr.mu.Unlock()
+	s.queriesProcessed.Add(3)
+
+	st := s.Status()
+	if st.ActiveSessions != 1 || st.PeakSessions != 2 {
+		t.Errorf("sessions = %d active, %d peak; want 1, 2", st.ActiveSessions, st.PeakSessions)
+	}
+	if st.QueriesProcessed != 3 {
+		t.Errorf("QueriesProcessed = %d, want 3", st.QueriesProcessed)
+	}
+	if st.Uptime < time.Minute {
+		t.Errorf("Uptime = %s, want at least 1m", st.Uptime)
+	}
+	if st.ListenAddress != "127.0.0.1:6432" || st.BackendHost != "db.internal" || st.BackendPort != 5433 || st.BackendDatabase != "app" {
+		t.Errorf("addresses = %+v", st)
+	}
+	if st.Version == "" || st.BackendVersion != fallbackServerVersion {
+		t.Errorf("Version = %q, BackendVersion = %q", st.Version, st.BackendVersion)
+	}
+}
+
+func TestBuildVersion_UsesLinkerVersion(t *testing.T) {
+	defer func(v string) { Version = v }(Version)
+	Version = "v1.2.3"
+	if got := buildVersion(); got != "v1.2.3" {
+		t.Errorf("buildVersion() = %q, want v1.2.3", got)
+	}
+	Version = ""
+	if got := buildVersion(); !strings.HasPrefix(got, "dev") && !strings.HasPrefix(got, "v") {
+		t.Errorf("buildVersion() = %q, want a module version or dev[-revision]", got)
+	}
+}
@@ -5,20 +5,23 @@ import (
 	"errors"
 	"fmt"
 	"hash/fnv"
+	"log"
 	"net"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
 )
 
-// BackendStartupCache holds ParameterStatus and BackendKeyData from the real PostgreSQL
-// so we can replay them to clients when they connect to pgrollback instead of hardcoded values.
+// BackendStartupCache holds the ParameterStatus messages from the real PostgreSQL so we can replay
+// them to clients when they connect to pgrollback instead of hardcoded values. BackendKeyData is
+// not cached: each client connection gets its own (see cancelKeyRegistry).
 type BackendStartupCache struct {
 	ParameterStatuses []pgproto3.ParameterStatus // order preserved for consistent client behavior
-	BackendKeyData    pgproto3.BackendKeyData
 }
 
 // Well-known parameter names that PostgreSQL sends after connection (we copy these from the real server).
@@ -34,12 +37,17 @@ type TestSession struct {
 	CreatedAt           time.Time
 	LastActivity        time.Time
 	DisconnectRequested bool
+	persist             bool // "pgrollback persist on": BEGIN/COMMIT/ROLLBACK act on the base transaction (see persist_mode.go)
+	explain             bool // "pgrollback explain on": queries are answered with their rewrite instead of run (see explain.go)
 	ctx                 context.Context
 	cancel              context.CancelFunc
 	mu                  sync.RWMutex
 
 	// teardown groups all session-destruction synchronization and connection tracking.
 	teardown sessionTeardownState
+
+	// concurrentNoticeSent: o aviso de conexões simultâneas já foi enviado (mu; ver concurrent_clients.go).
+	concurrentNoticeSent bool
 }
 
 // sessionTeardownState centralizes per-session teardown coordination.
@@ -52,6 +60,10 @@ type sessionTeardownState struct {
 	clientsWG   sync.WaitGroup
 	destroying  bool
 	destroyWait chan struct{} // closed when destroying finishes; GetOrCreateSession waits on it
+	// cleaningUp counts clients that have disconnected but are still in runDisconnectCleanup.
+	cleaningUp int
+	// clientsChanged is closed (and replaced) whenever clientConns or cleaningUp change; see WaitForDisconnectCleanup.
+	clientsChanged chan struct{}
 }
 
 // registerClientLocked tracks a proxy TCP client unless destroy is in progress.
@@ -73,6 +85,30 @@ func (t *sessionTeardownState) registerClientLocked(c net.Conn) bool {
 func (t *sessionTeardownState) unregisterClientLocked(c net.Conn) {
 	delete(t.clientConns, c)
 	t.clientsWG.Done()
+	t.notifyClientsChangedLocked()
+}
+
+// pendingClientsLocked is the number of clients still connected or running their disconnect cleanup.
+// Caller must hold session.mu.
+func (t *sessionTeardownState) pendingClientsLocked() int {
+	return len(t.clientConns) + t.cleaningUp
+}
+
+// clientsChangedChanLocked returns a channel closed at the next change of pendingClientsLocked.
+// Caller must hold session.mu.
+func (t *sessionTeardownState) clientsChangedChanLocked() <-chan struct{} {
+	if t.clientsChanged == nil {
+		t.clientsChanged = make(chan struct{})
+	}
+	return t.clientsChanged
+}
+
+// notifyClientsChangedLocked wakes WaitForDisconnectCleanup callers. Caller must hold session.mu.
+func (t *sessionTeardownState) notifyClientsChangedLocked() {
+	if t.clientsChanged != nil {
+		close(t.clientsChanged)
+		t.clientsChanged = nil
+	}
 }
 
 // destroyWaitChanLocked returns the current destroy wait channel (or nil).
@@ -114,19 +150,61 @@ func (t *sessionTeardownState) finishDestroyLocked() chan struct{} {
 }
 
 type PgRollback struct {
-	SessionsByTestID  map[string]*TestSession
-	PostgresHost      string
-	PostgresPort      int
-	PostgresDB        string
-	PostgresUser      string
-	PostgresPass      string
-	Timeout           time.Duration
-	SessionTimeout    time.Duration
-	KeepaliveInterval time.Duration // intervalo de ping pgrollback->PostgreSQL por conexão; 0 = desligado
-	mu                sync.RWMutex
+	SessionsByTestID    map[string]*TestSession
+	PostgresHost        string
+	PostgresPort        int
+	PostgresDB          string
+	PostgresUser        string
+	PostgresPass        string
+	Timeout             time.Duration
+	SessionTimeout      time.Duration
+	KeepaliveInterval   time.Duration // intervalo de ping pgrollback->PostgreSQL por conexão; 0 = desligado
+	BeginWaitTimeout    time.Duration // quanto um BEGIN de outra conexão espera a transação aberta terminar; 0 = erro imediato
+	ReadConnection      bool          // abre uma conexão somente leitura extra por sessão para SELECTs de clientes read-only
+	AdvisoryLockTimeout time.Duration // quanto ExecuteWithLock espera pelo advisory lock do test_id; 0 = DefaultAdvisoryLockTimeout
+	SavepointPrefix     string        // prefixo dos savepoints que substituem BEGIN (proxy.savepoint_prefix); "" = DefaultSavepointPrefix
+	IsolateSchema       bool          // cada sessão ganha seu próprio schema, primeiro no search_path (proxy.isolate_schema)
+	WarmPoolSize        int           // conexões ao PostgreSQL abertas de antemão para novas sessões (postgres.warm_pool_size); 0 = sem pool
+	QueryHistorySize    int           // queries guardadas por sessão (proxy.query_history_size); 0 = maxQueryHistory, negativo = sem histórico
+	mu                  sync.RWMutex
 
 	// backendStartupCache is filled from the first real PostgreSQL connection and replayed to clients.
 	backendStartupCache *BackendStartupCache
+
+	// serverVersion is the real backend's server_version, from the first connection that reported it
+	// (session or startup health check). Atomic: it is set both with and without p.mu held.
+	serverVersion atomic.Value // string
+
+	// warmPool guarda conexões já abertas para novas sessões; nil sem postgres.warm_pool_size. Set once by NewServer.
+	warmPool *warmPool
+
+	// expiredSessions guarda testIDs recém-removidos por inatividade para avisar o próximo cliente (NoticeResponse).
+	expiredSessions *expiredSessionSet
+
+	// peakSessions é o maior número de sessões vivas desde o início (mu; GET /api/server).
+	peakSessions int
+
+	// connecting guarda os testIDs cuja conexão ao PostgreSQL está sendo aberta (mu), para que outro
+	// cliente do mesmo testID espere por ela em vez de abrir outra; ver createAndStoreSessionLocked.
+	connecting map[string]*pendingSession
+
+	// Routes escolhe o banco pelo prefixo do test ID (postgres.routes), prefixo mais longo primeiro;
+	// vazio = todas as sessões no banco padrão (ver backend_routes.go). Set once by NewServer.
+	Routes []BackendRoute
+
+	// ConnectRetries é quantas vezes mais a conexão+BEGIN de uma nova sessão é tentada antes de falhar
+	// (postgres.connect_retries), esperando a partir de ConnectRetryInterval com backoff e jitter (ver connect_retry.go).
+	ConnectRetries       int
+	ConnectRetryInterval time.Duration
+
+	// SessionSetupSQL roda no início de toda transação base de uma sessão (postgres.session_setup_sql),
+	// ver session_setup.go. Set once by NewServer.
+	SessionSetupSQL []string
+
+	// AllowedStatements / DeniedStatements limitam os comandos que chegam ao PostgreSQL
+	// (proxy.allowed_statements / proxy.denied_statements), normalizados; ver statement_policy.go.
+	AllowedStatements []string
+	DeniedStatements  []string
 }
 
 // GetLastQueryDuration returns the last query execution duration (e.g. "12.345ms") for GUI, derived from the last history entry.
@@ -201,6 +279,25 @@ func (s *TestSession) Context() context.Context {
 	return context.Background()
 }
 
+// callContext returns a context for one call on the session that ends with ctx (the caller's deadline
+// or cancellation) or with the session's context (cancelled when the session is destroyed).
+func (s *TestSession) callContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	merged, cancel := context.WithCancel(ctx)
+	stop := context.AfterFunc(s.Context(), cancel)
+	return merged, func() {
+		stop()
+		cancel()
+	}
+}
+
+// contextLocked is Context for a caller holding s.mu.
+func (s *TestSession) contextLocked() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
 // Cancel cancels the session's context (if any). Safe to call multiple times.
 func (s *TestSession) Cancel() {
 	s.mu.Lock()
@@ -230,6 +327,24 @@ func (s *TestSession) unregisterProxyClient(c net.Conn) {
 	s.mu.Unlock()
 }
 
+// beginDisconnectCleanup unregisters a client whose message loop ended and counts it as cleaning up
+// until endDisconnectCleanup. Unregistering first lets a destroy started by the cleanup itself
+// (MarkDisconnectRequested) wait for the other clients without waiting for this one.
+func (s *TestSession) beginDisconnectCleanup(c net.Conn) {
+	s.mu.Lock()
+	s.teardown.cleaningUp++
+	s.teardown.unregisterClientLocked(c)
+	s.mu.Unlock()
+}
+
+// endDisconnectCleanup marks the disconnect cleanup started by beginDisconnectCleanup as finished.
+func (s *TestSession) endDisconnectCleanup() {
+	s.mu.Lock()
+	s.teardown.cleaningUp--
+	s.teardown.notifyClientsChangedLocked()
+	s.mu.Unlock()
+}
+
 func NewPgRollback(postgresHost string, postgresPort int, postgresDB, postgresUser, postgresPass string, timeout time.Duration, sessionTimeout time.Duration, keepaliveInterval time.Duration) *PgRollback {
 	return &PgRollback{
 		SessionsByTestID:  make(map[string]*TestSession),
@@ -241,6 +356,7 @@ func NewPgRollback(postgresHost string, postgresPort int, postgresDB, postgresUs
 		Timeout:           timeout,
 		SessionTimeout:    sessionTimeout,
 		KeepaliveInterval: keepaliveInterval,
+		expiredSessions:   newExpiredSessionSet(),
 	}
 }
 
@@ -258,6 +374,14 @@ func NewPgRollback(postgresHost string, postgresPort int, postgresDB, postgresUs
 // IMPORTANTE: O mesmo testID sempre usa a mesma conexão porque há apenas uma sessão por testID,
 // e a sessão guarda sua DB (connection + transaction). Tudo fica sob TestSession, indexado por testID.
 func (p *PgRollback) GetOrCreateSession(testID string) (*TestSession, error) {
+	return p.GetOrCreateSessionContext(context.Background(), testID)
+}
+
+// GetOrCreateSessionContext is GetOrCreateSession bounded by ctx: waiting for a teardown of testID in
+// progress, waiting for another client that is opening testID's connection, opening the backend
+// connection and its BEGIN all stop when ctx ends. ctx does not outlive the call; the session keeps its
+// own context.
+func (p *PgRollback) GetOrCreateSessionContext(ctx context.Context, testID string) (*TestSession, error) {
 	if testID == "" {
 		return nil, fmt.Errorf("testID is required")
 	}
@@ -267,7 +391,23 @@ func (p *PgRollback) GetOrCreateSession(testID string) (*TestSession, error) {
 		waitCh := p.waitForDestroyIfInProgress(session)
 		if waitCh != nil {
 			p.mu.Unlock()
-			<-waitCh
+			select {
+			case <-waitCh:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			continue
+		}
+		if pending := p.connecting[testID]; session == nil && pending != nil {
+			p.mu.Unlock()
+			select {
+			case <-pending.done:
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+			if pending.err != nil {
+				return nil, pending.err
+			}
 			continue
 		}
 		if session != nil {
@@ -282,7 +422,7 @@ func (p *PgRollback) GetOrCreateSession(testID string) (*TestSession, error) {
 			p.mu.Unlock()
 			continue
 		}
-		newSession, err := p.createAndStoreSessionLocked(testID)
+		newSession, err := p.createAndStoreSessionLocked(ctx, testID)
 		p.mu.Unlock()
 		if err != nil {
 			return nil, err
@@ -320,14 +460,35 @@ func (p *PgRollback) tryReuseSessionLocked(testID string, session *TestSession)
 	return nil
 }
 
-// createAndStoreSessionLocked creates a new session and stores it in map.
-// Caller must hold p.mu.
-func (p *PgRollback) createAndStoreSessionLocked(testID string) (*TestSession, error) {
-	newSession, err := p.createNewSessionLocked(testID)
+// pendingSession is a session whose backend connection is being opened: done is closed when it is
+// stored (err nil) or failed (err set).
+type pendingSession struct {
+	done chan struct{}
+	err  error
+}
+
+// createAndStoreSessionLocked creates a new session and stores it in map. Caller must hold p.mu, which
+// is released while the backend connection is opened (with its retries, that can take seconds, and
+// other test IDs must not wait for it) and held again on return. Meanwhile p.connecting makes other
+// callers for testID wait for this connection.
+func (p *PgRollback) createAndStoreSessionLocked(ctx context.Context, testID string) (*TestSession, error) {
+	pending := &pendingSession{done: make(chan struct{})}
+	if p.connecting == nil {
+		p.connecting = make(map[string]*pendingSession)
+	}
+	p.connecting[testID] = pending
+	p.mu.Unlock()
+	newSession, err := p.createNewSession(ctx, testID)
+	p.mu.Lock()
+	delete(p.connecting, testID)
+	pending.err = err
+	close(pending.done)
 	if err != nil {
 		return nil, err
 	}
 	p.SessionsByTestID[testID] = newSession
+	p.peakSessions = max(p.peakSessions, len(p.SessionsByTestID))
+	p.fillBackendStartupCacheIfNeeded(newSession.DB.PgConn())
 	return newSession, nil
 }
 
@@ -352,38 +513,73 @@ func (p *PgRollback) GetAllSessions() map[string]*TestSession {
 	return sessions
 }
 
-// createNewSessionLocked cria uma nova sessão para o testID.
+// createNewSession cria uma nova sessão para o testID, sem p.mu.
 // Só é chamada quando não existe sessão para este testID; a conexão fica na sessão.
-func (p *PgRollback) createNewSessionLocked(testID string) (*TestSession, error) {
+// callCtx limita só a criação (conexão, BEGIN); a sessão recebe um contexto próprio.
+func (p *PgRollback) createNewSession(callCtx context.Context, testID string) (*TestSession, error) {
 	if testID == "" {
 		return nil, fmt.Errorf("testID is required to create a new session")
 	}
 
-	conn, err := newConnectionForTestID(p.PostgresHost, p.PostgresPort, p.PostgresDB, p.PostgresUser, p.PostgresPass, p.SessionTimeout, testID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create connection for testID %s: %w", testID, err)
+	target, routed := p.backendFor(testID)
+	if routed {
+		log.Printf("[PROXY] testID %s routed to backend %s (postgres.routes)", testID, target)
 	}
-
-	// Inicia nova transação na conexão
+	notices := &backendNotices{}
+	// Abre a conexão e inicia nova transação nela, com retentativas (postgres.connect_retries)
 	// IMPORTANTE: Mesmo se reutilizamos a conexão, sempre criamos nova transação
 	// A transação anterior (se existia) deve ter sido commitada ou rollback
-	ctx, cancel := context.WithCancel(context.Background())
-	tx, err := conn.Begin(ctx)
+	conn, tx, err := p.connectSession(callCtx, testID, notices)
 	if err != nil {
-		cancel()
-		conn.Close(ctx)
-		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, err
 	}
+	ctx, cancel := context.WithCancel(context.Background())
 
 	//#TODO (IA MANTER ISSO AQUI) Danilo Quero revisar pra usar o keep interval
 	db := newSessionDB(conn, tx, ctx)
-	p.fillBackendStartupCacheIfNeeded(db.PgConn())
-	//if p.KeepaliveInterval > 0 {
-	//	db.startKeepalive(p.KeepaliveInterval)
-	//}
+	db.notices = notices
+	db.dial = func(ctx context.Context) (*pgx.Conn, error) {
+		return newConnectionForTestID(ctx, target.Host, target.Port, target.Database, target.User, target.Password, p.SessionTimeout, testID, notices.onNotice)
+	}
+	db.beginWaitTimeout = p.BeginWaitTimeout
+	db.savepointPrefix = p.SavepointPrefix
+	if p.QueryHistorySize != 0 {
+		db.Gui.setHistorySize(p.QueryHistorySize)
+	}
+	if p.IsolateSchema {
+		db.isolationSchema = isolationSchemaName(testID)
+		db.gucApplied = nil
+		if err := db.applyClientGUCs(callCtx, defaultClientGUCs()); err != nil {
+			cancel()
+			conn.Close(context.Background())
+			return nil, fmt.Errorf("failed to create schema %s for testID %s: %w", db.isolationSchema, testID, err)
+		}
+	}
+	if len(p.SessionSetupSQL) > 0 {
+		db.setupSQL = p.SessionSetupSQL
+		db.setupGUCs = sessionSetupGUCs(p.SessionSetupSQL)
+		if err := db.runSessionSetup(callCtx); err != nil {
+			cancel()
+			conn.Close(context.Background())
+			return nil, fmt.Errorf("failed to set up session for testID %s: %w", testID, err)
+		}
+	}
+	if p.ReadConnection {
+		readConn, err := newReadConnectionForTestID(target.Host, target.Port, target.Database, target.User, target.Password, p.SessionTimeout, testID)
+		if err != nil {
+			// A conexão de escrita continua servindo tudo; só perde a concorrência de leitura.
+			log.Printf("[PROXY] read connection for testID %s unavailable, using the write connection: %v", testID, err)
+		} else {
+			db.readConn = readConn
+		}
+	}
+	if p.KeepaliveInterval > 0 {
+		db.startKeepalive(p.KeepaliveInterval)
+	}
 
 	session := &TestSession{
 		DB:           db,
+		TestID:       testID,
 		CreatedAt:    time.Now(),
 		LastActivity: time.Now(),
 		ctx:          ctx,
@@ -394,12 +590,12 @@ func (p *PgRollback) createNewSessionLocked(testID string) (*TestSession, error)
 }
 
 // fillBackendStartupCacheIfNeeded copies ParameterStatus from the real PostgreSQL connection into the
-// cache so we can replay them to clients. Called when creating a new session; only fills once.
-// BackendKeyData is not exposed by pgx PgConn, so we keep a default value.
+// cache so we can replay them to clients. Called when storing a new session; only fills once. Caller must hold p.mu.
 func (p *PgRollback) fillBackendStartupCacheIfNeeded(pgConn *pgconn.PgConn) {
 	if pgConn == nil {
 		return
 	}
+	p.rememberServerVersion(pgConn)
 	if p.backendStartupCache != nil {
 		return
 	}
@@ -412,10 +608,30 @@ func (p *PgRollback) fillBackendStartupCacheIfNeeded(pgConn *pgconn.PgConn) {
 	}
 	p.backendStartupCache = &BackendStartupCache{
 		ParameterStatuses: params,
-		BackendKeyData:    pgproto3.BackendKeyData{ProcessID: 12345, SecretKey: 67890}, // pgx does not expose; keep default
 	}
 }
 
+// fallbackServerVersion is reported to clients only while no real PostgreSQL connection has told us its version.
+const fallbackServerVersion = "14.0"
+
+// rememberServerVersion stores the server_version reported by a real PostgreSQL connection; the first one wins.
+func (p *PgRollback) rememberServerVersion(pgConn *pgconn.PgConn) {
+	if pgConn == nil {
+		return
+	}
+	if version := pgConn.ParameterStatus("server_version"); version != "" {
+		p.serverVersion.CompareAndSwap(nil, version)
+	}
+}
+
+// ServerVersion returns the real backend's server_version, or fallbackServerVersion when it is not known yet.
+func (p *PgRollback) ServerVersion() string {
+	if version, ok := p.serverVersion.Load().(string); ok && version != "" {
+		return version
+	}
+	return fallbackServerVersion
+}
+
 // GetBackendStartupCache returns the cached backend startup messages from the real PostgreSQL, or nil if not yet filled.
 func (p *PgRollback) GetBackendStartupCache() *BackendStartupCache {
 	p.mu.RLock()
@@ -444,13 +660,23 @@ func isConnClosedOrFatal(err error) bool {
 // Se a conexão com o PostgreSQL já estiver morta (ex.: timeout), remove a sessão
 // do estado e retorna nil para o cliente ter sucesso (a tarefa "encerrar sessão" foi cumprida).
 func (p *PgRollback) DestroySession(testID string) error {
+	return p.DestroySessionContext(context.Background(), testID)
+}
+
+// ErrSessionNotFound is returned (wrapped) when no session exists for the test ID.
+var ErrSessionNotFound = errors.New("session not found")
+
+// DestroySessionContext is DestroySession with ctx bounding the backend ROLLBACK and close (at most
+// destroyCloseTimeout either way). Waiting for the session's clients to disconnect is not bounded: their
+// connections are closed first, so their handlers return promptly.
+func (p *PgRollback) DestroySessionContext(ctx context.Context, testID string) error {
 	p.mu.Lock()
 	session, exists := p.SessionsByTestID[testID]
 	p.mu.Unlock()
 	if !exists {
-		return fmt.Errorf("session not found for test_id: %s", testID)
+		return fmt.Errorf("%w for test_id: %s", ErrSessionNotFound, testID)
 	}
-	return p.destroySessionCore(session, testID)
+	return p.destroySessionCore(ctx, session, testID)
 }
 
 // destroySessionIgnoreNotFound tears down a session if it is still in the map (used by idle cleanup).
@@ -461,14 +687,14 @@ func (p *PgRollback) destroySessionIgnoreNotFound(testID string) error {
 	if !ok {
 		return nil
 	}
-	return p.destroySessionCore(session, testID)
+	return p.destroySessionCore(context.Background(), session, testID)
 }
 
 // destroySessionCore closes all proxy TCP clients for the session, waits until their message loops
 // (including disconnect cleanup) finish, then closes the shared backend and removes the session.
 // Caller must not hold p.mu or session.mu. Safe to call concurrently for the same session; only one
 // teardown succeeds.
-func (p *PgRollback) destroySessionCore(session *TestSession, testID string) error {
+func (p *PgRollback) destroySessionCore(ctx context.Context, session *TestSession, testID string) error {
 	p.mu.Lock()
 	if !p.beginDestroySessionMapGateLocked(session, testID) {
 		p.mu.Unlock()
@@ -489,17 +715,7 @@ func (p *PgRollback) destroySessionCore(session *TestSession, testID string) err
 
 	session.teardown.waitClients()
 
-	p.mu.Lock()
-	curSession := p.SessionsByTestID[testID]
-	session.mu.Lock()
-	destroyWaitCh, err := p.finishDestroySessionLocked(session, curSession, testID)
-	session.mu.Unlock()
-	p.mu.Unlock()
-
-	if destroyWaitCh != nil {
-		close(destroyWaitCh)
-	}
-	return err
+	return p.finishDestroySession(ctx, session, testID)
 }
 
 // destroySessionCoreWithPLock performs destroy while caller currently holds p.mu.
@@ -516,20 +732,13 @@ func (p *PgRollback) destroySessionCoreWithPLock(session *TestSession, testID st
 	}
 
 	p.mu.Unlock()
+	defer p.mu.Lock()
 	for _, c := range conns {
 		_ = c.Close()
 	}
 	session.teardown.waitClients()
-	p.mu.Lock()
 
-	curSession := p.SessionsByTestID[testID]
-	session.mu.Lock()
-	destroyWaitCh, err := p.finishDestroySessionLocked(session, curSession, testID)
-	session.mu.Unlock()
-	if destroyWaitCh != nil {
-		close(destroyWaitCh)
-	}
-	return err
+	return p.finishDestroySession(context.Background(), session, testID)
 }
 
 // beginDestroySessionMapGateLocked reports whether session is still the map entry for testID.
@@ -553,34 +762,60 @@ func (p *PgRollback) beginDestroySessionBodyLocked(session *TestSession, testID
 	return conns, false
 }
 
-// finishDestroySessionLocked closes backend DB and removes the session after clients finished.
-// Caller must hold p.mu and oldSession.mu.
-// It returns the destroy-wait channel that caller must close after releasing oldSession.mu.
-func (p *PgRollback) finishDestroySessionLocked(oldSession, curSession *TestSession, testID string) (chan struct{}, error) {
+// finishDestroySession closes the backend DB and removes the session after its clients finished.
+// The ROLLBACK and close (up to destroyCloseTimeout) run without p.mu or oldSession.mu, so a slow backend
+// does not hold up connections of other test IDs; until they end the session stays in the map with its
+// teardown in progress, so GetOrCreateSession for the same test ID waits for it.
+// Caller must not hold p.mu or oldSession.mu.
+func (p *PgRollback) finishDestroySession(ctx context.Context, oldSession *TestSession, testID string) error {
+	p.mu.Lock()
+	oldSession.mu.Lock()
 	// Between beginDestroy and finishDestroy we release p.mu and wait for proxy clients.
 	// During this window, another goroutine may install a new session for testID.
 	// In this case, oldSession teardown must finish, but we must not close/remove curSession.
-	if curSession != oldSession {
-		return p.signalDestroyWaitersLocked(oldSession), nil
+	db := oldSession.DB
+	if p.SessionsByTestID[testID] != oldSession || db == nil {
+		if db == nil && p.SessionsByTestID[testID] == oldSession {
+			delete(p.SessionsByTestID, testID)
+		}
+		p.finishDestroySessionUnlock(oldSession)
+		return nil
 	}
+	oldSession.mu.Unlock()
+	p.mu.Unlock()
 
-	if oldSession.DB == nil {
-		delete(p.SessionsByTestID, testID)
-		return p.signalDestroyWaitersLocked(oldSession), nil
-	}
+	ctx, cancel := context.WithTimeout(ctx, destroyCloseTimeout)
+	err := db.close(ctx)
+	cancel()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	err := oldSession.DB.close(ctx)
+	p.mu.Lock()
+	oldSession.mu.Lock()
 	if err != nil {
-		return p.signalDestroyWaitersLocked(oldSession), fmt.Errorf("failed to close session: '%s': %w", testID, err)
+		err = fmt.Errorf("failed to close session: '%s': %w", testID, err)
+	} else {
+		oldSession.DB = nil
+		if p.SessionsByTestID[testID] == oldSession {
+			delete(p.SessionsByTestID, testID)
+		}
 	}
+	p.finishDestroySessionUnlock(oldSession)
+	return err
+}
 
-	oldSession.DB = nil
-	delete(p.SessionsByTestID, testID)
-	return p.signalDestroyWaitersLocked(oldSession), nil
+// finishDestroySessionUnlock ends oldSession's teardown, releases oldSession.mu and p.mu (held by the
+// caller) and wakes whoever waits for the teardown.
+func (p *PgRollback) finishDestroySessionUnlock(oldSession *TestSession) {
+	destroyWaitCh := p.signalDestroyWaitersLocked(oldSession)
+	oldSession.mu.Unlock()
+	p.mu.Unlock()
+	if destroyWaitCh != nil {
+		close(destroyWaitCh)
+	}
 }
 
+// destroyCloseTimeout bounds the ROLLBACK and close of a destroyed session's backend connection.
+const destroyCloseTimeout = 5 * time.Second
+
 func (p *PgRollback) signalDestroyWaiters(session *TestSession) {
 	session.mu.Lock()
 	ch := p.signalDestroyWaitersLocked(session)
@@ -596,13 +831,31 @@ func (p *PgRollback) signalDestroyWaitersLocked(session *TestSession) chan struc
 	return session.teardown.finishDestroyLocked()
 }
 
+// handleReset discards every user BEGIN still open on the session ("pgrollback reset"), keeping the
+// base transaction and whatever was done outside BEGIN (e.g. schema created during setup). Unlike
+// "pgrollback rollback", which rolls back the whole base transaction and starts a new one.
+func (s *TestSession) handleReset(testID string, connID ConnectionID) (string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.DB == nil {
+		return "", fmt.Errorf("Reset TestSession has no connection to DB on ID: %s", testID)
+	}
+	return s.DB.handleReset(connID)
+}
+
 // RollbackBaseTransaction runs ROLLBACK and begins a new transaction on the session (used by "pgrollback rollback").
 func (p *PgRollback) RollbackBaseTransaction(testID string) (string, error) {
+	return p.RollbackBaseTransactionContext(context.Background(), testID)
+}
+
+// RollbackBaseTransactionContext is RollbackBaseTransaction with the ROLLBACK and BEGIN bounded by ctx
+// as well as by the session's own context.
+func (p *PgRollback) RollbackBaseTransactionContext(ctx context.Context, testID string) (string, error) {
 	session := p.GetSession(testID)
 	if session == nil {
 		return "", fmt.Errorf("session not found for test_id: '%s'", testID)
 	}
-	return session.RollbackBaseTransaction(testID)
+	return session.RollbackBaseTransactionContext(ctx, testID)
 }
 
 // RollbackSession é um alias para DestroySession mantido para compatibilidade.
@@ -620,22 +873,28 @@ func (p *PgRollback) sessionIdleExpired(session *TestSession, timeToConsider tim
 func (p *PgRollback) CleanupExpiredSessions() (int, error) {
 	now := time.Now()
 	var expiredIDs []string
-	p.mu.Lock()
+	idleByID := make(map[string]time.Duration)
+	// Only the scan holds p.mu (read); each expired session is then torn down on its own, its backend
+	// closed without the map lock (see finishDestroySession).
+	p.mu.RLock()
 	for testID, session := range p.SessionsByTestID {
 		session.mu.RLock()
 		expired := p.sessionIdleExpired(session, now)
+		idle := now.Sub(session.LastActivity)
 		session.mu.RUnlock()
 		if expired {
 			expiredIDs = append(expiredIDs, testID)
+			idleByID[testID] = idle
 		}
 	}
-	p.mu.Unlock()
+	p.mu.RUnlock()
 
 	cleaned := 0
 	for _, testID := range expiredIDs {
 		if err := p.destroySessionIgnoreNotFound(testID); err != nil {
 			return cleaned, err
 		}
+		p.expiredSessions.add(testID, idleByID[testID], now)
 		cleaned++
 	}
 	return cleaned, nil
@@ -649,12 +908,16 @@ func (p *PgRollback) getAdvisoryLockKey(session *TestSession) int64 {
 	return int64(hash.Sum64())
 }
 
-func (p *PgRollback) acquireAdvisoryLock(session *TestSession) error {
+func (p *PgRollback) acquireAdvisoryLock(ctx context.Context, session *TestSession) error {
 	if session.DB == nil {
 		return fmt.Errorf("session DB is nil for session %s", p.GetTestID(session))
 	}
 	lockKey := p.getAdvisoryLockKey(session)
-	return session.DB.acquireAdvisoryLock(session.Context(), lockKey)
+	timeout := p.AdvisoryLockTimeout
+	if timeout <= 0 {
+		timeout = DefaultAdvisoryLockTimeout
+	}
+	return session.DB.acquireAdvisoryLock(ctx, lockKey, timeout)
 }
 
 func (p *PgRollback) releaseAdvisoryLock(session *TestSession) error {
@@ -669,10 +932,19 @@ func (p *PgRollback) releaseAdvisoryLock(session *TestSession) error {
 // PostgreSQL advisory lock. The query is executed via SafeExec (guard savepoint) so a SQL error does
 // not abort the outer transaction — the next advisory lock / proxy command still sees a healthy tx.
 func (p *PgRollback) ExecuteWithLock(session *TestSession, query string) error {
+	return p.ExecuteWithLockContext(context.Background(), session, query)
+}
+
+// ExecuteWithLockContext is ExecuteWithLock with waiting for the advisory lock and running query bounded
+// by ctx as well as by the session's own context. The lock is released with the session's context, so
+// it is not left held when ctx ends.
+func (p *PgRollback) ExecuteWithLockContext(ctx context.Context, session *TestSession, query string) error {
 	if session.DB == nil {
 		return fmt.Errorf("session DB is nil for session %s", p.GetTestID(session))
 	}
-	if err := p.acquireAdvisoryLock(session); err != nil {
+	ctx, cancel := session.callContext(ctx)
+	defer cancel()
+	if err := p.acquireAdvisoryLock(ctx, session); err != nil {
 		return fmt.Errorf("failed to acquire advisory lock: %w", err)
 	}
 	defer p.releaseAdvisoryLock(session)
@@ -681,7 +953,7 @@ func (p *PgRollback) ExecuteWithLock(session *TestSession, query string) error {
 	session.LastActivity = time.Now()
 	session.mu.Unlock()
 
-	_, err := session.DB.SafeExec(session.Context(), query)
+	_, err := session.DB.SafeExec(ctx, query)
 	return err
 }
 
@@ -698,22 +970,33 @@ func (p *PgRollback) ExecuteWithLock(session *TestSession, query string) error {
 // - PHP faz comandos → executa BEGIN novamente → cria savepoint pgrollback_v_2
 // - PHP executa ROLLBACK → faz rollback até pgrollback_v_2 (não afeta pgrollback_v_1)
 // - PHP desconecta → próxima conexão PHP com mesmo testID pode continuar de onde parou
+//
+// s.mu is not held while DB.handleBegin runs: with proxy.begin_wait_timeout it may block waiting for
+// another connection's COMMIT/ROLLBACK.
 func (s *TestSession) handleBegin(testID string, connID ConnectionID) (string, error) {
 	s.mu.RLock()
-	defer s.mu.RUnlock()
-	if s.DB == nil {
+	db, persist := s.DB, s.persist
+	s.mu.RUnlock()
+	if db == nil {
 		return "", fmt.Errorf("Begin TestSession has no connection to DB on ID: %s", testID)
 	}
-	return s.DB.handleBegin(testID, connID)
+	if persist {
+		// The base transaction already plays the role of the client's transaction.
+		return DEFAULT_SELECT_ONE, nil
+	}
+	return db.handleBegin(testID, connID)
 }
 
-// handleCommit converte COMMIT em RELEASE SAVEPOINT
+// handleCommit converte COMMIT em RELEASE SAVEPOINT (em modo persist, faz COMMIT da transação base)
 func (s *TestSession) handleCommit(testID string) (string, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	if s.DB == nil {
 		return "", fmt.Errorf("Commit TestSession has no connection to DB on ID: %s", testID)
 	}
+	if s.persist {
+		return DEFAULT_SELECT_ONE, s.DB.restartBaseTx(s.contextLocked(), true)
+	}
 	return s.DB.handleCommit(testID)
 }
 
@@ -722,6 +1005,7 @@ func (s *TestSession) handleCommit(testID string) (string, error) {
 // Comportamento:
 // - Se SavepointLevel > 0: faz rollback até o último savepoint e o remove
 // - Se SavepointLevel = 0: não há savepoints para reverter, apenas retorna sucesso
+// - Em modo persist: faz ROLLBACK da transação base e inicia outra
 //
 // Caso de uso PHP:
 // - PHP executa ROLLBACK → reverte até o último savepoint criado por esta conexão
@@ -733,6 +1017,9 @@ func (s *TestSession) handleRollback(testID string) (string, error) {
 	if s.DB == nil {
 		return "", fmt.Errorf("Rollback TestSession has no connection to DB on ID: %s", testID)
 	}
+	if s.persist {
+		return DEFAULT_SELECT_ONE, s.DB.restartBaseTx(s.contextLocked(), false)
+	}
 	return s.DB.handleRollback(testID)
 }
 
@@ -740,7 +1027,14 @@ func (s *TestSession) handleRollback(testID string) (string, error) {
 // Returns FULLROLLBACK_SENTINEL so the proxy sends exactly one CommandComplete+ReadyForQuery without
 // forwarding to the DB, avoiding response attribution issues with the next query (e.g. ResetSession ping).
 func (s *TestSession) RollbackBaseTransaction(testID string) (string, error) {
-	return FULLROLLBACK_SENTINEL, s.DB.startNewTx(s.Context())
+	return s.RollbackBaseTransactionContext(context.Background(), testID)
+}
+
+// RollbackBaseTransactionContext is RollbackBaseTransaction bounded by ctx as well as by the session's context.
+func (s *TestSession) RollbackBaseTransactionContext(ctx context.Context, testID string) (string, error) {
+	ctx, cancel := s.callContext(ctx)
+	defer cancel()
+	return FULLROLLBACK_SENTINEL, s.DB.startNewTx(ctx)
 }
 
 // buildStatusResultSet constrói uma query SELECT para status de uma sessão
@@ -0,0 +1,46 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestGetOrCreateSessionContext_StopsWaitingForTeardown asserts a caller waiting for another goroutine
+// to finish destroying the session gives up when its context ends, instead of blocking until then.
+func TestGetOrCreateSessionContext_StopsWaitingForTeardown(t *testing.T) {
+	p := NewPgRollback("127.0.0.1", 1, "db", "u", "", time.Minute, time.Minute, 0)
+	session := &TestSession{DB: newTestSessionDB(), TestID: "t1"}
+	session.teardown.beginDestroyLocked()
+	p.SessionsByTestID["t1"] = session
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	got, err := p.GetOrCreateSessionContext(ctx, "t1")
+	if got != nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("GetOrCreateSessionContext = %v, %v; want context.DeadlineExceeded", got, err)
+	}
+}
+
+// TestCallContext_EndsWithCallerOrSession asserts a call context ends when either the caller's context
+// or the session's (cancelled by DestroySession) ends.
+func TestCallContext_EndsWithCallerOrSession(t *testing.T) {
+	sessionCtx, cancelSession := context.WithCancel(context.Background())
+	session := &TestSession{ctx: sessionCtx, cancel: cancelSession}
+
+	caller, cancelCaller := context.WithCancel(context.Background())
+	ctx, done := session.callContext(caller)
+	cancelCaller()
+	<-ctx.Done()
+	done()
+
+	ctx, done = session.callContext(context.Background())
+	defer done()
+	cancelSession()
+	select {
+	case <-ctx.Done():
+	case <-time.After(time.Second):
+		t.Fatal("call context must end when the session's context is cancelled")
+	}
+}
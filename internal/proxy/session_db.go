@@ -7,6 +7,7 @@ import (
 	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -22,6 +23,45 @@ type ConnectionID = uintptr
 // ErrOnlyOneTransactionAtATime is returned when a second connection tries to BEGIN while another already has an open user transaction on the same session.
 var ErrOnlyOneTransactionAtATime = errors.New("only one transaction could start a transaction at a time on our pgrollback")
 
+// ErrAdvisoryLockTimeout is returned by ExecuteWithLock when the test_id's advisory lock stays held by
+// another backend (e.g. a second pgrollback process on the same database) longer than AdvisoryLockTimeout.
+var ErrAdvisoryLockTimeout = errors.New("timed out waiting for the test session advisory lock")
+
+// advisoryLockRetryInterval is the pause between pg_try_advisory_lock attempts.
+const advisoryLockRetryInterval = 50 * time.Millisecond
+
+// TransactionInUseError is the ErrOnlyOneTransactionAtATime returned to clients: it names the connection
+// holding the open transaction and is sent as SQLSTATE 55006 (object_in_use). errors.Is still matches
+// ErrOnlyOneTransactionAtATime.
+type TransactionInUseError struct {
+	Holder      ConnectionID
+	HolderLabel string // client address (or other label) of the holder when known
+}
+
+func (e *TransactionInUseError) Error() string {
+	return fmt.Sprintf("%v (transaction held by %s)", ErrOnlyOneTransactionAtATime, e.holderName())
+}
+
+func (e *TransactionInUseError) Unwrap() error { return ErrOnlyOneTransactionAtATime }
+
+// Hint is sent in the ErrorResponse Hint field.
+func (e *TransactionInUseError) Hint() string {
+	return fmt.Sprintf("Connection %s has an open transaction on this test session; COMMIT or ROLLBACK it first, or set proxy.begin_wait_timeout to wait for it.", e.holderName())
+}
+
+func (e *TransactionInUseError) holderName() string {
+	return openTxHolderName(e.Holder, e.HolderLabel)
+}
+
+// openTxHolderName is how the holder of the open transaction is shown to people: its label (the client
+// address) when known, otherwise the connection ID in hex.
+func openTxHolderName(holder ConnectionID, label string) string {
+	if label != "" {
+		return label
+	}
+	return fmt.Sprintf("%#x", holder)
+}
+
 // guiState holds GUI-observable session fields with its own RWMutex.
 // All methods are self-contained (acquire/release the lock internally),
 // so callers never need to worry about which lock to hold.
@@ -30,44 +70,77 @@ type guiState struct {
 	mu           sync.RWMutex
 	queryHistory []QueryHistoryEntry
 	running      int
+
+	// historySize caps queryHistory: 0 = maxQueryHistory, negative = no history (see setHistorySize).
+	historySize int
 }
 
 // realSessionDB encapsulates the PostgreSQL connection and its active transaction.
 //
 // Lock design:
-//   - mu is the main lock: protects conn, tx, SavepointLevel, stopKeepalive, and serializes all SQL I/O.
+//   - mu is the main lock: protects conn, tx, stopKeepalive, and serializes all SQL I/O. SavepointLevel is
+//     only changed under mu (with the state that goes with it), but is atomic so that reading it needs no lock.
 //   - Gui has its own RWMutex protecting GUI-observable fields (queryHistory, running)
 //     so GUI/status reads never block on running queries.
 //   - Lock ordering when both are needed: mu first, then Gui.mu. Never the reverse.
 type realSessionDB struct {
 	conn                 *pgx.Conn
 	tx                   pgx.Tx
-	mu                   sync.RWMutex // main lock: conn/tx state + serializes SQL I/O
-	Gui                  guiState     // GUI-observable state; see guiState doc
-	SavepointLevel       int
-	connectionWithOpenTx ConnectionID // which connection has the open user transaction; 0 when none (mu)
+	mu                   sync.RWMutex            // main lock: conn/tx state + serializes SQL I/O
+	Gui                  guiState                // GUI-observable state; see guiState doc
+	prepared             preparedStatementCounts // per-connection prepared statement counts (own mutex)
+	backendStatements    backendStatementNames   // backend names of each connection's prepared statements (own mutex)
+	notices              *backendNotices         // backend NoticeResponses awaiting relay (own mutex)
+	readConn             *readConnection         // optional read-only connection (proxy.read_connection); set once at creation, own mutex
+	SavepointLevel       atomic.Int64
+	connectionWithOpenTx ConnectionID           // which connection has the open user transaction; 0 when none (mu)
+	openTxHolderLabel    string                 // client address of connectionWithOpenTx, for errors and status (mu)
+	openTxReleased       chan struct{}          // closed when the open transaction claim is released; nil when nobody waits (mu)
+	beginWaitTimeout     time.Duration          // how long a BEGIN from another connection waits for the claim; 0 = fail at once
+	savepointPrefix      string                 // proxy.savepoint_prefix; set once at creation, "" = DefaultSavepointPrefix
+	namedSavepoints      []namedSavepoint       // checkpoints from "pgrollback savepoint <name>", oldest first (mu)
+	gucApplied           map[string]string      // tracked client parameters last applied on the backend; nil = unknown (mu), see client_gucs.go
+	running              runningStatements      // client connections with a statement in progress (own mutex), see cancel.go
+	deadlockVictim       atomic.Pointer[string] // set by the lock watchdog before it cancels our statement, see lock_watchdog.go
 	stopKeepalive        func()
 	ctx                  context.Context
+
+	// Backend connection replacement, see backend_reconnect.go.
+	cancelConn    atomic.Pointer[pgconn.PgConn]                // backend connection targeted by client CancelRequests; replaced on reconnect
+	dial          func(ctx context.Context) (*pgx.Conn, error) // opens a new backend connection; nil = never reconnect
+	generation    uint64                                       // backend connections replaced so far (mu)
+	reconnectDone chan struct{}                                // non-nil while reconnect dials outside mu, closed when it is done (mu)
+
+	// Per-test schema (proxy.isolate_schema), see isolation_schema.go.
+	isolationSchema string // set once at creation; "" = shared schemas
+
+	// Session setup statements (postgres.session_setup_sql), see session_setup.go. Set once at creation.
+	setupSQL  []string
+	setupGUCs map[string]string // tracked parameters the setup SETs: their session default
+
+	// CREATE/DROP of schema objects since the base transaction began (own mutex), see schema_objects.go.
+	schemaChanges schemaChangeJournal
 }
 
+// GetSavepointLevel returns the savepoint level without taking d.mu. A caller that needs the level to stay
+// put while it acts on it must hold d.mu.
 func (d *realSessionDB) GetSavepointLevel() int {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
-	return d.SavepointLevel
+	return int(d.SavepointLevel.Load())
+}
+
+// setSavepointLevelLocked sets the savepoint level. Caller must hold d.mu.
+func (d *realSessionDB) setSavepointLevelLocked(level int) {
+	d.SavepointLevel.Store(int64(level))
 }
 
 // GetSavepointName returns the name for the current savepoint level. Caller must hold d.mu when level may be changing.
 func (d *realSessionDB) GetSavepointName() string {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
 	return d.getSavepointNameLocked()
 }
 
 // GetNextSavepointName returns the name for the next SAVEPOINT (current level + 1) without incrementing.
 // Used by the interceptor so SavepointLevel is only incremented when the SAVEPOINT is actually executed.
 func (d *realSessionDB) GetNextSavepointName() string {
-	d.mu.RLock()
-	defer d.mu.RUnlock()
 	return d.getNextSavepointNameLocked()
 }
 
@@ -87,24 +160,51 @@ func (d *realSessionDB) DecrementSavepointLevel() {
 
 // DecrementSavepointLevel decrements the savepoint level. Call only after a RELEASE SAVEPOINT or ROLLBACK TO SAVEPOINT has been successfully executed. No-op if level is already 0.
 func (d *realSessionDB) decrementSavepointLevelLocked() {
-	if d.SavepointLevel > 0 {
-		d.SavepointLevel--
+	if d.GetSavepointLevel() > 0 {
+		d.SavepointLevel.Add(-1)
 	}
+	// Checkpoints created inside the released level went with it on the backend.
+	kept := len(d.namedSavepoints)
+	for kept > 0 && d.namedSavepoints[kept-1].level > d.GetSavepointLevel() {
+		kept--
+	}
+	d.namedSavepoints = d.namedSavepoints[:kept]
+	d.mergeSchemaChangesInto(d.GetSavepointLevel())
+	d.invalidateClientGUCsLocked()
 }
 
 // getSavepointNameLocked returns the name for the current savepoint level. Caller must hold d.mu.
 func (d *realSessionDB) getSavepointNameLocked() string {
-	return fmt.Sprintf("pgrollback_v_%d", d.SavepointLevel)
+	return d.savepointName(d.GetSavepointLevel())
 }
 
 // getNextSavepointNameLocked returns the name for the next SAVEPOINT (current level + 1) without incrementing. Caller must hold d.mu.
 func (d *realSessionDB) getNextSavepointNameLocked() string {
-	return fmt.Sprintf("pgrollback_v_%d", d.SavepointLevel+1)
+	return d.savepointName(d.GetSavepointLevel() + 1)
+}
+
+// savepointPrefixOrDefault returns the proxy.savepoint_prefix of the session (DefaultSavepointPrefix when unset).
+func (d *realSessionDB) savepointPrefixOrDefault() string {
+	if d.savepointPrefix == "" {
+		return DefaultSavepointPrefix
+	}
+	return d.savepointPrefix
+}
+
+// savepointName returns the backend savepoint that stands for user transaction level.
+func (d *realSessionDB) savepointName(level int) string {
+	return fmt.Sprintf("%s%d", d.savepointPrefixOrDefault(), level)
+}
+
+// isUserSavepointName reports whether name is one of the savepoints the proxy creates for a user BEGIN.
+// Any other savepoint (the application's own, or pgrollback_user_*) is passed through untracked.
+func (d *realSessionDB) isUserSavepointName(name string) bool {
+	return strings.HasPrefix(name, d.savepointPrefixOrDefault())
 }
 
 // incrementSavepointLevelLocked increments the savepoint level. Caller must hold d.mu.
 func (d *realSessionDB) incrementSavepointLevelLocked() {
-	d.SavepointLevel++
+	d.SavepointLevel.Add(1)
 }
 
 // LockRun holds d.mu for the duration of using the backend outside SafeExec/SafeQuery/SafeExecTCL (e.g. PgConn().Exec). Unlock with UnlockRun.
@@ -188,42 +288,101 @@ func (d *realSessionDB) isTransactionHeldByOtherConnectionLocked(connID Connecti
 	return d.connectionWithOpenTx != 0 && d.connectionWithOpenTx != connID
 }
 
-// IsUserBeginQuery returns true when the query is a user BEGIN (SAVEPOINT pgrollback_v_*).
+// IsUserBeginQuery returns true when the query is a user BEGIN (SAVEPOINT <savepoint_prefix>*).
 // Callers use this to decide whether to call ClaimOpenTransaction (e.g. before executing TCL).
-func IsUserBeginQuery(query string) bool {
+func (d *realSessionDB) IsUserBeginQuery(query string) bool {
 	stmts, err := sqlpkg.ParseStatements(query)
 	if err != nil || len(stmts) == 0 || stmts[0].Stmt == nil {
 		return false
 	}
-	return sqlpkg.IsSavepoint(stmts[0].Stmt) && strings.HasPrefix(sqlpkg.GetSavepointName(stmts[0].Stmt), pgrollbackSavepointPrefix)
+	return sqlpkg.IsSavepoint(stmts[0].Stmt) && d.isUserSavepointName(sqlpkg.GetSavepointName(stmts[0].Stmt))
 }
 
-// isUserReleaseQuery returns true when the query is a user COMMIT (RELEASE SAVEPOINT pgrollback_v_*).
-func isUserReleaseQuery(query string) bool {
+// isUserReleaseQuery returns true when the query is a user COMMIT (RELEASE SAVEPOINT <savepoint_prefix>*).
+func (d *realSessionDB) isUserReleaseQuery(query string) bool {
 	stmts, err := sqlpkg.ParseStatements(query)
 	if err != nil || len(stmts) == 0 || stmts[0].Stmt == nil {
 		return false
 	}
-	return sqlpkg.IsReleaseSavepoint(stmts[0].Stmt) && strings.HasPrefix(sqlpkg.GetSavepointName(stmts[0].Stmt), pgrollbackSavepointPrefix)
+	return sqlpkg.IsReleaseSavepoint(stmts[0].Stmt) && d.isUserSavepointName(sqlpkg.GetSavepointName(stmts[0].Stmt))
 }
 
 // IsQueryThatAffectsClaim returns true when the query is one that claimed (BEGIN) or that would release (COMMIT).
 // Callers use this to decide whether to call ReleaseOpenTransaction (e.g. on TCL failure).
-func IsQueryThatAffectsClaim(query string) bool {
-	return IsUserBeginQuery(query) || isUserReleaseQuery(query)
+func (d *realSessionDB) IsQueryThatAffectsClaim(query string) bool {
+	return d.IsUserBeginQuery(query) || d.isUserReleaseQuery(query)
 }
 
 // ClaimOpenTransaction records that the given connection is starting a user transaction (BEGIN).
-// Nested BEGIN on the same connection is allowed; returns ErrOnlyOneTransactionAtATime only
-// when a different connection already has an open transaction.
-func (d *realSessionDB) ClaimOpenTransaction(connID ConnectionID) error {
-	d.mu.Lock()
-	defer d.mu.Unlock()
-	if d.isTransactionHeldByOtherConnectionLocked(connID) {
-		return ErrOnlyOneTransactionAtATime
+// label names the connection for people (its client address, e.g. "127.0.0.1:52586"): it appears in
+// the error other connections get and in "pgrollback status" until the claim is released; "" keeps
+// the label already recorded (nested BEGIN) or falls back to the connection ID.
+// Nested BEGIN on the same connection is allowed; returns a TransactionInUseError only when a
+// different connection still has an open transaction after waiting up to beginWaitTimeout.
+func (d *realSessionDB) ClaimOpenTransaction(connID ConnectionID, label string) error {
+	return d.waitForOpenTransaction(connID, func() {
+		d.connectionWithOpenTx = connID
+		if label != "" {
+			d.openTxHolderLabel = label
+		}
+	})
+}
+
+// OpenTransactionHolder returns who holds the open user transaction (see openTxHolderName), or "" when
+// no connection has one.
+func (d *realSessionDB) OpenTransactionHolder() string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.openTransactionHolderLocked()
+}
+
+// openTransactionHolderLocked is OpenTransactionHolder for callers already holding d.mu.
+func (d *realSessionDB) openTransactionHolderLocked() string {
+	if d.connectionWithOpenTx == 0 {
+		return ""
+	}
+	return openTxHolderName(d.connectionWithOpenTx, d.openTxHolderLabel)
+}
+
+// waitForOpenTransaction blocks until no other connection holds the open transaction, then runs
+// onFree (if any) under d.mu. With beginWaitTimeout == 0 it fails immediately.
+func (d *realSessionDB) waitForOpenTransaction(connID ConnectionID, onFree func()) error {
+	var timer *time.Timer
+	for {
+		d.mu.Lock()
+		if !d.isTransactionHeldByOtherConnectionLocked(connID) {
+			if onFree != nil {
+				onFree()
+			}
+			d.mu.Unlock()
+			if timer != nil {
+				timer.Stop()
+			}
+			return nil
+		}
+		inUse := &TransactionInUseError{Holder: d.connectionWithOpenTx, HolderLabel: d.openTxHolderLabel}
+		if d.beginWaitTimeout <= 0 {
+			d.mu.Unlock()
+			return inUse
+		}
+		if d.openTxReleased == nil {
+			d.openTxReleased = make(chan struct{})
+		}
+		released := d.openTxReleased
+		d.mu.Unlock()
+
+		if timer == nil {
+			timer = time.NewTimer(d.beginWaitTimeout)
+		}
+		select {
+		case <-released:
+		case <-d.contextOrBackground().Done():
+			timer.Stop()
+			return inUse
+		case <-timer.C:
+			return inUse
+		}
 	}
-	d.connectionWithOpenTx = connID
-	return nil
 }
 
 // ReleaseOpenTransaction clears the "one connection has open transaction" flag when the
@@ -234,10 +393,15 @@ func (d *realSessionDB) ReleaseOpenTransaction(connID ConnectionID) {
 	d.releaseOpenTransactionLocked(connID)
 }
 
-// releaseOpenTransactionLocked clears the claim. Caller must hold d.mu.
+// releaseOpenTransactionLocked clears the claim and wakes connections waiting in BEGIN. Caller must hold d.mu.
 func (d *realSessionDB) releaseOpenTransactionLocked(connID ConnectionID) {
 	if d.connectionWithOpenTx == connID {
 		d.connectionWithOpenTx = 0
+		d.openTxHolderLabel = ""
+		if d.openTxReleased != nil {
+			close(d.openTxReleased)
+			d.openTxReleased = nil
+		}
 	}
 }
 
@@ -268,26 +432,106 @@ func (d *realSessionDB) handleRollback(testID string) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if d.SavepointLevel > 0 {
-		savepointName := d.getSavepointNameLocked()
-		return fmt.Sprintf("ROLLBACK TO SAVEPOINT %s; RELEASE SAVEPOINT %s", savepointName, savepointName), nil
+	return d.rollbackRewrite(d.GetSavepointLevel()), nil
+}
+
+// rollbackRewrite returns what a client ROLLBACK becomes with level user transactions open.
+func (d *realSessionDB) rollbackRewrite(level int) string {
+	if level > 0 {
+		savepointName := d.savepointName(level)
+		return fmt.Sprintf("ROLLBACK TO SAVEPOINT %s; RELEASE SAVEPOINT %s", savepointName, savepointName)
+	}
+	return DEFAULT_SELECT_ONE
+}
+
+// handleReset converte "pgrollback reset" em ROLLBACK TO SAVEPOINT pgrollback_v_1; RELEASE SAVEPOINT
+// pgrollback_v_1, descartando todos os savepoints de usuário de uma vez (ApplyTCLSuccessTracking zera
+// SavepointLevel e a contagem da conexão). Savepoints belong to the connection holding the open BEGIN,
+// so another connection cannot reset them (TransactionInUseError). A notice tells the client what was
+// kept, since the command is easily confused with "pgrollback rollback".
+func (d *realSessionDB) handleReset(connID ConnectionID) (string, error) {
+	d.mu.RLock()
+	level := d.GetSavepointLevel()
+	holder, holderLabel := d.connectionWithOpenTx, d.openTxHolderLabel
+	d.mu.RUnlock()
+
+	if holder != 0 && connID != 0 && holder != connID {
+		return "", &TransactionInUseError{Holder: holder, HolderLabel: holderLabel}
 	}
+	if d.notices != nil {
+		d.notices.queue(&pgconn.Notice{
+			Severity: "NOTICE",
+			Code:     "00000",
+			Message:  fmt.Sprintf("pgrollback reset: rolled back %d open transaction level(s); the base transaction is kept", level),
+			Detail:   "Work done outside BEGIN (for example schema created during setup) is still there.",
+			Hint:     "Use \"pgrollback rollback\" to discard the whole base transaction.",
+		})
+	}
+	return d.resetRewrite(level), nil
+}
 
-	return DEFAULT_SELECT_ONE, nil
+// resetRewrite returns what "pgrollback reset" becomes with level user transactions open.
+func (d *realSessionDB) resetRewrite(level int) string {
+	if level == 0 {
+		return DEFAULT_SELECT_ONE
+	}
+	first := d.savepointName(1)
+	return fmt.Sprintf("ROLLBACK TO SAVEPOINT %s; RELEASE SAVEPOINT %s", first, first)
 }
 
 func (d *realSessionDB) buildStatusResultSet(createdAt time.Time, testID string) (string, error) {
 	d.mu.RLock()
 	active := d.hasActiveTransactionLocked()
-	level := d.SavepointLevel
+	level := d.GetSavepointLevel()
+	savepoints := d.savepointStackLocked()
+	openUserTx := d.connectionWithOpenTx != 0
+	holder := d.openTransactionHolderLocked()
 	d.mu.RUnlock()
+	prepared := d.PreparedStatementCount()
+	created, dropped := d.SchemaObjects()
 
 	return fmt.Sprintf(
-		"SELECT '%s' AS test_id, %t AS active, %d AS level, '%s' AS created_at",
-		testID, active, level, createdAt.Format(time.RFC3339),
+		"SELECT '%s' AS test_id, %t AS active, %d AS level, '%s' AS created_at, %d AS prepared_statements, %s AS savepoints, %s AS created_objects, %s AS dropped_objects, %t AS open_user_tx, %s AS open_tx_holder",
+		testID, active, level, createdAt.Format(time.RFC3339), prepared, textArrayLiteral(savepoints),
+		textArrayLiteral(schemaObjectNames(created)), textArrayLiteral(schemaObjectNames(dropped)), openUserTx, textLiteralOrNull(holder),
 	), nil
 }
 
+// savepointStackLocked returns the backend savepoints open on the session transaction, outermost first:
+// <savepoint_prefix>N for each user BEGIN and pgrollback_user_<name> for "pgrollback savepoint" checkpoints.
+// Caller must hold d.mu.
+func (d *realSessionDB) savepointStackLocked() []string {
+	names := make([]string, 0, d.GetSavepointLevel()+len(d.namedSavepoints))
+	next := 0
+	for level := 0; level <= d.GetSavepointLevel(); level++ {
+		if level > 0 {
+			names = append(names, d.savepointName(level))
+		}
+		for next < len(d.namedSavepoints) && d.namedSavepoints[next].level <= level {
+			names = append(names, backendNamedSavepoint(d.namedSavepoints[next].name))
+			next++
+		}
+	}
+	return names
+}
+
+// textArrayLiteral renders values as a SQL text[] expression (ARRAY['a','b']::text[]).
+func textArrayLiteral(values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = "'" + strings.ReplaceAll(v, "'", "''") + "'"
+	}
+	return "ARRAY[" + strings.Join(quoted, ",") + "]::text[]"
+}
+
+// textLiteralOrNull renders value as a SQL text literal, or NULL when it is "".
+func textLiteralOrNull(value string) string {
+	if value == "" {
+		return "NULL::text"
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
 // Query runs a query in the current transaction. Returns an error if there is no active transaction.
 func (d *realSessionDB) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
 	d.mu.RLock()
@@ -302,12 +546,15 @@ func (d *realSessionDB) handleCommit(testID string) (string, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if d.SavepointLevel > 0 {
-		savepointName := d.getSavepointNameLocked()
-		return fmt.Sprintf("RELEASE SAVEPOINT %s", savepointName), nil
-	}
+	return d.commitRewrite(d.GetSavepointLevel()), nil
+}
 
-	return DEFAULT_SELECT_ONE, nil
+// commitRewrite returns what a client COMMIT becomes with level user transactions open.
+func (d *realSessionDB) commitRewrite(level int) string {
+	if level > 0 {
+		return fmt.Sprintf("RELEASE SAVEPOINT %s", d.savepointName(level))
+	}
+	return DEFAULT_SELECT_ONE
 }
 
 func (d *realSessionDB) handleBegin(testID string, connID ConnectionID) (string, error) {
@@ -316,9 +563,8 @@ func (d *realSessionDB) handleBegin(testID string, connID ConnectionID) (string,
 	}
 
 	if connID != 0 {
-		heldByOther := d.isTransactionHeldByOtherConnection(connID)
-		if heldByOther {
-			return "", ErrOnlyOneTransactionAtATime
+		if err := d.waitForOpenTransaction(connID, nil); err != nil {
+			return "", err
 		}
 	}
 
@@ -326,12 +572,19 @@ func (d *realSessionDB) handleBegin(testID string, connID ConnectionID) (string,
 		return "", fmt.Errorf("Failed to Begin a transaction: %w", err)
 	}
 
-	if d.GetSavepointLevel() >= 1 {
-		return DEFAULT_SELECT_ONE, nil
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.beginRewrite(d.GetSavepointLevel()), nil
+}
+
+// beginRewrite returns what a client BEGIN becomes with level user transactions open: the next savepoint,
+// without incrementing; the level is incremented only when the SAVEPOINT is successfully executed (in
+// query_handler).
+func (d *realSessionDB) beginRewrite(level int) string {
+	if level >= 1 {
+		return DEFAULT_SELECT_ONE
 	}
-	// Return the next savepoint name without incrementing; level is incremented only when the SAVEPOINT is successfully executed (in query_handler).
-	name := d.GetNextSavepointName()
-	return fmt.Sprintf("SAVEPOINT %s", name), nil
+	return fmt.Sprintf("SAVEPOINT %s", d.savepointName(level+1))
 }
 
 // Exec runs a command in the current transaction. Returns an error if there is no active transaction.
@@ -356,45 +609,87 @@ func commitSavePoint(ctx context.Context, savepoint pgx.Tx) {
 	}
 }
 
+// SafeQuery runs sql inside the queryGuardSavepoint guard. The guard stays open while the rows are
+// read and is released (or rolled back on error) by the returned rows' Close. For a client statement
+// (see statementContext) the backend's notices are captured until then and relayed by the reader
+// (sendSelectResults), which has the connection to itself while the rows are open.
 func (d *realSessionDB) SafeQuery(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
 	d.Gui.incRunningQueryCount()
 	defer d.Gui.decRunningQueryCount()
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	savePoint, err := d.tx.Begin(ctx)
-	if err != nil || savePoint == nil {
+	if err := d.beginClientStatementLocked(ctx); err != nil {
+		return nil, err
+	}
+	if _, err := d.execTxLocked(ctx, "SAVEPOINT "+queryGuardSavepoint); err != nil {
+		d.endClientStatementLocked(ctx)
 		return nil, fmt.Errorf("Falha ao iniciar savepoint de guarda: %w, sql: '''%s'''", err, sql)
 	}
-	rows, err := savePoint.Query(ctx, sql, args...)
-	errList := []error{}
+	rows, err := d.tx.Query(ctx, sql, args...)
 	if err != nil {
-		errList = append(errList, fmt.Errorf("Falha ao executar consulta due to: %w", err))
-		if rollbackErr := savePoint.Rollback(ctx); rollbackErr != nil {
+		d.endClientStatementLocked(ctx)
+		errList := []error{fmt.Errorf("Falha ao executar consulta due to: %w", err)}
+		if rollbackErr := d.rollbackQueryGuardLocked(ctx); rollbackErr != nil {
 			errList = append(errList, fmt.Errorf("Falha no rollback de guarda: %w", rollbackErr))
 		}
-	} /* else {
-		if commitErr := savePoint.Commit(ctx); commitErr != nil {
-			errList = append(errList, fmt.Errorf("Falha no commit de guarda: %w", commitErr))
-		}
-	}*/
-
-	if len(errList) > 0 {
 		errList = append(errList, fmt.Errorf("For sql: %s", sql))
 		return nil, errors.Join(errList...)
 	}
 	return &guardedRows{
-		Rows:      rows,
-		ctx:       ctx,
-		tx:        d.tx,
-		savePoint: savePoint,
+		Rows: rows,
+		ctx:  ctx,
+		db:   d,
 	}, nil
 }
 
+// finishQueryGuard closes the SafeQuery guard once its rows are closed: released on success,
+// rolled back to and released when rowsErr is set.
+func (d *realSessionDB) finishQueryGuard(ctx context.Context, rowsErr error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.endClientStatementLocked(ctx)
+	if rowsErr != nil {
+		if err := d.rollbackQueryGuardLocked(ctx); err != nil {
+			log.Printf("[PROXY] FATAL: Falha ao reverter savepoint após erro em rows: %v", err)
+		}
+		return
+	}
+	if _, err := d.execTxLocked(ctx, "RELEASE SAVEPOINT "+queryGuardSavepoint); err != nil {
+		log.Printf("[PROXY] Aviso: Falha ao liberar savepoint de guarda: %v", err)
+	}
+}
+
+// rollbackQueryGuardLocked undoes and drops the SafeQuery guard. Caller must hold d.mu.
+func (d *realSessionDB) rollbackQueryGuardLocked(ctx context.Context) error {
+	if _, err := d.execTxLocked(ctx, "ROLLBACK TO SAVEPOINT "+queryGuardSavepoint); err != nil {
+		return err
+	}
+	_, err := d.execTxLocked(ctx, "RELEASE SAVEPOINT "+queryGuardSavepoint)
+	return err
+}
+
+// SafeExec runs sql inside a guard savepoint, so a failure does not abort the base transaction. A single
+// statement without arguments goes to the backend in one round trip with its guard
+// (safeExecBatchedLocked); anything else opens the guard, runs and releases it in three. For a client
+// statement (see statementContext) its notices are left on the statement for relayStatementNotices.
 func (d *realSessionDB) SafeExec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
 	d.Gui.incRunningQueryCount()
 	defer d.Gui.decRunningQueryCount()
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if err := d.beginClientStatementLocked(ctx); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer d.endClientStatementLocked(ctx)
+	if pgConn := d.PgConnLocked(); pgConn != nil && d.hasActiveTransactionLocked() && len(args) == 0 && isSingleStatement(sql) {
+		return d.safeExecBatchedLocked(ctx, pgConn, sql)
+	}
+	return d.safeExecSeparateLocked(ctx, sql, args...)
+}
+
+// safeExecSeparateLocked is SafeExec as a pgx pseudo nested transaction: SAVEPOINT, sql and RELEASE each
+// in their own round trip. Caller must hold d.mu.
+func (d *realSessionDB) safeExecSeparateLocked(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
 	savePoint, execErr := d.tx.Begin(ctx)
 	if execErr != nil {
 		return pgconn.CommandTag{}, fmt.Errorf("Falha ao iniciar savepoint de guarda: %w, pro sql '''%s'''", execErr, sql)
@@ -421,6 +716,58 @@ func (d *realSessionDB) SafeExec(ctx context.Context, sql string, args ...any) (
 	return result, nil
 }
 
+// safeExecBatchedLocked sends "SAVEPOINT g; <sql>; RELEASE SAVEPOINT g" as one simple query and returns
+// the command tag of sql. Rows sql returns are read and dropped, not buffered. When sql fails, PostgreSQL
+// skips the rest of the string and the guard is still open, so a second round trip rolls back to it and
+// releases it. Caller must hold d.mu.
+func (d *realSessionDB) safeExecBatchedLocked(ctx context.Context, pgConn *pgconn.PgConn, sql string) (pgconn.CommandTag, error) {
+	// The line breaks end a trailing "--" comment of sql before the RELEASE.
+	batch := "SAVEPOINT " + execGuardSavepoint + ";\n" + sql + "\n;RELEASE SAVEPOINT " + execGuardSavepoint
+	mrr := pgConn.Exec(ctx, batch)
+	// tags holds the statements that completed, in order; a statement that fails after its RowDescription
+	// is not among them.
+	var tags []pgconn.CommandTag
+	var execErr error
+	for mrr.NextResult() {
+		tag, err := mrr.ResultReader().Close()
+		if err != nil {
+			execErr = err
+			break
+		}
+		tags = append(tags, tag)
+	}
+	if err := mrr.Close(); execErr == nil {
+		execErr = err
+	}
+	if execErr == nil && len(tags) == 3 {
+		return tags[1], nil
+	}
+	// Only statements that completed have a tag: none means the guard itself failed.
+	if len(tags) == 0 {
+		return pgconn.CommandTag{}, fmt.Errorf("Falha ao iniciar savepoint de guarda: %w, pro sql '''%s'''", execErr, sql)
+	}
+	if execErr == nil {
+		execErr = fmt.Errorf("expected 3 results from the guarded statement, got %d", len(tags))
+	}
+	rollback := "ROLLBACK TO SAVEPOINT " + execGuardSavepoint + "; RELEASE SAVEPOINT " + execGuardSavepoint
+	if _, rbErr := pgConn.Exec(ctx, rollback).ReadAll(); rbErr != nil {
+		return pgconn.CommandTag{}, errors.Join(
+			fmt.Errorf("Safe exec failed: %w; sql=%q", execErr, sql),
+			fmt.Errorf("Safe rollback failed: %w", rbErr),
+		)
+	}
+	if len(tags) == 2 {
+		return pgconn.CommandTag{}, fmt.Errorf("Falha no commit de guarda: %w, sql: '''%s'''", execErr, sql)
+	}
+	return pgconn.CommandTag{}, fmt.Errorf("Safe exec failed: %w, sql: '''%s'''", execErr, sql)
+}
+
+// isSingleStatement returns true when query parses to exactly one statement.
+func isSingleStatement(query string) bool {
+	stmts, err := sqlpkg.ParseStatements(query)
+	return err == nil && len(stmts) == 1
+}
+
 // SafeExecTCL runs all TCL (SAVEPOINT, RELEASE, ROLLBACK, ROLLBACK TO SAVEPOINT). SAVEPOINT
 // must run on the main tx so the created savepoint is visible for later ROLLBACK/RELEASE;
 // RELEASE and ROLLBACK run inside a guard so a failure does not abort the main transaction.
@@ -429,6 +776,10 @@ func (d *realSessionDB) SafeExecTCL(ctx context.Context, sql string, args ...any
 	defer d.Gui.decRunningQueryCount()
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	if err := d.beginClientStatementLocked(ctx); err != nil {
+		return pgconn.CommandTag{}, err
+	}
+	defer d.endClientStatementLocked(ctx)
 	return d.safeExecTCLLocked(ctx, sql, args...)
 }
 
@@ -452,6 +803,7 @@ func (d *realSessionDB) safeExecTCLLocked(ctx context.Context, sql string, args
 		return pgconn.CommandTag{}, fmt.Errorf("Safe exec failed: %w, sql: '''%s'''", execErr, sql)
 	}
 	if commandInvalidatesGuardOnSuccess(sql) {
+		d.invalidateClientGUCsLocked()
 		return result, nil
 	}
 	if commitErr := savePoint.Commit(ctx); commitErr != nil {
@@ -496,22 +848,33 @@ func (d *realSessionDB) RollbackUserSavepointsOnDisconnect(ctx context.Context,
 	if count <= 0 {
 		return nil
 	}
-	d.Gui.incRunningQueryCount()
-	defer d.Gui.decRunningQueryCount()
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	qntToRollback := min(d.SavepointLevel, count)
+	return d.rollbackUserSavepointsOnDisconnectLocked(ctx, count)
+}
+
+// rollbackUserSavepointsOnDisconnectLocked is RollbackUserSavepointsOnDisconnect for a caller that
+// already holds d.mu (LockRun), e.g. runDisconnectCleanup. Caller must hold d.mu.
+func (d *realSessionDB) rollbackUserSavepointsOnDisconnectLocked(ctx context.Context, count int) error {
+	if count <= 0 {
+		return nil
+	}
+	d.Gui.incRunningQueryCount()
+	defer d.Gui.decRunningQueryCount()
+	qntToRollback := min(d.GetSavepointLevel(), count)
 	if qntToRollback <= 0 {
 		return nil
 	}
-	newSpQnt := d.SavepointLevel - qntToRollback
-	spName := fmt.Sprintf("pgrollback_v_%d", newSpQnt+1)
+	newSpQnt := d.GetSavepointLevel() - qntToRollback
+	spName := d.savepointName(newSpQnt + 1)
 	sql := fmt.Sprintf("ROLLBACK TO SAVEPOINT %s; RELEASE SAVEPOINT %s", spName, spName)
 	if _, err := d.safeExecTCLLocked(ctx, sql); err != nil {
 		logIfVerbose("[PROXY] RollbackUserSavepointsOnDisconnect: %v", err)
 		return err
 	}
-	d.SavepointLevel = newSpQnt
+	d.setSavepointLevelLocked(newSpQnt)
+	d.discardSchemaChangesFrom(newSpQnt + 1)
+	d.invalidateClientGUCsLocked()
 	return nil
 }
 
@@ -554,18 +917,41 @@ func (d *realSessionDB) rollbackTx(ctx context.Context) error {
 	}
 	err := d.tx.Rollback(ctx)
 	d.tx = nil
+	d.invalidateClientGUCsLocked()
 	return err
 }
 
 // startNewTx runs ROLLBACK on the connection (to clear any failed state) and begins a new transaction.
-// Used by "pgrollback rollback" to get a clean transaction.
+// Used by "pgrollback rollback" to get a clean transaction. Without a connection (the session was
+// destroyed) it does nothing. When the connection cannot be brought back in sync with the backend, or
+// turns out to be dead, it is replaced, as after a lost connection (see reconnect), instead of being used
+// in a broken state.
 func (d *realSessionDB) startNewTx(ctx context.Context) error {
 	d.mu.Lock()
-	defer d.mu.Unlock()
 	if d.conn == nil {
+		d.mu.Unlock()
 		return nil
 	}
-	d.conn.PgConn().SyncConn(ctx)
+	err := d.conn.PgConn().SyncConn(ctx)
+	if err == nil {
+		// SyncConn only looks at the local state, so a connection the backend dropped gets here too.
+		if err = d.restartTxLocked(ctx); err == nil || !d.backendDeadLocked() {
+			d.mu.Unlock()
+			return err
+		}
+	}
+	canDial := d.dial != nil
+	d.mu.Unlock()
+	if !canDial {
+		return fmt.Errorf("backend connection unusable: %w", err)
+	}
+	log.Printf("[PROXY] backend connection unusable (%v); reconnecting to start a new transaction", err)
+	_, err = d.reconnect(ctx)
+	return err
+}
+
+// restartTxLocked is the body of startNewTx on a connection in sync. Caller must hold d.mu.
+func (d *realSessionDB) restartTxLocked(ctx context.Context) error {
 	if d.hasActiveTransactionLocked() {
 		if err := d.tx.Rollback(ctx); err != nil {
 			logIfVerbose("Failed to rollback on starting a new Tx: %s", err)
@@ -575,20 +961,25 @@ func (d *realSessionDB) startNewTx(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
+	d.namedSavepoints = nil
+	d.clearSchemaChanges()
+	d.invalidateClientGUCsLocked()
 	newTx, err := d.conn.Begin(ctx)
 	if err != nil {
 		return fmt.Errorf("begin new transaction: %w", err)
 	}
 	d.tx = newTx
-	return nil
+	return d.runSessionSetupLocked(ctx)
 }
 
 // stopKeepaliveUnlocked clears the keepalive callback under a brief lock, then invokes it
 // without holding d.mu. The keepalive goroutine acquires mu for Ping; waiting for it to exit
 // while holding mu would deadlock with that goroutine.
-func (d *realSessionDB) stopKeepaliveLocked() {
+func (d *realSessionDB) stopKeepaliveUnlocked() {
+	d.mu.Lock()
 	stopFn := d.stopKeepalive
 	d.stopKeepalive = nil
+	d.mu.Unlock()
 	if stopFn != nil {
 		stopFn()
 	}
@@ -596,15 +987,24 @@ func (d *realSessionDB) stopKeepaliveLocked() {
 
 // close rolls back the current transaction (if any), stops keepalive, and closes the connection.
 func (d *realSessionDB) close(ctx context.Context) error {
+	d.stopKeepaliveUnlocked()
+
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	d.stopKeepaliveLocked()
 
 	d.Gui.ClearQueryHistory()
+	d.clearSchemaChanges()
+
+	if d.readConn != nil {
+		if err := d.readConn.close(ctx); err != nil {
+			log.Printf("[PROXY] Failed to close read connection: %v", err)
+		}
+	}
 
 	if d.conn == nil {
 		return nil
 	}
+	d.dropIsolationSchemaLocked(ctx)
 	if err := d.conn.Close(ctx); err != nil {
 		return fmt.Errorf("failed to close connection: %w", err)
 	}
@@ -616,12 +1016,18 @@ func (d *realSessionDB) close(ctx context.Context) error {
 func (d *realSessionDB) startKeepalive(interval time.Duration) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
-	if d.conn == nil || interval <= 0 {
+	if d.conn == nil || interval <= 0 || d.stopKeepalive != nil {
 		return
 	}
+	d.stopKeepalive = runKeepalive(interval, d.pingKeepaliveOnce)
+}
+
+// runKeepalive calls ping every interval in its own goroutine until the returned stop function is
+// called; stop waits for the goroutine to exit, so no ping runs after it returns.
+func runKeepalive(interval time.Duration, ping func()) (stop func()) {
 	ctx, cancel := context.WithCancel(context.Background())
 	done := make(chan struct{})
-	ticker := time.NewTicker(interval * 10000) //Danilo só pra n chamar isso mais
+	ticker := time.NewTicker(interval)
 	go func() {
 		defer close(done)
 		defer ticker.Stop()
@@ -630,11 +1036,11 @@ func (d *realSessionDB) startKeepalive(interval time.Duration) {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				d.pingKeepaliveOnce()
+				ping()
 			}
 		}
 	}()
-	d.stopKeepalive = func() {
+	return func() {
 		cancel()
 		<-done
 	}
@@ -650,18 +1056,38 @@ func (d *realSessionDB) pingKeepaliveOnce() {
 	pingCancel()
 }
 
-// acquireAdvisoryLock runs pg_advisory_lock on the connection.
-// Does not hold d.mu during the blocking pg_advisory_lock call (can wait for other sessions);
-// SafeExec and other paths can still take d.mu without being starved.
-func (d *realSessionDB) acquireAdvisoryLock(ctx context.Context, lockKey int64) error {
-	d.mu.RLock()
-	conn := d.conn
-	d.mu.RUnlock()
-	if conn == nil {
-		return fmt.Errorf("connection is nil")
+// acquireAdvisoryLock takes the advisory lock with pg_try_advisory_lock, retrying every
+// advisoryLockRetryInterval until it succeeds or timeout elapses (ErrAdvisoryLockTimeout).
+// Each try is short, so d.mu is only held for the try itself and is free while waiting:
+// SafeExec and other paths are not starved by a lock held elsewhere.
+func (d *realSessionDB) acquireAdvisoryLock(ctx context.Context, lockKey int64, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		acquired, err := d.tryAdvisoryLock(ctx, lockKey)
+		if err != nil || acquired {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%w (key %d, waited %s)", ErrAdvisoryLockTimeout, lockKey, timeout)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(advisoryLockRetryInterval):
+		}
 	}
-	_, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey)
-	return err
+}
+
+// tryAdvisoryLock runs pg_try_advisory_lock once on the connection.
+func (d *realSessionDB) tryAdvisoryLock(ctx context.Context, lockKey int64) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn == nil {
+		return false, fmt.Errorf("connection is nil")
+	}
+	var acquired bool
+	err := d.conn.QueryRow(ctx, "SELECT pg_try_advisory_lock($1)", lockKey).Scan(&acquired)
+	return acquired, err
 }
 
 // releaseAdvisoryLock runs pg_advisory_unlock on the connection.
@@ -712,9 +1138,13 @@ func (d *realSessionDB) Tx() pgx.Tx {
 // newSessionDB creates a realSessionDB with the given connection and transaction (caller must have begun tx on conn).
 func newSessionDB(conn *pgx.Conn, tx pgx.Tx, ctx context.Context) *realSessionDB {
 	d := &realSessionDB{
-		conn: conn,
-		tx:   tx,
-		ctx:  ctx,
+		conn:       conn,
+		tx:         tx,
+		ctx:        ctx,
+		gucApplied: map[string]string{},
+	}
+	if conn != nil {
+		d.cancelConn.Store(conn.PgConn())
 	}
 	return d
 }
@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+)
+
+func TestSavepointStackLocked_InterleavesUserAndNamedSavepoints(t *testing.T) {
+	d := newTestSessionDB()
+	d.SavepointLevel.Store(2)
+	d.namedSavepoints = []namedSavepoint{{name: "seed", level: 0}, {name: "mid", level: 1}}
+
+	got := strings.Join(d.savepointStackLocked(), ",")
+	want := "pgrollback_user_seed,pgrollback_v_1,pgrollback_user_mid,pgrollback_v_2"
+	if got != want {
+		t.Fatalf("savepointStackLocked = %s, want %s", got, want)
+	}
+}
+
+func TestBuildStatusResultSet_KeepsColumnOrderAndAddsSavepoints(t *testing.T) {
+	d := newTestSessionDB()
+	d.SavepointLevel.Store(1)
+	d.connectionWithOpenTx = 7
+
+	query, err := d.buildStatusResultSet(time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC), "t1")
+	if err != nil {
+		t.Fatalf("buildStatusResultSet: %v", err)
+	}
+	prefix := "SELECT 't1' AS test_id, false AS active, 1 AS level, '2024-01-02T03:04:05Z' AS created_at, "
+	if !strings.HasPrefix(query, prefix) {
+		t.Errorf("query = %s, want the original four columns first", query)
+	}
+	if !strings.Contains(query, "ARRAY['pgrollback_v_1']::text[] AS savepoints") {
+		t.Errorf("query = %s, want savepoints text[] column", query)
+	}
+	if !strings.HasSuffix(query, "true AS open_user_tx, '0x7' AS open_tx_holder") {
+		t.Errorf("query = %s, want open_user_tx then open_tx_holder last", query)
+	}
+
+	d.openTxHolderLabel = "127.0.0.1:52586"
+	if query, _ := d.buildStatusResultSet(time.Now(), "t1"); !strings.HasSuffix(query, "'127.0.0.1:52586' AS open_tx_holder") {
+		t.Errorf("query = %s, want the holder address", query)
+	}
+	d.releaseOpenTransactionLocked(7)
+	if query, _ := d.buildStatusResultSet(time.Now(), "t1"); !strings.HasSuffix(query, "false AS open_user_tx, NULL::text AS open_tx_holder") {
+		t.Errorf("query = %s, want NULL holder after release", query)
+	}
+}
+
+func TestTextArrayLiteral_EmptyAndQuoted(t *testing.T) {
+	if got := textArrayLiteral(nil); got != "ARRAY[]::text[]" {
+		t.Errorf("textArrayLiteral(nil) = %s", got)
+	}
+	if got := textArrayLiteral([]string{"a'b"}); got != "ARRAY['a''b']::text[]" {
+		t.Errorf("textArrayLiteral = %s", got)
+	}
+}
+
+func TestHandleReset_RollsBackToFirstUserSavepoint(t *testing.T) {
+	d := newTestSessionDB()
+	d.notices = &backendNotices{}
+
+	if got, err := d.handleReset(1); err != nil || got != DEFAULT_SELECT_ONE {
+		t.Errorf("reset with no open BEGIN = %q, %v; want %q", got, err, DEFAULT_SELECT_ONE)
+	}
+
+	d.SavepointLevel.Store(3)
+	d.connectionWithOpenTx = 1
+	got, err := d.handleReset(1)
+	if err != nil || got != "ROLLBACK TO SAVEPOINT pgrollback_v_1; RELEASE SAVEPOINT pgrollback_v_1" {
+		t.Fatalf("reset = %q, %v", got, err)
+	}
+	notices := d.notices.drain()
+	if len(notices) != 2 || !strings.Contains(notices[1].Message, "3 open transaction level(s)") || !strings.Contains(notices[1].Hint, "pgrollback rollback") {
+		t.Errorf("notices = %+v, want one per reset explaining the difference from pgrollback rollback", notices)
+	}
+
+	var inUse *TransactionInUseError
+	if _, err := d.handleReset(2); !errors.As(err, &inUse) || inUse.Holder != 1 {
+		t.Errorf("reset from another connection = %v; want TransactionInUseError held by 1", err)
+	}
+}
+
+func TestDecrementSavepointLevel_DropsCheckpointsOfReleasedLevel(t *testing.T) {
+	d := newTestSessionDB()
+	d.SavepointLevel.Store(2)
+	d.namedSavepoints = []namedSavepoint{{name: "seed", level: 0}, {name: "mid", level: 1}, {name: "top", level: 2}}
+
+	d.DecrementSavepointLevel()
+	d.DecrementSavepointLevel()
+	if got := d.NamedSavepoints(); len(got) != 1 || got[0] != "seed" {
+		t.Errorf("named savepoints after releasing both levels = %v, want [seed]", got)
+	}
+}
+
+func TestStartNewTx_NilConnIsANoOp(t *testing.T) {
+	d, backend := newFakeSessionDB()
+	d.SavepointLevel.Store(2)
+	d.namedSavepoints = []namedSavepoint{{name: "seed", level: 0}}
+
+	if err := d.startNewTx(context.Background()); err != nil {
+		t.Fatalf("startNewTx without a connection = %v, want nil", err)
+	}
+	if got := backend.Executed(); len(got) != 0 {
+		t.Errorf("startNewTx ran %q without a connection", got)
+	}
+	if d.GetSavepointLevel() != 2 || len(d.namedSavepoints) != 1 || !d.HasActiveTransaction() {
+		t.Error("startNewTx changed the session state without a connection")
+	}
+	if _, err := (&TestSession{DB: d}).RollbackBaseTransaction("t1"); err != nil {
+		t.Errorf("RollbackBaseTransaction without a connection = %v", err)
+	}
+}
+
+func TestStartNewTx_ReconnectsWhenOutOfSync(t *testing.T) {
+	d, backend := newWireSessionDB(t, 0)
+	ctx := context.Background()
+	d.dial = func(ctx context.Context) (*pgx.Conn, error) { return backend.connect(ctx, d.notices) }
+	d.SavepointLevel.Store(1)
+	d.namedSavepoints = []namedSavepoint{{name: "seed", level: 0}}
+
+	// The backend goes away: SyncConn cannot bring the connection back in sync.
+	oldConn := d.conn
+	oldConn.PgConn().Conn().Close()
+	if err := d.startNewTx(ctx); err != nil {
+		t.Fatalf("startNewTx = %v, want a reconnect", err)
+	}
+	if d.conn == oldConn || d.BackendGeneration() != 1 {
+		t.Fatalf("backend connection not replaced (generation %d)", d.BackendGeneration())
+	}
+	if !d.HasActiveTransaction() || d.GetSavepointLevel() != 0 || len(d.NamedSavepoints()) != 0 {
+		t.Errorf("after the reconnect: active=%v level=%d named=%v; want a fresh base transaction", d.HasActiveTransaction(), d.GetSavepointLevel(), d.NamedSavepoints())
+	}
+	if queries := backend.Queries(); len(queries) != 1 || queries[0] != "begin" {
+		t.Errorf("backend queries = %q, want the BEGIN of the new base transaction", queries)
+	}
+	if _, err := d.SafeExec(ctx, "INSERT INTO t VALUES (1)"); err != nil {
+		t.Errorf("statement on the new base transaction: %v", err)
+	}
+}
+
+// GetSavepointLevel and GetSavepointName do not take d.mu; run with -race to check them against writers.
+func TestGetSavepointLevel_ConcurrentWithTCL(t *testing.T) {
+	d := newTestSessionDB()
+	const rounds = 1000
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			d.IncrementSavepointLevel()
+			d.DecrementSavepointLevel()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if level := d.GetSavepointLevel(); level < 0 || level > 1 {
+				t.Errorf("GetSavepointLevel() = %d, want 0 or 1", level)
+				return
+			}
+			if name := d.GetSavepointName(); name != "pgrollback_v_0" && name != "pgrollback_v_1" {
+				t.Errorf("GetSavepointName() = %q", name)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+	if got := d.GetSavepointLevel(); got != 0 {
+		t.Errorf("GetSavepointLevel() after balanced TCL = %d, want 0", got)
+	}
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"pgrollback/pkg/sql"
+)
+
+// Session setup statements (postgres.session_setup_sql).
+//
+// The configured statements run in every new base transaction, right after its BEGIN: when the session
+// is created, and again when "pgrollback rollback", a persist-mode COMMIT/ROLLBACK or a reconnect starts
+// a new one, since the rollback undid them. They are rolled back with everything else the test did. A
+// failure at creation fails the session (the client gets the error on its first query); a failure later
+// is returned by the command that started the new transaction, and the setup's partial work is undone.
+//
+// A session-level SET of a per-connection parameter (search_path, statement_timeout, timezone, role) becomes
+// that parameter's default for the session's connections, so the proxy's own re-application of
+// connection defaults (client_gucs.go) keeps it instead of resetting it.
+
+// sessionSetupGUCs returns, for the parameters in trackedClientGUCs, the statement the setup's last
+// session-level SET of each leaves in effect. Statements that do not parse are skipped: they fail when run.
+func sessionSetupGUCs(statements []string) map[string]string {
+	var gucs map[string]string
+	for _, text := range statements {
+		stmts, err := sql.ParseStatements(text)
+		if err != nil {
+			continue
+		}
+		for _, st := range stmts {
+			vs, ok := sql.ParseVariableSet(st.Stmt)
+			switch {
+			case !ok || vs.IsLocal:
+			case vs.ResetAll:
+				gucs = keepRoleGUCs(gucs)
+			case !isTrackedClientGUC(vs.Name):
+			case vs.IsReset:
+				delete(gucs, vs.Name)
+			default:
+				gucs = setOrDeleteGUC(gucs, vs.Name, vs.SetSQL)
+			}
+		}
+	}
+	return gucs
+}
+
+// runSessionSetup runs the setup statements at session creation (see runSessionSetupLocked).
+func (d *realSessionDB) runSessionSetup(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.runSessionSetupLocked(ctx)
+}
+
+// runSessionSetupLocked runs the setup statements in the base transaction, inside a savepoint guard so a
+// failing statement leaves the transaction usable. Caller must hold d.mu.
+func (d *realSessionDB) runSessionSetupLocked(ctx context.Context) error {
+	if len(d.setupSQL) == 0 || !d.hasActiveTransactionLocked() {
+		return nil
+	}
+	return d.runWithSavepointGuardLocked(ctx, "pgrollback_setup_guard", func() error {
+		for i, stmt := range d.setupSQL {
+			if _, err := d.tx.Exec(ctx, stmt); err != nil {
+				return fmt.Errorf("postgres.session_setup_sql[%d] failed: %w", i, err)
+			}
+		}
+		return nil
+	})
+}
+
+// withSetupGUCsLocked returns want with the default of each parameter the setup SETs replaced by the
+// setup's value. Caller must hold d.mu.
+func (d *realSessionDB) withSetupGUCsLocked(want map[string]string) map[string]string {
+	if len(d.setupGUCs) == 0 {
+		return want
+	}
+	out := make(map[string]string, len(want))
+	for name, stmt := range want {
+		if setup, ok := d.setupGUCs[name]; ok && stmt == defaultGUCSQL(name) {
+			stmt = setup
+		}
+		out[name] = stmt
+	}
+	return out
+}
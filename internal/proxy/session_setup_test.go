@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSessionSetupGUCs(t *testing.T) {
+	got := sessionSetupGUCs([]string{
+		"CREATE SCHEMA IF NOT EXISTS suite_a",
+		"SET search_path = suite_a, public; SET LOCAL statement_timeout = '1s'",
+		"SET work_mem = '64MB'",
+		"SET timezone = 'UTC'",
+		"RESET timezone",
+		"not sql at all",
+	})
+	want := map[string]string{"search_path": "SET search_path TO suite_a, public"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("sessionSetupGUCs = %v, want %v", got, want)
+	}
+}
+
+func TestRunSessionSetup_RunsStatementsInBaseTransaction(t *testing.T) {
+	db, backend := newFakeSessionDB()
+	db.setupSQL = []string{"CREATE SCHEMA IF NOT EXISTS suite_a", "CREATE TABLE suite_a.t (id int)"}
+	if err := db.runSessionSetup(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"SAVEPOINT pgrollback_setup_guard",
+		"CREATE SCHEMA IF NOT EXISTS suite_a",
+		"CREATE TABLE suite_a.t (id int)",
+		"RELEASE SAVEPOINT pgrollback_setup_guard",
+	}
+	if got := backend.Executed(); !reflect.DeepEqual(got, want) {
+		t.Errorf("executed %q, want %q", got, want)
+	}
+}
+
+func TestRunSessionSetup_FailureNamesStatementAndUndoesSetup(t *testing.T) {
+	db, backend := newFakeSessionDB()
+	db.setupSQL = []string{"CREATE SCHEMA suite_a", "CREATE TABLE oops ("}
+	err := db.runSessionSetup(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "postgres.session_setup_sql[1] failed") {
+		t.Fatalf("expected the failing entry in the error, got %v", err)
+	}
+	if sp := backend.Savepoints(); len(sp) != 0 {
+		t.Errorf("savepoint guard left open: %v", sp)
+	}
+	executed := backend.Executed()
+	if last := executed[len(executed)-1]; last != "RELEASE SAVEPOINT pgrollback_setup_guard" {
+		t.Errorf("setup not undone, last statement %q", last)
+	}
+}
+
+func TestApplyClientGUCs_KeepsSetupSearchPath(t *testing.T) {
+	db, backend := newFakeSessionDB()
+	db.setupGUCs = sessionSetupGUCs([]string{"SET search_path = suite_a, public"})
+	if err := db.applyClientGUCs(context.Background(), defaultClientGUCs()); err != nil {
+		t.Fatal(err)
+	}
+	joined := strings.Join(backend.Executed(), "\n")
+	if !strings.Contains(joined, "SET search_path TO suite_a, public") || strings.Contains(joined, "RESET search_path") {
+		t.Errorf("connection defaults did not keep the setup's search_path:\n%s", joined)
+	}
+}
@@ -0,0 +1,63 @@
+package proxy
+
+import (
+	"sort"
+	"time"
+)
+
+// SessionSnapshot is an immutable copy of a session's state at one instant, for embedders that use
+// Server as a library (custom dashboards, test harness assertions). Unlike the live TestSession it
+// is safe to keep and read without any lock.
+type SessionSnapshot struct {
+	TestID         string
+	SavepointLevel int       // nesting of client BEGINs (savepoints)
+	Active         bool      // the session still holds its base transaction
+	OpenUserTx     bool      // a client connection holds an open BEGIN
+	OpenTxHolder   string    // client address of the connection holding it; "" when OpenUserTx is false
+	CreatedAt      time.Time // when the session (and its backend connection) was created
+	LastActivity   time.Time
+	LastQuery      string   // text of the last logged query; "" when none
+	CreatedObjects []string // schema objects a full rollback will discard, e.g. "table public.t" (see schema_objects.go)
+	DroppedObjects []string // schema objects dropped by the session that a full rollback will bring back
+}
+
+// Snapshot copies the session's state. Session fields are read under s.mu and the transaction state
+// under one DB.mu read lock, so level, Active, OpenUserTx and OpenTxHolder are consistent with each other.
+func (s *TestSession) Snapshot() SessionSnapshot {
+	s.mu.RLock()
+	snap := SessionSnapshot{
+		TestID:       s.TestID,
+		CreatedAt:    s.CreatedAt,
+		LastActivity: s.LastActivity,
+	}
+	s.mu.RUnlock()
+	if s.DB == nil {
+		return snap
+	}
+	s.DB.mu.RLock()
+	snap.SavepointLevel = s.DB.GetSavepointLevel()
+	snap.Active = s.DB.hasActiveTransactionLocked()
+	snap.OpenUserTx = s.DB.connectionWithOpenTx != 0
+	snap.OpenTxHolder = s.DB.openTransactionHolderLocked()
+	s.DB.mu.RUnlock()
+	snap.LastQuery = s.DB.Gui.GetLastQuery()
+	created, dropped := s.DB.SchemaObjects()
+	snap.CreatedObjects = schemaObjectNames(created)
+	snap.DroppedObjects = schemaObjectNames(dropped)
+	return snap
+}
+
+// Sessions returns a snapshot of every live session, sorted by test ID. The session map is copied
+// under PgRollback.mu and released before the snapshots are taken, so a slow statement on one
+// session never blocks session creation elsewhere.
+func (s *Server) Sessions() []SessionSnapshot {
+	sessions := s.PgRollback.GetAllSessions()
+	list := make([]SessionSnapshot, 0, len(sessions))
+	for testID, session := range sessions {
+		snap := session.Snapshot()
+		snap.TestID = testID
+		list = append(list, snap)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].TestID < list[j].TestID })
+	return list
+}
@@ -0,0 +1,47 @@
+package proxy
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestServerSessions_SnapshotsSortedCopies(t *testing.T) {
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	s := &Server{PgRollback: pgr}
+	created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	db := newTestSessionDB()
+	db.SavepointLevel.Store(2)
+	db.connectionWithOpenTx = 7
+	db.openTxHolderLabel = "127.0.0.1:52586"
+	db.Gui.SetLastQuery("SELECT 42")
+	busy := &TestSession{DB: db, TestID: "b_busy", CreatedAt: created, LastActivity: created.Add(time.Minute)}
+	pgr.SessionsByTestID["b_busy"] = busy
+	pgr.SessionsByTestID["a_empty"] = &TestSession{CreatedAt: created}
+
+	got := s.Sessions()
+	if len(got) != 2 || got[0].TestID != "a_empty" || got[1].TestID != "b_busy" {
+		t.Fatalf("Sessions() = %+v, want a_empty then b_busy", got)
+	}
+	want := SessionSnapshot{
+		TestID:         "b_busy",
+		SavepointLevel: 2,
+		OpenUserTx:     true,
+		OpenTxHolder:   "127.0.0.1:52586",
+		CreatedAt:      created,
+		LastActivity:   created.Add(time.Minute),
+		LastQuery:      "SELECT 42",
+		CreatedObjects: []string{},
+		DroppedObjects: []string{},
+	}
+	if !reflect.DeepEqual(got[1], want) {
+		t.Errorf("snapshot = %+v, want %+v", got[1], want)
+	}
+
+	db.SavepointLevel.Store(0)
+	busy.LastActivity = created.Add(time.Hour)
+	if got[1].SavepointLevel != 2 || !got[1].LastActivity.Equal(created.Add(time.Minute)) {
+		t.Error("a snapshot must not change with the live session")
+	}
+}
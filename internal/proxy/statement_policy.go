@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"fmt"
+	"strings"
+
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Statement allow and deny lists (proxy.allowed_statements, proxy.denied_statements).
+//
+// Entries name statements the way sql.StatementTag does ("DROP DATABASE", "ALTER SYSTEM", "CREATE ROLE",
+// "SELECT"), or by their first words ("DROP" covers every DROP, "ALTER" every ALTER), in any case.
+// InterceptQuery checks every statement of a client query before anything reaches the backend: a
+// statement the denied list matches, or, when the allowed list is set, one it does not match, fails the
+// whole query with 42501 insufficient_privilege. Transaction control (BEGIN, COMMIT, ROLLBACK, SAVEPOINT,
+// RELEASE) passes the allowed list, since the proxy emulates it; the denied list can still block it.
+// pgrollback commands are never checked, and a query that does not parse is left to PostgreSQL.
+
+// NormalizeStatementList upper-cases the entries of a statement list and collapses their spaces, dropping
+// empty ones.
+func NormalizeStatementList(entries []string) []string {
+	var out []string
+	for _, e := range entries {
+		if e = strings.Join(strings.Fields(strings.ToUpper(e)), " "); e != "" {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// statementListMatches reports whether an entry of list is tag or its first words.
+func statementListMatches(list []string, tag string) bool {
+	for _, e := range list {
+		if e == tag || strings.HasPrefix(tag, e+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func isTransactionControlTag(tag string) bool {
+	switch tag {
+	case "BEGIN", "COMMIT", "ROLLBACK", "SAVEPOINT", "RELEASE":
+		return true
+	}
+	return false
+}
+
+// checkStatementPolicy returns the 42501 error for the first statement of query the lists reject.
+func (p *PgRollback) checkStatementPolicy(query string) error {
+	if len(p.AllowedStatements) == 0 && len(p.DeniedStatements) == 0 {
+		return nil
+	}
+	stmts, err := sql.ParseStatements(query)
+	if err != nil {
+		return nil
+	}
+	for _, st := range stmts {
+		tag := sql.StatementTag(st.Stmt)
+		switch {
+		case statementListMatches(p.DeniedStatements, tag):
+			return statementPolicyError(tag, "it is in proxy.denied_statements")
+		case len(p.AllowedStatements) > 0 && !isTransactionControlTag(tag) && !statementListMatches(p.AllowedStatements, tag):
+			return statementPolicyError(tag, "it is not in proxy.allowed_statements")
+		}
+	}
+	return nil
+}
+
+func statementPolicyError(tag, reason string) error {
+	return &pgconn.PgError{
+		Severity: "ERROR",
+		Code:     "42501",
+		Message:  fmt.Sprintf("permission denied for %s: blocked by pgrollback", tag),
+		Detail:   fmt.Sprintf("The statement was not run because %s.", reason),
+	}
+}
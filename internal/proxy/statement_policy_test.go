@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestStatementPolicy_DeniedList(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	pgr.DeniedStatements = NormalizeStatementList([]string{"alter  system", "DROP DATABASE", "create role"})
+	pgr.newFakeTestSession("policy")
+
+	for _, q := range []string{"ALTER SYSTEM SET work_mem = '1GB'", "DROP DATABASE app", "SELECT 1; CREATE ROLE intruder"} {
+		_, err := pgr.InterceptQuery("policy", q, 0)
+		var pgErr *pgconn.PgError
+		if !errors.As(err, &pgErr) || pgErr.Code != "42501" {
+			t.Errorf("InterceptQuery(%q) = %v, want 42501", q, err)
+		}
+	}
+	for _, q := range []string{"SELECT 1", "DROP TABLE t", "CREATE TABLE t (id int)", "pgrollback status"} {
+		if _, err := pgr.InterceptQuery("policy", q, 0); err != nil {
+			t.Errorf("InterceptQuery(%q) = %v, want it to pass", q, err)
+		}
+	}
+}
+
+func TestStatementPolicy_AllowedList(t *testing.T) {
+	pgr := NewPgRollback("localhost", 5432, "postgres", "postgres", "", time.Second, time.Hour, 0)
+	pgr.AllowedStatements = NormalizeStatementList([]string{"SELECT", "INSERT", "UPDATE", "DELETE"})
+	pgr.DeniedStatements = NormalizeStatementList([]string{"COMMIT"})
+
+	for q, allowed := range map[string]bool{
+		"SELECT * FROM t":                         true,
+		"insert into t values (1)":                true,
+		"SAVEPOINT a":                             true,
+		"RELEASE SAVEPOINT a":                     true,
+		"COMMIT":                                  false,
+		"TRUNCATE t":                              false,
+		"CREATE TABLE t (id int)":                 false,
+		"INSERT INTO t VALUES (1); DROP t2":       true, // does not parse: left to PostgreSQL
+		"INSERT INTO t VALUES (1); DROP TABLE t2": false,
+	} {
+		if err := pgr.checkStatementPolicy(q); (err == nil) != allowed {
+			t.Errorf("checkStatementPolicy(%q) = %v, want allowed=%v", q, err, allowed)
+		}
+	}
+}
+
+func TestStatementListMatches(t *testing.T) {
+	list := NormalizeStatementList([]string{"drop", " ALTER   TABLE ", ""})
+	if len(list) != 2 {
+		t.Fatalf("NormalizeStatementList = %q", list)
+	}
+	for tag, want := range map[string]bool{"DROP TABLE": true, "DROP DATABASE": true, "ALTER TABLE": true, "ALTER SYSTEM": false, "DROPX": false} {
+		if got := statementListMatches(list, tag); got != want {
+			t.Errorf("statementListMatches(%q) = %v, want %v", tag, got, want)
+		}
+	}
+}
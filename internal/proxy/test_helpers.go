@@ -44,6 +44,37 @@ func NewTestSessionWithLevel(pgrollback *PgRollback, testID string) *TestSession
 	if err != nil {
 		return nil
 	}
+	return openTestSessionLevel(pgrollback, session, testID)
+}
+
+// NewFakeTestSessionForTesting registra para testID uma sessão sobre o backend em memória de
+// newFakeSessionDB (sem PostgreSQL): SQL executado é só registrado e os savepoints são simulados.
+func NewFakeTestSessionForTesting(pgrollback *PgRollback, testID string) *TestSession {
+	session, _ := pgrollback.newFakeTestSession(testID)
+	return session
+}
+
+// NewFakeTestSessionWithLevel é NewTestSessionWithLevel sobre o backend em memória.
+func NewFakeTestSessionWithLevel(pgrollback *PgRollback, testID string) *TestSession {
+	session, _ := pgrollback.newFakeTestSession(testID)
+	return openTestSessionLevel(pgrollback, session, testID)
+}
+
+// FakeSavepointsForTesting returns the savepoints open on the in-memory backend of a session created by
+// NewFakeTestSessionForTesting, outermost first; nil for a session on a real connection.
+func FakeSavepointsForTesting(session *TestSession) []string {
+	if session == nil || session.DB == nil {
+		return nil
+	}
+	tx, ok := session.DB.Tx().(*fakeTx)
+	if !ok {
+		return nil
+	}
+	return tx.backend.Savepoints()
+}
+
+// openTestSessionLevel runs a BEGIN (SAVEPOINT) on session and applies the claim and level increment.
+func openTestSessionLevel(pgrollback *PgRollback, session *TestSession, testID string) *TestSession {
 	if session.DB == nil || !session.DB.HasActiveTransaction() {
 		return nil
 	}
@@ -54,7 +85,7 @@ func NewTestSessionWithLevel(pgrollback *PgRollback, testID string) *TestSession
 	if _, err := session.DB.Tx().Exec(context.Background(), q); err != nil {
 		return nil
 	}
-	if err := session.DB.ClaimOpenTransaction(testSetupConnectionID); err != nil {
+	if err := session.DB.ClaimOpenTransaction(testSetupConnectionID, ""); err != nil {
 		return nil
 	}
 	session.DB.IncrementSavepointLevel()
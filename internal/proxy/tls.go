@@ -0,0 +1,45 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+)
+
+// LoadTLSConfig builds the server-side TLS config from a PEM certificate and key (proxy.tls_cert / proxy.tls_key).
+// Returns (nil, nil) when both paths are empty, meaning TLS stays disabled.
+func LoadTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("TLS requires both tls_cert and tls_key (got cert=%q key=%q)", certFile, keyFile)
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate %s / key %s: %w", certFile, keyFile, err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+// replySSLAcceptedThenStartup answers SSLRequest with 'S', performs the TLS handshake and runs the
+// normal startup flow over the encrypted connection. The GUI peek in acceptConnections already ran on
+// the plaintext SSLRequest bytes, so nothing here needs to know about it.
+func (s *Server) replySSLAcceptedThenStartup(clientConn net.Conn) {
+	if err := WriteSSLResponse(clientConn, true); err != nil {
+		log.Printf("Error writing SSL response: %v", err)
+		return
+	}
+	tlsConn := tls.Server(clientConn, s.tlsConfig)
+	if err := tlsConn.Handshake(); err != nil {
+		logIfVerbose("[SERVER] TLS handshake failed from %s: %v", clientConn.RemoteAddr(), err)
+		return
+	}
+	clientConn = s.wrapForCapture(tlsConn)
+	backend, startup := s.newClientBackend(clientConn, clientConn)
+	s.processConnectionStartupMessage(backend, startup, clientConn)
+}
@@ -0,0 +1,185 @@
+package proxy
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+// writeSelfSignedPEM generates a throwaway certificate/key pair and returns the file paths.
+func writeSelfSignedPEM(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pgrollback-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("create certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("marshal key: %v", err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// startPipeConnection runs handleConnection on one end of a net.Pipe and returns the client end.
+func startPipeConnection(t *testing.T, s *Server) net.Conn {
+	t.Helper()
+	clientSide, serverSide := net.Pipe()
+	s.wg.Add(1)
+	go s.handleConnection(serverSide)
+	t.Cleanup(func() {
+		clientSide.Close()
+		s.wg.Wait()
+	})
+	clientSide.SetDeadline(time.Now().Add(5 * time.Second))
+	return clientSide
+}
+
+func writeSSLRequest(t *testing.T, conn net.Conn) byte {
+	t.Helper()
+	return writeSpecialRequest(t, conn, PostgresSSLRequestCode)
+}
+
+// writeSpecialRequest sends an 8-byte special request with code and returns the one-byte answer.
+func writeSpecialRequest(t *testing.T, conn net.Conn, code uint32) byte {
+	t.Helper()
+	req := make([]byte, 8)
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], code)
+	if _, err := conn.Write(req); err != nil {
+		t.Fatalf("write special request %d: %v", code, err)
+	}
+	resp := make([]byte, 1)
+	if _, err := conn.Read(resp); err != nil {
+		t.Fatalf("read response to special request %d: %v", code, err)
+	}
+	return resp[0]
+}
+
+func TestLoadTLSConfig_EmptyDisablesTLS(t *testing.T) {
+	cfg, err := LoadTLSConfig("", "")
+	if err != nil || cfg != nil {
+		t.Fatalf("LoadTLSConfig(\"\", \"\") = %v, %v; want nil, nil", cfg, err)
+	}
+}
+
+func TestLoadTLSConfig_RequiresBothFiles(t *testing.T) {
+	certFile, _ := writeSelfSignedPEM(t)
+	if _, err := LoadTLSConfig(certFile, ""); err == nil {
+		t.Fatal("expected error when tls_key is missing")
+	}
+}
+
+func TestSSLRequest_WithoutTLSConfigRepliesN(t *testing.T) {
+	s := &Server{activeConns: make(map[net.Conn]struct{})}
+	conn := startPipeConnection(t, s)
+	if got := writeSSLRequest(t, conn); got != 'N' {
+		t.Fatalf("SSL response = %q, want 'N'", got)
+	}
+}
+
+func TestSSLRequest_WithTLSConfigUpgradesAndContinuesStartup(t *testing.T) {
+	certFile, keyFile := writeSelfSignedPEM(t)
+	tlsConfig, err := LoadTLSConfig(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadTLSConfig: %v", err)
+	}
+	s := &Server{activeConns: make(map[net.Conn]struct{}), tlsConfig: tlsConfig}
+	conn := startPipeConnection(t, s)
+	if got := writeSSLRequest(t, conn); got != 'S' {
+		t.Fatalf("SSL response = %q, want 'S'", got)
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{InsecureSkipVerify: true})
+	if err := tlsConn.Handshake(); err != nil {
+		t.Fatalf("TLS handshake: %v", err)
+	}
+	frontend := pgproto3.NewFrontend(tlsConn, tlsConn)
+	frontend.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "postgres", "application_name": "tls_test"},
+	})
+	if err := frontend.Flush(); err != nil {
+		t.Fatalf("send startup over TLS: %v", err)
+	}
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive auth request over TLS: %v", err)
+	}
+	if _, ok := msg.(*pgproto3.AuthenticationCleartextPassword); !ok {
+		t.Fatalf("got %T, want *pgproto3.AuthenticationCleartextPassword", msg)
+	}
+}
+
+// sendStartupExpectPasswordRequest sends a StartupMessage on conn and checks the proxy asks for a password.
+func sendStartupExpectPasswordRequest(t *testing.T, conn net.Conn) {
+	t.Helper()
+	frontend := pgproto3.NewFrontend(conn, conn)
+	frontend.Send(&pgproto3.StartupMessage{
+		ProtocolVersion: pgproto3.ProtocolVersionNumber,
+		Parameters:      map[string]string{"user": "postgres", "application_name": "gss_test"},
+	})
+	if err := frontend.Flush(); err != nil {
+		t.Fatalf("send startup: %v", err)
+	}
+	msg, err := frontend.Receive()
+	if err != nil {
+		t.Fatalf("receive auth request: %v", err)
+	}
+	if _, ok := msg.(*pgproto3.AuthenticationCleartextPassword); !ok {
+		t.Fatalf("got %T, want *pgproto3.AuthenticationCleartextPassword", msg)
+	}
+}
+
+func TestGSSENCRequest_DeclinedThenSSLRequestAndStartup(t *testing.T) {
+	s := &Server{activeConns: make(map[net.Conn]struct{})}
+	conn := startPipeConnection(t, s)
+	if got := writeSpecialRequest(t, conn, PostgresGSSENCRequestCode); got != 'N' {
+		t.Fatalf("GSSENC response = %q, want 'N'", got)
+	}
+	if got := writeSSLRequest(t, conn); got != 'N' {
+		t.Fatalf("SSL response after GSSENC = %q, want 'N'", got)
+	}
+	sendStartupExpectPasswordRequest(t, conn)
+}
+
+func TestGSSENCRequest_DeclinedThenStartup(t *testing.T) {
+	s := &Server{activeConns: make(map[net.Conn]struct{})}
+	conn := startPipeConnection(t, s)
+	if got := writeSpecialRequest(t, conn, PostgresGSSENCRequestCode); got != 'N' {
+		t.Fatalf("GSSENC response = %q, want 'N'", got)
+	}
+	sendStartupExpectPasswordRequest(t, conn)
+}
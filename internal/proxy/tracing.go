@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"pgrollback/pkg/sql"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// OpenTelemetry spans (tracing.enabled, see internal/tracing).
+//
+// RunMessageLoop starts a "pgrollback.message" span for every client message. Its children are
+// "pgrollback.intercept", around InterceptQuery, and "pgrollback.execute", around running a statement
+// on the backend. Spans carry the test ID and the statement type pkg/sql classifies the query as
+// (sql.AnalyzeCommand); execute spans add the rows the backend reported. Query text is not recorded.
+// Without a tracer provider the spans are no-ops, and the attributes that need parsing are skipped.
+
+// tracer creates the proxy's spans; tests replace it with one that records them.
+var tracer = otel.Tracer("pgrollback/internal/proxy")
+
+// Span attributes.
+const (
+	attrTestID        = attribute.Key("pgrollback.test_id")
+	attrMessage       = attribute.Key("pgrollback.message")
+	attrStatementType = attribute.Key("pgrollback.statement_type")
+	attrRowsAffected  = attribute.Key("db.rows_affected")
+)
+
+// startMessageSpan starts the span of one client message; the spans the proxy starts while handling it
+// are its children. Call the returned function once the message is handled.
+func (p *proxyConnection) startMessageSpan(testID string, msg pgproto3.FrontendMessage) (end func()) {
+	ctx, span := tracer.Start(context.Background(), "pgrollback.message", trace.WithAttributes(
+		attrTestID.String(testID),
+		attrMessage.String(strings.TrimPrefix(fmt.Sprintf("%T", msg), "*pgproto3.")),
+	))
+	if span.IsRecording() {
+		switch msg := msg.(type) {
+		case *pgproto3.Query:
+			span.SetAttributes(attrStatementType.String(sql.AnalyzeCommand(msg.String).Type))
+		case *pgproto3.Parse:
+			span.SetAttributes(attrStatementType.String(sql.AnalyzeCommand(msg.Query).Type))
+		}
+	}
+	p.traceCtx = ctx
+	return func() {
+		span.End()
+		p.traceCtx = nil
+	}
+}
+
+// startSpan starts a child span of the current message's span for query (a root span outside the
+// message loop). Finish it with endSpan.
+func (p *proxyConnection) startSpan(name, testID, query string) trace.Span {
+	parent := p.traceCtx
+	if parent == nil {
+		parent = context.Background()
+	}
+	_, span := tracer.Start(parent, name, trace.WithAttributes(attrTestID.String(testID)))
+	if span.IsRecording() && query != "" {
+		span.SetAttributes(attrStatementType.String(sql.AnalyzeCommand(query).Type))
+	}
+	return span
+}
+
+// endSpan records err (if any) on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// endExecuteSpan is endSpan for an execute span, adding the rows of the backend's command tag.
+func endExecuteSpan(span trace.Span, tag pgconn.CommandTag, err error) {
+	if err == nil {
+		span.SetAttributes(attrRowsAffected.Int64(tag.RowsAffected()))
+	}
+	endSpan(span, err)
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgproto3"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// recordSpans makes the proxy's spans go to a recorder until the test ends.
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	previous := tracer
+	tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	t.Cleanup(func() { tracer = previous })
+	return recorder
+}
+
+func spanAttr(span sdktrace.ReadOnlySpan, key attribute.Key) (attribute.Value, bool) {
+	for _, kv := range span.Attributes() {
+		if kv.Key == key {
+			return kv.Value, true
+		}
+	}
+	return attribute.Value{}, false
+}
+
+func TestTracing_MessageSpanWithInterceptAndExecuteChildren(t *testing.T) {
+	recorder := recordSpans(t)
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	pgr.newFakeTestSession("t1")
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: pgr}
+
+	const query = "UPDATE t SET a = 1"
+	end := p.startMessageSpan("t1", &pgproto3.Query{String: query})
+	if err := p.ForwardCommandToDB("t1", query, false); err != nil {
+		t.Fatalf("ForwardCommandToDB = %v", err)
+	}
+	end()
+
+	spans := recorder.Ended()
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, s := range spans {
+		byName[s.Name()] = s
+	}
+	msg, intercept, execute := byName["pgrollback.message"], byName["pgrollback.intercept"], byName["pgrollback.execute"]
+	if msg == nil || intercept == nil || execute == nil {
+		t.Fatalf("spans = %d, want message, intercept and execute", len(spans))
+	}
+	for _, child := range []sdktrace.ReadOnlySpan{intercept, execute} {
+		if child.Parent().SpanID() != msg.SpanContext().SpanID() {
+			t.Errorf("%s is not a child of the message span", child.Name())
+		}
+	}
+	if v, _ := spanAttr(msg, attrMessage); v.AsString() != "Query" {
+		t.Errorf("message = %q, want Query", v.AsString())
+	}
+	for _, s := range []sdktrace.ReadOnlySpan{msg, execute} {
+		if v, _ := spanAttr(s, attrTestID); v.AsString() != "t1" {
+			t.Errorf("%s test_id = %q", s.Name(), v.AsString())
+		}
+		if v, _ := spanAttr(s, attrStatementType); v.AsString() != "UPDATE" {
+			t.Errorf("%s statement_type = %q, want UPDATE", s.Name(), v.AsString())
+		}
+	}
+	if _, ok := spanAttr(execute, attrRowsAffected); !ok {
+		t.Error("execute span has no rows affected")
+	}
+	if p.traceCtx != nil {
+		t.Error("traceCtx kept after the message span ended")
+	}
+}
+
+func TestTracing_FailedInterceptRecordsError(t *testing.T) {
+	recorder := recordSpans(t)
+	pgr := NewPgRollback("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0)
+	pgr.newFakeTestSession("t1")
+	var out bytes.Buffer
+	p := newBufferedProxyConnection(&out)
+	p.server = &Server{PgRollback: pgr}
+
+	if _, err := p.interceptQuery("t1", "VACUUM"); err == nil {
+		t.Fatal("VACUUM was not rejected")
+	}
+	spans := recorder.Ended()
+	if len(spans) != 1 || spans[0].Name() != "pgrollback.intercept" || len(spans[0].Events()) == 0 {
+		t.Fatalf("spans = %v, want one intercept span with the error", spans)
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestClaimOpenTransaction_OtherConnectionGetsInUseError(t *testing.T) {
+	db := newTestSessionDB()
+	if err := db.ClaimOpenTransaction(1, "127.0.0.1:5001"); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+	if err := db.ClaimOpenTransaction(1, ""); err != nil {
+		t.Fatalf("nested claim on same connection: %v", err)
+	}
+
+	err := db.ClaimOpenTransaction(2, "")
+	if !errors.Is(err, ErrOnlyOneTransactionAtATime) {
+		t.Fatalf("err = %v, want ErrOnlyOneTransactionAtATime", err)
+	}
+	var inUse *TransactionInUseError
+	if !errors.As(err, &inUse) || inUse.Holder != 1 {
+		t.Fatalf("err = %#v, want TransactionInUseError held by 1", err)
+	}
+
+	resp := errorResponseFor(err)
+	if resp.Code != "55006" {
+		t.Errorf("Code = %q, want 55006", resp.Code)
+	}
+	if !strings.Contains(resp.Hint, "127.0.0.1:5001") {
+		t.Errorf("Hint = %q, want holder address", resp.Hint)
+	}
+	if !strings.Contains(err.Error(), "transaction held by 127.0.0.1:5001") {
+		t.Errorf("Error() = %q, want holder address", err.Error())
+	}
+
+	db.ReleaseOpenTransaction(1)
+	if got := db.OpenTransactionHolder(); got != "" {
+		t.Errorf("OpenTransactionHolder after release = %q, want empty", got)
+	}
+	if err := db.ClaimOpenTransaction(2, ""); err != nil {
+		t.Fatalf("claim after release: %v", err)
+	}
+	if got := db.OpenTransactionHolder(); got != "0x2" {
+		t.Errorf("OpenTransactionHolder = %q, want the connection ID when no label was given", got)
+	}
+}
+
+func TestClaimOpenTransaction_WaitsForRelease(t *testing.T) {
+	db := newTestSessionDB()
+	db.beginWaitTimeout = 2 * time.Second
+	if err := db.ClaimOpenTransaction(1, ""); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+
+	claimed := make(chan error, 1)
+	go func() { claimed <- db.ClaimOpenTransaction(2, "") }()
+
+	select {
+	case err := <-claimed:
+		t.Fatalf("second claim returned before release: %v", err)
+	case <-time.After(30 * time.Millisecond):
+	}
+	db.ReleaseOpenTransaction(1)
+
+	select {
+	case err := <-claimed:
+		if err != nil {
+			t.Fatalf("second claim after release: %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("second claim still blocked after release")
+	}
+	if db.isTransactionHeldByOtherConnection(2) {
+		t.Error("claim should now belong to connection 2")
+	}
+}
+
+func TestClaimOpenTransaction_WaitTimesOut(t *testing.T) {
+	db := newTestSessionDB()
+	db.beginWaitTimeout = 20 * time.Millisecond
+	if err := db.ClaimOpenTransaction(1, ""); err != nil {
+		t.Fatalf("first claim: %v", err)
+	}
+
+	start := time.Now()
+	err := db.ClaimOpenTransaction(2, "")
+	if !errors.Is(err, ErrOnlyOneTransactionAtATime) {
+		t.Fatalf("err = %v, want ErrOnlyOneTransactionAtATime", err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("returned after %v, want to wait the 20ms timeout", elapsed)
+	}
+}
+
+func TestErrorResponseFor_DefaultCode(t *testing.T) {
+	resp := errorResponseFor(errors.New("boom"))
+	if resp.Code != "XX000" || resp.Message != "boom" || resp.Hint != "" {
+		t.Errorf("resp = %+v, want XX000 boom without hint", resp)
+	}
+}
@@ -3,7 +3,6 @@ package proxy
 import (
 	"context"
 	"fmt"
-	"log"
 	"math/rand"
 	"time"
 
@@ -18,12 +17,21 @@ type pgxQueryer interface {
 	Exec(ctx context.Context, sql string, arguments ...any) (pgconn.CommandTag, error)
 }
 
+// queryGuardSavepoint is the savepoint that wraps a SafeQuery while its rows are read.
+// Only one is open at a time (d.mu serializes backend I/O), so a fixed name is enough.
+const queryGuardSavepoint = "pgrollback_query_guard"
+
+// execGuardSavepoint is the savepoint SafeExec sends in the same round trip as the statement it guards
+// (see safeExecBatchedLocked); d.mu serializes backend I/O, so a fixed name is enough here too.
+const execGuardSavepoint = "pgrollback_exec_guard"
+
+// guardedRows releases the SafeQuery guard savepoint on Close: RELEASE when the rows were
+// read without error, ROLLBACK TO + RELEASE otherwise, so guards never pile up in the session.
 type guardedRows struct {
 	pgx.Rows
-	ctx       context.Context
-	tx        pgxQueryer
-	savePoint pgx.Tx
-	closed    bool
+	ctx    context.Context
+	db     *realSessionDB
+	closed bool
 }
 
 func (r *guardedRows) Close() {
@@ -33,46 +41,17 @@ func (r *guardedRows) Close() {
 	r.closed = true
 
 	r.Rows.Close()
-
-	if err := r.Rows.Err(); err != nil {
-		if guardErr := r.savePoint.Rollback(r.ctx); guardErr != nil {
-			log.Printf("[PROXY] FATAL: Falha ao reverter savepoint após erro em rows: %v", guardErr)
-		}
-		return
-	}
-
-	if releaseErr := r.savePoint.Commit(r.ctx); releaseErr != nil {
-		log.Printf("[PROXY] Aviso: Falha ao liberar savepoint de guarda: %v", releaseErr)
-	}
+	r.db.finishQueryGuard(r.ctx, r.Rows.Err())
 }
 
+// SafeQuery é o equivalente livre de realSessionDB.SafeQuery.
 func SafeQuery(
 	ctx context.Context,
 	tx *realSessionDB,
 	query string,
 	args ...any,
 ) (pgx.Rows, error) {
-
-	savePoint, err := tx.tx.Begin(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("Falha ao guardar transacao: %w, da sql: querySql: '''%s'''", err, query)
-	}
-
-	rows, err := savePoint.Query(ctx, query, args...)
-	if err != nil {
-		savePoint.Rollback(ctx)
-		log.Printf("[PROXY] Erro na execução (revertendo guarda): %v", err)
-		return nil, fmt.Errorf("falha ao executar query: %w", err)
-	}
-	savePoint.Commit(ctx)
-
-	// Wrap rows so savepoint is finalized on Close()
-	return &guardedRows{
-		Rows:      rows,
-		ctx:       ctx,
-		tx:        tx,
-		savePoint: savePoint,
-	}, nil
+	return tx.SafeQuery(ctx, query, args...)
 }
 
 func releaseSavepoint(ctx context.Context, tx pgxQueryer, savepointName string) error {
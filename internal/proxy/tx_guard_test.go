@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// newGuardTestSession opens a real session, skipping when PostgreSQL is not reachable.
+func newGuardTestSession(t *testing.T, testID string) *TestSession {
+	t.Helper()
+	pgr := newPgRollbackFromConfig()
+	if pgr == nil {
+		t.Skip("no config for PostgreSQL")
+	}
+	session, err := pgr.GetOrCreateSession(testID)
+	if err != nil {
+		t.Skipf("PostgreSQL not available: %v", err)
+	}
+	t.Cleanup(func() { _ = pgr.DestroySession(testID) })
+	return session
+}
+
+func TestSafeQuery_GuardDepthStaysBounded(t *testing.T) {
+	session := newGuardTestSession(t, "tx_guard_depth")
+	ctx := context.Background()
+
+	for i := 0; i < 200; i++ {
+		query := "SELECT 1"
+		if i%10 == 0 {
+			query = "SELECT 1/0" // error surfaces while reading rows
+		}
+		rows, err := session.DB.SafeQuery(ctx, query)
+		if err != nil {
+			t.Fatalf("SafeQuery #%d: %v", i, err)
+		}
+		for rows.Next() {
+		}
+		rows.Close()
+	}
+
+	// With every guard released, the guard name no longer exists in the transaction.
+	_, err := session.DB.SafeExec(ctx, "RELEASE SAVEPOINT "+queryGuardSavepoint)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("RELEASE %s error = %v, want savepoint does not exist (guards leaked)", queryGuardSavepoint, err)
+	}
+
+	rows, err := session.DB.SafeQuery(ctx, "SELECT 1")
+	if err != nil {
+		t.Fatalf("SafeQuery after loop: %v", err)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		t.Fatalf("session transaction unusable after guards: %v", rows.Err())
+	}
+}
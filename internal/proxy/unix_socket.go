@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Unix domain socket listener (proxy.listen_socket).
+//
+// Clients such as libpq and PHP's pdo_pgsql connect to host=/some/dir by opening <dir>/.s.PGSQL.<port>,
+// so the proxy creates its socket under that name, with the TCP listen port, next to the TCP listener
+// (which the GUI and "pgrollback list/kill" keep using). Connections accepted on it go through the
+// same accept loop as TCP ones. The socket file is removed when the server stops; a stale one left by a
+// crashed proxy is replaced at startup, but one a live server still answers on is an error.
+
+// unixSocketPath returns the PostgreSQL-style socket path for port in dir.
+func unixSocketPath(dir string, port int) string {
+	return filepath.Join(dir, fmt.Sprintf(".s.PGSQL.%d", port))
+}
+
+// bindUnixSocket listens on <listenSocketDir>/.s.PGSQL.<listen port>. Call after the TCP listener is bound,
+// so a kernel-assigned port is known.
+func (s *Server) bindUnixSocket() error {
+	path := unixSocketPath(s.listenSocketDir, s.ListenPort())
+	if _, err := os.Stat(path); err == nil {
+		if unixSocketReady(path, portCheckTimeout) {
+			return fmt.Errorf("unix socket %s is already in use. Cannot start server. Please stop any service using it", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("failed to remove stale unix socket %s: %w", path, err)
+		}
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("unix socket %s: %w", path, err)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on unix socket %s: %w", path, err)
+	}
+	// Like PostgreSQL's default unix_socket_permissions: any local user may connect (e.g. php-fpm).
+	if err := os.Chmod(path, 0o777); err != nil {
+		_ = listener.Close()
+		return fmt.Errorf("failed to set permissions of unix socket %s: %w", path, err)
+	}
+	s.mu.Lock()
+	s.socketListener = listener
+	s.socketPath = path
+	s.mu.Unlock()
+	return nil
+}
+
+// SocketPath returns the Unix socket the server listens on, or "" when proxy.listen_socket is not set.
+func (s *Server) SocketPath() string { s.mu.RLock(); defer s.mu.RUnlock(); return s.socketPath }
+
+// unixSocketReady reports whether something accepts connections on the socket at path.
+func unixSocketReady(path string, timeout time.Duration) bool {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
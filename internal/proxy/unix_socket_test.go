@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketPath_UsesPostgresName(t *testing.T) {
+	if got := unixSocketPath("/var/run/postgresql", 5432); got != filepath.Join("/var/run/postgresql", ".s.PGSQL.5432") {
+		t.Errorf("unixSocketPath = %s", got)
+	}
+}
+
+func TestListenSocket_AcceptsClientsAndRemovesFileOnStop(t *testing.T) {
+	dir := t.TempDir()
+	s := NewServer("127.0.0.1", 1, "db", "u", "p", time.Minute, time.Hour, 0, "127.0.0.1", 0, false, WithListenSocket(dir))
+	if err := s.StartError(); err != nil {
+		t.Fatalf("StartError: %v", err)
+	}
+	path := s.SocketPath()
+	if want := unixSocketPath(dir, s.ListenPort()); path != want {
+		t.Fatalf("SocketPath = %s, want %s", path, want)
+	}
+
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		t.Fatalf("dial unix socket: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if got := writeSSLRequest(t, conn); got != 'N' {
+		t.Errorf("SSL response over the unix socket = %q, want 'N'", got)
+	}
+	conn.Close()
+
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("socket file after Stop: %v, want it removed", err)
+	}
+	if s.SocketPath() != "" {
+		t.Errorf("SocketPath after Stop = %q, want empty", s.SocketPath())
+	}
+}
+
+func TestBindUnixSocket_ReplacesStaleFileButNotLiveSocket(t *testing.T) {
+	dir := t.TempDir()
+	path := unixSocketPath(dir, 6543)
+
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	s := &Server{listenSocketDir: dir, listenPort: 6543}
+	if err := s.bindUnixSocket(); err != nil {
+		t.Fatalf("bindUnixSocket over a stale file: %v", err)
+	}
+	defer s.socketListener.Close()
+
+	other := &Server{listenSocketDir: dir, listenPort: 6543}
+	if err := other.bindUnixSocket(); err == nil || !strings.Contains(err.Error(), "already in use") {
+		t.Errorf("bindUnixSocket on a live socket = %v, want already in use", err)
+	}
+}
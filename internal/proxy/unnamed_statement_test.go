@@ -0,0 +1,55 @@
+package proxy
+
+import "testing"
+
+// TestSetPreparedStatement_UnnamedReplacesPrevious parses the unnamed statement back to back: the second
+// Parse closes the first statement and the portals bound to it, even when it has another parameter count
+// or the first one was a multi-statement batch.
+func TestSetPreparedStatement_UnnamedReplacesPrevious(t *testing.T) {
+	p, db := newCloseTestConnection(t, "unnamed_reparse")
+	p.SetMultiStatement("")
+
+	p.SetPreparedStatement("", "SELECT $1::int + $2::int")
+
+	assertStatements(t, p, "s1", "s2", "")
+	assertPortals(t, p, "p1", "", "p2")
+	if query, _ := p.GetPreparedStatement(""); query != "SELECT $1::int + $2::int" {
+		t.Errorf("unnamed statement = %q, want the second Parse", query)
+	}
+	if p.IsMultiStatement("") {
+		t.Error("unnamed statement still marked as multi-statement after a single-statement Parse")
+	}
+	if _, ok := db.ResolveBackendStatement(p.connectionID(), "s1"); !ok {
+		t.Error("re-Parse of the unnamed statement dropped s1")
+	}
+
+	p.BindPortal("p3", "", [][]byte{[]byte("1"), []byte("2")}, nil)
+	if query, params, _, ok := p.QueryForPortal("p3"); !ok || query != "SELECT $1::int + $2::int" || len(params) != 2 {
+		t.Errorf("portal on the new unnamed statement = %q %d params (open %v), want the second Parse with 2", query, len(params), ok)
+	}
+}
+
+// TestBackendStatements_UnnamedPerConnection checks that the unnamed statement of each connection gets its
+// own backend name, so one connection's unnamed Parse does not replace another's on the shared backend.
+func TestBackendStatements_UnnamedPerConnection(t *testing.T) {
+	d := &realSessionDB{}
+	a := d.SetPreparedStatement(1, "", "SELECT $1::int")
+	b := d.SetPreparedStatement(2, "", "SELECT $1::int, $2::int")
+	if a == "" || b == "" || a == b {
+		t.Fatalf("backend names of the unnamed statements = %q and %q, want two distinct names", a, b)
+	}
+	if stmt, ok := d.ResolveBackendStatement(1, ""); !ok || stmt.name != a || stmt.sql != "SELECT $1::int" {
+		t.Errorf("connection 1 unnamed statement = %+v, %v; want %q", stmt, ok, a)
+	}
+
+	again := d.SetPreparedStatement(1, "", "SELECT 1")
+	if again == a {
+		t.Errorf("unnamed re-Parse reused backend name %q", a)
+	}
+	if backendName, ok := d.forgetPreparedStatement(1, ""); !ok || backendName != again {
+		t.Errorf("forget unnamed = %q, %v; want %q", backendName, ok, again)
+	}
+	if _, ok := d.ResolveBackendStatement(2, ""); !ok {
+		t.Error("forgetting connection 1's unnamed statement dropped connection 2's")
+	}
+}
@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"context"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Warm pool (postgres.warm_pool_size).
+//
+// Creating a session opens a backend connection (TCP, TLS, authentication) before its first query can
+// run, which every test pays once. The warm pool keeps up to size connections already open, outside any
+// transaction, so createNewSession only has to Begin. A background goroutine refills the pool after
+// each checkout and retries with backoff while the backend is unreachable. A connection is pinged before
+// it is handed out; a dead one is closed and the session falls back to opening its own.
+//
+// Warm connections are opened before their test ID is known, with application_name warmPoolAppName;
+// at checkout they are renamed to the test ID's application_name and their NoticeResponses start going to
+// the session (warmConn.notices).
+
+const (
+	warmPoolAppName     = "pgrollback_warm"
+	warmPoolPingTimeout = 2 * time.Second
+	warmPoolRetryMin    = 500 * time.Millisecond
+	warmPoolRetryMax    = 30 * time.Second
+)
+
+// warmConn is an idle backend connection of the warm pool.
+type warmConn struct {
+	conn    *pgx.Conn
+	notices *atomic.Pointer[backendNotices] // receives the connection's NoticeResponses once handed out; nil before
+}
+
+type warmPool struct {
+	size int
+	dial func(ctx context.Context) (*warmConn, error)
+	ping func(ctx context.Context, wc *warmConn) error
+	drop func(wc *warmConn)
+
+	mu     sync.Mutex
+	idle   []*warmConn
+	closed bool
+
+	refill chan struct{} // wakes the refill goroutine; buffered (1)
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// newWarmPool returns a pool of size connections opened by dial; start begins filling it.
+func newWarmPool(size int, dial func(ctx context.Context) (*warmConn, error)) *warmPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &warmPool{
+		size:   size,
+		dial:   dial,
+		ping:   func(ctx context.Context, wc *warmConn) error { return wc.conn.Ping(ctx) },
+		drop:   func(wc *warmConn) { _ = wc.conn.Close(context.Background()) },
+		refill: make(chan struct{}, 1),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// start runs the refill goroutine until close.
+func (w *warmPool) start() {
+	go w.run()
+}
+
+func (w *warmPool) run() {
+	failures := 0
+	for {
+		var retry <-chan time.Time
+		if w.missing() > 0 {
+			if err := w.fillOne(); err == nil {
+				failures = 0
+				continue
+			} else if w.ctx.Err() != nil {
+				return
+			} else {
+				failures++
+				wait := min(warmPoolRetryMin<<min(failures-1, 6), warmPoolRetryMax)
+				log.Printf("[PROXY] warm pool: failed to open a backend connection (retry in %s): %v", wait, err)
+				retry = time.After(wait)
+			}
+		}
+		select {
+		case <-w.ctx.Done():
+			return
+		case <-w.refill:
+		case <-retry:
+		}
+	}
+}
+
+// missing returns how many connections the pool lacks; 0 once closed.
+func (w *warmPool) missing() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0
+	}
+	return w.size - len(w.idle)
+}
+
+// fillOne opens one connection and adds it to the pool (or discards it when the pool closed meanwhile).
+func (w *warmPool) fillOne() error {
+	wc, err := w.dial(w.ctx)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		w.drop(wc)
+		return nil
+	}
+	w.idle = append(w.idle, wc)
+	w.mu.Unlock()
+	return nil
+}
+
+// take returns a healthy idle connection, or nil when the pool is empty, closed or nil. Connections that
+// fail the ping are closed. Either way the refill goroutine is woken.
+func (w *warmPool) take() *warmConn {
+	if w == nil {
+		return nil
+	}
+	defer w.wakeRefill()
+	for {
+		w.mu.Lock()
+		if w.closed || len(w.idle) == 0 {
+			w.mu.Unlock()
+			return nil
+		}
+		wc := w.idle[0]
+		w.idle = w.idle[1:]
+		w.mu.Unlock()
+
+		ctx, cancel := context.WithTimeout(context.Background(), warmPoolPingTimeout)
+		err := w.ping(ctx, wc)
+		cancel()
+		if err == nil {
+			return wc
+		}
+		logIfVerbose("[PROXY] warm pool: discarding dead backend connection: %v", err)
+		w.drop(wc)
+	}
+}
+
+func (w *warmPool) wakeRefill() {
+	select {
+	case w.refill <- struct{}{}:
+	default:
+	}
+}
+
+// idleCount returns how many connections are waiting in the pool.
+func (w *warmPool) idleCount() int {
+	if w == nil {
+		return 0
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.idle)
+}
+
+// close stops the refill goroutine (a connection it is still opening is closed when ready) and closes
+// the idle connections. Safe to call more than once and on nil.
+func (w *warmPool) close() {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return
+	}
+	w.closed = true
+	idle := w.idle
+	w.idle = nil
+	w.mu.Unlock()
+	w.cancel()
+	for _, wc := range idle {
+		w.drop(wc)
+	}
+}
+
+// startWarmPool starts the warm pool when WarmPoolSize is positive. Called once by NewServer.
+func (p *PgRollback) startWarmPool() {
+	if p.WarmPoolSize <= 0 || p.warmPool != nil {
+		return
+	}
+	p.warmPool = newWarmPool(p.WarmPoolSize, p.dialWarmConn)
+	p.warmPool.start()
+}
+
+// dialWarmConn opens a session connection that does not belong to a test ID yet.
+func (p *PgRollback) dialWarmConn(ctx context.Context) (*warmConn, error) {
+	sessionTimeout := p.SessionTimeout
+	if sessionTimeout <= 0 {
+		sessionTimeout = 300 * time.Second
+	}
+	config, err := backendConnConfig(p.PostgresHost, p.PostgresPort, p.PostgresDB, p.PostgresUser, p.PostgresPass, sessionTimeout, warmPoolAppName)
+	if err != nil {
+		return nil, err
+	}
+	wc := &warmConn{notices: &atomic.Pointer[backendNotices]{}}
+	config.OnNotice = func(c *pgconn.PgConn, n *pgconn.Notice) {
+		if b := wc.notices.Load(); b != nil {
+			b.onNotice(c, n)
+		}
+	}
+	if wc.conn, err = connectBackend(ctx, config, sessionTimeout); err != nil {
+		return nil, err
+	}
+	return wc, nil
+}
+
+// sessionConnection returns the backend connection for a new session of testID: a warm one when the
+// pool has a healthy connection, otherwise a newly opened one. notices receives its NoticeResponses.
+func (p *PgRollback) sessionConnection(ctx context.Context, testID string, notices *backendNotices) (*pgx.Conn, error) {
+	target, routed := p.backendFor(testID)
+	if routed {
+		// Warm connections are open on the default database.
+		return newConnectionForTestID(ctx, target.Host, target.Port, target.Database, target.User, target.Password, p.SessionTimeout, testID, notices.onNotice)
+	}
+	if wc := p.warmPool.take(); wc != nil {
+		wc.notices.Store(notices)
+		// application_name identifies the session's backend in pg_stat_activity, as for a new connection.
+		_, err := wc.conn.Exec(ctx, "SELECT pg_catalog.set_config('application_name', $1, false)", getAppNameForTestID(testID))
+		if err == nil {
+			return wc.conn, nil
+		}
+		log.Printf("[PROXY] warm pool: could not hand out a connection to testID %s, opening a new one: %v", testID, err)
+		_ = wc.conn.Close(context.Background())
+	}
+	return newConnectionForTestID(ctx, p.PostgresHost, p.PostgresPort, p.PostgresDB, p.PostgresUser, p.PostgresPass, p.SessionTimeout, testID, notices.onNotice)
+}
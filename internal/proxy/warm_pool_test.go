@@ -0,0 +1,94 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeWarmPool returns a started pool whose connections are fakes: dead ones fail the ping, and
+// dropped ones are counted.
+func fakeWarmPool(t *testing.T, size int, dialErrs int) (w *warmPool, dead *sync.Map, dropped *atomic.Int32) {
+	t.Helper()
+	dead, dropped = &sync.Map{}, &atomic.Int32{}
+	var fails atomic.Int32
+	w = newWarmPool(size, func(ctx context.Context) (*warmConn, error) {
+		if int(fails.Add(1)) <= dialErrs {
+			return nil, errors.New("connection refused")
+		}
+		return &warmConn{notices: &atomic.Pointer[backendNotices]{}}, nil
+	})
+	w.ping = func(ctx context.Context, wc *warmConn) error {
+		if _, ok := dead.Load(wc); ok {
+			return errors.New("conn closed")
+		}
+		return nil
+	}
+	w.drop = func(*warmConn) { dropped.Add(1) }
+	w.start()
+	t.Cleanup(w.close)
+	return w, dead, dropped
+}
+
+func waitIdle(t *testing.T, w *warmPool, want int, within time.Duration) {
+	t.Helper()
+	deadline := time.Now().Add(within)
+	for w.idleCount() != want {
+		if time.Now().After(deadline) {
+			t.Fatalf("warm pool has %d idle connections, want %d", w.idleCount(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWarmPool_FillsAndRefillsAfterTake(t *testing.T) {
+	w, _, _ := fakeWarmPool(t, 3, 0)
+	waitIdle(t, w, 3, 2*time.Second)
+	if w.take() == nil || w.take() == nil {
+		t.Fatal("take from a full pool returned nil")
+	}
+	waitIdle(t, w, 3, 2*time.Second)
+}
+
+func TestWarmPool_DiscardsDeadConnections(t *testing.T) {
+	w, dead, dropped := fakeWarmPool(t, 2, 0)
+	waitIdle(t, w, 2, 2*time.Second)
+	w.mu.Lock()
+	first := w.idle[0]
+	w.mu.Unlock()
+	dead.Store(first, true)
+
+	wc := w.take()
+	if wc == nil || wc == first {
+		t.Fatalf("take = %p, want the healthy connection, not the dead %p", wc, first)
+	}
+	if dropped.Load() != 1 {
+		t.Errorf("dropped = %d, want the dead connection closed", dropped.Load())
+	}
+}
+
+func TestWarmPool_RetriesAfterDialFailure(t *testing.T) {
+	w, _, _ := fakeWarmPool(t, 1, 1)
+	waitIdle(t, w, 1, warmPoolRetryMin+2*time.Second)
+}
+
+func TestWarmPool_CloseDropsIdleConnections(t *testing.T) {
+	w, _, dropped := fakeWarmPool(t, 2, 0)
+	waitIdle(t, w, 2, 2*time.Second)
+	w.close()
+	w.close()
+	if dropped.Load() != 2 {
+		t.Errorf("dropped = %d, want 2", dropped.Load())
+	}
+	if w.take() != nil {
+		t.Error("take after close must return nil")
+	}
+	var nilPool *warmPool
+	if nilPool.take() != nil || nilPool.idleCount() != 0 {
+		t.Error("a nil pool has no connections")
+	}
+	nilPool.close()
+}
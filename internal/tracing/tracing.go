@@ -0,0 +1,42 @@
+// Package tracing exports the proxy's OpenTelemetry spans (tracing.enabled) to an OTLP/HTTP collector.
+//
+// The proxy creates its spans through the global tracer provider (see internal/proxy/tracing.go); until
+// Start installs one, the global provider is a no-op and the spans cost almost nothing.
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// ServiceName is the service.name of the exported spans.
+const ServiceName = "pgrollback"
+
+// Start installs a global tracer provider that sends spans in batches to the OTLP/HTTP collector at
+// endpoint: an http:// or https:// URL, or host:port for a plaintext collector. An empty endpoint uses
+// the OTEL_EXPORTER_OTLP_* variables, or localhost:4318. The returned shutdown flushes pending spans.
+func Start(ctx context.Context, endpoint string) (shutdown func(context.Context) error, err error) {
+	var opts []otlptracehttp.Option
+	switch {
+	case strings.Contains(endpoint, "://"):
+		opts = append(opts, otlptracehttp.WithEndpointURL(endpoint))
+	case endpoint != "":
+		opts = append(opts, otlptracehttp.WithEndpoint(endpoint), otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
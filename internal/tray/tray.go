@@ -26,6 +26,12 @@ func Run(guiURL string, postgresConnMasked string, onQuit func()) {
 	})
 }
 
+// Quit ends Run as the tray's "Quit" item does: onQuit runs and Run returns. Safe to call more than once
+// and from any goroutine (e.g. a signal handler).
+func Quit() {
+	systray.Quit()
+}
+
 // trayTooltipMaxRunes keeps NOTIFYICONDATA.szTip within 128 UTF-16 slots (ASCII ≈ 1 slot each).
 const trayTooltipMaxRunes = 127
 
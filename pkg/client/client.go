@@ -0,0 +1,110 @@
+// Package client builds connections that target the pgrollback proxy from Go tests.
+//
+// The proxy picks a connection's session (its test ID) from the application_name startup parameter,
+// as protocol.ExtractTestID reads it: "pgrollback_<test_id>". DSN and Open put the test ID there, so
+// tests do not have to repeat the convention in every connection string.
+package client
+
+import (
+	"database/sql"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib" // driver "pgx"
+)
+
+// Proxy address used by Open when PGROLLBACK_LISTEN_HOST / PGROLLBACK_LISTEN_PORT are not set (the
+// proxy's own defaults for proxy.listen_host and proxy.listen_port).
+const (
+	DefaultHost = "localhost"
+	DefaultPort = 5432
+)
+
+// Pool settings applied by Open and OpenDSN. Every connection of a test ID runs in the same backend
+// transaction, so more connections add no parallelism: they only take proxy.max_connections slots.
+const (
+	MaxOpenConns    = 4
+	MaxIdleConns    = 4
+	ConnMaxIdleTime = 5 * time.Minute
+)
+
+// ApplicationName returns the application_name that selects testID's session; an empty testID selects
+// the "default" session.
+func ApplicationName(testID string) string {
+	if testID == "" {
+		return "default"
+	}
+	return "pgrollback_" + testID
+}
+
+// DSN returns a connection string for the proxy at proxyHost:port whose connections belong to testID.
+// Without base it is a key=value string with sslmode=disable. With base (a postgres:// URL, or key=value
+// settings joined with spaces) the other settings of base are kept — user, password, dbname, sslmode —
+// and its host, port and application_name are replaced.
+func DSN(proxyHost string, port int, testID string, base ...string) string {
+	appName := ApplicationName(testID)
+	if len(base) == 1 && isURL(base[0]) {
+		if u, err := url.Parse(base[0]); err == nil {
+			u.Host = net.JoinHostPort(proxyHost, strconv.Itoa(port))
+			q := u.Query()
+			q.Set("application_name", appName)
+			u.RawQuery = q.Encode()
+			return u.String()
+		}
+	}
+	settings := strings.TrimSpace(strings.Join(base, " "))
+	if settings == "" {
+		settings = "sslmode=disable"
+	}
+	// The last occurrence of a key wins, so these override whatever base says.
+	return settings + " host=" + quoteValue(proxyHost) + " port=" + strconv.Itoa(port) + " application_name=" + quoteValue(appName)
+}
+
+// Open returns a pool of connections to the proxy that belong to testID. The proxy address is read from
+// PGROLLBACK_LISTEN_HOST and PGROLLBACK_LISTEN_PORT (DefaultHost and DefaultPort when unset). Like
+// sql.Open it does not connect; the first query does.
+func Open(testID string) (*sql.DB, error) {
+	host := os.Getenv("PGROLLBACK_LISTEN_HOST")
+	if host == "" {
+		host = DefaultHost
+	}
+	port := DefaultPort
+	if v := os.Getenv("PGROLLBACK_LISTEN_PORT"); v != "" {
+		p, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		port = p
+	}
+	return OpenDSN(DSN(host, port, testID))
+}
+
+// OpenDSN opens dsn (usually built by DSN) with the pgx driver and the pool settings above.
+func OpenDSN(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(MaxOpenConns)
+	db.SetMaxIdleConns(MaxIdleConns)
+	db.SetConnMaxIdleTime(ConnMaxIdleTime)
+	return db, nil
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "postgres://") || strings.HasPrefix(s, "postgresql://")
+}
+
+// quoteValue quotes a key=value setting when it is empty or has spaces, quotes or backslashes.
+func quoteValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\n'\\") {
+		return v
+	}
+	v = strings.ReplaceAll(v, `\`, `\\`)
+	v = strings.ReplaceAll(v, `'`, `\'`)
+	return "'" + v + "'"
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+
+	"pgrollback/pkg/protocol"
+)
+
+func TestDSN_SelectsTestIDSession(t *testing.T) {
+	cases := []struct {
+		name   string
+		testID string
+		base   []string
+		user   string
+		db     string
+	}{
+		{name: "no base", testID: "checkout"},
+		{name: "key=value base", testID: "checkout", base: []string{"user=app password=secret dbname=shop host=db port=5433 application_name=other"}, user: "app", db: "shop"},
+		{name: "URL base", testID: "checkout", base: []string{"postgres://app:secret@db:5433/shop?sslmode=disable&application_name=other"}, user: "app", db: "shop"},
+		{name: "test ID with spaces and quotes", testID: `it's a test\1`},
+		{name: "test ID with the prefix", testID: "pgrollback_x"},
+		{name: "empty test ID", testID: ""},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			dsn := DSN("proxy.local", 6432, tc.testID, tc.base...)
+			cfg, err := pgconn.ParseConfig(dsn)
+			if err != nil {
+				t.Fatalf("ParseConfig(%q): %v", dsn, err)
+			}
+			if cfg.Host != "proxy.local" || cfg.Port != 6432 {
+				t.Errorf("DSN %q targets %s:%d, want proxy.local:6432", dsn, cfg.Host, cfg.Port)
+			}
+			if tc.user != "" && (cfg.User != tc.user || cfg.Database != tc.db) {
+				t.Errorf("DSN %q lost base settings: user %q db %q", dsn, cfg.User, cfg.Database)
+			}
+			want := tc.testID
+			if want == "" {
+				want = "default"
+			}
+			got, _ := protocol.ExtractTestID(cfg.RuntimeParams)
+			if got != want {
+				t.Errorf("DSN %q selects test ID %q, want %q", dsn, got, want)
+			}
+		})
+	}
+}
+
+func TestOpen_AppliesPoolSettings(t *testing.T) {
+	t.Setenv("PGROLLBACK_LISTEN_HOST", "proxy.local")
+	t.Setenv("PGROLLBACK_LISTEN_PORT", "6432")
+	db, err := Open("checkout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if got := db.Stats().MaxOpenConnections; got != MaxOpenConns {
+		t.Errorf("MaxOpenConnections = %d, want %d", got, MaxOpenConns)
+	}
+
+	t.Setenv("PGROLLBACK_LISTEN_PORT", "not-a-port")
+	if _, err := Open("checkout"); err == nil {
+		t.Error("expected an error for an invalid PGROLLBACK_LISTEN_PORT")
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 // InitFromConfig inicializa o logger padrão a partir da configuração
-// Se config for nil, usa valores padrão (INFO level, stderr)
+// Se config for nil, usa valores padrão (INFO level, stderr, formato texto)
 func InitFromConfig(cfg *config.Config) error {
 	var level LogLevel = INFO
 	var output io.Writer = os.Stderr
@@ -28,6 +28,9 @@ func InitFromConfig(cfg *config.Config) error {
 
 	logger := NewLogger(level, "", log.LstdFlags)
 	logger.SetOutput(output)
+	if cfg != nil {
+		logger.SetFormat(ParseFormat(cfg.Logging.Format))
+	}
 	SetDefaultLogger(logger)
 
 	return nil
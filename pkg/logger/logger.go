@@ -1,12 +1,15 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 )
 
 // LogLevel representa os níveis de log disponíveis
@@ -52,14 +55,100 @@ func ParseLogLevel(level string) LogLevel {
 	}
 }
 
+// Format seleciona o formato de saída das mensagens (logging.format)
+type Format int
+
+const (
+	TextFormat Format = iota // "[LEVEL] message", com prefixo/flags do log padrão
+	JSONFormat               // uma linha JSON por entrada: {"ts":...,"level":...,"msg":...}
+)
+
+// ParseFormat converte "text" ou "json" em Format (padrão: TextFormat)
+func ParseFormat(format string) Format {
+	if strings.EqualFold(strings.TrimSpace(format), "json") {
+		return JSONFormat
+	}
+	return TextFormat
+}
+
+// field é um par chave/valor de contexto anexado por Logger.With.
+type field struct {
+	key   string
+	value interface{}
+}
+
+// formatter renderiza uma entrada já aprovada pelo filtro de nível do Logger.
+type formatter interface {
+	format(level LogLevel, message string, fields []field) string
+}
+
+// textFormatter produz "[LEVEL] key=value message"; data/hora e prefixo vêm das flags do log.Logger.
+type textFormatter struct{}
+
+func (textFormatter) format(level LogLevel, message string, fields []field) string {
+	var b strings.Builder
+	b.WriteString("[")
+	b.WriteString(level.String())
+	b.WriteString("] ")
+	for _, f := range fields {
+		v := fmt.Sprint(f.value)
+		if v == "" || strings.ContainsAny(v, " =\"\t\n") {
+			v = strconv.Quote(v)
+		}
+		b.WriteString(f.key)
+		b.WriteString("=")
+		b.WriteString(v)
+		b.WriteString(" ")
+	}
+	b.WriteString(message)
+	return b.String()
+}
+
+// jsonFormatter produz {"ts":...,"level":...,<campos>...,"msg":...} (ts em RFC3339 UTC com nanossegundos).
+type jsonFormatter struct{}
+
+func (jsonFormatter) format(level LogLevel, message string, fields []field) string {
+	var b strings.Builder
+	b.WriteString(`{"ts":`)
+	writeJSONValue(&b, time.Now().UTC().Format(time.RFC3339Nano))
+	b.WriteString(`,"level":`)
+	writeJSONValue(&b, level.String())
+	for _, f := range fields {
+		b.WriteString(",")
+		writeJSONValue(&b, f.key)
+		b.WriteString(":")
+		writeJSONValue(&b, f.value)
+	}
+	b.WriteString(`,"msg":`)
+	writeJSONValue(&b, message)
+	b.WriteString("}")
+	return b.String()
+}
+
+// writeJSONValue escreve v como JSON; valores não serializáveis viram string (fmt.Sprint).
+func writeJSONValue(b *strings.Builder, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(v))
+	}
+	b.Write(data)
+}
+
 // Logger gerencia mensagens de log com níveis configuráveis
 type Logger struct {
-	level  LogLevel
-	logger *log.Logger
-	mu     sync.RWMutex
-	output io.Writer
-	prefix string
-	flags  int
+	level     LogLevel
+	logger    *log.Logger
+	mu        sync.RWMutex
+	output    io.Writer
+	prefix    string
+	flags     int
+	format    Format
+	formatter formatter
+
+	// base e fields são usados por loggers filhos (With): nível, saída e formato vêm sempre de base,
+	// então mudanças no logger raiz valem também para os filhos já criados.
+	base   *Logger
+	fields []field
 }
 
 var (
@@ -94,16 +183,35 @@ func getDefaultLogger() *Logger {
 // flags: flags do log padrão (log.LstdFlags, etc.)
 func NewLogger(level LogLevel, prefix string, flags int) *Logger {
 	return &Logger{
-		level:  level,
-		logger: log.New(os.Stderr, prefix, flags),
-		output: os.Stderr,
-		prefix: prefix,
-		flags:  flags,
+		level:     level,
+		logger:    log.New(os.Stderr, prefix, flags),
+		output:    os.Stderr,
+		prefix:    prefix,
+		flags:     flags,
+		formatter: textFormatter{},
 	}
 }
 
+// With retorna um logger filho que anexa key=value a todas as mensagens (texto: "key=value"
+// antes da mensagem; JSON: campo "key"). Encadeável: logger.With("test_id", id).With("conn", addr).
+func (l *Logger) With(key string, value interface{}) *Logger {
+	fields := make([]field, 0, len(l.fields)+1)
+	fields = append(fields, l.fields...)
+	fields = append(fields, field{key: key, value: value})
+	return &Logger{base: l.root(), fields: fields}
+}
+
+// root retorna o logger que guarda nível, saída e formato (o próprio l, se não for filho).
+func (l *Logger) root() *Logger {
+	if l.base != nil {
+		return l.base
+	}
+	return l
+}
+
 // SetLevel define o nível mínimo de log
 func (l *Logger) SetLevel(level LogLevel) {
+	l = l.root()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.level = level
@@ -111,6 +219,7 @@ func (l *Logger) SetLevel(level LogLevel) {
 
 // GetLevel retorna o nível atual de log
 func (l *Logger) GetLevel() LogLevel {
+	l = l.root()
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	return l.level
@@ -118,14 +227,48 @@ func (l *Logger) GetLevel() LogLevel {
 
 // SetOutput define o destino de saída do log
 func (l *Logger) SetOutput(w io.Writer) {
+	l = l.root()
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	l.output = w
-	l.logger = log.New(w, l.prefix, l.flags)
+	l.resetLoggerLocked()
+}
+
+// SetFormat define o formato de saída (TextFormat ou JSONFormat).
+// Em JSON o prefixo e as flags são ignorados: cada linha é um objeto JSON completo.
+func (l *Logger) SetFormat(format Format) {
+	l = l.root()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.format = format
+	if format == JSONFormat {
+		l.formatter = jsonFormatter{}
+	} else {
+		l.formatter = textFormatter{}
+	}
+	l.resetLoggerLocked()
+}
+
+// GetFormat retorna o formato de saída atual
+func (l *Logger) GetFormat() Format {
+	l = l.root()
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.format
+}
+
+// resetLoggerLocked recria o log.Logger para a saída e o formato atuais. Chamar com l.mu travado.
+func (l *Logger) resetLoggerLocked() {
+	if l.format == JSONFormat {
+		l.logger = log.New(l.output, "", 0)
+		return
+	}
+	l.logger = log.New(l.output, l.prefix, l.flags)
 }
 
 // shouldLog verifica se o nível de log deve ser exibido
 func (l *Logger) shouldLog(level LogLevel) bool {
+	l = l.root()
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 	return level >= l.level
@@ -137,9 +280,11 @@ func (l *Logger) log(level LogLevel, format string, args ...interface{}) {
 		return
 	}
 
-	levelStr := level.String()
-	message := fmt.Sprintf(format, args...)
-	l.logger.Printf("[%s] %s", levelStr, message)
+	r := l.root()
+	r.mu.RLock()
+	out, f := r.logger, r.formatter
+	r.mu.RUnlock()
+	out.Print(f.format(level, fmt.Sprintf(format, args...), l.fields))
 }
 
 // Debug registra uma mensagem de debug
@@ -184,6 +329,11 @@ func SetDefaultLevel(level LogLevel) {
 	getDefaultLogger().SetLevel(level)
 }
 
+// With retorna um logger filho do logger padrão com o campo key=value (ver Logger.With)
+func With(key string, value interface{}) *Logger {
+	return getDefaultLogger().With(key, value)
+}
+
 // SetDefaultLevelFromString define o nível do logger padrão a partir de uma string
 func SetDefaultLevelFromString(level string) {
 	SetDefaultLevel(ParseLogLevel(level))
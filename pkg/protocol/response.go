@@ -3,9 +3,13 @@ package protocol
 import (
 	"encoding/binary"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgtype"
 )
 
 // ConvertFieldDescriptions converte FieldDescriptions do pgx para pgproto3
@@ -75,8 +79,58 @@ func RawValueToText(oid uint32, raw []byte) []byte {
 		if len(raw) == 4 {
 			return []byte(strconv.FormatInt(int64(int32(binary.BigEndian.Uint32(raw))), 10))
 		}
+	case 25, 1043, 1042, 19: // TEXT, VARCHAR, BPCHAR, NAME: binary and text are the same bytes
+		return raw
 	}
-	// TEXT and other types: assume already UTF-8
+	if text, ok := binaryToText(oid, raw); ok {
+		return text
+	}
+	// Unknown type: assume already UTF-8
 	return raw
 }
 
+// typeMap decodes binary values of the built-in types for RawValueToText; a pgtype.Map caches plans
+// and is not safe for concurrent use, hence typeMapMu.
+var (
+	typeMapMu sync.Mutex
+	typeMap   = pgtype.NewMap()
+)
+
+// binaryToText re-encodes a binary value of a built-in type (uuid, timestamptz, numeric, bool, ...) in
+// the text format PostgreSQL would have sent. ok is false for unknown types or undecodable values.
+func binaryToText(oid uint32, raw []byte) (text []byte, ok bool) {
+	typeMapMu.Lock()
+	defer typeMapMu.Unlock()
+	dt, found := typeMap.TypeForOID(oid)
+	if !found {
+		return nil, false
+	}
+	value, err := dt.Codec.DecodeValue(typeMap, oid, pgtype.BinaryFormatCode, raw)
+	if err != nil || value == nil {
+		return nil, false
+	}
+	if t, isTime := value.(time.Time); isTime && oid == pgtype.TimestamptzOID {
+		// pgtype writes "Z"; PostgreSQL (TimeZone UTC) writes "+00", which is what drivers parse.
+		return []byte(t.UTC().Format("2006-01-02 15:04:05.999999-07")), true
+	}
+	text, err = typeMap.Encode(oid, pgtype.TextFormatCode, value, nil)
+	if err != nil || text == nil {
+		return nil, false
+	}
+	return text, true
+}
+
+// NormalizeCommandTag returns the CommandComplete tag in the form the protocol documents.
+// INSERT always carries the oid field, which is 0 (tables have no OIDs): "INSERT 3" and
+// "INSERT 16384 3" both become "INSERT 0 3". Other tags are returned unchanged.
+func NormalizeCommandTag(tag string) string {
+	fields := strings.Fields(tag)
+	if len(fields) < 2 || len(fields) > 3 || fields[0] != "INSERT" {
+		return tag
+	}
+	rows := fields[len(fields)-1]
+	if _, err := strconv.ParseInt(rows, 10, 64); err != nil {
+		return tag
+	}
+	return "INSERT 0 " + rows
+}
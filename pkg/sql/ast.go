@@ -3,25 +3,31 @@
 package sql
 
 import (
+	"database/sql/driver"
+	"encoding/hex"
 	"fmt"
+	"net"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	pg_query "github.com/pganalyze/pg_query_go/v5"
 )
 
 // ParseStatements parses SQL and returns one RawStmt per statement (replaces SplitCommands).
+// Results are cached by SQL text (see parse_cache.go); the returned AST must not be modified.
 func ParseStatements(sql string) ([]*pg_query.RawStmt, error) {
-	tree, err := pg_query.Parse(sql)
-	if err != nil {
-		return nil, err
-	}
-	if tree == nil || tree.Stmts == nil {
-		return nil, nil
-	}
-	return tree.Stmts, nil
+	return statementCache.parse(sql, parseUncached)
+}
+
+// IsEmptyQuery reports whether sql has no statement at all: empty, whitespace, comments or bare
+// semicolons (e.g. the "-- ping" pgx sends on ResetSession). PostgreSQL answers such a query with
+// EmptyQueryResponse.
+func IsEmptyQuery(sql string) bool {
+	stmts, err := ParseStatements(sql)
+	return err == nil && len(stmts) == 0
 }
 
 // CommandStringFromRaw returns the SQL substring for a single RawStmt (PG uses 1-based StmtLocation).
@@ -43,6 +49,7 @@ func CommandStringFromRaw(query string, raw *pg_query.RawStmt) string {
 }
 
 // ClassifyStatement returns the statement kind: SELECT, INSERT, UPDATE, DELETE, BEGIN, COMMIT, ROLLBACK, SAVEPOINT, RELEASE, DEALLOCATE, SET, CREATE, DROP, OTHER.
+// The kind is that of the main statement after any WITH, like PostgreSQL's command tag; see DataModifyingCTE.
 func ClassifyStatement(stmt *pg_query.Node) string {
 	if stmt == nil {
 		return "OTHER"
@@ -92,73 +99,278 @@ func ClassifyStatement(stmt *pg_query.Node) string {
 	return "OTHER"
 }
 
-// returningColumnName extracts a single column name from a RETURNING list item (Node).
-// Returns "" if the item is RETURNING * or an expression we can't describe (e.g. expr AS alias).
+// StatementTag returns the command PostgreSQL would name stmt by, like its command tag: "SELECT",
+// "DROP TABLE", "DROP DATABASE", "ALTER SYSTEM", "CREATE ROLE", "GRANT", ... Statements it does not
+// know get their ClassifyStatement kind. Used to match statements against configured lists.
+func StatementTag(stmt *pg_query.Node) string {
+	if stmt == nil {
+		return "OTHER"
+	}
+	switch n := stmt.Node.(type) {
+	case *pg_query.Node_MergeStmt:
+		return "MERGE"
+	case *pg_query.Node_TransactionStmt:
+		switch n.TransactionStmt.GetKind() {
+		case pg_query.TransactionStmtKind_TRANS_STMT_PREPARE:
+			return "PREPARE TRANSACTION"
+		case pg_query.TransactionStmtKind_TRANS_STMT_COMMIT_PREPARED:
+			return "COMMIT PREPARED"
+		case pg_query.TransactionStmtKind_TRANS_STMT_ROLLBACK_PREPARED:
+			return "ROLLBACK PREPARED"
+		}
+	case *pg_query.Node_VariableSetStmt:
+		if n.VariableSetStmt.GetKind() == pg_query.VariableSetKind_VAR_RESET || n.VariableSetStmt.GetKind() == pg_query.VariableSetKind_VAR_RESET_ALL {
+			return "RESET"
+		}
+	case *pg_query.Node_VariableShowStmt:
+		return "SHOW"
+	case *pg_query.Node_CreateStmt:
+		return "CREATE TABLE"
+	case *pg_query.Node_CreateTableAsStmt:
+		if n.CreateTableAsStmt.GetObjtype() == pg_query.ObjectType_OBJECT_MATVIEW {
+			return "CREATE MATERIALIZED VIEW"
+		}
+		return "CREATE TABLE AS"
+	case *pg_query.Node_ViewStmt:
+		return "CREATE VIEW"
+	case *pg_query.Node_IndexStmt:
+		return "CREATE INDEX"
+	case *pg_query.Node_CreateSchemaStmt:
+		return "CREATE SCHEMA"
+	case *pg_query.Node_CreateSeqStmt:
+		return "CREATE SEQUENCE"
+	case *pg_query.Node_CreateExtensionStmt:
+		return "CREATE EXTENSION"
+	case *pg_query.Node_CreateFunctionStmt:
+		if n.CreateFunctionStmt.GetIsProcedure() {
+			return "CREATE PROCEDURE"
+		}
+		return "CREATE FUNCTION"
+	case *pg_query.Node_CreateTrigStmt:
+		return "CREATE TRIGGER"
+	case *pg_query.Node_CreateEnumStmt, *pg_query.Node_CompositeTypeStmt, *pg_query.Node_CreateRangeStmt:
+		return "CREATE TYPE"
+	case *pg_query.Node_CreateDomainStmt:
+		return "CREATE DOMAIN"
+	case *pg_query.Node_DefineStmt:
+		return "CREATE " + objectTypeName(n.DefineStmt.GetKind())
+	case *pg_query.Node_CreatedbStmt:
+		return "CREATE DATABASE"
+	case *pg_query.Node_CreateRoleStmt:
+		return "CREATE ROLE"
+	case *pg_query.Node_CreateTableSpaceStmt:
+		return "CREATE TABLESPACE"
+	case *pg_query.Node_CreatePolicyStmt:
+		return "CREATE POLICY"
+	case *pg_query.Node_CreatePublicationStmt:
+		return "CREATE PUBLICATION"
+	case *pg_query.Node_CreateSubscriptionStmt:
+		return "CREATE SUBSCRIPTION"
+	case *pg_query.Node_DropStmt:
+		return "DROP " + objectTypeName(n.DropStmt.GetRemoveType())
+	case *pg_query.Node_DropdbStmt:
+		return "DROP DATABASE"
+	case *pg_query.Node_DropRoleStmt:
+		return "DROP ROLE"
+	case *pg_query.Node_DropTableSpaceStmt:
+		return "DROP TABLESPACE"
+	case *pg_query.Node_DropOwnedStmt:
+		return "DROP OWNED"
+	case *pg_query.Node_DropSubscriptionStmt:
+		return "DROP SUBSCRIPTION"
+	case *pg_query.Node_AlterTableStmt:
+		return "ALTER " + objectTypeName(n.AlterTableStmt.GetObjtype())
+	case *pg_query.Node_RenameStmt:
+		return "ALTER " + objectTypeName(n.RenameStmt.GetRenameType())
+	case *pg_query.Node_AlterOwnerStmt:
+		return "ALTER " + objectTypeName(n.AlterOwnerStmt.GetObjectType())
+	case *pg_query.Node_AlterObjectSchemaStmt:
+		return "ALTER " + objectTypeName(n.AlterObjectSchemaStmt.GetObjectType())
+	case *pg_query.Node_AlterSeqStmt:
+		return "ALTER SEQUENCE"
+	case *pg_query.Node_AlterFunctionStmt:
+		return "ALTER FUNCTION"
+	case *pg_query.Node_AlterDatabaseStmt, *pg_query.Node_AlterDatabaseSetStmt, *pg_query.Node_AlterDatabaseRefreshCollStmt:
+		return "ALTER DATABASE"
+	case *pg_query.Node_AlterRoleStmt, *pg_query.Node_AlterRoleSetStmt:
+		return "ALTER ROLE"
+	case *pg_query.Node_AlterSystemStmt:
+		return "ALTER SYSTEM"
+	case *pg_query.Node_AlterExtensionStmt, *pg_query.Node_AlterExtensionContentsStmt:
+		return "ALTER EXTENSION"
+	case *pg_query.Node_AlterDefaultPrivilegesStmt:
+		return "ALTER DEFAULT PRIVILEGES"
+	case *pg_query.Node_GrantStmt:
+		if n.GrantStmt.GetIsGrant() {
+			return "GRANT"
+		}
+		return "REVOKE"
+	case *pg_query.Node_GrantRoleStmt:
+		if n.GrantRoleStmt.GetIsGrant() {
+			return "GRANT ROLE"
+		}
+		return "REVOKE ROLE"
+	case *pg_query.Node_ReassignOwnedStmt:
+		return "REASSIGN OWNED"
+	case *pg_query.Node_TruncateStmt:
+		return "TRUNCATE TABLE"
+	case *pg_query.Node_CopyStmt:
+		return "COPY"
+	case *pg_query.Node_CommentStmt:
+		return "COMMENT"
+	case *pg_query.Node_LockStmt:
+		return "LOCK TABLE"
+	case *pg_query.Node_VacuumStmt:
+		if n.VacuumStmt.GetIsVacuumcmd() {
+			return "VACUUM"
+		}
+		return "ANALYZE"
+	case *pg_query.Node_ClusterStmt:
+		return "CLUSTER"
+	case *pg_query.Node_ReindexStmt:
+		return "REINDEX"
+	case *pg_query.Node_RefreshMatViewStmt:
+		return "REFRESH MATERIALIZED VIEW"
+	case *pg_query.Node_CheckPointStmt:
+		return "CHECKPOINT"
+	case *pg_query.Node_LoadStmt:
+		return "LOAD"
+	case *pg_query.Node_ExplainStmt:
+		return "EXPLAIN"
+	case *pg_query.Node_DoStmt:
+		return "DO"
+	case *pg_query.Node_CallStmt:
+		return "CALL"
+	case *pg_query.Node_PrepareStmt:
+		return "PREPARE"
+	case *pg_query.Node_ExecuteStmt:
+		return "EXECUTE"
+	case *pg_query.Node_DiscardStmt:
+		return "DISCARD"
+	case *pg_query.Node_NotifyStmt:
+		return "NOTIFY"
+	case *pg_query.Node_ListenStmt:
+		return "LISTEN"
+	case *pg_query.Node_UnlistenStmt:
+		return "UNLISTEN"
+	case *pg_query.Node_DeclareCursorStmt:
+		return "DECLARE CURSOR"
+	case *pg_query.Node_FetchStmt:
+		return "FETCH"
+	case *pg_query.Node_ClosePortalStmt:
+		return "CLOSE CURSOR"
+	}
+	return ClassifyStatement(stmt)
+}
+
+// objectTypeName turns OBJECT_FOREIGN_TABLE into "FOREIGN TABLE", as PostgreSQL writes it in command tags.
+func objectTypeName(t pg_query.ObjectType) string {
+	switch t {
+	case pg_query.ObjectType_OBJECT_MATVIEW:
+		return "MATERIALIZED VIEW"
+	case pg_query.ObjectType_OBJECT_TABCONSTRAINT, pg_query.ObjectType_OBJECT_COLUMN:
+		return "TABLE"
+	case pg_query.ObjectType_OBJECT_TSCONFIGURATION:
+		return "TEXT SEARCH CONFIGURATION"
+	case pg_query.ObjectType_OBJECT_TSDICTIONARY:
+		return "TEXT SEARCH DICTIONARY"
+	}
+	return strings.ReplaceAll(strings.TrimPrefix(t.String(), "OBJECT_"), "_", " ")
+}
+
+// returningColumnName returns the output column name of a RETURNING list item: its alias, else the name
+// PostgreSQL derives from the expression (column, function or cast type name), else "?column?".
+// Returns "" for RETURNING * or tbl.*, whose columns depend on the table.
 func returningColumnName(n *pg_query.Node) string {
 	if n == nil {
 		return ""
 	}
-	rt := n.GetResTarget()
-	if rt != nil {
+	if rt := n.GetResTarget(); rt != nil {
 		if name := rt.GetName(); name != "" {
 			return name
 		}
-		// ResTarget with empty name: val is often ColumnRef (e.g. RETURNING id)
-		if val := rt.GetVal(); val != nil {
-			if cr := val.GetColumnRef(); cr != nil {
-				return columnRefName(cr)
-			}
-		}
+		n = rt.GetVal()
+	}
+	if isStarRef(n) {
 		return ""
 	}
-	// Direct ColumnRef in RETURNING list (some parses)
-	if cr := n.GetColumnRef(); cr != nil {
-		return columnRefName(cr)
+	if name := exprColumnName(n); name != "" {
+		return name
 	}
-	return ""
+	return "?column?"
 }
 
-// columnRefName returns the single column name from a ColumnRef (first field as string), or "".
-func columnRefName(cr *pg_query.ColumnRef) string {
-	fields := cr.GetFields()
-	if len(fields) == 0 {
+// isStarRef is true for * or tbl.* (a ColumnRef ending in A_Star).
+func isStarRef(n *pg_query.Node) bool {
+	fields := n.GetColumnRef().GetFields()
+	return len(fields) > 0 && fields[len(fields)-1].GetAStar() != nil
+}
+
+// exprColumnName is PostgreSQL's FigureColname for the expressions clients usually return: the last
+// field of a column reference, the function name of a call, the argument's name of a cast (else the
+// type name), "case" and "coalesce". Returns "" when the name would be "?column?".
+func exprColumnName(n *pg_query.Node) string {
+	switch {
+	case n == nil:
 		return ""
+	case n.GetColumnRef() != nil:
+		return columnRefName(n.GetColumnRef())
+	case n.GetFuncCall() != nil:
+		return lastStringName(n.GetFuncCall().GetFuncname())
+	case n.GetTypeCast() != nil:
+		if name := exprColumnName(n.GetTypeCast().GetArg()); name != "" {
+			return name
+		}
+		return lastStringName(n.GetTypeCast().GetTypeName().GetNames())
+	case n.GetCaseExpr() != nil:
+		return "case"
+	case n.GetCoalesceExpr() != nil:
+		return "coalesce"
 	}
-	// Simple column: one field, String node with the identifier
-	first := fields[0]
-	if first == nil {
+	return ""
+}
+
+// columnRefName returns the column name of a ColumnRef (its last field: "id" for t.id), or "".
+func columnRefName(cr *pg_query.ColumnRef) string {
+	return lastStringName(cr.GetFields())
+}
+
+// lastStringName returns the last element of a qualified name (String nodes), or "".
+func lastStringName(names []*pg_query.Node) string {
+	if len(names) == 0 {
 		return ""
 	}
-	if s := first.GetString_(); s != nil {
-		return s.GetSval()
-	}
-	return ""
+	return names[len(names)-1].GetString_().GetSval()
+}
+
+// returningList returns the RETURNING list of an INSERT/UPDATE/DELETE stmt; nil for other statements.
+func returningList(stmt *pg_query.Node) []*pg_query.Node {
+	switch {
+	case stmt == nil:
+		return nil
+	case stmt.GetInsertStmt() != nil:
+		return stmt.GetInsertStmt().GetReturningList()
+	case stmt.GetUpdateStmt() != nil:
+		return stmt.GetUpdateStmt().GetReturningList()
+	case stmt.GetDeleteStmt() != nil:
+		return stmt.GetDeleteStmt().GetReturningList()
+	}
+	return nil
 }
 
 // GetReturningColumns extracts RETURNING column names from INSERT/UPDATE/DELETE stmt (for RowDescription).
-// Returns nil for RETURNING * or when no RETURNING clause.
+// Expressions are named like PostgreSQL does (alias, column, function, "?column?"). Returns nil when there
+// is no RETURNING clause, or when it has * or tbl.*: those columns depend on the table, so the caller
+// describes the statement on the backend instead.
 func GetReturningColumns(stmt *pg_query.Node) []ReturningColumn {
-	if stmt == nil {
+	list := returningList(stmt)
+	if len(list) == 0 {
 		return nil
 	}
-	var list []*pg_query.Node
-	if s := stmt.GetInsertStmt(); s != nil {
-		list = s.GetReturningList()
-	}
-	if s := stmt.GetUpdateStmt(); s != nil {
-		list = s.GetReturningList()
-	}
-	if s := stmt.GetDeleteStmt(); s != nil {
-		list = s.GetReturningList()
-	}
-	if list == nil || len(list) == 0 {
-		return nil
-	}
-	var cols []ReturningColumn
+	cols := make([]ReturningColumn, 0, len(list))
 	for _, n := range list {
 		name := returningColumnName(n)
 		if name == "" {
-			// RETURNING * or unsupported expression
 			return nil
 		}
 		oid := uint32(TEXTOID)
@@ -170,6 +382,11 @@ func GetReturningColumns(stmt *pg_query.Node) []ReturningColumn {
 	return cols
 }
 
+// HasReturning is true for INSERT/UPDATE/DELETE with a RETURNING clause, including RETURNING *.
+func HasReturning(stmt *pg_query.Node) bool {
+	return len(returningList(stmt)) > 0
+}
+
 // StmtReturnsResultSet is true for SELECT or for INSERT/UPDATE/DELETE with RETURNING (AST-based).
 func StmtReturnsResultSet(stmt *pg_query.Node) bool {
 	if stmt == nil {
@@ -178,7 +395,80 @@ func StmtReturnsResultSet(stmt *pg_query.Node) bool {
 	if stmt.GetSelectStmt() != nil {
 		return true
 	}
-	return len(GetReturningColumns(stmt)) > 0
+	return HasReturning(stmt)
+}
+
+// IsPlainSelect is true for a SELECT that only reads: no SELECT INTO, no FOR UPDATE/SHARE and no
+// data-modifying WITH (INSERT/UPDATE/DELETE in a CTE). Functions with side effects are not detected.
+func IsPlainSelect(stmt *pg_query.Node) bool {
+	if stmt == nil {
+		return false
+	}
+	sel := stmt.GetSelectStmt()
+	if sel == nil || sel.GetIntoClause() != nil || len(sel.GetLockingClause()) > 0 {
+		return false
+	}
+	return DataModifyingCTE(stmt) == ""
+}
+
+// DataModifyingCTE returns the kind (INSERT, UPDATE or DELETE) of the first data-modifying statement in
+// the WITH clause of stmt, or "" when there is none. WITH upd AS (UPDATE ... RETURNING *) SELECT * FROM upd
+// is classified SELECT, as PostgreSQL tags it, but writes: callers that route reads use this.
+func DataModifyingCTE(stmt *pg_query.Node) string {
+	if stmt == nil {
+		return ""
+	}
+	var with *pg_query.WithClause
+	switch {
+	case stmt.GetSelectStmt() != nil:
+		with = stmt.GetSelectStmt().GetWithClause()
+	case stmt.GetInsertStmt() != nil:
+		with = stmt.GetInsertStmt().GetWithClause()
+	case stmt.GetUpdateStmt() != nil:
+		with = stmt.GetUpdateStmt().GetWithClause()
+	case stmt.GetDeleteStmt() != nil:
+		with = stmt.GetDeleteStmt().GetWithClause()
+	}
+	for _, cte := range with.GetCtes() {
+		switch kind := ClassifyStatement(cte.GetCommonTableExpr().GetCtequery()); kind {
+		case "INSERT", "UPDATE", "DELETE":
+			return kind
+		}
+	}
+	return ""
+}
+
+// CommandInfo describes the first statement of a query (AnalyzeCommand).
+type CommandInfo struct {
+	Type         string // ClassifyStatement kind; "OTHER" when the query does not parse or is empty
+	ReturnsRows  bool   // SELECT, or INSERT/UPDATE/DELETE ... RETURNING (also inside WITH)
+	ModifiesData bool   // INSERT/UPDATE/DELETE, or any statement whose WITH runs one (DataModifyingCTE)
+	IsTCL        bool   // BEGIN, COMMIT, ROLLBACK [TO SAVEPOINT], SAVEPOINT, RELEASE
+}
+
+// AnalyzeCommand classifies the first statement of sql from the AST, so a leading WITH or comments
+// do not hide the statement kind (WITH x AS (...) SELECT is a SELECT; WITH ... INSERT ... RETURNING
+// is an INSERT that returns rows).
+func AnalyzeCommand(sql string) CommandInfo {
+	stmts, err := ParseStatements(sql)
+	if err != nil || len(stmts) == 0 || stmts[0].Stmt == nil {
+		return CommandInfo{Type: "OTHER"}
+	}
+	stmt := stmts[0].Stmt
+	kind := ClassifyStatement(stmt)
+	return CommandInfo{
+		Type:         kind,
+		ReturnsRows:  StmtReturnsResultSet(stmt),
+		ModifiesData: kind == "INSERT" || kind == "UPDATE" || kind == "DELETE" || DataModifyingCTE(stmt) != "",
+		IsTCL:        stmt.GetTransactionStmt() != nil,
+	}
+}
+
+// IsSelect is true when the first statement of sql is a SELECT that only reads (including WITH ... SELECT,
+// VALUES and TABLE). A SELECT whose WITH runs INSERT/UPDATE/DELETE is not: it must not take the read path.
+func IsSelect(sql string) bool {
+	info := AnalyzeCommand(sql)
+	return info.Type == "SELECT" && !info.ModifiesData
 }
 
 // ParseDeallocate returns (name, isAll, true) for a DEALLOCATE statement; otherwise ("", false, false).
@@ -197,12 +487,425 @@ func ParseDeallocate(stmt *pg_query.Node) (name string, isAll bool, ok bool) {
 	return n, false, true
 }
 
+// IsDiscard returns true for DISCARD ALL / PLANS / SEQUENCES / TEMP.
+func IsDiscard(stmt *pg_query.Node) bool {
+	return stmt != nil && stmt.GetDiscardStmt() != nil
+}
+
+// DiscardTarget returns what a DISCARD statement discards ("ALL", "PLANS", "SEQUENCES" or "TEMP"), as in
+// its command tag; "" when stmt is not DISCARD.
+func DiscardTarget(stmt *pg_query.Node) string {
+	if !IsDiscard(stmt) {
+		return ""
+	}
+	switch stmt.GetDiscardStmt().GetTarget() {
+	case pg_query.DiscardMode_DISCARD_ALL:
+		return "ALL"
+	case pg_query.DiscardMode_DISCARD_PLANS:
+		return "PLANS"
+	case pg_query.DiscardMode_DISCARD_SEQUENCES:
+		return "SEQUENCES"
+	case pg_query.DiscardMode_DISCARD_TEMP:
+		return "TEMP"
+	}
+	return ""
+}
+
+// CopyStmt describes a COPY statement: direction, whether it uses the client connection (STDIN/STDOUT),
+// the target table and column list, and the data format.
+type CopyStmt struct {
+	IsFrom        bool     // COPY ... FROM (client sends data); false for COPY ... TO
+	IsStdio       bool     // FROM STDIN / TO STDOUT (not a server file or PROGRAM)
+	Binary        bool     // FORMAT binary
+	Relation      string   // schema-qualified table name when the statement names a table; "" for COPY (query)
+	RelationParts []string // Relation as unquoted identifiers (schema if given, then table), for quoting
+	Columns       []string // explicit column list; empty means all columns
+	Query         string   // deparsed inner query for COPY (query) TO; "" when the statement names a table
+}
+
+// ParseCopy returns the COPY details and true when stmt is a COPY statement (AST-based).
+func ParseCopy(stmt *pg_query.Node) (CopyStmt, bool) {
+	if stmt == nil {
+		return CopyStmt{}, false
+	}
+	c := stmt.GetCopyStmt()
+	if c == nil {
+		return CopyStmt{}, false
+	}
+	info := CopyStmt{
+		IsFrom:  c.GetIsFrom(),
+		IsStdio: c.GetFilename() == "" && !c.GetIsProgram(),
+	}
+	if rel := c.GetRelation(); rel != nil {
+		info.Relation = rel.GetRelname()
+		info.RelationParts = []string{rel.GetRelname()}
+		if schema := rel.GetSchemaname(); schema != "" {
+			info.Relation = schema + "." + info.Relation
+			info.RelationParts = []string{schema, rel.GetRelname()}
+		}
+	}
+	if q := c.GetQuery(); q != nil {
+		if deparsed, err := pg_query.Deparse(&pg_query.ParseResult{Stmts: []*pg_query.RawStmt{{Stmt: q}}}); err == nil {
+			info.Query = deparsed
+		}
+	}
+	for _, col := range c.GetAttlist() {
+		if str := col.GetString_(); str != nil {
+			info.Columns = append(info.Columns, str.GetSval())
+		}
+	}
+	for _, opt := range c.GetOptions() {
+		def := opt.GetDefElem()
+		if def == nil || !strings.EqualFold(def.GetDefname(), "format") {
+			continue
+		}
+		if str := def.GetArg().GetString_(); str != nil && strings.EqualFold(str.GetSval(), "binary") {
+			info.Binary = true
+		}
+	}
+	return info, true
+}
+
+// SchemaObject is a table, sequence, view, materialized view, index or schema named by a CREATE or DROP.
+type SchemaObject struct {
+	Kind      string // "TABLE", "SEQUENCE", "VIEW", "MATERIALIZED VIEW", "INDEX" or "SCHEMA"
+	Name      string // as written in the statement: schema-qualified only when it was
+	Temporary bool   // CREATE TEMPORARY TABLE / SEQUENCE / VIEW
+}
+
+// String returns the object as "table public.t", "temporary table t", ...
+func (o SchemaObject) String() string {
+	kind := strings.ToLower(o.Kind)
+	if o.Temporary {
+		kind = "temporary " + kind
+	}
+	return kind + " " + o.Name
+}
+
+// SchemaObjectChanges returns the objects stmt creates or drops (AST-based); nothing for other statements.
+// Names are not resolved against search_path, and an index created without a name is left out (PostgreSQL
+// picks its name). So are the statements that may leave the object as it was: CREATE ... IF NOT EXISTS,
+// CREATE OR REPLACE VIEW and DROP ... IF EXISTS; whether they changed anything is only known to the server.
+func SchemaObjectChanges(stmt *pg_query.Node) (created, dropped []SchemaObject) {
+	if stmt == nil {
+		return nil, nil
+	}
+	switch n := stmt.Node.(type) {
+	case *pg_query.Node_CreateStmt:
+		if n.CreateStmt.GetIfNotExists() {
+			return nil, nil
+		}
+		return []SchemaObject{rangeVarObject("TABLE", n.CreateStmt.GetRelation())}, nil
+	case *pg_query.Node_CreateSeqStmt:
+		if n.CreateSeqStmt.GetIfNotExists() {
+			return nil, nil
+		}
+		return []SchemaObject{rangeVarObject("SEQUENCE", n.CreateSeqStmt.GetSequence())}, nil
+	case *pg_query.Node_ViewStmt:
+		if n.ViewStmt.GetReplace() {
+			return nil, nil
+		}
+		return []SchemaObject{rangeVarObject("VIEW", n.ViewStmt.GetView())}, nil
+	case *pg_query.Node_CreateTableAsStmt:
+		if n.CreateTableAsStmt.GetIfNotExists() {
+			return nil, nil
+		}
+		kind := "TABLE"
+		if n.CreateTableAsStmt.GetObjtype() == pg_query.ObjectType_OBJECT_MATVIEW {
+			kind = "MATERIALIZED VIEW"
+		}
+		return []SchemaObject{rangeVarObject(kind, n.CreateTableAsStmt.GetInto().GetRel())}, nil
+	case *pg_query.Node_IndexStmt:
+		name := n.IndexStmt.GetIdxname()
+		if name == "" || n.IndexStmt.GetIfNotExists() {
+			return nil, nil
+		}
+		// The index lives in its table's schema.
+		if schema := n.IndexStmt.GetRelation().GetSchemaname(); schema != "" {
+			name = schema + "." + name
+		}
+		return []SchemaObject{{Kind: "INDEX", Name: name}}, nil
+	case *pg_query.Node_CreateSchemaStmt:
+		if name := n.CreateSchemaStmt.GetSchemaname(); name != "" && !n.CreateSchemaStmt.GetIfNotExists() {
+			return []SchemaObject{{Kind: "SCHEMA", Name: name}}, nil
+		}
+	case *pg_query.Node_DropStmt:
+		if n.DropStmt.GetMissingOk() {
+			return nil, nil
+		}
+		switch t := n.DropStmt.GetRemoveType(); t {
+		case pg_query.ObjectType_OBJECT_TABLE, pg_query.ObjectType_OBJECT_SEQUENCE, pg_query.ObjectType_OBJECT_VIEW,
+			pg_query.ObjectType_OBJECT_MATVIEW, pg_query.ObjectType_OBJECT_INDEX, pg_query.ObjectType_OBJECT_SCHEMA:
+			for _, obj := range n.DropStmt.GetObjects() {
+				if name := qualifiedName(obj); name != "" {
+					dropped = append(dropped, SchemaObject{Kind: objectTypeName(t), Name: name})
+				}
+			}
+		}
+	}
+	return nil, dropped
+}
+
+// rangeVarObject returns the object of kind named by rv.
+func rangeVarObject(kind string, rv *pg_query.RangeVar) SchemaObject {
+	name := rv.GetRelname()
+	if schema := rv.GetSchemaname(); schema != "" {
+		name = schema + "." + name
+	}
+	return SchemaObject{Kind: kind, Name: name, Temporary: rv.GetRelpersistence() == "t"}
+}
+
+// qualifiedName joins the names of a DROP object (a List of String nodes, or one String for a schema).
+func qualifiedName(n *pg_query.Node) string {
+	if str := n.GetString_(); str != nil {
+		return str.GetSval()
+	}
+	var parts []string
+	for _, item := range n.GetList().GetItems() {
+		parts = append(parts, item.GetString_().GetSval())
+	}
+	return strings.Join(parts, ".")
+}
+
 // paramRefPos holds location (1-based in PG) and param number for substitution.
 type paramRefPos struct {
 	location int
 	number   int32
 }
 
+// VariableSet describes a SET / SET LOCAL / RESET of one run-time parameter (or RESET ALL).
+type VariableSet struct {
+	Name     string // lower-case parameter name; "" for RESET ALL
+	IsLocal  bool   // SET LOCAL
+	IsReset  bool   // RESET name or SET name TO DEFAULT
+	ResetAll bool   // RESET ALL
+	SetSQL   string // session-level "SET name TO value" (deparsed, without LOCAL); "" for resets
+	Tag      string // CommandComplete tag: "SET" or "RESET"
+}
+
+// ChangesRole reports whether v sets or resets the role privileges are checked against: SET ROLE,
+// RESET ROLE, SET SESSION AUTHORIZATION or RESET SESSION AUTHORIZATION.
+func (v VariableSet) ChangesRole() bool {
+	return v.Name == "role" || v.Name == "session_authorization"
+}
+
+// ParseVariableSet returns the SET/RESET details and true when stmt sets or resets a parameter.
+// SET ... FROM CURRENT and multi-parameter forms (SET TRANSACTION ...) are not reported.
+func ParseVariableSet(stmt *pg_query.Node) (VariableSet, bool) {
+	if stmt == nil {
+		return VariableSet{}, false
+	}
+	v := stmt.GetVariableSetStmt()
+	if v == nil {
+		return VariableSet{}, false
+	}
+	info := VariableSet{Name: strings.ToLower(v.GetName()), IsLocal: v.GetIsLocal(), Tag: "SET"}
+	switch v.GetKind() {
+	case pg_query.VariableSetKind_VAR_RESET_ALL:
+		info.Name = ""
+		info.ResetAll = true
+		info.Tag = "RESET"
+	case pg_query.VariableSetKind_VAR_RESET:
+		info.IsReset = true
+		info.Tag = "RESET"
+	case pg_query.VariableSetKind_VAR_SET_DEFAULT:
+		info.IsReset = true
+	case pg_query.VariableSetKind_VAR_SET_VALUE:
+		session := &pg_query.Node{Node: &pg_query.Node_VariableSetStmt{VariableSetStmt: &pg_query.VariableSetStmt{
+			Kind: v.GetKind(),
+			Name: v.GetName(),
+			Args: v.GetArgs(),
+		}}}
+		deparsed, err := pg_query.Deparse(&pg_query.ParseResult{Stmts: []*pg_query.RawStmt{{Stmt: session}}})
+		if err != nil {
+			return VariableSet{}, false
+		}
+		info.SetSQL = deparsed
+	default:
+		return VariableSet{}, false
+	}
+	return info, true
+}
+
+// reportedParameters maps the lower-case name of each parameter PostgreSQL reports with ParameterStatus
+// (GUC_REPORT) to the spelling it uses in that message.
+var reportedParameters = map[string]string{
+	"application_name":              "application_name",
+	"client_encoding":               "client_encoding",
+	"datestyle":                     "DateStyle",
+	"default_transaction_read_only": "default_transaction_read_only",
+	"in_hot_standby":                "in_hot_standby",
+	"integer_datetimes":             "integer_datetimes",
+	"intervalstyle":                 "IntervalStyle",
+	"is_superuser":                  "is_superuser",
+	"scram_iterations":              "scram_iterations",
+	"server_encoding":               "server_encoding",
+	"server_version":                "server_version",
+	"session_authorization":         "session_authorization",
+	"standard_conforming_strings":   "standard_conforming_strings",
+	"timezone":                      "TimeZone",
+}
+
+// ReportedParameterSets returns the ParameterStatus names (e.g. "TimeZone", "client_encoding") of the
+// reported parameters the SET/RESET statements of query may change, in statement order without
+// duplicates; RESET ALL yields all of them. nil when query sets none or cannot be parsed.
+func ReportedParameterSets(query string) []string {
+	stmts, err := ParseStatements(query)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	seen := make(map[string]bool)
+	add := func(name string) {
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, raw := range stmts {
+		vs, ok := ParseVariableSet(raw.Stmt)
+		if !ok {
+			continue
+		}
+		if vs.ResetAll {
+			all := make([]string, 0, len(reportedParameters))
+			for _, name := range reportedParameters {
+				all = append(all, name)
+			}
+			sort.Strings(all)
+			for _, name := range all {
+				add(name)
+			}
+			continue
+		}
+		if name, ok := reportedParameters[vs.Name]; ok {
+			add(name)
+		}
+	}
+	return names
+}
+
+// ApplicationNameSet returns the new value and true when stmt is SET [LOCAL] application_name to a
+// string (SET application_name = 'x' or TO x). Resets and DEFAULT are not reported.
+func ApplicationNameSet(stmt *pg_query.Node) (string, bool) {
+	v := stmt.GetVariableSetStmt()
+	if v == nil || v.GetKind() != pg_query.VariableSetKind_VAR_SET_VALUE || !strings.EqualFold(v.GetName(), "application_name") {
+		return "", false
+	}
+	if len(v.GetArgs()) != 1 {
+		return "", false
+	}
+	c := v.GetArgs()[0].GetAConst()
+	if c == nil || c.GetSval() == nil {
+		return "", false
+	}
+	return c.GetSval().GetSval(), true
+}
+
+// TransactionOptions are the characteristics given on BEGIN / START TRANSACTION, in SQL spelling
+// ("SERIALIZABLE", "READ ONLY", ...). Empty fields were not given.
+type TransactionOptions struct {
+	IsolationLevel string // e.g. "SERIALIZABLE", "REPEATABLE READ"
+	AccessMode     string // "READ ONLY" or "READ WRITE"
+	Deferrable     string // "DEFERRABLE" or "NOT DEFERRABLE"
+}
+
+// IsZero reports whether no characteristic was given (plain BEGIN).
+func (o TransactionOptions) IsZero() bool {
+	return o == TransactionOptions{}
+}
+
+// String lists the given characteristics as in the statement, e.g. "ISOLATION LEVEL SERIALIZABLE, READ ONLY".
+func (o TransactionOptions) String() string {
+	var parts []string
+	if o.IsolationLevel != "" {
+		parts = append(parts, "ISOLATION LEVEL "+o.IsolationLevel)
+	}
+	if o.AccessMode != "" {
+		parts = append(parts, o.AccessMode)
+	}
+	if o.Deferrable != "" {
+		parts = append(parts, o.Deferrable)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// ParseTransactionOptions returns the characteristics of a BEGIN / START TRANSACTION and true when
+// stmt is one; a plain BEGIN gives zero options.
+func ParseTransactionOptions(stmt *pg_query.Node) (TransactionOptions, bool) {
+	if !IsTransactionBegin(stmt) {
+		return TransactionOptions{}, false
+	}
+	var opts TransactionOptions
+	for _, o := range GetTransactionOptions(stmt) {
+		switch o.Name {
+		case "transaction_isolation":
+			opts.IsolationLevel = strings.ToUpper(o.Value)
+		case "transaction_read_only":
+			opts.AccessMode = "READ WRITE"
+			if o.Value == "on" {
+				opts.AccessMode = "READ ONLY"
+			}
+		case "transaction_deferrable":
+			opts.Deferrable = "NOT DEFERRABLE"
+			if o.Value == "on" {
+				opts.Deferrable = "DEFERRABLE"
+			}
+		}
+	}
+	return opts, true
+}
+
+// TransactionOption is one transaction characteristic as the run-time parameter it sets:
+// Name is transaction_isolation, transaction_read_only or transaction_deferrable; Value is the
+// isolation level in lower case ("serializable") or "on"/"off". "SET name = value" applies it.
+type TransactionOption struct {
+	Name  string
+	Value string
+}
+
+// IsSetTransaction returns true for SET TRANSACTION <characteristics>. SET TRANSACTION SNAPSHOT and
+// SET SESSION CHARACTERISTICS AS TRANSACTION are not reported.
+func IsSetTransaction(stmt *pg_query.Node) bool {
+	if stmt == nil {
+		return false
+	}
+	v := stmt.GetVariableSetStmt()
+	return v != nil && v.GetKind() == pg_query.VariableSetKind_VAR_SET_MULTI && v.GetName() == "TRANSACTION"
+}
+
+// GetTransactionOptions returns the characteristics given on BEGIN / START TRANSACTION or
+// SET TRANSACTION, in statement order; nil for any other statement or when none are given.
+func GetTransactionOptions(stmt *pg_query.Node) []TransactionOption {
+	var elems []*pg_query.Node
+	switch {
+	case IsTransactionBegin(stmt):
+		elems = stmt.GetTransactionStmt().GetOptions()
+	case IsSetTransaction(stmt):
+		elems = stmt.GetVariableSetStmt().GetArgs()
+	default:
+		return nil
+	}
+	var opts []TransactionOption
+	for _, e := range elems {
+		d := e.GetDefElem()
+		if d == nil {
+			continue
+		}
+		c := d.GetArg().GetAConst()
+		o := TransactionOption{Name: d.GetDefname()}
+		if d.GetDefname() == "transaction_isolation" {
+			o.Value = strings.ToLower(c.GetSval().GetSval())
+		} else if c.GetIval().GetIval() != 0 {
+			o.Value = "on"
+		} else {
+			o.Value = "off"
+		}
+		opts = append(opts, o)
+	}
+	return opts
+}
+
 // collectParamRefs appends all ParamRef (location, number) from the AST into out.
 func collectParamRefs(node *pg_query.Node, out *[]paramRefPos) {
 	walkNodeTree(node, func(n *pg_query.Node) {
@@ -300,6 +1003,10 @@ func formatArgForSQL(v any) string {
 	if v == nil {
 		return "NULL"
 	}
+	// A typed nil pointer is NULL too; calling Value on it (driver.Valuer below) would panic.
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Pointer && rv.IsNil() {
+		return "NULL"
+	}
 	switch x := v.(type) {
 	case int32:
 		return strconv.FormatInt(int64(x), 10)
@@ -317,42 +1024,81 @@ func formatArgForSQL(v any) string {
 		}
 		return "false"
 	case []byte:
-		return "'" + escapeSQLString(string(x)) + "'"
+		return `'\x` + hex.EncodeToString(x) + "'" // bytea hex format
 	case string:
 		return "'" + escapeSQLString(x) + "'"
-	default:
-		return "'" + escapeSQLString(fmt.Sprint(v)) + "'"
+	case time.Time:
+		return "'" + x.Format(sqlTimestampLayout) + "'"
+	case net.IP:
+		if x == nil {
+			return "NULL"
+		}
+		return "'" + x.String() + "'"
+	case net.IPNet:
+		return "'" + x.String() + "'"
+	case *net.IPNet:
+		return "'" + x.String() + "'"
+	case driver.Valuer:
+		// pgtype values, sql.Null*, uuid types: render what they would send to the database.
+		dv, err := x.Value()
+		if err != nil {
+			return "'" + escapeSQLString(fmt.Sprint(v)) + "'"
+		}
+		return formatArgForSQL(dv)
 	}
+	if u, ok := uuidBytes(v); ok {
+		return "'" + formatUUID(u) + "'"
+	}
+	return "'" + escapeSQLString(fmt.Sprint(v)) + "'"
+}
+
+// sqlTimestampLayout renders time.Time args as ISO 8601 with microseconds (PostgreSQL's precision) and
+// the offset, which both timestamp and timestamptz accept.
+const sqlTimestampLayout = "2006-01-02T15:04:05.999999Z07:00"
+
+// uuidBytes reports whether v is a 16-byte array without a String method (a UUID as [16]byte or a named
+// type over it); types with String are left to fmt.Sprint.
+func uuidBytes(v any) ([16]byte, bool) {
+	var u [16]byte
+	if _, ok := v.(fmt.Stringer); ok {
+		return u, false
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Array || rv.Len() != 16 || rv.Type().Elem().Kind() != reflect.Uint8 {
+		return u, false
+	}
+	reflect.Copy(reflect.ValueOf(u[:]), rv)
+	return u, true
+}
+
+// formatUUID returns u in the canonical 8-4-4-4-12 form.
+func formatUUID(u [16]byte) string {
+	h := hex.EncodeToString(u[:])
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
 }
 
 func escapeSQLString(s string) string {
 	return strings.ReplaceAll(s, "'", "''")
 }
 
-// SubstituteParams parses the query, replaces $1, $2, ... with formatted args, and prepends connLabel for GUI.
+// SubstituteParams parses the query and replaces $1, $2, ... with formatted args, for the query history.
 // On parse error or when AST has no ParamRefs, falls back to string-based replacement so substitution still works.
-func SubstituteParams(sql string, args []any, connLabel string) string {
-	if connLabel != "" {
-		connLabel = strings.TrimSpace(connLabel)
-		if connLabel != "" {
-			connLabel = "[" + connLabel + "] "
-		}
-	}
+func SubstituteParams(sql string, args []any) string {
 	if len(args) == 0 {
-		return connLabel + sql
+		return sql
 	}
-	tree, err := pg_query.Parse(sql)
-	if err != nil || tree == nil || len(tree.Stmts) == 0 {
-		return connLabel + substituteParamsFallback(sql, args)
+	stmts, err := ParseStatements(sql)
+	if err != nil || len(stmts) == 0 {
+		return substituteParamsFallback(sql, args)
 	}
-	stmt := tree.Stmts[0].Stmt
+	stmt := stmts[0].Stmt
 	if stmt == nil {
-		return connLabel + substituteParamsFallback(sql, args)
+		return substituteParamsFallback(sql, args)
 	}
 	var refs []paramRefPos
 	collectParamRefs(stmt, &refs)
 	if len(refs) == 0 {
-		return connLabel + substituteParamsFallback(sql, args)
+		return substituteParamsFallback(sql, args)
 	}
 	// PG may set location to 0 for ParamRef; we need 1-based offsets to find $n in sql.
 	useFallback := false
@@ -363,7 +1109,7 @@ func SubstituteParams(sql string, args []any, connLabel string) string {
 		}
 	}
 	if useFallback {
-		return connLabel + substituteParamsFallback(sql, args)
+		return substituteParamsFallback(sql, args)
 	}
 	// Sort by location (ascending).
 	sort.Slice(refs, func(i, j int) bool { return refs[i].location < refs[j].location })
@@ -398,11 +1144,11 @@ func SubstituteParams(sql string, args []any, connLabel string) string {
 	if strings.Contains(result, "$") && len(args) > 0 {
 		for i := 1; i <= len(args); i++ {
 			if strings.Contains(result, "$"+strconv.Itoa(i)) {
-				return connLabel + substituteParamsFallback(sql, args)
+				return substituteParamsFallback(sql, args)
 			}
 		}
 	}
-	return connLabel + result
+	return result
 }
 
 // substituteParamsFallback replaces $1, $2, ... by string so substitution works when AST walk finds no ParamRefs.
@@ -484,7 +1230,9 @@ func GetSavepointName(stmt *pg_query.Node) string {
 	return t.GetSavepointName()
 }
 
-// StmtCommandTag returns the CommandComplete tag for the statement (e.g. "SELECT", "INSERT 0 1", "UPDATE 0") (AST-based).
+// StmtCommandTag returns a static CommandComplete tag for the statement (e.g. "SELECT", "INSERT 0 1", "UPDATE 0") (AST-based).
+// The row counts are placeholders: results that ran on the backend carry its own command tag, which the
+// proxy relays instead (see protocol.NormalizeCommandTag).
 func StmtCommandTag(stmt *pg_query.Node) string {
 	kind := ClassifyStatement(stmt)
 	switch kind {
@@ -519,6 +1267,96 @@ func StmtCommandTag(stmt *pg_query.Node) string {
 	}
 }
 
+// NotificationCommand returns "LISTEN", "UNLISTEN" or "NOTIFY" for those statements, "" otherwise.
+// The pg_notify() function is not detected.
+func NotificationCommand(stmt *pg_query.Node) string {
+	switch {
+	case stmt == nil:
+		return ""
+	case stmt.GetListenStmt() != nil:
+		return "LISTEN"
+	case stmt.GetUnlistenStmt() != nil:
+		return "UNLISTEN"
+	case stmt.GetNotifyStmt() != nil:
+		return "NOTIFY"
+	}
+	return ""
+}
+
+// NonTransactionalCommand returns the name of the command when PostgreSQL refuses to run stmt inside a
+// transaction block ("VACUUM", "CREATE INDEX CONCURRENTLY", "CREATE DATABASE", ...), "" otherwise.
+// ALTER TYPE ... ADD VALUE is not included: it runs in a transaction block since PostgreSQL 12.
+func NonTransactionalCommand(stmt *pg_query.Node) string {
+	if stmt == nil {
+		return ""
+	}
+	switch n := stmt.Node.(type) {
+	case *pg_query.Node_VacuumStmt:
+		if n.VacuumStmt.GetIsVacuumcmd() {
+			return "VACUUM"
+		}
+	case *pg_query.Node_IndexStmt:
+		if n.IndexStmt.GetConcurrent() {
+			return "CREATE INDEX CONCURRENTLY"
+		}
+	case *pg_query.Node_DropStmt:
+		if n.DropStmt.GetConcurrent() {
+			return "DROP INDEX CONCURRENTLY"
+		}
+	case *pg_query.Node_ReindexStmt:
+		switch {
+		case hasDefElem(n.ReindexStmt.GetParams(), "concurrently"):
+			return "REINDEX CONCURRENTLY"
+		case n.ReindexStmt.GetKind() == pg_query.ReindexObjectType_REINDEX_OBJECT_SYSTEM:
+			return "REINDEX SYSTEM"
+		case n.ReindexStmt.GetKind() == pg_query.ReindexObjectType_REINDEX_OBJECT_DATABASE:
+			return "REINDEX DATABASE"
+		}
+	case *pg_query.Node_ClusterStmt:
+		if n.ClusterStmt.GetRelation() == nil {
+			return "CLUSTER"
+		}
+	case *pg_query.Node_AlterTableStmt:
+		for _, c := range n.AlterTableStmt.GetCmds() {
+			cmd := c.GetAlterTableCmd()
+			if cmd.GetSubtype() == pg_query.AlterTableType_AT_DetachPartition && cmd.GetDef().GetPartitionCmd().GetConcurrent() {
+				return "ALTER TABLE ... DETACH PARTITION CONCURRENTLY"
+			}
+		}
+	case *pg_query.Node_CreatedbStmt:
+		return "CREATE DATABASE"
+	case *pg_query.Node_DropdbStmt:
+		return "DROP DATABASE"
+	case *pg_query.Node_AlterDatabaseStmt:
+		if hasDefElem(n.AlterDatabaseStmt.GetOptions(), "tablespace") {
+			return "ALTER DATABASE ... SET TABLESPACE"
+		}
+	case *pg_query.Node_CreateTableSpaceStmt:
+		return "CREATE TABLESPACE"
+	case *pg_query.Node_DropTableSpaceStmt:
+		return "DROP TABLESPACE"
+	case *pg_query.Node_AlterSystemStmt:
+		return "ALTER SYSTEM"
+	}
+	return ""
+}
+
+// IsNonTransactional reports whether PostgreSQL refuses to run stmt inside a transaction block (see
+// NonTransactionalCommand).
+func IsNonTransactional(stmt *pg_query.Node) bool {
+	return NonTransactionalCommand(stmt) != ""
+}
+
+// hasDefElem reports whether options has a DefElem named name.
+func hasDefElem(options []*pg_query.Node, name string) bool {
+	for _, opt := range options {
+		if strings.EqualFold(opt.GetDefElem().GetDefname(), name) {
+			return true
+		}
+	}
+	return false
+}
+
 // IsDeallocateNoise returns true when the statement is DEALLOCATE (internal driver noise for query history).
 func IsDeallocateNoise(stmt *pg_query.Node) bool {
 	return stmt != nil && stmt.GetDeallocateStmt() != nil
@@ -4,8 +4,14 @@
 package sql
 
 import (
+	gosql "database/sql"
+	"net"
+	"reflect"
+	"sort"
 	"testing"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgtype"
 	pg_query "github.com/pganalyze/pg_query_go/v5"
 )
 
@@ -108,6 +114,31 @@ func TestGetReturningColumns(t *testing.T) {
 			t.Errorf("RETURNING * should return nil for Describe, got %v", cols)
 		}
 	})
+	t.Run("returning_qualified_star", func(t *testing.T) {
+		stmt := firstStmt(t, `UPDATE t SET a = 1 RETURNING id, t.*`)
+		if cols := GetReturningColumns(stmt); cols != nil {
+			t.Errorf("RETURNING t.* should return nil, got %v", cols)
+		}
+		if !StmtReturnsResultSet(stmt) || !HasReturning(stmt) {
+			t.Error("RETURNING t.* must still return a result set")
+		}
+	})
+	t.Run("returning_expressions", func(t *testing.T) {
+		stmt := firstStmt(t, `INSERT INTO t (a, b) VALUES (1, 2) RETURNING a + b AS total, t.id, a + b, now(), pg_catalog.lower(name), b::text, 1::int4, CASE WHEN a > 0 THEN 1 END, coalesce(a, 0)`)
+		want := []string{"total", "id", "?column?", "now", "lower", "b", "int4", "case", "coalesce"}
+		cols := GetReturningColumns(stmt)
+		if len(cols) != len(want) {
+			t.Fatalf("got %v, want names %v", cols, want)
+		}
+		for i, c := range cols {
+			if c.Name != want[i] {
+				t.Errorf("column %d = %q, want %q", i, c.Name, want[i])
+			}
+		}
+		if cols[1].OID != INT8OID || cols[0].OID != TEXTOID {
+			t.Errorf("OIDs = %d, %d; want INT8OID for id and TEXTOID otherwise", cols[1].OID, cols[0].OID)
+		}
+	})
 	t.Run("no_returning", func(t *testing.T) {
 		stmt := firstStmt(t, `INSERT INTO t (a) VALUES (1)`)
 		cols := GetReturningColumns(stmt)
@@ -144,6 +175,12 @@ func TestStmtReturnsResultSet(t *testing.T) {
 			t.Error("INSERT RETURNING should return result set")
 		}
 	})
+	t.Run("insert_returning_star", func(t *testing.T) {
+		stmt := firstStmt(t, `INSERT INTO t (a) VALUES (1) RETURNING *`)
+		if !StmtReturnsResultSet(stmt) {
+			t.Error("INSERT RETURNING * should return result set")
+		}
+	})
 	t.Run("insert_no_returning", func(t *testing.T) {
 		stmt := firstStmt(t, `INSERT INTO t (a) VALUES (1)`)
 		if StmtReturnsResultSet(stmt) {
@@ -183,6 +220,52 @@ func TestParseDeallocate(t *testing.T) {
 	})
 }
 
+func TestParseCopy(t *testing.T) {
+	t.Run("from_stdin_columns", func(t *testing.T) {
+		info, ok := ParseCopy(firstStmt(t, "COPY public.items (id, name) FROM STDIN"))
+		if !ok || !info.IsFrom || !info.IsStdio || info.Binary {
+			t.Fatalf("got %+v ok=%v", info, ok)
+		}
+		if info.Relation != "public.items" || len(info.Columns) != 2 || info.Columns[1] != "name" {
+			t.Errorf("relation/columns: %+v", info)
+		}
+	})
+	t.Run("quoted_relation", func(t *testing.T) {
+		info, ok := ParseCopy(firstStmt(t, `COPY "My Schema"."Order.Items" FROM STDIN`))
+		if !ok || !reflect.DeepEqual(info.RelationParts, []string{"My Schema", "Order.Items"}) {
+			t.Errorf("got %+v ok=%v, want the identifiers unquoted and unsplit", info, ok)
+		}
+	})
+	t.Run("binary_legacy_and_option", func(t *testing.T) {
+		for _, q := range []string{"COPY items FROM STDIN (FORMAT binary)", "COPY items FROM STDIN WITH BINARY"} {
+			info, ok := ParseCopy(firstStmt(t, q))
+			if !ok || !info.Binary {
+				t.Errorf("%q: got %+v ok=%v", q, info, ok)
+			}
+		}
+	})
+	t.Run("server_file_is_not_stdio", func(t *testing.T) {
+		info, ok := ParseCopy(firstStmt(t, "COPY items FROM '/tmp/items.csv' CSV"))
+		if !ok || info.IsStdio {
+			t.Errorf("got %+v ok=%v", info, ok)
+		}
+	})
+	t.Run("query_to_stdout", func(t *testing.T) {
+		info, ok := ParseCopy(firstStmt(t, "COPY (SELECT id FROM items WHERE id > 1) TO STDOUT"))
+		if !ok || info.IsFrom || !info.IsStdio || info.Relation != "" {
+			t.Fatalf("got %+v ok=%v", info, ok)
+		}
+		if info.Query != "SELECT id FROM items WHERE id > 1" {
+			t.Errorf("query = %q", info.Query)
+		}
+	})
+	t.Run("not_copy", func(t *testing.T) {
+		if _, ok := ParseCopy(firstStmt(t, "SELECT 1")); ok {
+			t.Error("SELECT should not be COPY")
+		}
+	})
+}
+
 func TestMaxParamIndex(t *testing.T) {
 	t.Run("params_1_2_1", func(t *testing.T) {
 		stmt := firstStmt(t, "SELECT $1, $2, $1")
@@ -202,18 +285,110 @@ func TestMaxParamIndex(t *testing.T) {
 
 func TestSubstituteParams(t *testing.T) {
 	t.Run("two_params", func(t *testing.T) {
-		got := SubstituteParams("SELECT $1, $2", []any{10, "foo"}, "")
+		got := SubstituteParams("SELECT $1, $2", []any{10, "foo"})
 		want := "SELECT 10, 'foo'"
 		if got != want {
 			t.Errorf("got %q, want %q", got, want)
 		}
 	})
-	t.Run("with_conn_label", func(t *testing.T) {
-		got := SubstituteParams("SELECT $1", []any{1}, "conn:127.0.0.1:52586")
-		if got != "[conn:127.0.0.1:52586] SELECT 1" {
-			t.Errorf("got %q", got)
+}
+
+func TestFormatArgForSQL(t *testing.T) {
+	type namedUUID [16]byte
+	uuid := [16]byte{0x55, 0x0e, 0x84, 0x00, 0xe2, 0x9b, 0x41, 0xd4, 0xa7, 0x16, 0x44, 0x66, 0x55, 0x44, 0x00, 0x00}
+	_, ipNet, _ := net.ParseCIDR("10.1.0.0/16")
+	tests := []struct {
+		name string
+		arg  any
+		want string
+	}{
+		{"nil", nil, "NULL"},
+		{"string_quote", "O'Brien", "'O''Brien'"},
+		{"bytes_hex", []byte{0xde, 0xad, 0xbe, 0xef}, `'\xdeadbeef'`},
+		{"bytes_empty", []byte{}, `'\x'`},
+		{"time_utc", time.Date(2024, 3, 5, 14, 7, 9, 123456000, time.UTC), "'2024-03-05T14:07:09.123456Z'"},
+		{"time_offset", time.Date(2024, 3, 5, 14, 7, 9, 0, time.FixedZone("BRT", -3*3600)), "'2024-03-05T14:07:09-03:00'"},
+		{"ipv4", net.ParseIP("192.168.0.1"), "'192.168.0.1'"},
+		{"ipv6", net.ParseIP("2001:db8::1"), "'2001:db8::1'"},
+		{"ip_nil", net.IP(nil), "NULL"},
+		{"ipnet", *ipNet, "'10.1.0.0/16'"},
+		{"ipnet_ptr", ipNet, "'10.1.0.0/16'"},
+		{"ipnet_nil", (*net.IPNet)(nil), "NULL"},
+		{"uuid_array", uuid, "'550e8400-e29b-41d4-a716-446655440000'"},
+		{"uuid_named", namedUUID(uuid), "'550e8400-e29b-41d4-a716-446655440000'"},
+		{"pgtype_uuid", pgtype.UUID{Bytes: uuid, Valid: true}, "'550e8400-e29b-41d4-a716-446655440000'"},
+		{"pgtype_uuid_null", pgtype.UUID{}, "NULL"},
+		{"pgtype_uuid_nil_ptr", (*pgtype.UUID)(nil), "NULL"},
+		{"null_string_nil_ptr", (*gosql.NullString)(nil), "NULL"},
+		{"null_string", gosql.NullString{String: "it's", Valid: true}, "'it''s'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatArgForSQL(tt.arg); got != tt.want {
+				t.Errorf("formatArgForSQL(%#v) = %s, want %s", tt.arg, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubstituteParams_TypedArgs(t *testing.T) {
+	at := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	got := SubstituteParams("INSERT INTO t (at, data) VALUES ($1, $2)", []any{at, []byte("a'b")})
+	want := `INSERT INTO t (at, data) VALUES ('2024-01-02T03:04:05Z', '\x612762')`
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSchemaObjectChanges(t *testing.T) {
+	tests := []struct {
+		sql     string
+		created []string
+		dropped []string
+	}{
+		{"CREATE TABLE public.t (id int)", []string{"table public.t"}, nil},
+		{"CREATE TEMP TABLE scratch (id int)", []string{"temporary table scratch"}, nil},
+		{"CREATE SEQUENCE ids", []string{"sequence ids"}, nil},
+		{"CREATE VIEW v AS SELECT 1", []string{"view v"}, nil},
+		{"CREATE MATERIALIZED VIEW mv AS SELECT 1", []string{"materialized view mv"}, nil},
+		{"CREATE TABLE copy AS SELECT 1", []string{"table copy"}, nil},
+		{"CREATE INDEX t_idx ON app.t (id)", []string{"index app.t_idx"}, nil},
+		{"CREATE INDEX ON t (id)", nil, nil},
+		{"CREATE SCHEMA tenant", []string{"schema tenant"}, nil},
+		{"DROP TABLE a, app.b CASCADE", nil, []string{"table a", "table app.b"}},
+		// May leave the object as it was: only the server knows, so nothing is reported.
+		{"CREATE TABLE IF NOT EXISTS t (id int)", nil, nil},
+		{"CREATE SEQUENCE IF NOT EXISTS ids", nil, nil},
+		{"CREATE OR REPLACE VIEW v AS SELECT 1", nil, nil},
+		{"CREATE MATERIALIZED VIEW IF NOT EXISTS mv AS SELECT 1", nil, nil},
+		{"CREATE TABLE IF NOT EXISTS copy AS SELECT 1", nil, nil},
+		{"CREATE INDEX IF NOT EXISTS t_idx ON t (id)", nil, nil},
+		{"CREATE SCHEMA IF NOT EXISTS tenant", nil, nil},
+		{"DROP TABLE IF EXISTS a, app.b CASCADE", nil, nil},
+		{"DROP SCHEMA IF EXISTS tenant", nil, nil},
+		{"DROP MATERIALIZED VIEW mv", nil, []string{"materialized view mv"}},
+		{"DROP SCHEMA tenant", nil, []string{"schema tenant"}},
+		{"DROP FUNCTION f()", nil, nil},
+		{"INSERT INTO t VALUES (1)", nil, nil},
+	}
+	names := func(objects []SchemaObject) []string {
+		var out []string
+		for _, o := range objects {
+			out = append(out, o.String())
 		}
-	})
+		return out
+	}
+	for _, tt := range tests {
+		t.Run(tt.sql, func(t *testing.T) {
+			created, dropped := SchemaObjectChanges(firstStmt(t, tt.sql))
+			if got := names(created); !reflect.DeepEqual(got, tt.created) {
+				t.Errorf("created = %v, want %v", got, tt.created)
+			}
+			if got := names(dropped); !reflect.DeepEqual(got, tt.dropped) {
+				t.Errorf("dropped = %v, want %v", got, tt.dropped)
+			}
+		})
+	}
 }
 
 func TestTransactionDetection(t *testing.T) {
@@ -253,6 +428,44 @@ func TestTransactionDetection(t *testing.T) {
 			t.Error("expected ROLLBACK TO SAVEPOINT")
 		}
 	})
+	t.Run("set_transaction", func(t *testing.T) {
+		if !IsSetTransaction(firstStmt(t, "SET TRANSACTION ISOLATION LEVEL SERIALIZABLE")) {
+			t.Error("expected SET TRANSACTION")
+		}
+		for _, q := range []string{"SET TRANSACTION SNAPSHOT '00000003-0000001B-1'", "SET SESSION CHARACTERISTICS AS TRANSACTION READ ONLY", "SET search_path TO app", "BEGIN"} {
+			if IsSetTransaction(firstStmt(t, q)) {
+				t.Errorf("%q is not SET TRANSACTION", q)
+			}
+		}
+	})
+}
+
+func TestGetTransactionOptions(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []TransactionOption
+	}{
+		{"BEGIN", nil},
+		{"BEGIN ISOLATION LEVEL REPEATABLE READ", []TransactionOption{{"transaction_isolation", "repeatable read"}}},
+		{"START TRANSACTION READ ONLY, NOT DEFERRABLE", []TransactionOption{{"transaction_read_only", "on"}, {"transaction_deferrable", "off"}}},
+		{"SET TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ WRITE", []TransactionOption{{"transaction_isolation", "serializable"}, {"transaction_read_only", "off"}}},
+		{"SET TRANSACTION SNAPSHOT '00000003-0000001B-1'", nil},
+		{"COMMIT", nil},
+		{"SELECT 1", nil},
+	}
+	for _, tt := range tests {
+		got := GetTransactionOptions(firstStmt(t, tt.sql))
+		if len(got) != len(tt.want) {
+			t.Errorf("GetTransactionOptions(%q) = %+v, want %+v", tt.sql, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("GetTransactionOptions(%q) = %+v, want %+v", tt.sql, got, tt.want)
+				break
+			}
+		}
+	}
 }
 
 func TestGetSavepointName(t *testing.T) {
@@ -306,6 +519,29 @@ func TestStmtCommandTag(t *testing.T) {
 	})
 }
 
+func TestDiscardTarget(t *testing.T) {
+	for _, tt := range []struct {
+		query string
+		want  string
+	}{
+		{"DISCARD ALL", "ALL"},
+		{"discard /* pooler */ all", "ALL"},
+		{"DISCARD PLANS", "PLANS"},
+		{"DISCARD SEQUENCES", "SEQUENCES"},
+		{"DISCARD TEMP", "TEMP"},
+		{"DISCARD TEMPORARY", "TEMP"},
+		{"SELECT 1", ""},
+	} {
+		stmt := firstStmt(t, tt.query)
+		if got := DiscardTarget(stmt); got != tt.want {
+			t.Errorf("DiscardTarget(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+		if got := IsDiscard(stmt); got != (tt.want != "") {
+			t.Errorf("IsDiscard(%q) = %v", tt.query, got)
+		}
+	}
+}
+
 func TestIsDeallocateNoise(t *testing.T) {
 	t.Run("deallocate", func(t *testing.T) {
 		stmt := firstStmt(t, "DEALLOCATE x")
@@ -320,3 +556,312 @@ func TestIsDeallocateNoise(t *testing.T) {
 		}
 	})
 }
+
+func TestIsPlainSelect(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want bool
+	}{
+		{"SELECT 1", true},
+		{"SELECT * FROM t WHERE id = $1", true},
+		{"WITH x AS (SELECT 1) SELECT * FROM x", true},
+		{"SELECT * FROM t FOR UPDATE", false},
+		{"SELECT * INTO t2 FROM t", false},
+		{"WITH d AS (DELETE FROM t RETURNING *) SELECT * FROM d", false},
+		{"INSERT INTO t VALUES (1) RETURNING id", false},
+	}
+	for _, tt := range tests {
+		if got := IsPlainSelect(firstStmt(t, tt.sql)); got != tt.want {
+			t.Errorf("IsPlainSelect(%q) = %v, want %v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestParseVariableSet(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want VariableSet
+		ok   bool
+	}{
+		{"SET search_path TO app, public", VariableSet{Name: "search_path", SetSQL: "SET search_path TO app, public", Tag: "SET"}, true},
+		{"SET LOCAL statement_timeout = '5s'", VariableSet{Name: "statement_timeout", IsLocal: true, SetSQL: `SET statement_timeout TO "5s"`, Tag: "SET"}, true},
+		{"SET TIME ZONE 'UTC'", VariableSet{Name: "timezone", SetSQL: `SET timezone TO "UTC"`, Tag: "SET"}, true},
+		{"SET search_path TO DEFAULT", VariableSet{Name: "search_path", IsReset: true, Tag: "SET"}, true},
+		{"RESET TimeZone", VariableSet{Name: "timezone", IsReset: true, Tag: "RESET"}, true},
+		{"RESET ALL", VariableSet{ResetAll: true, Tag: "RESET"}, true},
+		{"SET TRANSACTION ISOLATION LEVEL SERIALIZABLE", VariableSet{}, false},
+		{"SELECT 1", VariableSet{}, false},
+		{"SET ROLE readonly", VariableSet{Name: "role", SetSQL: "SET role TO readonly", Tag: "SET"}, true},
+		{"RESET ROLE", VariableSet{Name: "role", IsReset: true, Tag: "RESET"}, true},
+		{"SET SESSION AUTHORIZATION bob", VariableSet{Name: "session_authorization", SetSQL: "SET session_authorization TO bob", Tag: "SET"}, true},
+		{"SET SESSION AUTHORIZATION DEFAULT", VariableSet{Name: "session_authorization", IsReset: true, Tag: "SET"}, true},
+	}
+	for _, tt := range tests {
+		got, ok := ParseVariableSet(firstStmt(t, tt.sql))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseVariableSet(%q) = %+v, %v; want %+v, %v", tt.sql, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestVariableSetChangesRole(t *testing.T) {
+	for sql, want := range map[string]bool{
+		"SET ROLE readonly":                 true,
+		"SET LOCAL ROLE readonly":           true,
+		"RESET ROLE":                        true,
+		"SET SESSION AUTHORIZATION bob":     true,
+		"RESET SESSION AUTHORIZATION":       true,
+		"SET search_path TO app":            false,
+		"SET SESSION statement_timeout = 1": false,
+	} {
+		vs, _ := ParseVariableSet(firstStmt(t, sql))
+		if got := vs.ChangesRole(); got != want {
+			t.Errorf("ChangesRole(%q) = %v, want %v", sql, got, want)
+		}
+	}
+}
+
+func TestReportedParameterSets(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want []string
+	}{
+		{"SET client_encoding = 'LATIN1'", []string{"client_encoding"}},
+		{"SET NAMES 'UTF8'", []string{"client_encoding"}},
+		{"SET TIME ZONE 'UTC'; SET datestyle TO 'German'", []string{"TimeZone", "DateStyle"}},
+		{"SET LOCAL intervalstyle = 'iso_8601'; RESET IntervalStyle", []string{"IntervalStyle"}},
+		{"SET search_path TO app", nil},
+		{"SELECT 1", nil},
+		{"SET client_encoding =", nil},
+	}
+	for _, tt := range tests {
+		if got := ReportedParameterSets(tt.sql); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ReportedParameterSets(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+	all := ReportedParameterSets("RESET ALL")
+	if len(all) != len(reportedParameters) || !sort.StringsAreSorted(all) {
+		t.Errorf("ReportedParameterSets(RESET ALL) = %q, want every reported parameter, sorted", all)
+	}
+}
+
+func TestApplicationNameSet(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want string
+		ok   bool
+	}{
+		{"SET application_name = 'pgrollback_other'", "pgrollback_other", true},
+		{"SET LOCAL application_name TO myapp", "myapp", true},
+		{"set Application_Name to ''", "", true},
+		{"SET application_name TO DEFAULT", "", false},
+		{"RESET application_name", "", false},
+		{"SET search_path = 'app'", "", false},
+		{"SELECT 1", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := ApplicationNameSet(firstStmt(t, tt.sql))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ApplicationNameSet(%q) = %q, %v; want %q, %v", tt.sql, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestNotificationCommand(t *testing.T) {
+	for sql, want := range map[string]string{
+		"LISTEN jobs":                "LISTEN",
+		"unlisten *":                 "UNLISTEN",
+		"NOTIFY jobs, 'payload'":     "NOTIFY",
+		"SELECT pg_notify('a', 'b')": "",
+		"SELECT 1":                   "",
+	} {
+		if got := NotificationCommand(firstStmt(t, sql)); got != want {
+			t.Errorf("NotificationCommand(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
+
+func TestNonTransactionalCommand(t *testing.T) {
+	for sql, want := range map[string]string{
+		"VACUUM":                                        "VACUUM",
+		"VACUUM (ANALYZE) t":                            "VACUUM",
+		"ANALYZE t":                                     "",
+		"CREATE INDEX CONCURRENTLY i ON t (c)":          "CREATE INDEX CONCURRENTLY",
+		"CREATE INDEX i ON t (c)":                       "",
+		"DROP INDEX CONCURRENTLY i":                     "DROP INDEX CONCURRENTLY",
+		"REINDEX TABLE CONCURRENTLY t":                  "REINDEX CONCURRENTLY",
+		"REINDEX (CONCURRENTLY) INDEX i":                "REINDEX CONCURRENTLY",
+		"REINDEX TABLE t":                               "",
+		"REINDEX DATABASE app":                          "REINDEX DATABASE",
+		"CLUSTER":                                       "CLUSTER",
+		"CLUSTER t USING i":                             "",
+		"ALTER TABLE p DETACH PARTITION c CONCURRENTLY": "ALTER TABLE ... DETACH PARTITION CONCURRENTLY",
+		"ALTER TABLE p DETACH PARTITION c":              "",
+		"CREATE DATABASE app":                           "CREATE DATABASE",
+		"DROP DATABASE IF EXISTS app":                   "DROP DATABASE",
+		"ALTER DATABASE app SET TABLESPACE fast":        "ALTER DATABASE ... SET TABLESPACE",
+		"ALTER DATABASE app SET work_mem = '64MB'":      "",
+		"CREATE TABLESPACE fast LOCATION '/mnt/fast'":   "CREATE TABLESPACE",
+		"ALTER SYSTEM SET work_mem = '1GB'":             "ALTER SYSTEM",
+		"ALTER TYPE mood ADD VALUE 'meh'":               "",
+		"SELECT 1":                                      "",
+	} {
+		stmt := firstStmt(t, sql)
+		if got := NonTransactionalCommand(stmt); got != want {
+			t.Errorf("NonTransactionalCommand(%q) = %q, want %q", sql, got, want)
+		}
+		if got := IsNonTransactional(stmt); got != (want != "") {
+			t.Errorf("IsNonTransactional(%q) = %v", sql, got)
+		}
+	}
+}
+
+func TestParseTransactionOptions(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want TransactionOptions
+		ok   bool
+	}{
+		{"BEGIN", TransactionOptions{}, true},
+		{"START TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ ONLY, DEFERRABLE", TransactionOptions{IsolationLevel: "SERIALIZABLE", AccessMode: "READ ONLY", Deferrable: "DEFERRABLE"}, true},
+		{"BEGIN READ WRITE NOT DEFERRABLE ISOLATION LEVEL read committed", TransactionOptions{IsolationLevel: "READ COMMITTED", AccessMode: "READ WRITE", Deferrable: "NOT DEFERRABLE"}, true},
+		{"BEGIN TRANSACTION ISOLATION LEVEL REPEATABLE READ", TransactionOptions{IsolationLevel: "REPEATABLE READ"}, true},
+		{"SET TRANSACTION READ ONLY", TransactionOptions{}, false},
+		{"COMMIT", TransactionOptions{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := ParseTransactionOptions(firstStmt(t, tt.sql))
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("ParseTransactionOptions(%q) = %+v, %v; want %+v, %v", tt.sql, got, ok, tt.want, tt.ok)
+		}
+	}
+	opts := TransactionOptions{IsolationLevel: "SERIALIZABLE", AccessMode: "READ ONLY"}
+	if got := opts.String(); got != "ISOLATION LEVEL SERIALIZABLE, READ ONLY" {
+		t.Errorf("String() = %q", got)
+	}
+	if !(TransactionOptions{}).IsZero() || opts.IsZero() {
+		t.Error("IsZero mismatch")
+	}
+}
+
+func TestIsEmptyQuery(t *testing.T) {
+	for _, q := range []string{"", "   ", "-- ping", "/* c */", ";", "-- a\n/* b */ ;"} {
+		if !IsEmptyQuery(q) {
+			t.Errorf("IsEmptyQuery(%q) = false, want true", q)
+		}
+	}
+	for _, q := range []string{"SELECT 1", "-- c\nSELECT 1", "SELEC 1"} {
+		if IsEmptyQuery(q) {
+			t.Errorf("IsEmptyQuery(%q) = true, want false", q)
+		}
+	}
+}
+
+func TestAnalyzeCommand(t *testing.T) {
+	tests := []struct {
+		sql  string
+		want CommandInfo
+	}{
+		{"SELECT 1", CommandInfo{Type: "SELECT", ReturnsRows: true}},
+		{"WITH upd AS (UPDATE t SET a = 1 RETURNING *) SELECT * FROM upd", CommandInfo{Type: "SELECT", ReturnsRows: true, ModifiesData: true}},
+		{"WITH upd AS (UPDATE t SET a = 1 RETURNING *) INSERT INTO log SELECT * FROM upd", CommandInfo{Type: "INSERT", ModifiesData: true}},
+		{"WITH x AS (SELECT 1 AS a) SELECT a FROM x", CommandInfo{Type: "SELECT", ReturnsRows: true}},
+		{"-- comment\n  with x as (select 1) select * from x", CommandInfo{Type: "SELECT", ReturnsRows: true}},
+		{"WITH x AS (SELECT 1 AS a) INSERT INTO t (a) SELECT a FROM x RETURNING id", CommandInfo{Type: "INSERT", ReturnsRows: true, ModifiesData: true}},
+		{"WITH x AS (SELECT 1 AS a) INSERT INTO t (a) SELECT a FROM x", CommandInfo{Type: "INSERT", ModifiesData: true}},
+		{"UPDATE t SET a = 1 RETURNING a", CommandInfo{Type: "UPDATE", ReturnsRows: true, ModifiesData: true}},
+		{"DELETE FROM t", CommandInfo{Type: "DELETE", ModifiesData: true}},
+		{"BEGIN", CommandInfo{Type: "BEGIN", IsTCL: true}},
+		{"COMMIT", CommandInfo{Type: "COMMIT", IsTCL: true}},
+		{"ROLLBACK TO SAVEPOINT sp", CommandInfo{Type: "ROLLBACK", IsTCL: true}},
+		{"SAVEPOINT sp", CommandInfo{Type: "SAVEPOINT", IsTCL: true}},
+		{"RELEASE SAVEPOINT sp", CommandInfo{Type: "RELEASE", IsTCL: true}},
+		{"SET search_path = public", CommandInfo{Type: "SET"}},
+		{"", CommandInfo{Type: "OTHER"}},
+		{"SELEC 1", CommandInfo{Type: "OTHER"}},
+	}
+	for _, tt := range tests {
+		if got := AnalyzeCommand(tt.sql); got != tt.want {
+			t.Errorf("AnalyzeCommand(%q) = %+v, want %+v", tt.sql, got, tt.want)
+		}
+	}
+}
+
+func TestIsSelect(t *testing.T) {
+	for _, q := range []string{"SELECT 1", "WITH x AS (SELECT 1) SELECT * FROM x", "VALUES (1)", "TABLE t", "/* c */ select 1"} {
+		if !IsSelect(q) {
+			t.Errorf("IsSelect(%q) = false, want true", q)
+		}
+	}
+	for _, q := range []string{"INSERT INTO t VALUES (1)", "WITH x AS (SELECT 1) INSERT INTO t SELECT * FROM x RETURNING *", "WITH d AS (DELETE FROM t RETURNING *) SELECT count(*) FROM d", "BEGIN", "SELEC 1", ""} {
+		if IsSelect(q) {
+			t.Errorf("IsSelect(%q) = true, want false", q)
+		}
+	}
+}
+
+func TestDataModifyingCTE(t *testing.T) {
+	tests := []struct {
+		sql      string
+		want     string
+		classify string
+		tag      string
+	}{
+		{"WITH x AS (SELECT 1) SELECT * FROM x", "", "SELECT", "SELECT"},
+		{"WITH upd AS (UPDATE t SET a = 1 RETURNING *) SELECT * FROM upd", "UPDATE", "SELECT", "SELECT"},
+		{"WITH d AS (DELETE FROM t RETURNING id), i AS (INSERT INTO u SELECT id FROM d) SELECT 1", "DELETE", "SELECT", "SELECT"},
+		{"WITH x AS (SELECT 1), i AS (INSERT INTO u VALUES (1) RETURNING id) SELECT * FROM i", "INSERT", "SELECT", "SELECT"},
+		{"WITH upd AS (UPDATE t SET a = 1 RETURNING *) INSERT INTO log SELECT * FROM upd", "UPDATE", "INSERT", "INSERT 0 1"},
+		{"WITH d AS (DELETE FROM t RETURNING id) UPDATE u SET b = 2 WHERE id IN (SELECT id FROM d)", "DELETE", "UPDATE", "UPDATE 0"},
+		{"WITH x AS (SELECT 1) DELETE FROM t WHERE a IN (SELECT * FROM x)", "", "DELETE", "DELETE 0"},
+		{"SELECT 1", "", "SELECT", "SELECT"},
+	}
+	for _, tt := range tests {
+		stmt := firstStmt(t, tt.sql)
+		if got := DataModifyingCTE(stmt); got != tt.want {
+			t.Errorf("DataModifyingCTE(%q) = %q, want %q", tt.sql, got, tt.want)
+		}
+		if got := ClassifyStatement(stmt); got != tt.classify {
+			t.Errorf("ClassifyStatement(%q) = %q, want %q", tt.sql, got, tt.classify)
+		}
+		if got := StmtCommandTag(stmt); got != tt.tag {
+			t.Errorf("StmtCommandTag(%q) = %q, want %q", tt.sql, got, tt.tag)
+		}
+		if got := IsPlainSelect(stmt); got != (tt.classify == "SELECT" && tt.want == "") {
+			t.Errorf("IsPlainSelect(%q) = %v", tt.sql, got)
+		}
+	}
+}
+
+func TestStatementTag(t *testing.T) {
+	tests := map[string]string{
+		"SELECT 1":                               "SELECT",
+		"WITH x AS (SELECT 1) SELECT * FROM x":   "SELECT",
+		"INSERT INTO t VALUES (1)":               "INSERT",
+		"BEGIN":                                  "BEGIN",
+		"SET search_path TO app":                 "SET",
+		"RESET ALL":                              "RESET",
+		"SHOW work_mem":                          "SHOW",
+		"CREATE TABLE t (id int)":                "CREATE TABLE",
+		"CREATE MATERIALIZED VIEW v AS SELECT 1": "CREATE MATERIALIZED VIEW",
+		"DROP TABLE t":                           "DROP TABLE",
+		"DROP MATERIALIZED VIEW v":               "DROP MATERIALIZED VIEW",
+		"DROP DATABASE app":                      "DROP DATABASE",
+		"ALTER SYSTEM SET work_mem = '1GB'":      "ALTER SYSTEM",
+		"ALTER TABLE t ADD COLUMN c int":         "ALTER TABLE",
+		"ALTER TABLE t RENAME TO u":              "ALTER TABLE",
+		"CREATE ROLE intruder":                   "CREATE ROLE",
+		"CREATE USER intruder":                   "CREATE ROLE",
+		"GRANT SELECT ON t TO PUBLIC":            "GRANT",
+		"REVOKE admin FROM bob":                  "REVOKE ROLE",
+		"TRUNCATE t":                             "TRUNCATE TABLE",
+		"CREATE OR REPLACE PROCEDURE p() AS $$ $$ LANGUAGE sql": "CREATE PROCEDURE",
+		"VACUUM t":  "VACUUM",
+		"ANALYZE t": "ANALYZE",
+	}
+	for sql, want := range tests {
+		if got := StatementTag(firstStmt(t, sql)); got != want {
+			t.Errorf("StatementTag(%q) = %q, want %q", sql, got, want)
+		}
+	}
+}
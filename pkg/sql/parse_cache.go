@@ -0,0 +1,94 @@
+package sql
+
+import (
+	"container/list"
+	"sync"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// A query goes through several AST checks on its way through the proxy (InterceptQuery, BEGIN and
+// savepoint detection, guard invalidation, forwarding), each of which used to parse it again through
+// CGO. ParseStatements keeps the most recent results in a small LRU keyed by the SQL text, so a query
+// is parsed once per request and a repeated query (ORM statements, test loops) not at all.
+//
+// The cached statements are shared: callers must treat the returned AST as read-only.
+
+const (
+	// parseCacheSize is the number of distinct SQL strings kept.
+	parseCacheSize = 256
+	// maxCachedQueryLen keeps large one-off statements (bulk INSERTs, dumps) out of the cache.
+	maxCachedQueryLen = 16 << 10
+)
+
+type parseResult struct {
+	sql   string
+	stmts []*pg_query.RawStmt
+	err   error
+}
+
+// parseCache is an LRU of parse results; safe for concurrent use.
+type parseCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // front = most recently used; values are *parseResult
+	entries map[string]*list.Element
+	misses  int64 // calls that had to run the parser
+}
+
+func newParseCache(size int) *parseCache {
+	return &parseCache{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+var statementCache = newParseCache(parseCacheSize)
+
+// parse returns the cached result for sql, running parseFn (and caching it) on a miss.
+func (c *parseCache) parse(sql string, parseFn func(string) ([]*pg_query.RawStmt, error)) ([]*pg_query.RawStmt, error) {
+	if len(sql) > maxCachedQueryLen {
+		c.mu.Lock()
+		c.misses++
+		c.mu.Unlock()
+		return parseFn(sql)
+	}
+	c.mu.Lock()
+	if el, ok := c.entries[sql]; ok {
+		c.order.MoveToFront(el)
+		r := el.Value.(*parseResult)
+		c.mu.Unlock()
+		return r.stmts, r.err
+	}
+	c.misses++
+	c.mu.Unlock()
+
+	// Parse outside the lock: concurrent misses for the same SQL just parse twice.
+	stmts, err := parseFn(sql)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.entries[sql]; !ok {
+		c.entries[sql] = c.order.PushFront(&parseResult{sql: sql, stmts: stmts, err: err})
+		if c.order.Len() > c.size {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.entries, oldest.Value.(*parseResult).sql)
+		}
+	}
+	return stmts, err
+}
+
+func (c *parseCache) missCount() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.misses
+}
+
+func parseUncached(sql string) ([]*pg_query.RawStmt, error) {
+	tree, err := pg_query.Parse(sql)
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil || tree.Stmts == nil {
+		return nil, nil
+	}
+	return tree.Stmts, nil
+}
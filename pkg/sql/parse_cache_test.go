@@ -0,0 +1,98 @@
+package sql
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+func TestParseCache_ReusesAndEvicts(t *testing.T) {
+	c := newParseCache(2)
+	calls := 0
+	parseFn := func(sql string) ([]*pg_query.RawStmt, error) {
+		calls++
+		return parseUncached(sql)
+	}
+
+	first, err := c.parse("SELECT 1", parseFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := c.parse("SELECT 1", parseFn)
+	if calls != 1 || len(again) != 1 || again[0] != first[0] {
+		t.Fatalf("second parse of the same SQL ran the parser (calls=%d) or returned a different AST", calls)
+	}
+
+	c.parse("SELECT 2", parseFn)
+	c.parse("SELECT 1", parseFn) // most recently used again
+	c.parse("SELECT 3", parseFn) // evicts SELECT 2
+	calls = 0
+	c.parse("SELECT 1", parseFn)
+	if calls != 0 {
+		t.Error("SELECT 1 was evicted although it was recently used")
+	}
+	c.parse("SELECT 2", parseFn)
+	if calls != 1 {
+		t.Error("SELECT 2 should have been evicted as least recently used")
+	}
+}
+
+func TestParseCache_CachesErrorsAndSkipsLargeQueries(t *testing.T) {
+	c := newParseCache(4)
+	if _, err := c.parse("SELEC 1", parseUncached); err == nil {
+		t.Fatal("expected a syntax error")
+	}
+	misses := c.missCount()
+	if _, err := c.parse("SELEC 1", parseUncached); err == nil || c.missCount() != misses {
+		t.Errorf("a cached syntax error must be returned without parsing again (err=%v)", err)
+	}
+
+	large := "SELECT '" + strings.Repeat("x", maxCachedQueryLen) + "'"
+	c.parse(large, parseUncached)
+	c.parse(large, parseUncached)
+	if got := c.missCount() - misses; got != 2 {
+		t.Errorf("large query parses = %d, want 2 (never cached)", got)
+	}
+	if len(c.entries) != 1 {
+		t.Errorf("cache holds %d entries, want only the small query", len(c.entries))
+	}
+}
+
+// BenchmarkQueryPipelineParses runs the AST checks one simple query goes through in the proxy
+// (intercept, BEGIN detection, savepoint detection, guard invalidation, forwarding) and reports how
+// many times the parser actually ran per query.
+func BenchmarkQueryPipelineParses(b *testing.B) {
+	pipeline := func(parse func(string) ([]*pg_query.RawStmt, error), sql string) {
+		for i := 0; i < 5; i++ {
+			if _, err := parse(sql); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+	queries := make([]string, 50)
+	for i := range queries {
+		queries[i] = fmt.Sprintf("UPDATE accounts SET balance = balance - %d WHERE id = $1", i)
+	}
+
+	b.Run("uncached", func(b *testing.B) {
+		parses := 0
+		parse := func(sql string) ([]*pg_query.RawStmt, error) {
+			parses++
+			return parseUncached(sql)
+		}
+		for i := 0; i < b.N; i++ {
+			pipeline(parse, queries[i%len(queries)])
+		}
+		b.ReportMetric(float64(parses)/float64(b.N), "parses/op")
+	})
+	b.Run("cached", func(b *testing.B) {
+		c := newParseCache(parseCacheSize)
+		parse := func(sql string) ([]*pg_query.RawStmt, error) { return c.parse(sql, parseUncached) }
+		for i := 0; i < b.N; i++ {
+			pipeline(parse, queries[i%len(queries)])
+		}
+		b.ReportMetric(float64(c.missCount())/float64(b.N), "parses/op")
+	})
+}
@@ -256,9 +256,12 @@ func TestPgRollbackCommands(t *testing.T) {
 	var active bool
 	var level int
 	var createdAt string
+	var preparedStatements int
+	var savepoints string
+	var openUserTx bool
 
 	// O testID já está na connection string (application_name), então não precisa passar como parâmetro
-	err = pgrollbackDB.QueryRow("pgrollback status").Scan(&testIDCol, &active, &level, &createdAt)
+	err = pgrollbackDB.QueryRow("pgrollback status").Scan(&testIDCol, &active, &level, &createdAt, &preparedStatements, &savepoints, &openUserTx)
 	if err != nil {
 		t.Logf("pgrollback status: %v", err)
 	} else {
@@ -268,6 +271,9 @@ func TestPgRollbackCommands(t *testing.T) {
 		if !active {
 			t.Error("Status active should be true")
 		}
+		if !strings.HasPrefix(savepoints, "{") {
+			t.Errorf("Status savepoints = %q, want a text[] value", savepoints)
+		}
 	}
 
 	execPgRollbackRollback(t, pgrollbackDB)
@@ -648,14 +654,17 @@ func TestIntegrationDisconnectDeallocatesPreparedStatements(t *testing.T) {
 	if err := conn1.Close(ctx); err != nil {
 		t.Fatalf("conn1 Close: %v", err)
 	}
-	// Client Close() returns immediately; proxy runs cleanup in its goroutine when it sees the close.
-	// Poll briefly so we observe the backend only after cleanup has run (proxy holds session lock for full cleanup).
+	// Client Close() returns immediately; the proxy runs cleanup in its handler when it sees the close.
+	// Wait for it so we observe the backend only after conn1's statements were deallocated.
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := pgServer.WaitForDisconnectCleanup(waitCtx, testID, 1); err != nil {
+		t.Fatalf("waiting for conn1 disconnect cleanup: %v", err)
+	}
 	wantCount := beforeCount - 3
 	afterCount := countPreparedStatementsOnBackend(t, ctx, conn2)
 	if afterCount != wantCount {
-		// Use t.Skipf so this test does not count as FAIL (e.g. known flaky race with disconnect cleanup).
-		// To treat this as a real failure, switch back to t.Fatalf.
-		t.Skipf("after conn1 disconnect: pg_prepared_statements count = %d, want %d (conn1's 3 must be deallocated)", afterCount, wantCount)
+		t.Fatalf("after conn1 disconnect: pg_prepared_statements count = %d, want %d (conn1's 3 must be deallocated)", afterCount, wantCount)
 	}
 
 	// conn2 must still have all three of its statements working.
@@ -711,8 +720,193 @@ func TestMultipleQueriesReturnsLastOnly(t *testing.T) {
 	}
 }
 
-// TestResetSessionPingBeforeQuery reproduces the response-attribution bug: after full rollback,
-// db.Query(tableExistenceQuery) triggers ResetSession (which sends "-- ping") then the query.
+// TestMultipleQueriesStreamsLargeLastResult runs a batch whose last SELECT returns more rows than one
+// streaming flush window, so rows are sent while still being read; all of them must arrive, in order.
+func TestMultipleQueriesStreamsLargeLastResult(t *testing.T) {
+	testID := "test_multi_stream_last"
+	db := connectToPgRollbackProxySingleConn(t, testID)
+	defer db.Close()
+
+	const total = 5000
+	rows, err := db.Query(fmt.Sprintf("SELECT 1 as val; SELECT g FROM generate_series(1, %d) g;", total))
+	if err != nil {
+		t.Fatalf("multi-query failed: %v", err)
+	}
+	defer rows.Close()
+
+	n := 0
+	for rows.Next() {
+		var v int
+		if err := rows.Scan(&v); err != nil {
+			t.Fatalf("scan: %v", err)
+		}
+		n++
+		if v != n {
+			t.Fatalf("row %d = %d, want %d", n, v, n)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("rows: %v", err)
+	}
+	if n != total {
+		t.Fatalf("got %d rows, want %d (last result only)", n, total)
+	}
+}
+
+// TestCommandTags checks the CommandComplete tags of DML through the simple and the extended
+// protocol, including a multi-statement batch; INSERT always has the "INSERT 0 <rows>" form.
+func TestCommandTags(t *testing.T) {
+	testID := "test_command_tags"
+	ctx := context.Background()
+	conn, err := pgconn.Connect(ctx, getPgRollbackProxyDSN(testID))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+	defer conn.Exec(ctx, "pgrollback rollback").ReadAll()
+
+	tableName := postgres.QuoteQualifiedName(getTestSchema(), "pgrollback_command_tags")
+	if _, err := conn.Exec(ctx, fmt.Sprintf("CREATE TABLE %s (id int)", tableName)).ReadAll(); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	tests := []struct {
+		sql  string
+		want string
+	}{
+		{fmt.Sprintf("INSERT INTO %s VALUES (1)", tableName), "INSERT 0 1"},
+		{fmt.Sprintf("INSERT INTO %s VALUES (2), (3), (4)", tableName), "INSERT 0 3"},
+		{fmt.Sprintf("INSERT INTO %s VALUES (5), (6) RETURNING id", tableName), "INSERT 0 2"},
+		{fmt.Sprintf("UPDATE %s SET id = id + 100 WHERE id > 4", tableName), "UPDATE 2"},
+		{fmt.Sprintf("DELETE FROM %s WHERE id > 100", tableName), "DELETE 2"},
+	}
+	for _, tt := range tests {
+		results, err := conn.Exec(ctx, tt.sql).ReadAll()
+		if err != nil {
+			t.Fatalf("simple %q: %v", tt.sql, err)
+		}
+		if got := results[len(results)-1].CommandTag.String(); got != tt.want {
+			t.Errorf("simple %q tag = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+
+	if _, err := conn.Exec(ctx, fmt.Sprintf("TRUNCATE %s", tableName)).ReadAll(); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	for _, tt := range tests {
+		result := conn.ExecParams(ctx, tt.sql, nil, nil, nil, nil).Read()
+		if result.Err != nil {
+			t.Fatalf("extended %q: %v", tt.sql, result.Err)
+		}
+		if got := result.CommandTag.String(); got != tt.want {
+			t.Errorf("extended %q tag = %q, want %q", tt.sql, got, tt.want)
+		}
+	}
+
+	batch := fmt.Sprintf("INSERT INTO %s VALUES (7); INSERT INTO %s VALUES (8), (9)", tableName, tableName)
+	results, err := conn.Exec(ctx, batch).ReadAll()
+	if err != nil {
+		t.Fatalf("batch: %v", err)
+	}
+	if got := results[len(results)-1].CommandTag.String(); got != "INSERT 0 2" {
+		t.Errorf("batch tag = %q, want INSERT 0 2", got)
+	}
+}
+
+// TestCopyFromStdin loads rows with COPY ... FROM STDIN through the proxy; the rows must be visible in the
+// session and disappear with pgrollback rollback. A client CopyFail must load nothing and keep the session usable.
+func TestCopyFromStdin(t *testing.T) {
+	testID := "test_copy_from_stdin"
+	ctx := context.Background()
+	conn, err := pgconn.Connect(ctx, getPgRollbackProxyDSN(testID))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	tableName := postgres.QuoteQualifiedName(getTestSchema(), "pgrollback_copy_in")
+	if _, err := conn.Exec(ctx, fmt.Sprintf("CREATE TABLE %s (id int, name text)", tableName)).ReadAll(); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+
+	tag, err := conn.CopyFrom(ctx, strings.NewReader("1\tone\n2\ttwo\n3\tthree\n"), fmt.Sprintf("COPY %s (id, name) FROM STDIN", tableName))
+	if err != nil {
+		t.Fatalf("COPY FROM STDIN: %v", err)
+	}
+	if tag.String() != "COPY 3" {
+		t.Errorf("tag = %q, want COPY 3", tag.String())
+	}
+
+	if _, err := conn.CopyFrom(ctx, &failingReader{}, fmt.Sprintf("COPY %s FROM STDIN", tableName)); err == nil {
+		t.Error("COPY with client CopyFail should fail")
+	}
+
+	result := conn.ExecParams(ctx, fmt.Sprintf("SELECT count(*) FROM %s", tableName), nil, nil, nil, nil).Read()
+	if result.Err != nil {
+		t.Fatalf("count after COPY: %v", result.Err)
+	}
+	if got := string(result.Rows[0][0]); got != "3" {
+		t.Errorf("rows after COPY = %s, want 3", got)
+	}
+
+	if _, err := conn.Exec(ctx, "pgrollback rollback").ReadAll(); err != nil {
+		t.Fatalf("pgrollback rollback: %v", err)
+	}
+	if _, err := conn.Exec(ctx, fmt.Sprintf("SELECT 1 FROM %s", tableName)).ReadAll(); err == nil {
+		t.Error("table loaded by COPY should be gone after pgrollback rollback")
+	}
+}
+
+// TestCopyToStdout exports rows written in the session (uncommitted on the real database) with
+// COPY ... TO STDOUT, both from a table and from a query, and checks the data and "COPY n" tag.
+func TestCopyToStdout(t *testing.T) {
+	testID := "test_copy_to_stdout"
+	ctx := context.Background()
+	conn, err := pgconn.Connect(ctx, getPgRollbackProxyDSN(testID))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+
+	tableName := postgres.QuoteQualifiedName(getTestSchema(), "pgrollback_copy_out")
+	if _, err := conn.Exec(ctx, fmt.Sprintf("CREATE TABLE %s (id int, name text); INSERT INTO %s VALUES (1, 'one'), (2, 'two')", tableName, tableName)).ReadAll(); err != nil {
+		t.Fatalf("create table: %v", err)
+	}
+	defer func() { _, _ = conn.Exec(ctx, "pgrollback rollback").ReadAll() }()
+
+	var buf strings.Builder
+	tag, err := conn.CopyTo(ctx, &buf, fmt.Sprintf("COPY %s TO STDOUT", tableName))
+	if err != nil {
+		t.Fatalf("COPY TO STDOUT: %v", err)
+	}
+	if tag.String() != "COPY 2" || buf.String() != "1\tone\n2\ttwo\n" {
+		t.Errorf("table copy: tag=%q data=%q", tag.String(), buf.String())
+	}
+
+	buf.Reset()
+	tag, err = conn.CopyTo(ctx, &buf, fmt.Sprintf("COPY (SELECT name FROM %s WHERE id = 2) TO STDOUT", tableName))
+	if err != nil {
+		t.Fatalf("COPY (query) TO STDOUT: %v", err)
+	}
+	if tag.String() != "COPY 1" || buf.String() != "two\n" {
+		t.Errorf("query copy: tag=%q data=%q", tag.String(), buf.String())
+	}
+}
+
+// failingReader makes pgconn.CopyFrom send CopyFail after one row.
+type failingReader struct{ sent bool }
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if !r.sent {
+		r.sent = true
+		return copy(p, "9\tnine\n"), nil
+	}
+	return 0, fmt.Errorf("client aborted copy")
+}
+
+// TestResetSessionPingBeforeQuery guards against the response-attribution bug: after full rollback,
+// db.Query(tableExistenceQuery) triggers ResetSession (which sends "-- ping") then the query. The proxy
+// now answers the comment-only ping itself with EmptyQueryResponse, without a backend round trip.
 // This test uses a single connection to rule out pool reordering and asserts we get exactly
 // one row with value 0 or 1 (table existence), not the ping response.
 func TestResetSessionPingBeforeQuery(t *testing.T) {
@@ -1223,3 +1417,38 @@ func TestIsolatedRollbackPerBegin(t *testing.T) {
 	// Verifica que a tabela não existe mais após o rollback do pgrollback
 	assertTableDoesNotExist(t, pgrollbackDB, tableName, "Table does not exist after pgrollback rollback")
 }
+
+func TestBeginWithCharacteristicsWarns(t *testing.T) {
+	testID := "test_begin_characteristics"
+	ctx := context.Background()
+	config, err := pgconn.ParseConfig(getPgRollbackProxyDSN(testID))
+	if err != nil {
+		t.Fatalf("parse DSN: %v", err)
+	}
+	var notices []*pgconn.Notice
+	config.OnNotice = func(_ *pgconn.PgConn, n *pgconn.Notice) { notices = append(notices, n) }
+	conn, err := pgconn.ConnectConfig(ctx, config)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+	defer conn.Exec(ctx, "pgrollback rollback").ReadAll()
+
+	if _, err := conn.Exec(ctx, "BEGIN").ReadAll(); err != nil {
+		t.Fatalf("BEGIN: %v", err)
+	}
+	if _, err := conn.Exec(ctx, "COMMIT").ReadAll(); err != nil {
+		t.Fatalf("COMMIT: %v", err)
+	}
+	if len(notices) != 0 {
+		t.Fatalf("plain BEGIN produced notices: %+v", notices)
+	}
+
+	if _, err := conn.Exec(ctx, "START TRANSACTION ISOLATION LEVEL SERIALIZABLE, READ ONLY").ReadAll(); err != nil {
+		t.Fatalf("START TRANSACTION: %v", err)
+	}
+	defer conn.Exec(ctx, "ROLLBACK").ReadAll()
+	if len(notices) != 1 || notices[0].Severity != "WARNING" || !strings.Contains(notices[0].Message, "ISOLATION LEVEL SERIALIZABLE, READ ONLY") {
+		t.Fatalf("notices = %+v, want one WARNING naming the ignored characteristics", notices)
+	}
+}
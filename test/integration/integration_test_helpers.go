@@ -16,6 +16,7 @@ import (
 
 	"pgrollback/internal/config"
 	"pgrollback/internal/testutil"
+	"pgrollback/pkg/client"
 	"pgrollback/pkg/logger"
 
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -65,10 +66,9 @@ func getPgRollbackProxyDSN(testID string) string {
 	if dbname == "" {
 		dbname = "postgres"
 	}
-	pgrollbackListenPort := pgrollbackConfig.Proxy.ListenPort
-	// Conecta ao proxy pgrollback na porta configurada
-	return fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable application_name=pgrollback_%s",
-		host, pgrollbackListenPort, user, password, dbname, testID)
+	// Conecta ao proxy pgrollback na porta configurada; client.DSN põe o testID no application_name
+	return client.DSN(host, pgrollbackConfig.Proxy.ListenPort, testID,
+		fmt.Sprintf("user=%s password=%s dbname=%s sslmode=disable", user, password, dbname))
 }
 
 // getRealPostgresDSN retorna o DSN para conectar diretamente ao servidor PostgreSQL real
@@ -2,8 +2,10 @@ package logger_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"strings"
 	"testing"
+	"time"
 
 	"pgrollback/pkg/logger"
 )
@@ -166,3 +168,92 @@ func TestStaticFunctions(t *testing.T) {
 		}
 	}
 }
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.NewLogger(logger.INFO, "", 0)
+	l.SetOutput(&buf)
+	l.SetFormat(logger.JSONFormat)
+
+	l.Debug("hidden")
+	l.Warn("disk %d%% full", 90)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one JSON line (DEBUG filtered), got %q", buf.String())
+	}
+	var entry map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("line is not JSON: %v (%q)", err, lines[0])
+	}
+	if entry["level"] != "WARN" || entry["msg"] != "disk 90% full" {
+		t.Errorf("entry = %v", entry)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, entry["ts"]); err != nil {
+		t.Errorf("ts %q is not RFC3339: %v", entry["ts"], err)
+	}
+
+	buf.Reset()
+	l.SetFormat(logger.TextFormat)
+	l.Info("plain")
+	if got := strings.TrimSpace(buf.String()); got != "[INFO] plain" {
+		t.Errorf("text format = %q, want [INFO] plain", got)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	if logger.ParseFormat("JSON") != logger.JSONFormat {
+		t.Error("JSON should parse as JSONFormat")
+	}
+	for _, s := range []string{"", "text", "other"} {
+		if logger.ParseFormat(s) != logger.TextFormat {
+			t.Errorf("ParseFormat(%q) should default to TextFormat", s)
+		}
+	}
+}
+
+func TestWithFields(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.NewLogger(logger.INFO, "", 0)
+	l.SetOutput(&buf)
+	child := l.With("test_id", "t1").With("conn", "127.0.0.1:5000")
+
+	child.Info("query ok")
+	if got := strings.TrimSpace(buf.String()); got != "[INFO] test_id=t1 conn=127.0.0.1:5000 query ok" {
+		t.Errorf("text with fields = %q", got)
+	}
+
+	// Level and format changes on the parent apply to existing children.
+	buf.Reset()
+	l.SetLevel(logger.WARN)
+	child.Info("hidden")
+	if buf.Len() != 0 {
+		t.Errorf("child should follow parent level, got %q", buf.String())
+	}
+	l.SetFormat(logger.JSONFormat)
+	child.Warn("slow")
+	var entry map[string]string
+	if err := json.Unmarshal(bytes.TrimSpace(buf.Bytes()), &entry); err != nil {
+		t.Fatalf("not JSON: %v (%q)", err, buf.String())
+	}
+	if entry["test_id"] != "t1" || entry["conn"] != "127.0.0.1:5000" || entry["msg"] != "slow" {
+		t.Errorf("json with fields = %v", entry)
+	}
+
+	// The parent itself carries no fields.
+	buf.Reset()
+	l.Warn("plain")
+	if strings.Contains(buf.String(), "test_id") {
+		t.Errorf("parent should not get child fields: %q", buf.String())
+	}
+}
+
+func TestWithQuotesValuesWithSpaces(t *testing.T) {
+	var buf bytes.Buffer
+	l := logger.NewLogger(logger.INFO, "", 0)
+	l.SetOutput(&buf)
+	l.With("test_id", "my test").Info("x")
+	if got := strings.TrimSpace(buf.String()); got != `[INFO] test_id="my test" x` {
+		t.Errorf("got %q", got)
+	}
+}
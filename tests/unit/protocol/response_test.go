@@ -0,0 +1,30 @@
+package protocol_test
+
+import (
+	"testing"
+
+	"pgrollback/pkg/protocol"
+)
+
+func TestNormalizeCommandTag(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+	}{
+		{"INSERT 0 1", "INSERT 0 1"},
+		{"INSERT 0 3", "INSERT 0 3"},
+		{"INSERT 3", "INSERT 0 3"},
+		{"INSERT 16384 1", "INSERT 0 1"},
+		{"UPDATE 2", "UPDATE 2"},
+		{"DELETE 0", "DELETE 0"},
+		{"SELECT 5", "SELECT 5"},
+		{"MERGE 1", "MERGE 1"},
+		{"INSERT", "INSERT"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := protocol.NormalizeCommandTag(tt.tag); got != tt.want {
+			t.Errorf("NormalizeCommandTag(%q) = %q, want %q", tt.tag, got, tt.want)
+		}
+	}
+}
@@ -124,12 +124,21 @@ func assertTableExists(t *testing.T, session *proxy.TestSession, tableName strin
 	}
 }
 
+// assertFakeSavepoints verifica quantos savepoints estão abertos no backend em memória da sessão
+// (proxy.NewFakeTestSessionForTesting).
+func assertFakeSavepoints(t *testing.T, session *proxy.TestSession, expected int, contextMsg string) {
+	t.Helper()
+	if got := proxy.FakeSavepointsForTesting(session); len(got) != expected {
+		t.Errorf("open savepoints = %v, want %d (%s)", got, expected, contextMsg)
+	}
+}
+
 // testConnectionID is used by flow helpers when applying BEGIN/COMMIT/ROLLBACK side effects (no real proxy connection).
 const testConnectionID = 1
 
 // applyBeginSuccess applies session side effects after a SAVEPOINT (user BEGIN) has been executed. Test-only helper.
 func applyBeginSuccess(session *proxy.TestSession, connID uintptr) error {
-	if err := session.DB.ClaimOpenTransaction(proxy.ConnectionID(connID)); err != nil {
+	if err := session.DB.ClaimOpenTransaction(proxy.ConnectionID(connID), ""); err != nil {
 		return err
 	}
 	session.DB.IncrementSavepointLevel()
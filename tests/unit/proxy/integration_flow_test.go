@@ -1,47 +1,48 @@
 package tstproxy
 
 import (
+	"context"
 	"fmt"
 	"testing"
 	"time"
+
+	"pgrollback/internal/proxy"
 )
 
-// TestTransactionFlow_CompleteCycle testa o fluxo completo de transações:
+// TestTransactionFlow_CompleteCycle testa o fluxo completo de transações sobre o backend em memória:
 // 1. Criar tabela -> commit (savepoint) -> inserir linha -> commit (savepoint)
-// 2. Verificar linha existe -> rollback -> verificar linha sumiu -> rollback -> verificar tabela sumiu
+// 2. Rollback -> rollback bloqueado no nível 0, conferindo a pilha de savepoints do backend a cada passo
 func TestTransactionFlow_CompleteCycle(t *testing.T) {
-	pgrollback := newPgRollbackFromConfig()
+	pgrollback := newFakePgRollback()
 	testID := "test_transaction_flow"
-	session, err := pgrollback.GetOrCreateSession(testID)
-	if err != nil {
-		t.Fatalf("GetOrCreateSession() error = %v", err)
-	}
+	session := proxy.NewFakeTestSessionForTesting(pgrollback, testID)
 
 	tableName := "test_transaction_table_" + testID
 	createTableWithIdAndName(t, pgrollback, session, tableName)
-	assertTableCount(t, session, tableName, 0, "Table should be empty initially")
+	assertFakeSavepoints(t, session, 0, "No savepoint before the first BEGIN")
 	assertCommitBlocked(t, pgrollback, session, "COMMIT at level 0 should be blocked") // fake commit
 	execBeginAndVerify(t, pgrollback, session, 1, "First BEGIN creates savepoint level 1")
-	insertRowWithName(t, pgrollback, session, tableName, "test_row")
-	assertRowCountWithCondition(t, session, tableName, "name = 'test_row'", 1, "Row should be inserted")
+	assertFakeSavepoints(t, session, 1, "First BEGIN opens a savepoint on the backend")
+	// O backend em memória não guarda linhas (nem conta as afetadas): só registra o INSERT.
+	if _, err := session.DB.Tx().Exec(context.Background(), "INSERT INTO "+tableName+" (name) VALUES ('test_row')"); err != nil {
+		t.Fatalf("INSERT: %v", err)
+	}
 	execBeginAndVerify(t, pgrollback, session, 1, "Second BEGIN is no-op (single level)")
+	assertFakeSavepoints(t, session, 1, "Second BEGIN opens no savepoint")
 	execCommitOnLevel(t, pgrollback, session, 0, "COMMIT releases savepoint level 1 -> level 0")
-	assertRowCountWithCondition(t, session, tableName, "name = 'test_row'", 1, "Row should still exist after COMMIT")
+	assertFakeSavepoints(t, session, 0, "COMMIT releases the savepoint on the backend")
 	execBeginAndVerify(t, pgrollback, session, 1, "BEGIN creates savepoint level 1 again")
 	execRollbackAndVerify(t, pgrollback, session, 0, "ROLLBACK to level 0")
-	// Rollback only released the savepoint; test_row was committed earlier so it still exists.
-	assertRowCountWithCondition(t, session, tableName, "name = 'test_row'", 1, "Row should still exist after ROLLBACK")
+	// ROLLBACK TO SAVEPOINT; RELEASE SAVEPOINT: nothing is left open, and test_row (committed earlier)
+	// stays in the base transaction.
+	assertFakeSavepoints(t, session, 0, "ROLLBACK releases the savepoint on the backend")
 
-	// 7. Segundo rollback não é possível (já estamos no nível 0)
-	// Mas a tabela ainda existe porque foi criada antes de qualquer savepoint
-	// Para remover a tabela, precisaríamos fazer rollback da transação principal
-	// que não é permitido pelo sistema (é bloqueado)
+	// Segundo rollback não é possível (já estamos no nível 0): a tabela e a linha só saem com o
+	// rollback da transação principal, que é bloqueado.
 	assertRollbackBlocked(t, pgrollback, session, "ROLLBACK at level 0 should be blocked")
-
-	// A tabela ainda existe porque foi criada antes de qualquer savepoint
-	// Em um sistema real, a tabela só seria removida se fizéssemos rollback da transação principal
-	// mas isso é bloqueado pelo sistema
-	assertTableExists(t, session, tableName, "Table should still exist (created before any savepoint, rollback of main transaction is blocked)")
+	if !session.DB.HasActiveTransaction() {
+		t.Error("Base transaction should still be active after the blocked ROLLBACK")
+	}
 }
 
 // TestExcessiveRollback testa fazer mais rollbacks que commits
@@ -2,8 +2,10 @@ package tstproxy
 
 import (
 	"context"
+	"errors"
 	"strings"
 	"testing"
+	"time"
 
 	"pgrollback/internal/proxy"
 	"pgrollback/internal/testutil"
@@ -26,11 +28,8 @@ func assertReleaseSavepointQuery(t *testing.T, query string, expectedLevel int)
 }
 
 func TestHandleBegin(t *testing.T) {
-	pgrollback := newPgRollbackFromConfig()
-	session, err := pgrollback.GetOrCreateSession("empty123")
-	if err != nil {
-		t.Fatalf("Failed to create the session for testing BEGIN")
-	}
+	pgrollback := newFakePgRollback()
+	session := proxy.NewFakeTestSessionForTesting(pgrollback, "empty123")
 
 	if session.DB == nil {
 		t.Fatalf("Error Session has no DB connection on ID: %s", pgrollback.GetTestID(session))
@@ -69,9 +68,9 @@ func TestHandleBegin(t *testing.T) {
 }
 
 func TestHandleCommit(t *testing.T) {
-	pgrollback := newPgRollbackFromConfig()
+	pgrollback := newFakePgRollback()
 
-	session := newTestSessionWithLevel(pgrollback, "test123")
+	session := proxy.NewFakeTestSessionWithLevel(pgrollback, "test123")
 	if session == nil {
 		t.Fatalf("Failed to create a session with the given levels")
 	}
@@ -95,10 +94,7 @@ func TestHandleCommit(t *testing.T) {
 	})
 
 	t.Run("block_commit_when_level_eq_0", func(t *testing.T) {
-		session, err := pgrollback.GetOrCreateSession("otherId")
-		if err != nil {
-			t.Fatalf("Failed to get the new Session")
-		}
+		session := proxy.NewFakeTestSessionForTesting(pgrollback, "otherId")
 
 		query, err := pgrollback.InterceptQuery(pgrollback.GetTestID(session), "COMMIT", testSetupConnectionID)
 		if err != nil {
@@ -116,10 +112,10 @@ func TestHandleCommit(t *testing.T) {
 }
 
 func TestHandleRollback(t *testing.T) {
-	pgrollback := newPgRollbackFromConfig()
+	pgrollback := newFakePgRollback()
 
 	t.Run("rollback_to_savepoint_when_level_gt_0", func(t *testing.T) {
-		session := newTestSessionWithLevel(pgrollback, "test123")
+		session := proxy.NewFakeTestSessionWithLevel(pgrollback, "test123")
 
 		query, err := pgrollback.InterceptQuery(pgrollback.GetTestID(session), "ROLLBACK", testSetupConnectionID)
 		if err != nil {
@@ -146,10 +142,7 @@ func TestHandleRollback(t *testing.T) {
 	})
 
 	t.Run("block_rollback_when_level_eq_0", func(t *testing.T) {
-		session, err := pgrollback.GetOrCreateSession("fakeId")
-		if err != nil {
-			t.Fatalf("Failed to get the new Session")
-		}
+		session := proxy.NewFakeTestSessionForTesting(pgrollback, "fakeId")
 
 		query, err := pgrollback.InterceptQuery(pgrollback.GetTestID(session), "ROLLBACK", testSetupConnectionID)
 		if err != nil {
@@ -304,6 +297,30 @@ func TestHandlePgRollbackCommandUnit(t *testing.T) {
 			t.Errorf("InterceptQuery(pgrollback cleanup) = %v, want SELECT query with cleaned", query)
 		}
 	})
+
+	t.Run("pgrollback_savepoint_and_release", func(t *testing.T) {
+		testID := "test_pgrollback_named_savepoint"
+		if _, err := pgrollback.GetOrCreateSession(testID); err != nil {
+			t.Skip("Skipping test - requires PostgreSQL connection")
+		}
+		query, err := pgrollback.InterceptQuery(testID, "pgrollback savepoint before_seed", testSetupConnectionID)
+		if err != nil {
+			t.Fatalf("InterceptQuery(pgrollback savepoint) error = %v", err)
+		}
+		if query != "SELECT 1" {
+			t.Errorf("InterceptQuery(pgrollback savepoint) = %v, want SELECT 1", query)
+		}
+		query, err = pgrollback.InterceptQuery(testID, "pgrollback release before_seed", testSetupConnectionID)
+		if err != nil {
+			t.Fatalf("InterceptQuery(pgrollback release) error = %v", err)
+		}
+		if query != "SELECT 1" {
+			t.Errorf("InterceptQuery(pgrollback release) = %v, want SELECT 1", query)
+		}
+		if _, err := pgrollback.InterceptQuery(testID, "pgrollback release before_seed", testSetupConnectionID); err == nil {
+			t.Error("releasing an already released savepoint should fail")
+		}
+	})
 }
 
 func TestInterceptQuery_NormalQueries(t *testing.T) {
@@ -370,7 +387,7 @@ func TestInterceptQuery_NormalQueries(t *testing.T) {
 // TestInterceptQuery_Routing is a table-driven test for InterceptQuery dispatch and ROLLBACK vs ROLLBACK TO SAVEPOINT.
 // It documents when a query is passed through unchanged vs intercepted, and helps find routing bugs.
 func TestInterceptQuery_Routing(t *testing.T) {
-	pgrollback := newPgRollbackFromConfig()
+	pgrollback := newFakePgRollback()
 
 	tests := []struct {
 		name              string
@@ -410,13 +427,9 @@ func TestInterceptQuery_Routing(t *testing.T) {
 				uniqueID := "routing_" + strings.ReplaceAll(tt.name, " ", "_")
 				var s *proxy.TestSession
 				if tt.savepointLevel == 0 {
-					var err error
-					s, err = pgrollback.GetOrCreateSession(uniqueID)
-					if err != nil {
-						t.Fatalf("GetOrCreateSession: %v", err)
-					}
+					s = proxy.NewFakeTestSessionForTesting(pgrollback, uniqueID)
 				} else {
-					s = proxy.NewTestSessionWithLevel(pgrollback, uniqueID)
+					s = proxy.NewFakeTestSessionWithLevel(pgrollback, uniqueID)
 					if s == nil {
 						t.Fatal("failed to create session for test")
 					}
@@ -463,8 +476,8 @@ func TestInterceptQuery_Routing(t *testing.T) {
 // TestInterceptQuery_MultipleSavepoints verifies single-level semantics: only the first BEGIN
 // creates a savepoint; further BEGINs are no-op. COMMIT/ROLLBACK when level 0 are no-op.
 func TestInterceptQuery_MultipleSavepoints(t *testing.T) {
-	pgrollback := newPgRollbackFromConfig()
-	session := newTestSession(pgrollback)
+	pgrollback := newFakePgRollback()
+	session := proxy.NewFakeTestSessionForTesting(pgrollback, "multiple_savepoints")
 
 	// First BEGIN creates level 1
 	query, err := pgrollback.InterceptQuery(pgrollback.GetTestID(session), "BEGIN", testConnectionID)
@@ -560,3 +573,47 @@ func TestExecuteWithLock(t *testing.T) {
 		}
 	})
 }
+
+func TestExecuteWithLock_TimesOutWhenLockHeldElsewhere(t *testing.T) {
+	// Two PgRollback instances with the same test ID stand for two pgrollback processes on the same
+	// database: separate backends contending for the same advisory lock.
+	testID := "test_execute_lock_timeout_286"
+	holder := newPgRollbackFromConfig()
+	holderSession, err := holder.GetOrCreateSession(testID)
+	if err != nil {
+		t.Skip("Skipping test - requires PostgreSQL connection")
+	}
+	defer holder.DestroySession(testID)
+	waiter := newPgRollbackFromConfig()
+	waiter.AdvisoryLockTimeout = 300 * time.Millisecond
+	waiterSession, err := waiter.GetOrCreateSession(testID)
+	if err != nil {
+		t.Fatalf("GetOrCreateSession(waiter) error = %v", err)
+	}
+	defer waiter.DestroySession(testID)
+
+	holderDone := make(chan error, 1)
+	go func() { holderDone <- holder.ExecuteWithLock(holderSession, "SELECT pg_sleep(2)") }()
+	time.Sleep(200 * time.Millisecond) // let the holder take the lock
+
+	waiterDone := make(chan error, 1)
+	start := time.Now()
+	go func() { waiterDone <- waiter.ExecuteWithLock(waiterSession, "SELECT 1") }()
+	select {
+	case err := <-waiterDone:
+		if !errors.Is(err, proxy.ErrAdvisoryLockTimeout) {
+			t.Fatalf("ExecuteWithLock while lock held elsewhere error = %v, want ErrAdvisoryLockTimeout", err)
+		}
+		if elapsed := time.Since(start); elapsed > 1500*time.Millisecond {
+			t.Errorf("timed out after %s, want about %s", elapsed, waiter.AdvisoryLockTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("ExecuteWithLock hung instead of timing out")
+	}
+	if err := <-holderDone; err != nil {
+		t.Fatalf("holder ExecuteWithLock error = %v", err)
+	}
+	if err := waiter.ExecuteWithLock(waiterSession, "SELECT 1"); err != nil {
+		t.Errorf("ExecuteWithLock after the lock was released error = %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+// pipeline_error_test.go: an error in the middle of a pipelined extended-query cycle must make the proxy
+// skip the rest of the cycle until Sync, as PostgreSQL does. A pgx batch sends all its messages before
+// reading anything and expects one ErrorResponse and one ReadyForQuery for the whole cycle.
+
+package tstproxy
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+)
+
+const pipelineErrorTestID = "pipeline_error_skip_until_sync"
+
+// receiveUntilReady reads backend messages up to and including ReadyForQuery.
+func receiveUntilReady(t *testing.T, fe *pgproto3.Frontend) []pgproto3.BackendMessage {
+	t.Helper()
+	var msgs []pgproto3.BackendMessage
+	for {
+		msg, err := fe.Receive()
+		if err != nil {
+			t.Fatalf("receive: %v", err)
+		}
+		msgs = append(msgs, msg)
+		if _, ok := msg.(*pgproto3.ReadyForQuery); ok {
+			return msgs
+		}
+	}
+}
+
+// TestPipeline_BadParseSkipsUntilSync pipelines a Parse with a syntax error, a valid query and one Sync
+// over a raw frontend: the client gets the syntax error and ReadyForQuery, nothing for the valid query,
+// and the next cycle runs normally.
+func TestPipeline_BadParseSkipsUntilSync(t *testing.T) {
+	_, ctx, server, cleanup := connectToProxyForTestWithServer(t, pipelineErrorTestID)
+	defer cleanup()
+	if server == nil {
+		return
+	}
+	cfg := getConfigForProxyTest(t)
+	dsn := buildDSN(server.ListenHost(), server.ListenPort(), cfg.Postgres.Database, cfg.Postgres.User, cfg.Postgres.Password, "pgrollback_"+pipelineErrorTestID)
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+	fe := conn.Frontend()
+
+	fe.Send(&pgproto3.Parse{Query: "SELEC 1"})
+	fe.Send(&pgproto3.Bind{})
+	fe.Send(&pgproto3.Execute{})
+	fe.Send(&pgproto3.Parse{Query: "SELECT 1"})
+	fe.Send(&pgproto3.Bind{})
+	fe.Send(&pgproto3.Describe{ObjectType: 'P'})
+	fe.Send(&pgproto3.Execute{})
+	fe.Send(&pgproto3.Sync{})
+	if err := fe.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	msgs := receiveUntilReady(t, fe)
+	if len(msgs) != 2 {
+		t.Fatalf("replies to the failed cycle = %#v, want ErrorResponse + ReadyForQuery", msgs)
+	}
+	if errResp, ok := msgs[0].(*pgproto3.ErrorResponse); !ok || errResp.Code != "42601" {
+		t.Errorf("first reply = %#v, want ErrorResponse 42601 (syntax_error)", msgs[0])
+	}
+
+	fe.Send(&pgproto3.Parse{Query: "SELECT 1"})
+	fe.Send(&pgproto3.Bind{})
+	fe.Send(&pgproto3.Execute{})
+	fe.Send(&pgproto3.Sync{})
+	if err := fe.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	var types []string
+	for _, msg := range receiveUntilReady(t, fe) {
+		switch msg := msg.(type) {
+		case *pgproto3.ErrorResponse:
+			t.Fatalf("cycle after Sync failed: %s", msg.Message)
+		case *pgproto3.DataRow:
+			if string(msg.Values[0]) != "1" {
+				t.Errorf("SELECT 1 returned %q", msg.Values[0])
+			}
+		}
+		types = append(types, fmt.Sprintf("%T", msg))
+	}
+	want := []string{"*pgproto3.ParseComplete", "*pgproto3.BindComplete", "*pgproto3.DataRow", "*pgproto3.CommandComplete", "*pgproto3.ReadyForQuery"}
+	if !slices.Equal(types, want) {
+		t.Errorf("replies to the next cycle = %v, want %v", types, want)
+	}
+}
+
+// TestPipeline_BackToBackUnnamedParses prepares the unnamed statement twice in one cycle, with one and then
+// two parameters: the second Parse replaces the first, so each Bind gets the parameter count of the Parse
+// before it and each Execute returns its own statement's result.
+func TestPipeline_BackToBackUnnamedParses(t *testing.T) {
+	_, ctx, server, cleanup := connectToProxyForTestWithServer(t, pipelineErrorTestID+"_unnamed")
+	defer cleanup()
+	if server == nil {
+		return
+	}
+	cfg := getConfigForProxyTest(t)
+	dsn := buildDSN(server.ListenHost(), server.ListenPort(), cfg.Postgres.Database, cfg.Postgres.User, cfg.Postgres.Password, "pgrollback_"+pipelineErrorTestID+"_unnamed")
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer conn.Close(ctx)
+	fe := conn.Frontend()
+
+	fe.Send(&pgproto3.Parse{Query: "SELECT $1::int * 10"})
+	fe.Send(&pgproto3.Bind{Parameters: [][]byte{[]byte("4")}})
+	fe.Send(&pgproto3.Execute{})
+	fe.Send(&pgproto3.Parse{Query: "SELECT $1::int + $2::int"})
+	fe.Send(&pgproto3.Bind{Parameters: [][]byte{[]byte("4"), []byte("5")}})
+	fe.Send(&pgproto3.Execute{})
+	fe.Send(&pgproto3.Sync{})
+	if err := fe.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	var rows []string
+	for _, msg := range receiveUntilReady(t, fe) {
+		switch msg := msg.(type) {
+		case *pgproto3.ErrorResponse:
+			t.Fatalf("back-to-back unnamed Parse failed: %s", msg.Message)
+		case *pgproto3.DataRow:
+			rows = append(rows, string(msg.Values[0]))
+		}
+	}
+	if want := []string{"40", "9"}; !slices.Equal(rows, want) {
+		t.Errorf("results = %v, want %v", rows, want)
+	}
+
+	// A Bind after Sync still gets the second statement.
+	fe.Send(&pgproto3.Bind{Parameters: [][]byte{[]byte("1"), []byte("2")}})
+	fe.Send(&pgproto3.Execute{})
+	fe.Send(&pgproto3.Sync{})
+	if err := fe.Flush(); err != nil {
+		t.Fatalf("flush: %v", err)
+	}
+	rows = nil
+	for _, msg := range receiveUntilReady(t, fe) {
+		switch msg := msg.(type) {
+		case *pgproto3.ErrorResponse:
+			t.Fatalf("Bind to the replaced unnamed statement failed: %s", msg.Message)
+		case *pgproto3.DataRow:
+			rows = append(rows, string(msg.Values[0]))
+		}
+	}
+	if want := []string{"3"}; !slices.Equal(rows, want) {
+		t.Errorf("results = %v, want %v", rows, want)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib" // Driver para database/sql
 )
 
@@ -40,6 +41,57 @@ func TestSelect1AsUm(t *testing.T) {
 	t.Logf("This confirms the pgrollback server correctly handles SELECT queries with column aliases")
 }
 
+// TestSelectSimpleQueryReturnsTextValues sends a Simple Query SELECT (as psql does) and checks that the
+// client gets PostgreSQL's own text output: Format 0 columns, a NULL as NULL, a boolean as "t" and a
+// timestamp in its text form, not pgx's binary encoding.
+func TestSelectSimpleQueryReturnsTextValues(t *testing.T) {
+	cfg := getConfigForProxyTest(t)
+	if cfg == nil {
+		return
+	}
+	db, ctx, server, cleanup := connectToProxyForTestWithServer(t, "select_text_values")
+	defer cleanup()
+	if db == nil {
+		return
+	}
+
+	dsn := buildDSN(server.ListenHost(), server.ListenPort(), cfg.Postgres.Database, cfg.Postgres.User, cfg.Postgres.Password, "pgrollback-select_text_values")
+	conn, err := pgconn.Connect(ctx, dsn)
+	if err != nil {
+		t.Fatalf("Failed to connect to proxy: %v", err)
+	}
+	defer conn.Close(context.Background())
+
+	setup := "CREATE TEMP TABLE select_text_values (n text, b boolean, ts timestamp);" +
+		"INSERT INTO select_text_values VALUES (NULL, true, '2024-01-02 03:04:05')"
+	if _, err := conn.Exec(ctx, setup).ReadAll(); err != nil {
+		t.Fatalf("setup: %v", err)
+	}
+
+	results, err := conn.Exec(ctx, "SELECT n, b, ts FROM select_text_values").ReadAll()
+	if err != nil {
+		t.Fatalf("SELECT: %v", err)
+	}
+	if len(results) != 1 || len(results[0].Rows) != 1 {
+		t.Fatalf("got %d results, want one result with one row", len(results))
+	}
+	for _, fd := range results[0].FieldDescriptions {
+		if fd.Format != 0 {
+			t.Errorf("column %s has format %d, want 0 (text)", fd.Name, fd.Format)
+		}
+	}
+	row := results[0].Rows[0]
+	if row[0] != nil {
+		t.Errorf("n = %q, want NULL", row[0])
+	}
+	if string(row[1]) != "t" {
+		t.Errorf("b = %q, want \"t\"", row[1])
+	}
+	if string(row[2]) != "2024-01-02 03:04:05" {
+		t.Errorf("ts = %q, want \"2024-01-02 03:04:05\"", row[2])
+	}
+}
+
 // TestSelectSiteUser runs SELECT * FROM p_conab_cafe.site_user LIMIT 10 through the proxy,
 // asserts row count is 10, discovers how many fields are returned, and asserts at least two different column types.
 // Skipped in CI (e.g. GitHub Actions) because the database has no p_conab_cafe schema/site_user table.
@@ -292,6 +292,12 @@ func newPgRollbackFromConfig() *proxy.PgRollback {
 	return proxy.NewPgRollbackFromConfigForTesting()
 }
 
+// newFakePgRollback returns a PgRollback for sessions on the in-memory backend
+// (proxy.NewFakeTestSessionForTesting); it never connects to PostgreSQL.
+func newFakePgRollback() *proxy.PgRollback {
+	return proxy.NewPgRollback("localhost", 5432, "postgres", "postgres", "", time.Hour, 24*time.Hour, 0)
+}
+
 func newTestSession(pgrollback *proxy.PgRollback) *proxy.TestSession {
 	return proxy.NewTestSessionForTesting(pgrollback)
 }
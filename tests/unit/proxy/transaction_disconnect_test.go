@@ -154,9 +154,13 @@ func TestDisconnectWithoutCommitTableGone(t *testing.T) {
 		t.Fatalf("Failed to close connection: %v", err)
 	}
 
-	// Reconnect with the same testID and check that the table does not exist.
-	// The proxy serializes session backend use (LockBackend): db2's first query blocks
-	// until the disconnect defer has finished RollbackUserSavepointsOnDisconnect.
+	// Reconnect with the same testID and check that the table does not exist, once the proxy has
+	// rolled back db1's savepoint (Close returns before the proxy sees the disconnect).
+	waitCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	if err := proxyServer.WaitForDisconnectCleanup(waitCtx, testID, 0); err != nil {
+		t.Fatalf("waiting for disconnect cleanup: %v", err)
+	}
 	// Must connect to the same proxy server (proxyServer) as db1 was using
 	cfg := getConfigForProxyTest(t)
 	if cfg == nil {